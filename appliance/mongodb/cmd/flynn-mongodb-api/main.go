@@ -6,16 +6,14 @@ import (
 	"net/http"
 	"os"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/flynn/flynn/appliance/mongodb/mongoretry"
 	discoverd "github.com/flynn/flynn/discoverd/client"
+	"github.com/flynn/flynn/pkg/appliance/api"
 	"github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/random"
 	"github.com/flynn/flynn/pkg/resource"
-	"github.com/flynn/flynn/pkg/shutdown"
-	sirenia "github.com/flynn/flynn/pkg/sirenia/client"
-	"github.com/flynn/flynn/pkg/sirenia/scale"
 	"github.com/inconshreveable/log15"
 	"github.com/julienschmidt/httprouter"
 	"go.mongodb.org/mongo-driver/bson"
@@ -23,12 +21,25 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var app = os.Getenv("FLYNN_APP_ID")
-var controllerKey = os.Getenv("CONTROLLER_KEY")
-var singleton = os.Getenv("SINGLETON")
 var serviceName = os.Getenv("FLYNN_MONGO")
 var serviceHost string
 
+// replicaSetURI determines whether provisioned databases are given a
+// DATABASE_URL listing the replica set members rather than just the leader,
+// so drivers can fail over between members themselves
+var replicaSetURI = os.Getenv("REPLICA_SET_URI") == "true"
+
+// replicaSetName is the name of the replica set configured by the appliance
+const replicaSetName = "rs0"
+
+// pingBackoff retries health checks for long enough to ride out a brief loss
+// of connectivity, but fails them well before the checker times out
+var pingBackoff = &mongoretry.Backoff{
+	Initial:  100 * time.Millisecond,
+	Max:      time.Second,
+	Attempts: 5,
+}
+
 func init() {
 	if serviceName == "" {
 		serviceName = "mongodb"
@@ -37,38 +48,24 @@ func init() {
 }
 
 func main() {
-	defer shutdown.Exit()
-
-	api := &API{}
-
-	router := httprouter.New()
-	router.POST("/databases", api.createDatabase)
-	router.DELETE("/databases", api.dropDatabase)
-	router.GET("/ping", api.ping)
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "3000"
-	}
-	addr := ":" + port
-
-	hb, err := discoverd.AddServiceAndRegister(serviceName+"-api", addr)
-	if err != nil {
-		shutdown.Fatal(err)
-	}
-	shutdown.BeforeExit(func() { hb.Close() })
-
-	handler := httphelper.ContextInjector(serviceName+"-api", httphelper.NewRequestLogger(router))
-	shutdown.Fatal(http.ListenAndServe(addr, handler))
-}
-
-type API struct {
-	mtx      sync.Mutex
-	scaledUp bool
+	d := &driver{logger: log15.New("app", "mongodb-web")}
+	api.Run(api.Config{
+		Service:     serviceName,
+		Port:        "27017",
+		Driver:      d,
+		Sirenia:     true,
+		ProcessType: "mongodb",
+		Routes: func(router *httprouter.Router) {
+			router.POST("/databases/:id/readonly-users", d.createReadOnlyUser)
+			router.DELETE("/databases/:id/readonly-users/:user", d.dropReadOnlyUser)
+		},
+		Logger: d.logger,
+	})
 }
 
-func (a *API) logger() log15.Logger {
-	return log15.New("app", "mongodb-web")
+// driver implements api.Driver for MongoDB
+type driver struct {
+	logger log15.Logger
 }
 
 // mongoURI builds a MongoDB connection URI
@@ -76,181 +73,257 @@ func mongoURI(host, port, username, password, database string) string {
 	return fmt.Sprintf("mongodb://%s:%s@%s:%s/%s?directConnection=true", username, password, host, port, database)
 }
 
-func (a *API) createDatabase(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	// Ensure the cluster has been scaled up before attempting to create a database.
-	if err := a.scaleUp(); err != nil {
+func (d *driver) CreateDatabase(ctx context.Context, db *api.Database) (map[string]string, error) {
+	if err := d.createUser(ctx, db.User, db.Password, db.Name, "dbOwner"); err != nil {
+		return nil, err
+	}
+	return databaseEnv(db.User, db.Password, db.Name)
+}
+
+// createReadOnlyUser handles a request to POST /databases/:id/readonly-users,
+// creating a user with the read role on the database (for analytics or
+// reporting consumers which shouldn't be able to modify it). The id is
+// either the database name or the resource ID returned when the database was
+// provisioned.
+func (d *driver) createReadOnlyUser(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	database := databaseName(params.ByName("id"))
+	if database == "" {
+		httphelper.ValidationError(w, "id", "is invalid")
+		return
+	}
+	exists, err := databaseExists(database)
+	if err != nil {
 		httphelper.Error(w, err)
 		return
 	}
+	if !exists {
+		httphelper.ObjectNotFoundError(w, fmt.Sprintf("database %q not found", database))
+		return
+	}
 
-	username, password, database := random.Hex(16), random.Hex(16), random.Hex(16)
-
-	// Retry the createUser command to handle transient NotWritablePrimary errors
-	// that occur when the replica set is being reconfigured after ScaleUp adds
-	// new members (the primary may briefly step down during reconfiguration).
-	var lastErr error
-	for i := 0; i < 30; i++ {
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-
-		uri := mongoURI(serviceHost, "27017", "flynn", os.Getenv("MONGO_PWD"), "admin")
-		client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
-		if err != nil {
-			cancel()
-			lastErr = err
-			time.Sleep(1 * time.Second)
-			continue
-		}
-
-		err = client.Database(database).RunCommand(ctx, bson.D{
-			{Key: "createUser", Value: username},
-			{Key: "pwd", Value: password},
-			{Key: "roles", Value: []bson.M{
-				{"role": "dbOwner", "db": database},
-			}},
-		}).Err()
-		client.Disconnect(ctx)
-		cancel()
-
-		if err == nil {
-			lastErr = nil
-			break
-		}
-
-		lastErr = err
-		// Retry on NotWritablePrimary or other transient errors
-		if !isRetryableMongoError(err) {
-			break
-		}
-		a.logger().Info("retrying createUser after transient error", "err", err, "attempt", i+1)
-		time.Sleep(1 * time.Second)
+	username, password := random.Hex(16), random.Hex(16)
+	if err := d.createUser(req.Context(), username, password, database, "read"); err != nil {
+		httphelper.Error(w, err)
+		return
 	}
-	if lastErr != nil {
-		httphelper.Error(w, lastErr)
+	env, err := databaseEnv(username, password, database)
+	if err != nil {
+		httphelper.Error(w, err)
 		return
 	}
-
-	url := fmt.Sprintf("mongodb://%s:%s@%s:27017/%s", username, password, serviceHost, database)
 	httphelper.JSON(w, 200, resource.Resource{
-		ID: fmt.Sprintf("/databases/%s:%s", username, database),
-		Env: map[string]string{
-			"FLYNN_MONGO":    serviceName,
-			"MONGO_HOST":     serviceHost,
-			"MONGO_USER":     username,
-			"MONGO_PWD":      password,
-			"MONGO_DATABASE": database,
-			"DATABASE_URL":   url,
-		},
+		ID:  fmt.Sprintf("/databases/%s/readonly-users/%s", database, username),
+		Env: env,
 	})
 }
 
-func (a *API) dropDatabase(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	id := strings.SplitN(strings.TrimPrefix(req.FormValue("id"), "/databases/"), ":", 2)
-	if len(id) != 2 || id[1] == "" {
+// dropReadOnlyUser handles a request to
+// DELETE /databases/:id/readonly-users/:user, removing a user created by
+// createReadOnlyUser.
+func (d *driver) dropReadOnlyUser(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	database := databaseName(params.ByName("id"))
+	if database == "" {
 		httphelper.ValidationError(w, "id", "is invalid")
 		return
 	}
-	user, database := id[0], id[1]
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	uri := mongoURI(serviceHost, "27017", "flynn", os.Getenv("MONGO_PWD"), "admin")
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	client, err := connectAdmin(ctx)
 	if err != nil {
 		httphelper.Error(w, err)
 		return
 	}
 	defer client.Disconnect(ctx)
 
-	// Delete user.
-	if err := client.Database(database).RunCommand(ctx, bson.D{{Key: "dropUser", Value: user}}).Err(); err != nil {
+	// only drop users which are limited to reading the database, so this
+	// can't be used to remove the database's owner
+	var info struct {
+		Users []struct {
+			Roles []userRole `bson:"roles"`
+		} `bson:"users"`
+	}
+	user := params.ByName("user")
+	if err := client.Database(database).RunCommand(ctx, bson.D{{Key: "usersInfo", Value: user}}).Decode(&info); err != nil {
 		httphelper.Error(w, err)
 		return
 	}
+	if len(info.Users) == 0 {
+		httphelper.ObjectNotFoundError(w, fmt.Sprintf("user %q not found", user))
+		return
+	}
+	if !readOnlyRoles(info.Users[0].Roles, database) {
+		httphelper.ValidationError(w, "user", "is not a read-only user")
+		return
+	}
 
-	// Delete database.
-	if err := client.Database(database).RunCommand(ctx, bson.D{{Key: "dropDatabase", Value: 1}}).Err(); err != nil {
+	if err := client.Database(database).RunCommand(ctx, bson.D{{Key: "dropUser", Value: user}}).Err(); err != nil {
 		httphelper.Error(w, err)
 		return
 	}
-
 	w.WriteHeader(200)
 }
 
-func (a *API) ping(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	logger := a.logger().New("fn", "ping")
+// userRole is a role granted to a user, as returned by usersInfo.
+type userRole struct {
+	Role string `bson:"role"`
+	DB   string `bson:"db"`
+}
 
-	logger.Info("checking status", "host", serviceHost)
-	if status, err := sirenia.NewClient(serviceHost + ":27017").Status(); err == nil && status.Database != nil && status.Database.ReadWrite {
-		logger.Info("database is up, skipping scale check")
-	} else {
-		scaled, err := scale.CheckScale(app, controllerKey, "mongodb", a.logger())
-		if err != nil {
-			httphelper.Error(w, err)
-			return
+// readOnlyRoles returns whether the roles only allow reading the database,
+// as granted by createReadOnlyUser.
+func readOnlyRoles(roles []userRole, database string) bool {
+	if len(roles) == 0 {
+		return false
+	}
+	for _, role := range roles {
+		if role.Role != "read" || role.DB != database {
+			return false
 		}
+	}
+	return true
+}
 
-		// Cluster has yet to be scaled, return healthy
-		if !scaled {
-			w.WriteHeader(200)
-			return
-		}
+// databaseName returns the database name from either a database name or a
+// resource ID of the form /databases/user:database.
+func databaseName(id string) string {
+	id = strings.TrimPrefix(id, "/databases/")
+	if i := strings.Index(id, ":"); i >= 0 {
+		id = id[i+1:]
+	}
+	if strings.ContainsAny(id, "/\\. \"$") {
+		return ""
 	}
+	return id
+}
 
+func databaseExists(database string) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	uri := mongoURI(serviceHost, "27017", "flynn", os.Getenv("MONGO_PWD"), "admin")
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	client, err := connectAdmin(ctx)
 	if err != nil {
-		httphelper.Error(w, err)
-		return
+		return false, err
 	}
 	defer client.Disconnect(ctx)
 
-	// Verify connection with a ping
-	if err := client.Ping(ctx, nil); err != nil {
-		httphelper.Error(w, err)
-		return
+	names, err := client.ListDatabaseNames(ctx, bson.D{{Key: "name", Value: database}})
+	if err != nil {
+		return false, err
 	}
+	return len(names) > 0, nil
+}
 
-	w.WriteHeader(200)
+// connectAdmin connects to the leader as the flynn admin user.
+func connectAdmin(ctx context.Context) (*mongo.Client, error) {
+	uri := mongoURI(serviceHost, "27017", "flynn", os.Getenv("MONGO_PWD"), "admin")
+	return mongo.Connect(ctx, options.Client().ApplyURI(uri))
 }
 
-func (a *API) scaleUp() error {
-	a.mtx.Lock()
-	defer a.mtx.Unlock()
+// createUser creates a user with the given role on the database.
+func (d *driver) createUser(ctx context.Context, username, password, database, role string) error {
+	// Retry to handle transient NotWritablePrimary errors that occur when the
+	// replica set is being reconfigured after ScaleUp adds new members (the
+	// primary may briefly step down during reconfiguration).
+	return mongoretry.Do(ctx, d.logger.New("fn", "createUser"), func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+
+		client, err := connectAdmin(ctx)
+		if err != nil {
+			return err
+		}
+		defer client.Disconnect(ctx)
+
+		return client.Database(database).RunCommand(ctx, bson.D{
+			{Key: "createUser", Value: username},
+			{Key: "pwd", Value: password},
+			{Key: "roles", Value: []bson.M{
+				{"role": role, "db": database},
+			}},
+		}).Err()
+	})
+}
 
-	// Ignore if already scaled up.
-	if a.scaledUp {
-		return nil
+// databaseEnv returns the environment variables for connecting to the
+// database with the given credentials.
+func databaseEnv(username, password, database string) (map[string]string, error) {
+	env := map[string]string{
+		"FLYNN_MONGO":    serviceName,
+		"MONGO_HOST":     serviceHost,
+		"MONGO_USER":     username,
+		"MONGO_PWD":      password,
+		"MONGO_DATABASE": database,
+		"DATABASE_URL":   fmt.Sprintf("mongodb://%s:%s@%s:27017/%s", username, password, serviceHost, database),
 	}
+	if replicaSetURI {
+		hosts, err := replicaSetHosts()
+		if err != nil {
+			return nil, err
+		}
+		env["MONGO_REPLICA_SET"] = replicaSetName
+		env["DATABASE_URL"] = fmt.Sprintf("mongodb://%s:%s@%s/%s?replicaSet=%s", username, password, strings.Join(hosts, ","), database, replicaSetName)
+	}
+	return env, nil
+}
 
-	serviceAddr := serviceHost + ":27017"
-	err := scale.ScaleUp(app, controllerKey, serviceAddr, "mongodb", singleton, a.logger())
+// replicaSetHosts returns the seed list for a replica set URI, which is the
+// leader's discoverd hostname followed by the address of each member.
+// Drivers discover the current members from whichever seed they reach, so
+// including the leader hostname keeps the URI usable after the members it
+// lists have been replaced.
+func replicaSetHosts() ([]string, error) {
+	instances, err := discoverd.NewService(serviceName).Instances()
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("error listing %s instances: %s", serviceName, err)
 	}
+	hosts := make([]string, 0, len(instances)+1)
+	hosts = append(hosts, serviceHost+":27017")
+	for _, inst := range instances {
+		hosts = append(hosts, inst.Addr)
+	}
+	return hosts, nil
+}
+
+func (d *driver) DropDatabase(ctx context.Context, db *api.Database) error {
+	// the user is only dropped once, as retrying after the connection is
+	// lost could otherwise fail because the user no longer exists
+	var userDropped bool
+	return mongoretry.Do(ctx, d.logger.New("fn", "DropDatabase"), func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
 
-	// Mark as successfully scaled up.
-	a.scaledUp = true
-	return nil
+		client, err := connectAdmin(ctx)
+		if err != nil {
+			return err
+		}
+		defer client.Disconnect(ctx)
+
+		// Delete user.
+		if !userDropped {
+			if err := client.Database(db.Name).RunCommand(ctx, bson.D{{Key: "dropUser", Value: db.User}}).Err(); err != nil {
+				return err
+			}
+			userDropped = true
+		}
+
+		// Delete database.
+		return client.Database(db.Name).RunCommand(ctx, bson.D{{Key: "dropDatabase", Value: 1}}).Err()
+	})
 }
 
+func (d *driver) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
 
-// isRetryableMongoError returns true for transient MongoDB errors that may
-// occur during replica set reconfiguration (e.g. when adding new members
-// causes the primary to briefly step down).
-func isRetryableMongoError(err error) bool {
-	if err == nil {
-		return false
-	}
-	msg := err.Error()
-	return strings.Contains(msg, "NotWritablePrimary") ||
-		strings.Contains(msg, "not primary") ||
-		strings.Contains(msg, "not master") ||
-		strings.Contains(msg, "NotPrimaryOrSecondary") ||
-		strings.Contains(msg, "node is recovering") ||
-		strings.Contains(msg, "connection refused") ||
-		strings.Contains(msg, "connection reset")
-}
\ No newline at end of file
+	// Verify connection with a ping
+	return pingBackoff.Do(ctx, d.logger.New("fn", "Ping"), func(ctx context.Context) error {
+		client, err := connectAdmin(ctx)
+		if err != nil {
+			return err
+		}
+		defer client.Disconnect(ctx)
+		return client.Ping(ctx, nil)
+	})
+}
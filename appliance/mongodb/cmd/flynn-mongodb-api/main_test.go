@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/inconshreveable/log15"
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestDatabaseName(t *testing.T) {
+	for _, test := range []struct {
+		id       string
+		expected string
+	}{
+		// database names
+		{"db1", "db1"},
+		{"my-db_2", "my-db_2"},
+		// resource IDs returned when the database was provisioned
+		{"/databases/user1:db1", "db1"},
+		{"databases/user1:db1", "db1"},
+		{"user1:db1", "db1"},
+		// names mongo does not allow, or which could escape the database
+		{"", ""},
+		{"/databases/user1:", ""},
+		{"db.name", ""},
+		{"db name", ""},
+		{"db/name", ""},
+		{`db\name`, ""},
+		{`db"name`, ""},
+		{"db$name", ""},
+		{"/databases/user1:../admin", ""},
+	} {
+		if name := databaseName(test.id); name != test.expected {
+			t.Errorf("databaseName(%q): expected %q, got %q", test.id, test.expected, name)
+		}
+	}
+}
+
+func TestReadOnlyRoles(t *testing.T) {
+	for _, test := range []struct {
+		desc     string
+		roles    []userRole
+		expected bool
+	}{
+		{"read role", []userRole{{"read", "db1"}}, true},
+		{"no roles", nil, false},
+		{"owner", []userRole{{"dbOwner", "db1"}}, false},
+		{"read and write", []userRole{{"read", "db1"}, {"readWrite", "db1"}}, false},
+		{"read on another database", []userRole{{"read", "db2"}}, false},
+	} {
+		if ok := readOnlyRoles(test.roles, "db1"); ok != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.desc, test.expected, ok)
+		}
+	}
+}
+
+func TestReadOnlyUserInvalidID(t *testing.T) {
+	d := &driver{logger: log15.New()}
+	d.logger.SetHandler(log15.DiscardHandler())
+
+	// invalid IDs are rejected before connecting to the database
+	for _, id := range []string{"", "db.name", "/databases/user1:"} {
+		for name, handler := range map[string]httprouter.Handle{
+			"create": d.createReadOnlyUser,
+			"drop":   d.dropReadOnlyUser,
+		} {
+			rec := httptest.NewRecorder()
+			params := httprouter.Params{{Key: "id", Value: id}, {Key: "user", Value: "user1"}}
+			handler(rec, httptest.NewRequest("POST", "/", nil), params)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("%s %q: expected status 400, got %d", name, id, rec.Code)
+			}
+			var jsonErr httphelper.JSONError
+			if err := json.NewDecoder(rec.Body).Decode(&jsonErr); err != nil {
+				t.Fatal(err)
+			}
+			if jsonErr.Code != httphelper.ValidationErrorCode {
+				t.Fatalf("%s %q: expected a validation error, got %v", name, id, jsonErr)
+			}
+		}
+	}
+}
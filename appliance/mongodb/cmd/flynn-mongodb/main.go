@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/flynn/flynn/appliance/mongodb"
 	"github.com/flynn/flynn/discoverd/client"
@@ -78,6 +80,29 @@ func main() {
 	process.Singleton = singleton
 	process.ServerID = serverId
 	process.Host = ip
+	if ms := os.Getenv("MONGO_SLOW_QUERY_MS"); ms != "" {
+		n, err := strconv.Atoi(ms)
+		if err != nil || n < 0 {
+			shutdown.Fatalf("invalid MONGO_SLOW_QUERY_MS: %q", ms)
+		}
+		process.SlowQueryThreshold = time.Duration(n) * time.Millisecond
+	}
+	if window := os.Getenv("MONGO_OPLOG_MIN_WINDOW"); window != "" {
+		d, err := time.ParseDuration(window)
+		if err != nil || d < 0 {
+			shutdown.Fatalf("invalid MONGO_OPLOG_MIN_WINDOW: %q", window)
+		}
+		process.OplogMinWindow = d
+	}
+	process.OplogAutoResize = os.Getenv("MONGO_OPLOG_AUTO_RESIZE") == "true"
+	if mb := os.Getenv("MONGO_OPLOG_MAX_SIZE_MB"); mb != "" {
+		n, err := strconv.ParseInt(mb, 10, 64)
+		if err != nil || n < 0 {
+			shutdown.Fatalf("invalid MONGO_OPLOG_MAX_SIZE_MB: %q", mb)
+		}
+		process.OplogMaxSize = n << 20
+	}
+	go process.MonitorOplog()
 
 	dd := sd.NewDiscoverd(discoverd.DefaultClient.Service(serviceName), log.New("component", "discoverd"))
 
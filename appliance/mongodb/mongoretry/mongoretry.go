@@ -0,0 +1,138 @@
+// Package mongoretry retries MongoDB operations which fail with transient
+// errors, such as those returned while a replica set is electing a new
+// primary after members are added or removed.
+package mongoretry
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Backoff retries an operation with exponentially increasing delays between
+// attempts.
+type Backoff struct {
+	// Initial is the delay before the first retry.
+	Initial time.Duration
+
+	// Max is the maximum delay between attempts.
+	Max time.Duration
+
+	// Attempts is the maximum number of attempts, zero meaning attempts
+	// are only limited by the context.
+	Attempts int
+}
+
+// DefaultBackoff retries for about 30 seconds, which is long enough for a
+// replica set to elect a new primary.
+var DefaultBackoff = &Backoff{
+	Initial:  250 * time.Millisecond,
+	Max:      5 * time.Second,
+	Attempts: 10,
+}
+
+// Do calls f with DefaultBackoff.
+func Do(ctx context.Context, log log15.Logger, f func(context.Context) error) error {
+	return DefaultBackoff.Do(ctx, log, f)
+}
+
+// Do calls f until it succeeds, returns an error which isn't retryable, the
+// attempts are exhausted or ctx is done, returning the last error from f.
+// Retries are logged to log if it is not nil.
+func (b *Backoff) Do(ctx context.Context, log log15.Logger, f func(context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		err := f(ctx)
+		if err == nil || !IsRetryable(err) || b.Attempts > 0 && attempt >= b.Attempts {
+			return err
+		}
+		delay := b.delay(attempt)
+		if log != nil {
+			log.Info("retrying after transient MongoDB error", "err", err, "attempt", attempt, "delay", delay)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// delay returns the delay after the given attempt, which doubles with each
+// attempt up to the maximum and is jittered by up to half so that clients
+// retrying at the same time don't all retry together.
+func (b *Backoff) delay(attempt int) time.Duration {
+	d := b.Initial
+	for i := 1; i < attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+	if half := int64(d / 2); half > 0 {
+		d = time.Duration(half + rand.Int63n(half+1))
+	}
+	return d
+}
+
+// retryableCodes are the server error codes returned while the replica set
+// has no primary or the connected member is changing state.
+var retryableCodes = []int{
+	6,     // HostUnreachable
+	7,     // HostNotFound
+	89,    // NetworkTimeout
+	91,    // ShutdownInProgress
+	189,   // PrimarySteppedDown
+	10107, // NotWritablePrimary
+	11600, // InterruptedAtShutdown
+	11602, // InterruptedDueToReplStateChange
+	13435, // NotPrimaryNoSecondaryOk
+	13436, // NotPrimaryOrSecondary
+}
+
+// retryableMessages match transient errors which don't have a server error
+// code, such as errors from older servers or wrapped connection errors.
+var retryableMessages = []string{
+	"NotWritablePrimary",
+	"not primary",
+	"not master",
+	"NotPrimaryOrSecondary",
+	"node is recovering",
+	"connection refused",
+	"connection reset",
+}
+
+// IsRetryable returns whether err is a transient error which may succeed if
+// the operation is retried.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if mongo.IsNetworkError(err) {
+		return true
+	}
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) {
+		if serverErr.HasErrorLabel("RetryableWriteError") {
+			return true
+		}
+		for _, code := range retryableCodes {
+			if serverErr.HasErrorCode(code) {
+				return true
+			}
+		}
+	}
+	msg := err.Error()
+	for _, m := range retryableMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
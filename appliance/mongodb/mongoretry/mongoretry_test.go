@@ -0,0 +1,103 @@
+package mongoretry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var testBackoff = &Backoff{Initial: time.Millisecond, Max: 4 * time.Millisecond, Attempts: 5}
+
+func TestDoRetriesTransientErrors(t *testing.T) {
+	var calls int
+	err := testBackoff.Do(context.Background(), nil, func(context.Context) error {
+		calls++
+		if calls < 3 {
+			return mongo.CommandError{Code: 10107, Name: "NotWritablePrimary"}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestDoStopsOnPermanentError(t *testing.T) {
+	var calls int
+	err := testBackoff.Do(context.Background(), nil, func(context.Context) error {
+		calls++
+		return mongo.CommandError{Code: 11, Name: "UserNotFound"}
+	})
+	if e, ok := err.(mongo.CommandError); !ok || e.Code != 11 {
+		t.Fatalf("expected UserNotFound error, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 call, got %d", calls)
+	}
+}
+
+func TestDoLimitsAttempts(t *testing.T) {
+	var calls int
+	err := testBackoff.Do(context.Background(), nil, func(context.Context) error {
+		calls++
+		return errors.New("connection refused")
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if calls != testBackoff.Attempts {
+		t.Fatalf("expected %d calls, got %d", testBackoff.Attempts, calls)
+	}
+}
+
+func TestDoStopsWhenContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls int
+	b := &Backoff{Initial: time.Hour, Max: time.Hour}
+	err := b.Do(ctx, nil, func(context.Context) error {
+		calls++
+		cancel()
+		return errors.New("not primary")
+	})
+	if err == nil || calls != 1 {
+		t.Fatalf("expected a single failed call, got %d calls and error %v", calls, err)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	b := &Backoff{Initial: 100 * time.Millisecond, Max: time.Second}
+	for attempt, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		max *= time.Millisecond
+		for i := 0; i < 10; i++ {
+			if d := b.delay(attempt + 1); d < max/2 || d > max {
+				t.Fatalf("attempt %d: expected delay between %s and %s, got %s", attempt+1, max/2, max, d)
+			}
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	for _, x := range []struct {
+		err       error
+		retryable bool
+	}{
+		{nil, false},
+		{mongo.CommandError{Code: 189, Name: "PrimarySteppedDown"}, true},
+		{mongo.CommandError{Code: 2, Labels: []string{"RetryableWriteError"}}, true},
+		{mongo.CommandError{Code: 51003, Name: "UserAlreadyExists"}, false},
+		{fmt.Errorf("dial tcp: connection refused"), true},
+		{context.DeadlineExceeded, false},
+		{errors.New("auth failed"), false},
+	} {
+		if actual := IsRetryable(x.err); actual != x.retryable {
+			t.Errorf("IsRetryable(%v): expected %t, got %t", x.err, x.retryable, actual)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// DefaultOplogMinWindow is the default oplog window below which the
+	// primary warns that lagging members may fall off the oplog
+	DefaultOplogMinWindow = 24 * time.Hour
+
+	oplogCheckInterval = 5 * time.Minute
+
+	// oplogResizeHeadroom is the factor by which a resized oplog exceeds
+	// the size needed to hold OplogMinWindow of operations at the current
+	// write rate, so that it doesn't need resizing again as soon as the
+	// write rate increases
+	oplogResizeHeadroom = 1.5
+
+	// minOplogSize is the smallest oplog size in bytes accepted by
+	// replSetResizeOplog
+	minOplogSize = 990 << 20
+)
+
+// oplogUsage is the utilization of the local oplog.
+type oplogUsage struct {
+	Size    int64         // bytes of operations held
+	MaxSize int64         // maximum size in bytes
+	Window  time.Duration // time between the first and last operation
+}
+
+// full returns whether the oplog has reached its maximum size, after which
+// old operations are discarded and the window no longer grows.
+func (u *oplogUsage) full() bool {
+	return u.MaxSize > 0 && u.Size >= u.MaxSize*9/10
+}
+
+// oplogWarning returns a warning if the oplog is full and holds less than
+// minWindow of operations, or an empty string otherwise. An oplog which isn't
+// full yet isn't warned about, as its window is still growing.
+func oplogWarning(u *oplogUsage, minWindow time.Duration) string {
+	if minWindow <= 0 || !u.full() || u.Window >= minWindow {
+		return ""
+	}
+	return fmt.Sprintf("oplog window of %s is below the minimum of %s, members which fall further behind will need a full resync", u.Window, minWindow)
+}
+
+// oplogTargetSize returns the oplog size in bytes needed to hold minWindow of
+// operations at the current write rate with headroom, limited to maxSize
+// unless it is zero.
+func oplogTargetSize(u *oplogUsage, minWindow time.Duration, maxSize int64) int64 {
+	target := int64(minOplogSize)
+	if u.Window > 0 {
+		if size := int64(float64(u.Size) * float64(minWindow) / float64(u.Window) * oplogResizeHeadroom); size > target {
+			target = size
+		}
+	}
+	if maxSize > 0 && target > maxSize {
+		target = maxSize
+	}
+	return target
+}
+
+// MonitorOplog periodically checks the oplog window while the process is the
+// primary, warning when it is below OplogMinWindow and growing the oplog if
+// OplogAutoResize is set. It should be called in a goroutine.
+func (p *Process) MonitorOplog() {
+	if p.OplogMinWindow <= 0 {
+		return
+	}
+	ticker := time.NewTicker(oplogCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := p.checkOplog(); err != nil {
+			p.Logger.Error("error checking oplog", "fn", "checkOplog", "err", err)
+		}
+	}
+}
+
+func (p *Process) oplogWarning() string {
+	warning, _ := p.oplogWarningValue.Load().(string)
+	return warning
+}
+
+func (p *Process) checkOplog() error {
+	logger := p.Logger.New("fn", "checkOplog")
+
+	primary, err := p.isReadWrite()
+	if err != nil || !primary {
+		p.oplogWarningValue.Store("")
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	client, err := p.connectLocal(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(ctx)
+
+	usage, err := oplogUsageQuery(ctx, client)
+	if err != nil {
+		return err
+	}
+	warning := oplogWarning(usage, p.OplogMinWindow)
+	p.oplogWarningValue.Store(warning)
+	if warning == "" {
+		return nil
+	}
+	logger.Warn(warning, "size", usage.Size, "max_size", usage.MaxSize)
+
+	if !p.OplogAutoResize {
+		return nil
+	}
+	target := oplogTargetSize(usage, p.OplogMinWindow, p.OplogMaxSize)
+	if target <= usage.MaxSize {
+		logger.Warn("not resizing oplog, already at maximum size", "max_size", usage.MaxSize)
+		return nil
+	}
+	logger.Info("resizing oplog", "from", usage.MaxSize, "to", target)
+	// replSetResizeOplog takes the size in megabytes
+	return client.Database("admin").RunCommand(ctx, bson.D{
+		{Key: "replSetResizeOplog", Value: 1},
+		{Key: "size", Value: float64(target) / (1 << 20)},
+	}).Err()
+}
+
+// oplogUsageQuery returns the size and window of the local oplog.
+func oplogUsageQuery(ctx context.Context, client *mongo.Client) (*oplogUsage, error) {
+	var stats struct {
+		Size    int64 `bson:"size"`
+		MaxSize int64 `bson:"maxSize"`
+	}
+	if err := client.Database("local").RunCommand(ctx, bson.D{{Key: "collStats", Value: "oplog.rs"}}).Decode(&stats); err != nil {
+		return nil, err
+	}
+	first, last, err := oplogBounds(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	usage := &oplogUsage{Size: stats.Size, MaxSize: stats.MaxSize}
+	if last.T > first.T {
+		usage.Window = time.Duration(last.T-first.T) * time.Second
+	}
+	return usage, nil
+}
+
+// oplogBounds returns the timestamps of the first and last entries in the
+// local oplog.
+func oplogBounds(ctx context.Context, client *mongo.Client) (first, last primitive.Timestamp, err error) {
+	oplog := client.Database("local").Collection("oplog.rs")
+	entryTime := func(order int) (primitive.Timestamp, error) {
+		var entry struct {
+			Timestamp primitive.Timestamp `bson:"ts"`
+		}
+		opts := options.FindOne().
+			SetSort(bson.D{{Key: "$natural", Value: order}}).
+			SetProjection(bson.D{{Key: "ts", Value: 1}})
+		err := oplog.FindOne(ctx, bson.D{}, opts).Decode(&entry)
+		if err == mongo.ErrNoDocuments {
+			err = nil
+		}
+		return entry.Timestamp, err
+	}
+	if first, err = entryTime(1); err != nil {
+		return
+	}
+	last, err = entryTime(-1)
+	return
+}
@@ -0,0 +1,42 @@
+package mongodb
+
+import (
+	"time"
+
+	. "github.com/flynn/go-check"
+)
+
+func (MongoDBSuite) TestOplogWarning(c *C) {
+	const gb = 1 << 30
+	for _, x := range []struct {
+		desc    string
+		usage   oplogUsage
+		warning bool
+	}{
+		{"full with short window", oplogUsage{Size: gb, MaxSize: gb, Window: time.Hour}, true},
+		{"full with long window", oplogUsage{Size: gb, MaxSize: gb, Window: 48 * time.Hour}, false},
+		{"not full with short window", oplogUsage{Size: gb / 2, MaxSize: gb, Window: time.Hour}, false},
+		{"unknown maximum size", oplogUsage{Size: gb, Window: time.Hour}, false},
+	} {
+		warning := oplogWarning(&x.usage, 24*time.Hour)
+		c.Assert(warning != "", Equals, x.warning, Commentf(x.desc))
+	}
+
+	// a zero minimum window disables the warning
+	c.Assert(oplogWarning(&oplogUsage{Size: gb, MaxSize: gb, Window: time.Hour}, 0), Equals, "")
+}
+
+func (MongoDBSuite) TestOplogTargetSize(c *C) {
+	const gb = 1 << 30
+
+	// 2GB holds 4h of operations, so 24h needs 12GB plus headroom
+	usage := &oplogUsage{Size: 2 * gb, MaxSize: 2 * gb, Window: 4 * time.Hour}
+	c.Assert(oplogTargetSize(usage, 24*time.Hour, 0), Equals, int64(18*gb))
+
+	// the target is limited to the maximum size
+	c.Assert(oplogTargetSize(usage, 24*time.Hour, 10*gb), Equals, int64(10*gb))
+
+	// the target is never below the minimum oplog size
+	usage = &oplogUsage{Size: 10 << 20, MaxSize: 10 << 20, Window: 4 * time.Hour}
+	c.Assert(oplogTargetSize(usage, 24*time.Hour, 0), Equals, int64(minOplogSize))
+}
@@ -36,6 +36,10 @@ const (
 	DefaultOpTimeout   = 5 * time.Minute
 	DefaultReplTimeout = 1 * time.Minute
 
+	// DefaultSlowQueryThreshold is the default duration after which
+	// operations are recorded by the profiler and logged as slow queries
+	DefaultSlowQueryThreshold = 100 * time.Millisecond
+
 	BinName    = "mongod"
 	ConfigName = "mongod.conf"
 
@@ -77,6 +81,23 @@ type Process struct {
 	OpTimeout   time.Duration
 	ReplTimeout time.Duration
 
+	// SlowQueryThreshold enables the profiler for operations which take
+	// longer than the threshold and logs them as structured slow query
+	// entries, zero disables it
+	SlowQueryThreshold time.Duration
+
+	// OplogMinWindow is the oplog window below which the primary warns
+	// that lagging members may fall off the oplog, zero disables the check
+	OplogMinWindow time.Duration
+
+	// OplogAutoResize grows the oplog with replSetResizeOplog when the
+	// window drops below OplogMinWindow, up to OplogMaxSize bytes unless
+	// it is zero
+	OplogAutoResize bool
+	OplogMaxSize    int64
+
+	oplogWarningValue atomic.Value // string
+
 	Logger log15.Logger
 
 	// cmd is the running system command.
@@ -99,6 +120,9 @@ func NewProcess() *Process {
 		ReplTimeout: DefaultReplTimeout,
 		Logger:      log15.New("app", "mongodb"),
 
+		SlowQueryThreshold: DefaultSlowQueryThreshold,
+		OplogMinWindow:     DefaultOplogMinWindow,
+
 		events:         make(chan state.DatabaseEvent, 1),
 		cancelSyncWait: func() {},
 	}
@@ -674,6 +698,9 @@ func (p *Process) start() error {
 	logger.Info("starting process")
 
 	cmd := NewCmd(exec.Command(filepath.Join(p.BinDir, "mongod"), "--config", p.ConfigPath()))
+	if p.SlowQueryThreshold > 0 {
+		cmd.Stdout = newSlowQueryWriter(cmd.Stdout, p.Logger.New("component", "slow-query"))
+	}
 	if err := cmd.Start(); err != nil {
 		logger.Error("failed to start process", "err", err)
 		return err
@@ -801,9 +828,44 @@ func (p *Process) Info() (*client.DatabaseInfo, error) {
 		return info, err
 	}
 	logger.Debug("final info", "readWrite", info.ReadWrite)
+	if info.Running && p.securityEnabled() {
+		// replica set health is informational, so don't fail the status
+		// request if it can't be determined
+		if info.ReplicaSet, err = p.replicaSetHealth(); err != nil {
+			logger.Error("error getting replica set health", "err", err)
+			err = nil
+		} else {
+			info.ReplicaSet.OplogWarning = p.oplogWarning()
+		}
+	}
 	return info, err
 }
 
+// replicaSetHealth returns the health of the replica set, including the
+// window of operations held in the local oplog.
+func (p *Process) replicaSetHealth() (*client.ReplicaSetStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	client, err := p.connectLocal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Disconnect(ctx)
+
+	status, err := replSetGetStatusQuery(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	first, last, err := oplogBounds(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return replicaSetHealth(status, first, last), nil
+}
+
 func (p *Process) isReadWrite() (bool, error) {
 	logger := p.Logger.New("fn", "isReadWrite")
 	if !p.running() {
@@ -974,6 +1036,12 @@ func (p *Process) writeConfig(d configData) error {
 	d.Port = p.Port
 	d.DataDir = p.DataDir
 	d.SecurityEnabled = p.securityEnabled()
+	if p.SlowQueryThreshold > 0 {
+		d.SlowOpThresholdMs = int(p.SlowQueryThreshold / time.Millisecond)
+		if d.SlowOpThresholdMs == 0 {
+			d.SlowOpThresholdMs = 1
+		}
+	}
 
 	f, err := os.Create(p.ConfigPath())
 	if err != nil {
@@ -990,6 +1058,7 @@ type configData struct {
 	DataDir            string
 	SecurityEnabled    bool
 	ReplicationEnabled bool
+	SlowOpThresholdMs  int
 }
 
 var configTemplate = template.Must(template.New("mongod.conf").Parse(`
@@ -1020,6 +1089,12 @@ replication:
   replSetName: rs0
   enableMajorityReadConcern: true
 {{end}}
+
+{{if .SlowOpThresholdMs}}
+operationProfiling:
+  mode: slowOp
+  slowOpThresholdMs: {{.SlowOpThresholdMs}}
+{{end}}
 `[1:]))
 
 // isConnectionClosedError checks if an error indicates the connection was closed.
@@ -1,6 +1,9 @@
 package mongodb
 
 import (
+	"time"
+
+	"github.com/flynn/flynn/pkg/sirenia/client"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -45,13 +48,76 @@ type replSetOptime struct {
 }
 
 type replSetStatusMember struct {
-	Name      string        `bson:"name"`
-	Optime    replSetOptime `bson:"optime"`
-	SyncingTo string        `bson:"syncingTo"`
-	State     replicaState  `bson:"state"`
+	Name          string        `bson:"name"`
+	Health        float64       `bson:"health"`
+	Optime        replSetOptime `bson:"optime"`
+	SyncingTo     string        `bson:"syncingTo"`
+	State         replicaState  `bson:"state"`
+	StateStr      string        `bson:"stateStr"`
+	LastHeartbeat time.Time     `bson:"lastHeartbeat"`
+	ElectionDate  time.Time     `bson:"electionDate"`
 }
 
 type replSetStatus struct {
+	Set     string                `bson:"set"`
 	MyState replicaState          `bson:"myState"`
 	Members []replSetStatusMember `bson:"members"`
 }
+
+// replicaSetHealth summarises the given replica set status, using the first
+// and last oplog entry timestamps to determine the oplog window.
+func replicaSetHealth(status *replSetStatus, oplogFirst, oplogLast primitive.Timestamp) *client.ReplicaSetStatus {
+	health := &client.ReplicaSetStatus{
+		Name:    status.Set,
+		Members: make([]*client.ReplicaSetMember, 0, len(status.Members)),
+	}
+
+	// measure lag against the primary, or against the most recent optime
+	// if there is currently no primary
+	var head uint32
+	for _, m := range status.Members {
+		if m.State == Primary {
+			head = m.Optime.Timestamp.T
+			if !m.ElectionDate.IsZero() {
+				t := m.ElectionDate
+				health.LastElection = &t
+			}
+			break
+		}
+		if m.Optime.Timestamp.T > head {
+			head = m.Optime.Timestamp.T
+		}
+	}
+
+	var maxLag int64
+	for _, m := range status.Members {
+		member := &client.ReplicaSetMember{
+			Name:    m.Name,
+			State:   m.StateStr,
+			Healthy: m.Health == 1,
+		}
+		if m.Optime.Timestamp.T > 0 {
+			member.Optime = time.Unix(int64(m.Optime.Timestamp.T), 0).UTC()
+			if m.Optime.Timestamp.T < head {
+				member.Lag = int64(head - m.Optime.Timestamp.T)
+			}
+		}
+		if !m.LastHeartbeat.IsZero() {
+			t := m.LastHeartbeat
+			member.LastHeartbeat = &t
+		}
+		if m.State == Secondary && member.Lag > maxLag {
+			maxLag = member.Lag
+		}
+		health.Members = append(health.Members, member)
+	}
+
+	if oplogLast.T > oplogFirst.T {
+		health.OplogWindow = int64(oplogLast.T - oplogFirst.T)
+	}
+	health.OplogWindowRemaining = health.OplogWindow - maxLag
+	if health.OplogWindowRemaining < 0 {
+		health.OplogWindowRemaining = 0
+	}
+	return health
+}
@@ -0,0 +1,48 @@
+package mongodb
+
+import (
+	"time"
+
+	. "github.com/flynn/go-check"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (MongoDBSuite) TestReplicaSetHealth(c *C) {
+	election := time.Unix(900, 0)
+	heartbeat := time.Unix(1000, 0)
+	status := &replSetStatus{
+		Set:     "rs0",
+		MyState: Primary,
+		Members: []replSetStatusMember{
+			{Name: "a:27017", Health: 1, State: Primary, StateStr: "PRIMARY", Optime: replSetOptime{Timestamp: primitive.Timestamp{T: 1000}}, ElectionDate: election},
+			{Name: "b:27017", Health: 1, State: Secondary, StateStr: "SECONDARY", Optime: replSetOptime{Timestamp: primitive.Timestamp{T: 970}}, LastHeartbeat: heartbeat},
+			{Name: "c:27017", Health: 0, State: Down, StateStr: "(not reachable/healthy)"},
+		},
+	}
+
+	health := replicaSetHealth(status, primitive.Timestamp{T: 400}, primitive.Timestamp{T: 1000})
+	c.Assert(health.Name, Equals, "rs0")
+	c.Assert(health.LastElection, NotNil)
+	c.Assert(health.LastElection.Equal(election), Equals, true)
+	c.Assert(health.OplogWindow, Equals, int64(600))
+	c.Assert(health.OplogWindowRemaining, Equals, int64(570))
+	c.Assert(health.Members, HasLen, 3)
+
+	primary, secondary, down := health.Members[0], health.Members[1], health.Members[2]
+	c.Assert(primary.State, Equals, "PRIMARY")
+	c.Assert(primary.Healthy, Equals, true)
+	c.Assert(primary.Lag, Equals, int64(0))
+	c.Assert(primary.LastHeartbeat, IsNil)
+	c.Assert(secondary.Lag, Equals, int64(30))
+	c.Assert(secondary.Optime.Unix(), Equals, int64(970))
+	c.Assert(secondary.LastHeartbeat, NotNil)
+	c.Assert(down.Healthy, Equals, false)
+	c.Assert(down.Optime.IsZero(), Equals, true)
+	c.Assert(down.Lag, Equals, int64(0))
+
+	// without a primary, lag is measured against the most recent optime
+	status.Members[0].State = Secondary
+	health = replicaSetHealth(status, primitive.Timestamp{T: 400}, primitive.Timestamp{T: 1000})
+	c.Assert(health.LastElection, IsNil)
+	c.Assert(health.Members[1].Lag, Equals, int64(30))
+}
@@ -0,0 +1,105 @@
+package mongodb
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/inconshreveable/log15"
+)
+
+// maxSlowQueryCommandLen is the maximum length of the command logged for a
+// slow query, longer commands are truncated.
+const maxSlowQueryCommandLen = 1024
+
+// slowQueryWriter passes through mongod's log output, replacing "Slow query"
+// entries (which mongod logs for operations exceeding slowOpThresholdMs)
+// with structured log15 entries so that slow operations are easy to find in
+// the job's log output.
+type slowQueryWriter struct {
+	out    io.Writer
+	logger log15.Logger
+
+	mtx sync.Mutex
+	buf []byte
+}
+
+func newSlowQueryWriter(out io.Writer, logger log15.Logger) *slowQueryWriter {
+	return &slowQueryWriter{out: out, logger: logger}
+}
+
+func (w *slowQueryWriter) Write(p []byte) (int, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := w.buf[:i+1]
+		if !w.logSlowQuery(line) {
+			if _, err := w.out.Write(line); err != nil {
+				return 0, err
+			}
+		}
+		w.buf = w.buf[i+1:]
+	}
+	// don't buffer an unbounded amount of output without a newline
+	if len(w.buf) > 64*1024 {
+		if _, err := w.out.Write(w.buf); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[:0]
+	}
+	return len(p), nil
+}
+
+// slowQueryEntry is a mongod structured log entry for a slow query.
+type slowQueryEntry struct {
+	Msg  string `json:"msg"`
+	Attr struct {
+		Type           string          `json:"type"`
+		NS             string          `json:"ns"`
+		AppName        string          `json:"appName"`
+		Command        json.RawMessage `json:"command"`
+		PlanSummary    string          `json:"planSummary"`
+		KeysExamined   int64           `json:"keysExamined"`
+		DocsExamined   int64           `json:"docsExamined"`
+		NReturned      int64           `json:"nreturned"`
+		NumYields      int64           `json:"numYields"`
+		DurationMillis int64           `json:"durationMillis"`
+	} `json:"attr"`
+}
+
+// logSlowQuery logs line as a structured entry if it is a slow query log
+// entry, returning whether it was.
+func (w *slowQueryWriter) logSlowQuery(line []byte) bool {
+	if !bytes.Contains(line, []byte(`"Slow query"`)) {
+		return false
+	}
+	var entry slowQueryEntry
+	if err := json.Unmarshal(line, &entry); err != nil || entry.Msg != "Slow query" {
+		return false
+	}
+	a := entry.Attr
+	command := string(a.Command)
+	if len(command) > maxSlowQueryCommandLen {
+		command = command[:maxSlowQueryCommandLen] + "..."
+	}
+	w.logger.Warn("slow query",
+		"ns", a.NS,
+		"type", a.Type,
+		"duration_ms", a.DurationMillis,
+		"plan", a.PlanSummary,
+		"keys_examined", a.KeysExamined,
+		"docs_examined", a.DocsExamined,
+		"returned", a.NReturned,
+		"yields", a.NumYields,
+		"app_name", a.AppName,
+		"command", command,
+	)
+	return true
+}
@@ -0,0 +1,43 @@
+package mongodb
+
+import (
+	"bytes"
+
+	. "github.com/flynn/go-check"
+	"github.com/inconshreveable/log15"
+)
+
+func (MongoDBSuite) TestSlowQueryWriter(c *C) {
+	var records []*log15.Record
+	logger := log15.New()
+	logger.SetHandler(log15.FuncHandler(func(r *log15.Record) error {
+		records = append(records, r)
+		return nil
+	}))
+
+	var out bytes.Buffer
+	w := newSlowQueryWriter(&out, logger)
+
+	other := `{"t":{"$date":"2024-01-01T00:00:00.000+00:00"},"s":"I","c":"NETWORK","id":22943,"ctx":"listener","msg":"Connection accepted"}` + "\n"
+	slow := `{"t":{"$date":"2024-01-01T00:00:00.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn1","msg":"Slow query","attr":{"type":"command","ns":"app.users","command":{"find":"users","filter":{"age":{"$gt":30}}},"planSummary":"COLLSCAN","keysExamined":0,"docsExamined":5000,"nreturned":12,"numYields":4,"durationMillis":153}}` + "\n"
+
+	// write the slow query in two parts to check partial lines are buffered
+	_, err := w.Write([]byte(other + slow[:50]))
+	c.Assert(err, IsNil)
+	c.Assert(records, HasLen, 0)
+	_, err = w.Write([]byte(slow[50:]))
+	c.Assert(err, IsNil)
+
+	c.Assert(out.String(), Equals, other)
+	c.Assert(records, HasLen, 1)
+	c.Assert(records[0].Msg, Equals, "slow query")
+	ctx := make(map[string]interface{})
+	for i := 0; i < len(records[0].Ctx); i += 2 {
+		ctx[records[0].Ctx[i].(string)] = records[0].Ctx[i+1]
+	}
+	c.Assert(ctx["ns"], Equals, "app.users")
+	c.Assert(ctx["duration_ms"], Equals, int64(153))
+	c.Assert(ctx["plan"], Equals, "COLLSCAN")
+	c.Assert(ctx["docs_examined"], Equals, int64(5000))
+	c.Assert(ctx["command"], Equals, `{"find":"users","filter":{"age":{"$gt":30}}}`)
+}
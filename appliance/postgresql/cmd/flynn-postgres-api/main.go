@@ -1,19 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"net/http"
 	"os"
-	"strings"
 
-	discoverd "github.com/flynn/flynn/discoverd/client"
-	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/pkg/appliance/api"
 	"github.com/flynn/flynn/pkg/postgres"
-	"github.com/flynn/flynn/pkg/random"
-	"github.com/flynn/flynn/pkg/resource"
-	"github.com/flynn/flynn/pkg/shutdown"
-	"github.com/julienschmidt/httprouter"
-	"golang.org/x/net/context"
 )
 
 const (
@@ -36,15 +29,12 @@ func init() {
 }
 
 func main() {
-	defer shutdown.Exit()
-
 	db := postgres.Wait(&postgres.Conf{
 		Service:  serviceName,
 		User:     "flynn",
 		Password: os.Getenv("PGPASSWORD"),
 		Database: "postgres",
 	}, nil)
-	api := &pgAPI{db}
 
 	// Revoke default PUBLIC connect on shared databases so that provisioned
 	// users can only connect to their own database.  The "flynn" superuser
@@ -53,44 +43,29 @@ func main() {
 		_ = db.Exec(fmt.Sprintf(`REVOKE CONNECT ON DATABASE "%s" FROM PUBLIC`, sysDB))
 	}
 
-	router := httprouter.New()
-	router.POST("/databases", httphelper.WrapHandler(api.createDatabase))
-	router.DELETE("/databases", httphelper.WrapHandler(api.dropDatabase))
-	router.GET("/ping", httphelper.WrapHandler(api.ping))
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "3000"
-	}
-	addr := ":" + port
-
-	hb, err := discoverd.AddServiceAndRegister(serviceName+"-api", addr)
-	if err != nil {
-		shutdown.Fatal(err)
-	}
-	shutdown.BeforeExit(func() { hb.Close() })
-
-	handler := httphelper.ContextInjector(serviceName+"-api", httphelper.NewRequestLogger(router))
-	shutdown.Fatal(http.ListenAndServe(addr, handler))
+	api.Run(api.Config{
+		Service: serviceName,
+		Port:    "5432",
+		Driver:  &pgDriver{db},
+	})
 }
 
-type pgAPI struct {
+// pgDriver implements api.Driver for PostgreSQL
+type pgDriver struct {
 	db *postgres.DB
 }
 
-func (p *pgAPI) createDatabase(ctx context.Context, w http.ResponseWriter, req *http.Request) {
-	username, password, database := random.Hex(16), random.Hex(16), random.Hex(16)
+func (p *pgDriver) CreateDatabase(ctx context.Context, d *api.Database) (map[string]string, error) {
+	username, password, database := d.User, d.Password, d.Name
 
 	if err := p.db.Exec(fmt.Sprintf(`CREATE USER "%s" WITH PASSWORD '%s'`, username, password)); err != nil {
-		httphelper.Error(w, err)
-		return
+		return nil, err
 	}
 	// Create database with the user as owner. This gives them full privileges
 	// including CREATE on the public schema (required for PostgreSQL 15+).
 	if err := p.db.Exec(fmt.Sprintf(`CREATE DATABASE "%s" OWNER "%s"`, database, username)); err != nil {
 		p.db.Exec(fmt.Sprintf(`DROP USER "%s"`, username))
-		httphelper.Error(w, err)
-		return
+		return nil, err
 	}
 
 	// Isolate the new database: revoke the default PUBLIC connect privilege
@@ -99,8 +74,7 @@ func (p *pgAPI) createDatabase(ctx context.Context, w http.ResponseWriter, req *
 		// best-effort cleanup
 		p.db.Exec(fmt.Sprintf(`DROP DATABASE "%s"`, database))
 		p.db.Exec(fmt.Sprintf(`DROP USER "%s"`, username))
-		httphelper.Error(w, err)
-		return
+		return nil, err
 	}
 	// Explicitly grant connect to the owner (redundant for owner, but
 	// makes the intent clear and survives ownership changes).
@@ -111,55 +85,34 @@ func (p *pgAPI) createDatabase(ctx context.Context, w http.ResponseWriter, req *
 	p.db.Exec(fmt.Sprintf(`REVOKE CONNECT ON DATABASE "postgres" FROM "%s"`, username))
 
 	url := fmt.Sprintf("postgres://%s:%s@%s:5432/%s", username, password, serviceHost, database)
-	httphelper.JSON(w, 200, resource.Resource{
-		ID: fmt.Sprintf("/databases/%s:%s", username, database),
-		Env: map[string]string{
-			"FLYNN_POSTGRES": serviceName,
-			"PGHOST":         serviceHost,
-			"PGUSER":         username,
-			"PGPASSWORD":     password,
-			"PGDATABASE":     database,
-			"DATABASE_URL":   url,
-		},
-	})
+	return map[string]string{
+		"FLYNN_POSTGRES": serviceName,
+		"PGHOST":         serviceHost,
+		"PGUSER":         username,
+		"PGPASSWORD":     password,
+		"PGDATABASE":     database,
+		"DATABASE_URL":   url,
+	}, nil
 }
 
-func (p *pgAPI) dropDatabase(ctx context.Context, w http.ResponseWriter, req *http.Request) {
-	id := strings.SplitN(strings.TrimPrefix(req.FormValue("id"), "/databases/"), ":", 2)
-	if len(id) != 2 || id[1] == "" {
-		httphelper.ValidationError(w, "id", "is invalid")
-		return
-	}
-
+func (p *pgDriver) DropDatabase(ctx context.Context, d *api.Database) error {
 	// disable new connections to the target database
-	if err := p.db.Exec(disallowConns, id[1]); err != nil {
-		httphelper.Error(w, err)
-		return
+	if err := p.db.Exec(disallowConns, d.Name); err != nil {
+		return err
 	}
 
 	// terminate current connections
-	if err := p.db.Exec(disconnectConns, id[1]); err != nil {
-		httphelper.Error(w, err)
-		return
+	if err := p.db.Exec(disconnectConns, d.Name); err != nil {
+		return err
 	}
 
-	if err := p.db.Exec(fmt.Sprintf(`DROP DATABASE "%s"`, id[1])); err != nil {
-		httphelper.Error(w, err)
-		return
+	if err := p.db.Exec(fmt.Sprintf(`DROP DATABASE "%s"`, d.Name)); err != nil {
+		return err
 	}
 
-	if err := p.db.Exec(fmt.Sprintf(`DROP USER "%s"`, id[0])); err != nil {
-		httphelper.Error(w, err)
-		return
-	}
-
-	w.WriteHeader(200)
+	return p.db.Exec(fmt.Sprintf(`DROP USER "%s"`, d.User))
 }
 
-func (p *pgAPI) ping(ctx context.Context, w http.ResponseWriter, req *http.Request) {
-	if err := p.db.Exec("SELECT 1"); err != nil {
-		httphelper.Error(w, err)
-		return
-	}
-	w.WriteHeader(200)
+func (p *pgDriver) Ping(ctx context.Context) error {
+	return p.db.Exec("SELECT 1")
 }
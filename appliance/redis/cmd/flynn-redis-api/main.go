@@ -181,6 +181,9 @@ func NewHandler() *Handler {
 func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) { h.router.ServeHTTP(w, req) }
 
 func (h *Handler) servePostCluster(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	// Send the request ID with each request to the controller
+	client := controller.WithContext(httphelper.RequestIDContext(req), h.ControllerClient)
+
 	// Generate a password for the redis instance to use.
 	password := random.String(PasswordLength)
 
@@ -212,14 +215,14 @@ func (h *Handler) servePostCluster(w http.ResponseWriter, req *http.Request, _ h
 		Name: serviceName,
 		Meta: map[string]string{"flynn-system-app": "true"},
 	}
-	if err := h.ControllerClient.CreateApp(app); err != nil {
+	if err := client.CreateApp(app); err != nil {
 		h.Logger.Error("error creating app", "err", err)
 		httphelper.Error(w, err)
 		return
 	}
 
 	h.Logger.Info("creating release", "artifact.id", h.RedisImageID)
-	if err := h.ControllerClient.CreateRelease(app.ID, release); err != nil {
+	if err := client.CreateRelease(app.ID, release); err != nil {
 		h.Logger.Error("error creating release", "err", err)
 		httphelper.Error(w, err)
 		return
@@ -228,14 +231,14 @@ func (h *Handler) servePostCluster(w http.ResponseWriter, req *http.Request, _ h
 	h.Logger.Info("scale formation", "release.id", release.ID)
 	timeout := 5 * time.Minute
 	scaleOpts := ct.ScaleOptions{Processes: map[string]int{"redis": 1}, Timeout: &timeout}
-	if err := h.ControllerClient.ScaleAppRelease(app.ID, release.ID, scaleOpts); err != nil {
+	if err := client.ScaleAppRelease(app.ID, release.ID, scaleOpts); err != nil {
 		h.Logger.Error("error deploying release", "err", err)
 		httphelper.Error(w, err)
 		return
 	}
 
 	h.Logger.Info("setting app release", "release.ID", release.ID)
-	if err := h.ControllerClient.SetAppRelease(app.ID, release.ID); err != nil {
+	if err := client.SetAppRelease(app.ID, release.ID); err != nil {
 		h.Logger.Error("error setting app release", "err", err)
 		httphelper.Error(w, err)
 		return
@@ -277,9 +280,11 @@ func (h *Handler) serveDeleteCluster(w http.ResponseWriter, req *http.Request, _
 		return
 	}
 
-	// Retrieve release.
+	// Retrieve release, sending the request ID with each request to the
+	// controller.
+	client := controller.WithContext(httphelper.RequestIDContext(req), h.ControllerClient)
 	h.Logger.Info("retrieving release", "release.id", releaseID)
-	release, err := h.ControllerClient.GetRelease(releaseID)
+	release, err := client.GetRelease(releaseID)
 	if err != nil {
 		h.Logger.Error("error finding release", "err", err, "release.id", releaseID)
 		httphelper.Error(w, err)
@@ -297,7 +302,7 @@ func (h *Handler) serveDeleteCluster(w http.ResponseWriter, req *http.Request, _
 
 	// Destroy app release.
 	h.Logger.Info("destroying app", "app.name", appName)
-	if _, err := h.ControllerClient.DeleteApp(appName); err != nil {
+	if _, err := client.DeleteApp(appName); err != nil {
 		h.Logger.Error("error destroying app", "err", err)
 		httphelper.Error(w, err)
 		return
@@ -33,6 +33,10 @@ type State struct {
 	HostTimeout time.Duration
 	JobTimeout  time.Duration
 
+	// Lite is true if all hosts are running in lite mode, in which case
+	// the steps in liteSkipSteps are skipped
+	Lite bool
+
 	discoverd     *discoverd.Client
 	controller    controller.Client
 	controllerKey string
@@ -169,8 +173,18 @@ func (m Manifest) Run(ch chan<- *StepInfo, cfg Config) (state *State, err error)
 	return m.RunWithState(ch, state)
 }
 
+// liteSkipSteps are the IDs of steps which aren't needed on lite mode hosts,
+// which configure their own network instead of using flannel.
+var liteSkipSteps = map[string]struct{}{
+	"flannel":     {},
+	"flannel-app": {},
+}
+
 func (m Manifest) RunWithState(ch chan<- *StepInfo, state *State) (*State, error) {
 	for _, s := range m {
+		if _, ok := liteSkipSteps[s.StepMeta.ID]; ok && state.Lite {
+			continue
+		}
 		ch <- &StepInfo{StepMeta: s.StepMeta, State: "start", Timestamp: time.Now().UTC()}
 
 		if err := s.Run(state); err != nil {
@@ -264,6 +278,7 @@ func checkOnlineHosts(expected int, state *State, urls []string, timeout time.Du
 				remaining[url] = struct{}{}
 			}
 			state.Hosts = make([]*cluster.Host, 0, known)
+			state.Lite = true
 			for _, url := range urls {
 				h := cluster.NewHost("", url, nil, nil)
 				status, err := h.GetStatus()
@@ -272,6 +287,7 @@ func checkOnlineHosts(expected int, state *State, urls []string, timeout time.Du
 				}
 				delete(remaining, url)
 				online++
+				state.Lite = state.Lite && status.Lite
 				state.Hosts = append(state.Hosts, cluster.NewHost(status.ID, status.URL, nil, nil))
 			}
 			if online >= expected {
@@ -1,6 +1,7 @@
 package bootstrap
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/url"
@@ -89,7 +90,7 @@ func (a *RunAppAction) Run(s *State) error {
 			return err
 		}
 		lookupDiscoverdURLHost(s, u, time.Second)
-		res, err := resource.Provision(u.String(), nil)
+		res, err := resource.Provision(context.Background(), u.String(), nil)
 		if err != nil {
 			return err
 		}
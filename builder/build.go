@@ -30,6 +30,7 @@ import (
 	host "github.com/flynn/flynn/host/types"
 	"github.com/flynn/flynn/pkg/exec"
 	"github.com/flynn/flynn/pkg/term"
+	registry "github.com/flynn/flynn/registry/client"
 	"github.com/flynn/go-docopt"
 	"github.com/golang/groupcache/singleflight"
 	"github.com/inconshreveable/log15"
@@ -117,6 +118,7 @@ usage: flynn-builder build [options]
 options:
   -x, --version=<version>   version to use [default: dev]
   -v, --verbose             be verbose
+  --registry=<url>          push built images to the registry at <url>
 
 Build Flynn images using builder/manifest.json.
 
@@ -508,6 +510,13 @@ func runBuild(args *docopt.Args) error {
 		return err
 	}
 
+	if registryURL := args.String["--registry"]; registryURL != "" {
+		log.Info("pushing images", "registry", registryURL)
+		if err := builder.PushImages(registry.New(registryURL, os.Getenv("FLYNN_REGISTRY_KEY"))); err != nil {
+			return err
+		}
+	}
+
 	log.Info("writing manifests")
 	if err := builder.WriteManifests(manifest.Manifests); err != nil {
 		return err
@@ -946,10 +955,11 @@ func (b *Builder) WriteManifests(manifests map[string]string) error {
 				replaceErr = fmt.Errorf("unknown image %q", name)
 				return nil
 			}
-			artifact.Meta = map[string]string{
-				"flynn.component":    name,
-				"flynn.system-image": "true",
+			if artifact.Meta == nil {
+				artifact.Meta = make(map[string]string, 2)
 			}
+			artifact.Meta["flynn.component"] = name
+			artifact.Meta["flynn.system-image"] = "true"
 			data, err := json.Marshal(artifact)
 			if err != nil {
 				replaceErr = err
@@ -970,6 +980,26 @@ func (b *Builder) WriteManifests(manifests map[string]string) error {
 	return nil
 }
 
+// PushImages pushes the built images and their layers to a registry as
+// flynn/<image> tagged with the build version, replacing the built artifacts
+// with ones which pull layers from the registry
+func (b *Builder) PushImages(client *registry.Client) error {
+	b.artifactsMtx.Lock()
+	defer b.artifactsMtx.Unlock()
+	openLayer := func(layer *ct.ImageLayer) (io.ReadSeekCloser, error) {
+		return os.Open(b.layerPath(layer.ID))
+	}
+	for name, artifact := range b.artifacts {
+		b.log.Info("pushing image", "image", name)
+		pushed, err := client.PushArtifact("flynn/"+name, b.version, artifact, openLayer)
+		if err != nil {
+			return fmt.Errorf("error pushing %s image: %s", name, err)
+		}
+		b.artifacts[name] = pushed
+	}
+	return nil
+}
+
 // WriteImages writes the built images to build/images.json
 func (b *Builder) WriteImages() error {
 	path := "build/images.json"
@@ -1138,6 +1168,13 @@ func (b *Builder) BuildLayer(l *Layer, id, name string, run []string, env map[st
 	// binary with the given version if the build inputs have changed.
 	job.Config.Env["FLYNN_VERSION"] = b.version
 
+	// set FLYNN_RELEASE_PUBLIC_KEY which will be assigned to the
+	// pkg/ghrelease.publicKey variable, so that the binaries verify the
+	// signature of release checksums before installing updates
+	if key := os.Getenv("FLYNN_RELEASE_PUBLIC_KEY"); key != "" {
+		job.Config.Env["FLYNN_RELEASE_PUBLIC_KEY"] = key
+	}
+
 	// run the job in the host network to avoid a kernel bug which causes
 	// subsequent jobs to block waiting on the lo network device to become
 	// free (see https://github.com/docker/docker/issues/5618).
@@ -28,6 +28,9 @@ if [[ $(basename $0) == "gobin" ]]; then
 fi
 
 GO_LDFLAGS="-X github.com/flynn/flynn/pkg/version.version=${FLYNN_VERSION}"
+if [[ -n "${FLYNN_RELEASE_PUBLIC_KEY}" ]]; then
+  GO_LDFLAGS="${GO_LDFLAGS} -X github.com/flynn/flynn/pkg/ghrelease.publicKey=${FLYNN_RELEASE_PUBLIC_KEY}"
+fi
 
 if [[ "$1" = "build" ]]; then
 	${BIN} $1 -tags "apparmor seccomp" -ldflags "${GO_LDFLAGS}" ${@:2}
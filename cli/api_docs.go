@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/flynn/flynn/controller/openapi"
+	"github.com/flynn/flynn/pkg/version"
+	"github.com/flynn/go-docopt"
+)
+
+func init() {
+	register("api-docs", runAPIDocs, `
+usage: flynn api-docs [options]
+
+Print the OpenAPI 3 document describing the controller API.
+
+The document is generated from the API definitions compiled into this
+binary, so no cluster is required. A running controller serves the same
+document at /schema.
+
+Options:
+	-f, --file=<file>  name of file to write to (defaults to stdout)
+`)
+}
+
+func runAPIDocs(args *docopt.Args) error {
+	out := os.Stdout
+	if name := args.String["--file"]; name != "" {
+		f, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(openapi.Spec(version.String()))
+}
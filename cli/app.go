@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os/exec"
+	"strings"
 
 	controller "github.com/flynn/flynn/controller/client"
 	ct "github.com/flynn/flynn/controller/types"
@@ -51,9 +52,22 @@ Examples:
 `)
 	register("apps", runApps, `
 usage: flynn apps
+       flynn apps clone [--domain=<domain>] [--exclude-env=<keys>] [--provision-resources] [<name>]
 
 List all apps.
 
+Options:
+	--domain=<domain>      domain which replaces the app's default domain in the routes of the clone (defaults to the clone's default domain)
+	--exclude-env=<keys>   comma separated list of env vars not copied to the clone
+	--provision-resources  provision new resources for the clone rather than sharing the app's resources
+
+Commands:
+	With no arguments, shows a list of apps.
+
+	clone  creates a copy of the app with its current release, formation,
+	       resources and routes. If a name is not provided, a random name
+	       will be generated.
+
 Examples:
 
 	$ flynn apps
@@ -63,6 +77,9 @@ Examples:
 	8cfd94d040b14bd8aecc086c8f5f5e0d  blobstore
 	f488cfb478f54edea497bf6347c2eb80  postgres
 	9d5be7be873c41b9898032c08aa87597  controller
+
+	$ flynn -a example apps clone --domain pr-12.example.com --exclude-env STRIPE_KEY --provision-resources example-pr-12
+	Cloned example to example-pr-12 (2 routes, provisioned 1 resources)
 `)
 
 	register("info", runInfo, `
@@ -143,6 +160,10 @@ func runDelete(args *docopt.Args, client controller.Client) error {
 }
 
 func runApps(args *docopt.Args, client controller.Client) error {
+	if args.Bool["clone"] {
+		return runAppsClone(args, client)
+	}
+
 	apps, err := client.AppList()
 	if err != nil {
 		return err
@@ -158,6 +179,33 @@ func runApps(args *docopt.Args, client controller.Client) error {
 	return nil
 }
 
+func runAppsClone(args *docopt.Args, client controller.Client) error {
+	req := &ct.AppCloneRequest{
+		Name:               args.String["<name>"],
+		Domain:             args.String["--domain"],
+		ProvisionResources: args.Bool["--provision-resources"],
+	}
+	if keys := args.String["--exclude-env"]; keys != "" {
+		for _, key := range strings.Split(keys, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				req.ExcludeEnv = append(req.ExcludeEnv, key)
+			}
+		}
+	}
+
+	appName := mustApp()
+	res, err := client.CloneApp(appName, req)
+	if err != nil {
+		return err
+	}
+	resources := "shared %d resources"
+	if req.ProvisionResources {
+		resources = "provisioned %d resources"
+	}
+	log.Printf("Cloned %s to %s (%d routes, "+resources+")", appName, res.App.Name, len(res.Routes), len(res.Resources))
+	return nil
+}
+
 func runInfo(_ *docopt.Args, client controller.Client) error {
 	appName := mustApp()
 
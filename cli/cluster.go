@@ -1,9 +1,6 @@
 package main
 
 import (
-	"crypto/sha256"
-	"crypto/tls"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -28,12 +25,13 @@ import (
 func init() {
 	register("cluster", runCluster, `
 usage: flynn cluster
-       flynn cluster add [-f] [-d] [--git-url <giturl>] [--no-git] [--dashboard-url <url>] [--image-url <url>] [--docker-push-url <url>] [--docker] [-p <tlspin>] <cluster-name> <domain> <key>
+       flynn cluster add [-f] [-d] [--git-url <giturl>] [--no-git] [--dashboard-url <url>] [--image-url <url>] [--docker-push-url <url>] [--docker] [-p <tlspin>] [--insecure-pin-refresh] <cluster-name> <domain> <key>
        flynn cluster remove <cluster-name>
        flynn cluster default [<cluster-name>]
        flynn cluster migrate-domain <domain>
        flynn cluster update-pin [--clear]
        flynn cluster backup [--file <file>]
+       flynn cluster report [--json | --html]
        flynn cluster log-sink
        flynn cluster log-sink add syslog [--use-ids] [--insecure] [--format <format>] <url> [<prefix>]
        flynn cluster log-sink remove <id>
@@ -57,6 +55,15 @@ Commands:
             --docker-push-url=<url>   [DEPRECATED] Docker push URL
             --docker                  [DEPRECATED] configure Docker to push to the cluster
             -p, --tls-pin=<tlspin>    SHA256 of the cluster's TLS cert
+            --insecure-pin-refresh    update the TLS pin automatically if the
+                                      cluster's certificate changes and is not
+                                      signed by a trusted CA
+
+        If the cluster's certificate changes and no longer matches the TLS
+        pin, for example once a Let's Encrypt certificate is issued for the
+        controller, the pin is cleared if the new certificate is signed by a
+        trusted CA (after confirmation when running interactively), and the
+        command retried.
 
     remove
         Removes <cluster-name> from the ~/.flynnrc configuration file.
@@ -92,6 +99,15 @@ Commands:
         options:
             --file=<backup-file>  file to write backup to (defaults to stdout)
 
+    report
+        Prints a report of the cluster's hosts, their total and allocated
+        resources, the number of apps, jobs by state and volumes by state, and
+        any skew between the versions of flynn-host running on each host.
+
+        options:
+            --json  print the report in JSON format
+            --html  print the report as an HTML document
+
     log-sink
         With no arguments, prints a list of registered log-sinks for this cluster
 
@@ -125,6 +141,16 @@ Examples:
 
 	$ flynn cluster update-pin --clear
 	Cleared TLS pin for cluster "default". Standard TLS verification will be used.
+
+	$ flynn cluster report
+	Generated:     2024-01-27 10:00:00 UTC
+	Hosts:         3
+	CPUs:          24 (3.5 allocated)
+	Memory:        45 GiB used of 94 GiB (12 GiB allocated)
+	Disk:          120 GiB used of 1.5 TiB
+	Apps:          21 (13 system)
+	Version skew:  no
+	...
 `)
 }
 
@@ -147,6 +173,8 @@ func runCluster(args *docopt.Args) error {
 		return runClusterUpdatePin(args)
 	} else if args.Bool["backup"] {
 		return runClusterBackup(args)
+	} else if args.Bool["report"] {
+		return runClusterReport(args)
 	}
 
 	w := tabWriter()
@@ -186,6 +214,8 @@ func runClusterAdd(args *docopt.Args) error {
 		ImageURL:      args.String["--image-url"],
 		DockerPushURL: args.String["--docker-push-url"],
 		TLSPin:        args.String["--tls-pin"],
+
+		InsecurePinRefresh: args.Bool["--insecure-pin-refresh"],
 	}
 	dash := strings.TrimSpace(args.String["--dashboard-url"])
 	if dash != "" {
@@ -474,34 +504,12 @@ func runClusterUpdatePin(args *docopt.Args) error {
 	}
 
 	// Fetch the current certificate from the controller
-	u, err := url.Parse(cluster.ControllerURL)
-	if err != nil {
-		return fmt.Errorf("Error parsing controller URL: %s", err)
-	}
-
-	host := u.Host
-	if !strings.Contains(host, ":") {
-		host = host + ":443"
-	}
-
-	// Connect without certificate verification to get the current cert
-	conn, err := tls.Dial("tcp", host, &tls.Config{
-		InsecureSkipVerify: true,
-	})
+	_, certs, err := controllerCertificates(cluster)
 	if err != nil {
-		return fmt.Errorf("Error connecting to controller: %s", err)
-	}
-	defer conn.Close()
-
-	state := conn.ConnectionState()
-	if len(state.PeerCertificates) == 0 {
-		return errors.New("No certificates returned by controller")
+		return err
 	}
-
-	// Calculate the pin (SHA256 of the leaf certificate's DER bytes, base64 encoded)
-	leafCert := state.PeerCertificates[0]
-	h := sha256.Sum256(leafCert.Raw)
-	newPin := base64.StdEncoding.EncodeToString(h[:])
+	leafCert := certs[0]
+	newPin := tlsPin(leafCert)
 
 	if cluster.TLSPin == newPin {
 		log.Printf("TLS pin for cluster %q is already up to date.", cluster.Name)
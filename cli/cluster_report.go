@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/docker/go-units"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/go-docopt"
+)
+
+func runClusterReport(args *docopt.Args) error {
+	client, err := getClusterClient()
+	if err != nil {
+		return err
+	}
+	report, err := client.ClusterReport()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case args.Bool["--json"]:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case args.Bool["--html"]:
+		return clusterReportTemplate.Execute(os.Stdout, report)
+	}
+	printClusterReport(report)
+	return nil
+}
+
+func printClusterReport(report *ct.ClusterReport) {
+	w := tabWriter()
+	res := report.Resources
+	listRec(w, "Generated:", report.GeneratedAt.UTC().Format("2006-01-02 15:04:05 MST"))
+	listRec(w, "Hosts:", len(report.Hosts))
+	listRec(w, "CPUs:", fmt.Sprintf("%d (%s allocated)", res.CPUCount, formatMilliCPU(res.AllocatedMilliCPU)))
+	listRec(w, "Memory:", fmt.Sprintf("%s used of %s (%s allocated)", formatBytes(res.MemoryUsedBytes), formatBytes(res.MemoryTotalBytes), formatBytes(res.AllocatedMemoryBytes)))
+	listRec(w, "Disk:", fmt.Sprintf("%s used of %s", formatBytes(res.DiskUsedBytes), formatBytes(res.DiskTotalBytes)))
+	listRec(w, "Apps:", fmt.Sprintf("%d (%d system)", report.Apps, report.SystemApps))
+	listRec(w, "Version skew:", yesNo(report.Skew))
+	w.Flush()
+
+	fmt.Println("\nJobs:")
+	w = tabWriter()
+	listRec(w, "STATE", "COUNT")
+	for _, state := range sortedKeys(report.Jobs) {
+		listRec(w, state, report.Jobs[ct.JobState(state)])
+	}
+	w.Flush()
+
+	fmt.Println("\nVolumes:")
+	w = tabWriter()
+	listRec(w, "STATE", "COUNT")
+	for _, state := range sortedKeys(report.Volumes) {
+		listRec(w, state, report.Volumes[ct.VolumeState(state)])
+	}
+	w.Flush()
+
+	fmt.Println("\nVersions:")
+	w = tabWriter()
+	listRec(w, "VERSION", "HOSTS")
+	for _, version := range sortedKeys(report.Versions) {
+		listRec(w, version, strings.Join(report.Versions[version], ", "))
+	}
+	w.Flush()
+
+	fmt.Println("\nHosts:")
+	w = tabWriter()
+	listRec(w, "ID", "ADDR", "VERSION", "CPUS", "MEMORY", "DISK", "JOBS", "VOLUMES", "ERROR")
+	for _, h := range report.Hosts {
+		r := h.Resources
+		listRec(w,
+			h.ID,
+			h.Addr,
+			h.Version,
+			fmt.Sprintf("%s/%d", formatMilliCPU(r.AllocatedMilliCPU), r.CPUCount),
+			fmt.Sprintf("%s/%s", formatBytes(r.AllocatedMemoryBytes), formatBytes(r.MemoryTotalBytes)),
+			fmt.Sprintf("%s/%s", formatBytes(r.DiskUsedBytes), formatBytes(r.DiskTotalBytes)),
+			h.Jobs,
+			h.Volumes,
+			h.Error,
+		)
+	}
+	w.Flush()
+}
+
+func formatBytes(n int64) string {
+	return units.BytesSize(float64(n))
+}
+
+func formatMilliCPU(n int64) string {
+	return fmt.Sprintf("%.2f", float64(n)/1000)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// sortedKeys returns the keys of a report map in order
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[ct.JobState]int:
+		for k := range m {
+			keys = append(keys, string(k))
+		}
+	case map[ct.VolumeState]int:
+		for k := range m {
+			keys = append(keys, string(k))
+		}
+	case map[string][]string:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var clusterReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"bytes": formatBytes,
+	"cpu":   formatMilliCPU,
+	"yesno": yesNo,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Flynn cluster report</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+</style>
+</head>
+<body>
+<h1>Flynn cluster report</h1>
+<p>Generated {{.GeneratedAt.UTC.Format "2006-01-02 15:04:05 MST"}}</p>
+<h2>Summary</h2>
+<table>
+<tr><th>Hosts</th><td>{{len .Hosts}}</td></tr>
+<tr><th>CPUs</th><td>{{.Resources.CPUCount}} ({{cpu .Resources.AllocatedMilliCPU}} allocated)</td></tr>
+<tr><th>Memory</th><td>{{bytes .Resources.MemoryUsedBytes}} used of {{bytes .Resources.MemoryTotalBytes}} ({{bytes .Resources.AllocatedMemoryBytes}} allocated)</td></tr>
+<tr><th>Disk</th><td>{{bytes .Resources.DiskUsedBytes}} used of {{bytes .Resources.DiskTotalBytes}}</td></tr>
+<tr><th>Apps</th><td>{{.Apps}} ({{.SystemApps}} system)</td></tr>
+<tr><th>Version skew</th><td>{{yesno .Skew}}</td></tr>
+</table>
+<h2>Jobs</h2>
+<table>
+<tr><th>State</th><th>Count</th></tr>
+{{range $state, $count := .Jobs}}<tr><td>{{$state}}</td><td>{{$count}}</td></tr>
+{{end}}</table>
+<h2>Volumes</h2>
+<table>
+<tr><th>State</th><th>Count</th></tr>
+{{range $state, $count := .Volumes}}<tr><td>{{$state}}</td><td>{{$count}}</td></tr>
+{{end}}</table>
+<h2>Versions</h2>
+<table>
+<tr><th>Version</th><th>Hosts</th></tr>
+{{range $version, $hosts := .Versions}}<tr><td>{{$version}}</td><td>{{range $i, $h := $hosts}}{{if $i}}, {{end}}{{$h}}{{end}}</td></tr>
+{{end}}</table>
+<h2>Hosts</h2>
+<table>
+<tr><th>ID</th><th>Address</th><th>Version</th><th>CPUs</th><th>Memory</th><th>Disk</th><th>Jobs</th><th>Volumes</th><th>Error</th></tr>
+{{range .Hosts}}<tr><td>{{.ID}}</td><td>{{.Addr}}</td><td>{{.Version}}</td><td>{{cpu .Resources.AllocatedMilliCPU}}/{{.Resources.CPUCount}}</td><td>{{bytes .Resources.AllocatedMemoryBytes}}/{{bytes .Resources.MemoryTotalBytes}}</td><td>{{bytes .Resources.DiskUsedBytes}}/{{bytes .Resources.DiskTotalBytes}}</td><td>{{.Jobs}}</td><td>{{.Volumes}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -31,6 +31,11 @@ type Cluster struct {
 	GitURL        string `json:"git_url"`
 	ImageURL      string `json:"image_url"`
 	DockerPushURL string `json:"docker_push_url,omitempty" toml:"DockerPushURL,omitempty"`
+
+	// InsecurePinRefresh is whether the TLS pin is updated automatically
+	// when the controller's certificate changes, even if the new
+	// certificate is not signed by a trusted CA
+	InsecurePinRefresh bool `json:"insecure_pin_refresh,omitempty" toml:"InsecurePinRefresh,omitempty"`
 }
 
 func (c *Cluster) Client() (controller.Client, error) {
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
 
+	"github.com/docker/go-units"
 	controller "github.com/flynn/flynn/controller/client"
 	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/go-docopt"
@@ -12,18 +15,28 @@ import (
 func init() {
 	register("deployment", runDeployments, `
 usage: flynn deployment
+       flynn deployment show <id>
        flynn deployment timeout [<timeout>]
        flynn deployment batch-size [<size>]
+       flynn deployment strategy [<strategy>]
 
 Manage app deployments.
 
 Commands:
     With no arguments, shows a list of deployments
 
+	show        shows a deployment's status, why any of its jobs failed and, once
+	            complete, the resource usage of the old release's jobs
+
 	timeout     gets or sets the number of seconds to wait for each job to start when deploying
 
 	batch-size  gets or sets the batch size for deployments using the in-batches strategy
 
+	strategy    gets or sets the deployment strategy, one of all-at-once, one-by-one,
+	            one-down-one-up, in-batches or canary. Canary deployments start the
+	            new release alongside the old one and shift the requests of its HTTP
+	            routes to it in steps, rolling back if any of its jobs go down
+
 Examples:
 
 	$ flynn deployment
@@ -33,6 +46,25 @@ Examples:
 	f415ae79-0b41-4a49-bc42-d4f90c5a36c5  failed    About a minute ago  About a minute ago
 	8901a4ba-8d0a-4c84-a467-bfc095aaa75d  complete  4 minutes ago       4 minutes ago
 
+	$ flynn deployment show f415ae79-0b41-4a49-bc42-d4f90c5a36c5
+	ID:        f415ae79-0b41-4a49-bc42-d4f90c5a36c5
+	Status:    failed
+	Created:   About a minute ago
+	Finished:  About a minute ago
+	Error:     web job failed to start: exited with status 1
+	Job failures:
+	  web job host0-6a1e4b1c-2f0d-4cbb-9a5e-0b7a8d6f1f3e on host host0: exited with status 1
+
+	$ flynn deployment show 39f8b98b-2aed-40a5-9423-ae174b3fb7a9
+	ID:        39f8b98b-2aed-40a5-9423-ae174b3fb7a9
+	Status:    complete
+	Created:   16 seconds ago
+	Finished:  14 seconds ago
+	Old release usage:
+	  TYPE    JOBS  CPU AVG  CPU PEAK  MEMORY AVG  MEMORY PEAK  MEMORY LIMIT
+	  web     3     12.4%    61.0%     182.3MiB    240.1MiB     1GiB
+	  worker  1     3.2%     8.5%      96.7MiB     101.2MiB     1GiB
+
 	$ flynn deployment timeout 150
 
 	$ flynn deployment timeout
@@ -42,6 +74,11 @@ Examples:
 
 	$ flynn deployment batch-size
 	3
+
+	$ flynn deployment strategy canary
+
+	$ flynn deployment strategy
+	canary
 `)
 }
 
@@ -51,11 +88,18 @@ func runDeployments(args *docopt.Args, client controller.Client) error {
 			return runSetDeployTimeout(args, client)
 		}
 		return runGetDeployTimeout(args, client)
+	} else if args.Bool["show"] {
+		return runDeploymentShow(args, client)
 	} else if args.Bool["batch-size"] {
 		if args.String["<size>"] != "" {
 			return runSetDeployBatchSize(args, client)
 		}
 		return runGetDeployBatchSize(args, client)
+	} else if args.Bool["strategy"] {
+		if args.String["<strategy>"] != "" {
+			return runSetDeployStrategy(args, client)
+		}
+		return runGetDeployStrategy(args, client)
 	}
 
 	deployments, err := client.DeploymentList(mustApp())
@@ -73,6 +117,95 @@ func runDeployments(args *docopt.Args, client controller.Client) error {
 	return nil
 }
 
+func runDeploymentShow(args *docopt.Args, client controller.Client) error {
+	d, err := client.GetDeployment(args.String["<id>"])
+	if err != nil {
+		return err
+	}
+	events, err := client.ListEvents(ct.ListEventsOptions{
+		AppID:       d.AppID,
+		ObjectTypes: []ct.EventType{ct.EventTypeDeployment},
+		ObjectID:    d.ID,
+	})
+	if err != nil {
+		return err
+	}
+	deployErr, failures := deploymentFailures(events)
+
+	w := tabWriter()
+	listRec(w, "ID:", d.ID)
+	listRec(w, "Status:", d.Status)
+	listRec(w, "Created:", humanTime(d.CreatedAt))
+	listRec(w, "Finished:", humanTime(d.FinishedAt))
+	if deployErr != "" {
+		listRec(w, "Error:", deployErr)
+	}
+	w.Flush()
+	if len(failures) > 0 {
+		fmt.Println("Job failures:")
+		for _, f := range failures {
+			fmt.Println("  " + f.String())
+		}
+	}
+	if len(d.OldReleaseUsage) > 0 {
+		fmt.Println("Old release usage:")
+		printProcessUsage(d.OldReleaseUsage)
+	}
+	return nil
+}
+
+// printProcessUsage prints a table of the resource usage of each process
+// type, indented under a heading.
+func printProcessUsage(usage map[string]*ct.ProcessUsage) {
+	types := make([]string, 0, len(usage))
+	for typ := range usage {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+
+	w := tabWriter()
+	defer w.Flush()
+	listRec(w, "  TYPE", "JOBS", "CPU AVG", "CPU PEAK", "MEMORY AVG", "MEMORY PEAK", "MEMORY LIMIT")
+	for _, typ := range types {
+		u := usage[typ]
+		limit := "-"
+		if u.MemoryLimitBytes > 0 {
+			limit = units.BytesSize(float64(u.MemoryLimitBytes))
+		}
+		listRec(w,
+			"  "+typ,
+			u.Jobs,
+			fmt.Sprintf("%.1f%%", u.CPUAvgPercent),
+			fmt.Sprintf("%.1f%%", u.CPUPeakPercent),
+			units.BytesSize(float64(u.MemoryAvgBytes)),
+			units.BytesSize(float64(u.MemoryPeakBytes)),
+			limit,
+		)
+	}
+}
+
+// deploymentFailures returns the error of a failed deployment and the
+// failures of the jobs it started, oldest first, from its events. The final
+// failed event repeats the job failures recorded as the deploy ran, so only
+// its error is used.
+func deploymentFailures(events []*ct.Event) (string, []*ct.DeploymentJobFailure) {
+	// events are returned newest first
+	var deployErr string
+	var failures []*ct.DeploymentJobFailure
+	for i := len(events) - 1; i >= 0; i-- {
+		var e ct.DeploymentEvent
+		if err := json.Unmarshal(events[i].Data, &e); err != nil {
+			continue
+		}
+		if e.Status == "failed" {
+			deployErr = e.Error
+			continue
+		}
+		failures = append(failures, e.JobFailures...)
+	}
+	return deployErr, failures
+}
+
 func runGetDeployTimeout(args *docopt.Args, client controller.Client) error {
 	app, err := client.GetApp(mustApp())
 	if err != nil {
@@ -116,3 +249,19 @@ func runSetDeployBatchSize(args *docopt.Args, client controller.Client) error {
 	app.SetDeployBatchSize(batchSize)
 	return client.UpdateApp(app)
 }
+
+func runGetDeployStrategy(args *docopt.Args, client controller.Client) error {
+	app, err := client.GetApp(mustApp())
+	if err != nil {
+		return err
+	}
+	fmt.Println(app.Strategy)
+	return nil
+}
+
+func runSetDeployStrategy(args *docopt.Args, client controller.Client) error {
+	return client.UpdateApp(&ct.App{
+		ID:       mustApp(),
+		Strategy: args.String["<strategy>"],
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	ct "github.com/flynn/flynn/controller/types"
+)
+
+func TestDeploymentFailures(t *testing.T) {
+	status := int32(1)
+	web := &ct.DeploymentJobFailure{JobID: "host0-web1", JobType: "web", HostID: "host0", ExitStatus: &status}
+	worker := &ct.DeploymentJobFailure{JobID: "host1-worker1", JobType: "worker", HostID: "host1", OOMKilled: true}
+
+	// events are listed newest first
+	var events []*ct.Event
+	for _, e := range []ct.DeploymentEvent{
+		{Status: "failed", Error: "worker job failed to start", JobFailures: []*ct.DeploymentJobFailure{web, worker}},
+		{JobType: "worker", JobState: ct.JobStateDown, JobFailures: []*ct.DeploymentJobFailure{worker}},
+		{JobType: "web", JobState: ct.JobStateUp},
+		{JobType: "web", JobState: ct.JobStateDown, JobFailures: []*ct.DeploymentJobFailure{web}},
+		{Status: "running"},
+	} {
+		data, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, &ct.Event{ObjectType: ct.EventTypeDeployment, Data: data})
+	}
+
+	deployErr, failures := deploymentFailures(events)
+	if deployErr != "worker job failed to start" {
+		t.Fatalf("unexpected deployment error %q", deployErr)
+	}
+	expected := []string{
+		"web job host0-web1 on host host0: exited with status 1",
+		"worker job host1-worker1 on host host1: killed for exceeding its memory limit",
+	}
+	if len(failures) != len(expected) {
+		t.Fatalf("expected %d failures, got %d", len(expected), len(failures))
+	}
+	for i, s := range expected {
+		if got := failures[i].String(); got != s {
+			t.Fatalf("expected failure %q, got %q", s, got)
+		}
+	}
+
+	// a running deployment has no error but lists the failures so far
+	deployErr, failures = deploymentFailures(events[2:])
+	if deployErr != "" || len(failures) != 1 || failures[0].JobID != "host0-web1" {
+		t.Fatalf("unexpected error %q and failures %v", deployErr, failures)
+	}
+}
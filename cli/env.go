@@ -18,6 +18,7 @@ usage: flynn env [-t <proc>]
        flynn env set [-t <proc>] <var>=<val>...
        flynn env unset [-t <proc>] <var>...
        flynn env get [-t <proc>] <var>
+       flynn env diff [-t <proc>] <other>
 
 Manage app environment variables.
 
@@ -30,6 +31,9 @@ Commands:
 	set    sets value of one or more env variables
 	unset  deletes one or more variables
 	get    returns the value of variable
+	diff   compares the env with another app or release, for example to
+	       check staging and production match before promoting a release.
+	       Values of variables which look like secrets are redacted.
 
 Examples:
 
@@ -45,6 +49,13 @@ Examples:
 
 	$ flynn env unset FOO
 	Created release b1bbd9bc76d6436ea2fd245300bce72e.
+
+	$ flynn -a myapp-staging env diff myapp
+	+ ANALYTICS_ID=UA-1234
+	~ DATABASE_URL=[redacted] -> [redacted]
+	- DEBUG=true
+	~ LOG_LEVEL=debug -> info
+	~ PORT=8080 -> 8081 (web)
 `)
 }
 
@@ -59,6 +70,8 @@ func runEnv(args *docopt.Args, client controller.Client) error {
 		return runEnvUnset(args, client)
 	} else if args.Bool["get"] {
 		return runEnvGet(args, client)
+	} else if args.Bool["diff"] {
+		return runEnvDiff(args, client)
 	}
 
 	release, err := client.GetAppRelease(mustApp())
@@ -149,6 +162,41 @@ func runEnvGet(args *docopt.Args, client controller.Client) error {
 	return fmt.Errorf("var %q not found in release %q", arg, release.ID)
 }
 
+func runEnvDiff(args *docopt.Args, client controller.Client) error {
+	diff, err := client.GetAppEnvDiff(mustApp(), args.String["<other>"])
+	if err == controller.ErrNotFound {
+		return fmt.Errorf("no app or release %q found", args.String["<other>"])
+	}
+	if err != nil {
+		return err
+	}
+
+	var n int
+	for _, c := range diff.Changes {
+		if envProc != "" && c.ProcessType != "" && c.ProcessType != envProc {
+			continue
+		}
+		var line string
+		switch c.Type {
+		case ct.EnvChangeAdded:
+			line = fmt.Sprintf("+ %s=%s", c.Key, c.To)
+		case ct.EnvChangeRemoved:
+			line = fmt.Sprintf("- %s=%s", c.Key, c.From)
+		case ct.EnvChangeChanged:
+			line = fmt.Sprintf("~ %s=%s -> %s", c.Key, c.From, c.To)
+		}
+		if c.ProcessType != "" {
+			line += fmt.Sprintf(" (%s)", c.ProcessType)
+		}
+		fmt.Println(line)
+		n++
+	}
+	if n == 0 {
+		log.Printf("No differences between release %s and release %s.", diff.FromReleaseID, diff.ToReleaseID)
+	}
+	return nil
+}
+
 func setEnv(client controller.Client, proc string, env map[string]*string) (string, error) {
 	app, err := client.GetApp(mustApp())
 	if err != nil {
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/go-docopt"
+)
+
+func init() {
+	register("event-sink", runEventSink, `
+usage: flynn event-sink
+       flynn event-sink add [--slack] [-t <type>...] <url>
+       flynn event-sink remove <id>
+
+Manage endpoints which cluster-wide events are forwarded to.
+
+Deployment outcomes, completed scale changes, route changes and managed
+certificate failures for all apps are posted to each event sink as they happen.
+
+Options:
+    --slack                send a Slack-compatible message rather than JSON
+    -t, --type=<type>      event type to forward (one of deployment,
+                           scale_request, route, route_deletion or
+                           managed_certificate), defaults to all of them
+
+Commands:
+    With no arguments, shows a list of event sinks.
+
+    add     adds an event sink which forwards events to an HTTPS URL
+    remove  removes an event sink
+
+Examples:
+
+    $ flynn event-sink add --slack https://hooks.slack.com/services/T000/B000/XXXX
+    Created event sink 8c5b4ba2-4b0c-4bd6-a4d3-7c1ea1e44aa2
+
+    $ flynn event-sink add -t deployment https://ci.example.com/flynn-events
+    Created event sink 1d2c3c34-bd1e-4c5a-9d3b-57e1a1c9b8d1
+`)
+}
+
+func runEventSink(args *docopt.Args, client controller.Client) error {
+	if args.Bool["add"] {
+		return runEventSinkAdd(args, client)
+	} else if args.Bool["remove"] {
+		return runEventSinkRemove(args, client)
+	}
+	return runEventSinkList(client)
+}
+
+func runEventSinkList(client controller.Client) error {
+	sinks, err := client.ListEventSinks()
+	if err != nil {
+		return err
+	}
+
+	w := tabWriter()
+	defer w.Flush()
+
+	listRec(w, "ID", "URL", "FORMAT", "EVENT TYPES", "LAST ERROR")
+	for _, s := range sinks {
+		types := make([]string, len(s.EventTypes))
+		for i, typ := range s.EventTypes {
+			types[i] = string(typ)
+		}
+		listRec(w, s.ID, s.URL, s.Format, strings.Join(types, ","), s.LastError)
+	}
+	return nil
+}
+
+func runEventSinkAdd(args *docopt.Args, client controller.Client) error {
+	sink := &ct.EventSink{
+		URL:    args.String["<url>"],
+		Format: ct.EventSinkFormatJSON,
+	}
+	if args.Bool["--slack"] {
+		sink.Format = ct.EventSinkFormatSlack
+	}
+	for _, typ := range args.All["--type"].([]string) {
+		sink.EventTypes = append(sink.EventTypes, ct.EventType(typ))
+	}
+	if err := client.CreateEventSink(sink); err != nil {
+		return err
+	}
+	fmt.Printf("Created event sink %s\n", sink.ID)
+	return nil
+}
+
+func runEventSinkRemove(args *docopt.Args, client controller.Client) error {
+	id := args.String["<id>"]
+	if _, err := client.DeleteEventSink(id); err != nil {
+		return err
+	}
+	fmt.Printf("Deleted event sink %s\n", id)
+	return nil
+}
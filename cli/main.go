@@ -68,6 +68,7 @@ Commands:
 	export      export app data
 	import      create app from exported data
 	version     show flynn version
+	api-docs    print the controller OpenAPI document
 
 See 'flynn help <command>' for more information on a specific command.
 `[1:]
@@ -213,6 +214,21 @@ func runCommand(name string, args []string) (err error) {
 			shutdown.Fatal(err)
 		}
 
+		err = f(parsedArgs, client)
+		if !isPinFailure(err) || clusterConf == nil || clusterConf.TLSPin == "" {
+			return err
+		}
+		// the controller's certificate has changed, retry the command
+		// if the pin can be cleared or refreshed
+		if refreshed, refreshErr := refreshPin(clusterConf); refreshErr != nil {
+			return refreshErr
+		} else if !refreshed {
+			return err
+		}
+		client, err = getClusterClient()
+		if err != nil {
+			return err
+		}
 		return f(parsedArgs, client)
 	case func(*docopt.Args) error:
 		return f(parsedArgs)
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	cfg "github.com/flynn/flynn/cli/config"
+	"github.com/flynn/flynn/pkg/pinned"
+	"github.com/flynn/flynn/pkg/term"
+)
+
+// pinRoots are the CAs a controller certificate must chain to for it to no
+// longer need pinning, the system roots if nil. It is a variable so it can be
+// replaced in tests.
+var pinRoots *x509.CertPool
+
+// stdinIsTerminal reports whether the user can be prompted before the TLS
+// pin is cleared, it is a variable so it can be replaced in tests.
+var stdinIsTerminal = func() bool { return term.IsTerminal(os.Stdin.Fd()) }
+
+// isPinFailure reports whether err was caused by the controller's TLS
+// certificate not matching the cluster's TLS pin.
+func isPinFailure(err error) bool {
+	return err != nil && strings.Contains(err.Error(), pinned.ErrPinFailure.Error())
+}
+
+// tlsPin returns the TLS pin of a certificate, the base64 encoded SHA256 of
+// its DER bytes.
+func tlsPin(cert *x509.Certificate) string {
+	h := sha256.Sum256(cert.Raw)
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// controllerCertificates returns the hostname of the cluster's controller and
+// the certificate chain it presents, without verifying it.
+func controllerCertificates(cluster *cfg.Cluster) (string, []*x509.Certificate, error) {
+	u, err := url.Parse(cluster.ControllerURL)
+	if err != nil {
+		return "", nil, fmt.Errorf("Error parsing controller URL: %s", err)
+	}
+	host := u.Host
+	if !strings.Contains(host, ":") {
+		host = host + ":443"
+	}
+	conn, err := tls.Dial("tcp", host, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return "", nil, fmt.Errorf("Error connecting to controller: %s", err)
+	}
+	defer conn.Close()
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", nil, errors.New("No certificates returned by controller")
+	}
+	hostname, _, _ := net.SplitHostPort(host)
+	return hostname, certs, nil
+}
+
+// verifyPublicCertificate checks that a certificate chain is valid for the
+// hostname and chains to a CA trusted by the system.
+func verifyPublicCertificate(hostname string, certs []*x509.Certificate) error {
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		DNSName:       hostname,
+		Roots:         pinRoots,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// refreshPin handles the controller presenting a certificate which does not
+// match the cluster's TLS pin, as happens once a managed certificate is
+// issued for the controller. A certificate signed by a trusted CA does not
+// need pinning so the pin is cleared (after confirmation when running
+// interactively), otherwise the pin is only updated to the new certificate if
+// the cluster was added with --insecure-pin-refresh. It returns whether the
+// pin was changed so that the failed command can be retried.
+func refreshPin(cluster *cfg.Cluster) (bool, error) {
+	hostname, certs, err := controllerCertificates(cluster)
+	if err != nil {
+		return false, err
+	}
+	leaf := certs[0]
+	newPin := tlsPin(leaf)
+	if newPin == cluster.TLSPin {
+		return false, nil
+	}
+
+	if verifyErr := verifyPublicCertificate(hostname, certs); verifyErr == nil {
+		log.Printf("The TLS certificate of cluster %q has changed and is signed by a trusted CA (%s), so it no longer needs to be pinned.", cluster.Name, leaf.Issuer.CommonName)
+		if stdinIsTerminal() && !promptYesNo("Clear the TLS pin and use standard TLS verification?") {
+			return false, nil
+		}
+		cluster.TLSPin = ""
+		log.Printf("Cleared TLS pin for cluster %q.", cluster.Name)
+	} else if cluster.InsecurePinRefresh {
+		log.Printf("WARNING: The TLS certificate of cluster %q has changed and is not signed by a trusted CA (%s).", cluster.Name, verifyErr)
+		log.Printf("Updating the TLS pin to %s as the cluster was added with --insecure-pin-refresh.", newPin)
+		cluster.TLSPin = newPin
+	} else {
+		return false, fmt.Errorf("The TLS certificate of cluster %q does not match its TLS pin and is not signed by a trusted CA (%s).\nIf the certificate was replaced intentionally, run 'flynn cluster update-pin' to pin the new certificate.", cluster.Name, verifyErr)
+	}
+
+	if err := config.SaveTo(configPath()); err != nil {
+		return false, fmt.Errorf("Error saving config: %s", err)
+	}
+	return true, nil
+}
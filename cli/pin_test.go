@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cfg "github.com/flynn/flynn/cli/config"
+)
+
+// setupPinTest starts a TLS server standing in for the controller and
+// returns a cluster pinned to a different certificate, saved in a config
+// file in a temporary directory.
+func setupPinTest(t *testing.T, trusted bool) (*httptest.Server, *cfg.Cluster, string) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(srv.Close)
+
+	roots := x509.NewCertPool()
+	if trusted {
+		roots.AddCert(srv.Certificate())
+	}
+	prevRoots, prevTerminal, prevConfig := pinRoots, stdinIsTerminal, config
+	pinRoots = roots
+	stdinIsTerminal = func() bool { return false }
+	t.Cleanup(func() { pinRoots, stdinIsTerminal, config = prevRoots, prevTerminal, prevConfig })
+
+	path := filepath.Join(t.TempDir(), "flynnrc")
+	t.Setenv("FLYNNRC", path)
+	cluster := &cfg.Cluster{
+		Name:          "default",
+		ControllerURL: srv.URL,
+		Key:           "key",
+		TLSPin:        "old-pin",
+	}
+	config = &cfg.Config{Clusters: []*cfg.Cluster{cluster}}
+	if err := config.SaveTo(path); err != nil {
+		t.Fatal(err)
+	}
+	return srv, cluster, path
+}
+
+// savedPin returns the TLS pin of the cluster in the saved config.
+func savedPin(t *testing.T, path string) string {
+	c, err := cfg.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.Clusters[0].TLSPin
+}
+
+func TestRefreshPinTrustedCA(t *testing.T) {
+	_, cluster, path := setupPinTest(t, true)
+
+	// a certificate signed by a trusted CA does not need pinning
+	refreshed, err := refreshPin(cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !refreshed {
+		t.Fatal("expected the pin to be refreshed")
+	}
+	if cluster.TLSPin != "" || savedPin(t, path) != "" {
+		t.Fatalf("expected the pin to be cleared, got %q", cluster.TLSPin)
+	}
+}
+
+func TestRefreshPinInsecure(t *testing.T) {
+	srv, cluster, path := setupPinTest(t, false)
+	cluster.InsecurePinRefresh = true
+
+	// the pin is rewritten to the new certificate
+	refreshed, err := refreshPin(cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !refreshed {
+		t.Fatal("expected the pin to be refreshed")
+	}
+	expected := tlsPin(srv.Certificate())
+	if cluster.TLSPin != expected || savedPin(t, path) != expected {
+		t.Fatalf("expected the pin to be updated to %q, got %q", expected, cluster.TLSPin)
+	}
+
+	// once the pin matches, nothing is changed
+	refreshed, err = refreshPin(cluster)
+	if err != nil || refreshed {
+		t.Fatalf("expected a matching pin not to be refreshed, got %v, %v", refreshed, err)
+	}
+}
+
+func TestRefreshPinRefused(t *testing.T) {
+	_, cluster, path := setupPinTest(t, false)
+
+	// an untrusted certificate is not pinned without --insecure-pin-refresh
+	refreshed, err := refreshPin(cluster)
+	if err == nil || !strings.Contains(err.Error(), "does not match its TLS pin") {
+		t.Fatalf("expected the refresh to be refused, got %v", err)
+	}
+	if refreshed {
+		t.Fatal("expected the pin not to be refreshed")
+	}
+	if cluster.TLSPin != "old-pin" || savedPin(t, path) != "old-pin" {
+		t.Fatalf("expected the pin to be unchanged, got %q", cluster.TLSPin)
+	}
+}
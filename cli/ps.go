@@ -14,7 +14,8 @@ import (
 
 func init() {
 	register("ps", runPs, `
-usage: flynn ps [-a] [-c] [-q] [-t <type>]
+usage: flynn ps [-a] [-c] [-q] [-f] [-t <type>]
+       flynn ps --history [--since=<time>] [--until=<time>] [-t <type>]
 
 List flynn jobs.
 
@@ -22,7 +23,11 @@ Options:
   -a, --all           Show all jobs (default is running and pending)
   -c, --command       Show command
   -q, --quiet         Only display IDs
+  -f, --failed        Only show jobs which crashed or failed to start, with their final output
   -t, --type=<type>   Show jobs of type <type>
+  --history           Show which hosts jobs ran on, and when, between --since and --until
+  --since=<time>      Start of the history, as an RFC3339 time or a duration ago such as 2h [default: 24h]
+  --until=<time>      End of the history, as an RFC3339 time or a duration ago [default: 0s]
 
 Example:
 
@@ -48,26 +53,49 @@ Example:
        $ flynn ps --all --type=run
        ID                                          TYPE  STATE  CREATED             RELEASE
        host0-129b821f-3195-4b3b-b04b-669196cfbb03  run   down   5 seconds ago       cf39a906-38d1-4393-a6b1-8ad2befe842
+
+       $ flynn ps --failed
+       ID                                          TYPE  STATE  CREATED        RELEASE                               EXIT
+       host0-6a1e4b1c-2f0d-4cbb-9a5e-0b7a8d6f1f3e  web   down   2 minutes ago  cf39a906-38d1-4393-a6b1-8ad2befe842  1
+
+       === host0-6a1e4b1c-2f0d-4cbb-9a5e-0b7a8d6f1f3e (web)
+       Error: Cannot find module 'express'
+
+       $ flynn ps --history --since=2h
+       ID                                          TYPE  HOST   STARTED                    STOPPED                    EXIT
+       host1-b2c4e1f0-8d3a-4c5e-9f6b-1a2b3c4d5e6f  web   host1  2024-03-05T14:02:11Z       -                          -
+       host0-52aedfbf-e613-40f2-941a-d832d10fc400  web   host0  2024-03-05T13:15:40Z       2024-03-05T14:01:58Z       137
 `)
 }
 
 func runPs(args *docopt.Args, client controller.Client) error {
+	if args.Bool["--history"] {
+		return runPsHistory(args, client)
+	}
 	jobs, err := client.JobList(mustApp())
 	if err != nil {
 		return err
 	}
 	sort.Sort(sortJobs(jobs))
+	failed := args.Bool["--failed"]
 	w := tabWriter()
-	defer w.Flush()
 	if !args.Bool["--quiet"] {
 		headers := []interface{}{"ID", "TYPE", "STATE", "CREATED", "RELEASE"}
 		if args.Bool["--command"] {
 			headers = append(headers, "COMMAND")
 		}
+		if failed {
+			headers = append(headers, "EXIT")
+		}
 		listRec(w, headers...)
 	}
+	var failedJobs []*ct.Job
 	for _, j := range jobs {
-		if !args.Bool["--all"] && j.State != ct.JobStateUp && j.State != ct.JobStatePending {
+		if failed {
+			if !jobFailed(j) {
+				continue
+			}
+		} else if !args.Bool["--all"] && j.State != ct.JobStateUp && j.State != ct.JobStatePending {
 			continue
 		}
 		if j.Type == "" {
@@ -92,11 +120,106 @@ func runPs(args *docopt.Args, client controller.Client) error {
 		if args.Bool["--command"] {
 			fields = append(fields, strings.Join(j.Args, " "))
 		}
+		if failed {
+			fields = append(fields, jobExit(j))
+			failedJobs = append(failedJobs, j)
+		}
 		listRec(w, fields...)
 	}
+	w.Flush()
+
+	for _, j := range failedJobs {
+		if len(j.LogTail) == 0 && j.HostError == nil {
+			continue
+		}
+		id := j.ID
+		if id == "" {
+			id = j.UUID
+		}
+		fmt.Printf("\n=== %s (%s)\n", id, j.Type)
+		if j.HostError != nil {
+			fmt.Println(*j.HostError)
+		}
+		for _, line := range j.LogTail {
+			fmt.Println(line)
+		}
+	}
+	return nil
+}
+
+func runPsHistory(args *docopt.Args, client controller.Client) error {
+	now := time.Now()
+	since, err := parseTimeArg(args.String["--since"], now)
+	if err != nil {
+		return fmt.Errorf("invalid --since: %s", err)
+	}
+	until, err := parseTimeArg(args.String["--until"], now)
+	if err != nil {
+		return fmt.Errorf("invalid --until: %s", err)
+	}
+	placements, err := client.JobPlacements(mustApp(), since, until)
+	if err != nil {
+		return err
+	}
+	w := tabWriter()
+	defer w.Flush()
+	listRec(w, "ID", "TYPE", "HOST", "STARTED", "STOPPED", "EXIT")
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return "-"
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	for _, p := range placements {
+		typ := p.Type
+		if typ == "" {
+			typ = "run"
+		}
+		if args.String["<type>"] != "" && typ != args.String["<type>"] {
+			continue
+		}
+		id := p.JobID
+		if id == "" {
+			id = p.UUID
+		}
+		exit := "-"
+		if p.ExitStatus != nil {
+			exit = fmt.Sprint(*p.ExitStatus)
+		} else if p.HostError != nil {
+			exit = "failed"
+		}
+		listRec(w, id, typ, p.HostID, formatTime(p.StartedAt), formatTime(p.StoppedAt), exit)
+	}
 	return nil
 }
 
+// parseTimeArg parses either an RFC3339 time or a duration before now
+func parseTimeArg(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// jobFailed returns whether the job crashed or failed to start
+func jobFailed(j *ct.Job) bool {
+	if j.State == ct.JobStateUp || j.State == ct.JobStatePending || j.State == ct.JobStateStarting {
+		return false
+	}
+	return j.HostError != nil || j.ExitStatus != nil && *j.ExitStatus != 0
+}
+
+// jobExit returns the exit status of a failed job for display
+func jobExit(j *ct.Job) string {
+	if j.ExitStatus != nil {
+		return fmt.Sprint(*j.ExitStatus)
+	}
+	if j.HostError != nil {
+		return "failed"
+	}
+	return ""
+}
+
 // sortJobs sorts Jobs in chronological order based on their CreatedAt time
 type sortJobs []*ct.Job
 
@@ -2,27 +2,32 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
 	controller "github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
 	router "github.com/flynn/flynn/router/types"
 	"github.com/flynn/go-docopt"
+	"gopkg.in/yaml.v2"
 )
 
 func init() {
 	register("route", runRoute, `
 usage: flynn route
-       flynn route add http [-s <service>] [-p <port>] [-c <tls-cert> -k <tls-key>] [--auto-tls] [--sticky] [--leader] [--no-leader] [--no-drain-backends] [--disable-keep-alives] <domain>
-       flynn route add tcp [-s <service>] [-p <port>] [--leader] [--no-drain-backends]
-       flynn route update <id> [-s <service>] [-c <tls-cert> -k <tls-key>] [--auto-tls] [--no-auto-tls] [--sticky] [--no-sticky] [--leader] [--no-leader] [--disable-keep-alives] [--enable-keep-alives]
+       flynn route add http [-s <service>] [-p <port>] [-c <tls-cert> -k <tls-key>] [--auto-tls] [--redirect-https] [--sticky] [--leader] [--no-leader] [--no-drain-backends] [--disable-keep-alives] [--http2] [--tls-min-version=<version>] [--tls-ciphers=<ciphers>] [--tls-curves=<curves>] [--alpn=<protocols>] [--hsts-max-age=<seconds>] [--hsts-include-subdomains] [--client-ca=<file>] [--dry-run] <domain>
+       flynn route add tcp [-s <service>] [-p <port>] [--leader] [--no-drain-backends] [--dry-run]
+       flynn route update <id> [-s <service>] [-c <tls-cert> -k <tls-key>] [--auto-tls] [--no-auto-tls] [--redirect-https] [--no-redirect-https] [--sticky] [--no-sticky] [--leader] [--no-leader] [--disable-keep-alives] [--enable-keep-alives] [--http2] [--no-http2] [--tls-min-version=<version>] [--tls-ciphers=<ciphers>] [--tls-curves=<curves>] [--alpn=<protocols>] [--hsts-max-age=<seconds>] [--hsts-include-subdomains] [--no-tls-policy] [--client-ca=<file>] [--no-client-ca] [--split=<split>...] [--no-splits] [--dry-run]
        flynn route remove <id>
+       flynn route apply -f <file> [--dry-run]
 
 Manage routes for application.
 
@@ -32,6 +37,8 @@ Options:
 	-k, --tls-key=<tls-key>    path to PEM encoded private key for TLS, - for stdin (http only)
 	--auto-tls                 automatically provision TLS certificate via Let's Encrypt (http only)
 	--no-auto-tls              disable automatic TLS certificate provisioning (update http only)
+	--redirect-https           redirect plaintext requests to HTTPS once the route has a certificate (http only)
+	--no-redirect-https        stop redirecting plaintext requests to HTTPS (update http only)
 	--sticky                   enable cookie-based sticky routing (http only)
 	--no-sticky                disable cookie-based sticky routing (update http only)
 	--leader                   enable leader-only routing mode
@@ -40,12 +47,57 @@ Options:
 	--no-drain-backends        don't wait for in-flight requests to complete before stopping backends
 	--disable-keep-alives      disable keep-alives between the router and backends for the given route
 	--enable-keep-alives       enable keep-alives between the router and backends for the given route (default for new routes)
+	--http2                    proxy requests to backends using HTTP/2 without TLS (h2c), for gRPC services (http only)
+	--no-http2                 proxy requests to backends using HTTP/1.1 (update http only)
+	--tls-min-version=<version>  minimum TLS version accepted for the route, one of 1.0, 1.1, 1.2 or 1.3 (http only)
+	--tls-ciphers=<ciphers>      comma separated list of cipher suites accepted for the route (http only)
+	--tls-curves=<curves>        comma separated list of elliptic curves accepted for the route, in order of preference (http only)
+	--alpn=<protocols>           comma separated list of protocols offered with ALPN for the route, h2 and/or http/1.1 (http only)
+	--hsts-max-age=<seconds>     send a Strict-Transport-Security header with this max-age in HTTPS responses for the route, 0 to disable (http only)
+	--hsts-include-subdomains    apply the Strict-Transport-Security header to subdomains of the route's domain (http only)
+	--no-tls-policy              use the router's default TLS policy for the route (update http only)
+	--client-ca=<file>           path to PEM encoded CA certificates which clients must present a certificate signed by (http only)
+	--no-client-ca               stop requiring client certificates (update http only)
+	--split=<split>              send a percentage of requests to another service, given as service=weight, replacing existing splits (update http only)
+	--no-splits                  send all requests to the route's service (update http only)
+	-f, --file=<file>            path to a YAML or JSON file listing all of the app's routes, - for stdin
+	--dry-run                    show the impact of adding or updating the route without changing it
 
 Commands:
 	With no arguments, shows a list of routes.
 
 	add     adds a route to an app
 	remove  removes a route
+	apply   makes the app's routes match those in a file, adding, updating
+	        and removing routes as needed
+
+	The file given to apply has a list of routes, for example:
+
+	    routes:
+	    - type: http
+	      domain: example.com
+	      auto_tls: true
+	      tls_policy:
+	        min_version: "1.2"
+	        hsts_max_age: 31536000
+	    - type: http
+	      domain: example.com
+	      path: /api/
+	      service: myapp-api-web
+	      tls_cert: certs/example.com.crt
+	      tls_key: certs/example.com.key
+	      splits:
+	      - service: myapp-api-canary-web
+	        weight: 10
+	    - type: tcp
+	      port: 2222
+	      leader: true
+
+	HTTP routes are identified by their domain, port and path, and TCP routes
+	by their port, which must be set. Other keys are service, redirect_https,
+	sticky, drain_backends, disable_keep_alives, http2, client_ca and splits. Paths to certificates,
+	keys and client CAs are relative to the file. Routes of the app which are
+	not in the file are removed.
 
 Examples:
 
@@ -53,11 +105,27 @@ Examples:
 
 	$ flynn route add http --auto-tls example.com
 
+	$ flynn route add http --auto-tls --redirect-https example.com
+
 	$ flynn route add http example.com/path/
 
+	$ flynn route add http --tls-min-version=1.2 --tls-curves=X25519,P256 example.com
+
+	$ flynn route add http --hsts-max-age=31536000 --hsts-include-subdomains example.com
+
+	$ flynn route add http --client-ca ca.pem api.example.com
+
+	$ flynn route add http --auto-tls --http2 -s myapp-grpc grpc.example.com
+
 	$ flynn route add tcp
 
 	$ flynn route add tcp --leader
+
+	$ flynn route update --dry-run -s myapp-canary-web http/1ba949d1-654e-4b1f-9f9e-3f33ef2fd2a6
+
+	$ flynn route update --split myapp-canary-web=10 http/1ba949d1-654e-4b1f-9f9e-3f33ef2fd2a6
+
+	$ flynn route apply --dry-run -f routes.yaml
 `)
 }
 
@@ -83,6 +151,8 @@ func runRoute(args *docopt.Args, client controller.Client) error {
 		}
 	} else if args.Bool["remove"] {
 		return runRouteRemove(args, client)
+	} else if args.Bool["apply"] {
+		return runRouteApply(args, client)
 	}
 
 	routes, err := client.AppRouteList(mustApp())
@@ -151,6 +221,9 @@ func runRouteAddTCP(args *docopt.Args, client controller.Client) error {
 	}
 
 	r := hr.ToRoute()
+	if args.Bool["--dry-run"] {
+		return runRouteDryRun(client.CreateRouteDryRun(mustApp(), r))
+	}
 	if err := client.CreateRoute(mustApp(), r); err != nil {
 		return err
 	}
@@ -201,6 +274,11 @@ func runRouteAddHTTP(args *docopt.Args, client controller.Client) error {
 		return fmt.Errorf("Failed to parse %s as URL", args.String["<domain>"])
 	}
 
+	tlsPolicy, err := parseTLSPolicy(args, nil)
+	if err != nil {
+		return err
+	}
+
 	hr := &router.HTTPRoute{
 		Service:           service,
 		Domain:            u.Host,
@@ -212,6 +290,14 @@ func runRouteAddHTTP(args *docopt.Args, client controller.Client) error {
 		Path:              u.Path,
 		DrainBackends:     !args.Bool["--no-drain-backends"],
 		DisableKeepAlives: args.Bool["--disable-keep-alives"],
+		HTTP2:             args.Bool["--http2"],
+		TLSPolicy:         tlsPolicy,
+		RedirectHTTPS:     args.Bool["--redirect-https"],
+	}
+	if path := args.String["--client-ca"]; path != "" {
+		if hr.ClientCA, err = readClientCA(path); err != nil {
+			return err
+		}
 	}
 
 	// Set managed certificate domain if auto-TLS is enabled
@@ -220,6 +306,9 @@ func runRouteAddHTTP(args *docopt.Args, client controller.Client) error {
 	}
 
 	route := hr.ToRoute()
+	if args.Bool["--dry-run"] {
+		return runRouteDryRun(client.CreateRouteDryRun(mustApp(), route))
+	}
 	if err := client.CreateRoute(mustApp(), route); err != nil {
 		return err
 	}
@@ -248,6 +337,9 @@ func runRouteUpdateTCP(args *docopt.Args, client controller.Client) error {
 		route.Leader = false
 	}
 
+	if args.Bool["--dry-run"] {
+		return runRouteDryRun(client.UpdateRouteDryRun(appName, id, route))
+	}
 	if err := client.UpdateRoute(appName, id, route); err != nil {
 		return err
 	}
@@ -312,6 +404,43 @@ func runRouteUpdateHTTP(args *docopt.Args, client controller.Client) error {
 		route.DisableKeepAlives = false
 	}
 
+	if args.Bool["--http2"] {
+		route.HTTP2 = true
+	} else if args.Bool["--no-http2"] {
+		route.HTTP2 = false
+	}
+
+	if args.Bool["--redirect-https"] {
+		route.RedirectHTTPS = true
+	} else if args.Bool["--no-redirect-https"] {
+		route.RedirectHTTPS = false
+	}
+
+	if args.Bool["--no-tls-policy"] {
+		route.TLSPolicy = nil
+	} else if route.TLSPolicy, err = parseTLSPolicy(args, route.TLSPolicy); err != nil {
+		return err
+	}
+
+	if args.Bool["--no-client-ca"] {
+		route.ClientCA = ""
+	} else if path := args.String["--client-ca"]; path != "" {
+		if route.ClientCA, err = readClientCA(path); err != nil {
+			return err
+		}
+	}
+
+	if args.Bool["--no-splits"] {
+		route.Splits = nil
+	} else if splits := args.All["--split"].([]string); len(splits) > 0 {
+		if route.Splits, err = parseRouteSplits(splits); err != nil {
+			return err
+		}
+	}
+
+	if args.Bool["--dry-run"] {
+		return runRouteDryRun(client.UpdateRouteDryRun(appName, id, route))
+	}
 	if err := client.UpdateRoute(appName, id, route); err != nil {
 		return err
 	}
@@ -319,6 +448,120 @@ func runRouteUpdateHTTP(args *docopt.Args, client controller.Client) error {
 	return nil
 }
 
+// parseRouteSplits parses splits given as service=weight.
+func parseRouteSplits(args []string) ([]*router.RouteSplit, error) {
+	splits := make([]*router.RouteSplit, len(args))
+	for i, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid split %q, expected service=weight", arg)
+		}
+		weight, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid split weight %q", parts[1])
+		}
+		splits[i] = &router.RouteSplit{Service: parts[0], Weight: weight}
+	}
+	if err := router.ValidateRouteSplits(splits); err != nil {
+		return nil, err
+	}
+	return splits, nil
+}
+
+// runRouteDryRun prints the impact of adding or updating a route.
+func runRouteDryRun(res *ct.RouteDryRun, err error) error {
+	if err != nil {
+		return err
+	}
+	route := res.Route
+	if res.Previous == nil {
+		fmt.Printf("would add %s\n", routeDescription(route))
+	} else if len(res.Changes) == 0 {
+		fmt.Printf("%s would not change\n", routeDescription(route))
+	} else {
+		fmt.Printf("would update %s: %s\n", routeDescription(route), strings.Join(res.Changes, ", "))
+	}
+	if res.DrainsConnections {
+		fmt.Printf("existing connections would be drained: %s\n", res.DrainReason)
+	}
+	for _, conflict := range res.Conflicts {
+		switch conflict.Type {
+		case ct.RouteConflictDuplicate:
+			fmt.Printf("conflicts with %s of app %s\n", routeDescription(conflict.Route), conflict.AppID)
+		case ct.RouteConflictDomain:
+			fmt.Printf("shares its domain with %s of app %s\n", routeDescription(conflict.Route), conflict.AppID)
+		}
+	}
+	if len(res.Routers) > 0 {
+		fmt.Printf("routers which would change: %s\n", strings.Join(res.Routers, ", "))
+	}
+	fmt.Println("dry run, nothing was changed")
+	return nil
+}
+
+func routeDescription(r *router.Route) string {
+	if r.Type == "http" {
+		return fmt.Sprintf("%s (%s%s)", r.FormattedID(), r.Domain, r.Path)
+	}
+	return fmt.Sprintf("%s (port %d)", r.FormattedID(), r.Port)
+}
+
+// parseTLSPolicy returns the given policy with the fields set by the
+// --tls-*, --alpn and --hsts-* flags overridden, or nil if there is no
+// policy.
+func parseTLSPolicy(args *docopt.Args, policy *router.TLSPolicy) (*router.TLSPolicy, error) {
+	minVersion := args.String["--tls-min-version"]
+	ciphers := args.String["--tls-ciphers"]
+	curves := args.String["--tls-curves"]
+	alpn := args.String["--alpn"]
+	hstsMaxAge := args.String["--hsts-max-age"]
+	hstsIncludeSubdomains := args.Bool["--hsts-include-subdomains"]
+	if minVersion == "" && ciphers == "" && curves == "" && alpn == "" && hstsMaxAge == "" && !hstsIncludeSubdomains {
+		return policy, nil
+	}
+	if policy == nil {
+		policy = &router.TLSPolicy{}
+	}
+	if minVersion != "" {
+		policy.MinVersion = minVersion
+	}
+	if ciphers != "" {
+		policy.CipherSuites = router.ParseTLSPolicyList(ciphers)
+	}
+	if curves != "" {
+		policy.CurvePreferences = router.ParseTLSPolicyList(curves)
+	}
+	if alpn != "" {
+		policy.ALPNProtocols = router.ParseTLSPolicyList(alpn)
+	}
+	if hstsMaxAge != "" {
+		maxAge, err := strconv.Atoi(hstsMaxAge)
+		if err != nil || maxAge < 0 {
+			return nil, fmt.Errorf("invalid --hsts-max-age %q, must be a number of seconds", hstsMaxAge)
+		}
+		policy.HSTSMaxAge = maxAge
+		if maxAge == 0 {
+			policy.HSTSIncludeSubdomains = false
+		}
+	}
+	if hstsIncludeSubdomains {
+		policy.HSTSIncludeSubdomains = true
+	}
+	return policy, nil
+}
+
+// readClientCA reads a PEM encoded client CA bundle from path.
+func readClientCA(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read client CA: %s", err)
+	}
+	if _, err := router.ParseClientCA(string(data)); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
 func parseTLSCert(args *docopt.Args) (string, string, error) {
 	tlsCertPath := args.String["--tls-cert"]
 	tlsKeyPath := args.String["--tls-key"]
@@ -380,3 +623,308 @@ func runRouteRemove(args *docopt.Args, client controller.Client) error {
 	fmt.Printf("Route %s removed.\n", routeID)
 	return nil
 }
+
+// routeSpec is a route in the file given to 'flynn route apply'.
+type routeSpec struct {
+	Type              string               `json:"type"`
+	Service           string               `json:"service"`
+	Domain            string               `json:"domain"`
+	Path              string               `json:"path"`
+	Port              int                  `json:"port"`
+	AutoTLS           bool                 `json:"auto_tls"`
+	RedirectHTTPS     bool                 `json:"redirect_https"`
+	TLSCert           string               `json:"tls_cert"`
+	TLSKey            string               `json:"tls_key"`
+	Sticky            bool                 `json:"sticky"`
+	Leader            bool                 `json:"leader"`
+	DrainBackends     *bool                `json:"drain_backends"`
+	DisableKeepAlives bool                 `json:"disable_keep_alives"`
+	HTTP2             bool                 `json:"http2"`
+	TLSPolicy         *router.TLSPolicy    `json:"tls_policy"`
+	ClientCA          string               `json:"client_ca"`
+	Splits            []*router.RouteSplit `json:"splits"`
+}
+
+func runRouteApply(args *docopt.Args, client controller.Client) error {
+	appName := mustApp()
+	dryRun := args.Bool["--dry-run"]
+
+	routes, err := readRouteSpecs(args.String["--file"], appName)
+	if err != nil {
+		return err
+	}
+	for _, r := range routes {
+		if r.ManagedCertificateDomain == nil {
+			continue
+		}
+		acmeConfig, err := client.GetACMEConfig()
+		if err != nil {
+			return fmt.Errorf("error checking ACME configuration: %s", err)
+		}
+		if !acmeConfig.Enabled {
+			return fmt.Errorf("ACME/Let's Encrypt is not enabled for this cluster, but auto_tls is set for %s.\nRun 'flynn-host acme configure --email=<email> --agree-tos' and 'flynn-host acme enable' first.", routeKey(r))
+		}
+		break
+	}
+
+	existing, err := client.AppRouteList(appName)
+	if err != nil {
+		return err
+	}
+	current := make(map[string]*router.Route, len(existing))
+	for _, r := range existing {
+		current[routeKey(r)] = r
+	}
+
+	// add and update routes before removing any so that traffic moving
+	// to a new route is not interrupted
+	for _, r := range routes {
+		key := routeKey(r)
+		prev, ok := current[key]
+		if !ok {
+			if dryRun {
+				if _, err := client.CreateRouteDryRun(appName, r); err != nil {
+					return fmt.Errorf("error adding %s: %s", key, err)
+				}
+				fmt.Printf("would add %s\n", key)
+				continue
+			}
+			if err := client.CreateRoute(appName, r); err != nil {
+				return fmt.Errorf("error adding %s: %s", key, err)
+			}
+			fmt.Printf("added %s\n", routeDescription(r))
+			continue
+		}
+		delete(current, key)
+		r.ID = prev.ID
+		r.ParentRef = prev.ParentRef
+		res, err := client.UpdateRouteDryRun(appName, r.FormattedID(), r)
+		if err != nil {
+			return fmt.Errorf("error updating %s: %s", routeDescription(prev), err)
+		}
+		if len(res.Changes) == 0 {
+			fmt.Printf("%s is unchanged\n", routeDescription(prev))
+			continue
+		}
+		changes := strings.Join(res.Changes, ", ")
+		if dryRun {
+			fmt.Printf("would update %s: %s\n", routeDescription(prev), changes)
+			continue
+		}
+		if err := client.UpdateRoute(appName, r.FormattedID(), r); err != nil {
+			return fmt.Errorf("error updating %s: %s", routeDescription(prev), err)
+		}
+		fmt.Printf("updated %s: %s\n", routeDescription(prev), changes)
+	}
+
+	for _, r := range existing {
+		if _, ok := current[routeKey(r)]; !ok {
+			continue
+		}
+		if dryRun {
+			fmt.Printf("would remove %s\n", routeDescription(r))
+			continue
+		}
+		if err := client.DeleteRoute(appName, r.FormattedID()); err != nil {
+			return fmt.Errorf("error removing %s: %s", routeDescription(r), err)
+		}
+		fmt.Printf("removed %s\n", routeDescription(r))
+	}
+
+	if dryRun {
+		fmt.Println("dry run, nothing was changed")
+	}
+	return nil
+}
+
+// readRouteSpecs reads the routes listed in a YAML or JSON file, resolving
+// the certificate paths relative to the file.
+func readRouteSpecs(path, appName string) ([]*router.Route, error) {
+	var data []byte
+	var err error
+	dir := "."
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+		dir = filepath.Dir(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading routes file: %s", err)
+	}
+
+	// decode YAML via JSON so that the route fields use their JSON names
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("error parsing routes file: %s", err)
+	}
+	v, err = yamlToJSON(v)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing routes file: %s", err)
+	}
+	data, err = json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Routes []*routeSpec `json:"routes"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("error parsing routes file: %s", err)
+	}
+
+	readFile := func(name string) (string, error) {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		data, err := ioutil.ReadFile(name)
+		return string(data), err
+	}
+
+	routes := make([]*router.Route, 0, len(file.Routes))
+	seen := make(map[string]struct{}, len(file.Routes))
+	for i, spec := range file.Routes {
+		r, err := spec.route(appName, readFile)
+		if err != nil {
+			return nil, fmt.Errorf("route %d: %s", i+1, err)
+		}
+		key := routeKey(r)
+		if _, ok := seen[key]; ok {
+			return nil, fmt.Errorf("route %d: %s is listed more than once", i+1, key)
+		}
+		seen[key] = struct{}{}
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+// route returns the route described by the spec.
+func (s *routeSpec) route(appName string, readFile func(string) (string, error)) (*router.Route, error) {
+	service := s.Service
+	if service == "" {
+		service = appName + "-web"
+	}
+	drainBackends := s.DrainBackends == nil || *s.DrainBackends
+
+	switch s.Type {
+	case "tcp":
+		if s.Port == 0 {
+			return nil, errors.New("port must be set for tcp routes")
+		}
+		if s.Domain != "" || s.Path != "" || s.AutoTLS || s.RedirectHTTPS || s.TLSCert != "" || s.TLSKey != "" || s.Sticky ||
+			s.DisableKeepAlives || s.HTTP2 || s.TLSPolicy != nil || s.ClientCA != "" || len(s.Splits) > 0 {
+			return nil, errors.New("only service, port, leader and drain_backends can be set for tcp routes")
+		}
+		r := &router.TCPRoute{
+			Service:       service,
+			Port:          s.Port,
+			Leader:        s.Leader,
+			DrainBackends: drainBackends,
+		}
+		return r.ToRoute(), nil
+	case "http":
+		if s.Domain == "" {
+			return nil, errors.New("domain must be set for http routes")
+		}
+		u, err := url.Parse("http://" + s.Domain + s.Path)
+		if err != nil || u.Path != s.Path {
+			return nil, fmt.Errorf("invalid domain %q or path %q", s.Domain, s.Path)
+		}
+		r := &router.HTTPRoute{
+			Service:           service,
+			Domain:            u.Host,
+			Port:              s.Port,
+			Path:              u.Path,
+			Sticky:            s.Sticky,
+			Leader:            s.Leader,
+			DrainBackends:     drainBackends,
+			DisableKeepAlives: s.DisableKeepAlives,
+			HTTP2:             s.HTTP2,
+			TLSPolicy:         s.TLSPolicy,
+			RedirectHTTPS:     s.RedirectHTTPS,
+			Splits:            s.Splits,
+		}
+		if s.AutoTLS {
+			if s.TLSCert != "" || s.TLSKey != "" {
+				return nil, errors.New("auto_tls cannot be used with tls_cert or tls_key")
+			}
+			r.ManagedCertificateDomain = &r.Domain
+		} else if s.TLSCert != "" && s.TLSKey != "" {
+			if r.LegacyTLSCert, err = readFile(s.TLSCert); err != nil {
+				return nil, fmt.Errorf("Failed to read TLS cert: %s", err)
+			}
+			if r.LegacyTLSKey, err = readFile(s.TLSKey); err != nil {
+				return nil, fmt.Errorf("Failed to read TLS key: %s", err)
+			}
+		} else if s.TLSCert != "" || s.TLSKey != "" {
+			return nil, errors.New("Both the TLS certificate AND private key need to be specified")
+		}
+		if s.TLSPolicy != nil {
+			if err := s.TLSPolicy.Validate(); err != nil {
+				return nil, err
+			}
+		}
+		if err := router.ValidateRouteSplits(s.Splits); err != nil {
+			return nil, err
+		}
+		if s.ClientCA != "" {
+			if r.ClientCA, err = readFile(s.ClientCA); err != nil {
+				return nil, fmt.Errorf("Failed to read client CA: %s", err)
+			}
+			if _, err := router.ParseClientCA(r.ClientCA); err != nil {
+				return nil, err
+			}
+		}
+		return r.ToRoute(), nil
+	case "":
+		return nil, errors.New("type must be set to http or tcp")
+	default:
+		return nil, fmt.Errorf("Route type %s not supported.", s.Type)
+	}
+}
+
+// routeKey returns the key identifying a route when applying a routes file,
+// the port for TCP routes and the domain, port and path for HTTP routes.
+func routeKey(r *router.Route) string {
+	if r.Type == "tcp" {
+		return fmt.Sprintf("tcp:%d", r.Port)
+	}
+	path := r.Path
+	if path == "" {
+		path = "/"
+	}
+	if r.Port != 0 {
+		return fmt.Sprintf("http:%s:%d%s", strings.ToLower(r.Domain), r.Port, path)
+	}
+	return fmt.Sprintf("http:%s%s", strings.ToLower(r.Domain), path)
+}
+
+// yamlToJSON converts a value decoded from YAML into one which can be
+// encoded as JSON.
+func yamlToJSON(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			k, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("unexpected key %v", key)
+			}
+			var err error
+			if m[k], err = yamlToJSON(val); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case []interface{}:
+		for i, val := range v {
+			var err error
+			if v[i], err = yamlToJSON(val); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	controller "github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	router "github.com/flynn/flynn/router/types"
+	"github.com/flynn/go-docopt"
+)
+
+// routeApplyClient is a controller client which records the changes made by
+// 'flynn route apply'.
+type routeApplyClient struct {
+	controller.Client
+
+	routes  []*router.Route
+	dryRuns []string
+	added   []string
+	updated []string
+	removed []string
+}
+
+func (c *routeApplyClient) AppRouteList(appID string) ([]*router.Route, error) {
+	return c.routes, nil
+}
+
+func (c *routeApplyClient) CreateRouteDryRun(appID string, route *router.Route) (*ct.RouteDryRun, error) {
+	c.dryRuns = append(c.dryRuns, routeKey(route))
+	return &ct.RouteDryRun{Route: route}, nil
+}
+
+func (c *routeApplyClient) CreateRoute(appID string, route *router.Route) error {
+	c.added = append(c.added, routeKey(route))
+	route.ID = "new"
+	return nil
+}
+
+// UpdateRouteDryRun reports the route as changed if its stickiness differs
+// from the existing route.
+func (c *routeApplyClient) UpdateRouteDryRun(appID, routeID string, route *router.Route) (*ct.RouteDryRun, error) {
+	res := &ct.RouteDryRun{Route: route}
+	for _, r := range c.routes {
+		if r.FormattedID() != routeID {
+			continue
+		}
+		res.Previous = r
+		if r.Sticky != route.Sticky {
+			res.Changes = []string{"sticky"}
+		}
+	}
+	return res, nil
+}
+
+func (c *routeApplyClient) UpdateRoute(appID, routeID string, route *router.Route) error {
+	c.updated = append(c.updated, routeID)
+	return nil
+}
+
+func (c *routeApplyClient) DeleteRoute(appID, routeID string) error {
+	c.removed = append(c.removed, routeID)
+	return nil
+}
+
+// runRouteApplyTest applies the routes file to an app with an unchanged, an
+// updated and a removed route, returning the client and the output.
+func runRouteApplyTest(t *testing.T, dryRun bool) (*routeApplyClient, string) {
+	defer func(app string) { flagApp = app }(flagApp)
+	flagApp = "app"
+
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	if err := ioutil.WriteFile(path, []byte(`
+routes:
+- type: http
+  domain: unchanged.example.com
+- type: http
+  domain: updated.example.com
+  sticky: true
+- type: http
+  domain: added.example.com
+  path: /api/
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	client := &routeApplyClient{routes: []*router.Route{
+		(&router.HTTPRoute{ID: "1", Service: "app-web", Domain: "unchanged.example.com", Path: "/", DrainBackends: true}).ToRoute(),
+		(&router.HTTPRoute{ID: "2", Service: "app-web", Domain: "updated.example.com", Path: "/", DrainBackends: true}).ToRoute(),
+		(&router.TCPRoute{ID: "3", Service: "app-web", Port: 2000}).ToRoute(),
+	}}
+	args := &docopt.Args{
+		String: map[string]string{"--file": path},
+		Bool:   map[string]bool{"--dry-run": dryRun},
+	}
+
+	// capture the plan printed to stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	err = runRouteApply(args, client)
+	os.Stdout = stdout
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := ioutil.ReadAll(r)
+	return client, string(out)
+}
+
+func TestRouteApply(t *testing.T) {
+	client, out := runRouteApplyTest(t, false)
+
+	if !reflect.DeepEqual(client.added, []string{"http:added.example.com/api/"}) {
+		t.Fatalf("unexpected added routes %v", client.added)
+	}
+	if !reflect.DeepEqual(client.updated, []string{"http/2"}) {
+		t.Fatalf("unexpected updated routes %v", client.updated)
+	}
+	if !reflect.DeepEqual(client.removed, []string{"tcp/3"}) {
+		t.Fatalf("unexpected removed routes %v", client.removed)
+	}
+	if len(client.dryRuns) != 0 {
+		t.Fatalf("expected no dry run of added routes, got %v", client.dryRuns)
+	}
+	expected := strings.Join([]string{
+		"http/1 (unchanged.example.com/) is unchanged",
+		"updated http/2 (updated.example.com/): sticky",
+		"added http/new (added.example.com/api/)",
+		"removed tcp/3 (port 2000)",
+		"",
+	}, "\n")
+	if out != expected {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
+func TestRouteApplyDryRun(t *testing.T) {
+	client, out := runRouteApplyTest(t, true)
+
+	// nothing is changed, but added routes are validated
+	if len(client.added) != 0 || len(client.updated) != 0 || len(client.removed) != 0 {
+		t.Fatalf("expected no changes, got added %v, updated %v, removed %v", client.added, client.updated, client.removed)
+	}
+	if !reflect.DeepEqual(client.dryRuns, []string{"http:added.example.com/api/"}) {
+		t.Fatalf("unexpected dry runs %v", client.dryRuns)
+	}
+	expected := strings.Join([]string{
+		"http/1 (unchanged.example.com/) is unchanged",
+		"would update http/2 (updated.example.com/): sticky",
+		"would add http:added.example.com/api/",
+		"would remove tcp/3 (port 2000)",
+		"dry run, nothing was changed",
+		"",
+	}, "\n")
+	if out != expected {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
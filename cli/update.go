@@ -14,6 +14,7 @@ import (
 	"time"
 
 	cfg "github.com/flynn/flynn/cli/config"
+	"github.com/flynn/flynn/pkg/ghrelease"
 	"github.com/flynn/flynn/pkg/random"
 	"github.com/flynn/flynn/pkg/version"
 	"github.com/kardianos/osext"
@@ -84,7 +85,7 @@ func (u *Updater) update() error {
 		return fmt.Errorf("failed to check for updates: %w", err)
 	}
 
-	if latestVersion == version.Release() {
+	if !ghrelease.CompareVersions(version.Release(), latestVersion) {
 		return nil
 	}
 
@@ -18,6 +18,9 @@ func init() {
 	register("volume", runVolume, `
 usage: flynn volume
        flynn volume show [--json] <id>
+       flynn volume inspect [--json] <id>
+       flynn volume snapshot <id>
+       flynn volume restore <id> <snapshot>
        flynn volume decommission <id>
 
 Manage cluster volumes.
@@ -28,6 +31,21 @@ Commands:
     show
 	    Show information about a volume.
 
+    inspect
+	    Show information about a volume along with its disk usage and
+	    snapshots, as reported by the host the volume is on.
+
+    snapshot
+	    Create a snapshot of a volume.
+
+    restore
+	    Restore a volume from one of its snapshots.
+
+	    A new volume is created from the snapshot and the existing volume
+	    is decommissioned, so the restored volume is used by the next job
+	    started in place of the one currently using the volume (e.g. after
+	    running 'flynn restart').
+
     decommission
 	    Decommission a volume.
 
@@ -39,6 +57,12 @@ Commands:
 func runVolume(args *docopt.Args, client controller.Client) error {
 	if args.Bool["show"] {
 		return runVolumeShow(args, client)
+	} else if args.Bool["inspect"] {
+		return runVolumeInspect(args, client)
+	} else if args.Bool["snapshot"] {
+		return runVolumeSnapshot(args, client)
+	} else if args.Bool["restore"] {
+		return runVolumeRestore(args, client)
 	} else if args.Bool["decommission"] {
 		return runVolumeDecommission(args, client)
 	}
@@ -97,6 +121,60 @@ func runVolumeShow(args *docopt.Args, client controller.Client) error {
 	return nil
 }
 
+func runVolumeInspect(args *docopt.Args, client controller.Client) error {
+	vol, err := client.GetExpandedVolume(mustApp(), args.String["<id>"])
+	if err != nil {
+		return err
+	}
+	if args.Bool["--json"] {
+		return json.NewEncoder(os.Stdout).Encode(vol)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
+	listRec(w, "ID:", vol.ID)
+	listRec(w, "State:", vol.State)
+	listRec(w, "HostID:", vol.HostID)
+	listRec(w, "Size:", units.BytesSize(float64(vol.Size)))
+	listRec(w, "Path:", vol.Path)
+	listRec(w, "ReleaseID:", vol.ReleaseID)
+	listRec(w, "JobType:", vol.JobType)
+	var jobID string
+	if vol.JobID != nil {
+		jobID = cluster.GenerateJobID(vol.HostID, *vol.JobID)
+	}
+	listRec(w, "JobID:", jobID)
+	listRec(w, "DecommissionedAt:", vol.DecommissionedAt)
+	w.Flush()
+
+	fmt.Println()
+	w = tabWriter()
+	defer w.Flush()
+	listRec(w, "SNAPSHOT", "SIZE", "CREATED")
+	for _, s := range vol.Snapshots {
+		created := units.HumanDuration(time.Now().UTC().Sub(s.CreatedAt)) + " ago"
+		listRec(w, s.ID, units.BytesSize(float64(s.Size)), created)
+	}
+	return nil
+}
+
+func runVolumeSnapshot(args *docopt.Args, client controller.Client) error {
+	snap, err := client.CreateVolumeSnapshot(mustApp(), args.String["<id>"])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created snapshot %s of volume %s\n", snap.ID, snap.VolumeID)
+	return nil
+}
+
+func runVolumeRestore(args *docopt.Args, client controller.Client) error {
+	vol, err := client.RestoreVolume(mustApp(), args.String["<id>"], args.String["<snapshot>"])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored volume %s from snapshot %s as volume %s\n", args.String["<id>"], args.String["<snapshot>"], vol.ID)
+	fmt.Println("the restored volume will be used by the next job started in place of the one currently using the volume")
+	return nil
+}
+
 func runVolumeDecommission(args *docopt.Args, client controller.Client) error {
 	vol := &ct.Volume{ID: args.String["<id>"]}
 	if err := client.DecommissionVolume(mustApp(), vol); err != nil {
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/ctxhelper"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"golang.org/x/net/context"
+)
+
+// PutACMEChallenge stores a pending HTTP-01 challenge response so that any
+// instance of the ACME service can respond to the challenge
+func (c *controllerAPI) PutACMEChallenge(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+
+	var challenge ct.ACMEChallenge
+	if err := httphelper.DecodeJSON(req, &challenge); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	challenge.Token = params.ByName("token")
+	if !strings.HasPrefix(challenge.KeyAuthorization, challenge.Token+".") {
+		respondWithError(w, ct.ValidationError{
+			Field:   "key_authorization",
+			Message: "must be the challenge token followed by the account key thumbprint",
+		})
+		return
+	}
+
+	if err := c.acmeChallengeRepo.Add(&challenge); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, &challenge)
+}
+
+// GetACMEChallenge returns a pending HTTP-01 challenge response
+func (c *controllerAPI) GetACMEChallenge(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+
+	challenge, err := c.acmeChallengeRepo.Get(params.ByName("token"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, challenge)
+}
+
+// DeleteACMEChallenge removes a pending HTTP-01 challenge response
+func (c *controllerAPI) DeleteACMEChallenge(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+
+	if err := c.acmeChallengeRepo.Remove(params.ByName("token")); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	w.WriteHeader(200)
+}
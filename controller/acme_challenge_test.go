@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	hh "github.com/flynn/flynn/pkg/httphelper"
+	. "github.com/flynn/go-check"
+)
+
+func (s *S) TestACMEChallenges(c *C) {
+	// the key authorization must be for the token
+	err := s.c.PutACMEChallenge(&ct.ACMEChallenge{Token: "token1", KeyAuthorization: "token2.thumbprint"})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+
+	challenge := &ct.ACMEChallenge{Token: "token1", KeyAuthorization: "token1.thumbprint"}
+	c.Assert(s.c.PutACMEChallenge(challenge), IsNil)
+	c.Assert(challenge.CreatedAt, NotNil)
+
+	got, err := s.c.GetACMEChallenge("token1")
+	c.Assert(err, IsNil)
+	c.Assert(got.KeyAuthorization, Equals, "token1.thumbprint")
+
+	// setting the same token again replaces the challenge
+	c.Assert(s.c.PutACMEChallenge(&ct.ACMEChallenge{Token: "token1", KeyAuthorization: "token1.other"}), IsNil)
+	got, err = s.c.GetACMEChallenge("token1")
+	c.Assert(err, IsNil)
+	c.Assert(got.KeyAuthorization, Equals, "token1.other")
+
+	c.Assert(s.c.DeleteACMEChallenge("token1"), IsNil)
+	_, err = s.c.GetACMEChallenge("token1")
+	c.Assert(err, Equals, controller.ErrNotFound)
+}
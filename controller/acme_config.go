@@ -2,6 +2,7 @@ package main
 
 import (
 	"net/http"
+	"net/url"
 
 	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/flynn/pkg/httphelper"
@@ -15,11 +16,15 @@ func (c *controllerAPI) GetACMEConfig(ctx context.Context, w http.ResponseWriter
 		respondWithError(w, err)
 		return
 	}
-	// Set HasAccountKey before stripping the key for security
+	// Set HasAccountKey and HasEABHMACKey before stripping the keys for security
 	config.HasAccountKey = config.AccountKey != ""
+	config.HasEABHMACKey = config.EABHMACKey != ""
+	config.HasDNSWebhookToken = config.DNSWebhookToken != ""
 	// Check if the request includes the internal header
 	if req.Header.Get("X-Flynn-Internal") != "true" {
 		config.AccountKey = ""
+		config.EABHMACKey = ""
+		config.DNSWebhookToken = ""
 	}
 	httphelper.JSON(w, 200, config)
 }
@@ -44,6 +49,27 @@ func (c *controllerAPI) UpdateACMEConfig(ctx context.Context, w http.ResponseWri
 		newConfig.AccountKey = existingConfig.AccountKey
 	}
 
+	// Preserve the EAB HMAC key if not provided and the key ID is unchanged
+	if newConfig.EABHMACKey == "" && newConfig.EABKeyID == existingConfig.EABKeyID {
+		newConfig.EABHMACKey = existingConfig.EABHMACKey
+	}
+	if (newConfig.EABKeyID == "") != (newConfig.EABHMACKey == "") {
+		respondWithError(w, ct.ValidationError{
+			Field:   "eab_key_id",
+			Message: "the EAB key ID and HMAC key must be set together",
+		})
+		return
+	}
+
+	// Preserve the DNS webhook token if not provided and the URL is unchanged
+	if newConfig.DNSWebhookToken == "" && newConfig.DNSWebhookURL == existingConfig.DNSWebhookURL {
+		newConfig.DNSWebhookToken = existingConfig.DNSWebhookToken
+	}
+	if err := validateDNSWebhook(&newConfig); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
 	// Validate required fields when enabling ACME
 	if newConfig.Enabled {
 		if newConfig.ContactEmail == "" {
@@ -67,11 +93,37 @@ func (c *controllerAPI) UpdateACMEConfig(ctx context.Context, w http.ResponseWri
 		return
 	}
 
-	// Don't expose the private key in the response
+	// Don't expose the private keys in the response
+	newConfig.HasAccountKey = newConfig.AccountKey != ""
+	newConfig.HasEABHMACKey = newConfig.EABHMACKey != ""
+	newConfig.HasDNSWebhookToken = newConfig.DNSWebhookToken != ""
 	newConfig.AccountKey = ""
+	newConfig.EABHMACKey = ""
+	newConfig.DNSWebhookToken = ""
 	httphelper.JSON(w, 200, &newConfig)
 }
 
+// validateDNSWebhook checks that the DNS webhook is an HTTP(S) URL, and that
+// one is configured if default routes use a wildcard certificate, which can
+// only be issued using the DNS-01 challenge.
+func validateDNSWebhook(config *ct.ACMEConfig) error {
+	if config.DNSWebhookURL != "" {
+		u, err := url.Parse(config.DNSWebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return ct.ValidationError{Field: "dns_webhook_url", Message: "must be an HTTP or HTTPS URL"}
+		}
+	} else if config.DNSWebhookToken != "" {
+		return ct.ValidationError{Field: "dns_webhook_token", Message: "requires dns_webhook_url"}
+	}
+	if config.WildcardDefaultDomain && config.DNSWebhookURL == "" {
+		return ct.ValidationError{
+			Field:   "wildcard_default_domain",
+			Message: "wildcard certificates require a DNS webhook to complete DNS-01 challenges",
+		}
+	}
+	return nil
+}
+
 // IsACMEEnabled checks if ACME is enabled globally
 func (c *controllerAPI) IsACMEEnabled() (bool, error) {
 	return c.acmeConfigRepo.IsEnabled()
@@ -182,7 +182,7 @@ func NewError(err error, message string, args ...interface{}) error {
 		errCode = codes.NotFound
 	}
 	switch err.(type) {
-	case ct.ValidationError, *ct.ValidationError:
+	case ct.ValidationError, *ct.ValidationError, ct.ValidationErrors:
 		errCode = codes.InvalidArgument
 	}
 	msg := fmt.Sprintf(message, args...)
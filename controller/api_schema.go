@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/flynn/flynn/controller/openapi"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/pkg/version"
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/net/context"
+)
+
+// apiRouter is an httprouter.Router which records the routes registered on
+// it so that the OpenAPI document served at /schema reflects the routes
+// actually being served.
+type apiRouter struct {
+	*httprouter.Router
+
+	routes []openapi.Operation
+
+	specOnce sync.Once
+	spec     *openapi.Document
+}
+
+func newAPIRouter() *apiRouter {
+	return &apiRouter{Router: httprouter.New()}
+}
+
+func (r *apiRouter) record(method, path string) {
+	op, ok := openapi.Lookup(method, path)
+	if !ok {
+		// undocumented routes are still included, just without
+		// request or response types
+		op = openapi.Operation{Method: method, Path: path}
+	}
+	r.routes = append(r.routes, op)
+}
+
+func (r *apiRouter) Handle(method, path string, handle httprouter.Handle) {
+	r.record(method, path)
+	r.Router.Handle(method, path, handle)
+}
+
+func (r *apiRouter) Handler(method, path string, handler http.Handler) {
+	r.record(method, path)
+	r.Router.Handler(method, path, handler)
+}
+
+func (r *apiRouter) GET(path string, handle httprouter.Handle)    { r.Handle("GET", path, handle) }
+func (r *apiRouter) POST(path string, handle httprouter.Handle)   { r.Handle("POST", path, handle) }
+func (r *apiRouter) PUT(path string, handle httprouter.Handle)    { r.Handle("PUT", path, handle) }
+func (r *apiRouter) DELETE(path string, handle httprouter.Handle) { r.Handle("DELETE", path, handle) }
+
+// Spec returns the OpenAPI document for the registered routes, it must
+// only be called once all routes have been registered.
+func (r *apiRouter) Spec() *openapi.Document {
+	r.specOnce.Do(func() {
+		r.spec = openapi.Generate(version.String(), r.routes)
+	})
+	return r.spec
+}
+
+// ServeSchema serves the OpenAPI document for the registered routes.
+func (r *apiRouter) ServeSchema(_ context.Context, w http.ResponseWriter, _ *http.Request) {
+	httphelper.JSON(w, 200, r.Spec())
+}
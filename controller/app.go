@@ -47,18 +47,23 @@ func (c *controllerAPI) UpdateApp(ctx context.Context, rw http.ResponseWriter, r
 }
 
 func (c *controllerAPI) DeleteApp(ctx context.Context, w http.ResponseWriter, req *http.Request) {
-	args, err := json.Marshal(c.getApp(ctx))
-	if err != nil {
+	if err := c.enqueueAppDeletion(c.getApp(ctx)); err != nil {
 		respondWithError(w, err)
 		return
 	}
-	if err := c.que.Enqueue(&que.Job{
+}
+
+// enqueueAppDeletion enqueues a job which deletes the app along with its
+// routes, releases and resources.
+func (c *controllerAPI) enqueueAppDeletion(app *ct.App) error {
+	args, err := json.Marshal(app)
+	if err != nil {
+		return err
+	}
+	return c.que.Enqueue(&que.Job{
 		Type: "app_deletion",
 		Args: args,
-	}); err != nil {
-		respondWithError(w, err)
-		return
-	}
+	})
 }
 
 func (c *controllerAPI) ScheduleAppGarbageCollection(ctx context.Context, w http.ResponseWriter, req *http.Request) {
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/flynn/flynn/controller/schema"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/pkg/resource"
+	router "github.com/flynn/flynn/router/types"
+	"golang.org/x/net/context"
+)
+
+// CloneApp creates a new app with a copy of the app's current release,
+// formation, resources and routes.
+func (c *controllerAPI) CloneApp(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var cr ct.AppCloneRequest
+	if err := httphelper.DecodeJSON(req, &cr); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	res, err := c.cloneApp(ctx, c.getApp(ctx), &cr)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, res)
+}
+
+// cloneApp creates a copy of the source app as described by the clone
+// request, deleting anything created if it fails.
+func (c *controllerAPI) cloneApp(ctx context.Context, src *ct.App, cr *ct.AppCloneRequest) (*ct.AppClone, error) {
+	if src.System() {
+		return nil, ct.ValidationError{Message: "system apps cannot be cloned"}
+	}
+	for _, key := range cr.ExcludeEnv {
+		if key == "" {
+			return nil, ct.ValidationError{Field: "exclude_env", Message: "must not contain empty keys"}
+		}
+	}
+
+	var srcRelease *ct.Release
+	if src.ReleaseID != "" {
+		var err error
+		if srcRelease, err = c.appRepo.GetRelease(src.ID); err != nil {
+			return nil, err
+		}
+	}
+	srcResources, err := c.resourceRepo.AppList(src.ID)
+	if err != nil {
+		return nil, err
+	}
+	srcRoutes, err := c.routeRepo.List(routeParentRef(src.ID))
+	if err != nil {
+		return nil, err
+	}
+	providers := make(map[string]*ct.Provider, len(srcResources))
+	for _, res := range srcResources {
+		p, err := c.providerRepo.Get(res.ProviderID)
+		if err != nil {
+			return nil, err
+		}
+		providers[res.ProviderID] = p.(*ct.Provider)
+	}
+
+	app := &ct.App{
+		Name:          cr.Name,
+		Meta:          make(map[string]string, len(src.Meta)),
+		Strategy:      src.Strategy,
+		DeployTimeout: src.DeployTimeout,
+	}
+	for k, v := range src.Meta {
+		app.Meta[k] = v
+	}
+	if err := schema.Validate(app); err != nil {
+		return nil, err
+	}
+	if err := c.appRepo.Add(app); err != nil {
+		return nil, err
+	}
+	res := &ct.AppClone{App: app}
+
+	// once the app exists, delete it along with anything else created if
+	// cloning fails, which also deprovisions any new resources
+	fail := func(err error) (*ct.AppClone, error) {
+		c.deleteApp(app)
+		return nil, err
+	}
+
+	// resourceEnv maps the env of the source app's resources to the env of
+	// the new resources when provisioning them
+	var resourceEnv []*clonedResource
+	for _, r := range srcResources {
+		p := providers[r.ProviderID]
+		if err := c.checkResourcePolicy(p, []string{app.ID}); err != nil {
+			return fail(err)
+		}
+		if !cr.ProvisionResources {
+			shared, err := c.resourceRepo.AddApp(r.ID, app.ID)
+			if err != nil {
+				return fail(err)
+			}
+			res.Resources = append(res.Resources, shared)
+			continue
+		}
+		data, err := resource.Provision(ctx, p.URL, []byte(`{}`))
+		if err != nil {
+			return fail(fmt.Errorf("error provisioning %s resource: %s", p.Name, err))
+		}
+		newRes := &ct.Resource{
+			ProviderID: p.ID,
+			ExternalID: data.ID,
+			Env:        data.Env,
+			Apps:       []string{app.ID},
+		}
+		if err := c.resourceRepo.Add(newRes); err != nil {
+			return fail(err)
+		}
+		res.Resources = append(res.Resources, newRes)
+		resourceEnv = append(resourceEnv, &clonedResource{from: r, to: newRes})
+	}
+
+	if srcRelease != nil {
+		release := cloneRelease(srcRelease, app, cr.ExcludeEnv, resourceEnv)
+		if err := c.releaseRepo.Add(release); err != nil {
+			return fail(err)
+		}
+		if err := c.appRepo.SetRelease(app, release.ID); err != nil {
+			return fail(err)
+		}
+		res.Release = release
+
+		formation, err := c.formationRepo.Get(src.ID, srcRelease.ID)
+		if err != nil && err != ErrNotFound {
+			return fail(err)
+		}
+		if formation != nil && len(release.ArtifactIDs) > 0 {
+			f := &ct.Formation{
+				AppID:     app.ID,
+				ReleaseID: release.ID,
+				Processes: formation.Processes,
+				Tags:      formation.Tags,
+			}
+			if err := c.validateFormationPlacement(ctx, f, release); err != nil {
+				return fail(err)
+			}
+			if _, err := c.formationRepo.AddScaleRequest(newScaleRequest(f, release), false); err != nil {
+				return fail(err)
+			}
+			res.Formation = f
+		}
+	}
+
+	// the new app may already have a default route, which is updated with
+	// the settings of the cloned route on the same domain and path
+	existing, err := c.routeRepo.List(routeParentRef(app.ID))
+	if err != nil {
+		return fail(err)
+	}
+	srcDomain, domain := appDefaultDomain(src), strings.ToLower(cr.Domain)
+	if domain == "" {
+		domain = appDefaultDomain(app)
+	}
+	for _, r := range srcRoutes {
+		route := cloneRoute(r, src, app, srcDomain, domain)
+		if route == nil {
+			continue
+		}
+		for _, e := range existing {
+			if e.Type == "http" && route.Type == "http" && strings.EqualFold(e.Domain, route.Domain) && e.Port == route.Port && routePath(e) == routePath(route) {
+				route.ID = e.ID
+				break
+			}
+		}
+		if err := validateRouteTLS(route); err != nil {
+			return fail(err)
+		}
+		if route.ID != "" {
+			err = c.routeRepo.Update(route)
+		} else {
+			err = c.routeRepo.Add(route)
+		}
+		if err != nil {
+			return fail(routeAddError(route, err))
+		}
+	}
+	if res.Routes, err = c.routeRepo.List(routeParentRef(app.ID)); err != nil {
+		return fail(err)
+	}
+	return res, nil
+}
+
+// deleteApp enqueues the deletion of an app which was created as a copy of
+// another app, logging any error since the original error is more useful
+// to the caller.
+func (c *controllerAPI) deleteApp(app *ct.App) {
+	if err := c.enqueueAppDeletion(app); err != nil {
+		logger.Error("error deleting app", "app", app.Name, "err", err)
+	}
+}
+
+// clonedResource is a resource of the source app and the resource
+// provisioned to replace it in the new app.
+type clonedResource struct {
+	from *ct.Resource
+	to   *ct.Resource
+}
+
+// appDefaultDomain returns the domain of the route created for the app when
+// it was created, or an empty string if there is no default route domain.
+func appDefaultDomain(app *ct.App) string {
+	defaultDomain := os.Getenv("DEFAULT_ROUTE_DOMAIN")
+	if defaultDomain == "" {
+		return ""
+	}
+	return strings.ToLower(fmt.Sprintf("%s.%s", app.Name, defaultDomain))
+}
+
+// cloneRelease returns a copy of the release for the given app without the
+// excluded env keys, and with env referring to provisioned resources
+// rewritten to refer to their replacements.
+func cloneRelease(src *ct.Release, app *ct.App, excludeEnv []string, resources []*clonedResource) *ct.Release {
+	excluded := make(map[string]struct{}, len(excludeEnv))
+	for _, key := range excludeEnv {
+		excluded[key] = struct{}{}
+	}
+	cloneEnv := func(env map[string]string) map[string]string {
+		if env == nil {
+			return nil
+		}
+		clone := make(map[string]string, len(env))
+		for k, v := range env {
+			if _, ok := excluded[k]; ok {
+				continue
+			}
+			clone[k] = cloneResourceEnv(k, v, resources)
+		}
+		return clone
+	}
+
+	release := &ct.Release{
+		AppID:       app.ID,
+		ArtifactIDs: append([]string(nil), src.ArtifactIDs...),
+		Env:         cloneEnv(src.Env),
+		Processes:   make(map[string]ct.ProcessType, len(src.Processes)),
+	}
+	if src.Meta != nil {
+		release.Meta = make(map[string]string, len(src.Meta))
+		for k, v := range src.Meta {
+			release.Meta[k] = v
+		}
+	}
+	for typ, proc := range src.Processes {
+		proc.Env = cloneEnv(proc.Env)
+		release.Processes[typ] = proc
+	}
+	return release
+}
+
+// cloneResourceEnv returns the value of an env var for a cloned release,
+// replacing values taken from or referring to a provisioned resource with
+// those of its replacement.
+func cloneResourceEnv(key, value string, resources []*clonedResource) string {
+	if id, name, ok, err := ct.ParseResourceEnvRef(value); ok {
+		if err != nil {
+			return value
+		}
+		for _, r := range resources {
+			if r.from.ID == id {
+				return fmt.Sprintf("%s%s/%s", ct.ResourceEnvRefPrefix, r.to.ID, name)
+			}
+		}
+		return value
+	}
+	for _, r := range resources {
+		if v, ok := r.from.Env[key]; ok && v == value {
+			if newValue, ok := r.to.Env[key]; ok {
+				return newValue
+			}
+		}
+	}
+	return value
+}
+
+// cloneRoute returns a copy of a route of the source app for the new app,
+// or nil if the route cannot be cloned. HTTP routes on the source app's
+// default domain or its subdomains are moved to the given domain, and TCP
+// routes get a new port.
+func cloneRoute(r *router.Route, src, app *ct.App, srcDomain, domain string) *router.Route {
+	route := &router.Route{
+		Type:              r.Type,
+		ParentRef:         routeParentRef(app.ID),
+		Service:           r.Service,
+		Leader:            r.Leader,
+		DrainBackends:     r.DrainBackends,
+		Sticky:            r.Sticky,
+		Path:              r.Path,
+		DisableKeepAlives: r.DisableKeepAlives,
+		TLSPolicy:         r.TLSPolicy,
+		ClientCA:          r.ClientCA,
+		RedirectHTTPS:     r.RedirectHTTPS,
+		HTTP2:             r.HTTP2,
+	}
+	if strings.HasPrefix(r.Service, src.Name+"-") {
+		route.Service = app.Name + strings.TrimPrefix(r.Service, src.Name)
+	}
+	if r.Type == "tcp" {
+		return route
+	}
+
+	if srcDomain == "" || domain == "" {
+		return nil
+	}
+	d := strings.ToLower(r.Domain)
+	switch {
+	case d == srcDomain:
+		route.Domain = domain
+	case strings.HasSuffix(d, "."+srcDomain):
+		route.Domain = strings.TrimSuffix(d, srcDomain) + domain
+	default:
+		return nil
+	}
+	route.Port = r.Port
+
+	// certificates are for the source app's domain, but a managed
+	// certificate can be requested for the new one, or a wildcard managed
+	// certificate shared if it also covers the new domain
+	if m := r.ManagedCertificateDomain; m != nil && *m != "" {
+		if ct.WildcardDomainCovers(*m, route.Domain) {
+			route.ManagedCertificateDomain = m
+		} else {
+			route.ManagedCertificateDomain = &route.Domain
+		}
+	}
+	return route
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/random"
+	router "github.com/flynn/flynn/router/types"
+	. "github.com/flynn/go-check"
+)
+
+func (s *S) TestCloneApp(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "clone-app", Meta: map[string]string{"foo": "bar"}})
+	release := s.createTestRelease(c, app.ID, &ct.Release{
+		Env: map[string]string{"FOO": "foo", "SECRET": "secret"},
+		Processes: map[string]ct.ProcessType{
+			"web": {Args: []string{"start", "web"}, Env: map[string]string{"SECRET": "web-secret"}},
+		},
+	})
+	c.Assert(s.c.SetAppRelease(app.ID, release.ID), IsNil)
+	s.createTestFormation(c, &ct.Formation{AppID: app.ID, ReleaseID: release.ID, Processes: map[string]int{"web": 2}})
+	provider := s.createTestProvider(c, &ct.Provider{URL: "https://example.com", Name: "clone-app"})
+	resource := &ct.Resource{
+		ID:         random.UUID(),
+		ProviderID: provider.ID,
+		ExternalID: "/things/clone-app",
+		Env:        map[string]string{"DATABASE_URL": "postgres://db"},
+		Apps:       []string{app.ID},
+	}
+	c.Assert(s.c.PutResource(resource), IsNil)
+	s.createTestRoute(c, app.ID, (&router.TCPRoute{Service: "clone-app-web", Leader: true}).ToRoute())
+
+	res, err := s.c.CloneApp(app.ID, &ct.AppCloneRequest{Name: "clone-app-copy", ExcludeEnv: []string{"SECRET"}})
+	c.Assert(err, IsNil)
+	c.Assert(res.App.Name, Equals, "clone-app-copy")
+	c.Assert(res.App.ID, Not(Equals), app.ID)
+	c.Assert(res.App.Meta["foo"], Equals, "bar")
+
+	c.Assert(res.Release, NotNil)
+	c.Assert(res.Release.ID, Not(Equals), release.ID)
+	c.Assert(res.Release.ArtifactIDs, DeepEquals, release.ArtifactIDs)
+	c.Assert(res.Release.Env, DeepEquals, map[string]string{"FOO": "foo"})
+	c.Assert(res.Release.Processes["web"].Env, DeepEquals, map[string]string{})
+	gotRelease, err := s.c.GetAppRelease(res.App.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotRelease.ID, Equals, res.Release.ID)
+
+	formation, err := s.c.GetFormation(res.App.ID, res.Release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(formation.Processes, DeepEquals, map[string]int{"web": 2})
+
+	// resources are shared unless provisioning new ones
+	c.Assert(res.Resources, HasLen, 1)
+	c.Assert(res.Resources[0].ID, Equals, resource.ID)
+	resources, err := s.c.AppResourceList(res.App.ID)
+	c.Assert(err, IsNil)
+	c.Assert(resources, HasLen, 1)
+	c.Assert(resources[0].ID, Equals, resource.ID)
+
+	var tcpRoutes []*router.Route
+	for _, r := range res.Routes {
+		if r.Type == "tcp" {
+			tcpRoutes = append(tcpRoutes, r)
+		}
+	}
+	c.Assert(tcpRoutes, HasLen, 1)
+	c.Assert(tcpRoutes[0].Service, Equals, "clone-app-copy-web")
+	c.Assert(tcpRoutes[0].Leader, Equals, true)
+	c.Assert(tcpRoutes[0].Port, Not(Equals), int32(0))
+
+	// cloning onto an existing app name fails
+	_, err = s.c.CloneApp(app.ID, &ct.AppCloneRequest{Name: "clone-app-copy"})
+	c.Assert(err, NotNil)
+}
+
+func (s *S) TestCloneResourceEnv(c *C) {
+	from := &ct.Resource{ID: random.UUID(), Env: map[string]string{"DATABASE_URL": "postgres://old"}}
+	to := &ct.Resource{ID: random.UUID(), Env: map[string]string{"DATABASE_URL": "postgres://new"}}
+	resources := []*clonedResource{{from: from, to: to}}
+
+	for _, t := range []struct {
+		key, value, expected string
+	}{
+		{"DATABASE_URL", "postgres://old", "postgres://new"},
+		{"DATABASE_URL", "postgres://custom", "postgres://custom"},
+		{"OTHER_URL", "postgres://old", "postgres://old"},
+		{"DB", fmt.Sprintf("resource://%s/DATABASE_URL", from.ID), fmt.Sprintf("resource://%s/DATABASE_URL", to.ID)},
+		{"DB", "resource://other/DATABASE_URL", "resource://other/DATABASE_URL"},
+	} {
+		c.Assert(cloneResourceEnv(t.key, t.value, resources), Equals, t.expected, Commentf("%s=%s", t.key, t.value))
+	}
+}
+
+func (s *S) TestCloneRoute(c *C) {
+	src := &ct.App{ID: random.UUID(), Name: "myapp"}
+	app := &ct.App{ID: random.UUID(), Name: "myapp-pr-1"}
+	managed := "api.myapp.example.com"
+
+	for _, t := range []struct {
+		domain   string
+		expected string
+	}{
+		{"myapp.example.com", "pr-1.review.com"},
+		{"API.myapp.example.com", "api.pr-1.review.com"},
+		{"www.example.com", ""},
+		{"notmyapp.example.com", ""},
+	} {
+		route := cloneRoute((&router.HTTPRoute{Domain: t.domain, Service: "myapp-web"}).ToRoute(), src, app, "myapp.example.com", "pr-1.review.com")
+		if t.expected == "" {
+			c.Assert(route, IsNil, Commentf("%s", t.domain))
+			continue
+		}
+		c.Assert(route, NotNil, Commentf("%s", t.domain))
+		c.Assert(route.Domain, Equals, t.expected)
+		c.Assert(route.Service, Equals, "myapp-pr-1-web")
+		c.Assert(route.ParentRef, Equals, routeParentRef(app.ID))
+	}
+
+	route := cloneRoute((&router.HTTPRoute{
+		Domain:                   managed,
+		Service:                  "other-web",
+		ManagedCertificateDomain: &managed,
+		LegacyTLSCert:            "cert",
+		LegacyTLSKey:             "key",
+	}).ToRoute(), src, app, "myapp.example.com", "pr-1.review.com")
+	c.Assert(route.Service, Equals, "other-web")
+	c.Assert(route.LegacyTLSCert, Equals, "")
+	c.Assert(*route.ManagedCertificateDomain, Equals, "api.pr-1.review.com")
+
+	// a wildcard managed certificate is kept if it covers the new domain
+	wildcard := "*.example.com"
+	route = cloneRoute((&router.HTTPRoute{
+		Domain:                   "myapp.example.com",
+		Service:                  "myapp-web",
+		ManagedCertificateDomain: &wildcard,
+	}).ToRoute(), src, app, "myapp.example.com", "pr-1.example.com")
+	c.Assert(*route.ManagedCertificateDomain, Equals, "*.example.com")
+	route = cloneRoute((&router.HTTPRoute{
+		Domain:                   "myapp.example.com",
+		Service:                  "myapp-web",
+		ManagedCertificateDomain: &wildcard,
+	}).ToRoute(), src, app, "myapp.example.com", "pr-1.review.com")
+	c.Assert(*route.ManagedCertificateDomain, Equals, "pr-1.review.com")
+}
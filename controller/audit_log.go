@@ -40,13 +40,21 @@ type limitedBodyReader struct {
 
 const redactedPlaceholder = "[redacted]"
 
+// isSecretEnvKey returns whether the env var with the given name looks like
+// it contains a secret which should not be logged or displayed.
+func isSecretEnvKey(key string) bool {
+	for _, s := range []string{"key", "token", "pass", "secret", "url"} {
+		if strings.Contains(strings.ToLower(key), s) {
+			return true
+		}
+	}
+	return false
+}
+
 func redactEnv(env map[string]string) {
 	for k, v := range env {
-		for _, s := range []string{"key", "token", "pass", "secret", "url"} {
-			if v != "" && strings.Contains(strings.ToLower(k), s) {
-				env[k] = redactedPlaceholder
-				break
-			}
+		if v != "" && isSecretEnvKey(k) {
+			env[k] = redactedPlaceholder
 		}
 	}
 }
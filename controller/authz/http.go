@@ -63,6 +63,14 @@ func httpRequirement(method, rawPath string) (routeKind, string) {
 		if m == http.MethodPost && parts[2] == "deploy" {
 			return rkAppDeploy, appID
 		}
+		if m == http.MethodPost && parts[2] == "clone" {
+			// cloning creates a new app, like POST /apps
+			return rkCluster, ""
+		}
+		if parts[2] == "review-apps" && m != http.MethodGet && m != http.MethodHead {
+			// review apps are created and deleted like other apps
+			return rkCluster, ""
+		}
 		switch m {
 		case http.MethodGet, http.MethodHead:
 			return rkAppRead, appID
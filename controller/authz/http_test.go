@@ -37,6 +37,12 @@ func TestHTTPAllowed(t *testing.T) {
 		{"app_write_can_post_release", appWrite, http.MethodPost, "/apps/app-1/releases", true},
 		{"app_write_can_post_deploy_route", appWrite, http.MethodPost, "/apps/app-1/deploy", true},
 		{"wrong_app_denied", wrongApp, http.MethodGet, "/apps/app-1", false},
+		{"app_write_cannot_clone_app", appWrite, http.MethodPost, "/apps/app-1/clone", false},
+		{"scoped_admin_can_clone_app", adminBearer, http.MethodPost, "/apps/app-1/clone", true},
+		{"app_read_can_list_review_apps", appRead, http.MethodGet, "/apps/app-1/review-apps", true},
+		{"app_write_cannot_create_review_app", appWrite, http.MethodPost, "/apps/app-1/review-apps", false},
+		{"app_write_cannot_delete_review_app", appWrite, http.MethodDelete, "/apps/app-1/review-apps", false},
+		{"scoped_admin_can_create_review_app", adminBearer, http.MethodPost, "/apps/app-1/review-apps", true},
 
 		{"deploy_grant_allows_named_deploy_route", appDeploy, http.MethodPost, "/apps/app-1/deploy", true},
 		// app:deploy satisfies rkAppWrite (see grantCovers), not only POST …/deploy.
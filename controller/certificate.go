@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/httphelper"
+	router "github.com/flynn/flynn/router/types"
+	"golang.org/x/net/context"
+)
+
+// defaultCertificateExpiryWindow is how far ahead ListExpiringCertificates
+// looks if the within parameter is not set
+const defaultCertificateExpiryWindow = 30 * 24 * time.Hour
+
+// ListCertificates lists the manual and managed certificates of HTTP routes
+// along with the routes using them, ordered by expiry.
+func (c *controllerAPI) ListCertificates(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	certs, err := c.certificateInventory()
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, certs)
+}
+
+// ListExpiringCertificates lists the certificates of HTTP routes which expire
+// within the duration given in the within parameter (e.g. 720h).
+func (c *controllerAPI) ListExpiringCertificates(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	within := defaultCertificateExpiryWindow
+	if s := req.FormValue("within"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			httphelper.ValidationError(w, "within", "must be a positive duration (e.g. 720h)")
+			return
+		}
+		within = d
+	}
+
+	certs, err := c.certificateInventory()
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	deadline := time.Now().Add(within)
+	expiring := make([]*ct.CertificateSummary, 0, len(certs))
+	for _, cert := range certs {
+		if cert.ExpiresAt != nil && !cert.ExpiresAt.After(deadline) {
+			expiring = append(expiring, cert)
+		}
+	}
+	httphelper.JSON(w, 200, expiring)
+}
+
+func (c *controllerAPI) certificateInventory() ([]*ct.CertificateSummary, error) {
+	routes, err := c.routeRepo.List("")
+	if err != nil {
+		return nil, err
+	}
+	managed, err := c.managedCertificateRepo.List()
+	if err != nil {
+		return nil, err
+	}
+	return certificateInventory(routes, managed), nil
+}
+
+// certificateInventory returns summaries of the certificates of the given
+// routes and managed certificates. Issued managed certificates are matched
+// to route certificates by fingerprint, and those not yet issued to routes
+// by domain.
+func certificateInventory(routes []*router.Route, managed []*ct.ManagedCertificate) []*ct.CertificateSummary {
+	var certs []*ct.CertificateSummary
+	byKey := make(map[string]*ct.CertificateSummary)
+	byManagedDomain := make(map[string]*ct.CertificateSummary)
+
+	for _, m := range managed {
+		var cert *ct.CertificateSummary
+		if m.Cert != "" {
+			cert = summarizeCertificate(m.Cert)
+			byKey[certificateKey(cert, "managed:"+m.ID)] = cert
+		} else {
+			cert = &ct.CertificateSummary{Domains: []string{m.Domain}}
+			byManagedDomain[strings.ToLower(m.Domain)] = cert
+		}
+		cert.ManagedCertificateID = m.ID
+		cert.Status = m.Status
+		certs = append(certs, cert)
+	}
+
+	for _, r := range routes {
+		if r.Type != "http" {
+			continue
+		}
+		var cert *ct.CertificateSummary
+		if r.Certificate != nil && r.Certificate.Cert != "" {
+			summary := summarizeCertificate(r.Certificate.Cert)
+			key := certificateKey(summary, r.Certificate.ID)
+			if cert = byKey[key]; cert == nil {
+				cert = summary
+				byKey[key] = cert
+				certs = append(certs, cert)
+			}
+			if cert.ID == "" {
+				cert.ID = r.Certificate.ID
+			}
+		} else if r.ManagedCertificateDomain != nil {
+			if cert = byManagedDomain[strings.ToLower(*r.ManagedCertificateDomain)]; cert == nil {
+				continue
+			}
+		} else {
+			continue
+		}
+		route := &ct.CertificateRoute{
+			ID:     r.FormattedID(),
+			Domain: r.Domain,
+			Path:   r.Path,
+		}
+		if strings.HasPrefix(r.ParentRef, ct.RouteParentRefPrefix) {
+			route.AppID = strings.TrimPrefix(r.ParentRef, ct.RouteParentRefPrefix)
+		}
+		cert.Routes = append(cert.Routes, route)
+	}
+
+	// order by expiry, with certificates which have not been issued last
+	sort.SliceStable(certs, func(i, j int) bool {
+		a, b := certs[i].ExpiresAt, certs[j].ExpiresAt
+		if a == nil || b == nil {
+			return a != nil
+		}
+		return a.Before(*b)
+	})
+	return certs
+}
+
+// certificateKey returns the key identifying a certificate, which is its
+// fingerprint or, if it could not be parsed, the given ID.
+func certificateKey(cert *ct.CertificateSummary, id string) string {
+	if cert.SHA256 != "" {
+		return cert.SHA256
+	}
+	return id
+}
+
+// summarizeCertificate returns a summary of the leaf certificate in the
+// given PEM encoded chain, with no details if it cannot be parsed.
+func summarizeCertificate(chain string) *ct.CertificateSummary {
+	summary := &ct.CertificateSummary{Domains: []string{}}
+	block, _ := pem.Decode([]byte(chain))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return summary
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return summary
+	}
+	fingerprint := sha256.Sum256(cert.Raw)
+	summary.SHA256 = hex.EncodeToString(fingerprint[:])
+	summary.Issuer = cert.Issuer.String()
+	summary.NotBefore = &cert.NotBefore
+	summary.ExpiresAt = &cert.NotAfter
+	if len(cert.DNSNames) > 0 {
+		summary.Domains = cert.DNSNames
+	} else if cert.Subject.CommonName != "" {
+		summary.Domains = []string{cert.Subject.CommonName}
+	}
+	return summary
+}
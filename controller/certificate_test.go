@@ -0,0 +1,98 @@
+package main
+
+import (
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/tlscert"
+	router "github.com/flynn/flynn/router/types"
+	. "github.com/flynn/go-check"
+)
+
+func (s *S) TestListCertificates(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "list-certificates"})
+	cert, err := tlscert.Generate([]string{"certs.example.com"})
+	c.Assert(err, IsNil)
+	for _, path := range []string{"", "/api/"} {
+		s.createTestRoute(c, app.ID, router.HTTPRoute{
+			Domain:  "certs.example.com",
+			Path:    path,
+			Service: "list-certificates-web",
+			Certificate: &router.Certificate{
+				Cert: cert.Cert,
+				Key:  cert.PrivateKey,
+			},
+		}.ToRoute())
+	}
+
+	find := func(certs []*ct.CertificateSummary) *ct.CertificateSummary {
+		for _, cert := range certs {
+			if len(cert.Domains) == 1 && cert.Domains[0] == "certs.example.com" {
+				return cert
+			}
+		}
+		return nil
+	}
+
+	certs, err := s.c.ListCertificates()
+	c.Assert(err, IsNil)
+	summary := find(certs)
+	c.Assert(summary, NotNil)
+	c.Assert(summary.ID, Not(Equals), "")
+	c.Assert(summary.Managed(), Equals, false)
+	c.Assert(summary.SHA256, HasLen, 64)
+	c.Assert(summary.ExpiresAt, NotNil)
+	c.Assert(summary.Routes, HasLen, 2)
+	for _, route := range summary.Routes {
+		c.Assert(route.AppID, Equals, app.ID)
+		c.Assert(route.Domain, Equals, "certs.example.com")
+	}
+
+	certs, err = s.c.ListExpiringCertificates(0)
+	c.Assert(err, IsNil)
+	c.Assert(find(certs), IsNil)
+
+	certs, err = s.c.ListExpiringCertificates(summary.ExpiresAt.Sub(time.Now()) + time.Hour)
+	c.Assert(err, IsNil)
+	c.Assert(find(certs), NotNil)
+}
+
+func (s *S) TestCertificateInventory(c *C) {
+	issued, err := tlscert.Generate([]string{"issued.example.com"})
+	c.Assert(err, IsNil)
+	issuedDomain := "issued.example.com"
+	pendingDomain := "pending.example.com"
+
+	routes := []*router.Route{
+		router.HTTPRoute{
+			ID:                       "1",
+			Domain:                   issuedDomain,
+			ManagedCertificateDomain: &issuedDomain,
+			Certificate:              &router.Certificate{ID: "cert-1", Cert: issued.Cert},
+		}.ToRoute(),
+		router.HTTPRoute{ID: "2", Domain: pendingDomain, ManagedCertificateDomain: &pendingDomain}.ToRoute(),
+		router.HTTPRoute{ID: "3", Domain: "plain.example.com"}.ToRoute(),
+		router.TCPRoute{ID: "4"}.ToRoute(),
+	}
+	managed := []*ct.ManagedCertificate{
+		{ID: "managed-pending", Domain: pendingDomain, Status: ct.ManagedCertificateStatusPending},
+		{ID: "managed-issued", Domain: issuedDomain, Status: ct.ManagedCertificateStatusIssued, Cert: issued.Cert},
+	}
+
+	certs := certificateInventory(routes, managed)
+	c.Assert(certs, HasLen, 2)
+
+	// issued certificates are listed first and matched to the route using
+	// them by fingerprint
+	c.Assert(certs[0].ManagedCertificateID, Equals, "managed-issued")
+	c.Assert(certs[0].ID, Equals, "cert-1")
+	c.Assert(certs[0].Domains, DeepEquals, []string{issuedDomain})
+	c.Assert(certs[0].Routes, HasLen, 1)
+	c.Assert(certs[0].Routes[0].ID, Equals, "http/1")
+
+	c.Assert(certs[1].ManagedCertificateID, Equals, "managed-pending")
+	c.Assert(certs[1].Status, Equals, ct.ManagedCertificateStatusPending)
+	c.Assert(certs[1].ExpiresAt, IsNil)
+	c.Assert(certs[1].Routes, HasLen, 1)
+	c.Assert(certs[1].Routes[0].ID, Equals, "http/2")
+}
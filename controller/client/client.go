@@ -14,9 +14,11 @@ import (
 	"github.com/flynn/flynn/pkg/httpclient"
 	"github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/pinned"
+	"github.com/flynn/flynn/pkg/sse"
 	"github.com/flynn/flynn/pkg/status"
 	"github.com/flynn/flynn/pkg/stream"
 	router "github.com/flynn/flynn/router/types"
+	"golang.org/x/net/context"
 )
 
 type Client interface {
@@ -39,6 +41,7 @@ type Client interface {
 	AddResourceApp(providerID, resourceID, appID string) (*ct.Resource, error)
 	DeleteResourceApp(providerID, resourceID, appID string) (*ct.Resource, error)
 	AppResourceList(appID string) ([]*ct.Resource, error)
+	ResolveSecretEnv(appID, jobID string, env map[string]string) (map[string]string, error)
 	PutResource(resource *ct.Resource) error
 	DeleteResource(providerID, resourceID string) (*ct.Resource, error)
 	PutFormation(formation *ct.Formation) error
@@ -47,11 +50,15 @@ type Client interface {
 	DeleteJob(appID, jobID string) error
 	SetAppRelease(appID, releaseID string) error
 	GetAppRelease(appID string) (*ct.Release, error)
+	GetAppEnvDiff(appID, other string) (*ct.EnvDiff, error)
 	RouteList() ([]*router.Route, error)
 	AppRouteList(appID string) ([]*router.Route, error)
 	GetRoute(appID string, routeID string) (*router.Route, error)
 	CreateRoute(appID string, route *router.Route) error
 	UpdateRoute(appID string, routeID string, route *router.Route) error
+	PatchRoute(appID string, routeID string, patch *router.RoutePatch) (*router.Route, error)
+	CreateRouteDryRun(appID string, route *router.Route) (*ct.RouteDryRun, error)
+	UpdateRouteDryRun(appID string, routeID string, route *router.Route) (*ct.RouteDryRun, error)
 	DeleteRoute(appID string, routeID string) error
 	GetFormation(appID, releaseID string) (*ct.Formation, error)
 	GetExpandedFormation(appID, releaseID string) (*ct.ExpandedFormation, error)
@@ -79,7 +86,11 @@ type Client interface {
 	RunJobDetached(appID string, req *ct.NewJob) (*ct.Job, error)
 	GetJob(appID, jobID string) (*ct.Job, error)
 	JobList(appID string) ([]*ct.Job, error)
+	JobPlacements(appID string, since, until time.Time) ([]*ct.JobPlacement, error)
+	AppStats(appID string, resolution ct.AppStatsResolution, since time.Time) (*ct.AppStatsRollup, error)
 	JobListActive() ([]*ct.Job, error)
+	ClusterReport() (*ct.ClusterReport, error)
+	UpdateHistory() (*ct.UpdateHistory, error)
 	AppList() ([]*ct.App, error)
 	ArtifactList() ([]*ct.Artifact, error)
 	ReleaseList() ([]*ct.Release, error)
@@ -90,17 +101,30 @@ type Client interface {
 	GetVolume(appID, volID string) (*ct.Volume, error)
 	PutVolume(vol *ct.Volume) error
 	DecommissionVolume(appID string, vol *ct.Volume) error
+	GetExpandedVolume(appID, volID string) (*ct.ExpandedVolume, error)
+	CreateVolumeSnapshot(appID, volID string) (*ct.VolumeSnapshot, error)
+	RestoreVolume(appID, volID, snapshotID string) (*ct.Volume, error)
 	StreamVolumes(since *time.Time, output chan *ct.Volume) (stream.Stream, error)
 	Backup() (io.ReadCloser, error)
 	GetBackupMeta() (*ct.ClusterBackup, error)
 	DeleteRelease(appID, releaseID string) (*ct.ReleaseDeletion, error)
 	ScheduleAppGarbageCollection(appID string) error
+	CloneApp(appID string, req *ct.AppCloneRequest) (*ct.AppClone, error)
+	CreateReviewApp(appID string, req *ct.ReviewAppRequest) (*ct.ReviewApp, error)
+	ReviewAppList(appID string) ([]*ct.ReviewApp, error)
+	DeleteReviewApp(appID, branch string) (*ct.ReviewApp, error)
 	Status() (*status.Status, error)
 	CreateSink(sink *ct.Sink) error
 	GetSink(sinkID string) (*ct.Sink, error)
 	DeleteSink(sinkID string) (*ct.Sink, error)
 	ListSinks() ([]*ct.Sink, error)
 	StreamSinks(since *time.Time, output chan *ct.Sink) (stream.Stream, error)
+	CreateEventSink(sink *ct.EventSink) error
+	GetEventSink(sinkID string) (*ct.EventSink, error)
+	DeleteEventSink(sinkID string) (*ct.EventSink, error)
+	ListEventSinks() ([]*ct.EventSink, error)
+	ListCertificates() ([]*ct.CertificateSummary, error)
+	ListExpiringCertificates(within time.Duration) ([]*ct.CertificateSummary, error)
 	ListManagedCertificates() ([]*ct.ManagedCertificate, error)
 	GetManagedCertificate(certID string) (*ct.ManagedCertificate, error)
 	UpdateManagedCertificate(cert *ct.ManagedCertificate) error
@@ -108,6 +132,30 @@ type Client interface {
 	GetACMEConfig() (*ct.ACMEConfig, error)
 	GetACMEConfigInternal() (*ct.ACMEConfig, error)
 	UpdateACMEConfig(config *ct.ACMEConfig) error
+	PutACMEChallenge(challenge *ct.ACMEChallenge) error
+	GetACMEChallenge(token string) (*ct.ACMEChallenge, error)
+	DeleteACMEChallenge(token string) error
+
+	// context-aware variants which abort once the context is done
+	GetAppCtx(ctx context.Context, appID string) (*ct.App, error)
+	AppListCtx(ctx context.Context) ([]*ct.App, error)
+	GetAppReleaseCtx(ctx context.Context, appID string) (*ct.Release, error)
+	GetReleaseCtx(ctx context.Context, releaseID string) (*ct.Release, error)
+	CreateReleaseCtx(ctx context.Context, appID string, release *ct.Release) error
+	GetArtifactCtx(ctx context.Context, artifactID string) (*ct.Artifact, error)
+	CreateArtifactCtx(ctx context.Context, artifact *ct.Artifact) error
+	GetFormationCtx(ctx context.Context, appID, releaseID string) (*ct.Formation, error)
+	PutFormationCtx(ctx context.Context, formation *ct.Formation) error
+	GetDeploymentCtx(ctx context.Context, deploymentID string) (*ct.Deployment, error)
+	CreateDeploymentCtx(ctx context.Context, appID, releaseID string) (*ct.Deployment, error)
+	DeployAppReleaseCtx(ctx context.Context, appID, releaseID string) error
+	ScaleAppReleaseCtx(ctx context.Context, appID, releaseID string, opts ct.ScaleOptions) error
+	GetJobCtx(ctx context.Context, appID, jobID string) (*ct.Job, error)
+	JobListCtx(ctx context.Context, appID string) ([]*ct.Job, error)
+	GetAppLogCtx(ctx context.Context, appID string, options *logagg.LogOpts) (io.ReadCloser, error)
+	StreamEventsCtx(ctx context.Context, opts ct.StreamEventsOptions, output chan *ct.Event) (stream.Stream, error)
+	StreamJobEventsCtx(ctx context.Context, appID string, output chan *ct.Job) (stream.Stream, error)
+	StreamDeploymentCtx(ctx context.Context, d *ct.Deployment, output chan *ct.DeploymentEvent) (stream.Stream, error)
 }
 
 type Config struct {
@@ -126,6 +174,11 @@ func newClient(key string, url string, http *http.Client) *v1controller.Client {
 			Key:         key,
 			URL:         url,
 			HTTP:        http,
+
+			// treat a stream as dead once several keep-alives have
+			// been missed so that consumers reconnect to a healthy
+			// controller instance
+			StreamIdleTimeout: 3 * sse.KeepAliveInterval,
 		},
 	}
 	return c
@@ -148,6 +201,16 @@ func NewClientWithHTTP(uri, key string, httpClient *http.Client) (Client, error)
 	return newClient(key, u.String(), httpClient), nil
 }
 
+// WithContext returns a copy of c which makes requests with ctx, sending the
+// request ID and trace context it carries with each request and aborting
+// them once it is done, if c supports it.
+func WithContext(ctx context.Context, c Client) Client {
+	if v1, ok := c.(*v1controller.Client); ok {
+		return v1.WithContext(ctx)
+	}
+	return c
+}
+
 // NewClientWithConfig acts like NewClient, but supports custom configuration.
 func NewClientWithConfig(uri, key string, config Config) (Client, error) {
 	if config.Pin == nil {
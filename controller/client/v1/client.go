@@ -269,6 +269,15 @@ func (c *Client) AppResourceList(appID string) ([]*ct.Resource, error) {
 	return resources, c.Get(fmt.Sprintf("/apps/%s/resources", appID), &resources)
 }
 
+// ResolveSecretEnv resolves the secret env references (see
+// ct.SecretEnvRefPrefix) of a job which is about to be started, returning the
+// resolved env.
+func (c *Client) ResolveSecretEnv(appID, jobID string, env map[string]string) (map[string]string, error) {
+	var res map[string]string
+	req := &ct.SecretEnvRequest{JobID: jobID, Env: env}
+	return res, c.Post(fmt.Sprintf("/apps/%s/secret-env", appID), req, &res)
+}
+
 // PutResource updates a resource.
 func (c *Client) PutResource(resource *ct.Resource) error {
 	if resource.ID == "" || resource.ProviderID == "" {
@@ -328,6 +337,7 @@ func (c *Client) ScaleAppRelease(appID, releaseID string, opts ct.ScaleOptions)
 	if opts.Tags != nil {
 		scaleReq.NewTags = &opts.Tags
 	}
+	scaleReq.TraceParent = opts.TraceParent
 	if err := c.PutScaleRequest(scaleReq); err != nil {
 		return err
 	}
@@ -417,6 +427,13 @@ func (c *Client) GetAppRelease(appID string) (*ct.Release, error) {
 	return release, c.Get(fmt.Sprintf("/apps/%s/release", appID), release)
 }
 
+// GetAppEnvDiff compares the env of an app's current release with the current
+// release of another app, or a release, with the values of secrets redacted.
+func (c *Client) GetAppEnvDiff(appID, other string) (*ct.EnvDiff, error) {
+	diff := &ct.EnvDiff{}
+	return diff, c.Get(fmt.Sprintf("/apps/%s/env-diff?other=%s", appID, url.QueryEscape(other)), diff)
+}
+
 // RouteList returns all routes.
 func (c *Client) RouteList() ([]*router.Route, error) {
 	var routes []*router.Route
@@ -445,6 +462,28 @@ func (c *Client) UpdateRoute(appID string, routeID string, route *router.Route)
 	return c.Put(fmt.Sprintf("/apps/%s/routes/%s", appID, routeID), route, route)
 }
 
+// PatchRoute updates only the fields of the route which are set in the patch,
+// returning the updated route. If the patch sets UpdatedAt and the route has
+// been updated since, an error with the ConflictErrorCode is returned.
+func (c *Client) PatchRoute(appID string, routeID string, patch *router.RoutePatch) (*router.Route, error) {
+	var route router.Route
+	return &route, c.Send("PATCH", fmt.Sprintf("/apps/%s/routes/%s", appID, routeID), patch, &route)
+}
+
+// CreateRouteDryRun returns the impact of creating the route under the
+// specified app without creating it.
+func (c *Client) CreateRouteDryRun(appID string, route *router.Route) (*ct.RouteDryRun, error) {
+	var res ct.RouteDryRun
+	return &res, c.Post(fmt.Sprintf("/apps/%s/routes?dry_run=true", appID), route, &res)
+}
+
+// UpdateRouteDryRun returns the impact of updating the route under the
+// specified app without updating it.
+func (c *Client) UpdateRouteDryRun(appID string, routeID string, route *router.Route) (*ct.RouteDryRun, error) {
+	var res ct.RouteDryRun
+	return &res, c.Put(fmt.Sprintf("/apps/%s/routes/%s?dry_run=true", appID, routeID), route, &res)
+}
+
 // DeleteRoute deletes a route under the specified app.
 func (c *Client) DeleteRoute(appID string, routeID string) error {
 	return c.Delete(fmt.Sprintf("/apps/%s/routes/%s", appID, routeID), nil)
@@ -630,6 +669,8 @@ func (c *Client) waitDeploymentTerminal(d *ct.Deployment, stopWait <-chan struct
 		select {
 		case <-stopWait:
 			return errors.New("deploy wait cancelled")
+		case <-c.Context().Done():
+			return c.Context().Err()
 		case <-tick.C:
 			dep, err := c.GetDeployment(d.ID)
 			if err != nil {
@@ -670,7 +711,7 @@ func (c *Client) deploymentFailedMessage(d *ct.Deployment) error {
 			continue
 		}
 		if de.Status == "failed" && de.Error != "" {
-			return errors.New(de.Error)
+			return de.Err()
 		}
 	}
 	return errors.New("deployment failed")
@@ -813,6 +854,40 @@ func (c *Client) JobList(appID string) ([]*ct.Job, error) {
 	return jobs, c.Get(fmt.Sprintf("/apps/%s/jobs", appID), &jobs)
 }
 
+// JobPlacements returns the hosts an app's jobs ran on between since and
+// until.
+func (c *Client) JobPlacements(appID string, since, until time.Time) ([]*ct.JobPlacement, error) {
+	q := make(url.Values)
+	q.Set("since", since.Format(time.RFC3339Nano))
+	q.Set("until", until.Format(time.RFC3339Nano))
+	var placements []*ct.JobPlacement
+	return placements, c.Get(fmt.Sprintf("/apps/%s/job-placements?%s", appID, q.Encode()), &placements)
+}
+
+// AppStats returns the usage of an app's jobs since the given time rolled up
+// over each hour or day.
+func (c *Client) AppStats(appID string, resolution ct.AppStatsResolution, since time.Time) (*ct.AppStatsRollup, error) {
+	q := make(url.Values)
+	q.Set("resolution", string(resolution))
+	q.Set("since", since.Format(time.RFC3339Nano))
+	rollup := &ct.AppStatsRollup{}
+	return rollup, c.Get(fmt.Sprintf("/apps/%s/stats?%s", appID, q.Encode()), rollup)
+}
+
+// ClusterReport returns a summary of the cluster's hosts, resources, apps,
+// jobs and volumes.
+func (c *Client) ClusterReport() (*ct.ClusterReport, error) {
+	report := &ct.ClusterReport{}
+	return report, c.Get("/cluster/report", report)
+}
+
+// UpdateHistory returns the updates run from any of the cluster's hosts,
+// oldest first.
+func (c *Client) UpdateHistory() (*ct.UpdateHistory, error) {
+	history := &ct.UpdateHistory{}
+	return history, c.Get("/cluster/update-history", history)
+}
+
 // JobListActive returns a list of all active jobs.
 func (c *Client) JobListActive() ([]*ct.Job, error) {
 	var jobs []*ct.Job
@@ -885,6 +960,45 @@ func (c *Client) AppVolumeList(appID string) ([]*ct.Volume, error) {
 }
 
 // DecommissionVolume decommissions a volume
+// GetExpandedVolume returns a volume along with its disk usage and
+// snapshots.
+func (c *Client) GetExpandedVolume(appID, id string) (*ct.ExpandedVolume, error) {
+	if appID == "" {
+		return nil, errors.New("controller: missing app ID")
+	}
+	if id == "" {
+		return nil, errors.New("controller: missing id")
+	}
+	vol := &ct.ExpandedVolume{}
+	return vol, c.Get(fmt.Sprintf("/apps/%s/volumes/%s?expand=true", appID, id), vol)
+}
+
+// CreateVolumeSnapshot creates a snapshot of a volume.
+func (c *Client) CreateVolumeSnapshot(appID, id string) (*ct.VolumeSnapshot, error) {
+	if appID == "" {
+		return nil, errors.New("controller: missing app ID")
+	}
+	if id == "" {
+		return nil, errors.New("controller: missing id")
+	}
+	snap := &ct.VolumeSnapshot{}
+	return snap, c.Post(fmt.Sprintf("/apps/%s/volumes/%s/snapshots", appID, id), nil, snap)
+}
+
+// RestoreVolume restores a volume from one of its snapshots, returning the
+// restored volume which replaces it.
+func (c *Client) RestoreVolume(appID, id, snapshotID string) (*ct.Volume, error) {
+	if appID == "" {
+		return nil, errors.New("controller: missing app ID")
+	}
+	if id == "" {
+		return nil, errors.New("controller: missing id")
+	}
+	vol := &ct.Volume{}
+	req := &ct.VolumeRestoreRequest{SnapshotID: snapshotID}
+	return vol, c.Post(fmt.Sprintf("/apps/%s/volumes/%s/restore", appID, id), req, vol)
+}
+
 func (c *Client) DecommissionVolume(appID string, vol *ct.Volume) error {
 	if appID == "" {
 		return errors.New("controller: missing app ID")
@@ -961,6 +1075,33 @@ func (c *Client) ScheduleAppGarbageCollection(appID string) error {
 	return c.Post(fmt.Sprintf("/apps/%s/gc", appID), nil, nil)
 }
 
+// CloneApp creates a new app with a copy of the app's current release,
+// formation, resources and routes.
+func (c *Client) CloneApp(appID string, req *ct.AppCloneRequest) (*ct.AppClone, error) {
+	res := &ct.AppClone{}
+	return res, c.Post(fmt.Sprintf("/apps/%s/clone", appID), req, res)
+}
+
+// CreateReviewApp creates a review app for a branch of the app, or extends
+// the TTL of the branch's existing review app.
+func (c *Client) CreateReviewApp(appID string, req *ct.ReviewAppRequest) (*ct.ReviewApp, error) {
+	res := &ct.ReviewApp{}
+	return res, c.Post(fmt.Sprintf("/apps/%s/review-apps", appID), req, res)
+}
+
+// ReviewAppList returns the review apps of the app.
+func (c *Client) ReviewAppList(appID string) ([]*ct.ReviewApp, error) {
+	var apps []*ct.ReviewApp
+	return apps, c.Get(fmt.Sprintf("/apps/%s/review-apps", appID), &apps)
+}
+
+// DeleteReviewApp deletes the review app of a branch of the app along with
+// its resources.
+func (c *Client) DeleteReviewApp(appID, branch string) (*ct.ReviewApp, error) {
+	res := &ct.ReviewApp{}
+	return res, c.Delete(fmt.Sprintf("/apps/%s/review-apps?branch=%s", appID, url.QueryEscape(branch)), res)
+}
+
 // Status gets the controller status
 func (c *Client) Status() (*status.Status, error) {
 	type statusResponse struct {
@@ -1007,6 +1148,43 @@ func (c *Client) StreamSinks(since *time.Time, output chan *ct.Sink) (stream.Str
 	return c.Stream("GET", "/sinks?since="+t, nil, output)
 }
 
+// CreateEventSink creates a new event sink
+func (c *Client) CreateEventSink(sink *ct.EventSink) error {
+	return c.Post("/event-sinks", sink, sink)
+}
+
+// GetEventSink gets an event sink
+func (c *Client) GetEventSink(sinkID string) (*ct.EventSink, error) {
+	sink := &ct.EventSink{}
+	return sink, c.Get(fmt.Sprintf("/event-sinks/%s", sinkID), sink)
+}
+
+// DeleteEventSink removes an event sink
+func (c *Client) DeleteEventSink(sinkID string) (*ct.EventSink, error) {
+	sink := &ct.EventSink{}
+	return sink, c.Delete(fmt.Sprintf("/event-sinks/%s", sinkID), sink)
+}
+
+// ListEventSinks returns all event sinks
+func (c *Client) ListEventSinks() ([]*ct.EventSink, error) {
+	var sinks []*ct.EventSink
+	return sinks, c.Get("/event-sinks", &sinks)
+}
+
+// ListCertificates returns the manual and managed certificates of HTTP
+// routes, ordered by expiry
+func (c *Client) ListCertificates() ([]*ct.CertificateSummary, error) {
+	var certs []*ct.CertificateSummary
+	return certs, c.Get("/certificates", &certs)
+}
+
+// ListExpiringCertificates returns the certificates of HTTP routes which
+// expire within the given duration
+func (c *Client) ListExpiringCertificates(within time.Duration) ([]*ct.CertificateSummary, error) {
+	var certs []*ct.CertificateSummary
+	return certs, c.Get(fmt.Sprintf("/certificates/expiring?within=%s", within), &certs)
+}
+
 // ListManagedCertificates returns all managed certificates
 func (c *Client) ListManagedCertificates() ([]*ct.ManagedCertificate, error) {
 	var certs []*ct.ManagedCertificate
@@ -1055,6 +1233,23 @@ func (c *Client) UpdateACMEConfig(config *ct.ACMEConfig) error {
 	return c.Put("/acme/config", config, config)
 }
 
+// PutACMEChallenge stores a pending ACME HTTP-01 challenge response
+func (c *Client) PutACMEChallenge(challenge *ct.ACMEChallenge) error {
+	return c.Put(fmt.Sprintf("/acme/challenges/%s", challenge.Token), challenge, challenge)
+}
+
+// GetACMEChallenge returns the pending ACME HTTP-01 challenge response for
+// the given token
+func (c *Client) GetACMEChallenge(token string) (*ct.ACMEChallenge, error) {
+	challenge := &ct.ACMEChallenge{}
+	return challenge, c.Get(fmt.Sprintf("/acme/challenges/%s", token), challenge)
+}
+
+// DeleteACMEChallenge removes a pending ACME HTTP-01 challenge response
+func (c *Client) DeleteACMEChallenge(token string) error {
+	return c.Delete(fmt.Sprintf("/acme/challenges/%s", token), nil)
+}
+
 func (c *Client) Put(path string, in, out interface{}) error {
 	return c.send("PUT", path, in, out)
 }
@@ -1074,7 +1269,7 @@ func (c *Client) Delete(path string, out interface{}) error {
 func (c *Client) send(method, path string, in, out interface{}) (err error) {
 	for startTime := time.Now(); time.Since(startTime) < 10*time.Second; time.Sleep(100 * time.Millisecond) {
 		err = c.Send(method, path, in, out)
-		if !httphelper.IsRetryableError(err) {
+		if !httphelper.IsRetryableError(err) || c.Context().Err() != nil {
 			break
 		}
 	}
@@ -0,0 +1,151 @@
+package v1controller
+
+import (
+	"io"
+
+	ct "github.com/flynn/flynn/controller/types"
+	logagg "github.com/flynn/flynn/logaggregator/types"
+	"github.com/flynn/flynn/pkg/stream"
+	"golang.org/x/net/context"
+)
+
+// WithContext returns a copy of the client which makes requests with the
+// given context, so that all calls, including streams and waits, are
+// aborted once the context is cancelled or its deadline expires.
+//
+// The *Ctx methods are shorthands for calling a single method on such a
+// copy, and return the context's error if they were aborted.
+func (c *Client) WithContext(ctx context.Context) *Client {
+	return &Client{Client: c.Client.WithContext(ctx)}
+}
+
+// ctxErr returns the context's error if err was caused by the context being
+// done, otherwise err.
+func ctxErr(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func (c *Client) GetAppCtx(ctx context.Context, appID string) (*ct.App, error) {
+	app, err := c.WithContext(ctx).GetApp(appID)
+	return app, ctxErr(ctx, err)
+}
+
+func (c *Client) AppListCtx(ctx context.Context) ([]*ct.App, error) {
+	apps, err := c.WithContext(ctx).AppList()
+	return apps, ctxErr(ctx, err)
+}
+
+func (c *Client) GetAppReleaseCtx(ctx context.Context, appID string) (*ct.Release, error) {
+	release, err := c.WithContext(ctx).GetAppRelease(appID)
+	return release, ctxErr(ctx, err)
+}
+
+func (c *Client) GetReleaseCtx(ctx context.Context, releaseID string) (*ct.Release, error) {
+	release, err := c.WithContext(ctx).GetRelease(releaseID)
+	return release, ctxErr(ctx, err)
+}
+
+func (c *Client) CreateReleaseCtx(ctx context.Context, appID string, release *ct.Release) error {
+	return ctxErr(ctx, c.WithContext(ctx).CreateRelease(appID, release))
+}
+
+func (c *Client) GetArtifactCtx(ctx context.Context, artifactID string) (*ct.Artifact, error) {
+	artifact, err := c.WithContext(ctx).GetArtifact(artifactID)
+	return artifact, ctxErr(ctx, err)
+}
+
+func (c *Client) CreateArtifactCtx(ctx context.Context, artifact *ct.Artifact) error {
+	return ctxErr(ctx, c.WithContext(ctx).CreateArtifact(artifact))
+}
+
+func (c *Client) GetFormationCtx(ctx context.Context, appID, releaseID string) (*ct.Formation, error) {
+	formation, err := c.WithContext(ctx).GetFormation(appID, releaseID)
+	return formation, ctxErr(ctx, err)
+}
+
+func (c *Client) PutFormationCtx(ctx context.Context, formation *ct.Formation) error {
+	return ctxErr(ctx, c.WithContext(ctx).PutFormation(formation))
+}
+
+func (c *Client) GetDeploymentCtx(ctx context.Context, deploymentID string) (*ct.Deployment, error) {
+	deployment, err := c.WithContext(ctx).GetDeployment(deploymentID)
+	return deployment, ctxErr(ctx, err)
+}
+
+func (c *Client) CreateDeploymentCtx(ctx context.Context, appID, releaseID string) (*ct.Deployment, error) {
+	deployment, err := c.WithContext(ctx).CreateDeployment(appID, releaseID)
+	return deployment, ctxErr(ctx, err)
+}
+
+// DeployAppReleaseCtx is like DeployAppRelease but stops waiting for the
+// deployment to finish once ctx is done. The deployment itself is not
+// cancelled.
+func (c *Client) DeployAppReleaseCtx(ctx context.Context, appID, releaseID string) error {
+	return ctxErr(ctx, c.WithContext(ctx).DeployAppRelease(appID, releaseID, ctx.Done()))
+}
+
+// ScaleAppReleaseCtx is like ScaleAppRelease but stops waiting for the
+// scale to complete once ctx is done, in addition to opts.Stop and
+// opts.Timeout.
+func (c *Client) ScaleAppReleaseCtx(ctx context.Context, appID, releaseID string, opts ct.ScaleOptions) error {
+	// the context's deadline is not copied to opts.Timeout since the
+	// timeout could then fire before the context is done, instead the
+	// context being done stops the wait like closing opts.Stop
+	stop := opts.Stop
+	opts.Stop = make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stop:
+		case <-done:
+			return
+		}
+		close(opts.Stop)
+	}()
+	err := c.WithContext(ctx).ScaleAppRelease(appID, releaseID, opts)
+	if err == ct.ErrScalingStopped && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return ctxErr(ctx, err)
+}
+
+func (c *Client) GetJobCtx(ctx context.Context, appID, jobID string) (*ct.Job, error) {
+	job, err := c.WithContext(ctx).GetJob(appID, jobID)
+	return job, ctxErr(ctx, err)
+}
+
+func (c *Client) JobListCtx(ctx context.Context, appID string) ([]*ct.Job, error) {
+	jobs, err := c.WithContext(ctx).JobList(appID)
+	return jobs, ctxErr(ctx, err)
+}
+
+func (c *Client) GetAppLogCtx(ctx context.Context, appID string, options *logagg.LogOpts) (io.ReadCloser, error) {
+	log, err := c.WithContext(ctx).GetAppLog(appID, options)
+	return log, ctxErr(ctx, err)
+}
+
+// StreamEventsCtx is like StreamEvents but closes the stream once ctx is
+// done, in which case the stream's Err returns the context's error.
+func (c *Client) StreamEventsCtx(ctx context.Context, opts ct.StreamEventsOptions, output chan *ct.Event) (stream.Stream, error) {
+	s, err := c.WithContext(ctx).StreamEvents(opts, output)
+	return s, ctxErr(ctx, err)
+}
+
+// StreamJobEventsCtx is like StreamJobEvents but closes the stream once ctx
+// is done.
+func (c *Client) StreamJobEventsCtx(ctx context.Context, appID string, output chan *ct.Job) (stream.Stream, error) {
+	s, err := c.WithContext(ctx).StreamJobEvents(appID, output)
+	return s, ctxErr(ctx, err)
+}
+
+// StreamDeploymentCtx is like StreamDeployment but closes the stream once
+// ctx is done.
+func (c *Client) StreamDeploymentCtx(ctx context.Context, d *ct.Deployment, output chan *ct.DeploymentEvent) (stream.Stream, error) {
+	s, err := c.WithContext(ctx).StreamDeployment(d, output)
+	return s, ctxErr(ctx, err)
+}
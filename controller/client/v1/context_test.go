@@ -0,0 +1,94 @@
+package v1controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/httpclient"
+	"golang.org/x/net/context"
+)
+
+// newScaleServer returns a client for a server which accepts scale requests
+// but never completes them, signalling scaled for each request.
+func newScaleServer(scaled chan struct{}) (*Client, func()) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.Method == "GET" && req.URL.Path == "/events":
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			<-req.Context().Done()
+		case req.Method == "PUT" && req.URL.Path == "/apps/app/scale/release":
+			var scaleReq ct.ScaleRequest
+			json.NewDecoder(req.Body).Decode(&scaleReq)
+			scaleReq.ID = "scale"
+			json.NewEncoder(w).Encode(&scaleReq)
+			scaled <- struct{}{}
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	client := &Client{Client: &httpclient.Client{
+		URL:         srv.URL,
+		HTTP:        http.DefaultClient,
+		ErrNotFound: ct.ErrNotFound,
+	}}
+	return client, srv.Close
+}
+
+func TestScaleAppReleaseCtx(t *testing.T) {
+	scaled := make(chan struct{}, 10)
+	client, stop := newScaleServer(scaled)
+	defer stop()
+	opts := func() ct.ScaleOptions {
+		return ct.ScaleOptions{Processes: map[string]int{"web": 1}}
+	}
+	scale := func(ctx context.Context, opts ct.ScaleOptions) error {
+		errs := make(chan error)
+		go func() { errs <- client.ScaleAppReleaseCtx(ctx, "app", "release", opts) }()
+		select {
+		case err := <-errs:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the scale to return")
+			return nil
+		}
+	}
+
+	// cancelling the context returns the context's error rather than
+	// ErrScalingStopped
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-scaled
+		cancel()
+	}()
+	if err := scale(ctx, opts()); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	// as does the context's deadline passing
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := scale(ctx, opts()); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	select {
+	case <-scaled:
+	default:
+	}
+
+	// closing opts.Stop still returns ErrScalingStopped
+	o := opts()
+	o.Stop = make(chan struct{})
+	go func() {
+		<-scaled
+		close(o.Stop)
+	}()
+	if err := scale(context.Background(), o); err != ct.ErrScalingStopped {
+		t.Fatalf("expected ErrScalingStopped, got %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/controller/utils"
+	"github.com/flynn/flynn/host/resource"
+	host "github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"golang.org/x/net/context"
+)
+
+// GetClusterReport returns a summary of the cluster's hosts, resources,
+// apps, jobs and volumes
+func (c *controllerAPI) GetClusterReport(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	report, err := c.clusterReport()
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, report)
+}
+
+func (c *controllerAPI) clusterReport() (*ct.ClusterReport, error) {
+	report := &ct.ClusterReport{
+		GeneratedAt: time.Now(),
+		Versions:    make(map[string][]string),
+		Volumes:     make(map[ct.VolumeState]int),
+	}
+
+	list, err := c.appRepo.List()
+	if err != nil {
+		return nil, err
+	}
+	apps := list.([]*ct.App)
+	report.Apps = len(apps)
+	for _, app := range apps {
+		if app.System() {
+			report.SystemApps++
+		}
+	}
+
+	if report.Jobs, err = c.jobRepo.CountByState(); err != nil {
+		return nil, err
+	}
+
+	volumes, err := c.volumeRepo.List()
+	if err != nil {
+		return nil, err
+	}
+	hostVolumes := make(map[string]int)
+	for _, vol := range volumes {
+		report.Volumes[vol.State]++
+		if vol.State != ct.VolumeStateDestroyed {
+			hostVolumes[vol.HostID]++
+		}
+	}
+
+	hosts, err := c.clusterClient.Hosts()
+	if err != nil {
+		return nil, err
+	}
+	report.Hosts = make([]*ct.ClusterReportHost, len(hosts))
+	var wg sync.WaitGroup
+	for i, h := range hosts {
+		wg.Add(1)
+		go func(i int, h utils.HostClient) {
+			defer wg.Done()
+			report.Hosts[i] = hostReport(h)
+		}(i, h)
+	}
+	wg.Wait()
+
+	sort.Slice(report.Hosts, func(i, j int) bool { return report.Hosts[i].ID < report.Hosts[j].ID })
+	for _, h := range report.Hosts {
+		h.Volumes = hostVolumes[h.ID]
+		if h.Error != "" {
+			continue
+		}
+		report.Resources.Add(h.Resources)
+		report.Versions[h.Version] = append(report.Versions[h.Version], h.ID)
+	}
+	report.Skew = len(report.Versions) > 1
+	return report, nil
+}
+
+// hostReport queries a host for its version, resources and active jobs
+func hostReport(h utils.HostClient) *ct.ClusterReportHost {
+	res := &ct.ClusterReportHost{ID: h.ID(), Addr: h.Addr()}
+
+	status, err := h.GetStatus()
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Version = status.Version
+
+	stats, err := h.GetStats()
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Resources = ct.ClusterReportResources{
+		CPUCount:         stats.CPUCount,
+		MemoryTotalBytes: int64(stats.MemoryTotalBytes),
+		MemoryUsedBytes:  int64(stats.MemoryUsedBytes),
+		DiskTotalBytes:   int64(stats.DiskTotalBytes),
+		DiskUsedBytes:    int64(stats.DiskUsedBytes),
+	}
+
+	jobs, err := h.ListActiveJobs()
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	for _, job := range jobs {
+		if (job.Status != host.StatusStarting && job.Status != host.StatusRunning) || job.Job == nil {
+			continue
+		}
+		res.Jobs++
+		if spec, ok := job.Job.Resources[resource.TypeMemory]; ok {
+			if spec.Request != nil {
+				res.Resources.AllocatedMemoryBytes += *spec.Request
+			} else if spec.Limit != nil {
+				res.Resources.AllocatedMemoryBytes += *spec.Limit
+			}
+		}
+		if spec, ok := job.Job.Resources[resource.TypeCPU]; ok && spec.Limit != nil {
+			res.Resources.AllocatedMilliCPU += *spec.Limit
+		}
+	}
+	return res
+}
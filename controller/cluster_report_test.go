@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/docker/go-units"
+	tu "github.com/flynn/flynn/controller/testutils"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/host/resource"
+	host "github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/pkg/random"
+	. "github.com/flynn/go-check"
+)
+
+func (s *S) TestClusterReport(c *C) {
+	s.createTestApp(c, &ct.App{Name: "cluster-report"})
+
+	memory := int64(256 * units.MiB)
+	cpu := int64(500)
+	hc := tu.NewFakeHostClient("report-host1", false)
+	hc.AddJob(&host.Job{
+		ID: random.UUID(),
+		Resources: resource.Resources{
+			resource.TypeMemory: resource.Spec{Request: &memory, Limit: &memory},
+			resource.TypeCPU:    resource.Spec{Limit: &cpu},
+		},
+	})
+	s.cc.AddHost(hc)
+	unhealthy := tu.NewFakeHostClient("report-host2", false)
+	unhealthy.Healthy = false
+	s.cc.AddHost(unhealthy)
+
+	report, err := s.c.ClusterReport()
+	c.Assert(err, IsNil)
+	c.Assert(report.Apps > 0, Equals, true)
+	c.Assert(report.Hosts, HasLen, 2)
+
+	// hosts are sorted by ID, with errors reported for unreachable hosts
+	h := report.Hosts[0]
+	c.Assert(h.ID, Equals, "report-host1")
+	c.Assert(h.Error, Equals, "")
+	c.Assert(h.Jobs, Equals, 1)
+	c.Assert(h.Resources.AllocatedMemoryBytes, Equals, memory)
+	c.Assert(h.Resources.AllocatedMilliCPU, Equals, cpu)
+	c.Assert(report.Hosts[1].ID, Equals, "report-host2")
+	c.Assert(report.Hosts[1].Error, Not(Equals), "")
+
+	// totals only include hosts which reported their resources
+	c.Assert(report.Resources.AllocatedMemoryBytes, Equals, memory)
+	c.Assert(report.Versions, HasLen, 1)
+	c.Assert(report.Skew, Equals, false)
+}
+
+func (s *S) TestClusterVersions(c *C) {
+	s.cc.AddHost(tu.NewFakeHostClient("versions-host1", false))
+	unhealthy := tu.NewFakeHostClient("versions-host2", false)
+	unhealthy.Healthy = false
+	s.cc.AddHost(unhealthy)
+
+	req, err := http.NewRequest("GET", s.srv.URL+"/cluster/versions", nil)
+	c.Assert(err, IsNil)
+	req.SetBasicAuth("", authKey)
+	res, err := http.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, Equals, 200)
+
+	var versions ClusterVersionStatus
+	c.Assert(json.NewDecoder(res.Body).Decode(&versions), IsNil)
+	c.Assert(versions.HostVersions, DeepEquals, map[string][]string{"": {"versions-host1"}})
+	c.Assert(versions.UnreachableHosts, DeepEquals, []string{"versions-host2"})
+	c.Assert(versions.MixedVersions, Equals, false)
+}
@@ -3,12 +3,15 @@ package main
 import (
 	"crypto/ecdsa"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,7 +20,9 @@ import (
 	"github.com/flynn/flynn/controller/authorizer"
 	"github.com/flynn/flynn/controller/data"
 	"github.com/flynn/flynn/controller/name"
+	"github.com/flynn/flynn/controller/resourcepolicy"
 	"github.com/flynn/flynn/controller/schema"
+	"github.com/flynn/flynn/controller/secrets"
 	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/flynn/controller/utils"
 	discoverd "github.com/flynn/flynn/discoverd/client"
@@ -29,11 +34,11 @@ import (
 	"github.com/flynn/flynn/pkg/postgres"
 	"github.com/flynn/flynn/pkg/shutdown"
 	"github.com/flynn/flynn/pkg/status"
+	"github.com/flynn/flynn/pkg/tracing"
 	router "github.com/flynn/flynn/router/types"
 	"github.com/flynn/que-go"
 	"github.com/improbable-eng/grpc-web/go/grpcweb"
 	"github.com/inconshreveable/log15"
-	"github.com/julienschmidt/httprouter"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 )
@@ -48,6 +53,10 @@ var schemaRoot = "/etc/flynn-controller/jsonschema"
 func main() {
 	defer shutdown.Exit()
 
+	if tracing.Init("controller") {
+		shutdown.BeforeExit(tracing.Flush)
+	}
+
 	httpPort := os.Getenv("PORT")
 	if httpPort == "" {
 		httpPort = "3000"
@@ -77,6 +86,20 @@ func main() {
 		log.Fatalln("error parsing ACCESS_TOKEN_MAX_VALIDITY:", err)
 	}
 
+	resourcePolicy, err := resourcepolicy.Parse(os.Getenv("RESOURCE_POLICY"))
+	if err != nil {
+		log.Fatalln("error parsing RESOURCE_POLICY:", err)
+	}
+
+	routerHTTPPorts, err := parsePorts(os.Getenv("ADDITIONAL_HTTP_PORTS"))
+	if err != nil {
+		log.Fatalln("error parsing ADDITIONAL_HTTP_PORTS:", err)
+	}
+	routerHTTPSPorts, err := parsePorts(os.Getenv("ADDITIONAL_HTTPS_PORTS"))
+	if err != nil {
+		log.Fatalln("error parsing ADDITIONAL_HTTPS_PORTS:", err)
+	}
+
 	db := data.OpenAndMigrateDB(nil)
 	shutdown.BeforeExit(func() { db.Close() })
 
@@ -130,6 +153,11 @@ func main() {
 		tokenKey:         tokenKey,
 		tokenMaxValidity: tokenMaxValidity,
 		caCert:           []byte(os.Getenv("CA_CERT")),
+		resourcePolicy:   resourcePolicy,
+		routers:          discoverd.NewService("router-api"),
+		routerHTTPPorts:  routerHTTPPorts,
+		routerHTTPSPorts: routerHTTPSPorts,
+		secretProviders:  secrets.FromEnv(),
 	})
 	go grpcServer.Serve(grpcListener)
 	shutdown.Fatal(http.ListenAndServe(httpAddr, handler))
@@ -148,6 +176,38 @@ type handlerConfig struct {
 	tokenKey         *ecdsa.PublicKey
 	tokenMaxValidity time.Duration
 	caCert           []byte
+	resourcePolicy   *resourcepolicy.Policy
+
+	// routers is the service router instances are registered with, used
+	// to report which routers a route change would affect
+	routers discoverd.Service
+
+	// routerHTTPPorts and routerHTTPSPorts are the ports routers serve
+	// HTTP and HTTPS traffic on in addition to the default ports
+	routerHTTPPorts  []int
+	routerHTTPSPorts []int
+
+	// secretProviders resolve env values referring to secrets when jobs
+	// are started
+	secretProviders secrets.Providers
+}
+
+// parsePorts parses a comma separated list of ports
+func parsePorts(s string) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var ports []int
+	for _, raw := range strings.Split(s, ",") {
+		port, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, err
+		} else if port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %d", port)
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
 }
 
 // NOTE: this is temporary until httphelper supports custom errors
@@ -155,6 +215,12 @@ func respondWithError(w http.ResponseWriter, err error) {
 	switch v := err.(type) {
 	case ct.ValidationError:
 		httphelper.ValidationError(w, v.Field, v.Message)
+	case ct.ValidationErrors:
+		jsonErr := httphelper.JSONError{Code: httphelper.ValidationErrorCode, Message: v.Message()}
+		if len(v) > 0 {
+			jsonErr.Detail, _ = json.Marshal(map[string]interface{}{"field": v[0].Field, "errors": v})
+		}
+		httphelper.Error(w, jsonErr)
 	default:
 		if err == ErrNotFound {
 			w.WriteHeader(404)
@@ -175,6 +241,7 @@ func appHandler(c handlerConfig) (http.Handler, *grpc.Server, *controllerAPI) {
 	providerRepo := data.NewProviderRepo(c.db)
 	resourceRepo := data.NewResourceRepo(c.db)
 	routeRepo := data.NewRouteRepo(c.db)
+	routeRepo.SetHTTPPorts(c.routerHTTPPorts, c.routerHTTPSPorts)
 	appRepo := data.NewAppRepo(c.db, os.Getenv("DEFAULT_ROUTE_DOMAIN"), routeRepo)
 	artifactRepo := data.NewArtifactRepo(c.db)
 	releaseRepo := data.NewReleaseRepo(c.db, artifactRepo, q)
@@ -187,6 +254,11 @@ func appHandler(c handlerConfig) (http.Handler, *grpc.Server, *controllerAPI) {
 	volumeRepo := data.NewVolumeRepo(c.db)
 	managedCertificateRepo := data.NewManagedCertificateRepo(c.db)
 	acmeConfigRepo := data.NewACMEConfigRepo(c.db)
+	acmeChallengeRepo := data.NewACMEChallengeRepo(c.db)
+	secretLeaseRepo := data.NewSecretLeaseRepo(c.db)
+	eventSinkRepo := data.NewEventSinkRepo(c.db)
+	reviewAppRepo := data.NewReviewAppRepo(c.db)
+	appStatsRepo := data.NewAppStatsRepo(c.db)
 
 	api := controllerAPI{
 		domainMigrationRepo:    domainMigrationRepo,
@@ -205,6 +277,11 @@ func appHandler(c handlerConfig) (http.Handler, *grpc.Server, *controllerAPI) {
 		volumeRepo:             volumeRepo,
 		managedCertificateRepo: managedCertificateRepo,
 		acmeConfigRepo:         acmeConfigRepo,
+		acmeChallengeRepo:      acmeChallengeRepo,
+		secretLeaseRepo:        secretLeaseRepo,
+		eventSinkRepo:          eventSinkRepo,
+		reviewAppRepo:          reviewAppRepo,
+		appStatsRepo:           appStatsRepo,
 		clusterClient:          c.cc,
 		logaggc:                c.lc,
 		que:                    q,
@@ -215,7 +292,7 @@ func appHandler(c handlerConfig) (http.Handler, *grpc.Server, *controllerAPI) {
 
 	shutdown.BeforeExit(api.Shutdown)
 
-	httpRouter := httprouter.New()
+	httpRouter := newAPIRouter()
 
 	crud(httpRouter, "apps", ct.App{}, appRepo)
 	crud(httpRouter, "releases", ct.Release{}, releaseRepo)
@@ -231,6 +308,8 @@ func appHandler(c handlerConfig) (http.Handler, *grpc.Server, *controllerAPI) {
 
 	httpRouter.GET("/ca-cert", httphelper.WrapHandler(api.GetCACert))
 
+	httpRouter.GET("/schema", httphelper.WrapHandler(httpRouter.ServeSchema))
+
 	httpRouter.GET("/backup", httphelper.WrapHandler(api.GetBackup))
 
 	httpRouter.PUT("/domain", httphelper.WrapHandler(api.MigrateDomain))
@@ -240,6 +319,10 @@ func appHandler(c handlerConfig) (http.Handler, *grpc.Server, *controllerAPI) {
 	httpRouter.DELETE("/apps/:apps_id", httphelper.WrapHandler(api.appLookup(api.DeleteApp)))
 	httpRouter.DELETE("/apps/:apps_id/releases/:releases_id", httphelper.WrapHandler(api.appLookup(api.DeleteRelease)))
 	httpRouter.POST("/apps/:apps_id/gc", httphelper.WrapHandler(api.appLookup(api.ScheduleAppGarbageCollection)))
+	httpRouter.POST("/apps/:apps_id/clone", httphelper.WrapHandler(api.appLookup(api.CloneApp)))
+	httpRouter.POST("/apps/:apps_id/review-apps", httphelper.WrapHandler(api.appLookup(api.CreateReviewApp)))
+	httpRouter.GET("/apps/:apps_id/review-apps", httphelper.WrapHandler(api.appLookup(api.ListReviewApps)))
+	httpRouter.DELETE("/apps/:apps_id/review-apps", httphelper.WrapHandler(api.appLookup(api.DeleteReviewApp)))
 
 	httpRouter.PUT("/apps/:apps_id/formations/:releases_id", httphelper.WrapHandler(api.appLookup(api.PutFormation)))
 	httpRouter.GET("/apps/:apps_id/formations/:releases_id", httphelper.WrapHandler(api.appLookup(api.GetFormation)))
@@ -253,6 +336,8 @@ func appHandler(c handlerConfig) (http.Handler, *grpc.Server, *controllerAPI) {
 	httpRouter.GET("/apps/:apps_id/jobs/:jobs_id", httphelper.WrapHandler(api.GetJob))
 	httpRouter.PUT("/apps/:apps_id/jobs/:jobs_id", httphelper.WrapHandler(api.PutJob))
 	httpRouter.GET("/apps/:apps_id/jobs", httphelper.WrapHandler(api.appLookup(api.ListJobs)))
+	httpRouter.POST("/apps/:apps_id/secret-env", httphelper.WrapHandler(api.appLookup(api.ResolveSecretEnv)))
+	httpRouter.GET("/apps/:apps_id/job-placements", httphelper.WrapHandler(api.appLookup(api.ListJobPlacements)))
 	httpRouter.DELETE("/apps/:apps_id/jobs/:jobs_id", httphelper.WrapHandler(api.KillJob))
 	httpRouter.GET("/active-jobs", httphelper.WrapHandler(api.ListActiveJobs))
 
@@ -263,6 +348,7 @@ func appHandler(c handlerConfig) (http.Handler, *grpc.Server, *controllerAPI) {
 	httpRouter.PUT("/apps/:apps_id/release", httphelper.WrapHandler(api.appLookup(api.SetAppRelease)))
 	httpRouter.GET("/apps/:apps_id/release", httphelper.WrapHandler(api.appLookup(api.GetAppRelease)))
 	httpRouter.GET("/apps/:apps_id/releases", httphelper.WrapHandler(api.appLookup(api.GetAppReleases)))
+	httpRouter.GET("/apps/:apps_id/env-diff", httphelper.WrapHandler(api.appLookup(api.GetAppEnvDiff)))
 
 	httpRouter.GET("/resources", httphelper.WrapHandler(api.GetResources))
 	httpRouter.POST("/providers/:providers_id/resources", httphelper.WrapHandler(api.ProvisionResource))
@@ -279,6 +365,7 @@ func appHandler(c handlerConfig) (http.Handler, *grpc.Server, *controllerAPI) {
 	httpRouter.GET("/apps/:apps_id/routes", httphelper.WrapHandler(api.appLookup(api.GetAppRouteList)))
 	httpRouter.GET("/apps/:apps_id/routes/:routes_type/:routes_id", httphelper.WrapHandler(api.appLookup(api.GetRoute)))
 	httpRouter.PUT("/apps/:apps_id/routes/:routes_type/:routes_id", httphelper.WrapHandler(api.appLookup(api.UpdateRoute)))
+	httpRouter.PATCH("/apps/:apps_id/routes/:routes_type/:routes_id", httphelper.WrapHandler(api.appLookup(api.PatchRoute)))
 	httpRouter.DELETE("/apps/:apps_id/routes/:routes_type/:routes_id", httphelper.WrapHandler(api.appLookup(api.DeleteRoute)))
 
 	httpRouter.POST("/apps/:apps_id/meta", httphelper.WrapHandler(api.appLookup(api.UpdateApp)))
@@ -291,25 +378,41 @@ func appHandler(c handlerConfig) (http.Handler, *grpc.Server, *controllerAPI) {
 	httpRouter.GET("/apps/:apps_id/volumes", httphelper.WrapHandler(api.appLookup(api.GetAppVolumes)))
 	httpRouter.GET("/apps/:apps_id/volumes/:volume_id", httphelper.WrapHandler(api.appLookup(api.GetVolume)))
 	httpRouter.PUT("/apps/:apps_id/volumes/:volume_id/decommission", httphelper.WrapHandler(api.appLookup(api.DecommissionVolume)))
+	httpRouter.POST("/apps/:apps_id/volumes/:volume_id/snapshots", httphelper.WrapHandler(api.appLookup(api.CreateVolumeSnapshot)))
+	httpRouter.POST("/apps/:apps_id/volumes/:volume_id/restore", httphelper.WrapHandler(api.appLookup(api.RestoreVolume)))
 
 	httpRouter.POST("/sinks", httphelper.WrapHandler(api.CreateSink))
 	httpRouter.GET("/sinks", httphelper.WrapHandler(api.GetSinks))
 	httpRouter.GET("/sinks/:sink_id", httphelper.WrapHandler(api.GetSink))
 	httpRouter.DELETE("/sinks/:sink_id", httphelper.WrapHandler(api.DeleteSink))
 
+	httpRouter.POST("/event-sinks", httphelper.WrapHandler(api.CreateEventSink))
+	httpRouter.GET("/event-sinks", httphelper.WrapHandler(api.GetEventSinks))
+	httpRouter.GET("/event-sinks/:event_sink_id", httphelper.WrapHandler(api.GetEventSink))
+	httpRouter.DELETE("/event-sinks/:event_sink_id", httphelper.WrapHandler(api.DeleteEventSink))
+
+	httpRouter.GET("/certificates", httphelper.WrapHandler(api.ListCertificates))
+	httpRouter.GET("/certificates/expiring", httphelper.WrapHandler(api.ListExpiringCertificates))
 	httpRouter.GET("/managed-certificates", httphelper.WrapHandler(api.GetManagedCertificates))
 	httpRouter.GET("/managed-certificates/:managed_certificate_id", httphelper.WrapHandler(api.GetManagedCertificate))
 	httpRouter.PUT("/managed-certificates/:managed_certificate_id", httphelper.WrapHandler(api.UpdateManagedCertificate))
 
 	httpRouter.GET("/acme/config", httphelper.WrapHandler(api.GetACMEConfig))
 	httpRouter.PUT("/acme/config", httphelper.WrapHandler(api.UpdateACMEConfig))
+	httpRouter.PUT("/acme/challenges/:token", httphelper.WrapHandler(api.PutACMEChallenge))
+	httpRouter.GET("/acme/challenges/:token", httphelper.WrapHandler(api.GetACMEChallenge))
+	httpRouter.DELETE("/acme/challenges/:token", httphelper.WrapHandler(api.DeleteACMEChallenge))
 
 	// Host and stats endpoints
 	httpRouter.GET("/hosts", httphelper.WrapHandler(api.GetHosts))
 	httpRouter.GET("/hosts/:host_id/stats", httphelper.WrapHandler(api.GetHostStats))
 	httpRouter.GET("/cluster/stats", httphelper.WrapHandler(api.GetClusterStats))
 	httpRouter.GET("/cluster/jobs-stats", httphelper.WrapHandler(api.GetClusterJobsStats))
+	httpRouter.GET("/cluster/report", httphelper.WrapHandler(api.GetClusterReport))
+	httpRouter.GET("/cluster/versions", httphelper.WrapHandler(api.GetClusterVersions))
+	httpRouter.GET("/cluster/update-history", httphelper.WrapHandler(api.GetUpdateHistory))
 	httpRouter.GET("/apps/:apps_id/jobs-stats", httphelper.WrapHandler(api.appLookup(api.GetAppJobsStats)))
+	httpRouter.GET("/apps/:apps_id/stats", httphelper.WrapHandler(api.appLookup(api.GetAppStats)))
 
 	grpcAPI := &grpcAPI{&api, c.db}
 	grpcSrv := grpcAPI.grpcServer()
@@ -343,7 +446,7 @@ func muxHandler(main http.Handler, grpcSrv *grpc.Server, authorizer *authorizer.
 		}
 
 		_, password, _ := r.BasicAuth()
-		if password == "" && r.URL.Path == "/ca-cert" {
+		if password == "" && (r.URL.Path == "/ca-cert" || r.URL.Path == "/schema") {
 			main.ServeHTTP(w, r)
 			return
 		}
@@ -381,6 +484,11 @@ type controllerAPI struct {
 	volumeRepo             *data.VolumeRepo
 	managedCertificateRepo *data.ManagedCertificateRepo
 	acmeConfigRepo         *data.ACMEConfigRepo
+	acmeChallengeRepo      *data.ACMEChallengeRepo
+	secretLeaseRepo        *data.SecretLeaseRepo
+	eventSinkRepo          *data.EventSinkRepo
+	reviewAppRepo          *data.ReviewAppRepo
+	appStatsRepo           *data.AppStatsRepo
 	clusterClient          utils.ClusterClient
 	logaggc                logClient
 	que                    *que.Client
@@ -12,9 +12,11 @@ import (
 
 	controller "github.com/flynn/flynn/controller/client"
 	"github.com/flynn/flynn/controller/data"
+	"github.com/flynn/flynn/controller/openapi"
 	tu "github.com/flynn/flynn/controller/testutils"
 	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/flynn/controller/utils"
+	host "github.com/flynn/flynn/host/types"
 	"github.com/flynn/flynn/pkg/certgen"
 	hh "github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/postgres"
@@ -124,6 +126,27 @@ func (s *S) TestBadAuth(c *C) {
 	c.Assert(res.StatusCode, Equals, 401)
 }
 
+func (s *S) TestSchema(c *C) {
+	// the schema is served without authentication
+	res, err := http.Get(s.srv.URL + "/schema")
+	c.Assert(err, IsNil)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, Equals, 200)
+
+	var doc openapi.Document
+	c.Assert(json.NewDecoder(res.Body).Decode(&doc), IsNil)
+	c.Assert(doc.OpenAPI, Equals, "3.0.3")
+	c.Assert(doc.Paths["/apps/{apps_id}"]["get"], NotNil)
+	c.Assert(doc.Components.Schemas["App"], NotNil)
+
+	// every registered route should be listed in openapi.Operations
+	for path, ops := range doc.Paths {
+		for method, op := range ops {
+			c.Assert(op.OperationID, Not(Equals), "", Commentf("%s %s is not documented", strings.ToUpper(method), path))
+		}
+	}
+}
+
 func (s *S) createTestApp(c *C, in *ct.App) *ct.App {
 	c.Assert(s.c.CreateApp(in), IsNil)
 	return in
@@ -337,6 +360,57 @@ func (s *S) TestCreateRelease(c *C) {
 	}
 }
 
+func (s *S) TestCreateReleaseInvalidProcesses(c *C) {
+	// every invalid field is reported at once
+	err := s.c.CreateRelease("", &ct.Release{Processes: map[string]ct.ProcessType{
+		"web": {
+			Ports: []ct.Port{
+				{Port: 8080, Proto: "tcp", Service: &host.Service{Name: "web", Check: &host.HealthCheck{Type: "tcp"}}},
+				{Port: 8080, Proto: "tcp"},
+				{Port: 70000, Proto: "sctp"},
+			},
+			Volumes: []ct.VolumeReq{{Path: "/data"}, {Path: "data"}},
+			Mounts:  []host.Mount{{Location: "/tmp", Target: "/data/"}},
+		},
+		"worker": {
+			Ports: []ct.Port{{Port: 53, Proto: "udp", Service: &host.Service{Name: "dns", Check: &host.HealthCheck{Type: "tcp"}}}},
+		},
+	}})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+	jsonErr := err.(hh.JSONError)
+	var detail struct {
+		Field  string               `json:"field"`
+		Errors []ct.ValidationError `json:"errors"`
+	}
+	c.Assert(json.Unmarshal(jsonErr.Detail, &detail), IsNil)
+	c.Assert(detail.Field, Equals, "processes.web.ports.1.port")
+	fields := make([]string, len(detail.Errors))
+	for i, e := range detail.Errors {
+		fields[i] = e.Field
+	}
+	c.Assert(fields, DeepEquals, []string{
+		"processes.web.ports.1.port",
+		"processes.web.ports.2.port",
+		"processes.web.ports.2.proto",
+		"processes.web.volumes.0.path",
+		"processes.web.volumes.1.path",
+		"processes.worker.ports.0.service.check.type",
+	})
+
+	// unknown keys are rejected rather than dropped
+	req, err := http.NewRequest("POST", s.srv.URL+"/releases", strings.NewReader(`{"processes":{"web":{"args":["start"],"portz":[]}}}`))
+	c.Assert(err, IsNil)
+	req.SetBasicAuth("", authKey)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, Equals, 400)
+	var resErr hh.JSONError
+	c.Assert(json.NewDecoder(res.Body).Decode(&resErr), IsNil)
+	c.Assert(resErr.Message, Equals, `processes.web unknown field "portz"`)
+}
+
 func (s *S) TestCreateFormation(c *C) {
 	for i, useName := range []bool{false, true} {
 		release := s.createTestRelease(c, "", &ct.Release{
@@ -638,6 +712,45 @@ func (s *S) TestSetAppRelease(c *C) {
 	c.Assert(formations, HasLen, 0)
 }
 
+func (s *S) TestAppEnvDiff(c *C) {
+	staging := s.createTestApp(c, &ct.App{Name: "env-diff-staging"})
+	stagingRelease := s.createTestRelease(c, staging.ID, &ct.Release{
+		Env:       map[string]string{"DEBUG": "true", "LOG_LEVEL": "debug", "DATABASE_URL": "postgres://staging"},
+		Processes: map[string]ct.ProcessType{"web": {Env: map[string]string{"PORT": "8080"}}},
+	})
+	s.setAppRelease(c, staging.ID, stagingRelease.ID)
+	production := s.createTestApp(c, &ct.App{Name: "env-diff-production"})
+	productionRelease := s.createTestRelease(c, production.ID, &ct.Release{
+		Env:       map[string]string{"LOG_LEVEL": "info", "DATABASE_URL": "postgres://production", "SENTRY_DSN": ""},
+		Processes: map[string]ct.ProcessType{"web": {Env: map[string]string{"PORT": "8081"}}},
+	})
+	s.setAppRelease(c, production.ID, productionRelease.ID)
+
+	expected := []*ct.EnvChange{
+		{Type: ct.EnvChangeChanged, Key: "DATABASE_URL", From: "[redacted]", To: "[redacted]", Redacted: true},
+		{Type: ct.EnvChangeRemoved, Key: "DEBUG", From: "true"},
+		{Type: ct.EnvChangeChanged, Key: "LOG_LEVEL", From: "debug", To: "info"},
+		{Type: ct.EnvChangeAdded, Key: "SENTRY_DSN"},
+		{Type: ct.EnvChangeChanged, ProcessType: "web", Key: "PORT", From: "8080", To: "8081"},
+	}
+	for _, other := range []string{production.Name, production.ID, productionRelease.ID} {
+		diff, err := s.c.GetAppEnvDiff(staging.Name, other)
+		c.Assert(err, IsNil)
+		c.Assert(diff.FromReleaseID, Equals, stagingRelease.ID)
+		c.Assert(diff.ToReleaseID, Equals, productionRelease.ID)
+		c.Assert(diff.Changes, DeepEquals, expected)
+	}
+
+	diff, err := s.c.GetAppEnvDiff(staging.ID, stagingRelease.ID)
+	c.Assert(err, IsNil)
+	c.Assert(diff.Changes, HasLen, 0)
+
+	_, err = s.c.GetAppEnvDiff(staging.ID, "env-diff-missing")
+	c.Assert(err, Equals, controller.ErrNotFound)
+	_, err = s.c.GetAppEnvDiff(staging.ID, random.UUID())
+	c.Assert(err, Equals, controller.ErrNotFound)
+}
+
 func (s *S) createTestProvider(c *C, provider *ct.Provider) *ct.Provider {
 	c.Assert(s.c.CreateProvider(provider), IsNil)
 	return provider
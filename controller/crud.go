@@ -1,13 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"io"
 	"net/http"
 	"reflect"
 
 	"github.com/flynn/flynn/controller/schema"
 	"github.com/flynn/flynn/pkg/ctxhelper"
 	"github.com/flynn/flynn/pkg/httphelper"
-	"github.com/julienschmidt/httprouter"
 	"golang.org/x/net/context"
 )
 
@@ -21,11 +22,28 @@ type Remover interface {
 	Remove(string) error
 }
 
-func crud(r *httprouter.Router, resource string, example interface{}, repo Repository) {
+// JSONValidator is implemented by repositories which validate the raw JSON
+// of created resources, for example to reject unknown keys.
+type JSONValidator interface {
+	ValidateJSON(data []byte) error
+}
+
+func crud(r *apiRouter, resource string, example interface{}, repo Repository) {
 	resourceType := reflect.TypeOf(example)
 	prefix := "/" + resource
 
 	r.POST(prefix, httphelper.WrapHandler(func(ctx context.Context, rw http.ResponseWriter, req *http.Request) {
+		validator, validateJSON := repo.(JSONValidator)
+		var data []byte
+		if validateJSON {
+			var err error
+			if data, err = io.ReadAll(req.Body); err != nil {
+				respondWithError(rw, err)
+				return
+			}
+			req.Body = io.NopCloser(bytes.NewReader(data))
+		}
+
 		thing := reflect.New(resourceType).Interface()
 		if err := httphelper.DecodeJSON(req, thing); err != nil {
 			respondWithError(rw, err)
@@ -37,6 +55,13 @@ func crud(r *httprouter.Router, resource string, example interface{}, repo Repos
 			return
 		}
 
+		if validateJSON {
+			if err := validator.ValidateJSON(data); err != nil {
+				respondWithError(rw, err)
+				return
+			}
+		}
+
 		if err := repo.Add(thing); err != nil {
 			respondWithError(rw, err)
 			return
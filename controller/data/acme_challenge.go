@@ -0,0 +1,49 @@
+package data
+
+import (
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/postgres"
+	"github.com/jackc/pgx"
+)
+
+// acmeChallengeMaxAge is how long challenges are kept for, so that those
+// left behind by an ACME service which stopped mid-order are removed
+const acmeChallengeMaxAge = 24 * time.Hour
+
+type ACMEChallengeRepo struct {
+	db *postgres.DB
+}
+
+func NewACMEChallengeRepo(db *postgres.DB) *ACMEChallengeRepo {
+	return &ACMEChallengeRepo{db: db}
+}
+
+// Add stores a challenge, replacing any existing challenge with the same
+// token, and removes stale challenges
+func (r *ACMEChallengeRepo) Add(challenge *ct.ACMEChallenge) error {
+	if err := r.db.Exec("acme_challenge_prune", time.Now().Add(-acmeChallengeMaxAge)); err != nil {
+		return err
+	}
+	return r.db.QueryRow("acme_challenge_insert", challenge.Token, challenge.KeyAuthorization).Scan(&challenge.CreatedAt)
+}
+
+// Get returns the challenge with the given token
+func (r *ACMEChallengeRepo) Get(token string) (*ct.ACMEChallenge, error) {
+	challenge := &ct.ACMEChallenge{}
+	err := r.db.QueryRow("acme_challenge_select", token).Scan(
+		&challenge.Token,
+		&challenge.KeyAuthorization,
+		&challenge.CreatedAt,
+	)
+	if err == pgx.ErrNoRows {
+		err = ErrNotFound
+	}
+	return challenge, err
+}
+
+// Remove removes the challenge with the given token
+func (r *ACMEChallengeRepo) Remove(token string) error {
+	return r.db.Exec("acme_challenge_delete", token)
+}
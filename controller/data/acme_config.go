@@ -17,13 +17,18 @@ func NewACMEConfigRepo(db *postgres.DB) *ACMEConfigRepo {
 func (r *ACMEConfigRepo) Get() (*ct.ACMEConfig, error) {
 	config := &ct.ACMEConfig{}
 	// Use pointers to handle NULL values from the database
-	var contactEmail, directoryURL, accountKey *string
+	var contactEmail, directoryURL, accountKey, eabKeyID, eabHMACKey, dnsWebhookURL, dnsWebhookToken *string
 	err := r.db.QueryRow("acme_config_select").Scan(
 		&config.Enabled,
 		&contactEmail,
 		&directoryURL,
 		&config.TermsOfServiceAgreed,
 		&accountKey,
+		&eabKeyID,
+		&eabHMACKey,
+		&dnsWebhookURL,
+		&dnsWebhookToken,
+		&config.WildcardDefaultDomain,
 		&config.CreatedAt,
 		&config.UpdatedAt,
 	)
@@ -40,6 +45,18 @@ func (r *ACMEConfigRepo) Get() (*ct.ACMEConfig, error) {
 	if accountKey != nil {
 		config.AccountKey = *accountKey
 	}
+	if eabKeyID != nil {
+		config.EABKeyID = *eabKeyID
+	}
+	if eabHMACKey != nil {
+		config.EABHMACKey = *eabHMACKey
+	}
+	if dnsWebhookURL != nil {
+		config.DNSWebhookURL = *dnsWebhookURL
+	}
+	if dnsWebhookToken != nil {
+		config.DNSWebhookToken = *dnsWebhookToken
+	}
 	return config, nil
 }
 
@@ -51,6 +68,11 @@ func (r *ACMEConfigRepo) Update(config *ct.ACMEConfig) error {
 		config.DirectoryURL,
 		config.TermsOfServiceAgreed,
 		config.AccountKey,
+		config.EABKeyID,
+		config.EABHMACKey,
+		config.DNSWebhookURL,
+		config.DNSWebhookToken,
+		config.WildcardDefaultDomain,
 	).Scan(&config.UpdatedAt)
 }
 
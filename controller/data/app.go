@@ -89,6 +89,7 @@ func (r *AppRepo) Add(data interface{}) error {
 			Service:       app.Name + "-web",
 			DrainBackends: true,
 		}).ToRoute()
+		route.ManagedCertificateDomain = r.defaultManagedCertificateDomain()
 		if err := r.routes.Add(route); err != nil {
 			log.Printf("Error creating default route for %s: %s", app.Name, err)
 		}
@@ -96,6 +97,22 @@ func (r *AppRepo) Add(data interface{}) error {
 	return nil
 }
 
+// defaultManagedCertificateDomain returns the wildcard managed certificate
+// domain which default app routes share when ACME is configured to issue one,
+// so a single certificate covers every app on the default domain.
+func (r *AppRepo) defaultManagedCertificateDomain() *string {
+	config, err := NewACMEConfigRepo(r.db).Get()
+	if err != nil {
+		log.Printf("Error getting ACME config: %s", err)
+		return nil
+	}
+	if !config.Enabled || !config.WildcardDefaultDomain || config.DNSWebhookURL == "" {
+		return nil
+	}
+	domain := "*." + r.defaultDomain
+	return &domain
+}
+
 func scanApp(s postgres.Scanner) (*ct.App, error) {
 	app := &ct.App{}
 	var releaseID *string
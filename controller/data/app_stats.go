@@ -0,0 +1,80 @@
+package data
+
+import (
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/postgres"
+)
+
+// AppStatsRepo stores periodic snapshots of the resource usage of app jobs
+// and rolls them up for graphing.
+type AppStatsRepo struct {
+	db *postgres.DB
+}
+
+func NewAppStatsRepo(db *postgres.DB) *AppStatsRepo {
+	return &AppStatsRepo{db: db}
+}
+
+// Add stores the snapshots taken of the jobs running at one time.
+func (r *AppStatsRepo) Add(snapshots []*ct.AppStatsSnapshot) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, s := range snapshots {
+		if err := tx.Exec(
+			"app_stats_snapshot_insert",
+			s.AppID,
+			s.JobID,
+			s.ProcessType,
+			s.CPUUsagePercent,
+			s.MemoryBytes,
+			s.NetworkRxBytes,
+			s.NetworkTxBytes,
+			s.CreatedAt,
+		); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteBefore deletes the snapshots taken before the given time.
+func (r *AppStatsRepo) DeleteBefore(t time.Time) error {
+	return r.db.Exec("app_stats_snapshot_delete_before", t)
+}
+
+// Rollup returns the usage of an app's jobs since the given time rolled up
+// over each period of the resolution.
+func (r *AppStatsRepo) Rollup(appID string, resolution ct.AppStatsResolution, since time.Time) (*ct.AppStatsRollup, error) {
+	rows, err := r.db.Query("app_stats_rollup", appID, string(resolution), since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rollup := &ct.AppStatsRollup{
+		AppID:      appID,
+		Resolution: resolution,
+		Since:      since,
+		Points:     []*ct.AppStatsPoint{},
+	}
+	for rows.Next() {
+		p := &ct.AppStatsPoint{}
+		if err := rows.Scan(
+			&p.Time,
+			&p.CPUUsagePercent,
+			&p.CPUUsagePercentMax,
+			&p.MemoryBytes,
+			&p.MemoryBytesMax,
+			&p.NetworkRxBytes,
+			&p.NetworkTxBytes,
+		); err != nil {
+			return nil, err
+		}
+		rollup.Points = append(rollup.Points, p)
+	}
+	return rollup, rows.Err()
+}
@@ -0,0 +1,102 @@
+package data
+
+import (
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/postgres"
+	"github.com/flynn/flynn/pkg/random"
+	"github.com/jackc/pgx"
+)
+
+// EventSinkRepo stores the endpoints which cluster-wide events are forwarded
+// to, along with how far through the event log each has got.
+type EventSinkRepo struct {
+	db *postgres.DB
+}
+
+func NewEventSinkRepo(db *postgres.DB) *EventSinkRepo {
+	return &EventSinkRepo{db: db}
+}
+
+// Add stores an event sink, which will forward events created after it was
+// added.
+func (r *EventSinkRepo) Add(s *ct.EventSink) error {
+	if s.ID == "" {
+		s.ID = random.UUID()
+	}
+	if s.Format == "" {
+		s.Format = ct.EventSinkFormatJSON
+	}
+	if len(s.EventTypes) == 0 {
+		s.EventTypes = ct.DefaultEventSinkTypes
+	}
+	types := make([]string, len(s.EventTypes))
+	for i, typ := range s.EventTypes {
+		types[i] = string(typ)
+	}
+	return r.db.QueryRow(
+		"event_sink_insert",
+		s.ID,
+		s.URL,
+		string(s.Format),
+		types,
+	).Scan(&s.LastEventID, &s.CreatedAt, &s.UpdatedAt)
+}
+
+func scanEventSink(s postgres.Scanner) (*ct.EventSink, error) {
+	sink := &ct.EventSink{}
+	var format string
+	var types []string
+	err := s.Scan(
+		&sink.ID,
+		&sink.URL,
+		&format,
+		&types,
+		&sink.LastEventID,
+		&sink.LastError,
+		&sink.CreatedAt,
+		&sink.UpdatedAt,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			err = ErrNotFound
+		}
+		return nil, err
+	}
+	sink.Format = ct.EventSinkFormat(format)
+	sink.EventTypes = make([]ct.EventType, len(types))
+	for i, typ := range types {
+		sink.EventTypes[i] = ct.EventType(typ)
+	}
+	return sink, nil
+}
+
+func (r *EventSinkRepo) Get(id string) (*ct.EventSink, error) {
+	return scanEventSink(r.db.QueryRow("event_sink_select", id))
+}
+
+func (r *EventSinkRepo) List() ([]*ct.EventSink, error) {
+	rows, err := r.db.Query("event_sink_list")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var sinks []*ct.EventSink
+	for rows.Next() {
+		sink, err := scanEventSink(rows)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, rows.Err()
+}
+
+// UpdateCursor records the last event a sink has forwarded and the error from
+// its last failed delivery, if any.
+func (r *EventSinkRepo) UpdateCursor(id string, lastEventID int64, lastError string) error {
+	return r.db.Exec("event_sink_update_cursor", id, lastEventID, lastError)
+}
+
+func (r *EventSinkRepo) Remove(id string) error {
+	return r.db.Exec("event_sink_delete", id)
+}
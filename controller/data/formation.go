@@ -147,6 +147,7 @@ func (r *FormationRepo) AddScaleRequest(req *ct.ScaleRequest, deleteFormation bo
 		req.NewProcesses,
 		req.OldTags,
 		req.NewTags,
+		req.TraceParent,
 	).Scan(&req.CreatedAt, &req.UpdatedAt)
 	if err != nil {
 		tx.Rollback()
@@ -266,6 +267,7 @@ func scanExpandedFormation(s postgres.Scanner) (*ct.ExpandedFormation, error) {
 		&req.OldTags,
 		&req.NewTags,
 		&req.CreatedAt,
+		&req.TraceParent,
 		&f.Processes,
 		&f.Tags,
 		&f.UpdatedAt,
@@ -469,7 +471,7 @@ func (r *FormationRepo) ListScaleRequests(opts ListScaleRequestOptions) ([]*ct.S
 
 func scanScaleRequest(s postgres.Scanner) (*ct.ScaleRequest, error) {
 	sr := &ct.ScaleRequest{}
-	err := s.Scan(&sr.ID, &sr.AppID, &sr.ReleaseID, &sr.State, &sr.OldProcesses, &sr.NewProcesses, &sr.OldTags, &sr.NewTags, &sr.CreatedAt, &sr.UpdatedAt)
+	err := s.Scan(&sr.ID, &sr.AppID, &sr.ReleaseID, &sr.State, &sr.OldProcesses, &sr.NewProcesses, &sr.OldTags, &sr.NewTags, &sr.TraceParent, &sr.CreatedAt, &sr.UpdatedAt)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			err = ErrNotFound
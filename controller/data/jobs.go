@@ -1,7 +1,9 @@
 package data
 
 import (
+	"encoding/json"
 	"strings"
+	"time"
 
 	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/flynn/pkg/cluster"
@@ -52,6 +54,7 @@ func (r *JobRepo) Add(job *ct.Job) error {
 		job.RunAt,
 		job.Restarts,
 		job.Args,
+		job.LogTail,
 	).Scan(&job.CreatedAt, &job.UpdatedAt)
 	if postgres.IsPostgresCode(err, postgres.CheckViolation) {
 		tx.Rollback()
@@ -99,6 +102,7 @@ func scanJob(s postgres.Scanner) (*ct.Job, error) {
 		&job.CreatedAt,
 		&job.UpdatedAt,
 		&job.Args,
+		&job.LogTail,
 		&volumeIDs,
 	)
 	if err != nil {
@@ -147,3 +151,54 @@ func (r *JobRepo) ListActive() ([]*ct.Job, error) {
 	}
 	return jobs, rows.Err()
 }
+
+// CountByState returns the number of jobs in each state.
+func (r *JobRepo) CountByState() (map[ct.JobState]int, error) {
+	rows, err := r.db.Query("job_count_by_state")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make(map[ct.JobState]int)
+	for rows.Next() {
+		var state string
+		var count int64
+		if err := rows.Scan(&state, &count); err != nil {
+			return nil, err
+		}
+		counts[ct.JobState(state)] = int(count)
+	}
+	return counts, rows.Err()
+}
+
+// ListPlacements returns the hosts the given app's jobs were placed on
+// between since and until, aggregated from job events. Jobs which never
+// reached a host are omitted.
+func (r *JobRepo) ListPlacements(appID string, since, until time.Time) ([]*ct.JobPlacement, error) {
+	rows, err := r.db.Query("job_placement_list", appID, since, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var placements []*ct.JobPlacement
+	for rows.Next() {
+		var data []byte
+		p := &ct.JobPlacement{}
+		if err := rows.Scan(&p.UUID, &data, &p.StartedAt, &p.StoppedAt); err != nil {
+			return nil, err
+		}
+		var job ct.Job
+		if err := json.Unmarshal(data, &job); err != nil {
+			return nil, err
+		}
+		p.JobID = job.ID
+		p.HostID = job.HostID
+		p.ReleaseID = job.ReleaseID
+		p.Type = job.Type
+		p.State = job.State
+		p.ExitStatus = job.ExitStatus
+		p.HostError = job.HostError
+		placements = append(placements, p)
+	}
+	return placements, rows.Err()
+}
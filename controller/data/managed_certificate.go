@@ -107,7 +107,8 @@ func (r *ManagedCertificateRepo) Update(cert *ct.ManagedCertificate) error {
 
 	err = tx.QueryRow("managed_certificate_update",
 		cert.ID, cert.Status, cert.Cert, cert.Key, certSHA256,
-		cert.ExpiresAt, cert.LastError, cert.LastErrorAt,
+		cert.ExpiresAt, cert.LastError, cert.LastErrorAt, cert.RetryAfter,
+		cert.OCSPResponse, cert.OCSPNextUpdate,
 	).Scan(&cert.UpdatedAt)
 	if err == pgx.ErrNoRows {
 		tx.Rollback()
@@ -118,28 +119,36 @@ func (r *ManagedCertificateRepo) Update(cert *ct.ManagedCertificate) error {
 		return err
 	}
 
-	// If the certificate was issued, update the route's certificate
-	if cert.Status == ct.ManagedCertificateStatusIssued && cert.Cert != "" && cert.Key != "" && cert.RouteID != "" {
-		if err := r.updateRouteCertificate(tx, cert); err != nil {
-			tx.Rollback()
-			return err
-		}
-
-		// Fetch the route with the newly-linked certificate and create an event
-		route, err := scanHTTPRouteFromTx(tx, cert.RouteID)
+	// If the certificate was issued, update the certificate of each route
+	// for the domain
+	if cert.Status == ct.ManagedCertificateStatusIssued && cert.Cert != "" && cert.Key != "" {
+		routeIDs, err := r.managedRouteIDs(tx, cert)
 		if err != nil {
 			tx.Rollback()
 			return err
 		}
+		for _, routeID := range routeIDs {
+			if err := r.updateRouteCertificate(tx, cert, routeID); err != nil {
+				tx.Rollback()
+				return err
+			}
 
-		// Create a route event so the router picks up the change
-		if err := CreateEvent(tx.Exec, &ct.Event{
-			ObjectID:   cert.RouteID,
-			ObjectType: ct.EventTypeRoute,
-			Op:         ct.EventOpUpdate,
-		}, route); err != nil {
-			tx.Rollback()
-			return err
+			// Fetch the route with the newly-linked certificate and create an event
+			route, err := scanHTTPRouteFromTx(tx, routeID)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+
+			// Create a route event so the router picks up the change
+			if err := CreateEvent(tx.Exec, &ct.Event{
+				ObjectID:   routeID,
+				ObjectType: ct.EventTypeRoute,
+				Op:         ct.EventOpUpdate,
+			}, route); err != nil {
+				tx.Rollback()
+				return err
+			}
 		}
 	}
 
@@ -154,8 +163,27 @@ func (r *ManagedCertificateRepo) Update(cert *ct.ManagedCertificate) error {
 	return tx.Commit()
 }
 
+// managedRouteIDs returns the IDs of the routes using the managed
+// certificate's domain, which may be routed on multiple ports
+func (r *ManagedCertificateRepo) managedRouteIDs(tx *postgres.DBTx, cert *ct.ManagedCertificate) ([]string, error) {
+	rows, err := tx.Query("http_route_list_ids_by_managed_domain", cert.Domain)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
 // updateRouteCertificate adds the managed certificate to the route's certificate table
-func (r *ManagedCertificateRepo) updateRouteCertificate(tx *postgres.DBTx, cert *ct.ManagedCertificate) error {
+func (r *ManagedCertificateRepo) updateRouteCertificate(tx *postgres.DBTx, cert *ct.ManagedCertificate, routeID string) error {
 	// Validate the certificate
 	if _, err := tls.X509KeyPair([]byte(cert.Cert), []byte(cert.Key)); err != nil {
 		return err
@@ -174,13 +202,19 @@ func (r *ManagedCertificateRepo) updateRouteCertificate(tx *postgres.DBTx, cert
 		return err
 	}
 
+	// Store the OCSP response with the certificate so that it is
+	// included in route events for the router to staple
+	if err := tx.Exec("certificate_update_ocsp", certID, cert.OCSPResponse); err != nil {
+		return err
+	}
+
 	// Delete any existing route certificate mapping
-	if err := tx.Exec("route_certificate_delete_by_route_id", cert.RouteID); err != nil {
+	if err := tx.Exec("route_certificate_delete_by_route_id", routeID); err != nil {
 		return err
 	}
 
 	// Link the certificate to the route
-	if err := tx.Exec("route_certificate_insert", cert.RouteID, certID); err != nil {
+	if err := tx.Exec("route_certificate_insert", routeID, certID); err != nil {
 		return err
 	}
 
@@ -195,6 +229,7 @@ func scanHTTPRouteFromTx(tx *postgres.DBTx, id string) (*router.Route, error) {
 		certID                   *string
 		certCert                 *string
 		certKey                  *string
+		certOCSPResponse         []byte
 		certCreatedAt            *time.Time
 		certUpdatedAt            *time.Time
 	)
@@ -209,12 +244,18 @@ func scanHTTPRouteFromTx(tx *postgres.DBTx, id string) (*router.Route, error) {
 		&route.Sticky,
 		&route.Path,
 		&route.DisableKeepAlives,
+		&route.TLSPolicy,
+		&route.ClientCA,
+		&route.RedirectHTTPS,
+		&route.Splits,
+		&route.HTTP2,
 		&managedCertificateDomain,
 		&route.CreatedAt,
 		&route.UpdatedAt,
 		&certID,
 		&certCert,
 		&certKey,
+		&certOCSPResponse,
 		&certCreatedAt,
 		&certUpdatedAt,
 	); err != nil {
@@ -224,11 +265,12 @@ func scanHTTPRouteFromTx(tx *postgres.DBTx, id string) (*router.Route, error) {
 	route.Type = "http"
 	if certID != nil {
 		route.Certificate = &router.Certificate{
-			ID:        *certID,
-			Cert:      *certCert,
-			Key:       *certKey,
-			CreatedAt: *certCreatedAt,
-			UpdatedAt: *certUpdatedAt,
+			ID:           *certID,
+			Cert:         *certCert,
+			Key:          *certKey,
+			OCSPResponse: certOCSPResponse,
+			CreatedAt:    *certCreatedAt,
+			UpdatedAt:    *certUpdatedAt,
 		}
 	}
 	return &route, nil
@@ -246,7 +288,8 @@ func scanManagedCertificate(s postgres.Scanner) (*ct.ManagedCertificate, error)
 	err := s.Scan(
 		&cert.ID, &cert.Domain, &cert.RouteID, &cert.Status,
 		&certPEM, &keyPEM, &certSHA256, &cert.ExpiresAt,
-		&cert.LastError, &cert.LastErrorAt, &cert.CreatedAt, &cert.UpdatedAt,
+		&cert.LastError, &cert.LastErrorAt, &cert.RetryAfter,
+		&cert.OCSPResponse, &cert.OCSPNextUpdate, &cert.CreatedAt, &cert.UpdatedAt,
 	)
 	if err != nil {
 		return nil, err
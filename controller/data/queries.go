@@ -44,6 +44,7 @@ var preparedStatements = map[string]string{
 	"deployment_insert":                      deploymentInsertQuery,
 	"deployment_update_finished_at":          deploymentUpdateFinishedAtQuery,
 	"deployment_update_finished_at_now":      deploymentUpdateFinishedAtNowQuery,
+	"deployment_update_old_release_usage":    deploymentUpdateOldReleaseUsageQuery,
 	"deployment_delete":                      deploymentDeleteQuery,
 	"event_select":                           eventSelectQuery,
 	"event_insert":                           eventInsertQuery,
@@ -64,6 +65,8 @@ var preparedStatements = map[string]string{
 	"scale_request_list":                     scaleRequestListQuery,
 	"job_list":                               jobListQuery,
 	"job_list_active":                        jobListActiveQuery,
+	"job_count_by_state":                     jobCountByStateQuery,
+	"job_placement_list":                     jobPlacementListQuery,
 	"job_select":                             jobSelectQuery,
 	"job_insert":                             jobInsertQuery,
 	"job_volume_insert":                      jobVolumeInsertQuery,
@@ -100,15 +103,19 @@ var preparedStatements = map[string]string{
 	"http_route_list_by_parent_ref":          httpRouteListByParentRefQuery,
 	"http_route_insert":                      httpRouteInsertQuery,
 	"http_route_select":                      httpRouteSelectQuery,
+	"http_route_select_for_update":           httpRouteSelectForUpdateQuery,
 	"http_route_update":                      httpRouteUpdateQuery,
 	"http_route_delete":                      httpRouteDeleteQuery,
+	"http_route_list_ids_by_managed_domain":  httpRouteListIDsByManagedDomainQuery,
 	"tcp_route_list":                         tcpRouteListQuery,
 	"tcp_route_list_by_parent_ref":           tcpRouteListByParentRefQuery,
 	"tcp_route_insert":                       tcpRouteInsertQuery,
 	"tcp_route_select":                       tcpRouteSelectQuery,
+	"tcp_route_select_for_update":            tcpRouteSelectForUpdateQuery,
 	"tcp_route_update":                       tcpRouteUpdateQuery,
 	"tcp_route_delete":                       tcpRouteDeleteQuery,
 	"certificate_insert":                     certificateInsertQuery,
+	"certificate_update_ocsp":                certificateUpdateOCSPQuery,
 	"route_certificate_delete_by_route_id":   routeCertificateDeleteByRouteIDQuery,
 	"route_certificate_insert":               routeCertificateInsertQuery,
 	"managed_certificate_list":               managedCertificateListQuery,
@@ -123,6 +130,33 @@ var preparedStatements = map[string]string{
 	"managed_certificate_list_expiring":      managedCertificateListExpiringQuery,
 	"acme_config_select":                     acmeConfigSelectQuery,
 	"acme_config_update":                     acmeConfigUpdateQuery,
+	"acme_challenge_insert":                  acmeChallengeInsertQuery,
+	"acme_challenge_select":                  acmeChallengeSelectQuery,
+	"acme_challenge_delete":                  acmeChallengeDeleteQuery,
+	"acme_challenge_prune":                   acmeChallengePruneQuery,
+	"retention_prune_events":                 retentionPruneEventsQuery,
+	"retention_prune_deployments":            retentionPruneDeploymentsQuery,
+	"retention_prune_certificate_events":     retentionPruneCertificateEventsQuery,
+	"retention_prune_deleted_certificates":   retentionPruneDeletedCertificatesQuery,
+	"secret_lease_insert":                    secretLeaseInsertQuery,
+	"secret_lease_list":                      secretLeaseListQuery,
+	"secret_lease_update_expiry":             secretLeaseUpdateExpiryQuery,
+	"secret_lease_delete":                    secretLeaseDeleteQuery,
+	"review_app_insert":                      reviewAppInsertQuery,
+	"review_app_select":                      reviewAppSelectQuery,
+	"review_app_list":                        reviewAppListQuery,
+	"review_app_list_expired":                reviewAppListExpiredQuery,
+	"review_app_update_expiry":               reviewAppUpdateExpiryQuery,
+	"review_app_delete":                      reviewAppDeleteQuery,
+	"review_app_delete_by_app":               reviewAppDeleteByAppQuery,
+	"event_sink_insert":                      eventSinkInsertQuery,
+	"event_sink_select":                      eventSinkSelectQuery,
+	"event_sink_list":                        eventSinkListQuery,
+	"event_sink_update_cursor":               eventSinkUpdateCursorQuery,
+	"event_sink_delete":                      eventSinkDeleteQuery,
+	"app_stats_snapshot_insert":              appStatsSnapshotInsertQuery,
+	"app_stats_snapshot_delete_before":       appStatsSnapshotDeleteBeforeQuery,
+	"app_stats_rollup":                       appStatsRollupQuery,
 }
 
 func PrepareStatements(conn *pgx.Conn) error {
@@ -278,11 +312,13 @@ VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING created_at`
 UPDATE deployments SET finished_at = $2 WHERE deployment_id = $1`
 	deploymentUpdateFinishedAtNowQuery = `
 UPDATE deployments SET finished_at = now() WHERE deployment_id = $1`
+	deploymentUpdateOldReleaseUsageQuery = `
+UPDATE deployments SET old_release_usage = $2 WHERE deployment_id = $1`
 	deploymentDeleteQuery = `
 DELETE FROM deployments WHERE deployment_id = $1`
 	deploymentSelectQuery = `
 SELECT deployment_id, app_id, old_release_id, new_release_id, strategy, deployment_status(deployment_id),
-  processes, tags, deploy_timeout, deploy_batch_size, created_at, finished_at
+  processes, tags, deploy_timeout, deploy_batch_size, created_at, finished_at, old_release_usage
 FROM deployments
 WHERE deployment_id = $1`
 	deploymentSelectExpandedQuery = `
@@ -311,7 +347,7 @@ LIMIT 1
 `
 	deploymentListQuery = `
 SELECT deployment_id, app_id, old_release_id, new_release_id, strategy, deployment_status(deployment_id),
-  processes, tags, deploy_timeout, deploy_batch_size, created_at, finished_at
+  processes, tags, deploy_timeout, deploy_batch_size, created_at, finished_at, old_release_usage
 FROM deployments
 WHERE app_id = $1 ORDER BY created_at DESC`
 	deploymentListPageQuery = `
@@ -380,6 +416,7 @@ SELECT
   releases.meta, releases.env, releases.processes, releases.created_at,
   scale_requests.scale_request_id, scale_requests.old_processes, scale_requests.new_processes,
   scale_requests.old_tags, scale_requests.new_tags, scale_requests.created_at,
+  COALESCE(scale_requests.trace_parent, ''),
   formations.processes, formations.tags, formations.updated_at, formations.deleted_at IS NOT NULL
 FROM formations
 JOIN apps USING (app_id)
@@ -411,6 +448,7 @@ SELECT
   releases.meta, releases.env, releases.processes, releases.created_at,
   scale_requests.scale_request_id, scale_requests.old_processes, scale_requests.new_processes,
   scale_requests.old_tags, scale_requests.new_tags, scale_requests.created_at,
+  COALESCE(scale_requests.trace_parent, ''),
   formations.processes, formations.tags, formations.updated_at, formations.deleted_at IS NOT NULL
 FROM formations
 JOIN apps USING (app_id)
@@ -438,6 +476,7 @@ SELECT
   releases.meta, releases.env, releases.processes, releases.created_at,
   scale_requests.scale_request_id, scale_requests.old_processes, scale_requests.new_processes,
   scale_requests.old_tags, scale_requests.new_tags, scale_requests.created_at,
+  COALESCE(scale_requests.trace_parent, ''),
   formations.processes, formations.tags, formations.updated_at, formations.deleted_at IS NOT NULL
 FROM formations
 JOIN apps USING (app_id)
@@ -460,22 +499,22 @@ WHERE app_id = $1 AND release_id = $2 AND deleted_at IS NULL`
 UPDATE formations SET deleted_at = now(), processes = NULL, updated_at = now()
 WHERE app_id = $1 AND deleted_at IS NULL`
 	scaleRequestInsertQuery = `
-INSERT INTO scale_requests (scale_request_id, app_id, release_id, state, old_processes, new_processes, old_tags, new_tags)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+INSERT INTO scale_requests (scale_request_id, app_id, release_id, state, old_processes, new_processes, old_tags, new_tags, trace_parent)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 RETURNING created_at, updated_at`
 	scaleRequestCancelQuery = `
 WITH updated AS (
 	UPDATE scale_requests SET state = 'cancelled', updated_at = now() WHERE app_id = $1 AND release_id = $2 AND state != 'cancelled'
 	RETURNING *
 )
-SELECT scale_request_id, app_id, release_id, state, old_processes, new_processes, old_tags, new_tags, created_at, updated_at
+SELECT scale_request_id, app_id, release_id, state, old_processes, new_processes, old_tags, new_tags, COALESCE(trace_parent, ''), created_at, updated_at
 FROM updated
 ORDER BY created_at DESC`
 	scaleRequestUpdateQuery = `
 UPDATE scale_requests SET state = $2, updated_at = now() WHERE scale_request_id = $1
 RETURNING updated_at`
 	scaleRequestListQuery = `
-SELECT s.scale_request_id, s.app_id, s.release_id, s.state, s.old_processes, s.new_processes, s.old_tags, s.new_tags, s.created_at, s.updated_at
+SELECT s.scale_request_id, s.app_id, s.release_id, s.state, s.old_processes, s.new_processes, s.old_tags, s.new_tags, COALESCE(s.trace_parent, ''), s.created_at, s.updated_at
 FROM scale_requests s
 WHERE
   CASE WHEN array_length($1::text[], 1) > 0 THEN s.app_id::text = ANY($1::text[]) ELSE true END
@@ -493,7 +532,7 @@ LIMIT $6
 	jobListQuery = `
 SELECT
   cluster_id, job_id, host_id, app_id, release_id, process_type, state, meta,
-  exit_status, host_error, run_at, restarts, created_at, updated_at, args,
+  exit_status, host_error, run_at, restarts, created_at, updated_at, args, log_tail,
   ARRAY(
     SELECT job_volumes.volume_id
     FROM job_volumes
@@ -504,7 +543,7 @@ FROM job_cache WHERE app_id = $1 ORDER BY created_at DESC`
 	jobListActiveQuery = `
 SELECT
   cluster_id, job_id, host_id, app_id, release_id, process_type, state, meta,
-  exit_status, host_error, run_at, restarts, created_at, updated_at, args,
+  exit_status, host_error, run_at, restarts, created_at, updated_at, args, log_tail,
   ARRAY(
     SELECT job_volumes.volume_id
     FROM job_volumes
@@ -512,10 +551,24 @@ SELECT
     ORDER BY job_volumes.index
   )
 FROM job_cache WHERE state = 'pending' OR state = 'starting' OR state = 'up' OR state = 'stopping' ORDER BY updated_at DESC`
+	jobCountByStateQuery = `
+SELECT state, COUNT(*) FROM job_cache GROUP BY state`
+	jobPlacementListQuery = `
+SELECT
+  object_id,
+  (array_agg(data ORDER BY event_id DESC))[1],
+  min(created_at) FILTER (WHERE data->>'state' = 'up'),
+  max(created_at) FILTER (WHERE data->>'state' IN ('down', 'crashed', 'failed'))
+FROM events
+WHERE app_id = $1 AND object_type = 'job' AND created_at < $3
+GROUP BY object_id
+HAVING bool_or(data ? 'host_id')
+AND coalesce(max(created_at) FILTER (WHERE data->>'state' IN ('down', 'crashed', 'failed')), now()) >= $2
+ORDER BY min(created_at) DESC`
 	jobSelectQuery = `
 SELECT
   cluster_id, job_id, host_id, app_id, release_id, process_type, state, meta,
-  exit_status, host_error, run_at, restarts, created_at, updated_at, args,
+  exit_status, host_error, run_at, restarts, created_at, updated_at, args, log_tail,
   ARRAY(
     SELECT job_volumes.volume_id
     FROM job_volumes
@@ -524,9 +577,9 @@ SELECT
   )
 FROM job_cache WHERE job_id = $1`
 	jobInsertQuery = `
-INSERT INTO job_cache (cluster_id, job_id, host_id, app_id, release_id, process_type, state, meta, exit_status, host_error, run_at, restarts, args)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) ON CONFLICT (job_id) DO UPDATE
-SET cluster_id = $1, host_id = $3, state = $7, exit_status = $9, host_error = $10, run_at = $11, restarts = $12, args = $13, updated_at = now()
+INSERT INTO job_cache (cluster_id, job_id, host_id, app_id, release_id, process_type, state, meta, exit_status, host_error, run_at, restarts, args, log_tail)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) ON CONFLICT (job_id) DO UPDATE
+SET cluster_id = $1, host_id = $3, state = $7, exit_status = $9, host_error = $10, run_at = $11, restarts = $12, args = $13, log_tail = $14, updated_at = now()
 RETURNING created_at, updated_at`
 	jobVolumeInsertQuery = `
 INSERT INTO job_volumes (job_id, volume_id, index) VALUES ($1, $2, $3)
@@ -638,34 +691,43 @@ RETURNING created_at, updated_at`
 	volumeDecommissionQuery = `
 UPDATE volumes SET updated_at = now(), decommissioned_at = now() WHERE app_id = $1 AND volume_id = $2 RETURNING updated_at, decommissioned_at`
 	httpRouteListQuery = `
-SELECT r.id, r.parent_ref, r.service, r.port, r.leader, r.drain_backends, r.domain, r.sticky, r.path, r.disable_keep_alives, r.managed_certificate_domain, r.created_at, r.updated_at, c.id, c.cert, c.key, c.created_at, c.updated_at FROM http_routes as r
+SELECT r.id, r.parent_ref, r.service, r.port, r.leader, r.drain_backends, r.domain, r.sticky, r.path, r.disable_keep_alives, r.tls_policy, r.client_ca, r.redirect_https, r.splits, r.http2, r.managed_certificate_domain, r.created_at, r.updated_at, c.id, c.cert, c.key, c.ocsp_response, c.created_at, c.updated_at FROM http_routes as r
 LEFT OUTER JOIN route_certificates AS rc on r.id = rc.http_route_id
 LEFT OUTER JOIN certificates AS c ON c.id = rc.certificate_id
 WHERE r.deleted_at IS NULL
 ORDER BY r.domain, r.path`
 	httpRouteListByParentRefQuery = `
-SELECT r.id, r.parent_ref, r.service, r.port, r.leader, r.drain_backends, r.domain, r.sticky, r.path, r.disable_keep_alives, r.managed_certificate_domain, r.created_at, r.updated_at, c.id, c.cert, c.key, c.created_at, c.updated_at FROM http_routes as r
+SELECT r.id, r.parent_ref, r.service, r.port, r.leader, r.drain_backends, r.domain, r.sticky, r.path, r.disable_keep_alives, r.tls_policy, r.client_ca, r.redirect_https, r.splits, r.http2, r.managed_certificate_domain, r.created_at, r.updated_at, c.id, c.cert, c.key, c.ocsp_response, c.created_at, c.updated_at FROM http_routes as r
 LEFT OUTER JOIN route_certificates AS rc on r.id = rc.http_route_id
 LEFT OUTER JOIN certificates AS c ON c.id = rc.certificate_id
 WHERE r.parent_ref = $1 AND r.deleted_at IS NULL
 ORDER BY r.domain, r.path`
 	httpRouteInsertQuery = `
-INSERT INTO http_routes (parent_ref, service, port, leader, drain_backends, domain, sticky, path, disable_keep_alives, managed_certificate_domain)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+INSERT INTO http_routes (parent_ref, service, port, leader, drain_backends, domain, sticky, path, disable_keep_alives, managed_certificate_domain, tls_policy, client_ca, redirect_https, splits, http2)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
 RETURNING id, path, created_at, updated_at`
 	httpRouteSelectQuery = `
-SELECT r.id, r.parent_ref, r.service, r.port, r.leader, r.drain_backends, r.domain, r.sticky, r.path, r.disable_keep_alives, r.managed_certificate_domain, r.created_at, r.updated_at, c.id, c.cert, c.key, c.created_at, c.updated_at FROM http_routes as r
+SELECT r.id, r.parent_ref, r.service, r.port, r.leader, r.drain_backends, r.domain, r.sticky, r.path, r.disable_keep_alives, r.tls_policy, r.client_ca, r.redirect_https, r.splits, r.http2, r.managed_certificate_domain, r.created_at, r.updated_at, c.id, c.cert, c.key, c.ocsp_response, c.created_at, c.updated_at FROM http_routes as r
 LEFT OUTER JOIN route_certificates AS rc on r.id = rc.http_route_id
 LEFT OUTER JOIN certificates AS c ON c.id = rc.certificate_id
 WHERE r.id = $1 AND r.deleted_at IS NULL`
+	httpRouteSelectForUpdateQuery = `
+SELECT r.id, r.parent_ref, r.service, r.port, r.leader, r.drain_backends, r.domain, r.sticky, r.path, r.disable_keep_alives, r.tls_policy, r.client_ca, r.redirect_https, r.splits, r.http2, r.managed_certificate_domain, r.created_at, r.updated_at, c.id, c.cert, c.key, c.ocsp_response, c.created_at, c.updated_at FROM http_routes as r
+LEFT OUTER JOIN route_certificates AS rc on r.id = rc.http_route_id
+LEFT OUTER JOIN certificates AS c ON c.id = rc.certificate_id
+WHERE r.id = $1 AND r.deleted_at IS NULL
+FOR UPDATE OF r`
 	httpRouteUpdateQuery = `
 UPDATE http_routes as r
-SET parent_ref = $1, service = $2, port = $3, leader = $4, sticky = $5, path = $6, disable_keep_alives = $7, managed_certificate_domain = $8
+SET parent_ref = $1, service = $2, port = $3, leader = $4, sticky = $5, path = $6, disable_keep_alives = $7, managed_certificate_domain = $8, tls_policy = $11, client_ca = $12, redirect_https = $13, splits = $14, http2 = $15
 WHERE id = $9 AND domain = $10 AND deleted_at IS NULL
-RETURNING r.id, r.parent_ref, r.service, r.port, r.leader, r.drain_backends, r.domain, r.sticky, r.path, r.disable_keep_alives, r.managed_certificate_domain, r.created_at, r.updated_at`
+RETURNING r.id, r.parent_ref, r.service, r.port, r.leader, r.drain_backends, r.domain, r.sticky, r.path, r.disable_keep_alives, r.tls_policy, r.client_ca, r.redirect_https, r.splits, r.http2, r.managed_certificate_domain, r.created_at, r.updated_at`
 	httpRouteDeleteQuery = `
 UPDATE http_routes SET deleted_at = now()
 WHERE id = $1`
+	httpRouteListIDsByManagedDomainQuery = `
+SELECT id FROM http_routes
+WHERE managed_certificate_domain = $1 AND deleted_at IS NULL ORDER BY created_at`
 	tcpRouteListQuery = `
 SELECT id, parent_ref, service, port, leader, drain_backends, created_at, updated_at FROM tcp_routes
 WHERE deleted_at IS NULL`
@@ -679,6 +741,10 @@ RETURNING id, port, created_at, updated_at`
 	tcpRouteSelectQuery = `
 SELECT id, parent_ref, service, port, leader, drain_backends, created_at, updated_at FROM tcp_routes
 WHERE id = $1 AND deleted_at IS NULL`
+	tcpRouteSelectForUpdateQuery = `
+SELECT id, parent_ref, service, port, leader, drain_backends, created_at, updated_at FROM tcp_routes
+WHERE id = $1 AND deleted_at IS NULL
+FOR UPDATE`
 	tcpRouteUpdateQuery = `
 UPDATE tcp_routes SET parent_ref = $1, service = $2, port = $3, leader = $4
 WHERE id = $5 AND deleted_at IS NULL
@@ -691,6 +757,9 @@ INSERT INTO certificates (cert, key, cert_sha256)
 VALUES ($1, $2, $3)
 ON CONFLICT (cert_sha256) WHERE deleted_at IS NULL DO UPDATE SET cert_sha256 = $3
 RETURNING id, created_at, updated_at`
+	certificateUpdateOCSPQuery = `
+UPDATE certificates SET ocsp_response = $2, updated_at = now()
+WHERE id = $1`
 	routeCertificateDeleteByRouteIDQuery = `
 DELETE FROM route_certificates
 WHERE http_route_id = $1`
@@ -700,25 +769,25 @@ VALUES ($1, $2)`
 
 	// managed certificates
 	managedCertificateListQuery = `
-SELECT id, domain, route_id, status, cert, key, cert_sha256, expires_at, last_error, last_error_at, created_at, updated_at
+SELECT id, domain, route_id, status, cert, key, cert_sha256, expires_at, last_error, last_error_at, retry_after, ocsp_response, ocsp_next_update, created_at, updated_at
 FROM managed_certificates
 WHERE deleted_at IS NULL
 ORDER BY created_at DESC`
 	managedCertificateListSinceQuery = `
-SELECT id, domain, route_id, status, cert, key, cert_sha256, expires_at, last_error, last_error_at, created_at, updated_at
+SELECT id, domain, route_id, status, cert, key, cert_sha256, expires_at, last_error, last_error_at, retry_after, ocsp_response, ocsp_next_update, created_at, updated_at
 FROM managed_certificates
 WHERE deleted_at IS NULL AND updated_at >= $1
 ORDER BY updated_at`
 	managedCertificateSelectQuery = `
-SELECT id, domain, route_id, status, cert, key, cert_sha256, expires_at, last_error, last_error_at, created_at, updated_at
+SELECT id, domain, route_id, status, cert, key, cert_sha256, expires_at, last_error, last_error_at, retry_after, ocsp_response, ocsp_next_update, created_at, updated_at
 FROM managed_certificates
 WHERE id = $1 AND deleted_at IS NULL`
 	managedCertificateSelectByDomainQuery = `
-SELECT id, domain, route_id, status, cert, key, cert_sha256, expires_at, last_error, last_error_at, created_at, updated_at
+SELECT id, domain, route_id, status, cert, key, cert_sha256, expires_at, last_error, last_error_at, retry_after, ocsp_response, ocsp_next_update, created_at, updated_at
 FROM managed_certificates
 WHERE domain = $1 AND deleted_at IS NULL`
 	managedCertificateSelectByRouteIDQuery = `
-SELECT id, domain, route_id, status, cert, key, cert_sha256, expires_at, last_error, last_error_at, created_at, updated_at
+SELECT id, domain, route_id, status, cert, key, cert_sha256, expires_at, last_error, last_error_at, retry_after, ocsp_response, ocsp_next_update, created_at, updated_at
 FROM managed_certificates
 WHERE route_id = $1 AND deleted_at IS NULL`
 	managedCertificateInsertQuery = `
@@ -733,7 +802,10 @@ UPDATE managed_certificates SET
 	cert_sha256 = $5,
 	expires_at = $6,
 	last_error = $7,
-	last_error_at = $8
+	last_error_at = $8,
+	retry_after = $9,
+	ocsp_response = $10,
+	ocsp_next_update = $11
 WHERE id = $1 AND deleted_at IS NULL
 RETURNING updated_at`
 	managedCertificateUpdateRouteIDQuery = `
@@ -744,14 +816,15 @@ RETURNING updated_at`
 UPDATE managed_certificates SET deleted_at = now()
 WHERE id = $1`
 	managedCertificateListExpiringQuery = `
-SELECT id, domain, route_id, status, cert, key, cert_sha256, expires_at, last_error, last_error_at, created_at, updated_at
+SELECT id, domain, route_id, status, cert, key, cert_sha256, expires_at, last_error, last_error_at, retry_after, ocsp_response, ocsp_next_update, created_at, updated_at
 FROM managed_certificates
 WHERE deleted_at IS NULL AND status = 'issued' AND expires_at <= $1
 ORDER BY expires_at`
 
 	// ACME configuration
 	acmeConfigSelectQuery = `
-SELECT enabled, contact_email, directory_url, terms_of_service_agreed, account_key, created_at, updated_at
+SELECT enabled, contact_email, directory_url, terms_of_service_agreed, account_key, eab_key_id, eab_hmac_key,
+	dns_webhook_url, dns_webhook_token, wildcard_default_domain, created_at, updated_at
 FROM acme_config WHERE id = 1`
 	acmeConfigUpdateQuery = `
 UPDATE acme_config SET
@@ -759,7 +832,139 @@ UPDATE acme_config SET
 	contact_email = $2,
 	directory_url = $3,
 	terms_of_service_agreed = $4,
-	account_key = $5
+	account_key = $5,
+	eab_key_id = $6,
+	eab_hmac_key = $7,
+	dns_webhook_url = $8,
+	dns_webhook_token = $9,
+	wildcard_default_domain = $10
 WHERE id = 1
 RETURNING updated_at`
+	acmeChallengeInsertQuery = `
+INSERT INTO acme_challenges (token, key_authorization) VALUES ($1, $2)
+ON CONFLICT (token) DO UPDATE SET key_authorization = $2, created_at = now()
+RETURNING created_at`
+	acmeChallengeSelectQuery = `
+SELECT token, key_authorization, created_at FROM acme_challenges WHERE token = $1`
+	acmeChallengeDeleteQuery = `
+DELETE FROM acme_challenges WHERE token = $1`
+	acmeChallengePruneQuery = `
+DELETE FROM acme_challenges WHERE created_at < $1`
+
+	// retention
+	retentionPruneEventsQuery = `
+DELETE FROM events WHERE event_id IN (
+	SELECT event_id FROM events
+	WHERE created_at < $1 AND object_type NOT IN ('deployment', 'managed_certificate')
+	ORDER BY event_id LIMIT $2
+)`
+	retentionPruneDeploymentsQuery = `
+WITH deleted AS (
+	DELETE FROM deployments WHERE deployment_id IN (
+		SELECT d.deployment_id FROM deployments d
+		WHERE d.finished_at < $1 AND d.deployment_id <> (
+			SELECT deployment_id FROM deployments
+			WHERE app_id = d.app_id
+			ORDER BY created_at DESC LIMIT 1
+		)
+		ORDER BY d.finished_at LIMIT $2
+	)
+	RETURNING deployment_id
+), deleted_events AS (
+	DELETE FROM events
+	WHERE object_type = 'deployment' AND object_id IN (SELECT deployment_id::text FROM deleted)
+)
+SELECT count(*) FROM deleted`
+	retentionPruneCertificateEventsQuery = `
+DELETE FROM events WHERE event_id IN (
+	SELECT event_id FROM events
+	WHERE created_at < $1 AND object_type = 'managed_certificate'
+	ORDER BY event_id LIMIT $2
+)`
+	retentionPruneDeletedCertificatesQuery = `
+DELETE FROM managed_certificates WHERE id IN (
+	SELECT id FROM managed_certificates
+	WHERE deleted_at < $1
+	LIMIT $2
+)`
+	// secret leases
+	secretLeaseInsertQuery = `
+INSERT INTO secret_leases (lease_id, provider, app_id, job_id, renewable, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (lease_id) DO NOTHING
+RETURNING created_at`
+	secretLeaseListQuery = `
+SELECT l.lease_id, l.provider, l.app_id, l.job_id, l.renewable, l.expires_at, l.created_at, j.state
+FROM secret_leases l LEFT JOIN job_cache j ON j.job_id = l.job_id
+ORDER BY l.expires_at`
+	secretLeaseUpdateExpiryQuery = `
+UPDATE secret_leases SET expires_at = $2 WHERE lease_id = $1`
+	secretLeaseDeleteQuery = `
+DELETE FROM secret_leases WHERE lease_id = $1`
+	// review apps
+	reviewAppInsertQuery = `
+INSERT INTO review_apps (app_id, parent_app_id, branch, expires_at)
+VALUES ($1, $2, $3, $4)
+RETURNING created_at, updated_at`
+	reviewAppSelectQuery = `
+SELECT r.app_id, a.name, r.parent_app_id, r.branch, r.expires_at, r.created_at, r.updated_at
+FROM review_apps r JOIN apps a USING (app_id)
+WHERE r.parent_app_id = $1 AND r.branch = $2 AND a.deleted_at IS NULL`
+	reviewAppListQuery = `
+SELECT r.app_id, a.name, r.parent_app_id, r.branch, r.expires_at, r.created_at, r.updated_at
+FROM review_apps r JOIN apps a USING (app_id)
+WHERE r.parent_app_id = $1 AND a.deleted_at IS NULL
+ORDER BY r.branch`
+	reviewAppListExpiredQuery = `
+SELECT r.app_id, a.name, r.parent_app_id, r.branch, r.expires_at, r.created_at, r.updated_at
+FROM review_apps r JOIN apps a USING (app_id)
+WHERE r.expires_at <= $1 AND a.deleted_at IS NULL
+ORDER BY r.expires_at`
+	reviewAppUpdateExpiryQuery = `
+UPDATE review_apps SET expires_at = $2, updated_at = now() WHERE app_id = $1
+RETURNING updated_at`
+	reviewAppDeleteQuery = `
+DELETE FROM review_apps WHERE app_id = $1`
+	reviewAppDeleteByAppQuery = `
+DELETE FROM review_apps WHERE app_id = $1`
+	// event sinks
+	eventSinkInsertQuery = `
+INSERT INTO event_sinks (event_sink_id, url, format, event_types, last_event_id)
+VALUES ($1, $2, $3, $4, (SELECT coalesce(max(event_id), 0) FROM events))
+RETURNING last_event_id, created_at, updated_at`
+	eventSinkSelectQuery = `
+SELECT event_sink_id, url, format, event_types, last_event_id, last_error, created_at, updated_at
+FROM event_sinks WHERE event_sink_id = $1 AND deleted_at IS NULL`
+	eventSinkListQuery = `
+SELECT event_sink_id, url, format, event_types, last_event_id, last_error, created_at, updated_at
+FROM event_sinks WHERE deleted_at IS NULL ORDER BY created_at`
+	eventSinkUpdateCursorQuery = `
+UPDATE event_sinks SET last_event_id = $2, last_error = $3, updated_at = now()
+WHERE event_sink_id = $1 AND deleted_at IS NULL`
+	eventSinkDeleteQuery = `
+UPDATE event_sinks SET deleted_at = now() WHERE event_sink_id = $1 AND deleted_at IS NULL`
+	// app stats
+	appStatsSnapshotInsertQuery = `
+INSERT INTO app_stats_snapshots (app_id, job_id, process_type, cpu_usage_percent, memory_bytes, network_rx_bytes, network_tx_bytes, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	appStatsSnapshotDeleteBeforeQuery = `
+DELETE FROM app_stats_snapshots WHERE created_at < $1`
+	// the network counters of each job are cumulative, so the bytes
+	// transferred between snapshots are the difference from the job's
+	// previous snapshot
+	appStatsRollupQuery = `
+WITH samples AS (
+  SELECT created_at, cpu_usage_percent, memory_bytes,
+    network_rx_bytes - lag(network_rx_bytes) OVER w AS rx,
+    network_tx_bytes - lag(network_tx_bytes) OVER w AS tx
+  FROM app_stats_snapshots
+  WHERE app_id = $1 AND created_at >= $3
+  WINDOW w AS (PARTITION BY job_id ORDER BY created_at)
+), totals AS (
+  SELECT created_at, sum(cpu_usage_percent) AS cpu, sum(memory_bytes) AS memory,
+    sum(greatest(coalesce(rx, 0), 0)) AS rx, sum(greatest(coalesce(tx, 0), 0)) AS tx
+  FROM samples GROUP BY created_at
+)
+SELECT date_trunc($2, created_at) AS period, avg(cpu), max(cpu), avg(memory)::bigint, max(memory)::bigint, sum(rx)::bigint, sum(tx)::bigint
+FROM totals GROUP BY period ORDER BY period`
 )
@@ -47,6 +47,27 @@ func scanRelease(s postgres.Scanner) (*ct.Release, error) {
 	return release, err
 }
 
+// validateEnvRefs checks that env values referring to resource env vars or
+// secrets are well formed. The resources and secrets themselves are checked
+// when jobs are started as they may be created after the release is.
+func validateEnvRefs(env map[string]string) error {
+	for k, v := range env {
+		if _, _, _, err := ct.ParseResourceEnvRef(v); err != nil {
+			return ct.ValidationError{
+				Field:   "env",
+				Message: fmt.Sprintf("%s: %s", k, err),
+			}
+		}
+		if _, _, _, _, err := ct.ParseSecretEnvRef(v); err != nil {
+			return ct.ValidationError{
+				Field:   "env",
+				Message: fmt.Sprintf("%s: %s", k, err),
+			}
+		}
+	}
+	return nil
+}
+
 func (r *ReleaseRepo) Add(data interface{}) error {
 	release := data.(*ct.Release)
 
@@ -81,6 +102,19 @@ func (r *ReleaseRepo) Add(data interface{}) error {
 		}
 	}
 
+	if err := validateProcesses(release.Processes); err != nil {
+		return err
+	}
+
+	if err := validateEnvRefs(release.Env); err != nil {
+		return err
+	}
+	for _, proc := range release.Processes {
+		if err := validateEnvRefs(proc.Env); err != nil {
+			return err
+		}
+	}
+
 	tx, err := r.db.Begin()
 	if err != nil {
 		return err
@@ -0,0 +1,141 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	ct "github.com/flynn/flynn/controller/types"
+)
+
+// ValidateJSON checks the process types of a release in its JSON encoding
+// for unknown keys, which would otherwise be silently dropped when decoding.
+func (r *ReleaseRepo) ValidateJSON(data []byte) error {
+	var release struct {
+		Processes map[string]json.RawMessage `json:"processes"`
+	}
+	if err := json.Unmarshal(data, &release); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(release.Processes))
+	for name := range release.Processes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs ct.ValidationErrors
+	for _, name := range names {
+		dec := json.NewDecoder(bytes.NewReader(release.Processes[name]))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&ct.ProcessType{}); err != nil {
+			errs = append(errs, ct.ValidationError{
+				Field:   "processes." + name,
+				Message: strings.TrimPrefix(err.Error(), "json: "),
+			})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateProcesses checks the process types of a release for definitions
+// which would otherwise only fail once their jobs are started on hosts, such
+// as invalid ports, services or health checks and conflicting volume
+// targets, returning an error for each invalid field.
+func validateProcesses(procs map[string]ct.ProcessType) error {
+	names := make([]string, 0, len(procs))
+	for name := range procs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs ct.ValidationErrors
+	for _, name := range names {
+		errs = append(errs, validateProcess("processes."+name, procs[name])...)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateProcess(field string, proc ct.ProcessType) ct.ValidationErrors {
+	var errs ct.ValidationErrors
+	invalid := func(f, format string, args ...interface{}) {
+		errs = append(errs, ct.ValidationError{Field: f, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if _, ok := proc.Env[""]; ok {
+		invalid(field+".env", "must not contain an empty key")
+	}
+
+	ports := make(map[string]string, len(proc.Ports))
+	services := make(map[string]string, len(proc.Ports))
+	for i, port := range proc.Ports {
+		f := fmt.Sprintf("%s.ports.%d", field, i)
+		if port.Port < 0 || port.Port > 65535 {
+			invalid(f+".port", "must be between 0 and 65535, got %d", port.Port)
+		}
+		switch port.Proto {
+		case "", "tcp", "udp":
+		default:
+			invalid(f+".proto", "must be tcp or udp, got %q", port.Proto)
+		}
+		if port.Port > 0 {
+			key := fmt.Sprintf("%d/%s", port.Port, port.Proto)
+			if other, ok := ports[key]; ok {
+				invalid(f+".port", "conflicts with %s", other)
+			}
+			ports[key] = f
+		}
+
+		s := port.Service
+		if s == nil {
+			continue
+		}
+		if s.Name == "" {
+			invalid(f+".service.name", "must be set")
+		} else if other, ok := services[s.Name]; ok {
+			invalid(f+".service.name", "service %q is already registered by %s", s.Name, other)
+		} else {
+			services[s.Name] = f
+		}
+		if s.Check != nil {
+			switch s.Check.Type {
+			case "tcp", "http", "https":
+				if port.Proto == "udp" {
+					invalid(f+".service.check.type", "%s checks cannot be used with udp ports", s.Check.Type)
+				}
+			default:
+				invalid(f+".service.check.type", "must be tcp, http or https, got %q", s.Check.Type)
+			}
+		}
+	}
+
+	targets := make(map[string]string, len(proc.Volumes)+len(proc.Mounts))
+	for i, mount := range proc.Mounts {
+		if mount.Target != "" {
+			targets[path.Clean(mount.Target)] = fmt.Sprintf("%s.mounts.%d", field, i)
+		}
+	}
+	for i, vol := range proc.Volumes {
+		f := fmt.Sprintf("%s.volumes.%d.path", field, i)
+		if !strings.HasPrefix(vol.Path, "/") {
+			invalid(f, "must be an absolute path, got %q", vol.Path)
+			continue
+		}
+		target := path.Clean(vol.Path)
+		if other, ok := targets[target]; ok {
+			invalid(f, "%s conflicts with %s", target, other)
+			continue
+		}
+		targets[target] = f
+	}
+
+	return errs
+}
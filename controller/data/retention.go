@@ -0,0 +1,59 @@
+package data
+
+import (
+	"time"
+
+	"github.com/flynn/flynn/pkg/postgres"
+)
+
+// RetentionRepo deletes historical records which are no longer needed.
+//
+// Each method deletes at most limit rows in a single short transaction so
+// that callers can prune large tables in batches, pausing between them to
+// give autovacuum a chance to reclaim the dead tuples rather than leaving
+// a single long-running delete which bloats the table and blocks vacuum.
+type RetentionRepo struct {
+	db *postgres.DB
+}
+
+func NewRetentionRepo(db *postgres.DB) *RetentionRepo {
+	return &RetentionRepo{db: db}
+}
+
+// PruneEvents deletes events created before the given time, other than
+// deployment events (which are pruned with their deployment, see
+// PruneDeployments) and managed certificate events (see
+// PruneCertificateErrors), returning the number of events deleted.
+func (r *RetentionRepo) PruneEvents(before time.Time, limit int) (int64, error) {
+	return r.exec("retention_prune_events", before, limit)
+}
+
+// PruneDeployments deletes deployments which finished before the given
+// time along with their events, returning the number of deployments
+// deleted. The most recent deployment of each app is always kept.
+func (r *RetentionRepo) PruneDeployments(before time.Time, limit int) (int64, error) {
+	var count int64
+	err := r.db.QueryRow("retention_prune_deployments", before, limit).Scan(&count)
+	return count, err
+}
+
+// PruneCertificateErrors deletes managed certificate events, which record
+// the history of issuance errors, created before the given time along with
+// certificates which were deleted before the given time, returning the
+// number of rows deleted.
+func (r *RetentionRepo) PruneCertificateErrors(before time.Time, limit int) (int64, error) {
+	events, err := r.exec("retention_prune_certificate_events", before, limit)
+	if err != nil {
+		return events, err
+	}
+	certs, err := r.exec("retention_prune_deleted_certificates", before, limit)
+	return events + certs, err
+}
+
+func (r *RetentionRepo) exec(query string, args ...interface{}) (int64, error) {
+	tag, err := r.db.ConnPool.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,98 @@
+package data
+
+import (
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/postgres"
+	"github.com/jackc/pgx"
+)
+
+// ReviewAppRepo stores the review apps created for branches pushed to apps
+// along with when they expire.
+type ReviewAppRepo struct {
+	db *postgres.DB
+}
+
+func NewReviewAppRepo(db *postgres.DB) *ReviewAppRepo {
+	return &ReviewAppRepo{db: db}
+}
+
+// Add stores a review app once its app has been created.
+func (r *ReviewAppRepo) Add(app *ct.ReviewApp) error {
+	return r.db.QueryRow(
+		"review_app_insert",
+		app.AppID,
+		app.ParentAppID,
+		app.Branch,
+		app.ExpiresAt,
+	).Scan(&app.CreatedAt, &app.UpdatedAt)
+}
+
+func scanReviewApp(s postgres.Scanner) (*ct.ReviewApp, error) {
+	app := &ct.ReviewApp{}
+	err := s.Scan(
+		&app.AppID,
+		&app.AppName,
+		&app.ParentAppID,
+		&app.Branch,
+		&app.ExpiresAt,
+		&app.CreatedAt,
+		&app.UpdatedAt,
+	)
+	if err == pgx.ErrNoRows {
+		err = ErrNotFound
+	}
+	return app, err
+}
+
+// Get returns the review app of the given app for a branch.
+func (r *ReviewAppRepo) Get(parentAppID, branch string) (*ct.ReviewApp, error) {
+	app, err := scanReviewApp(r.db.QueryRow("review_app_select", parentAppID, branch))
+	if err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
+// List returns the review apps of the given app ordered by branch.
+func (r *ReviewAppRepo) List(parentAppID string) ([]*ct.ReviewApp, error) {
+	return r.list("review_app_list", parentAppID)
+}
+
+// ListExpired returns the review apps which expired before the given time.
+func (r *ReviewAppRepo) ListExpired(now time.Time) ([]*ct.ReviewApp, error) {
+	return r.list("review_app_list_expired", now)
+}
+
+func (r *ReviewAppRepo) list(query string, args ...interface{}) ([]*ct.ReviewApp, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var apps []*ct.ReviewApp
+	for rows.Next() {
+		app, err := scanReviewApp(rows)
+		if err != nil {
+			return nil, err
+		}
+		apps = append(apps, app)
+	}
+	return apps, rows.Err()
+}
+
+// UpdateExpiry sets when a review app expires after its branch is pushed
+// again.
+func (r *ReviewAppRepo) UpdateExpiry(app *ct.ReviewApp) error {
+	err := r.db.QueryRow("review_app_update_expiry", app.AppID, app.ExpiresAt).Scan(&app.UpdatedAt)
+	if err == pgx.ErrNoRows {
+		err = ErrNotFound
+	}
+	return err
+}
+
+// Remove removes a review app before its app is deleted.
+func (r *ReviewAppRepo) Remove(appID string) error {
+	return r.db.Exec("review_app_delete", appID)
+}
@@ -24,17 +24,78 @@ var (
 	ErrRouteUnreservedHTTP  = errors.New("controller: cannot route HTTP to a non-HTTP port")
 	ErrRouteUnreservedHTTPS = errors.New("controller: cannot route HTTPS to a non-HTTPS port")
 	ErrRouteInvalid         = errors.New("controller: invalid route")
+	ErrRouteStale           = errors.New("controller: route has been updated since it was read")
 )
 
 type RouteRepo struct {
 	db *postgres.DB
+
+	// httpPorts and httpsPorts are the non-default ports the router
+	// serves HTTP and HTTPS traffic on
+	httpPorts  []int
+	httpsPorts []int
 }
 
 func NewRouteRepo(db *postgres.DB) *RouteRepo {
 	return &RouteRepo{db: db}
 }
 
+// SetHTTPPorts sets the additional ports the router is configured to serve
+// HTTP and HTTPS traffic on, which HTTP routes may be bound to and TCP routes
+// may not.
+func (r *RouteRepo) SetHTTPPorts(httpPorts, httpsPorts []int) {
+	r.httpPorts = httpPorts
+	r.httpsPorts = httpsPorts
+}
+
+func containsPort(ports []int, port int32) bool {
+	for _, p := range ports {
+		if p == int(port) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHTTPPort checks that a HTTP route with a non-default port is bound to
+// a port the router serves HTTP or HTTPS on, and that routes with TLS
+// certificates are bound to a HTTPS port.
+func (r *RouteRepo) checkHTTPPort(route *router.Route) error {
+	if route.Port == 0 || containsPort(r.httpsPorts, route.Port) {
+		return nil
+	}
+	if !containsPort(r.httpPorts, route.Port) {
+		return ErrRouteUnreservedHTTP
+	}
+	hasCert := route.Certificate != nil && (route.Certificate.Cert != "" || route.Certificate.Key != "")
+	hasLegacyCert := route.LegacyTLSCert != "" || route.LegacyTLSKey != ""
+	hasManagedCert := route.ManagedCertificateDomain != nil && *route.ManagedCertificateDomain != ""
+	if hasCert || hasLegacyCert || hasManagedCert {
+		return ErrRouteUnreservedHTTPS
+	}
+	return nil
+}
+
+// checkTCPPort checks that a TCP route is not bound to a port reserved for
+// HTTP or HTTPS traffic.
+func (r *RouteRepo) checkTCPPort(route *router.Route) error {
+	if route.Port == 80 || route.Port == 443 || containsPort(r.httpPorts, route.Port) || containsPort(r.httpsPorts, route.Port) {
+		return ErrRouteReserved
+	}
+	return nil
+}
+
 func (r *RouteRepo) Add(route *router.Route) error {
+	return r.add(route, false)
+}
+
+// AddDryRun validates adding the route by adding it in a transaction which
+// is then rolled back, populating the route as Add would.
+func (r *RouteRepo) AddDryRun(route *router.Route) error {
+	return r.add(route, true)
+}
+
+func (r *RouteRepo) add(route *router.Route, dryRun bool) error {
 	tx, err := r.db.Begin()
 	if err != nil {
 		return err
@@ -57,6 +118,9 @@ func (r *RouteRepo) Add(route *router.Route) error {
 		return err
 	}
 
+	if dryRun {
+		return tx.Rollback()
+	}
 	if err := r.createEvent(tx, route, ct.EventTypeRoute); err != nil {
 		tx.Rollback()
 		return err
@@ -65,9 +129,8 @@ func (r *RouteRepo) Add(route *router.Route) error {
 }
 
 func (r *RouteRepo) addHTTP(tx *postgres.DBTx, route *router.Route) error {
-	// TODO: support non-default HTTP ports
-	if route.Port > 0 {
-		return ErrRouteInvalid
+	if err := r.checkHTTPPort(route); err != nil {
+		return err
 	}
 	if err := tx.QueryRow(
 		"http_route_insert",
@@ -81,6 +144,11 @@ func (r *RouteRepo) addHTTP(tx *postgres.DBTx, route *router.Route) error {
 		route.Path,
 		route.DisableKeepAlives,
 		route.ManagedCertificateDomain,
+		route.TLSPolicy,
+		route.ClientCA,
+		route.RedirectHTTPS,
+		route.Splits,
+		route.HTTP2,
 	).Scan(&route.ID, &route.Path, &route.CreatedAt, &route.UpdatedAt); err != nil {
 		return err
 	}
@@ -147,12 +215,14 @@ func (r *RouteRepo) ensureManagedCertificate(tx *postgres.DBTx, route *router.Ro
 		&existingCert.ExpiresAt,
 		&existingCert.LastError,
 		&existingCert.LastErrorAt,
+		&existingCert.RetryAfter,
 		&existingCert.CreatedAt,
 		&existingCert.UpdatedAt,
 	)
 	if err == pgx.ErrNoRows {
-		// No certificate exists, create one
-		return r.createManagedCertificate(tx, route)
+		// No certificate exists for this route, but one may exist for
+		// the domain if it is also routed on another port
+		return r.ensureManagedCertificateByDomain(tx, route)
 	}
 	if err != nil {
 		return err
@@ -203,6 +273,7 @@ func (r *RouteRepo) ensureManagedCertificateByDomain(tx *postgres.DBTx, route *r
 		&existingCert.ExpiresAt,
 		&existingCert.LastError,
 		&existingCert.LastErrorAt,
+		&existingCert.RetryAfter,
 		&existingCert.CreatedAt,
 		&existingCert.UpdatedAt,
 	)
@@ -302,7 +373,7 @@ func (r *RouteRepo) resetManagedCertificateToPending(tx *postgres.DBTx, cert *ct
 
 	if err := tx.QueryRow("managed_certificate_update",
 		cert.ID, cert.Status, cert.Cert, cert.Key, nil, // keep existing cert/key for reference
-		cert.ExpiresAt, cert.LastError, cert.LastErrorAt,
+		cert.ExpiresAt, cert.LastError, cert.LastErrorAt, cert.RetryAfter,
 	).Scan(&cert.UpdatedAt); err != nil {
 		return err
 	}
@@ -316,9 +387,8 @@ func (r *RouteRepo) resetManagedCertificateToPending(tx *postgres.DBTx, cert *ct
 }
 
 func (r *RouteRepo) addTCP(tx *postgres.DBTx, route *router.Route) error {
-	// TODO: check non-default HTTP ports if set
-	if route.Port == 80 || route.Port == 443 {
-		return ErrRouteReserved
+	if err := r.checkTCPPort(route); err != nil {
+		return err
 	}
 	return tx.QueryRow(
 		"tcp_route_insert",
@@ -421,6 +491,7 @@ func scanHTTPRoute(s postgres.Scanner) (*router.Route, error) {
 		certID                   *string
 		certCert                 *string
 		certKey                  *string
+		certOCSPResponse         []byte
 		certCreatedAt            *time.Time
 		certUpdatedAt            *time.Time
 	)
@@ -435,12 +506,18 @@ func scanHTTPRoute(s postgres.Scanner) (*router.Route, error) {
 		&route.Sticky,
 		&route.Path,
 		&route.DisableKeepAlives,
+		&route.TLSPolicy,
+		&route.ClientCA,
+		&route.RedirectHTTPS,
+		&route.Splits,
+		&route.HTTP2,
 		&managedCertificateDomain,
 		&route.CreatedAt,
 		&route.UpdatedAt,
 		&certID,
 		&certCert,
 		&certKey,
+		&certOCSPResponse,
 		&certCreatedAt,
 		&certUpdatedAt,
 	); err != nil {
@@ -450,11 +527,12 @@ func scanHTTPRoute(s postgres.Scanner) (*router.Route, error) {
 	route.Type = "http"
 	if certID != nil {
 		route.Certificate = &router.Certificate{
-			ID:        *certID,
-			Cert:      *certCert,
-			Key:       *certKey,
-			CreatedAt: *certCreatedAt,
-			UpdatedAt: *certUpdatedAt,
+			ID:           *certID,
+			Cert:         *certCert,
+			Key:          *certKey,
+			OCSPResponse: certOCSPResponse,
+			CreatedAt:    *certCreatedAt,
+			UpdatedAt:    *certUpdatedAt,
 		}
 	}
 	return &route, nil
@@ -545,15 +623,44 @@ func (r *RouteRepo) listTCP(parentRef string) ([]*router.Route, error) {
 }
 
 func (r *RouteRepo) Update(route *router.Route) error {
+	return r.update(route, false)
+}
+
+// UpdateDryRun validates updating the route by updating it in a transaction
+// which is then rolled back, populating the route as Update would.
+func (r *RouteRepo) UpdateDryRun(route *router.Route) error {
+	return r.update(route, true)
+}
+
+func (r *RouteRepo) update(route *router.Route, dryRun bool) error {
 	tx, err := r.db.Begin()
 	if err != nil {
 		return err
 	}
-	switch route.Type {
+	if err := r.updateWithTx(tx, route); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return r.commitUpdate(tx, route, dryRun)
+}
+
+// Patch updates only the fields of the route which are set in the patch,
+// returning the updated route. If the patch sets UpdatedAt and the route has
+// been updated since then, ErrRouteStale is returned along with the current
+// route.
+func (r *RouteRepo) Patch(typ, id string, patch *router.RoutePatch) (*router.Route, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// lock the route so that it is not updated between being read and
+	// the patched route being written
+	var route *router.Route
+	switch typ {
 	case "http":
-		err = r.updateHTTP(tx, route)
+		route, err = scanHTTPRoute(tx.QueryRow("http_route_select_for_update", id))
 	case "tcp":
-		err = r.updateTCP(tx, route)
+		route, err = scanTCPRoute(tx.QueryRow("tcp_route_select_for_update", id))
 	default:
 		err = ErrRouteNotFound
 	}
@@ -562,9 +669,45 @@ func (r *RouteRepo) Update(route *router.Route) error {
 	}
 	if err != nil {
 		tx.Rollback()
-		return err
+		return nil, err
+	}
+	if patch.UpdatedAt != nil && !patch.UpdatedAt.Equal(route.UpdatedAt) {
+		tx.Rollback()
+		return route, ErrRouteStale
+	}
+
+	patch.Apply(route)
+	if err := r.updateWithTx(tx, route); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return route, r.commitUpdate(tx, route, false)
+}
+
+func (r *RouteRepo) updateWithTx(tx *postgres.DBTx, route *router.Route) error {
+	var err error
+	switch route.Type {
+	case "http":
+		err = r.updateHTTP(tx, route)
+	case "tcp":
+		err = r.updateTCP(tx, route)
+	default:
+		err = ErrRouteNotFound
 	}
+	if err == pgx.ErrNoRows {
+		err = ErrRouteNotFound
+	} else if postgres.IsUniquenessError(err, "") {
+		err = ErrRouteConflict
+	}
+	return err
+}
 
+// commitUpdate commits an updated route along with a route event, or rolls
+// it back for a dry run.
+func (r *RouteRepo) commitUpdate(tx *postgres.DBTx, route *router.Route, dryRun bool) error {
+	if dryRun {
+		return tx.Rollback()
+	}
 	if err := r.createEvent(tx, route, ct.EventTypeRoute); err != nil {
 		tx.Rollback()
 		return err
@@ -573,6 +716,9 @@ func (r *RouteRepo) Update(route *router.Route) error {
 }
 
 func (r *RouteRepo) updateHTTP(tx *postgres.DBTx, route *router.Route) error {
+	if err := r.checkHTTPPort(route); err != nil {
+		return err
+	}
 	if err := tx.QueryRow(
 		"http_route_update",
 		route.ParentRef,
@@ -585,6 +731,11 @@ func (r *RouteRepo) updateHTTP(tx *postgres.DBTx, route *router.Route) error {
 		route.ManagedCertificateDomain,
 		route.ID,
 		route.Domain,
+		route.TLSPolicy,
+		route.ClientCA,
+		route.RedirectHTTPS,
+		route.Splits,
+		route.HTTP2,
 	).Scan(
 		&route.ID,
 		&route.ParentRef,
@@ -596,6 +747,11 @@ func (r *RouteRepo) updateHTTP(tx *postgres.DBTx, route *router.Route) error {
 		&route.Sticky,
 		&route.Path,
 		&route.DisableKeepAlives,
+		&route.TLSPolicy,
+		&route.ClientCA,
+		&route.RedirectHTTPS,
+		&route.Splits,
+		&route.HTTP2,
 		&route.ManagedCertificateDomain,
 		&route.CreatedAt,
 		&route.UpdatedAt,
@@ -629,6 +785,9 @@ func (r *RouteRepo) updateHTTP(tx *postgres.DBTx, route *router.Route) error {
 }
 
 func (r *RouteRepo) updateTCP(tx *postgres.DBTx, route *router.Route) error {
+	if err := r.checkTCPPort(route); err != nil {
+		return err
+	}
 	return tx.QueryRow(
 		"tcp_route_update",
 		route.ParentRef,
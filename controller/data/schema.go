@@ -1003,6 +1003,147 @@ ALTER TABLE http_routes ADD COLUMN disable_keep_alives boolean NOT NULL DEFAULT
 		// Insert default row (ACME disabled by default)
 		`INSERT INTO acme_config (id, enabled) VALUES (1, false)`,
 	)
+	migrations.Add(52,
+		// Add the final output of crashed jobs
+		`ALTER TABLE job_cache ADD COLUMN log_tail jsonb`,
+	)
+	migrations.Add(53,
+		// Support pruning old events and looking up the events of
+		// deployments being pruned (also used by deployment_status)
+		`CREATE INDEX events_created_at_idx ON events (created_at)`,
+		`CREATE INDEX events_deployment_object_id_idx ON events (object_id) WHERE object_type = 'deployment'`,
+		`CREATE INDEX deployments_finished_at_idx ON deployments (finished_at) WHERE finished_at IS NOT NULL`,
+	)
+	migrations.Add(54,
+		// Per-route TLS policy overriding the router's default
+		`ALTER TABLE http_routes ADD COLUMN tls_policy jsonb`,
+	)
+	migrations.Add(55,
+		// CA certificates which clients of a route must present a
+		// certificate signed by
+		`ALTER TABLE http_routes ADD COLUMN client_ca text NOT NULL DEFAULT ''`,
+	)
+	migrations.Add(56,
+		// Leases on secrets resolved from external secrets backends
+		// for jobs, renewed and revoked by the controller worker
+		`CREATE TABLE secret_leases (
+			lease_id text PRIMARY KEY,
+			provider text NOT NULL,
+			app_id uuid NOT NULL REFERENCES apps (app_id),
+			job_id uuid NOT NULL,
+			renewable boolean NOT NULL DEFAULT false,
+			expires_at timestamptz NOT NULL,
+			created_at timestamptz NOT NULL DEFAULT now()
+		)`,
+		`CREATE INDEX secret_leases_job_id_idx ON secret_leases (job_id)`,
+	)
+	migrations.Add(57,
+		// Endpoints which cluster-wide events are forwarded to by the
+		// controller worker, starting from the latest event when added
+		`CREATE TABLE event_sinks (
+			event_sink_id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+			url text NOT NULL,
+			format text NOT NULL,
+			event_types text[] NOT NULL,
+			last_event_id bigint NOT NULL DEFAULT 0,
+			last_error text NOT NULL DEFAULT '',
+			created_at timestamptz NOT NULL DEFAULT now(),
+			updated_at timestamptz NOT NULL DEFAULT now(),
+			deleted_at timestamptz
+		)`,
+	)
+	migrations.Add(58,
+		// Resource usage of the old release's jobs, recorded when a
+		// deployment completes
+		`ALTER TABLE deployments ADD COLUMN old_release_usage jsonb`,
+	)
+	migrations.Add(59,
+		// When a managed certificate may next be ordered after the ACME
+		// CA rate limited an order for it
+		`ALTER TABLE managed_certificates ADD COLUMN retry_after timestamptz`,
+	)
+	migrations.Add(60,
+		// External Account Binding credentials for ACME CAs which
+		// require accounts to be bound to an existing account with them
+		`ALTER TABLE acme_config ADD COLUMN eab_key_id varchar(255)`,
+		`ALTER TABLE acme_config ADD COLUMN eab_hmac_key text`,
+	)
+	migrations.Add(61,
+		// Pending ACME HTTP-01 challenge responses, shared by all
+		// instances of the ACME service
+		`CREATE TABLE acme_challenges (
+			token text PRIMARY KEY,
+			key_authorization text NOT NULL,
+			created_at timestamptz NOT NULL DEFAULT now()
+		)`,
+	)
+	migrations.Add(62,
+		// Whether plaintext requests to a route with a certificate are
+		// redirected to HTTPS
+		`ALTER TABLE http_routes ADD COLUMN redirect_https boolean NOT NULL DEFAULT false`,
+	)
+	migrations.Add(63,
+		// Temporary apps created for branches pushed to an app, which
+		// the controller worker deletes once they expire
+		`CREATE TABLE review_apps (
+			app_id uuid PRIMARY KEY REFERENCES apps (app_id),
+			parent_app_id uuid NOT NULL REFERENCES apps (app_id),
+			branch text NOT NULL,
+			expires_at timestamptz NOT NULL,
+			created_at timestamptz NOT NULL DEFAULT now(),
+			updated_at timestamptz NOT NULL DEFAULT now()
+		)`,
+		`CREATE UNIQUE INDEX review_apps_parent_app_id_branch_idx ON review_apps (parent_app_id, branch)`,
+		`CREATE INDEX review_apps_expires_at_idx ON review_apps (expires_at)`,
+	)
+	migrations.Add(64,
+		// OCSP responses for managed certificates, which are copied to
+		// the route certificates so the router can staple them
+		`ALTER TABLE managed_certificates ADD COLUMN ocsp_response bytea`,
+		`ALTER TABLE managed_certificates ADD COLUMN ocsp_next_update timestamptz`,
+		`ALTER TABLE certificates ADD COLUMN ocsp_response bytea`,
+	)
+	migrations.Add(65,
+		// services which are sent a percentage of an HTTP route's
+		// requests, for example a canary service during a deploy
+		`ALTER TABLE http_routes ADD COLUMN splits jsonb`,
+	)
+	migrations.Add(66,
+		// periodic samples of the resource usage of app jobs, which are
+		// rolled up to graph app usage
+		`CREATE TABLE app_stats_snapshots (
+			app_id uuid NOT NULL REFERENCES apps (app_id) ON DELETE CASCADE,
+			job_id text NOT NULL,
+			process_type text,
+			cpu_usage_percent double precision NOT NULL,
+			memory_bytes bigint NOT NULL,
+			network_rx_bytes bigint NOT NULL,
+			network_tx_bytes bigint NOT NULL,
+			created_at timestamptz NOT NULL
+		)`,
+		`CREATE INDEX app_stats_snapshots_app_id_created_at_idx ON app_stats_snapshots (app_id, created_at)`,
+		`CREATE INDEX app_stats_snapshots_created_at_idx ON app_stats_snapshots (created_at)`,
+	)
+	migrations.Add(67,
+		// whether HTTP routes are proxied to backends using h2c, for
+		// gRPC services
+		`ALTER TABLE http_routes ADD COLUMN http2 boolean NOT NULL DEFAULT false`,
+	)
+	migrations.Add(68,
+		// the webhook publishing DNS-01 challenge records, and whether
+		// default app routes share a wildcard managed certificate
+		`ALTER TABLE acme_config ADD COLUMN dns_webhook_url varchar(512)`,
+		`ALTER TABLE acme_config ADD COLUMN dns_webhook_token text`,
+		`ALTER TABLE acme_config ADD COLUMN wildcard_default_domain boolean NOT NULL DEFAULT false`,
+	)
+	migrations.Add(69,
+		// the trace context of the deploy which requested a scale, so
+		// that the scheduler's job launch spans are part of its trace
+		`ALTER TABLE scale_requests ADD COLUMN trace_parent text`,
+	)
+	migrations.Add(70,
+		`INSERT INTO deployment_strategies (name) VALUES ('canary')`,
+	)
 }
 
 func MigrateDB(db *postgres.DB) error {
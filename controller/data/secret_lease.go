@@ -0,0 +1,85 @@
+package data
+
+import (
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/postgres"
+	"github.com/jackc/pgx"
+)
+
+// SecretLeaseRepo stores the leases on secrets which were resolved for jobs
+// so they can be renewed while the jobs run and revoked once they stop.
+type SecretLeaseRepo struct {
+	db *postgres.DB
+}
+
+func NewSecretLeaseRepo(db *postgres.DB) *SecretLeaseRepo {
+	return &SecretLeaseRepo{db: db}
+}
+
+// Add stores the given leases.
+func (r *SecretLeaseRepo) Add(leases []*ct.SecretLease) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, lease := range leases {
+		err := tx.QueryRow(
+			"secret_lease_insert",
+			lease.ID,
+			lease.Provider,
+			lease.AppID,
+			lease.JobID,
+			lease.Renewable,
+			lease.ExpiresAt,
+		).Scan(&lease.CreatedAt)
+		if err != nil && err != pgx.ErrNoRows {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// List returns all leases ordered by when they expire, along with the state
+// of their jobs.
+func (r *SecretLeaseRepo) List() ([]*ct.SecretLease, error) {
+	rows, err := r.db.Query("secret_lease_list")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var leases []*ct.SecretLease
+	for rows.Next() {
+		lease := &ct.SecretLease{}
+		var jobState *string
+		if err := rows.Scan(
+			&lease.ID,
+			&lease.Provider,
+			&lease.AppID,
+			&lease.JobID,
+			&lease.Renewable,
+			&lease.ExpiresAt,
+			&lease.CreatedAt,
+			&jobState,
+		); err != nil {
+			return nil, err
+		}
+		if jobState != nil {
+			lease.JobState = ct.JobState(*jobState)
+		}
+		leases = append(leases, lease)
+	}
+	return leases, rows.Err()
+}
+
+// UpdateExpiry sets when a lease expires after it has been renewed.
+func (r *SecretLeaseRepo) UpdateExpiry(id string, expiresAt time.Time) error {
+	return r.db.Exec("secret_lease_update_expiry", id, expiresAt)
+}
+
+// Remove removes a lease which has been revoked or has expired.
+func (r *SecretLeaseRepo) Remove(id string) error {
+	return r.db.Exec("secret_lease_delete", id)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+
+	"github.com/flynn/flynn/controller/authz"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"golang.org/x/net/context"
+)
+
+var releaseIDPattern = regexp.MustCompile(`^[a-f0-9]{8}-?([a-f0-9]{4}-?){3}[a-f0-9]{12}$`)
+
+// GetAppEnvDiff compares the environment of the app's current release with
+// the release or app given in the other query parameter, redacting the
+// values of secrets.
+func (c *controllerAPI) GetAppEnvDiff(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	app := c.getApp(ctx)
+	other := req.FormValue("other")
+	if other == "" {
+		httphelper.ValidationError(w, "other", "must be set to an app or release")
+		return
+	}
+
+	from, err := c.appRepo.GetRelease(app.ID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	to, err := c.lookupEnvDiffRelease(other)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	// the other release may belong to an app the credential cannot read
+	if to.AppID != app.ID {
+		auth, err := c.authorizer.AuthorizeRequest(req)
+		if err != nil || !authz.HTTPAllowed(auth, "GET", "/apps/"+to.AppID) {
+			httphelper.Forbidden(w, "this credential is not allowed to read "+other)
+			return
+		}
+	}
+
+	httphelper.JSON(w, 200, diffReleaseEnv(from, to))
+}
+
+// lookupEnvDiffRelease returns the current release of the app with the given
+// name or ID, or otherwise the release with the given ID.
+func (c *controllerAPI) lookupEnvDiffRelease(id string) (*ct.Release, error) {
+	data, err := c.appRepo.Get(id)
+	if err == nil {
+		return c.appRepo.GetRelease(data.(*ct.App).ID)
+	} else if err != ErrNotFound || !releaseIDPattern.MatchString(id) {
+		return nil, err
+	}
+	release, err := c.releaseRepo.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return release.(*ct.Release), nil
+}
+
+// diffReleaseEnv returns the changes to the release and process type env
+// going from one release to another, sorted by process type and key.
+func diffReleaseEnv(from, to *ct.Release) *ct.EnvDiff {
+	diff := &ct.EnvDiff{
+		FromAppID:     from.AppID,
+		FromReleaseID: from.ID,
+		ToAppID:       to.AppID,
+		ToReleaseID:   to.ID,
+	}
+	diff.Changes = diffEnv("", from.Env, to.Env)
+
+	types := make([]string, 0, len(from.Processes)+len(to.Processes))
+	for typ := range from.Processes {
+		types = append(types, typ)
+	}
+	for typ := range to.Processes {
+		if _, ok := from.Processes[typ]; !ok {
+			types = append(types, typ)
+		}
+	}
+	sort.Strings(types)
+	for _, typ := range types {
+		diff.Changes = append(diff.Changes, diffEnv(typ, from.Processes[typ].Env, to.Processes[typ].Env)...)
+	}
+	return diff
+}
+
+func diffEnv(processType string, from, to map[string]string) []*ct.EnvChange {
+	var changes []*ct.EnvChange
+	add := func(typ ct.EnvChangeType, key, fromVal, toVal string) {
+		change := &ct.EnvChange{
+			Type:        typ,
+			ProcessType: processType,
+			Key:         key,
+			From:        fromVal,
+			To:          toVal,
+		}
+		if isSecretEnvKey(key) {
+			change.Redacted = true
+			if change.From != "" {
+				change.From = redactedPlaceholder
+			}
+			if change.To != "" {
+				change.To = redactedPlaceholder
+			}
+		}
+		changes = append(changes, change)
+	}
+	for k, v := range from {
+		if toVal, ok := to[k]; !ok {
+			add(ct.EnvChangeRemoved, k, v, "")
+		} else if toVal != v {
+			add(ct.EnvChangeChanged, k, v, toVal)
+		}
+	}
+	for k, v := range to {
+		if _, ok := from[k]; !ok {
+			add(ct.EnvChangeAdded, k, "", v)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/ctxhelper"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"golang.org/x/net/context"
+)
+
+// Create a new event sink
+func (c *controllerAPI) CreateEventSink(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var sink ct.EventSink
+	if err := httphelper.DecodeJSON(req, &sink); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	if err := validateEventSink(&sink); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	if err := c.eventSinkRepo.Add(&sink); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, &sink)
+}
+
+// validateEventSink checks that an event sink delivers to an HTTPS URL and
+// only forwards the supported event types, which notably excludes events
+// such as releases whose data contains app configuration.
+func validateEventSink(sink *ct.EventSink) error {
+	u, err := url.Parse(sink.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return ct.ValidationError{Field: "url", Message: "must be an https URL"}
+	}
+	switch sink.Format {
+	case "", ct.EventSinkFormatJSON, ct.EventSinkFormatSlack:
+	default:
+		return ct.ValidationError{Field: "format", Message: `must be either "json" or "slack"`}
+	}
+outer:
+	for _, typ := range sink.EventTypes {
+		for _, supported := range ct.DefaultEventSinkTypes {
+			if typ == supported {
+				continue outer
+			}
+		}
+		return ct.ValidationError{Field: "event_types", Message: "unsupported event type " + string(typ)}
+	}
+	return nil
+}
+
+// Get an event sink
+func (c *controllerAPI) GetEventSink(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+
+	sink, err := c.eventSinkRepo.Get(params.ByName("event_sink_id"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	httphelper.JSON(w, 200, sink)
+}
+
+// List event sinks
+func (c *controllerAPI) GetEventSinks(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	list, err := c.eventSinkRepo.List()
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	httphelper.JSON(w, 200, list)
+}
+
+// Delete an event sink
+func (c *controllerAPI) DeleteEventSink(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	sinkID := params.ByName("event_sink_id")
+
+	sink, err := c.eventSinkRepo.Get(sinkID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	if err = c.eventSinkRepo.Remove(sinkID); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	httphelper.JSON(w, 200, sink)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	hh "github.com/flynn/flynn/pkg/httphelper"
+	. "github.com/flynn/go-check"
+)
+
+func (s *S) TestEventSinks(c *C) {
+	// event sinks must use HTTPS and only forward supported event types
+	for _, sink := range []*ct.EventSink{
+		{URL: "http://example.com/events"},
+		{URL: "https://example.com/events", Format: "xml"},
+		{URL: "https://example.com/events", EventTypes: []ct.EventType{ct.EventTypeRelease}},
+	} {
+		err := s.c.CreateEventSink(sink)
+		c.Assert(hh.IsValidationError(err), Equals, true, Commentf("%+v", sink))
+	}
+
+	sink := &ct.EventSink{URL: "https://example.com/events", Format: ct.EventSinkFormatSlack}
+	c.Assert(s.c.CreateEventSink(sink), IsNil)
+	c.Assert(sink.ID, Not(Equals), "")
+	c.Assert(sink.EventTypes, DeepEquals, ct.DefaultEventSinkTypes)
+
+	gotSink, err := s.c.GetEventSink(sink.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotSink.URL, Equals, sink.URL)
+	c.Assert(gotSink.Format, Equals, ct.EventSinkFormatSlack)
+	c.Assert(gotSink.LastEventID, Equals, sink.LastEventID)
+
+	sinks, err := s.c.ListEventSinks()
+	c.Assert(err, IsNil)
+	found := false
+	for _, s := range sinks {
+		if s.ID == sink.ID {
+			found = true
+		}
+	}
+	c.Assert(found, Equals, true)
+
+	_, err = s.c.DeleteEventSink(sink.ID)
+	c.Assert(err, IsNil)
+	_, err = s.c.GetEventSink(sink.ID)
+	c.Assert(err, Equals, controller.ErrNotFound)
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/flynn/flynn/controller/schema"
 	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/controller/utils"
 	"github.com/flynn/flynn/pkg/ctxhelper"
 	"github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/sse"
@@ -41,6 +42,11 @@ func (c *controllerAPI) PutFormation(ctx context.Context, w http.ResponseWriter,
 		return
 	}
 
+	if err := c.validateFormationPlacement(ctx, formation, release); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
 	req := newScaleRequest(formation, release)
 	req, err = c.formationRepo.AddScaleRequest(req, false)
 	if err != nil {
@@ -72,6 +78,17 @@ func (c *controllerAPI) PutScaleRequest(ctx context.Context, w http.ResponseWrit
 	}
 
 	if req.State == ct.ScaleRequestStatePending {
+		formation := &ct.Formation{AppID: app.ID, ReleaseID: release.ID}
+		if req.NewProcesses != nil {
+			formation.Processes = *req.NewProcesses
+		}
+		if req.NewTags != nil {
+			formation.Tags = *req.NewTags
+		}
+		if err := c.validateFormationPlacement(ctx, formation, release); err != nil {
+			respondWithError(w, err)
+			return
+		}
 		_, err = c.formationRepo.AddScaleRequest(&req, false)
 	} else {
 		err = c.formationRepo.UpdateScaleRequest(&req)
@@ -83,6 +100,23 @@ func (c *controllerAPI) PutScaleRequest(ctx context.Context, w http.ResponseWrit
 	httphelper.JSON(w, 200, &req)
 }
 
+// validateFormationPlacement checks that the hosts in the cluster can run the
+// process types being scaled up in the formation, so that formations which
+// can't be placed are rejected rather than the scheduler silently failing to
+// start their jobs.
+func (c *controllerAPI) validateFormationPlacement(ctx context.Context, formation *ct.Formation, release *ct.Release) error {
+	hosts, err := c.clusterClient.Hosts()
+	if err != nil {
+		// don't block scaling if the hosts can't be listed, the
+		// scheduler reports jobs it can't place anyway
+		if l, ok := ctxhelper.LoggerFromContext(ctx); ok {
+			l.Error("error listing hosts to validate formation", "err", err)
+		}
+		return nil
+	}
+	return utils.ValidateFormationPlacement(hosts, formation, release)
+}
+
 func (c *controllerAPI) GetFormation(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	params, _ := ctxhelper.ParamsFromContext(ctx)
 
@@ -1,21 +1,20 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
+	"sort"
+	"sync"
+	"time"
 
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/controller/utils"
 	host "github.com/flynn/flynn/host/types"
 	"github.com/flynn/flynn/pkg/ctxhelper"
 	"github.com/flynn/flynn/pkg/httphelper"
 	"golang.org/x/net/context"
 )
 
-// HostInfo represents basic information about a host in the cluster
-type HostInfo struct {
-	ID   string            `json:"id"`
-	Tags map[string]string `json:"tags"`
-	Addr string            `json:"addr"`
-}
-
 // GetHosts returns a list of all hosts in the cluster
 func (c *controllerAPI) GetHosts(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	hosts, err := c.clusterClient.Hosts()
@@ -24,9 +23,9 @@ func (c *controllerAPI) GetHosts(ctx context.Context, w http.ResponseWriter, req
 		return
 	}
 
-	result := make([]HostInfo, len(hosts))
+	result := make([]ct.HostInfo, len(hosts))
 	for i, h := range hosts {
-		result[i] = HostInfo{
+		result[i] = ct.HostInfo{
 			ID:   h.ID(),
 			Tags: h.Tags(),
 			Addr: h.Addr(),
@@ -35,6 +34,94 @@ func (c *controllerAPI) GetHosts(ctx context.Context, w http.ResponseWriter, req
 	httphelper.JSON(w, 200, result)
 }
 
+// hostVersionTimeout is how long to wait for each host to report its version
+// before listing it as unreachable.
+const hostVersionTimeout = 5 * time.Second
+
+// ClusterVersionStatus is returned by GetClusterVersions so that a partially
+// rolled out update (flynn-host update --hosts) is visible as a mixed-version
+// cluster.
+type ClusterVersionStatus struct {
+	// HostVersions maps each flynn-host version to the IDs of the hosts
+	// running it.
+	HostVersions map[string][]string `json:"host_versions"`
+
+	// MixedVersions is true when hosts are running more than one version.
+	MixedVersions bool `json:"mixed_versions"`
+
+	// UnreachableHosts lists hosts whose version could not be determined.
+	UnreachableHosts []string `json:"unreachable_hosts,omitempty"`
+}
+
+// GetClusterVersions returns the flynn-host version of each host in the
+// cluster
+func (c *controllerAPI) GetClusterVersions(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	versions, err := c.clusterVersionStatus()
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, versions)
+}
+
+// clusterVersionStatus queries every host for its flynn-host version, hosts
+// which do not respond within hostVersionTimeout are listed as unreachable.
+func (c *controllerAPI) clusterVersionStatus() (*ClusterVersionStatus, error) {
+	hosts, err := c.clusterClient.Hosts()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ClusterVersionStatus{HostVersions: make(map[string][]string)}
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	for _, h := range hosts {
+		wg.Add(1)
+		go func(h utils.HostClient) {
+			defer wg.Done()
+			version, err := hostVersion(h, hostVersionTimeout)
+			mtx.Lock()
+			defer mtx.Unlock()
+			if err != nil {
+				res.UnreachableHosts = append(res.UnreachableHosts, h.ID())
+				return
+			}
+			res.HostVersions[version] = append(res.HostVersions[version], h.ID())
+		}(h)
+	}
+	wg.Wait()
+
+	for _, ids := range res.HostVersions {
+		sort.Strings(ids)
+	}
+	sort.Strings(res.UnreachableHosts)
+	res.MixedVersions = len(res.HostVersions) > 1
+	return res, nil
+}
+
+// hostVersion returns the flynn-host version of the given host, or an error
+// if it does not respond within the timeout.
+func hostVersion(h utils.HostClient, timeout time.Duration) (string, error) {
+	type result struct {
+		status *host.HostStatus
+		err    error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		status, err := h.GetStatus()
+		ch <- result{status, err}
+	}()
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return "", r.err
+		}
+		return r.status.Version, nil
+	case <-time.After(timeout):
+		return "", fmt.Errorf("timed out after %s waiting for host %s", timeout, h.ID())
+	}
+}
+
 // GetHostStats returns resource usage stats for a specific host
 func (c *controllerAPI) GetHostStats(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	params, _ := ctxhelper.ParamsFromContext(ctx)
@@ -77,16 +164,6 @@ func (c *controllerAPI) GetClusterStats(ctx context.Context, w http.ResponseWrit
 	httphelper.JSON(w, 200, result)
 }
 
-// EnrichedContainerStats extends ContainerStats with job metadata
-type EnrichedContainerStats struct {
-	*host.ContainerStats
-	HostID      string `json:"host_id"`
-	AppID       string `json:"app_id,omitempty"`
-	AppName     string `json:"app_name,omitempty"`
-	ReleaseID   string `json:"release_id,omitempty"`
-	ProcessType string `json:"process_type,omitempty"`
-}
-
 // GetClusterJobsStats returns stats for all jobs running across all hosts with enriched metadata
 func (c *controllerAPI) GetClusterJobsStats(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	hosts, err := c.clusterClient.Hosts()
@@ -95,7 +172,7 @@ func (c *controllerAPI) GetClusterJobsStats(ctx context.Context, w http.Response
 		return
 	}
 
-	result := make([]*EnrichedContainerStats, 0)
+	result := make([]*ct.EnrichedContainerStats, 0)
 	for _, h := range hosts {
 		jobsStats, err := h.GetAllJobsStats()
 		if err != nil {
@@ -108,7 +185,7 @@ func (c *controllerAPI) GetClusterJobsStats(ctx context.Context, w http.Response
 		jobs, _ := h.ListJobs()
 
 		for _, jobStats := range jobsStats.Jobs {
-			enriched := &EnrichedContainerStats{
+			enriched := &ct.EnrichedContainerStats{
 				ContainerStats: jobStats,
 				HostID:         h.ID(),
 			}
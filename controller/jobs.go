@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/flynn/flynn/controller/schema"
+	"github.com/flynn/flynn/controller/secrets"
 	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/flynn/controller/utils"
 	"github.com/flynn/flynn/host/resource"
@@ -31,6 +32,40 @@ func (c *controllerAPI) ListJobs(ctx context.Context, w http.ResponseWriter, req
 	httphelper.JSON(w, 200, list)
 }
 
+// ListJobPlacements lists the hosts an app's jobs ran on between the since
+// and until query parameters, which default to the last 24 hours.
+func (c *controllerAPI) ListJobPlacements(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	app := c.getApp(ctx)
+	until := time.Now()
+	if s := req.URL.Query().Get("until"); s != "" {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			httphelper.ValidationError(w, "until", "must be a valid RFC3339 timestamp")
+			return
+		}
+		until = t
+	}
+	since := until.Add(-24 * time.Hour)
+	if s := req.URL.Query().Get("since"); s != "" {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			httphelper.ValidationError(w, "since", "must be a valid RFC3339 timestamp")
+			return
+		}
+		since = t
+	}
+	if !since.Before(until) {
+		httphelper.ValidationError(w, "since", "must be before until")
+		return
+	}
+	list, err := c.jobRepo.ListPlacements(app.ID, since, until)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, list)
+}
+
 func (c *controllerAPI) ListActiveJobs(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	list, err := c.jobRepo.ListActive()
 	if err != nil {
@@ -154,7 +189,7 @@ func (c *controllerAPI) RunJob(ctx context.Context, w http.ResponseWriter, req *
 		respondWithError(w, errors.New("no hosts found"))
 		return
 	}
-	client := hosts[random.Math.Intn(len(hosts))]
+	client := utils.HostClientWithContext(ctx, hosts[random.Math.Intn(len(hosts))])
 
 	uuid := random.UUID()
 	hostID := client.ID()
@@ -176,6 +211,23 @@ func (c *controllerAPI) RunJob(ctx context.Context, w http.ResponseWriter, req *
 	for k, v := range newJob.Env {
 		env[k] = v
 	}
+	if utils.HasResourceEnvRefs(env) {
+		resources, err := c.resourceRepo.AppList(app.ID)
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		if err := utils.ResolveResourceEnv(env, resources); err != nil {
+			httphelper.ValidationError(w, "env", err.Error())
+			return
+		}
+	}
+	if secrets.HasEnvRefs(env) {
+		if err := c.resolveSecretEnv(app.ID, uuid, env); err != nil {
+			httphelper.ValidationError(w, "env", err.Error())
+			return
+		}
+	}
 	metadata := make(map[string]string, len(newJob.Meta)+3)
 	for k, v := range newJob.Meta {
 		metadata[k] = v
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"encoding/json"
 	"io"
+	"time"
 
 	tu "github.com/flynn/flynn/controller/testutils"
 	ct "github.com/flynn/flynn/controller/types"
@@ -76,6 +78,53 @@ func (s *S) TestJobListActive(c *C) {
 	}
 }
 
+func (s *S) TestJobPlacements(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "job-placements"})
+	release := s.createTestRelease(c, app.ID, &ct.Release{})
+	start := time.Now()
+
+	hostID := fakeHostID()
+	uuid := random.UUID()
+	job := &ct.Job{
+		ID:        cluster.GenerateJobID(hostID, uuid),
+		UUID:      uuid,
+		HostID:    hostID,
+		AppID:     app.ID,
+		ReleaseID: release.ID,
+		Type:      "web",
+	}
+	for _, state := range []ct.JobState{ct.JobStateStarting, ct.JobStateUp, ct.JobStateDown} {
+		job.State = state
+		if state == ct.JobStateDown {
+			status := int32(137)
+			job.ExitStatus = &status
+		}
+		s.createTestJob(c, job)
+	}
+
+	// pending jobs which never reached a host are omitted
+	s.createTestJob(c, &ct.Job{UUID: random.UUID(), AppID: app.ID, ReleaseID: release.ID, Type: "web", State: ct.JobStatePending})
+
+	list, err := s.c.JobPlacements(app.ID, start.Add(-time.Minute), time.Now().Add(time.Minute))
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 1)
+	p := list[0]
+	c.Assert(p.JobID, Equals, job.ID)
+	c.Assert(p.HostID, Equals, hostID)
+	c.Assert(p.Type, Equals, "web")
+	c.Assert(p.State, Equals, ct.JobStateDown)
+	c.Assert(p.StartedAt, NotNil)
+	c.Assert(p.StoppedAt, NotNil)
+	c.Assert(p.StoppedAt.Before(*p.StartedAt), Equals, false)
+	c.Assert(p.ExitStatus, NotNil)
+	c.Assert(*p.ExitStatus, Equals, int32(137))
+
+	// jobs which stopped before the range are omitted
+	list, err = s.c.JobPlacements(app.ID, time.Now().Add(time.Minute), time.Now().Add(time.Hour))
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 0)
+}
+
 func (s *S) TestJobGet(c *C) {
 	app := s.createTestApp(c, &ct.App{Name: "job-get"})
 	release := s.createTestRelease(c, app.ID, &ct.Release{})
@@ -150,6 +199,42 @@ func (s *S) TestJobStateChanges(c *C) {
 	c.Assert(gotJob.State, Equals, ct.JobStateDown)
 }
 
+func (s *S) TestPutJobFailureReasons(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "put-job-failure-reasons"})
+	release := s.createTestRelease(c, app.ID, &ct.Release{})
+	s.createTestFormation(c, &ct.Formation{ReleaseID: release.ID, AppID: app.ID})
+
+	for _, set := range []func(*ct.Job){
+		func(job *ct.Job) { job.OOMKilled = true },
+		func(job *ct.Job) { job.HealthCheckFailed = true },
+	} {
+		uuid := random.UUID()
+		job := &ct.Job{
+			ID:        cluster.GenerateJobID("host0", uuid),
+			UUID:      uuid,
+			AppID:     app.ID,
+			ReleaseID: release.ID,
+			Type:      "web",
+			State:     ct.JobStateDown,
+		}
+		set(job)
+		c.Assert(s.c.PutJob(job), IsNil)
+
+		// the reasons are included in the job event
+		events, err := s.c.ListEvents(ct.ListEventsOptions{
+			AppID:       app.ID,
+			ObjectTypes: []ct.EventType{ct.EventTypeJob},
+			ObjectID:    uuid,
+		})
+		c.Assert(err, IsNil)
+		c.Assert(events, HasLen, 1)
+		var got ct.Job
+		c.Assert(json.Unmarshal(events[0].Data, &got), IsNil)
+		c.Assert(got.OOMKilled, Equals, job.OOMKilled)
+		c.Assert(got.HealthCheckFailed, Equals, job.HealthCheckFailed)
+	}
+}
+
 func fakeHostID() string {
 	return random.Hex(16)
 }
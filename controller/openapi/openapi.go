@@ -0,0 +1,315 @@
+// Package openapi generates an OpenAPI 3 document describing the controller
+// HTTP API from its route table and the Go types used in requests and
+// responses, so that clients can be generated for languages other than Go.
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flynn/flynn/pkg/httphelper"
+)
+
+// Operation describes a controller API route.
+type Operation struct {
+	Method  string
+	Path    string // httprouter path, e.g. /apps/:apps_id
+	ID      string // the operationId
+	Summary string
+	Tag     string
+
+	// Request and Response are values of the request and response body
+	// types, nil if the request or response has no body.
+	Request  interface{}
+	Response interface{}
+
+	// ContentType is the response content type if the response is not
+	// JSON.
+	ContentType string
+
+	// Stream is set if the response is streamed as server-sent events
+	// when the request accepts text/event-stream.
+	Stream bool
+}
+
+// Document is an OpenAPI 3 document.
+type Document struct {
+	OpenAPI    string                                 `json:"openapi"`
+	Info       Info                                   `json:"info"`
+	Paths      map[string]map[string]*OperationObject `json:"paths"`
+	Components Components                             `json:"components"`
+	Security   []map[string][]string                  `json:"security,omitempty"`
+	Tags       []Tag                                  `json:"tags,omitempty"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type Tag struct {
+	Name string `json:"name"`
+}
+
+// OperationObject is an OpenAPI operation object.
+type OperationObject struct {
+	OperationID string               `json:"operationId,omitempty"`
+	Summary     string               `json:"summary,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []*Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme"`
+}
+
+// Schema is an OpenAPI schema object.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// Generate returns an OpenAPI document for the given operations.
+func Generate(version string, ops []Operation) *Document {
+	doc := &Document{
+		OpenAPI: "3.0.3",
+		Info:    Info{Title: "Flynn Controller API", Version: version},
+		Paths:   make(map[string]map[string]*OperationObject),
+		Components: Components{
+			Schemas: make(map[string]*Schema),
+			SecuritySchemes: map[string]*SecurityScheme{
+				"basicAuth": {Type: "http", Scheme: "basic"},
+			},
+		},
+		Security: []map[string][]string{{"basicAuth": {}}},
+	}
+	g := &generator{doc: doc, names: make(map[reflect.Type]string)}
+	tags := make(map[string]struct{})
+	for _, op := range ops {
+		path, params := convertPath(op.Path)
+		item := &OperationObject{
+			OperationID: op.ID,
+			Summary:     op.Summary,
+			Parameters:  params,
+			Responses:   make(map[string]*Response),
+		}
+		if op.Tag != "" {
+			item.Tags = []string{op.Tag}
+			tags[op.Tag] = struct{}{}
+		}
+		if op.Request != nil {
+			item.RequestBody = &RequestBody{
+				Required: true,
+				Content:  map[string]*MediaType{"application/json": {Schema: g.schema(reflect.TypeOf(op.Request))}},
+			}
+		}
+		res := &Response{Description: "OK"}
+		switch {
+		case op.ContentType != "":
+			res.Content = map[string]*MediaType{op.ContentType: {Schema: &Schema{Type: "string", Format: "binary"}}}
+		case op.Response != nil:
+			schema := g.schema(reflect.TypeOf(op.Response))
+			res.Content = map[string]*MediaType{"application/json": {Schema: schema}}
+			if op.Stream {
+				// each event contains a single item of a list response
+				if schema.Items != nil {
+					schema = schema.Items
+				}
+				res.Content["text/event-stream"] = &MediaType{Schema: schema}
+			}
+		}
+		item.Responses["200"] = res
+		item.Responses["default"] = &Response{
+			Description: "Error",
+			Content:     map[string]*MediaType{"application/json": {Schema: g.schema(reflect.TypeOf(httphelper.JSONError{}))}},
+		}
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*OperationObject)
+		}
+		doc.Paths[path][strings.ToLower(op.Method)] = item
+	}
+	for tag := range tags {
+		doc.Tags = append(doc.Tags, Tag{Name: tag})
+	}
+	sort.Slice(doc.Tags, func(i, j int) bool { return doc.Tags[i].Name < doc.Tags[j].Name })
+	return doc
+}
+
+// convertPath converts an httprouter path into an OpenAPI path and its
+// parameters.
+func convertPath(path string) (string, []*Parameter) {
+	var params []*Parameter
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if len(part) > 1 && (part[0] == ':' || part[0] == '*') {
+			name := part[1:]
+			parts[i] = "{" + name + "}"
+			params = append(params, &Parameter{
+				Name:     name,
+				In:       "path",
+				Required: true,
+				Schema:   &Schema{Type: "string"},
+			})
+		}
+	}
+	return strings.Join(parts, "/"), params
+}
+
+type generator struct {
+	doc   *Document
+	names map[reflect.Type]string
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	durationType   = reflect.TypeOf(time.Duration(0))
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	marshalerType  = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// schema returns the schema for the given type, adding named struct types
+// to the document components and returning a reference to them.
+func (g *generator) schema(t reflect.Type) *Schema {
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		nullable = true
+	}
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time", Nullable: nullable}
+	case durationType:
+		return &Schema{Type: "integer", Format: "int64", Nullable: nullable}
+	case rawMessageType:
+		return &Schema{}
+	}
+	// types with custom JSON encoding can't be described by reflection
+	if t.Kind() == reflect.Struct && (t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType)) {
+		return &Schema{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean", Nullable: nullable}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32", Nullable: nullable}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64", Nullable: nullable}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number", Nullable: nullable}
+	case reflect.String:
+		return &Schema{Type: "string", Nullable: nullable}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte", Nullable: nullable}
+		}
+		return &Schema{Type: "array", Items: g.schema(t.Elem()), Nullable: true}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schema(t.Elem()), Nullable: true}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + g.componentName(t)}
+	default:
+		// interfaces and anything else can hold any value
+		return &Schema{}
+	}
+}
+
+// componentName returns the component name for a named struct type,
+// generating its schema the first time the type is seen. Types are named
+// after the Go type, prefixed with the package name if a type with the same
+// name from another package has already been seen.
+func (g *generator) componentName(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+	name := t.Name()
+	if _, exists := g.doc.Components.Schemas[name]; exists {
+		pkg := t.PkgPath()
+		if i := strings.LastIndex(pkg, "/"); i >= 0 {
+			pkg = pkg[i+1:]
+		}
+		name = strings.Title(pkg) + name
+	}
+	g.names[t] = name
+	// reserve the name before generating the schema to support
+	// recursive types
+	g.doc.Components.Schemas[name] = &Schema{}
+	g.doc.Components.Schemas[name] = g.structSchema(t)
+	return name
+}
+
+func (g *generator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	g.addFields(s, t)
+	return s
+}
+
+// addFields adds the JSON encoded fields of the struct t to s, including
+// the fields of embedded structs.
+func (g *generator) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addFields(s, ft)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			// unexported
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = g.schema(f.Type)
+	}
+}
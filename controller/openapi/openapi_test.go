@@ -0,0 +1,55 @@
+package openapi
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSpec(t *testing.T) {
+	doc := Spec("dev")
+
+	// the document should be valid JSON
+	if _, err := json.Marshal(doc); err != nil {
+		t.Fatal(err)
+	}
+
+	op, ok := doc.Paths["/apps/{apps_id}/formations/{releases_id}"]["put"]
+	if !ok {
+		t.Fatal("missing PUT formation operation")
+	}
+	if op.OperationID != "putFormation" {
+		t.Fatalf("expected operationId putFormation, got %q", op.OperationID)
+	}
+	if len(op.Parameters) != 2 || op.Parameters[0].Name != "apps_id" || op.Parameters[1].Name != "releases_id" {
+		t.Fatalf("unexpected parameters: %+v", op.Parameters)
+	}
+	if ref := op.RequestBody.Content["application/json"].Schema.Ref; ref != "#/components/schemas/Formation" {
+		t.Fatalf("unexpected request schema ref %q", ref)
+	}
+
+	formation := doc.Components.Schemas["Formation"]
+	if formation == nil {
+		t.Fatal("missing Formation schema")
+	}
+	if s := formation.Properties["processes"]; s == nil || s.Type != "object" || s.AdditionalProperties.Type != "integer" {
+		t.Fatalf("unexpected processes schema: %+v", s)
+	}
+	if s := formation.Properties["created_at"]; s == nil || s.Format != "date-time" {
+		t.Fatalf("unexpected created_at schema: %+v", s)
+	}
+
+	// streamed list responses describe a single item per event
+	events := doc.Paths["/events"]["get"].Responses["200"].Content["text/event-stream"]
+	if events == nil || events.Schema.Ref != "#/components/schemas/Event" {
+		t.Fatalf("unexpected event stream schema: %+v", events)
+	}
+
+	// every operation should have a unique ID
+	ids := make(map[string]struct{})
+	for _, op := range Operations {
+		if _, ok := ids[op.ID]; ok {
+			t.Fatalf("duplicate operation ID %q", op.ID)
+		}
+		ids[op.ID] = struct{}{}
+	}
+}
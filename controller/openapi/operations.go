@@ -0,0 +1,144 @@
+package openapi
+
+import (
+	ct "github.com/flynn/flynn/controller/types"
+	host "github.com/flynn/flynn/host/types"
+	router "github.com/flynn/flynn/router/types"
+)
+
+// ReleaseRef is the body of requests which reference a release.
+type ReleaseRef struct {
+	ID string `json:"id"`
+}
+
+// Operations are the routes served by the controller HTTP API. The
+// controller checks in its tests that every route it registers is listed
+// here.
+var Operations = []Operation{
+	{Method: "POST", Path: "/apps", ID: "createApp", Summary: "Create an app", Tag: "apps", Request: ct.App{}, Response: ct.App{}},
+	{Method: "GET", Path: "/apps", ID: "listApps", Summary: "List apps", Tag: "apps", Response: []*ct.App{}},
+	{Method: "GET", Path: "/apps/:apps_id", ID: "getApp", Summary: "Get an app", Tag: "apps", Response: ct.App{}},
+	{Method: "POST", Path: "/apps/:apps_id", ID: "updateApp", Summary: "Update an app", Tag: "apps", Request: map[string]interface{}{}, Response: ct.App{}},
+	{Method: "DELETE", Path: "/apps/:apps_id", ID: "deleteApp", Summary: "Delete an app", Tag: "apps"},
+	{Method: "POST", Path: "/apps/:apps_id/meta", ID: "updateAppMeta", Summary: "Update app metadata", Tag: "apps", Request: map[string]interface{}{}, Response: ct.App{}},
+	{Method: "GET", Path: "/apps/:apps_id/log", ID: "getAppLog", Summary: "Get app logs", Tag: "apps", ContentType: "text/plain"},
+	{Method: "POST", Path: "/apps/:apps_id/gc", ID: "scheduleAppGarbageCollection", Summary: "Garbage collect old releases of an app", Tag: "apps"},
+	{Method: "POST", Path: "/apps/:apps_id/clone", ID: "cloneApp", Summary: "Create a copy of an app with its release, formation, resources and routes", Tag: "apps", Request: ct.AppCloneRequest{}, Response: ct.AppClone{}},
+	{Method: "POST", Path: "/apps/:apps_id/review-apps", ID: "createReviewApp", Summary: "Create a review app for a branch, or extend the TTL of an existing one", Tag: "apps", Request: ct.ReviewAppRequest{}, Response: ct.ReviewApp{}},
+	{Method: "GET", Path: "/apps/:apps_id/review-apps", ID: "listReviewApps", Summary: "List the review apps of an app", Tag: "apps", Response: []*ct.ReviewApp{}},
+	{Method: "DELETE", Path: "/apps/:apps_id/review-apps", ID: "deleteReviewApp", Summary: "Delete the review app of the branch given in the branch parameter", Tag: "apps", Response: ct.ReviewApp{}},
+	{Method: "POST", Path: "/apps/:apps_id/secret-env", ID: "resolveSecretEnv", Summary: "Resolve the secret references in the env of a job", Tag: "apps", Request: ct.SecretEnvRequest{}, Response: map[string]string{}},
+
+	{Method: "POST", Path: "/releases", ID: "createRelease", Summary: "Create a release", Tag: "releases", Request: ct.Release{}, Response: ct.Release{}},
+	{Method: "GET", Path: "/releases", ID: "listReleases", Summary: "List releases", Tag: "releases", Response: []*ct.Release{}},
+	{Method: "GET", Path: "/releases/:releases_id", ID: "getRelease", Summary: "Get a release", Tag: "releases", Response: ct.Release{}},
+	{Method: "GET", Path: "/apps/:apps_id/releases", ID: "listAppReleases", Summary: "List the releases of an app", Tag: "releases", Response: []*ct.Release{}},
+	{Method: "GET", Path: "/apps/:apps_id/release", ID: "getAppRelease", Summary: "Get the current release of an app", Tag: "releases", Response: ct.Release{}},
+	{Method: "PUT", Path: "/apps/:apps_id/release", ID: "setAppRelease", Summary: "Set the current release of an app", Tag: "releases", Request: ReleaseRef{}, Response: ct.Release{}},
+	{Method: "DELETE", Path: "/apps/:apps_id/releases/:releases_id", ID: "deleteAppRelease", Summary: "Delete a release of an app", Tag: "releases"},
+	{Method: "GET", Path: "/apps/:apps_id/env-diff", ID: "getAppEnvDiff", Summary: "Get the difference between the env of two releases of an app", Tag: "releases", Response: ct.EnvDiff{}},
+
+	{Method: "POST", Path: "/artifacts", ID: "createArtifact", Summary: "Create an artifact", Tag: "artifacts", Request: ct.Artifact{}, Response: ct.Artifact{}},
+	{Method: "GET", Path: "/artifacts", ID: "listArtifacts", Summary: "List artifacts", Tag: "artifacts", Response: []*ct.Artifact{}},
+	{Method: "GET", Path: "/artifacts/:artifacts_id", ID: "getArtifact", Summary: "Get an artifact", Tag: "artifacts", Response: ct.Artifact{}},
+
+	{Method: "GET", Path: "/formations", ID: "listAllFormations", Summary: "List expanded formations", Tag: "formations", Response: []*ct.ExpandedFormation{}, Stream: true},
+	{Method: "GET", Path: "/apps/:apps_id/formations", ID: "listAppFormations", Summary: "List the formations of an app", Tag: "formations", Response: []*ct.Formation{}},
+	{Method: "GET", Path: "/apps/:apps_id/formations/:releases_id", ID: "getFormation", Summary: "Get a formation", Tag: "formations", Response: ct.Formation{}},
+	{Method: "PUT", Path: "/apps/:apps_id/formations/:releases_id", ID: "putFormation", Summary: "Create or update a formation", Tag: "formations", Request: ct.Formation{}, Response: ct.Formation{}},
+	{Method: "DELETE", Path: "/apps/:apps_id/formations/:releases_id", ID: "deleteFormation", Summary: "Delete a formation", Tag: "formations"},
+	{Method: "PUT", Path: "/apps/:apps_id/scale/:releases_id", ID: "createScaleRequest", Summary: "Scale a release", Tag: "formations", Request: ct.ScaleRequest{}, Response: ct.ScaleRequest{}},
+
+	{Method: "GET", Path: "/apps/:apps_id/jobs", ID: "listAppJobs", Summary: "List the jobs of an app", Tag: "jobs", Response: []*ct.Job{}},
+	{Method: "POST", Path: "/apps/:apps_id/jobs", ID: "runJob", Summary: "Run a one-off job", Tag: "jobs", Request: ct.NewJob{}, Response: ct.Job{}},
+	{Method: "GET", Path: "/apps/:apps_id/jobs/:jobs_id", ID: "getJob", Summary: "Get a job", Tag: "jobs", Response: ct.Job{}},
+	{Method: "PUT", Path: "/apps/:apps_id/jobs/:jobs_id", ID: "putJob", Summary: "Create or update a job", Tag: "jobs", Request: ct.Job{}, Response: ct.Job{}},
+	{Method: "DELETE", Path: "/apps/:apps_id/jobs/:jobs_id", ID: "killJob", Summary: "Stop a job", Tag: "jobs"},
+	{Method: "GET", Path: "/apps/:apps_id/job-placements", ID: "listJobPlacements", Summary: "List the hosts the jobs of an app were placed on", Tag: "jobs", Response: []*ct.JobPlacement{}},
+	{Method: "GET", Path: "/active-jobs", ID: "listActiveJobs", Summary: "List active jobs", Tag: "jobs", Response: []*ct.Job{}},
+
+	{Method: "POST", Path: "/apps/:apps_id/deploy", ID: "createDeployment", Summary: "Deploy a release", Tag: "deployments", Request: ReleaseRef{}, Response: ct.Deployment{}},
+	{Method: "GET", Path: "/apps/:apps_id/deployments", ID: "listAppDeployments", Summary: "List the deployments of an app", Tag: "deployments", Response: []*ct.Deployment{}},
+	{Method: "GET", Path: "/deployments/:deployment_id", ID: "getDeployment", Summary: "Get a deployment", Tag: "deployments", Response: ct.Deployment{}},
+
+	{Method: "POST", Path: "/providers", ID: "createProvider", Summary: "Create a provider", Tag: "resources", Request: ct.Provider{}, Response: ct.Provider{}},
+	{Method: "GET", Path: "/providers", ID: "listProviders", Summary: "List providers", Tag: "resources", Response: []*ct.Provider{}},
+	{Method: "GET", Path: "/providers/:providers_id", ID: "getProvider", Summary: "Get a provider", Tag: "resources", Response: ct.Provider{}},
+	{Method: "GET", Path: "/resources", ID: "listResources", Summary: "List resources", Tag: "resources", Response: []*ct.Resource{}},
+	{Method: "POST", Path: "/providers/:providers_id/resources", ID: "provisionResource", Summary: "Provision a resource", Tag: "resources", Request: ct.ResourceReq{}, Response: ct.Resource{}},
+	{Method: "GET", Path: "/providers/:providers_id/resources", ID: "listProviderResources", Summary: "List the resources of a provider", Tag: "resources", Response: []*ct.Resource{}},
+	{Method: "GET", Path: "/providers/:providers_id/resources/:resources_id", ID: "getResource", Summary: "Get a resource", Tag: "resources", Response: ct.Resource{}},
+	{Method: "PUT", Path: "/providers/:providers_id/resources/:resources_id", ID: "putResource", Summary: "Create or update a resource", Tag: "resources", Request: ct.Resource{}, Response: ct.Resource{}},
+	{Method: "DELETE", Path: "/providers/:providers_id/resources/:resources_id", ID: "deleteResource", Summary: "Deprovision a resource", Tag: "resources", Response: ct.Resource{}},
+	{Method: "PUT", Path: "/providers/:providers_id/resources/:resources_id/apps/:app_id", ID: "addResourceApp", Summary: "Add an app to a resource", Tag: "resources", Response: ct.Resource{}},
+	{Method: "DELETE", Path: "/providers/:providers_id/resources/:resources_id/apps/:app_id", ID: "deleteResourceApp", Summary: "Remove an app from a resource", Tag: "resources", Response: ct.Resource{}},
+	{Method: "GET", Path: "/apps/:apps_id/resources", ID: "listAppResources", Summary: "List the resources of an app", Tag: "resources", Response: []*ct.Resource{}},
+
+	{Method: "GET", Path: "/routes", ID: "listRoutes", Summary: "List routes", Tag: "routes", Response: []*router.Route{}},
+	{Method: "POST", Path: "/apps/:apps_id/routes", ID: "createRoute", Summary: "Create a route", Tag: "routes", Request: router.Route{}, Response: router.Route{}},
+	{Method: "GET", Path: "/apps/:apps_id/routes", ID: "listAppRoutes", Summary: "List the routes of an app", Tag: "routes", Response: []*router.Route{}},
+	{Method: "GET", Path: "/apps/:apps_id/routes/:routes_type/:routes_id", ID: "getRoute", Summary: "Get a route", Tag: "routes", Response: router.Route{}},
+	{Method: "PUT", Path: "/apps/:apps_id/routes/:routes_type/:routes_id", ID: "updateRoute", Summary: "Update a route", Tag: "routes", Request: router.Route{}, Response: router.Route{}},
+	{Method: "PATCH", Path: "/apps/:apps_id/routes/:routes_type/:routes_id", ID: "patchRoute", Summary: "Update the fields of a route which are set", Tag: "routes", Request: router.RoutePatch{}, Response: router.Route{}},
+	{Method: "DELETE", Path: "/apps/:apps_id/routes/:routes_type/:routes_id", ID: "deleteRoute", Summary: "Delete a route", Tag: "routes"},
+
+	{Method: "GET", Path: "/events", ID: "listEvents", Summary: "List events", Tag: "events", Response: []*ct.Event{}, Stream: true},
+	{Method: "GET", Path: "/events/:id", ID: "getEvent", Summary: "Get an event", Tag: "events", Response: ct.Event{}},
+	{Method: "POST", Path: "/event-sinks", ID: "createEventSink", Summary: "Create an event sink", Tag: "events", Request: ct.EventSink{}, Response: ct.EventSink{}},
+	{Method: "GET", Path: "/event-sinks", ID: "listEventSinks", Summary: "List event sinks", Tag: "events", Response: []*ct.EventSink{}},
+	{Method: "GET", Path: "/event-sinks/:event_sink_id", ID: "getEventSink", Summary: "Get an event sink", Tag: "events", Response: ct.EventSink{}},
+	{Method: "DELETE", Path: "/event-sinks/:event_sink_id", ID: "deleteEventSink", Summary: "Delete an event sink", Tag: "events", Response: ct.EventSink{}},
+
+	{Method: "GET", Path: "/volumes", ID: "listVolumes", Summary: "List volumes", Tag: "volumes", Response: []*ct.Volume{}, Stream: true},
+	{Method: "PUT", Path: "/volumes/:volume_id", ID: "putVolume", Summary: "Create or update a volume", Tag: "volumes", Request: ct.Volume{}, Response: ct.Volume{}},
+	{Method: "GET", Path: "/apps/:apps_id/volumes", ID: "listAppVolumes", Summary: "List the volumes of an app", Tag: "volumes", Response: []*ct.Volume{}},
+	{Method: "GET", Path: "/apps/:apps_id/volumes/:volume_id", ID: "getVolume", Summary: "Get a volume", Tag: "volumes", Response: ct.Volume{}},
+	{Method: "PUT", Path: "/apps/:apps_id/volumes/:volume_id/decommission", ID: "decommissionVolume", Summary: "Decommission a volume", Tag: "volumes", Request: ct.Volume{}, Response: ct.Volume{}},
+	{Method: "POST", Path: "/apps/:apps_id/volumes/:volume_id/snapshots", ID: "createVolumeSnapshot", Summary: "Snapshot a volume", Tag: "volumes", Response: ct.VolumeSnapshot{}},
+	{Method: "POST", Path: "/apps/:apps_id/volumes/:volume_id/restore", ID: "restoreVolume", Summary: "Restore a snapshot of a volume into a new volume", Tag: "volumes", Request: ct.VolumeRestoreRequest{}, Response: ct.Volume{}},
+
+	{Method: "POST", Path: "/sinks", ID: "createSink", Summary: "Create a log sink", Tag: "sinks", Request: ct.Sink{}, Response: ct.Sink{}},
+	{Method: "GET", Path: "/sinks", ID: "listSinks", Summary: "List log sinks", Tag: "sinks", Response: []*ct.Sink{}, Stream: true},
+	{Method: "GET", Path: "/sinks/:sink_id", ID: "getSink", Summary: "Get a log sink", Tag: "sinks", Response: ct.Sink{}},
+	{Method: "DELETE", Path: "/sinks/:sink_id", ID: "deleteSink", Summary: "Delete a log sink", Tag: "sinks", Response: ct.Sink{}},
+
+	{Method: "GET", Path: "/certificates", ID: "listCertificates", Summary: "List the certificates of HTTP routes and the routes using them", Tag: "certificates", Response: []*ct.CertificateSummary{}},
+	{Method: "GET", Path: "/certificates/expiring", ID: "listExpiringCertificates", Summary: "List the certificates of HTTP routes expiring within a duration", Tag: "certificates", Response: []*ct.CertificateSummary{}},
+	{Method: "GET", Path: "/managed-certificates", ID: "listManagedCertificates", Summary: "List managed certificates", Tag: "certificates", Response: []*ct.ManagedCertificate{}, Stream: true},
+	{Method: "GET", Path: "/managed-certificates/:managed_certificate_id", ID: "getManagedCertificate", Summary: "Get a managed certificate", Tag: "certificates", Response: ct.ManagedCertificate{}},
+	{Method: "PUT", Path: "/managed-certificates/:managed_certificate_id", ID: "updateManagedCertificate", Summary: "Update a managed certificate", Tag: "certificates", Request: ct.ManagedCertificate{}, Response: ct.ManagedCertificate{}},
+	{Method: "GET", Path: "/acme/config", ID: "getACMEConfig", Summary: "Get the ACME configuration", Tag: "certificates", Response: ct.ACMEConfig{}},
+	{Method: "PUT", Path: "/acme/config", ID: "updateACMEConfig", Summary: "Update the ACME configuration", Tag: "certificates", Request: ct.ACMEConfig{}, Response: ct.ACMEConfig{}},
+	{Method: "PUT", Path: "/acme/challenges/:token", ID: "putACMEChallenge", Summary: "Store a pending ACME HTTP-01 challenge response", Tag: "certificates", Request: ct.ACMEChallenge{}, Response: ct.ACMEChallenge{}},
+	{Method: "GET", Path: "/acme/challenges/:token", ID: "getACMEChallenge", Summary: "Get a pending ACME HTTP-01 challenge response", Tag: "certificates", Response: ct.ACMEChallenge{}},
+	{Method: "DELETE", Path: "/acme/challenges/:token", ID: "deleteACMEChallenge", Summary: "Remove a pending ACME HTTP-01 challenge response", Tag: "certificates"},
+
+	{Method: "GET", Path: "/hosts", ID: "listHosts", Summary: "List cluster hosts", Tag: "cluster", Response: []ct.HostInfo{}},
+	{Method: "GET", Path: "/hosts/:host_id/stats", ID: "getHostStats", Summary: "Get resource usage of a host", Tag: "cluster", Response: host.HostResourceStats{}},
+	{Method: "GET", Path: "/cluster/stats", ID: "getClusterStats", Summary: "Get resource usage of all hosts", Tag: "cluster", Response: []*host.HostResourceStats{}},
+	{Method: "GET", Path: "/cluster/jobs-stats", ID: "getClusterJobsStats", Summary: "Get resource usage of all jobs", Tag: "cluster", Response: []*ct.EnrichedContainerStats{}},
+	{Method: "GET", Path: "/apps/:apps_id/jobs-stats", ID: "getAppJobsStats", Summary: "Get resource usage of the jobs of an app", Tag: "cluster", Response: []*host.ContainerStats{}},
+	{Method: "GET", Path: "/apps/:apps_id/stats", ID: "getAppStats", Summary: "Get the usage of the jobs of an app rolled up by hour or day", Tag: "cluster", Response: ct.AppStatsRollup{}},
+	{Method: "GET", Path: "/cluster/report", ID: "getClusterReport", Summary: "Get a summary of the hosts, resources, apps, jobs and volumes of the cluster", Tag: "cluster", Response: ct.ClusterReport{}},
+	{Method: "GET", Path: "/cluster/update-history", ID: "getUpdateHistory", Summary: "Get the updates run from any host of the cluster", Tag: "cluster", Response: ct.UpdateHistory{}},
+	{Method: "GET", Path: "/ca-cert", ID: "getCACert", Summary: "Get the cluster CA certificate", Tag: "cluster", ContentType: "application/x-x509-ca-cert"},
+	{Method: "GET", Path: "/backup", ID: "getBackup", Summary: "Create a cluster backup, or get the latest backup status if JSON is requested", Tag: "cluster", ContentType: "application/tar"},
+	{Method: "PUT", Path: "/domain", ID: "migrateDomain", Summary: "Migrate the cluster domain", Tag: "cluster", Request: ct.DomainMigration{}, Response: ct.DomainMigration{}},
+	{Method: "GET", Path: "/.well-known/status", ID: "getStatus", Summary: "Get the controller status", Tag: "cluster", Response: map[string]interface{}{}},
+	{Method: "GET", Path: "/schema", ID: "getSchema", Summary: "Get this OpenAPI document", Tag: "cluster", Response: map[string]interface{}{}},
+}
+
+// Lookup returns the documented operation for the given method and path.
+func Lookup(method, path string) (Operation, bool) {
+	for _, op := range Operations {
+		if op.Method == method && op.Path == path {
+			return op, true
+		}
+	}
+	return Operation{}, false
+}
+
+// Spec returns the OpenAPI document for all controller operations.
+func Spec(version string) *Document {
+	return Generate(version, Operations)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/flynn/flynn/controller/worker/periodic"
+	. "github.com/flynn/go-check"
+	"github.com/flynn/que-go"
+)
+
+func (s *S) TestPeriodicSchedule(c *C) {
+	const jobType = "test_periodic"
+	db := s.hc.db
+	defer db.Exec("DELETE FROM que_jobs WHERE job_class = $1", jobType)
+
+	queued := func() []int64 {
+		rows, err := db.Query("SELECT job_id FROM que_jobs WHERE job_class = $1 ORDER BY job_id", jobType)
+		c.Assert(err, IsNil)
+		defer rows.Close()
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			c.Assert(rows.Scan(&id), IsNil)
+			ids = append(ids, id)
+		}
+		c.Assert(rows.Err(), IsNil)
+		return ids
+	}
+
+	// workers starting at the same time queue a single run
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- periodic.Schedule(db, jobType)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		c.Assert(err, IsNil)
+	}
+	ids := queued()
+	c.Assert(ids, HasLen, 1)
+
+	// the running job schedules its next run, which is not repeated
+	running := &que.Job{ID: ids[0], Type: jobType}
+	c.Assert(periodic.ScheduleNext(db, running, time.Hour), IsNil)
+	c.Assert(periodic.ScheduleNext(db, running, time.Hour), IsNil)
+	c.Assert(periodic.Schedule(db, jobType), IsNil)
+	c.Assert(queued(), HasLen, 2)
+}
@@ -24,13 +24,18 @@ func (c *controllerAPI) ProvisionResource(ctx context.Context, w http.ResponseWr
 		return
 	}
 
+	if err := c.checkResourcePolicy(p, rr.Apps); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
 	var config []byte
 	if rr.Config != nil {
 		config = *rr.Config
 	} else {
 		config = []byte(`{}`)
 	}
-	data, err := resource.Provision(p.URL, config)
+	data, err := resource.Provision(ctx, p.URL, config)
 	if err != nil {
 		respondWithError(w, err)
 		return
@@ -149,7 +154,7 @@ func (c *controllerAPI) DeleteResource(ctx context.Context, w http.ResponseWrite
 	}
 
 	logger.Info("deprovisioning", "url", p.URL, "external.id", res.ExternalID)
-	if err := resource.Deprovision(p.URL, res.ExternalID); err != nil {
+	if err := resource.Deprovision(ctx, p.URL, res.ExternalID); err != nil {
 		logger.Error("error deprovisioning", "err", err)
 		respondWithError(w, err)
 		return
@@ -169,12 +174,17 @@ func (c *controllerAPI) DeleteResource(ctx context.Context, w http.ResponseWrite
 func (c *controllerAPI) AddResourceApp(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	params, _ := ctxhelper.ParamsFromContext(ctx)
 
-	_, err := c.getProvider(ctx)
+	p, err := c.getProvider(ctx)
 	if err != nil {
 		respondWithError(w, err)
 		return
 	}
 
+	if err := c.checkResourcePolicy(p, []string{params.ByName("app_id")}); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
 	resource, err := c.resourceRepo.AddApp(params.ByName("resources_id"), params.ByName("app_id"))
 	if err != nil {
 		respondWithError(w, err)
@@ -210,3 +220,33 @@ func (c *controllerAPI) GetAppResources(ctx context.Context, w http.ResponseWrit
 	}
 	httphelper.JSON(w, 200, res)
 }
+
+// checkResourcePolicy returns an error if the resource policy does not
+// permit any of the given apps to add a resource from the given provider.
+func (c *controllerAPI) checkResourcePolicy(p *ct.Provider, appIDs []string) error {
+	policy := c.config.resourcePolicy
+	if !policy.Enabled() {
+		return nil
+	}
+	for _, appID := range appIDs {
+		data, err := c.appRepo.Get(appID)
+		if err != nil {
+			return err
+		}
+		app := data.(*ct.App)
+		resources, err := c.resourceRepo.AppList(app.ID)
+		if err != nil {
+			return err
+		}
+		existing := 0
+		for _, r := range resources {
+			if r.ProviderID == p.ID {
+				existing++
+			}
+		}
+		if err := policy.Check(app, p, existing); err != nil {
+			return err
+		}
+	}
+	return nil
+}
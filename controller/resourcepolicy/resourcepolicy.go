@@ -0,0 +1,140 @@
+// Package resourcepolicy implements the policy which limits the resources
+// apps may provision, configured by the controller's RESOURCE_POLICY
+// environment variable.
+//
+// The policy is a JSON object with a default app policy and optional
+// per-app policies keyed by app name or ID, for example to deny MongoDB
+// resources and limit apps to a single Postgres database, except for the
+// "reporting" app which may have two:
+//
+//	{
+//	  "default": {"deny": ["mongodb"], "limits": {"postgres": 1}},
+//	  "apps": {"reporting": {"deny": ["mongodb"], "limits": {"postgres": 2}}}
+//	}
+//
+// A per-app policy replaces the default policy rather than being merged
+// with it.
+package resourcepolicy
+
+import (
+	"encoding/json"
+	"fmt"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/httphelper"
+)
+
+type Policy struct {
+	Default *AppPolicy            `json:"default,omitempty"`
+	Apps    map[string]*AppPolicy `json:"apps,omitempty"`
+}
+
+// AppPolicy restricts the resources an app may provision, by provider name.
+type AppPolicy struct {
+	// Allow is the list of providers the app may provision resources
+	// from, an empty list allows all providers
+	Allow []string `json:"allow,omitempty"`
+
+	// Deny is the list of providers the app may not provision resources
+	// from, and takes precedence over Allow
+	Deny []string `json:"deny,omitempty"`
+
+	// Limits is the maximum number of resources the app may have from
+	// each provider
+	Limits map[string]int `json:"limits,omitempty"`
+}
+
+// Parse parses a JSON encoded policy, returning a nil policy (which allows
+// all resources) if s is empty.
+func Parse(s string) (*Policy, error) {
+	if s == "" {
+		return nil, nil
+	}
+	p := &Policy{}
+	if err := json.Unmarshal([]byte(s), p); err != nil {
+		return nil, err
+	}
+	for name, app := range p.Apps {
+		if app == nil {
+			return nil, fmt.Errorf("resourcepolicy: missing policy for app %q", name)
+		}
+		if err := app.validate(); err != nil {
+			return nil, fmt.Errorf("resourcepolicy: invalid policy for app %q: %s", name, err)
+		}
+	}
+	if p.Default != nil {
+		if err := p.Default.validate(); err != nil {
+			return nil, fmt.Errorf("resourcepolicy: invalid default policy: %s", err)
+		}
+	}
+	return p, nil
+}
+
+func (a *AppPolicy) validate() error {
+	for provider, limit := range a.Limits {
+		if limit < 0 {
+			return fmt.Errorf("negative limit for provider %q", provider)
+		}
+	}
+	return nil
+}
+
+// Enabled returns whether the policy restricts any apps.
+func (p *Policy) Enabled() bool {
+	return p != nil && (p.Default != nil || len(p.Apps) > 0)
+}
+
+// For returns the policy for the given app, or nil if it is unrestricted.
+func (p *Policy) For(app *ct.App) *AppPolicy {
+	if p == nil {
+		return nil
+	}
+	if a, ok := p.Apps[app.Name]; ok {
+		return a
+	}
+	if a, ok := p.Apps[app.ID]; ok {
+		return a
+	}
+	return p.Default
+}
+
+// Check returns an error if the app may not add another resource from the
+// given provider, given the number of resources the app already has from
+// that provider.
+func (p *Policy) Check(app *ct.App, provider *ct.Provider, existing int) error {
+	a := p.For(app)
+	if a == nil {
+		return nil
+	}
+	if !a.allows(provider.Name) {
+		return forbidden("app %s is not permitted to provision %s resources", app.Name, provider.Name)
+	}
+	if limit, ok := a.Limits[provider.Name]; ok && existing >= limit {
+		return forbidden("app %s has reached its limit of %d %s resources", app.Name, limit, provider.Name)
+	}
+	return nil
+}
+
+func (a *AppPolicy) allows(provider string) bool {
+	for _, name := range a.Deny {
+		if name == provider {
+			return false
+		}
+	}
+	if len(a.Allow) == 0 {
+		return true
+	}
+	for _, name := range a.Allow {
+		if name == provider {
+			return true
+		}
+	}
+	return false
+}
+
+func forbidden(format string, args ...interface{}) error {
+	return httphelper.JSONError{
+		Code:    httphelper.ForbiddenErrorCode,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
@@ -0,0 +1,76 @@
+package resourcepolicy
+
+import (
+	"testing"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/httphelper"
+)
+
+func TestPolicy(t *testing.T) {
+	policy, err := Parse(`{
+		"default": {"deny": ["mongodb"], "limits": {"postgres": 1}},
+		"apps": {
+			"reporting": {"limits": {"postgres": 2}},
+			"cache": {"allow": ["redis"]}
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !policy.Enabled() {
+		t.Fatal("expected policy to be enabled")
+	}
+
+	var (
+		web       = &ct.App{ID: "1", Name: "web"}
+		reporting = &ct.App{ID: "2", Name: "reporting"}
+		cache     = &ct.App{ID: "3", Name: "cache"}
+
+		postgres = &ct.Provider{Name: "postgres"}
+		mongodb  = &ct.Provider{Name: "mongodb"}
+		redis    = &ct.Provider{Name: "redis"}
+	)
+	for _, x := range []struct {
+		app      *ct.App
+		provider *ct.Provider
+		existing int
+		allowed  bool
+	}{
+		{web, postgres, 0, true},
+		{web, postgres, 1, false},
+		{web, mongodb, 0, false},
+		{web, redis, 5, true},
+		{reporting, postgres, 1, true},
+		{reporting, postgres, 2, false},
+		{reporting, mongodb, 0, true},
+		{cache, redis, 0, true},
+		{cache, postgres, 0, false},
+	} {
+		err := policy.Check(x.app, x.provider, x.existing)
+		if x.allowed && err != nil {
+			t.Fatalf("expected %s to be allowed %s resource with %d existing, got %s", x.app.Name, x.provider.Name, x.existing, err)
+		}
+		if !x.allowed && !httphelper.IsForbidden(err) {
+			t.Fatalf("expected %s to be forbidden %s resource with %d existing, got %v", x.app.Name, x.provider.Name, x.existing, err)
+		}
+	}
+
+	var nilPolicy *Policy
+	if nilPolicy.Enabled() {
+		t.Fatal("expected nil policy to be disabled")
+	}
+	if err := nilPolicy.Check(web, mongodb, 10); err != nil {
+		t.Fatalf("expected nil policy to allow all resources, got %s", err)
+	}
+
+	for _, s := range []string{
+		`{"default": {"limits": {"postgres": -1}}}`,
+		`{"apps": {"web": null}}`,
+		`not json`,
+	} {
+		if _, err := Parse(s); err == nil {
+			t.Fatalf("expected error parsing %q", s)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"golang.org/x/net/context"
+)
+
+// maxReviewAppNameLength keeps review app names short enough to be used as
+// a DNS label in the domain of their default route
+const maxReviewAppNameLength = 63
+
+// CreateReviewApp creates a review app for a branch by cloning the app with
+// new resources and routes on the review app's default domain, or extends
+// the TTL of the branch's existing review app.
+func (c *controllerAPI) CreateReviewApp(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	parent := c.getApp(ctx)
+
+	var rr ct.ReviewAppRequest
+	if err := httphelper.DecodeJSON(req, &rr); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if rr.Branch == "" {
+		respondWithError(w, ct.ValidationError{Field: "branch", Message: "must not be empty"})
+		return
+	}
+	ttl, err := reviewAppTTL(parent, rr.TTL)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	expiresAt := time.Now().Add(ttl)
+
+	app, err := c.reviewAppRepo.Get(parent.ID, rr.Branch)
+	switch {
+	case err == nil:
+		app.ExpiresAt = &expiresAt
+		if err := c.reviewAppRepo.UpdateExpiry(app); err != nil {
+			respondWithError(w, err)
+			return
+		}
+	case err == ErrNotFound:
+		// the review app should not itself create review apps
+		src := *parent
+		src.Meta = make(map[string]string, len(parent.Meta))
+		for k, v := range parent.Meta {
+			if k != ct.ReviewAppsMetaKey && k != ct.ReviewAppTTLMetaKey {
+				src.Meta[k] = v
+			}
+		}
+		clone, err := c.cloneApp(ctx, &src, &ct.AppCloneRequest{
+			Name:               reviewAppName(parent.Name, rr.Branch),
+			ProvisionResources: true,
+		})
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		app = &ct.ReviewApp{
+			AppID:       clone.App.ID,
+			AppName:     clone.App.Name,
+			ParentAppID: parent.ID,
+			Branch:      rr.Branch,
+			ExpiresAt:   &expiresAt,
+		}
+		if err := c.reviewAppRepo.Add(app); err != nil {
+			c.deleteApp(clone.App)
+			respondWithError(w, err)
+			return
+		}
+	default:
+		respondWithError(w, err)
+		return
+	}
+
+	if app.Routes, err = c.routeRepo.List(routeParentRef(app.AppID)); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, app)
+}
+
+// ListReviewApps lists the review apps of the app.
+func (c *controllerAPI) ListReviewApps(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	apps, err := c.reviewAppRepo.List(c.getApp(ctx).ID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, apps)
+}
+
+// DeleteReviewApp deletes the review app of the branch given in the branch
+// parameter along with its resources, which is done when the branch is
+// deleted. Branches are not part of the path since they may contain
+// slashes.
+func (c *controllerAPI) DeleteReviewApp(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	branch := req.FormValue("branch")
+	if branch == "" {
+		respondWithError(w, ct.ValidationError{Field: "branch", Message: "must not be empty"})
+		return
+	}
+	app, err := c.reviewAppRepo.Get(c.getApp(ctx).ID, branch)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	a, err := c.appRepo.Get(app.AppID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := c.reviewAppRepo.Remove(app.AppID); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := c.enqueueAppDeletion(a.(*ct.App)); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, app)
+}
+
+// reviewAppTTL returns how long a review app of the given app lives after
+// its branch is pushed, which is ttl seconds if set, otherwise the app's
+// TTL or the default.
+func reviewAppTTL(parent *ct.App, ttl int32) (time.Duration, error) {
+	if ttl < 0 {
+		return 0, ct.ValidationError{Field: "ttl", Message: "must not be negative"}
+	}
+	if ttl > 0 {
+		return time.Duration(ttl) * time.Second, nil
+	}
+	if s, ok := parent.Meta[ct.ReviewAppTTLMetaKey]; ok && s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return 0, ct.ValidationError{
+				Field:   "ttl",
+				Message: fmt.Sprintf("app meta %s must be a positive duration (e.g. 72h), got %q", ct.ReviewAppTTLMetaKey, s),
+			}
+		}
+		return d, nil
+	}
+	return ct.DefaultReviewAppTTL, nil
+}
+
+// reviewAppName returns the name of the review app of a branch, which is
+// the app name followed by the branch with anything not valid in an app
+// name replaced with hyphens. Names which would be too long, or branches
+// with nothing valid in an app name, are given a suffix derived from the
+// branch to keep them unique.
+func reviewAppName(appName, branch string) string {
+	var slug []byte
+	for _, r := range strings.ToLower(branch) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			slug = append(slug, byte(r))
+		} else if len(slug) > 0 && slug[len(slug)-1] != '-' {
+			slug = append(slug, '-')
+		}
+	}
+	name := appName
+	if s := strings.TrimSuffix(string(slug), "-"); s != "" {
+		name += "-" + s
+		if len(name) <= maxReviewAppNameLength {
+			return name
+		}
+	}
+	sum := sha1.Sum([]byte(branch))
+	suffix := "-" + hex.EncodeToString(sum[:])[:8]
+	if len(name) > maxReviewAppNameLength-len(suffix) {
+		name = strings.TrimRight(name[:maxReviewAppNameLength-len(suffix)], "-")
+	}
+	return name + suffix
+}
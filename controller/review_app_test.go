@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	. "github.com/flynn/go-check"
+)
+
+func (s *S) TestReviewApps(c *C) {
+	app := s.createTestApp(c, &ct.App{
+		Name: "review-apps",
+		Meta: map[string]string{"foo": "bar", ct.ReviewAppsMetaKey: "true", ct.ReviewAppTTLMetaKey: "2h"},
+	})
+
+	before := time.Now()
+	reviewApp, err := s.c.CreateReviewApp(app.ID, &ct.ReviewAppRequest{Branch: "feature/Login"})
+	c.Assert(err, IsNil)
+	c.Assert(reviewApp.AppName, Equals, "review-apps-feature-login")
+	c.Assert(reviewApp.ParentAppID, Equals, app.ID)
+	c.Assert(reviewApp.Branch, Equals, "feature/Login")
+	c.Assert(reviewApp.ExpiresAt.After(before.Add(2*time.Hour)), Equals, true)
+
+	// the review app doesn't inherit the settings for review apps
+	gotApp, err := s.c.GetApp(reviewApp.AppID)
+	c.Assert(err, IsNil)
+	c.Assert(gotApp.Meta, DeepEquals, map[string]string{"foo": "bar"})
+
+	// pushing the branch again extends the TTL of the same review app
+	again, err := s.c.CreateReviewApp(app.ID, &ct.ReviewAppRequest{Branch: "feature/Login", TTL: 3600 * 24})
+	c.Assert(err, IsNil)
+	c.Assert(again.AppID, Equals, reviewApp.AppID)
+	c.Assert(again.ExpiresAt.After(*reviewApp.ExpiresAt), Equals, true)
+
+	list, err := s.c.ReviewAppList(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 1)
+	c.Assert(list[0].AppID, Equals, reviewApp.AppID)
+
+	_, err = s.c.CreateReviewApp(app.ID, &ct.ReviewAppRequest{})
+	c.Assert(err, NotNil)
+	_, err = s.c.CreateReviewApp(app.ID, &ct.ReviewAppRequest{Branch: "other", TTL: -1})
+	c.Assert(err, NotNil)
+
+	deleted, err := s.c.DeleteReviewApp(app.ID, "feature/Login")
+	c.Assert(err, IsNil)
+	c.Assert(deleted.AppID, Equals, reviewApp.AppID)
+	list, err = s.c.ReviewAppList(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 0)
+	_, err = s.c.DeleteReviewApp(app.ID, "feature/Login")
+	c.Assert(err, NotNil)
+}
+
+func (s *S) TestReviewAppName(c *C) {
+	for _, t := range []struct {
+		app    string
+		branch string
+		name   string
+	}{
+		{"myapp", "feature", "myapp-feature"},
+		{"myapp", "feature/Login_Page", "myapp-feature-login-page"},
+		{"myapp", "--fix--", "myapp-fix"},
+	} {
+		c.Assert(reviewAppName(t.app, t.branch), Equals, t.name, Commentf("%s", t.branch))
+	}
+
+	// branches without any valid characters get a suffix from the branch
+	c.Assert(reviewAppName("myapp", "___"), Matches, "myapp-[0-9a-f]{8}")
+	c.Assert(reviewAppName("myapp", "___"), Not(Equals), reviewAppName("myapp", "__"))
+
+	long := strings.Repeat("a", 70)
+	name := reviewAppName("myapp", long)
+	c.Assert(len(name) <= maxReviewAppNameLength, Equals, true)
+	c.Assert(name, Not(Equals), reviewAppName("myapp", long+"b"))
+}
+
+func (s *S) TestReviewAppTTL(c *C) {
+	app := &ct.App{Meta: map[string]string{}}
+	ttl, err := reviewAppTTL(app, 0)
+	c.Assert(err, IsNil)
+	c.Assert(ttl, Equals, ct.DefaultReviewAppTTL)
+
+	app.Meta[ct.ReviewAppTTLMetaKey] = "12h"
+	ttl, err = reviewAppTTL(app, 0)
+	c.Assert(err, IsNil)
+	c.Assert(ttl, Equals, 12*time.Hour)
+
+	ttl, err = reviewAppTTL(app, 60)
+	c.Assert(err, IsNil)
+	c.Assert(ttl, Equals, time.Minute)
+
+	app.Meta[ct.ReviewAppTTLMetaKey] = "soon"
+	_, err = reviewAppTTL(app, 0)
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/flynn/flynn/controller/data"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/httphelper"
+	router "github.com/flynn/flynn/router/types"
+)
+
+// isDryRun returns whether the request asks for the impact of a change
+// rather than making it.
+func isDryRun(req *http.Request) bool {
+	return req.FormValue("dry_run") == "true"
+}
+
+// routeDryRun responds with the impact of adding the route, or of updating
+// it if previous is set, without saving it.
+func (c *controllerAPI) routeDryRun(w http.ResponseWriter, route, previous *router.Route) {
+	var err error
+	if previous == nil {
+		err = c.routeRepo.AddDryRun(route)
+	} else {
+		err = c.routeRepo.UpdateDryRun(route)
+		if err == data.ErrRouteNotFound {
+			err = ErrNotFound
+		}
+	}
+	if err != nil && err != data.ErrRouteConflict {
+		if err != ErrNotFound {
+			err = routeAddError(route, err)
+		}
+		respondWithError(w, err)
+		return
+	}
+
+	res := &ct.RouteDryRun{Route: route, Previous: previous}
+	routes, lerr := c.routeRepo.List("")
+	if lerr != nil {
+		respondWithError(w, lerr)
+		return
+	}
+	res.Conflicts = routeConflicts(route, routes)
+	duplicate := false
+	for _, conflict := range res.Conflicts {
+		if conflict.Type == ct.RouteConflictDuplicate {
+			duplicate = true
+		}
+	}
+	if err != nil && !duplicate {
+		// the conflict is not with another route, so report it as
+		// adding the route would
+		respondWithError(w, routeAddError(route, err))
+		return
+	}
+
+	if previous != nil {
+		res.Changes = routeChanges(previous, route)
+		res.DrainsConnections, res.DrainReason = routeDrains(previous, route)
+	}
+	if !duplicate && (previous == nil || len(res.Changes) > 0) && c.config.routers != nil {
+		instances, err := c.config.routers.Instances()
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		for _, inst := range instances {
+			res.Routers = append(res.Routers, inst.Addr)
+		}
+		sort.Strings(res.Routers)
+	}
+	httphelper.JSON(w, 200, res)
+}
+
+// routeConflicts returns the routes which conflict with the given route,
+// either because they have the same domain and path or port, or because they
+// belong to another app and route the same domain.
+func routeConflicts(route *router.Route, routes []*router.Route) []*ct.RouteConflict {
+	var conflicts []*ct.RouteConflict
+	for _, r := range routes {
+		if r.ID == route.ID || r.Type != route.Type {
+			continue
+		}
+		conflict := &ct.RouteConflict{
+			AppID: strings.TrimPrefix(r.ParentRef, ct.RouteParentRefPrefix),
+			Route: r,
+		}
+		switch {
+		case route.Type == "tcp" && r.Port == route.Port:
+			conflict.Type = ct.RouteConflictDuplicate
+		case route.Type == "http" && strings.EqualFold(r.Domain, route.Domain) && r.Port == route.Port:
+			if routePath(r) == routePath(route) {
+				conflict.Type = ct.RouteConflictDuplicate
+			} else if r.ParentRef != route.ParentRef {
+				conflict.Type = ct.RouteConflictDomain
+			} else {
+				continue
+			}
+		default:
+			continue
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	return conflicts
+}
+
+// routePath returns the path of an HTTP route, which is "/" if not set.
+func routePath(r *router.Route) string {
+	if r.Path == "" {
+		return "/"
+	}
+	return r.Path
+}
+
+// routeChanges returns the names of the fields which differ between two
+// versions of a route.
+func routeChanges(from, to *router.Route) []string {
+	var changes []string
+	changed := func(name string, a, b interface{}) {
+		if !reflect.DeepEqual(a, b) {
+			changes = append(changes, name)
+		}
+	}
+	changed("service", from.Service, to.Service)
+	changed("port", from.Port, to.Port)
+	changed("leader", from.Leader, to.Leader)
+	changed("drain_backends", from.DrainBackends, to.DrainBackends)
+	if from.Type == "http" {
+		changed("domain", strings.ToLower(from.Domain), strings.ToLower(to.Domain))
+		changed("path", routePath(from), routePath(to))
+		changed("sticky", from.Sticky, to.Sticky)
+		changed("disable_keep_alives", from.DisableKeepAlives, to.DisableKeepAlives)
+		changed("http2", from.HTTP2, to.HTTP2)
+		changed("certificate", routeCert(from), routeCert(to))
+		changed("managed_certificate_domain", managedCertDomain(from), managedCertDomain(to))
+		changed("tls_policy", from.TLSPolicy, to.TLSPolicy)
+		changed("client_ca", from.ClientCA, to.ClientCA)
+		changed("redirect_https", from.RedirectHTTPS, to.RedirectHTTPS)
+		changed("splits", from.Splits, to.Splits)
+	}
+	return changes
+}
+
+// routeCert returns the PEM encoded certificate chain of a route.
+func routeCert(r *router.Route) string {
+	if r.ManagedCertificateDomain != nil && *r.ManagedCertificateDomain != "" {
+		// managed certificates are tracked by domain
+		return ""
+	}
+	if r.Certificate != nil {
+		return strings.TrimSpace(r.Certificate.Cert)
+	}
+	return strings.TrimSpace(r.LegacyTLSCert)
+}
+
+func managedCertDomain(r *router.Route) string {
+	if r.ManagedCertificateDomain == nil {
+		return ""
+	}
+	return *r.ManagedCertificateDomain
+}
+
+// routeDrains returns whether updating a route drains existing connections
+// to its backends, and why.
+func routeDrains(from, to *router.Route) (bool, string) {
+	var reasons []string
+	if from.Service != to.Service {
+		reasons = append(reasons, fmt.Sprintf("traffic moves from service %s to %s", from.Service, to.Service))
+	}
+	if from.Leader != to.Leader {
+		if to.Leader {
+			reasons = append(reasons, "traffic is only routed to the leader")
+		} else {
+			reasons = append(reasons, "traffic is routed to all instances instead of only the leader")
+		}
+	}
+	if from.Type == "tcp" && from.Port != to.Port {
+		reasons = append(reasons, fmt.Sprintf("the route moves from port %d to %d", from.Port, to.Port))
+	}
+	return len(reasons) > 0, strings.Join(reasons, ", ")
+}
@@ -2,11 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"sort"
+	"strings"
 
 	"github.com/flynn/flynn/controller/data"
 	"github.com/flynn/flynn/controller/schema"
+	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/flynn/pkg/ctxhelper"
 	"github.com/flynn/flynn/pkg/httphelper"
 	router "github.com/flynn/flynn/router/types"
@@ -26,57 +29,54 @@ func (c *controllerAPI) CreateRoute(ctx context.Context, w http.ResponseWriter,
 		return
 	}
 
-	// Check if ACME is enabled when managed certificate is requested
-	if route.ManagedCertificateDomain != nil && *route.ManagedCertificateDomain != "" {
-		enabled, err := c.acmeConfigRepo.IsEnabled()
-		if err != nil {
-			respondWithError(w, err)
-			return
-		}
-		if !enabled {
-			httphelper.Error(w, httphelper.JSONError{
-				Code:    httphelper.ValidationErrorCode,
-				Message: "ACME/Let's Encrypt is not enabled. Run 'flynn-host acme configure' and 'flynn-host acme enable' first.",
-			})
-			return
-		}
+	if err := c.validateRoute(&route); err != nil {
+		respondWithError(w, err)
+		return
 	}
 
-	err := c.routeRepo.Add(&route)
-	if err != nil {
-		rjson, jerr := json.Marshal(&route)
-		if jerr != nil {
-			httphelper.Error(w, jerr)
-			return
-		}
-		jsonError := httphelper.JSONError{Detail: rjson}
-		switch err {
-		case data.ErrRouteConflict:
-			jsonError.Code = httphelper.ConflictErrorCode
-			jsonError.Message = "Duplicate route"
-		case data.ErrRouteReserved:
-			jsonError.Code = httphelper.ConflictErrorCode
-			jsonError.Message = "Port reserved for HTTP/HTTPS traffic"
-		case data.ErrRouteUnreservedHTTP:
-			jsonError.Code = httphelper.ValidationErrorCode
-			jsonError.Message = "Port not reserved for HTTP traffic"
-		case data.ErrRouteUnreservedHTTPS:
-			jsonError.Code = httphelper.ValidationErrorCode
-			jsonError.Message = "Port not reserved for HTTPS traffic"
-		case data.ErrRouteInvalid:
-			jsonError.Code = httphelper.ValidationErrorCode
-			jsonError.Message = "Invalid route"
-		default:
-			httphelper.Error(w, err)
-			return
-		}
-		httphelper.Error(w, jsonError)
+	if isDryRun(req) {
+		c.routeDryRun(w, &route, nil)
+		return
+	}
+
+	if err := c.routeRepo.Add(&route); err != nil {
+		httphelper.Error(w, routeAddError(&route, err))
 		return
 	}
 
 	httphelper.JSON(w, 200, &route)
 }
 
+// routeAddError converts an error from adding the route to a JSON error
+// including the route.
+func routeAddError(route *router.Route, err error) error {
+	rjson, jerr := json.Marshal(route)
+	if jerr != nil {
+		return jerr
+	}
+	jsonError := httphelper.JSONError{Detail: rjson}
+	switch err {
+	case data.ErrRouteConflict:
+		jsonError.Code = httphelper.ConflictErrorCode
+		jsonError.Message = "Duplicate route"
+	case data.ErrRouteReserved:
+		jsonError.Code = httphelper.ConflictErrorCode
+		jsonError.Message = "Port reserved for HTTP/HTTPS traffic"
+	case data.ErrRouteUnreservedHTTP:
+		jsonError.Code = httphelper.ValidationErrorCode
+		jsonError.Message = "Port not reserved for HTTP traffic"
+	case data.ErrRouteUnreservedHTTPS:
+		jsonError.Code = httphelper.ValidationErrorCode
+		jsonError.Message = "Port not reserved for HTTPS traffic"
+	case data.ErrRouteInvalid:
+		jsonError.Code = httphelper.ValidationErrorCode
+		jsonError.Message = "Invalid route"
+	default:
+		return err
+	}
+	return jsonError
+}
+
 func (c *controllerAPI) GetRoute(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	route, err := c.getRoute(ctx)
 	if err != nil {
@@ -124,25 +124,26 @@ func (c *controllerAPI) UpdateRoute(ctx context.Context, w http.ResponseWriter,
 	route.Type = params.ByName("routes_type")
 	route.ID = params.ByName("routes_id")
 
-	// Check if ACME is enabled when managed certificate is requested
-	if route.ManagedCertificateDomain != nil && *route.ManagedCertificateDomain != "" {
-		enabled, err := c.acmeConfigRepo.IsEnabled()
+	if err := c.validateRoute(&route); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	if isDryRun(req) {
+		previous, err := c.getRoute(ctx)
 		if err != nil {
 			respondWithError(w, err)
 			return
 		}
-		if !enabled {
-			httphelper.Error(w, httphelper.JSONError{
-				Code:    httphelper.ValidationErrorCode,
-				Message: "ACME/Let's Encrypt is not enabled. Run 'flynn-host acme configure' and 'flynn-host acme enable' first.",
-			})
-			return
-		}
+		c.routeDryRun(w, &route, previous)
+		return
 	}
 
 	if err := c.routeRepo.Update(&route); err != nil {
 		if err == data.ErrRouteNotFound {
 			err = ErrNotFound
+		} else if err == data.ErrRouteConflict {
+			err = routeAddError(&route, err)
 		}
 		respondWithError(w, err)
 		return
@@ -150,6 +151,223 @@ func (c *controllerAPI) UpdateRoute(ctx context.Context, w http.ResponseWriter,
 	httphelper.JSON(w, 200, route)
 }
 
+// PatchRoute updates only the fields of a route which are set in the
+// request, rejecting the update with a conflict if the request sets
+// updated_at and the route has been updated since.
+func (c *controllerAPI) PatchRoute(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var patch router.RoutePatch
+	if err := httphelper.DecodeJSON(req, &patch); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	previous, err := c.getRoute(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if patch.UpdatedAt != nil && !patch.UpdatedAt.Equal(previous.UpdatedAt) {
+		respondWithError(w, routeStaleError(previous))
+		return
+	}
+
+	// validate the patched route before saving it, which re-applies the
+	// patch to the route once it is locked
+	route := *previous
+	patch.Apply(&route)
+	if err := c.validateRoute(&route); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	if isDryRun(req) {
+		c.routeDryRun(w, &route, previous)
+		return
+	}
+
+	updated, err := c.routeRepo.Patch(previous.Type, previous.ID, &patch)
+	switch err {
+	case nil:
+	case data.ErrRouteNotFound:
+		err = ErrNotFound
+	case data.ErrRouteStale:
+		err = routeStaleError(updated)
+	case data.ErrRouteConflict:
+		err = routeAddError(&route, err)
+	}
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, updated)
+}
+
+// routeStaleError returns a conflict error for a patch which was based on an
+// older version of the route, including the current route.
+func routeStaleError(route *router.Route) error {
+	rjson, err := json.Marshal(route)
+	if err != nil {
+		return err
+	}
+	return httphelper.JSONError{
+		Code:    httphelper.ConflictErrorCode,
+		Message: "Route has been updated since updated_at",
+		Detail:  rjson,
+	}
+}
+
+// validateRoute checks the TLS settings and managed certificate domain of a
+// route being created or updated, and that ACME is enabled if the route has
+// a managed certificate.
+func (c *controllerAPI) validateRoute(route *router.Route) error {
+	if err := validateRouteTLS(route); err != nil {
+		return err
+	}
+	if err := validateRouteSplits(route); err != nil {
+		return err
+	}
+	if err := validateRouteHTTP2(route); err != nil {
+		return err
+	}
+	if route.ManagedCertificateDomain != nil && *route.ManagedCertificateDomain != "" {
+		config, err := c.acmeConfigRepo.Get()
+		if err != nil {
+			return err
+		}
+		if !config.Enabled {
+			return httphelper.JSONError{
+				Code:    httphelper.ValidationErrorCode,
+				Message: "ACME/Let's Encrypt is not enabled. Run 'flynn-host acme configure' and 'flynn-host acme enable' first.",
+			}
+		}
+		if err := validateManagedCertificateDomain(route, config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateRouteSplits checks that a route's splits have valid services and
+// weights, that they only send requests to the route's own service when
+// limited to a release, and that they are not set on TCP routes.
+func validateRouteSplits(route *router.Route) error {
+	if len(route.Splits) == 0 {
+		return nil
+	}
+	if route.Type == "tcp" {
+		return httphelper.JSONError{
+			Code:    httphelper.ValidationErrorCode,
+			Message: "route splits are only supported for HTTP routes",
+		}
+	}
+	if err := router.ValidateRouteSplits(route.Splits); err != nil {
+		return httphelper.JSONError{
+			Code:    httphelper.ValidationErrorCode,
+			Message: err.Error(),
+		}
+	}
+	seen := make(map[string]struct{}, len(route.Splits))
+	for _, split := range route.Splits {
+		key := split.Service + "@" + split.Release
+		if _, ok := seen[key]; ok || (split.Service == route.Service && split.Release == "") {
+			return httphelper.JSONError{
+				Code:    httphelper.ValidationErrorCode,
+				Message: fmt.Sprintf("route split service %q is duplicated", split.Service),
+			}
+		}
+		seen[key] = struct{}{}
+	}
+	return nil
+}
+
+// validateRouteHTTP2 checks that h2c is only enabled for HTTP routes without
+// sticky sessions, which rely on a cookie per HTTP/1.1 response rather than
+// long lived streams, and that the route's TLS policy does not stop clients
+// negotiating HTTP/2.
+func validateRouteHTTP2(route *router.Route) error {
+	if !route.HTTP2 {
+		return nil
+	}
+	offersH2 := route.TLSPolicy == nil || len(route.TLSPolicy.ALPNProtocols) == 0
+	if !offersH2 {
+		for _, proto := range route.TLSPolicy.ALPNProtocols {
+			if proto == "h2" {
+				offersH2 = true
+				break
+			}
+		}
+	}
+	var msg string
+	switch {
+	case route.Type == "tcp":
+		msg = "http2 is only supported for HTTP routes"
+	case route.Sticky:
+		msg = "http2 is not supported for routes with sticky sessions"
+	case !offersH2:
+		msg = "http2 requires the route's TLS policy to offer h2 with ALPN"
+	default:
+		return nil
+	}
+	return httphelper.JSONError{
+		Code:    httphelper.ValidationErrorCode,
+		Message: msg,
+	}
+}
+
+// validateRouteTLS checks that a route's TLS policy only references known TLS
+// versions, cipher suites and curves, that its client CA bundle contains
+// certificates, and that neither is set on TCP routes.
+func validateRouteTLS(route *router.Route) error {
+	if route.TLSPolicy == nil && route.ClientCA == "" {
+		return nil
+	}
+	if route.Type == "tcp" {
+		return httphelper.JSONError{
+			Code:    httphelper.ValidationErrorCode,
+			Message: "TLS policies and client CAs are only supported for HTTP routes",
+		}
+	}
+	if err := route.TLSPolicy.Validate(); err != nil {
+		return httphelper.JSONError{
+			Code:    httphelper.ValidationErrorCode,
+			Message: err.Error(),
+		}
+	}
+	if route.ClientCA != "" {
+		if _, err := router.ParseClientCA(route.ClientCA); err != nil {
+			return httphelper.JSONError{
+				Code:    httphelper.ValidationErrorCode,
+				Message: err.Error(),
+			}
+		}
+	}
+	return nil
+}
+
+// validateManagedCertificateDomain checks that a wildcard managed
+// certificate domain covers the route's domain, and that a DNS webhook is
+// configured to complete the DNS-01 challenges which CAs require to issue
+// wildcard certificates.
+func validateManagedCertificateDomain(route *router.Route, config *ct.ACMEConfig) error {
+	domain := *route.ManagedCertificateDomain
+	if !strings.HasPrefix(domain, "*.") {
+		return nil
+	}
+	var msg string
+	switch {
+	case !ct.WildcardDomainCovers(domain, route.Domain):
+		msg = fmt.Sprintf("managed certificate domain %q does not cover the route domain %q", domain, route.Domain)
+	case config.DNSWebhookURL == "":
+		msg = "managed certificates for wildcard domains require the DNS-01 ACME challenge, configure a DNS webhook with 'flynn-host acme configure-dns' first"
+	default:
+		return nil
+	}
+	return httphelper.JSONError{
+		Code:    httphelper.ValidationErrorCode,
+		Message: msg,
+	}
+}
+
 func (c *controllerAPI) DeleteRoute(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	route, err := c.getRoute(ctx)
 	if err != nil {
@@ -6,7 +6,9 @@ import (
 	"time"
 
 	controller "github.com/flynn/flynn/controller/client"
+	"github.com/flynn/flynn/controller/data"
 	ct "github.com/flynn/flynn/controller/types"
+	hh "github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/tlscert"
 	"github.com/flynn/flynn/router/testutils"
 	router "github.com/flynn/flynn/router/types"
@@ -102,6 +104,48 @@ func (s *S) TestCreateHTTPRouteWithInvalidCertificate(c *C) {
 	c.Assert(err, Not(IsNil))
 }
 
+func (s *S) TestCreateHTTPRouteWithWildcardManagedCertificate(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "create-http-route-with-wildcard-managed-cert"})
+	domain := "*.example.com"
+	route := router.HTTPRoute{
+		Domain:  "wildcard.example.com",
+		Service: "foo",
+	}.ToRoute()
+	route.ManagedCertificateDomain = &domain
+
+	config, err := s.c.GetACMEConfig()
+	c.Assert(err, IsNil)
+	config.Enabled = true
+	config.ContactEmail = "admin@example.com"
+	c.Assert(s.c.UpdateACMEConfig(config), IsNil)
+	defer func() {
+		config.Enabled = false
+		config.DNSWebhookURL = ""
+		c.Assert(s.c.UpdateACMEConfig(config), IsNil)
+	}()
+
+	// wildcards are rejected without a DNS webhook
+	err = s.c.CreateRoute(app.ID, route)
+	c.Assert(err, Not(IsNil))
+	c.Assert(strings.Contains(err.Error(), "DNS-01"), Equals, true)
+
+	config.DNSWebhookURL = "https://dns.example.com/acme"
+	c.Assert(s.c.UpdateACMEConfig(config), IsNil)
+
+	// the wildcard must cover the route domain
+	other := router.HTTPRoute{
+		Domain:  "a.wildcard.example.com",
+		Service: "foo",
+	}.ToRoute()
+	other.ManagedCertificateDomain = &domain
+	err = s.c.CreateRoute(app.ID, other)
+	c.Assert(err, Not(IsNil))
+	c.Assert(strings.Contains(err.Error(), "does not cover"), Equals, true)
+
+	c.Assert(s.c.CreateRoute(app.ID, route), IsNil)
+	c.Assert(*route.ManagedCertificateDomain, Equals, domain)
+}
+
 func (s *S) TestCreateHTTPRouteWithPath(c *C) {
 	app := s.createTestApp(c, &ct.App{Name: "create-http-route-with-invalid-path"})
 
@@ -190,6 +234,45 @@ func (s *S) TestCreateTCPRouteReservedPort(c *C) {
 	}
 }
 
+func (s *S) TestCreateRouteAdditionalHTTPPorts(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "create-route-additional-http-ports"})
+	repo := data.NewRouteRepo(s.hc.db)
+	repo.SetHTTPPorts([]int{8080}, []int{8443})
+	httpRoute := func(port int, cert *router.Certificate) *router.Route {
+		return (&router.HTTPRoute{
+			ParentRef:   routeParentRef(app.ID),
+			Service:     "foo",
+			Domain:      "additional-ports.example.com",
+			Port:        port,
+			Certificate: cert,
+		}).ToRoute()
+	}
+
+	// HTTP routes can only use ports the router serves HTTP on
+	c.Assert(repo.Add(httpRoute(9000, nil)), Equals, data.ErrRouteUnreservedHTTP)
+	c.Assert(repo.Add(httpRoute(8080, nil)), IsNil)
+
+	// routes with certificates must use a HTTPS port
+	tlsCert := testutils.TLSConfigForDomain("additional-ports.example.com")
+	cert := &router.Certificate{Cert: tlsCert.Cert, Key: tlsCert.PrivateKey}
+	route := httpRoute(8080, cert)
+	route.Path = "/tls/"
+	c.Assert(repo.Add(route), Equals, data.ErrRouteUnreservedHTTPS)
+	route = httpRoute(8443, cert)
+	c.Assert(repo.Add(route), IsNil)
+	c.Assert(route.Certificate.ID, Not(Equals), "")
+
+	// moving a route to an unserved port is rejected
+	route.Port = 9443
+	c.Assert(repo.Update(route), Equals, data.ErrRouteUnreservedHTTP)
+
+	// TCP routes cannot use ports reserved for HTTP traffic
+	for _, port := range []int{8080, 8443} {
+		err := repo.Add(router.TCPRoute{ParentRef: routeParentRef(app.ID), Service: "foo", Port: port}.ToRoute())
+		c.Assert(err, Equals, data.ErrRouteReserved)
+	}
+}
+
 func (s *S) TestDeleteRoute(c *C) {
 	app := s.createTestApp(c, &ct.App{Name: "delete-route"})
 	route := s.createTestRoute(c, app.ID, (&router.TCPRoute{Service: "foo"}).ToRoute())
@@ -223,6 +306,156 @@ func (s *S) TestUpdateRoute(c *C) {
 	c.Assert(routes[0].Sticky, Equals, route1.Sticky)
 }
 
+func (s *S) TestPatchRoute(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "patch-route"})
+	route := s.createTestRoute(c, app.ID, (&router.HTTPRoute{Service: "foo", Domain: "patch.example.com", Sticky: true, Path: "/api/"}).ToRoute())
+
+	// fields which are not in the patch are left unchanged
+	service := "foo-1"
+	patched, err := s.c.PatchRoute(app.ID, route.FormattedID(), &router.RoutePatch{Service: &service})
+	c.Assert(err, IsNil)
+	c.Assert(patched.Service, Equals, "foo-1")
+	c.Assert(patched.Sticky, Equals, true)
+	c.Assert(patched.Path, Equals, "/api/")
+	gotRoute, err := s.c.GetRoute(app.ID, route.FormattedID())
+	c.Assert(err, IsNil)
+	c.Assert(gotRoute.Service, Equals, "foo-1")
+	c.Assert(gotRoute.Sticky, Equals, true)
+	c.Assert(gotRoute.Path, Equals, "/api/")
+
+	// a patch based on an older version of the route is rejected with
+	// the current route
+	sticky := false
+	_, err = s.c.PatchRoute(app.ID, route.FormattedID(), &router.RoutePatch{Sticky: &sticky, UpdatedAt: &route.UpdatedAt})
+	c.Assert(err, NotNil)
+	jsonErr, ok := err.(hh.JSONError)
+	c.Assert(ok, Equals, true)
+	c.Assert(jsonErr.Code, Equals, hh.ConflictErrorCode)
+	var current router.Route
+	c.Assert(json.Unmarshal(jsonErr.Detail, &current), IsNil)
+	c.Assert(current.Service, Equals, "foo-1")
+
+	// a patch based on the current version is applied
+	patched, err = s.c.PatchRoute(app.ID, route.FormattedID(), &router.RoutePatch{Sticky: &sticky, UpdatedAt: &gotRoute.UpdatedAt})
+	c.Assert(err, IsNil)
+	c.Assert(patched.Sticky, Equals, false)
+	c.Assert(patched.Service, Equals, "foo-1")
+	c.Assert(patched.UpdatedAt.After(gotRoute.UpdatedAt), Equals, true)
+
+	// patching another app's route is not found
+	other := s.createTestApp(c, &ct.App{Name: "patch-route-other"})
+	_, err = s.c.PatchRoute(other.ID, route.FormattedID(), &router.RoutePatch{Service: &service})
+	c.Assert(err, Equals, controller.ErrNotFound)
+}
+
+func (s *S) TestRouteSplits(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "route-splits"})
+	route := s.createTestRoute(c, app.ID, (&router.HTTPRoute{Service: "foo", Domain: "splits.example.com"}).ToRoute())
+
+	// splits are stored and returned with the route
+	splits := []*router.RouteSplit{{Service: "foo-canary", Weight: 10}}
+	patched, err := s.c.PatchRoute(app.ID, route.FormattedID(), &router.RoutePatch{Splits: &splits})
+	c.Assert(err, IsNil)
+	c.Assert(patched.Splits, DeepEquals, splits)
+	gotRoute, err := s.c.GetRoute(app.ID, route.FormattedID())
+	c.Assert(err, IsNil)
+	c.Assert(gotRoute.Splits, DeepEquals, splits)
+
+	// invalid splits are rejected
+	for _, invalid := range [][]*router.RouteSplit{
+		{{Service: "foo-canary", Weight: 0}},
+		{{Service: "foo-canary", Weight: 60}, {Service: "foo-other", Weight: 50}},
+		{{Service: "foo", Weight: 10}},
+		{{Service: "foo", Release: "release1", Weight: 10}, {Service: "foo", Release: "release1", Weight: 10}},
+	} {
+		_, err := s.c.PatchRoute(app.ID, route.FormattedID(), &router.RoutePatch{Splits: &invalid})
+		c.Assert(hh.IsValidationError(err), Equals, true)
+	}
+	tcpRoute := (&router.TCPRoute{Service: "foo"}).ToRoute()
+	tcpRoute.Splits = splits
+	c.Assert(hh.IsValidationError(s.c.CreateRoute(app.ID, tcpRoute)), Equals, true)
+
+	// splits limited to a release can send requests to the route's own
+	// service
+	splits = []*router.RouteSplit{{Service: "foo", Release: "release1", Weight: 10}}
+	patched, err = s.c.PatchRoute(app.ID, route.FormattedID(), &router.RoutePatch{Splits: &splits})
+	c.Assert(err, IsNil)
+	c.Assert(patched.Splits, DeepEquals, splits)
+
+	// an empty list removes the splits
+	patched, err = s.c.PatchRoute(app.ID, route.FormattedID(), &router.RoutePatch{Splits: &[]*router.RouteSplit{}})
+	c.Assert(err, IsNil)
+	c.Assert(patched.Splits, IsNil)
+}
+
+func (s *S) TestRouteHTTP2(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "route-http2"})
+	route := s.createTestRoute(c, app.ID, (&router.HTTPRoute{Service: "foo", Domain: "http2.example.com", HTTP2: true}).ToRoute())
+	gotRoute, err := s.c.GetRoute(app.ID, route.FormattedID())
+	c.Assert(err, IsNil)
+	c.Assert(gotRoute.HTTP2, Equals, true)
+
+	// h2c cannot be combined with sticky sessions, an ALPN policy without
+	// h2 or a TCP route
+	sticky := true
+	_, err = s.c.PatchRoute(app.ID, route.FormattedID(), &router.RoutePatch{Sticky: &sticky})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+	_, err = s.c.PatchRoute(app.ID, route.FormattedID(), &router.RoutePatch{TLSPolicy: &router.TLSPolicy{ALPNProtocols: []string{"http/1.1"}}})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+	tcpRoute := (&router.TCPRoute{Service: "foo"}).ToRoute()
+	tcpRoute.HTTP2 = true
+	c.Assert(hh.IsValidationError(s.c.CreateRoute(app.ID, tcpRoute)), Equals, true)
+
+	// disabling h2c allows sticky sessions
+	disabled := false
+	patched, err := s.c.PatchRoute(app.ID, route.FormattedID(), &router.RoutePatch{HTTP2: &disabled, Sticky: &sticky})
+	c.Assert(err, IsNil)
+	c.Assert(patched.HTTP2, Equals, false)
+	c.Assert(patched.Sticky, Equals, true)
+}
+
+func (s *S) TestRouteDryRun(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "route-dry-run"})
+	other := s.createTestApp(c, &ct.App{Name: "route-dry-run-other"})
+	route := s.createTestRoute(c, app.ID, (&router.HTTPRoute{Service: "foo", Domain: "dry-run.example.com"}).ToRoute())
+	otherRoute := s.createTestRoute(c, other.ID, (&router.HTTPRoute{Service: "bar", Domain: "dry-run.example.com", Path: "/other/"}).ToRoute())
+
+	// adding a route reports conflicts without creating it
+	res, err := s.c.CreateRouteDryRun(app.ID, (&router.HTTPRoute{Service: "foo", Domain: "dry-run.example.com", Path: "/api/"}).ToRoute())
+	c.Assert(err, IsNil)
+	c.Assert(res.Previous, IsNil)
+	c.Assert(res.Conflicts, HasLen, 1)
+	c.Assert(res.Conflicts[0].Type, Equals, ct.RouteConflictDomain)
+	c.Assert(res.Conflicts[0].AppID, Equals, other.ID)
+	c.Assert(res.Conflicts[0].Route.ID, Equals, otherRoute.ID)
+	routes, err := s.c.AppRouteList(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(routes, HasLen, 1)
+
+	res, err = s.c.CreateRouteDryRun(other.ID, (&router.HTTPRoute{Service: "bar", Domain: "dry-run.example.com"}).ToRoute())
+	c.Assert(err, IsNil)
+	c.Assert(res.Conflicts, HasLen, 1)
+	c.Assert(res.Conflicts[0].Type, Equals, ct.RouteConflictDuplicate)
+	c.Assert(res.Conflicts[0].Route.ID, Equals, route.ID)
+
+	// updating a route reports the changes without updating it
+	updated := *route
+	updated.Service = "foo-canary"
+	updated.Sticky = true
+	res, err = s.c.UpdateRouteDryRun(app.ID, route.FormattedID(), &updated)
+	c.Assert(err, IsNil)
+	c.Assert(res.Previous.Service, Equals, "foo")
+	c.Assert(res.Changes, DeepEquals, []string{"service", "sticky"})
+	c.Assert(res.DrainsConnections, Equals, true)
+	gotRoute, err := s.c.GetRoute(app.ID, route.FormattedID())
+	c.Assert(err, IsNil)
+	c.Assert(gotRoute.Service, Equals, "foo")
+
+	// updating another app's route is not found
+	_, err = s.c.UpdateRouteDryRun(app.ID, otherRoute.FormattedID(), otherRoute)
+	c.Assert(err, Equals, controller.ErrNotFound)
+}
+
 func (s *S) TestListRoutes(c *C) {
 	app0 := s.createTestApp(c, &ct.App{Name: "list-route1"})
 	app1 := s.createTestApp(c, &ct.App{Name: "list-route2"})
@@ -6,7 +6,6 @@ import (
 
 	discoverd "github.com/flynn/flynn/discoverd/client"
 	"github.com/flynn/flynn/pkg/shutdown"
-	"github.com/flynn/flynn/pkg/stream"
 	"github.com/inconshreveable/log15"
 )
 
@@ -48,38 +47,12 @@ func (d *discoverdWrapper) Register() bool {
 	selfAddr := hb.Addr()
 	log = log.New("self.addr", selfAddr)
 
-	service := discoverd.NewService(serviceName)
-	var leaders chan *discoverd.Instance
-	var stream stream.Stream
-	connect := func() (err error) {
-		log.Info("connecting service leader stream")
-		leaders = make(chan *discoverd.Instance)
-		stream, err = service.Leaders(leaders)
-		if err != nil {
-			log.Error("error connecting service leader stream", "err", err)
-		}
-		return
-	}
-
+	leaders := make(chan *discoverd.Instance)
+	discoverd.FollowLeader(discoverd.NewService(serviceName), leaders)
 	go func() {
-		for {
-			for {
-				if err := connect(); err == nil {
-					break
-				}
-				time.Sleep(100 * time.Millisecond)
-			}
-			for leader := range leaders {
-				if leader == nil {
-					// a nil leader indicates there are no instances for
-					// the service, ignore and wait for an actual leader
-					log.Warn("received nil leader event")
-					continue
-				}
-				log.Info("received leader event", "leader.addr", leader.Addr)
-				d.leader <- leader.Addr == selfAddr
-			}
-			log.Warn("service leader stream disconnected", "err", stream.Err())
+		for leader := range leaders {
+			log.Info("received leader event", "leader.addr", leader.Addr)
+			d.leader <- leader.Addr == selfAddr
 		}
 	}()
 
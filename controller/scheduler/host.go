@@ -20,6 +20,7 @@ type Host struct {
 	Healthy  bool              `json:"healthy"`
 	Checks   int               `json:"checks"`
 	Shutdown bool              `json:"shutdown"`
+	Draining bool              `json:"draining"`
 
 	client   utils.HostClient
 	stop     chan struct{}
@@ -33,6 +34,7 @@ func NewHost(h utils.HostClient, l log15.Logger) *Host {
 	return &Host{
 		ID:       h.ID(),
 		Tags:     h.Tags(),
+		Draining: h.Draining(),
 		Healthy:  true,
 		client:   h,
 		stop:     make(chan struct{}),
@@ -150,8 +152,14 @@ func (h *Host) StreamVolumeEventsTo(ch chan *VolumeEvent) (map[string]*Volume, e
 
 // StreamJobEventsTo streams all job events from the host to the given channel
 // in a goroutine, returning the current list of active jobs.
-func (h *Host) StreamJobEventsTo(ch chan *host.Event) (map[string]host.ActiveJob, error) {
+//
+// Duplicate events are dropped, and resync is called if events may have been
+// missed, either because the stream reconnected or because the event sequence
+// numbers have a gap or restarted, so that the caller can reconcile its
+// state with the host.
+func (h *Host) StreamJobEventsTo(ch chan *host.Event, resync func()) (map[string]host.ActiveJob, error) {
 	log := h.logger.New("fn", "StreamJobEventsTo", "host.id", h.ID)
+	var seq jobEventSeq
 	var events chan *host.Event
 	var stream stream.Stream
 	connect := func() (err error) {
@@ -186,6 +194,14 @@ func (h *Host) StreamJobEventsTo(ch chan *host.Event) (map[string]host.ActiveJob
 					if !ok {
 						break eventLoop
 					}
+					switch seq.Next(event) {
+					case jobEventDuplicate:
+						log.Warn("dropping duplicate job event", "job.id", event.JobID, "event.seq", event.Seq)
+						continue
+					case jobEventGap:
+						log.Warn("job events missed, resyncing jobs", "job.id", event.JobID, "event.seq", event.Seq, "event.epoch", event.Epoch)
+						resync()
+					}
 					ch <- event
 
 					// if the host is a FakeHostClient with TestEventHook
@@ -213,11 +229,48 @@ func (h *Host) StreamJobEventsTo(ch chan *host.Event) (map[string]host.ActiveJob
 				}
 				time.Sleep(100 * time.Millisecond)
 			}
+
+			// events may have been sent while disconnected
+			log.Info("job event stream reconnected, resyncing jobs")
+			resync()
 		}
 	}()
 	return jobs, nil
 }
 
+type jobEventOrder int
+
+const (
+	jobEventInOrder jobEventOrder = iota
+	jobEventDuplicate
+	jobEventGap
+)
+
+// jobEventSeq tracks the sequence numbers of events from a host's job
+// stream. Events from hosts which don't number events are always in order.
+type jobEventSeq struct {
+	epoch string
+	last  uint64
+}
+
+// Next records the given event, returning whether it follows the previous
+// event, has already been seen, or follows a gap in the sequence.
+func (s *jobEventSeq) Next(e *host.Event) jobEventOrder {
+	if e.Seq == 0 {
+		return jobEventInOrder
+	}
+	if e.Epoch == s.epoch && e.Seq <= s.last {
+		return jobEventDuplicate
+	}
+	order := jobEventInOrder
+	if s.epoch != "" && (e.Epoch != s.epoch || e.Seq != s.last+1) {
+		order = jobEventGap
+	}
+	s.epoch = e.Epoch
+	s.last = e.Seq
+	return order
+}
+
 func (h *Host) GetSinks() ([]*ct.Sink, error) {
 	return h.client.GetSinks()
 }
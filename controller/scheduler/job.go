@@ -108,6 +108,17 @@ type Job struct {
 	// hostError is the error from the host if the job fails to start
 	hostError *string
 
+	// logTail is the job's final output if it crashed
+	logTail []string
+
+	// oomKilled is whether the host killed the job for exceeding its
+	// memory limit
+	oomKilled bool
+
+	// traceParent is the trace context of the scale request which caused
+	// the job to be started, such as that of a deploy, if any
+	traceParent string
+
 	serviceFirstSeen *time.Time
 }
 
@@ -130,6 +141,16 @@ func (j *Job) TagsMatchHost(host *Host) bool {
 	return true
 }
 
+// CanMigrate returns whether the job can be moved to another host when the
+// host it is running on drains, which is not the case for omni jobs or jobs
+// using volumes.
+func (j *Job) CanMigrate() bool {
+	if j.Formation == nil || j.Formation.Release == nil {
+		return false
+	}
+	return !j.Formation.Release.Processes[j.Type].Omni && len(j.VolumeRequests()) == 0
+}
+
 func (j *Job) VolumeRequests() []ct.VolumeReq {
 	proc := j.Formation.Release.Processes[j.Type]
 	if len(proc.Volumes) > 0 {
@@ -171,6 +192,8 @@ func (j *Job) ControllerJob() *ct.Job {
 		HostError: j.hostError,
 		RunAt:     j.RunAt,
 		Args:      j.Args,
+		LogTail:   j.logTail,
+		OOMKilled: j.oomKilled,
 	}
 
 	switch j.State {
@@ -186,6 +209,9 @@ func (j *Job) ControllerJob() *ct.Job {
 		job.State = ct.JobStateStopping
 	case JobStateStopped:
 		job.State = ct.JobStateDown
+		// a job with a service which was started but never registered
+		// with discoverd did not pass its health check
+		job.HealthCheckFailed = j.Service() != "" && j.serviceFirstSeen == nil && !j.StartedAt.IsZero()
 	}
 
 	job.VolumeIDs = make([]string, len(j.Volumes))
@@ -238,6 +264,18 @@ func (j Jobs) GetHostJobCounts(key utils.FormationKey, typ string) map[string]in
 	return counts
 }
 
+// GetActiveHostJobCounts returns the number of pending, starting and running
+// jobs placed on each host across all formations.
+func (j Jobs) GetActiveHostJobCounts() map[string]int {
+	counts := make(map[string]int)
+	for _, job := range j {
+		if job.HostID != "" && (job.IsRunning() || job.State == JobStatePending) {
+			counts[job.HostID]++
+		}
+	}
+	return counts
+}
+
 func (js Jobs) GetProcesses(key utils.FormationKey) Processes {
 	procs := make(Processes)
 	for _, j := range js {
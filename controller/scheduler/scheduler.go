@@ -13,6 +13,7 @@ import (
 	"time"
 
 	controller "github.com/flynn/flynn/controller/client"
+	"github.com/flynn/flynn/controller/secrets"
 	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/flynn/controller/utils"
 	discoverd "github.com/flynn/flynn/discoverd/client"
@@ -20,14 +21,17 @@ import (
 	"github.com/flynn/flynn/host/volume"
 	"github.com/flynn/flynn/pkg/attempt"
 	"github.com/flynn/flynn/pkg/cluster"
+	"github.com/flynn/flynn/pkg/ctxhelper"
 	"github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/random"
 	"github.com/flynn/flynn/pkg/shutdown"
 	"github.com/flynn/flynn/pkg/status"
 	"github.com/flynn/flynn/pkg/stream"
+	"github.com/flynn/flynn/pkg/tracing"
 	"github.com/flynn/flynn/pkg/typeconv"
 	"github.com/flynn/flynn/router/types"
 	"github.com/inconshreveable/log15"
+	"golang.org/x/net/context"
 )
 
 const (
@@ -107,6 +111,14 @@ type Scheduler struct {
 	generateJobUUID func() string
 
 	routerBackends map[string]*RouterBackend
+
+	// placementStrategy is the cluster's default placement strategy, which
+	// apps can override (spread if empty)
+	placementStrategy string
+
+	// binpackMaxJobs is the number of active jobs after which the binpack
+	// strategy stops placing jobs on a host (unlimited if zero)
+	binpackMaxJobs int
 }
 
 func NewScheduler(cluster utils.ClusterClient, cc utils.ControllerClient, disc Discoverd, l log15.Logger) *Scheduler {
@@ -158,6 +170,10 @@ func main() {
 	logger.SetHandler(log15.LvlFilterHandler(log15.LvlInfo, log15.StdoutHandler))
 	log := logger.New("fn", "main")
 
+	if tracing.Init("controller-scheduler") {
+		shutdown.BeforeExit(tracing.Flush)
+	}
+
 	// Use a low timeout for HTTP requests to avoid blocking the main loop.
 	//
 	// TODO: make all HTTP calls asynchronous
@@ -179,7 +195,20 @@ func main() {
 	}
 
 	s := NewScheduler(clusterClient, controllerClient, newDiscoverdWrapper(logger), logger)
-	log.Info("started scheduler")
+	if strategy := os.Getenv("PLACEMENT_STRATEGY"); strategy != "" {
+		if !ct.ValidPlacementStrategy(strategy) {
+			shutdown.Fatalf("invalid PLACEMENT_STRATEGY %q, must be %q or %q", strategy, ct.PlacementStrategySpread, ct.PlacementStrategyBinpack)
+		}
+		s.placementStrategy = strategy
+	}
+	if max := os.Getenv("PLACEMENT_BINPACK_MAX_JOBS"); max != "" {
+		n, err := strconv.Atoi(max)
+		if err != nil || n < 0 {
+			shutdown.Fatalf("invalid PLACEMENT_BINPACK_MAX_JOBS %q", max)
+		}
+		s.binpackMaxJobs = n
+	}
+	log.Info("started scheduler", "placement_strategy", s.jobPlacementStrategy(&Job{}), "binpack_max_jobs", s.binpackMaxJobs)
 
 	go s.startHTTPServer(os.Getenv("PORT"))
 
@@ -434,6 +463,12 @@ func (s *Scheduler) streamSinkEvents() error {
 			if event.ID == "" {
 				continue
 			}
+			// the initial list of sinks is newest first, so only
+			// move since forward to avoid streaming them all again
+			// when reconnecting
+			if event.UpdatedAt != nil && (since == nil || event.UpdatedAt.After(*since)) {
+				since = event.UpdatedAt
+			}
 			s.sinkEvents <- event
 		}
 		log.Warn("log sink event stream disconnected", "err", stream.Err())
@@ -903,6 +938,9 @@ func (s *Scheduler) RectifyFormation(key utils.FormationKey) {
 	// them on hosts with matching tags
 	s.stopJobsWithMismatchedTags(formation)
 
+	// stop jobs on draining hosts so they are rescheduled on other hosts
+	s.stopJobsOnDrainingHosts(formation)
+
 	// if there is a pending scale request, mark it as complete if the
 	// formation has the correct number of running jobs
 	if req := formation.PendingScaleRequest; req != nil && req.State == ct.ScaleRequestStatePending {
@@ -997,6 +1035,23 @@ func (s *Scheduler) stopJobsWithMismatchedTags(formation *Formation) {
 	}
 }
 
+// stopJobsOnDrainingHosts stops any running jobs of the formation which are
+// running on a draining host and can be moved to other hosts
+func (s *Scheduler) stopJobsOnDrainingHosts(formation *Formation) {
+	log := s.logger.New("fn", "stopJobsOnDrainingHosts")
+	for _, job := range s.jobs {
+		if !job.IsInFormation(formation.key()) || !job.IsRunning() {
+			continue
+		}
+		host, ok := s.hosts[job.HostID]
+		if !ok || !host.Draining || !job.CanMigrate() {
+			continue
+		}
+		log.Info("job is running on a draining host, stopping", "job.id", job.ID, "host.id", host.ID)
+		s.stopJob(job)
+	}
+}
+
 // maybeStartBlockedJobs starts any jobs which are blocked due to not
 // matching tags of any hosts on the given host, which is expected to be
 // either a new host or a host whose tags have just changed
@@ -1204,28 +1259,14 @@ func (s *Scheduler) HandlePlacementRequest(req *PlacementRequest) {
 		}
 	}
 
-	// if we didn't pick a host for the job's volumes, pick a host with
-	// the least amount of jobs running of the given type
+	// if we didn't pick a host for the job's volumes, pick one using the
+	// placement strategy
 	if req.Host == nil {
-		formation := req.Job.Formation
-		counts := s.jobs.GetHostJobCounts(formation.key(), req.Job.Type)
-		var minCount int = math.MaxInt32
-		for _, h := range s.ShuffledHosts() {
-			if h.Shutdown {
-				continue
-			}
-			if !req.Job.TagsMatchHost(h) {
-				continue
-			}
-			count, ok := counts[h.ID]
-			if !ok || count == 0 {
-				req.Host = h
-				break
-			}
-			if count < minCount {
-				minCount = count
-				req.Host = h
-			}
+		strategy := s.jobPlacementStrategy(req.Job)
+		if strategy == ct.PlacementStrategyBinpack {
+			req.Host = s.findBinpackHost(req.Job)
+		} else {
+			req.Host = s.findSpreadHost(req.Job)
 		}
 
 		// if we still didn't pick a host, the job's tags don't match
@@ -1238,9 +1279,12 @@ func (s *Scheduler) HandlePlacementRequest(req *PlacementRequest) {
 			return
 		}
 
-		if len(req.Job.Tags()) == 0 {
+		switch {
+		case strategy == ct.PlacementStrategyBinpack:
+			log.Info("placed job on host with matching tags and most jobs", "host.id", req.Host.ID, "host.tags", req.Host.Tags)
+		case len(req.Job.Tags()) == 0:
 			log.Info(fmt.Sprintf("placed job on host with least %s jobs", req.Job.Type), "host.id", req.Host.ID)
-		} else {
+		default:
 			log.Info(fmt.Sprintf("placed job on host with matching tags and least %s jobs", req.Job.Type), "host.id", req.Host.ID, "host.tags", req.Host.Tags)
 		}
 	}
@@ -1256,6 +1300,74 @@ func (s *Scheduler) HandlePlacementRequest(req *PlacementRequest) {
 	req.Error(nil)
 }
 
+// jobPlacementStrategy returns the placement strategy for the job, which is
+// the app's strategy if it sets one, otherwise the cluster's. Omni jobs are
+// always spread so that each host runs the same number of them.
+func (s *Scheduler) jobPlacementStrategy(job *Job) string {
+	if f := job.Formation; f != nil && f.Release != nil && f.Release.Processes[job.Type].Omni {
+		return ct.PlacementStrategySpread
+	}
+	if f := job.Formation; f != nil && f.App != nil {
+		if strategy := f.App.PlacementStrategy(); strategy != "" {
+			return strategy
+		}
+	}
+	if s.placementStrategy != "" {
+		return s.placementStrategy
+	}
+	return ct.PlacementStrategySpread
+}
+
+// findSpreadHost returns a host matching the job's tags with the least
+// amount of jobs running of the job's formation and type.
+func (s *Scheduler) findSpreadHost(job *Job) *Host {
+	counts := s.jobs.GetHostJobCounts(job.Formation.key(), job.Type)
+	var host *Host
+	var minCount int = math.MaxInt32
+	for _, h := range s.ShuffledHosts() {
+		if h.Shutdown || h.Draining && job.CanMigrate() {
+			continue
+		}
+		if !job.TagsMatchHost(h) {
+			continue
+		}
+		count, ok := counts[h.ID]
+		if !ok || count == 0 {
+			return h
+		}
+		if count < minCount {
+			minCount = count
+			host = h
+		}
+	}
+	return host
+}
+
+// findBinpackHost returns a host matching the job's tags with the most
+// active jobs, skipping hosts which have reached binpackMaxJobs if set.
+func (s *Scheduler) findBinpackHost(job *Job) *Host {
+	counts := s.jobs.GetActiveHostJobCounts()
+	var host *Host
+	maxCount := -1
+	for _, h := range s.ShuffledHosts() {
+		if h.Shutdown || h.Draining && job.CanMigrate() {
+			continue
+		}
+		if !job.TagsMatchHost(h) {
+			continue
+		}
+		count := counts[h.ID]
+		if s.binpackMaxJobs > 0 && count >= s.binpackMaxJobs {
+			continue
+		}
+		if count > maxCount {
+			maxCount = count
+			host = h
+		}
+	}
+	return host
+}
+
 type InternalState struct {
 	JobID      string                `json:"job_id"`
 	Hosts      map[string]*Host      `json:"hosts"`
@@ -1552,6 +1664,9 @@ func (s *Scheduler) handleFormationDiff(f *Formation, diff Processes) {
 					State:     JobStatePending,
 					Args:      f.Release.Processes[typ].Args,
 				}
+				if req := f.PendingScaleRequest; req != nil {
+					job.traceParent = req.TraceParent
+				}
 				s.jobs.Add(job)
 
 				// persist the job so that it appears as pending in the database
@@ -1581,11 +1696,33 @@ func (s *Scheduler) activeFormationCount(appID string) int {
 }
 
 func (s *Scheduler) StartJob(job *Job) {
-	log := s.logger.New("fn", "StartJob", "app.id", job.AppID, "release.id", job.ReleaseID, "job.id", job.ID, "job.type", job.Type)
+	// send a request ID with the requests made to hosts to start the job
+	// so that they can be found in the host logs
+	reqID := random.UUID()
+	ctx := ctxhelper.NewContextRequestID(context.Background(), reqID)
+
+	log := s.logger.New("fn", "StartJob", "app.id", job.AppID, "release.id", job.ReleaseID, "job.id", job.ID, "job.type", job.Type, "request.id", reqID)
 	log.Info("starting job")
 
+	// trace the job launch as part of the trace of the scale request
+	// which caused it, such as a deploy, and propagate the span to the
+	// requests made to hosts
+	parent, _ := tracing.ParseTraceparent(job.traceParent)
+	span := tracing.Start("scheduler.start_job", parent)
+	span.SetAttr("app.id", job.AppID)
+	span.SetAttr("release.id", job.ReleaseID)
+	span.SetAttr("job.id", job.ID)
+	span.SetAttr("job.type", job.Type)
+	defer span.End()
+	ctx = tracing.ContextWithSpan(ctx, span)
+
+	// start is used to record the time taken to schedule the job,
+	// including any failed attempts
+	start := time.Now()
+
 outer:
 	for attempt := 0; ; attempt++ {
+		span.SetAttr("attempts", attempt+1)
 		if attempt > 0 {
 			// when making multiple attempts, backoff in increments
 			// of 500ms (capped at 30s)
@@ -1616,10 +1753,12 @@ outer:
 			continue
 		}
 
+		placed := time.Now()
+		client := utils.HostClientWithContext(ctx, req.Host.client)
 		for _, vol := range job.Volumes {
 			if vol.GetState() == ct.VolumeStatePending {
 				log.Info("creating new volume", "host.id", req.Host.ID, "vol.id", vol.ID, "vol.path", vol.Path)
-				if err := req.Host.client.CreateVolume("default", vol.Info()); err != nil {
+				if err := client.CreateVolume("default", vol.Info()); err != nil {
 					log.Error("error creating new volume", "vol.id", vol.ID, "err", err)
 					continue outer
 				}
@@ -1627,12 +1766,51 @@ outer:
 				s.persistVolume(vol)
 			}
 		}
+		req.Config.StartPhases = []host.JobStartPhase{
+			{Name: host.JobStartPhaseSchedule, Duration: placed.Sub(start)},
+			{Name: host.JobStartPhaseVolumes, Duration: time.Since(placed)},
+		}
+
+		if utils.HasResourceEnvRefs(req.Config.Config.Env) {
+			log.Info("resolving resource env references")
+			resources, err := s.AppResourceList(job.AppID)
+			if err != nil {
+				log.Error("error listing app resources", "err", err)
+				continue
+			}
+			if err := utils.ResolveResourceEnv(req.Config.Config.Env, resources); err != nil {
+				// the resource may not have been provisioned yet, so
+				// keep retrying like other placement errors
+				log.Error("error resolving resource env references", "err", err)
+				continue
+			}
+		}
+
+		if secrets.HasEnvRefs(req.Config.Config.Env) {
+			log.Info("resolving secret env references")
+			env, err := s.ResolveSecretEnv(job.AppID, req.Config.ID, req.Config.Config.Env)
+			if err != nil {
+				// the secrets backend may be temporarily
+				// unavailable, so keep retrying
+				log.Error("error resolving secret env references", "err", err)
+				continue
+			}
+			req.Config.Config.Env = env
+		}
 
 		log.Info("adding job to the cluster", "host.id", req.Host.ID)
-		err = req.Host.client.AddJob(req.Config)
+		span.SetAttr("host.id", req.Host.ID)
+		if traceparent := tracing.Traceparent(span); traceparent != "" {
+			if req.Config.Metadata == nil {
+				req.Config.Metadata = make(map[string]string)
+			}
+			req.Config.Metadata[tracing.MetadataKey] = traceparent
+		}
+		err = client.AddJob(req.Config)
 		if err == nil {
 			return
 		}
+		span.SetError(err)
 		log.Error("error adding job to the cluster", "attempts", attempt+1, "err", err)
 	}
 }
@@ -1674,7 +1852,7 @@ func (s *Scheduler) followHost(h utils.HostClient) (*Host, error) {
 		s.volumes[id] = vol
 		s.persistVolume(vol)
 	}
-	jobs, err := host.StreamJobEventsTo(s.jobEvents)
+	jobs, err := host.StreamJobEventsTo(s.jobEvents, s.triggerSyncJobs)
 	if err != nil {
 		return nil, err
 	}
@@ -1728,6 +1906,7 @@ func (s *Scheduler) HandleHostEvent(e *discoverd.Event) {
 	case discoverd.EventKindUpdate:
 		id := e.Instance.Meta["id"]
 		_, isShutdown := e.Instance.Meta["shutdown"]
+		_, isDraining := e.Instance.Meta[host.DrainMetaKey]
 
 		// if we haven't seen this host before, handle it as new
 		// (provided it is not shutdown)
@@ -1768,6 +1947,18 @@ func (s *Scheduler) HandleHostEvent(e *discoverd.Event) {
 			s.rectifyAll()
 			s.maybeStartBlockedJobs(host)
 		}
+
+		// if the host has started or stopped draining, rectify all
+		// formations so that jobs are moved to other hosts, or may
+		// be placed on the host again
+		if isDraining != host.Draining {
+			log.Info("host draining changed", "host.id", id, "draining", isDraining)
+			host.Draining = isDraining
+			s.rectifyAll()
+			if !isDraining {
+				s.maybeStartBlockedJobs(host)
+			}
+		}
 	case discoverd.EventKindDown:
 		id := e.Instance.Meta["id"]
 		log = log.New("host.id", id)
@@ -2159,6 +2350,8 @@ func (s *Scheduler) handleActiveJob(activeJob *host.ActiveJob) *Job {
 	job.metadata = hostJob.Metadata
 	job.exitStatus = activeJob.ExitStatus
 	job.hostError = activeJob.Error
+	job.logTail = activeJob.LogTail
+	job.oomKilled = activeJob.OOMKilled
 
 	// if the host job is running but has a service, wait for either
 	// service or router events before marking the job as running
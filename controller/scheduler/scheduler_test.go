@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -765,6 +766,124 @@ func (TestSuite) TestJobPlacementTags(c *C) {
 	}
 }
 
+func (TestSuite) TestJobPlacementStrategy(c *C) {
+	newFormation := func(appID string, meta map[string]string) *Formation {
+		return NewFormation(&ct.ExpandedFormation{
+			App:       &ct.App{ID: appID, Meta: meta},
+			Release:   &ct.Release{ID: appID + "-release", Processes: map[string]ct.ProcessType{"web": {}}},
+			Artifacts: []*ct.Artifact{{}},
+		})
+	}
+	place := func(s *Scheduler, formation *Formation, count int) map[string]int {
+		hosts := make(map[string]int)
+		for i := 0; i < count; i++ {
+			job := s.jobs.Add(&Job{ID: fmt.Sprintf("job-%s-%d", formation.App.ID, i), Formation: formation, Type: "web", State: JobStatePending})
+			req := &PlacementRequest{Job: job, Err: make(chan error, 1)}
+			s.HandlePlacementRequest(req)
+			c.Assert(<-req.Err, IsNil)
+			hosts[req.Host.ID]++
+		}
+		return hosts
+	}
+	newScheduler := func(strategy string, maxJobs int) *Scheduler {
+		return &Scheduler{
+			isLeader: typeconv.BoolPtr(true),
+			jobs:     make(Jobs),
+			hosts: map[string]*Host{
+				"host1": {ID: "host1"},
+				"host2": {ID: "host2"},
+				"host3": {ID: "host3"},
+			},
+			logger:            log15.New(),
+			placementStrategy: strategy,
+			binpackMaxJobs:    maxJobs,
+		}
+	}
+
+	// spread is the default
+	s := newScheduler("", 0)
+	c.Assert(place(s, newFormation("app1", nil), 6), DeepEquals, map[string]int{"host1": 2, "host2": 2, "host3": 2})
+
+	// binpack places all jobs on one host
+	s = newScheduler(ct.PlacementStrategyBinpack, 0)
+	hosts := place(s, newFormation("app1", nil), 6)
+	c.Assert(hosts, HasLen, 1)
+
+	// and other apps' jobs on the same host
+	var packed string
+	for id := range hosts {
+		packed = id
+	}
+	c.Assert(place(s, newFormation("app2", nil), 2), DeepEquals, map[string]int{packed: 2})
+
+	// apps can override the cluster's strategy
+	spread := newFormation("app3", map[string]string{"flynn-placement-strategy": ct.PlacementStrategySpread})
+	c.Assert(place(s, spread, 3), DeepEquals, map[string]int{"host1": 1, "host2": 1, "host3": 1})
+	s = newScheduler("", 0)
+	binpack := newFormation("app4", map[string]string{"flynn-placement-strategy": ct.PlacementStrategyBinpack})
+	c.Assert(place(s, binpack, 4), HasLen, 1)
+
+	// binpack moves on to another host once one is full
+	s = newScheduler(ct.PlacementStrategyBinpack, 3)
+	hosts = place(s, newFormation("app1", nil), 7)
+	c.Assert(hosts, HasLen, 3)
+	counts := make([]int, 0, len(hosts))
+	for _, count := range hosts {
+		counts = append(counts, count)
+	}
+	sort.Ints(counts)
+	c.Assert(counts, DeepEquals, []int{1, 3, 3})
+}
+
+func (TestSuite) TestJobPlacementDrainingHost(c *C) {
+	formation := NewFormation(&ct.ExpandedFormation{
+		App: &ct.App{ID: "app"},
+		Release: &ct.Release{ID: "release", Processes: map[string]ct.ProcessType{
+			"web":  {},
+			"omni": {Omni: true},
+			"db":   {Volumes: []ct.VolumeReq{{Path: "/data"}}},
+		}},
+		Artifacts: []*ct.Artifact{{}},
+	})
+	for _, strategy := range []string{ct.PlacementStrategySpread, ct.PlacementStrategyBinpack} {
+		s := &Scheduler{
+			isLeader: typeconv.BoolPtr(true),
+			jobs:     make(Jobs),
+			volumes:  make(map[string]*Volume),
+			hosts: map[string]*Host{
+				"host1": {ID: "host1", Draining: true},
+				"host2": {ID: "host2"},
+			},
+			logger:            log15.New(),
+			placementStrategy: strategy,
+		}
+		place := func(typ string, i int) string {
+			job := s.jobs.Add(&Job{ID: fmt.Sprintf("job-%s-%d", typ, i), Formation: formation, Type: typ, State: JobStatePending})
+			req := &PlacementRequest{Job: job, Err: make(chan error, 1)}
+			s.HandlePlacementRequest(req)
+			c.Assert(<-req.Err, IsNil)
+			return req.Host.ID
+		}
+
+		// jobs which can migrate are not placed on draining hosts
+		for i := 0; i < 4; i++ {
+			c.Assert(place("web", i), Equals, "host2")
+		}
+
+		// omni jobs are still placed on draining hosts, and spread
+		// regardless of the placement strategy
+		hosts := make(map[string]int)
+		for i := 0; i < 4; i++ {
+			hosts[place("omni", i)]++
+		}
+		c.Assert(hosts, DeepEquals, map[string]int{"host1": 2, "host2": 2})
+	}
+
+	c.Assert((&Job{Formation: formation, Type: "web"}).CanMigrate(), Equals, true)
+	c.Assert((&Job{Formation: formation, Type: "omni"}).CanMigrate(), Equals, false)
+	c.Assert((&Job{Formation: formation, Type: "db"}).CanMigrate(), Equals, false)
+}
+
 func (TestSuite) TestScaleCriticalApp(c *C) {
 	s := runTestScheduler(c, nil, true)
 	defer s.Stop()
@@ -1122,3 +1241,25 @@ func (TestSuite) TestFindVolumeCrossRelease(c *C) {
 	got = s.findVolume(jobFor(testAppID, newReleaseID, "postgres"), &ct.VolumeReq{Path: "/data"})
 	c.Assert(got, IsNil)
 }
+
+func (TestSuite) TestJobEventSeq(c *C) {
+	var seq jobEventSeq
+	for _, t := range []struct {
+		desc     string
+		seq      uint64
+		epoch    string
+		expected jobEventOrder
+	}{
+		{"first event", 5, "a", jobEventInOrder},
+		{"next event", 6, "a", jobEventInOrder},
+		{"duplicate event", 6, "a", jobEventDuplicate},
+		{"old event", 4, "a", jobEventDuplicate},
+		{"unnumbered event", 0, "", jobEventInOrder},
+		{"missed event", 8, "a", jobEventGap},
+		{"next event after gap", 9, "a", jobEventInOrder},
+		{"restarted sequence", 1, "b", jobEventGap},
+		{"next event after restart", 2, "b", jobEventInOrder},
+	} {
+		c.Assert(seq.Next(&host.Event{Seq: t.seq, Epoch: t.epoch}), Equals, t.expected, Commentf(t.desc))
+	}
+}
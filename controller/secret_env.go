@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/cluster"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"golang.org/x/net/context"
+)
+
+// ResolveSecretEnv resolves the secret env references of a job which is
+// about to be started, returning the resolved env. It is used by the
+// scheduler so that only the controller needs access to secrets backends.
+func (c *controllerAPI) ResolveSecretEnv(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var secretReq ct.SecretEnvRequest
+	if err := httphelper.DecodeJSON(req, &secretReq); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	jobUUID, err := cluster.ExtractUUID(secretReq.JobID)
+	if err != nil {
+		httphelper.ValidationError(w, "job_id", "must be a valid job ID")
+		return
+	}
+	env := secretReq.Env
+	if env == nil {
+		env = make(map[string]string)
+	}
+	if err := c.resolveSecretEnv(c.getApp(ctx).ID, jobUUID, env); err != nil {
+		httphelper.ValidationError(w, "env", err.Error())
+		return
+	}
+	httphelper.JSON(w, 200, env)
+}
+
+// resolveSecretEnv resolves the secret env references of the given job in
+// place, storing the leases on the secrets so the worker can renew them
+// while the job runs and revoke them once it stops.
+func (c *controllerAPI) resolveSecretEnv(appID, jobUUID string, env map[string]string) error {
+	providers := c.config.secretProviders
+	leases, err := providers.Resolve(env)
+	if err != nil {
+		return err
+	}
+	if len(leases) == 0 {
+		return nil
+	}
+	for _, lease := range leases {
+		lease.AppID = appID
+		lease.JobID = jobUUID
+	}
+	if err := c.secretLeaseRepo.Add(leases); err != nil {
+		providers.Revoke(leases)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+// Package secrets resolves env values which refer to secrets stored in
+// external secrets backends (see ct.SecretEnvRefPrefix).
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+)
+
+// Secret is a secret read from a provider.
+type Secret struct {
+	// Data is the secret's key/value data
+	Data map[string]string
+
+	// LeaseID, LeaseDuration and Renewable describe the lease on the
+	// secret, with LeaseID empty if it is not leased (e.g. a static
+	// key/value secret)
+	LeaseID       string
+	LeaseDuration time.Duration
+	Renewable     bool
+}
+
+// Provider is a secrets backend.
+type Provider interface {
+	// Read returns the secret at the given path.
+	Read(path string) (*Secret, error)
+
+	// Renew extends a lease by increment, returning the lease's new
+	// duration.
+	Renew(leaseID string, increment time.Duration) (time.Duration, error)
+
+	// Revoke revokes a lease, invalidating the secret.
+	Revoke(leaseID string) error
+}
+
+// Providers maps the provider names used in secret env references to
+// providers.
+type Providers map[string]Provider
+
+// FromEnv returns the providers configured in the environment, which is a
+// Vault provider named "vault" if VAULT_ADDR is set, authenticating with
+// VAULT_TOKEN and using the VAULT_NAMESPACE namespace if set.
+func FromEnv() Providers {
+	providers := make(Providers)
+	if addr := os.Getenv("VAULT_ADDR"); addr != "" {
+		vault := NewVault(addr, os.Getenv("VAULT_TOKEN"))
+		vault.Namespace = os.Getenv("VAULT_NAMESPACE")
+		providers["vault"] = vault
+	}
+	return providers
+}
+
+// HasEnvRefs returns whether any env values refer to secrets.
+func HasEnvRefs(env map[string]string) bool {
+	for _, v := range env {
+		if strings.HasPrefix(v, ct.SecretEnvRefPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve replaces env values which refer to secrets with the current values
+// from their providers, returning the leases on the secrets which were read.
+// Each secret is only read once even if several env vars refer to it, and
+// if resolving fails any leases which were already acquired are revoked.
+func (p Providers) Resolve(env map[string]string) (leases []*ct.SecretLease, err error) {
+	defer func() {
+		if err != nil {
+			p.Revoke(leases)
+			leases = nil
+		}
+	}()
+	read := make(map[string]*Secret)
+	for k, v := range env {
+		name, path, key, ok, err := ct.ParseSecretEnvRef(v)
+		if err != nil {
+			return leases, fmt.Errorf("error resolving %s: %s", k, err)
+		} else if !ok {
+			continue
+		}
+		provider, ok := p[name]
+		if !ok {
+			return leases, fmt.Errorf("error resolving %s: unknown secrets provider %q", k, name)
+		}
+		secret, ok := read[name+"/"+path]
+		if !ok {
+			secret, err = provider.Read(path)
+			if err != nil {
+				return leases, fmt.Errorf("error resolving %s: %s", k, err)
+			}
+			read[name+"/"+path] = secret
+			if secret.LeaseID != "" {
+				expiresAt := time.Now().Add(secret.LeaseDuration)
+				leases = append(leases, &ct.SecretLease{
+					ID:        secret.LeaseID,
+					Provider:  name,
+					Renewable: secret.Renewable,
+					ExpiresAt: &expiresAt,
+				})
+			}
+		}
+		value, ok := secret.Data[key]
+		if !ok {
+			return leases, fmt.Errorf("error resolving %s: secret %s has no key %s", k, path, key)
+		}
+		env[k] = value
+	}
+	return leases, nil
+}
+
+// Revoke revokes the given leases, ignoring errors as leases expire anyway.
+func (p Providers) Revoke(leases []*ct.SecretLease) {
+	for _, lease := range leases {
+		if provider, ok := p[lease.Provider]; ok {
+			provider.Revoke(lease.ID)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package secrets
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeVault implements the parts of the Vault HTTP API used by the Vault
+// provider.
+type fakeVault struct {
+	mtx     sync.Mutex
+	reads   int
+	renewed map[string]int64
+	revoked []string
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if r.Header.Get("X-Vault-Token") != "s3cret" {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string][]string{"errors": {"permission denied"}})
+		return
+	}
+	switch r.URL.Path {
+	case "/v1/secret/data/app":
+		f.reads++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     map[string]interface{}{"password": "hunter2", "port": 5432},
+				"metadata": map[string]interface{}{"version": 1},
+			},
+		})
+	case "/v1/database/creds/app":
+		f.reads++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"lease_id":       "database/creds/app/abc",
+			"lease_duration": 300,
+			"renewable":      true,
+			"data":           map[string]interface{}{"username": "v-app", "password": "dynamic"},
+		})
+	case "/v1/sys/leases/renew":
+		var req struct {
+			LeaseID   string `json:"lease_id"`
+			Increment int64  `json:"increment"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		f.renewed[req.LeaseID] = req.Increment
+		json.NewEncoder(w).Encode(map[string]interface{}{"lease_id": req.LeaseID, "lease_duration": req.Increment, "renewable": true})
+	case "/v1/sys/leases/revoke":
+		var req struct {
+			LeaseID string `json:"lease_id"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		f.revoked = append(f.revoked, req.LeaseID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"errors":[]}`))
+	}
+}
+
+func newTestVault(t *testing.T) (*fakeVault, Providers) {
+	fake := &fakeVault{renewed: make(map[string]int64)}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	return fake, Providers{"vault": NewVault(srv.URL, "s3cret")}
+}
+
+func TestResolve(t *testing.T) {
+	fake, providers := newTestVault(t)
+	env := map[string]string{
+		"PLAIN":       "value",
+		"PASSWORD":    "secret://vault/secret/data/app#password",
+		"PORT":        "secret://vault/secret/data/app#port",
+		"DB_USER":     "secret://vault/database/creds/app#username",
+		"DB_PASSWORD": "secret://vault/database/creds/app#password",
+	}
+	if !HasEnvRefs(env) {
+		t.Fatal("expected env to have secret references")
+	}
+	leases, err := providers.Resolve(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]string{
+		"PLAIN":       "value",
+		"PASSWORD":    "hunter2",
+		"PORT":        "5432",
+		"DB_USER":     "v-app",
+		"DB_PASSWORD": "dynamic",
+	} {
+		if env[k] != v {
+			t.Fatalf("expected %s to be %q, got %q", k, v, env[k])
+		}
+	}
+	if HasEnvRefs(env) {
+		t.Fatal("expected all secret references to be resolved")
+	}
+	if fake.reads != 2 {
+		t.Fatalf("expected each secret to be read once, got %d reads", fake.reads)
+	}
+
+	// only the dynamic secret is leased
+	if len(leases) != 1 {
+		t.Fatalf("expected 1 lease, got %d", len(leases))
+	}
+	lease := leases[0]
+	if lease.ID != "database/creds/app/abc" || lease.Provider != "vault" || !lease.Renewable {
+		t.Fatalf("unexpected lease %+v", lease)
+	}
+	if d := time.Until(*lease.ExpiresAt); d <= 4*time.Minute || d > 5*time.Minute {
+		t.Fatalf("unexpected lease expiry in %s", d)
+	}
+
+	d, err := providers["vault"].Renew(lease.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != time.Hour || fake.renewed[lease.ID] != 3600 {
+		t.Fatalf("unexpected renewal %s %v", d, fake.renewed)
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	fake, providers := newTestVault(t)
+	for _, tt := range []struct {
+		value string
+		err   string
+	}{
+		{"secret://vault/secret/data/app", "expected secret://<provider>/<path>#<key>"},
+		{"secret://other/secret/data/app#password", `unknown secrets provider "other"`},
+		{"secret://vault/secret/data/missing#password", "vault: secret/data/missing not found"},
+		{"secret://vault/secret/data/app#missing", "secret secret/data/app has no key missing"},
+	} {
+		_, err := providers.Resolve(map[string]string{"KEY": tt.value})
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Fatalf("expected error containing %q resolving %s, got %v", tt.err, tt.value, err)
+		}
+	}
+
+	// leases are revoked if resolving fails
+	_, err := providers.Resolve(map[string]string{
+		"A": "secret://vault/database/creds/app#username",
+		"B": "secret://vault/database/creds/app#missing",
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if len(fake.revoked) != 1 || fake.revoked[0] != "database/creds/app/abc" {
+		t.Fatalf("expected lease to be revoked, got %v", fake.revoked)
+	}
+
+	vault := NewVault(providers["vault"].(*Vault).Addr, "wrong")
+	if _, err := vault.Read("secret/data/app"); err == nil || err.Error() != "vault: permission denied" {
+		t.Fatalf("expected permission denied, got %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Vault is a Provider which reads secrets from HashiCorp Vault using its
+// HTTP API. Both key/value secrets (version 1 and 2) and dynamic secrets
+// with leases, such as database credentials, are supported.
+type Vault struct {
+	Addr      string
+	Token     string
+	Namespace string
+
+	client *http.Client
+}
+
+// NewVault returns a Vault provider for the server at addr which
+// authenticates with the given token.
+func NewVault(addr, token string) *Vault {
+	return &Vault{
+		Addr:   strings.TrimSuffix(addr, "/"),
+		Token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// vaultResponse is the response to reading a secret or renewing a lease.
+type vaultResponse struct {
+	LeaseID       string                     `json:"lease_id"`
+	LeaseDuration int64                      `json:"lease_duration"`
+	Renewable     bool                       `json:"renewable"`
+	Data          map[string]json.RawMessage `json:"data"`
+}
+
+func (v *Vault) Read(path string) (*Secret, error) {
+	var res vaultResponse
+	if err := v.do("GET", "/v1/"+strings.TrimPrefix(path, "/"), nil, &res); err != nil {
+		return nil, err
+	}
+	data := res.Data
+	// key/value version 2 secrets nest the data under a data key
+	// alongside metadata
+	if raw, ok := data["data"]; ok {
+		if _, ok := data["metadata"]; ok {
+			data = nil
+			if err := json.Unmarshal(raw, &data); err != nil {
+				return nil, fmt.Errorf("vault: error decoding secret %s: %s", path, err)
+			}
+		}
+	}
+	secret := &Secret{
+		Data:          make(map[string]string, len(data)),
+		LeaseID:       res.LeaseID,
+		LeaseDuration: time.Duration(res.LeaseDuration) * time.Second,
+		Renewable:     res.Renewable,
+	}
+	for k, raw := range data {
+		// use strings as is and the JSON encoding of other values
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			s = string(raw)
+		}
+		secret.Data[k] = s
+	}
+	return secret, nil
+}
+
+func (v *Vault) Renew(leaseID string, increment time.Duration) (time.Duration, error) {
+	req := map[string]interface{}{
+		"lease_id":  leaseID,
+		"increment": int64(increment / time.Second),
+	}
+	var res vaultResponse
+	if err := v.do("PUT", "/v1/sys/leases/renew", req, &res); err != nil {
+		return 0, err
+	}
+	return time.Duration(res.LeaseDuration) * time.Second, nil
+}
+
+func (v *Vault) Revoke(leaseID string) error {
+	return v.do("PUT", "/v1/sys/leases/revoke", map[string]string{"lease_id": leaseID}, nil)
+}
+
+func (v *Vault) do(method, path string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, v.Addr+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("vault: %s not found", strings.TrimPrefix(path, "/v1/"))
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		var e struct {
+			Errors []string `json:"errors"`
+		}
+		json.NewDecoder(res.Body).Decode(&e)
+		if len(e.Errors) == 0 {
+			return fmt.Errorf("vault: unexpected status %d", res.StatusCode)
+		}
+		return fmt.Errorf("vault: %s", strings.Join(e.Errors, ", "))
+	}
+	if out == nil || res.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
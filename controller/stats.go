@@ -3,7 +3,9 @@ package main
 import (
 	"net/http"
 	"strings"
+	"time"
 
+	ct "github.com/flynn/flynn/controller/types"
 	host "github.com/flynn/flynn/host/types"
 	"github.com/flynn/flynn/pkg/httphelper"
 	"golang.org/x/net/context"
@@ -124,3 +126,43 @@ func (c *controllerAPI) GetAppJobsStatsEnriched(ctx context.Context, w http.Resp
 
 	httphelper.JSON(w, 200, result)
 }
+
+// defaultAppStatsWindows are how far back app stats are rolled up for each
+// resolution if the request doesn't set since
+var defaultAppStatsWindows = map[ct.AppStatsResolution]time.Duration{
+	ct.AppStatsResolutionHour: 24 * time.Hour,
+	ct.AppStatsResolutionDay:  30 * 24 * time.Hour,
+}
+
+// GetAppStats returns the usage of an app's jobs rolled up over each hour or
+// day from the snapshots recorded by the controller worker, so that usage can
+// be graphed without requesting stats from every host.
+func (c *controllerAPI) GetAppStats(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	app := c.getApp(ctx)
+
+	resolution := ct.AppStatsResolutionHour
+	if s := req.URL.Query().Get("resolution"); s != "" {
+		resolution = ct.AppStatsResolution(s)
+	}
+	window, ok := defaultAppStatsWindows[resolution]
+	if !ok {
+		httphelper.ValidationError(w, "resolution", "must be one of hour or day")
+		return
+	}
+	since := time.Now().Add(-window).Truncate(time.Hour)
+	if s := req.URL.Query().Get("since"); s != "" {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			httphelper.ValidationError(w, "since", "must be a valid RFC3339 timestamp")
+			return
+		}
+		since = t
+	}
+
+	rollup, err := c.appStatsRepo.Rollup(app.ID, resolution, since)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, rollup)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"time"
+
+	"github.com/flynn/flynn/controller/data"
+	ct "github.com/flynn/flynn/controller/types"
+	hh "github.com/flynn/flynn/pkg/httphelper"
+	. "github.com/flynn/go-check"
+)
+
+func (s *S) TestAppStats(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "app-stats"})
+	repo := data.NewAppStatsRepo(s.hc.db)
+
+	start := time.Now().Add(-2 * time.Hour).Truncate(time.Hour)
+	snapshot := func(job string, at time.Duration, cpu float64, memory, rx int64) *ct.AppStatsSnapshot {
+		return &ct.AppStatsSnapshot{
+			AppID:           app.ID,
+			JobID:           job,
+			ProcessType:     "web",
+			CPUUsagePercent: cpu,
+			MemoryBytes:     memory,
+			NetworkRxBytes:  rx,
+			NetworkTxBytes:  rx / 2,
+			CreatedAt:       start.Add(at),
+		}
+	}
+	c.Assert(repo.Add([]*ct.AppStatsSnapshot{
+		snapshot("job1", 0, 10, 100, 1000),
+		snapshot("job2", 0, 20, 200, 5000),
+	}), IsNil)
+	c.Assert(repo.Add([]*ct.AppStatsSnapshot{
+		snapshot("job1", 30*time.Minute, 30, 300, 3000),
+		snapshot("job2", 30*time.Minute, 40, 400, 6000),
+	}), IsNil)
+	c.Assert(repo.Add([]*ct.AppStatsSnapshot{
+		snapshot("job1", time.Hour, 10, 100, 4000),
+	}), IsNil)
+
+	// usage is totalled across jobs, then averaged over the snapshots of
+	// each hour, with network usage the bytes transferred in the hour
+	rollup, err := s.c.AppStats(app.ID, ct.AppStatsResolutionHour, start)
+	c.Assert(err, IsNil)
+	c.Assert(rollup.Points, HasLen, 2)
+	p := rollup.Points[0]
+	c.Assert(p.Time.Equal(start), Equals, true)
+	c.Assert(p.CPUUsagePercent, Equals, float64(50))
+	c.Assert(p.CPUUsagePercentMax, Equals, float64(70))
+	c.Assert(p.MemoryBytes, Equals, int64(500))
+	c.Assert(p.MemoryBytesMax, Equals, int64(700))
+	c.Assert(p.NetworkRxBytes, Equals, int64(3000))
+	c.Assert(p.NetworkTxBytes, Equals, int64(1500))
+	c.Assert(rollup.Points[1].NetworkRxBytes, Equals, int64(1000))
+
+	// daily rollups combine the hours
+	rollup, err = s.c.AppStats(app.ID, ct.AppStatsResolutionDay, start)
+	c.Assert(err, IsNil)
+	c.Assert(len(rollup.Points) > 0, Equals, true)
+
+	// unknown resolutions are rejected
+	_, err = s.c.AppStats(app.ID, "minute", start)
+	c.Assert(hh.IsValidationError(err), Equals, true)
+
+	// old snapshots are deleted
+	c.Assert(repo.DeleteBefore(time.Now()), IsNil)
+	rollup, err = s.c.AppStats(app.ID, ct.AppStatsResolutionHour, start)
+	c.Assert(err, IsNil)
+	c.Assert(rollup.Points, HasLen, 0)
+}
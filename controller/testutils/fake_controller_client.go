@@ -271,6 +271,14 @@ func (c *FakeControllerClient) StreamSinks(*time.Time, chan *ct.Sink) (stream.St
 	return nil, nil
 }
 
+func (c *FakeControllerClient) AppResourceList(appID string) ([]*ct.Resource, error) {
+	return nil, nil
+}
+
+func (c *FakeControllerClient) ResolveSecretEnv(appID, jobID string, env map[string]string) (map[string]string, error) {
+	return env, nil
+}
+
 func (c *FakeControllerClient) VolumeList() ([]*ct.Volume, error) {
 	return nil, nil
 }
@@ -10,6 +10,7 @@ import (
 	host "github.com/flynn/flynn/host/types"
 	"github.com/flynn/flynn/host/volume"
 	"github.com/flynn/flynn/pkg/cluster"
+	"github.com/flynn/flynn/pkg/installsource"
 	"github.com/flynn/flynn/pkg/random"
 	"github.com/flynn/flynn/pkg/stream"
 )
@@ -41,12 +42,17 @@ type FakeHostClient struct {
 	jobsMtx          sync.RWMutex
 	Healthy          bool
 	TestEventHook    chan struct{}
+	History          []*installsource.UpdateRecord
 }
 
 func (c *FakeHostClient) ID() string { return c.hostID }
 
 func (c *FakeHostClient) Tags() map[string]string { return nil }
 
+func (c *FakeHostClient) Profiles() []host.JobProfile { return nil }
+
+func (c *FakeHostClient) Draining() bool { return false }
+
 func (c *FakeHostClient) Addr() string { return "127.0.0.1:1113" }
 
 func (c *FakeHostClient) Attach(req *host.AttachReq, wait bool) (cluster.AttachClient, error) {
@@ -222,6 +228,44 @@ func (c *FakeHostClient) ListVolumes() ([]*volume.Info, error) {
 	return nil, nil
 }
 
+func (c *FakeHostClient) GetVolume(id string) (*volume.Info, error) {
+	info, ok := c.volumes[id]
+	if !ok {
+		return nil, cluster.ErrNotFound
+	}
+	return info, nil
+}
+
+func (c *FakeHostClient) CreateSnapshot(volumeID string) (*volume.Info, error) {
+	vol, ok := c.volumes[volumeID]
+	if !ok {
+		return nil, cluster.ErrNotFound
+	}
+	snap := &volume.Info{
+		ID:        random.UUID(),
+		Type:      vol.Type,
+		Meta:      map[string]string{volume.SnapshotOfMetaKey: volumeID},
+		CreatedAt: time.Now(),
+	}
+	c.volumes[snap.ID] = snap
+	return snap, nil
+}
+
+func (c *FakeHostClient) ForkVolume(snapID string, meta map[string]string) (*volume.Info, error) {
+	snap, ok := c.volumes[snapID]
+	if !ok {
+		return nil, cluster.ErrNotFound
+	}
+	vol := &volume.Info{
+		ID:        random.UUID(),
+		Type:      snap.Type,
+		Meta:      meta,
+		CreatedAt: time.Now(),
+	}
+	c.volumes[vol.ID] = vol
+	return vol, nil
+}
+
 func (c *FakeHostClient) StreamVolumes(ch chan *volume.Event) (stream.Stream, error) {
 	return stream.New(), nil
 }
@@ -233,6 +277,13 @@ func (c *FakeHostClient) GetStatus() (*host.HostStatus, error) {
 	return &host.HostStatus{ID: c.ID()}, nil
 }
 
+func (c *FakeHostClient) UpdateHistory() ([]*installsource.UpdateRecord, error) {
+	if !c.Healthy {
+		return nil, errors.New("unhealthy")
+	}
+	return c.History, nil
+}
+
 func (c *FakeHostClient) GetStats() (*host.HostResourceStats, error) {
 	if !c.Healthy {
 		return nil, errors.New("unhealthy")
@@ -14,6 +14,7 @@ import (
 	"github.com/flynn/flynn/host/resource"
 	host "github.com/flynn/flynn/host/types"
 	"github.com/flynn/flynn/host/volume"
+	"github.com/flynn/flynn/pkg/installsource"
 	"github.com/flynn/flynn/pkg/tlscert"
 	router "github.com/flynn/flynn/router/types"
 	"github.com/jtacoma/uritemplates"
@@ -73,6 +74,75 @@ func (a *App) RedisAppliance() bool {
 	return a.System() && strings.HasPrefix(a.Name, "redis-")
 }
 
+// AppCloneRequest is a request to create a copy of an app, for example to
+// run a review app for a branch.
+type AppCloneRequest struct {
+	// Name is the name of the new app, generated if not set
+	Name string `json:"name,omitempty"`
+
+	// Domain replaces the source app's default domain in the domains of
+	// cloned HTTP routes, and defaults to the new app's default domain.
+	// HTTP routes on other domains are not cloned.
+	Domain string `json:"domain,omitempty"`
+
+	// ExcludeEnv are keys of the source app's release env which are not
+	// copied to the new app
+	ExcludeEnv []string `json:"exclude_env,omitempty"`
+
+	// ProvisionResources provisions new resources from the providers of
+	// the source app's resources rather than sharing them with the new app
+	ProvisionResources bool `json:"provision_resources,omitempty"`
+}
+
+// AppClone is the result of cloning an app.
+type AppClone struct {
+	App       *App            `json:"app"`
+	Release   *Release        `json:"release,omitempty"`
+	Formation *Formation      `json:"formation,omitempty"`
+	Resources []*Resource     `json:"resources,omitempty"`
+	Routes    []*router.Route `json:"routes,omitempty"`
+}
+
+const (
+	// ReviewAppsMetaKey is the app meta key which, when set to "true",
+	// enables creating a review app for each branch pushed to the app
+	ReviewAppsMetaKey = "review_apps"
+
+	// ReviewAppTTLMetaKey is the app meta key setting how long review
+	// apps of the app live after their last push, as a duration (e.g. 72h)
+	ReviewAppTTLMetaKey = "review_apps.ttl"
+
+	// DefaultReviewAppTTL is how long review apps live after their last
+	// push if their parent app does not set a TTL
+	DefaultReviewAppTTL = 72 * time.Hour
+)
+
+// ReviewAppRequest is a request to create a review app for a branch, or to
+// extend its TTL if it already exists.
+type ReviewAppRequest struct {
+	Branch string `json:"branch"`
+
+	// TTL is how long in seconds the review app lives before it is
+	// deleted, defaulting to the parent app's TTL
+	TTL int32 `json:"ttl,omitempty"`
+}
+
+// ReviewApp is a temporary copy of an app which runs a branch pushed to it,
+// and is deleted along with its resources once it expires or the branch is
+// deleted.
+type ReviewApp struct {
+	AppID       string     `json:"app"`
+	AppName     string     `json:"app_name,omitempty"`
+	ParentAppID string     `json:"parent_app"`
+	Branch      string     `json:"branch"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+
+	// Routes are the routes of a newly created review app
+	Routes []*router.Route `json:"routes,omitempty"`
+}
+
 // Critical apps cannot be completely scaled down by the scheduler
 func (a *App) Critical() bool {
 	v, ok := a.Meta["flynn-system-critical"]
@@ -101,6 +171,40 @@ func (a *App) SetDeployBatchSize(size int) {
 	a.Meta["flynn-deploy-batch-size"] = strconv.Itoa(size)
 }
 
+// Placement strategies used by the scheduler to pick hosts for new jobs
+const (
+	// PlacementStrategySpread places jobs on the host running the fewest
+	// jobs of the same formation and process type to balance load and
+	// tolerate host failures, and is the default
+	PlacementStrategySpread = "spread"
+
+	// PlacementStrategyBinpack places jobs on the host already running the
+	// most jobs to consolidate them onto as few hosts as possible
+	PlacementStrategyBinpack = "binpack"
+)
+
+// ValidPlacementStrategy returns whether s is a known placement strategy.
+func ValidPlacementStrategy(s string) bool {
+	return s == PlacementStrategySpread || s == PlacementStrategyBinpack
+}
+
+// PlacementStrategy returns the placement strategy the app overrides the
+// cluster's strategy with, or an empty string if it doesn't set a valid one
+func (a *App) PlacementStrategy() string {
+	if v := a.Meta["flynn-placement-strategy"]; ValidPlacementStrategy(v) {
+		return v
+	}
+	return ""
+}
+
+// SetPlacementStrategy sets the placement strategy to use for the app's jobs
+func (a *App) SetPlacementStrategy(strategy string) {
+	if a.Meta == nil {
+		a.Meta = make(map[string]string)
+	}
+	a.Meta["flynn-placement-strategy"] = strategy
+}
+
 type ReleaseType string
 
 var (
@@ -139,6 +243,39 @@ func (r *Release) IsSirenia() bool {
 	return r.Env["SIRENIA_PROCESS"] != ""
 }
 
+// EnvDiff is the difference between the environment of two releases, for
+// example the current releases of a staging and a production app.
+type EnvDiff struct {
+	FromAppID     string       `json:"from_app,omitempty"`
+	FromReleaseID string       `json:"from_release,omitempty"`
+	ToAppID       string       `json:"to_app,omitempty"`
+	ToReleaseID   string       `json:"to_release,omitempty"`
+	Changes       []*EnvChange `json:"changes,omitempty"`
+}
+
+type EnvChangeType string
+
+const (
+	EnvChangeAdded   EnvChangeType = "added"
+	EnvChangeRemoved EnvChangeType = "removed"
+	EnvChangeChanged EnvChangeType = "changed"
+)
+
+// EnvChange is a variable which differs between two releases. Values of
+// variables which look like secrets are redacted.
+type EnvChange struct {
+	Type EnvChangeType `json:"type"`
+
+	// ProcessType is set for variables in a process type's env rather than
+	// the release's env
+	ProcessType string `json:"process_type,omitempty"`
+
+	Key      string `json:"key"`
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+	Redacted bool   `json:"redacted,omitempty"`
+}
+
 type ProcessType struct {
 	Args              []string           `json:"args,omitempty"`
 	Env               map[string]string  `json:"env,omitempty"`
@@ -192,6 +329,30 @@ type Volume struct {
 	DecommissionedAt *time.Time        `json:"decommissioned_at,omitempty"`
 }
 
+// ExpandedVolume is a volume along with its disk usage and snapshots, as
+// reported by the host the volume is on.
+type ExpandedVolume struct {
+	*Volume
+
+	Size      int64             `json:"size"`
+	Snapshots []*VolumeSnapshot `json:"snapshots"`
+}
+
+// VolumeSnapshot is a snapshot of a volume.
+type VolumeSnapshot struct {
+	ID        string    `json:"id"`
+	VolumeID  string    `json:"volume"`
+	HostID    string    `json:"host_id"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// VolumeRestoreRequest is a request to restore a volume from one of its
+// snapshots.
+type VolumeRestoreRequest struct {
+	SnapshotID string `json:"snapshot"`
+}
+
 type VolumeState string
 
 const (
@@ -292,6 +453,17 @@ type Job struct {
 	Restarts   *int32            `json:"restarts,omitempty"`
 	CreatedAt  *time.Time        `json:"created_at,omitempty"`
 	UpdatedAt  *time.Time        `json:"updated_at,omitempty"`
+
+	// LogTail is the last lines of output from a job which exited with a
+	// non-zero status
+	LogTail []string `json:"log_tail,omitempty"`
+
+	// OOMKilled and HealthCheckFailed describe why a job went down. The
+	// scheduler sets them when it marks the job down, and they are stored
+	// in the resulting job event rather than in the jobs table, so they are
+	// only set on jobs read from job events.
+	OOMKilled         bool `json:"oom_killed,omitempty"`
+	HealthCheckFailed bool `json:"health_check_failed,omitempty"`
 }
 
 type JobState string
@@ -311,6 +483,21 @@ const (
 	JobStateFailed  JobState = "failed"
 )
 
+// JobPlacement records which host a job ran on and when, as aggregated from
+// the job's events
+type JobPlacement struct {
+	JobID      string     `json:"job_id,omitempty"`
+	UUID       string     `json:"uuid"`
+	HostID     string     `json:"host_id"`
+	ReleaseID  string     `json:"release,omitempty"`
+	Type       string     `json:"type,omitempty"`
+	State      JobState   `json:"state,omitempty"`
+	ExitStatus *int32     `json:"exit_status,omitempty"`
+	HostError  *string    `json:"host_error,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	StoppedAt  *time.Time `json:"stopped_at,omitempty"`
+}
+
 type DomainMigration struct {
 	ID         string        `json:"id"`
 	OldTLSCert *tlscert.Cert `json:"old_tls_cert,omitempty"`
@@ -410,6 +597,22 @@ type Deployment struct {
 	DeployBatchSize *int                         `json:"deploy_batch_size,omitempty"`
 	CreatedAt       *time.Time                   `json:"created_at,omitempty"`
 	FinishedAt      *time.Time                   `json:"finished_at,omitempty"`
+
+	// OldReleaseUsage summarises the resource usage of the old release's
+	// jobs by process type, recorded when the deployment completes
+	OldReleaseUsage map[string]*ProcessUsage `json:"old_release_usage,omitempty"`
+}
+
+// ProcessUsage summarises the CPU and memory usage of the jobs of a process
+// type, as sampled by the hosts they ran on.
+type ProcessUsage struct {
+	Jobs             int     `json:"jobs"`
+	Samples          int     `json:"samples"`
+	CPUAvgPercent    float64 `json:"cpu_avg_percent"`
+	CPUPeakPercent   float64 `json:"cpu_peak_percent"`
+	MemoryAvgBytes   uint64  `json:"memory_avg_bytes"`
+	MemoryPeakBytes  uint64  `json:"memory_peak_bytes"`
+	MemoryLimitBytes uint64  `json:"memory_limit_bytes,omitempty"`
 }
 
 type ExpandedDeployment struct {
@@ -440,13 +643,80 @@ type DeploymentEvent struct {
 	JobType      string   `json:"job_type,omitempty"`
 	JobState     JobState `json:"job_state,omitempty"`
 	Error        string   `json:"error,omitempty"`
+
+	// JobFailures describes the jobs of the new release which went down
+	// during the deployment.
+	JobFailures []*DeploymentJobFailure `json:"job_failures,omitempty"`
 }
 
 func (e *DeploymentEvent) Err() error {
 	if e.Error == "" {
 		return nil
 	}
-	return errors.New(e.Error)
+	if len(e.JobFailures) == 0 {
+		return errors.New(e.Error)
+	}
+	msg := e.Error
+	for _, f := range e.JobFailures {
+		msg += "\n  " + f.String()
+	}
+	return errors.New(msg)
+}
+
+// DeploymentJobFailure is the structured reason a job started by a
+// deployment went down.
+type DeploymentJobFailure struct {
+	JobID             string `json:"job_id,omitempty"`
+	JobType           string `json:"job_type,omitempty"`
+	HostID            string `json:"host_id,omitempty"`
+	ExitStatus        *int32 `json:"exit_status,omitempty"`
+	HostError         string `json:"host_error,omitempty"`
+	OOMKilled         bool   `json:"oom_killed,omitempty"`
+	HealthCheckFailed bool   `json:"health_check_failed,omitempty"`
+}
+
+// NewDeploymentJobFailure returns the failure details of a down job.
+func NewDeploymentJobFailure(job *Job) *DeploymentJobFailure {
+	f := &DeploymentJobFailure{
+		JobID:             job.ID,
+		JobType:           job.Type,
+		HostID:            job.HostID,
+		ExitStatus:        job.ExitStatus,
+		OOMKilled:         job.OOMKilled,
+		HealthCheckFailed: job.HealthCheckFailed,
+	}
+	if f.JobID == "" {
+		f.JobID = job.UUID
+	}
+	if job.HostError != nil {
+		f.HostError = *job.HostError
+	}
+	return f
+}
+
+// Reason returns a human readable description of why the job went down.
+func (f *DeploymentJobFailure) Reason() string {
+	var reasons []string
+	if f.HostError != "" {
+		reasons = append(reasons, "failed to start: "+f.HostError)
+	}
+	if f.ExitStatus != nil {
+		reasons = append(reasons, fmt.Sprintf("exited with status %d", *f.ExitStatus))
+	}
+	if f.OOMKilled {
+		reasons = append(reasons, "killed for exceeding its memory limit")
+	}
+	if f.HealthCheckFailed {
+		reasons = append(reasons, "never passed its service health check")
+	}
+	if len(reasons) == 0 {
+		return "stopped"
+	}
+	return strings.Join(reasons, ", ")
+}
+
+func (f *DeploymentJobFailure) String() string {
+	return fmt.Sprintf("%s job %s on host %s: %s", f.JobType, f.JobID, f.HostID, f.Reason())
 }
 
 type Provider struct {
@@ -466,6 +736,74 @@ type Resource struct {
 	CreatedAt  *time.Time        `json:"created_at,omitempty"`
 }
 
+// ResourceEnvRefPrefix is the prefix of release env values which refer to an
+// env var of a resource, written as resource://<resource id>/<name>. They are
+// resolved when jobs are started, so resource credentials can be changed
+// without creating new releases for every app using them.
+const ResourceEnvRefPrefix = "resource://"
+
+// ParseResourceEnvRef parses an env value referring to a resource env var,
+// returning ok as false if the value is not a reference.
+func ParseResourceEnvRef(value string) (resourceID, name string, ok bool, err error) {
+	if !strings.HasPrefix(value, ResourceEnvRefPrefix) {
+		return "", "", false, nil
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, ResourceEnvRefPrefix), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", true, fmt.Errorf("invalid resource env reference %q, expected %s<resource id>/<name>", value, ResourceEnvRefPrefix)
+	}
+	return parts[0], parts[1], true, nil
+}
+
+// SecretEnvRefPrefix is the prefix of env values which refer to a secret
+// stored in an external secrets backend, written as
+// secret://<provider>/<path>#<key> (e.g.
+// secret://vault/secret/data/myapp#db_password). They are resolved by the
+// controller when jobs are started so secrets are never stored in releases.
+const SecretEnvRefPrefix = "secret://"
+
+// ParseSecretEnvRef parses an env value referring to a secret, returning ok
+// as false if the value is not a reference.
+func ParseSecretEnvRef(value string) (provider, path, key string, ok bool, err error) {
+	if !strings.HasPrefix(value, SecretEnvRefPrefix) {
+		return "", "", "", false, nil
+	}
+	ref := strings.TrimPrefix(value, SecretEnvRefPrefix)
+	i := strings.LastIndex(ref, "#")
+	if i == -1 {
+		return "", "", "", true, fmt.Errorf("invalid secret env reference %q, expected %s<provider>/<path>#<key>", value, SecretEnvRefPrefix)
+	}
+	key = ref[i+1:]
+	parts := strings.SplitN(ref[:i], "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" || key == "" {
+		return "", "", "", true, fmt.Errorf("invalid secret env reference %q, expected %s<provider>/<path>#<key>", value, SecretEnvRefPrefix)
+	}
+	return parts[0], parts[1], key, true, nil
+}
+
+// SecretLease is a lease on a secret which was resolved for a job. Leases
+// are renewed while the job is running and revoked once it stops.
+type SecretLease struct {
+	ID        string     `json:"id"`
+	Provider  string     `json:"provider"`
+	AppID     string     `json:"app,omitempty"`
+	JobID     string     `json:"job_id,omitempty"`
+	Renewable bool       `json:"renewable"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+
+	// JobState is the state of the job the lease is for, which is empty
+	// if the job has not yet been persisted
+	JobState JobState `json:"job_state,omitempty"`
+}
+
+// SecretEnvRequest is a request to resolve the secret env references of a
+// job which is about to be started.
+type SecretEnvRequest struct {
+	JobID string            `json:"job_id"`
+	Env   map[string]string `json:"env"`
+}
+
 type ResourceReq struct {
 	ProviderID string           `json:"-"`
 	Apps       []string         `json:"apps,omitempty"`
@@ -481,6 +819,23 @@ func (v ValidationError) Error() string {
 	return fmt.Sprintf("validation error: %s %s", v.Field, v.Message)
 }
 
+// ValidationErrors is returned when several fields of a request are
+// invalid, so that they can all be fixed at once.
+type ValidationErrors []ValidationError
+
+func (v ValidationErrors) Error() string {
+	return "validation errors: " + v.Message()
+}
+
+// Message returns each error's field and message, separated by semicolons.
+func (v ValidationErrors) Message() string {
+	msgs := make([]string, len(v))
+	for i, e := range v {
+		msgs[i] = strings.TrimSpace(e.Field + " " + e.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
 type NotFoundError struct {
 	Resource string `json:"field"`
 }
@@ -557,8 +912,14 @@ type ScaleRequest struct {
 	NewProcesses *map[string]int               `json:"new_processes,omitempty"`
 	OldTags      map[string]map[string]string  `json:"old_tags,omitempty"`
 	NewTags      *map[string]map[string]string `json:"new_tags,omitempty"`
-	CreatedAt    *time.Time                    `json:"created_at"`
-	UpdatedAt    *time.Time                    `json:"updated_at"`
+
+	// TraceParent is the W3C traceparent of the span which requested the
+	// scale, for example a deploy, which the scheduler uses as the parent
+	// of the spans of the jobs it starts
+	TraceParent string `json:"trace_parent,omitempty"`
+
+	CreatedAt *time.Time `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at"`
 }
 
 type ScaleRequestState string
@@ -583,6 +944,9 @@ type ScaleOptions struct {
 	NoWait               bool
 	ScaleRequestCallback func(*ScaleRequest)
 	JobEventCallback     func(*Job) error
+
+	// TraceParent is set as the TraceParent of the scale request
+	TraceParent string
 }
 
 var DefaultScaleTimeout = 30 * time.Second
@@ -705,6 +1069,28 @@ func (m *ImageManifest) DefaultEntrypoint() *ImageEntrypoint {
 	return m.Entrypoints["_default"]
 }
 
+// RootfsForArch returns the rootfs entries for the given architecture (e.g.
+// runtime.GOARCH) of a multi-architecture image, treating entries without a
+// platform as DefaultImagePlatform. Images with a single rootfs entry are
+// returned as is, they predate multi-architecture images and are built for
+// the architecture of the cluster which built them.
+func (m *ImageManifest) RootfsForArch(arch string) []*ImageRootfs {
+	if len(m.Rootfs) <= 1 {
+		return m.Rootfs
+	}
+	var rootfs []*ImageRootfs
+	for _, r := range m.Rootfs {
+		platform := r.Platform
+		if platform == nil {
+			platform = DefaultImagePlatform
+		}
+		if platform.Architecture == arch {
+			rootfs = append(rootfs, r)
+		}
+	}
+	return rootfs
+}
+
 type ImageEntrypoint struct {
 	Env               map[string]string `json:"env,omitempty"`
 	WorkingDir        string            `json:"cwd,omitempty"`
@@ -792,6 +1178,64 @@ type LogAggregatorSinkConfig struct {
 	Addr string `json:"addr"`
 }
 
+// EventSinkFormat is the format of the payloads an EventSink delivers.
+type EventSinkFormat string
+
+const (
+	// EventSinkFormatJSON delivers events as JSON documents
+	EventSinkFormatJSON EventSinkFormat = "json"
+
+	// EventSinkFormatSlack delivers a Slack-compatible message with a
+	// human readable summary of each event
+	EventSinkFormatSlack EventSinkFormat = "slack"
+)
+
+// DefaultEventSinkTypes are the event types forwarded by an EventSink which
+// does not specify any.
+var DefaultEventSinkTypes = []EventType{
+	EventTypeDeployment,
+	EventTypeScaleRequest,
+	EventTypeRoute,
+	EventTypeRouteDeletion,
+	EventTypeManagedCertificate,
+}
+
+// EventSink forwards cluster-wide controller events to an HTTPS endpoint.
+type EventSink struct {
+	ID     string          `json:"id,omitempty"`
+	URL    string          `json:"url"`
+	Format EventSinkFormat `json:"format,omitempty"`
+
+	// EventTypes are the types of events to forward, defaulting to
+	// DefaultEventSinkTypes
+	EventTypes []EventType `json:"event_types,omitempty"`
+
+	// LastEventID is the ID of the last event which was forwarded or
+	// skipped
+	LastEventID int64 `json:"last_event_id,omitempty"`
+
+	// LastError is the error from the last failed delivery, cleared once
+	// a delivery succeeds
+	LastError string `json:"last_error,omitempty"`
+
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// EventSinkPayload is the JSON document delivered by an EventSink with the
+// json format.
+type EventSinkPayload struct {
+	ID         int64           `json:"id"`
+	AppID      string          `json:"app,omitempty"`
+	AppName    string          `json:"app_name,omitempty"`
+	ObjectType EventType       `json:"object_type"`
+	ObjectID   string          `json:"object_id,omitempty"`
+	Op         EventOp         `json:"op,omitempty"`
+	Summary    string          `json:"summary"`
+	Data       json.RawMessage `json:"data,omitempty"`
+	CreatedAt  *time.Time      `json:"created_at,omitempty"`
+}
+
 type LabelFilter []*LabelFilterExpression
 
 type LabelFilterExpressionOp int
@@ -849,6 +1293,14 @@ type ManagedCertificate struct {
 	LastError *string `json:"last_error,omitempty"`
 	// LastErrorAt is when the last error occurred
 	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	// RetryAfter, if set, is when the certificate may next be ordered
+	// after an order was rate limited by the ACME CA
+	RetryAfter *time.Time `json:"retry_after,omitempty"`
+	// OCSPResponse is the DER-encoded OCSP response for the issued
+	// certificate, which the router staples to TLS handshakes
+	OCSPResponse []byte `json:"ocsp_response,omitempty"`
+	// OCSPNextUpdate is when the OCSP response should next be refreshed
+	OCSPNextUpdate *time.Time `json:"ocsp_next_update,omitempty"`
 	// OrderURL is the URL of the ACME order for this certificate
 	OrderURL string `json:"order_url,omitempty"`
 	// Errors contains any errors encountered during issuance (deprecated, use LastError)
@@ -869,6 +1321,86 @@ func (m *ManagedCertificate) AddError(errType, detail string) {
 	})
 }
 
+// CertificateSummary describes a TLS certificate used by HTTP routes,
+// without its private key, for reporting which certificates are in use and
+// when they expire.
+type CertificateSummary struct {
+	// ID is the ID of the certificate, empty for managed certificates
+	// which have not been issued yet
+	ID string `json:"id,omitempty"`
+	// ManagedCertificateID is set for certificates provisioned via ACME
+	ManagedCertificateID string `json:"managed_certificate_id,omitempty"`
+	// Status is the issuance status of a managed certificate
+	Status ManagedCertificateStatus `json:"status,omitempty"`
+	// Domains are the names the certificate is valid for
+	Domains []string `json:"domains"`
+	// Issuer is the distinguished name of the certificate's issuer
+	Issuer string `json:"issuer,omitempty"`
+	// SHA256 is the hex encoded SHA-256 fingerprint of the certificate
+	SHA256 string `json:"sha256,omitempty"`
+	// NotBefore is when the certificate becomes valid
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	// ExpiresAt is when the certificate expires
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Routes are the routes using the certificate
+	Routes []*CertificateRoute `json:"routes,omitempty"`
+}
+
+// Managed returns whether the certificate is provisioned via ACME.
+func (c *CertificateSummary) Managed() bool {
+	return c.ManagedCertificateID != ""
+}
+
+// CertificateRoute is an HTTP route using a certificate.
+type CertificateRoute struct {
+	// ID is the formatted ID of the route, e.g. http/<uuid>
+	ID string `json:"id"`
+	// AppID is the ID of the app the route belongs to, if any
+	AppID  string `json:"app,omitempty"`
+	Domain string `json:"domain"`
+	Path   string `json:"path,omitempty"`
+}
+
+// RouteDryRun describes the impact of adding or updating a route, returned
+// instead of saving the route when the dry_run query parameter is set.
+type RouteDryRun struct {
+	// Route is the route as it would be saved
+	Route *router.Route `json:"route"`
+	// Previous is the current route, set when updating a route
+	Previous *router.Route `json:"previous,omitempty"`
+	// Changes are the names of the route fields which would change
+	Changes []string `json:"changes,omitempty"`
+	// Routers are the addresses of the router instances which would apply
+	// the change
+	Routers []string `json:"routers,omitempty"`
+	// DrainsConnections is set if existing connections to the route's
+	// backends would be drained, with the reason in DrainReason
+	DrainsConnections bool   `json:"drains_connections,omitempty"`
+	DrainReason       string `json:"drain_reason,omitempty"`
+	// Conflicts are the routes of other apps which share the route's
+	// domain or port
+	Conflicts []*RouteConflict `json:"conflicts,omitempty"`
+}
+
+type RouteConflictType string
+
+const (
+	// RouteConflictDuplicate means the route cannot be saved because a
+	// route with the same domain and path or port already exists
+	RouteConflictDuplicate RouteConflictType = "duplicate"
+	// RouteConflictDomain means another app routes a different path of
+	// the same domain
+	RouteConflictDomain RouteConflictType = "domain"
+)
+
+// RouteConflict is a route which conflicts with a route being added or
+// updated.
+type RouteConflict struct {
+	Type  RouteConflictType `json:"type"`
+	AppID string            `json:"app_id,omitempty"`
+	Route *router.Route     `json:"route"`
+}
+
 // ACMEConfig represents the global ACME/Let's Encrypt configuration for the cluster
 type ACMEConfig struct {
 	// Enabled indicates whether ACME/Let's Encrypt is enabled for the cluster
@@ -883,14 +1415,205 @@ type ACMEConfig struct {
 	AccountKey string `json:"account_key,omitempty"`
 	// HasAccountKey indicates whether an account key is configured (set by server, AccountKey is stripped for security)
 	HasAccountKey bool `json:"has_account_key,omitempty"`
+	// EABKeyID is the key identifier of the External Account Binding
+	// used to register the account with CAs which require one
+	EABKeyID string `json:"eab_key_id,omitempty"`
+	// EABHMACKey is the base64url-encoded HMAC key of the External Account Binding
+	EABHMACKey string `json:"eab_hmac_key,omitempty"`
+	// HasEABHMACKey indicates whether an EAB HMAC key is configured (set by server, EABHMACKey is stripped for security)
+	HasEABHMACKey bool `json:"has_eab_hmac_key,omitempty"`
+	// DNSWebhookURL is the URL of a webhook which publishes the TXT
+	// records of DNS-01 challenges, which CAs require to issue wildcard
+	// certificates. The ACME service POSTs {"fqdn": ..., "value": ...} to
+	// <url>/present before the challenge is validated and to
+	// <url>/cleanup afterwards.
+	DNSWebhookURL string `json:"dns_webhook_url,omitempty"`
+	// DNSWebhookToken, if set, is sent to the DNS webhook as a bearer token
+	DNSWebhookToken string `json:"dns_webhook_token,omitempty"`
+	// HasDNSWebhookToken indicates whether a DNS webhook token is configured (set by server, DNSWebhookToken is stripped for security)
+	HasDNSWebhookToken bool `json:"has_dns_webhook_token,omitempty"`
+	// WildcardDefaultDomain indicates whether the default routes of new
+	// apps share a single *.<default route domain> managed certificate
+	// rather than each ordering their own, which requires DNSWebhookURL
+	WildcardDefaultDomain bool `json:"wildcard_default_domain,omitempty"`
 	// CreatedAt is when the ACME configuration was created
 	CreatedAt *time.Time `json:"created_at,omitempty"`
 	// UpdatedAt is when the ACME configuration was last updated
 	UpdatedAt *time.Time `json:"updated_at,omitempty"`
 }
 
+// WildcardDomainCovers returns whether a wildcard domain such as
+// *.example.com covers domain, which must be a direct subdomain of the
+// wildcard's parent as wildcards only match a single label.
+func WildcardDomainCovers(wildcard, domain string) bool {
+	if !strings.HasPrefix(wildcard, "*.") {
+		return false
+	}
+	parent := strings.ToLower(wildcard[1:])
+	domain = strings.ToLower(domain)
+	if !strings.HasSuffix(domain, parent) {
+		return false
+	}
+	label := strings.TrimSuffix(domain, parent)
+	return label != "" && !strings.Contains(label, ".") && label != "*"
+}
+
+// ACMEChallenge is a pending HTTP-01 challenge response, stored in the
+// controller so that any instance of the ACME service can respond to it
+type ACMEChallenge struct {
+	// Token is the challenge token from the ACME CA
+	Token string `json:"token"`
+	// KeyAuthorization is the response served for the token
+	KeyAuthorization string `json:"key_authorization"`
+	// CreatedAt is when the challenge was stored
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
 // ErrACMENotEnabled is returned when ACME is required but not enabled
 var ErrACMENotEnabled = &ValidationError{
 	Field:   "acme",
 	Message: "ACME/Let's Encrypt is not enabled. Run 'flynn-host acme enable' to enable it.",
 }
+
+// HostInfo represents basic information about a host in the cluster
+type HostInfo struct {
+	ID   string            `json:"id"`
+	Tags map[string]string `json:"tags"`
+	Addr string            `json:"addr"`
+}
+
+// EnrichedContainerStats extends ContainerStats with job metadata
+type EnrichedContainerStats struct {
+	*host.ContainerStats
+	HostID      string `json:"host_id"`
+	AppID       string `json:"app_id,omitempty"`
+	AppName     string `json:"app_name,omitempty"`
+	ReleaseID   string `json:"release_id,omitempty"`
+	ProcessType string `json:"process_type,omitempty"`
+}
+
+// AppStatsSnapshot is a sample of the resource usage of a job, which the
+// controller worker records periodically so that app usage can be graphed
+// over longer periods than hosts keep stats for.
+type AppStatsSnapshot struct {
+	AppID           string    `json:"app"`
+	JobID           string    `json:"job"`
+	ProcessType     string    `json:"process_type,omitempty"`
+	CPUUsagePercent float64   `json:"cpu_usage_percent"`
+	MemoryBytes     int64     `json:"memory_bytes"`
+	NetworkRxBytes  int64     `json:"network_rx_bytes"`
+	NetworkTxBytes  int64     `json:"network_tx_bytes"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// AppStatsResolution is the period which app stats are rolled up over.
+type AppStatsResolution string
+
+const (
+	AppStatsResolutionHour AppStatsResolution = "hour"
+	AppStatsResolutionDay  AppStatsResolution = "day"
+)
+
+// AppStatsRollup is the resource usage of an app's jobs rolled up over
+// each period of the resolution, oldest first, with periods which have no
+// snapshots omitted.
+type AppStatsRollup struct {
+	AppID      string             `json:"app"`
+	Resolution AppStatsResolution `json:"resolution"`
+	Since      time.Time          `json:"since"`
+	Points     []*AppStatsPoint   `json:"points"`
+}
+
+// AppStatsPoint is the resource usage of an app's jobs over one period of
+// a rollup, with CPU and memory totalled across the jobs of each snapshot
+// and then averaged and maximised across the snapshots of the period.
+type AppStatsPoint struct {
+	Time               time.Time `json:"time"`
+	CPUUsagePercent    float64   `json:"cpu_usage_percent"`
+	CPUUsagePercentMax float64   `json:"cpu_usage_percent_max"`
+	MemoryBytes        int64     `json:"memory_bytes"`
+	MemoryBytesMax     int64     `json:"memory_bytes_max"`
+	// NetworkRxBytes and NetworkTxBytes are the bytes transferred
+	// during the period
+	NetworkRxBytes int64 `json:"network_rx_bytes"`
+	NetworkTxBytes int64 `json:"network_tx_bytes"`
+}
+
+// ClusterReport summarises the hosts, resources, apps, jobs and volumes of
+// a cluster for capacity planning and audits.
+type ClusterReport struct {
+	GeneratedAt time.Time `json:"generated_at"`
+
+	Hosts []*ClusterReportHost `json:"hosts"`
+
+	// Resources are the totals across the hosts which reported them
+	Resources ClusterReportResources `json:"resources"`
+
+	// Apps is the number of apps, and SystemApps the number of those
+	// which are system apps
+	Apps       int `json:"apps"`
+	SystemApps int `json:"system_apps"`
+
+	// Jobs is the number of jobs the controller knows about by state
+	Jobs map[JobState]int `json:"jobs"`
+
+	// Volumes is the number of volumes by state
+	Volumes map[VolumeState]int `json:"volumes"`
+
+	// Versions maps each flynn-host version to the IDs of the hosts
+	// running it, with Skew set when there is more than one
+	Versions map[string][]string `json:"versions"`
+	Skew     bool                `json:"version_skew"`
+}
+
+// UpdateHistory is the combined update history of a cluster's hosts.
+type UpdateHistory struct {
+	// Updates are the updates run from any host, oldest first
+	Updates []*installsource.UpdateRecord `json:"updates"`
+
+	// Errors maps the IDs of hosts whose history could not be read to
+	// the error
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// ClusterReportHost is the part of a ClusterReport for one host.
+type ClusterReportHost struct {
+	ID      string `json:"id"`
+	Addr    string `json:"addr"`
+	Version string `json:"version,omitempty"`
+
+	// Error is set if the host could not be queried, in which case only
+	// its ID and address are known
+	Error string `json:"error,omitempty"`
+
+	Resources ClusterReportResources `json:"resources"`
+
+	// Jobs is the number of active jobs on the host, and Volumes the
+	// number of volumes the controller has placed on it
+	Jobs    int `json:"jobs"`
+	Volumes int `json:"volumes"`
+}
+
+// ClusterReportResources are the total and allocated resources of a host or
+// cluster, with allocations being the memory requested and CPU limits of
+// active jobs.
+type ClusterReportResources struct {
+	CPUCount             int   `json:"cpu_count"`
+	AllocatedMilliCPU    int64 `json:"allocated_milli_cpu"`
+	MemoryTotalBytes     int64 `json:"memory_total_bytes"`
+	MemoryUsedBytes      int64 `json:"memory_used_bytes"`
+	AllocatedMemoryBytes int64 `json:"allocated_memory_bytes"`
+	DiskTotalBytes       int64 `json:"disk_total_bytes"`
+	DiskUsedBytes        int64 `json:"disk_used_bytes"`
+}
+
+// Add adds other to r.
+func (r *ClusterReportResources) Add(other ClusterReportResources) {
+	r.CPUCount += other.CPUCount
+	r.AllocatedMilliCPU += other.AllocatedMilliCPU
+	r.MemoryTotalBytes += other.MemoryTotalBytes
+	r.MemoryUsedBytes += other.MemoryUsedBytes
+	r.AllocatedMemoryBytes += other.AllocatedMemoryBytes
+	r.DiskTotalBytes += other.DiskTotalBytes
+	r.DiskUsedBytes += other.DiskUsedBytes
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/controller/utils"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/pkg/installsource"
+	"golang.org/x/net/context"
+)
+
+// GetUpdateHistory returns the updates run from any of the cluster's hosts
+func (c *controllerAPI) GetUpdateHistory(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	history, err := c.updateHistory()
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, history)
+}
+
+func (c *controllerAPI) updateHistory() (*ct.UpdateHistory, error) {
+	hosts, err := c.clusterClient.Hosts()
+	if err != nil {
+		return nil, err
+	}
+	results := make([][]*installsource.UpdateRecord, len(hosts))
+	errs := make([]error, len(hosts))
+	var wg sync.WaitGroup
+	for i, h := range hosts {
+		wg.Add(1)
+		go func(i int, h utils.HostClient) {
+			defer wg.Done()
+			results[i], errs[i] = h.UpdateHistory()
+		}(i, h)
+	}
+	wg.Wait()
+
+	// records are keyed by ID in case a host's config dir was copied
+	// from another host
+	history := &ct.UpdateHistory{Updates: []*installsource.UpdateRecord{}}
+	seen := make(map[string]struct{})
+	for i, h := range hosts {
+		if errs[i] != nil {
+			if history.Errors == nil {
+				history.Errors = make(map[string]string)
+			}
+			history.Errors[h.ID()] = errs[i].Error()
+			continue
+		}
+		for _, r := range results[i] {
+			if _, ok := seen[r.ID]; ok {
+				continue
+			}
+			seen[r.ID] = struct{}{}
+			history.Updates = append(history.Updates, r)
+		}
+	}
+	sort.SliceStable(history.Updates, func(i, j int) bool {
+		return history.Updates[i].StartedAt.Before(history.Updates[j].StartedAt)
+	})
+	return history, nil
+}
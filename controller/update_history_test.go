@@ -0,0 +1,40 @@
+package main
+
+import (
+	"time"
+
+	tu "github.com/flynn/flynn/controller/testutils"
+	"github.com/flynn/flynn/pkg/installsource"
+	. "github.com/flynn/go-check"
+)
+
+func (s *S) TestUpdateHistory(c *C) {
+	now := time.Now()
+	older := &installsource.UpdateRecord{ID: "update1", ToVersion: "v20260101.0", Result: installsource.ResultSuccess, StartedAt: now.Add(-time.Hour)}
+	newer := &installsource.UpdateRecord{ID: "update2", ToVersion: "v20260201.0", Result: installsource.ResultFailed, StartedAt: now}
+
+	hc1 := tu.NewFakeHostClient("history-host1", false)
+	hc1.History = []*installsource.UpdateRecord{newer}
+	s.cc.AddHost(hc1)
+	hc2 := tu.NewFakeHostClient("history-host2", false)
+	hc2.History = []*installsource.UpdateRecord{older, newer}
+	s.cc.AddHost(hc2)
+	unhealthy := tu.NewFakeHostClient("history-host3", false)
+	unhealthy.Healthy = false
+	s.cc.AddHost(unhealthy)
+	defer func() {
+		s.cc.RemoveHost(hc1.ID())
+		s.cc.RemoveHost(hc2.ID())
+		s.cc.RemoveHost(unhealthy.ID())
+	}()
+
+	// records from every host are combined, oldest first, with errors
+	// reported for unreachable hosts
+	history, err := s.c.UpdateHistory()
+	c.Assert(err, IsNil)
+	c.Assert(history.Updates, HasLen, 2)
+	c.Assert(history.Updates[0].ID, Equals, "update1")
+	c.Assert(history.Updates[1].ID, Equals, "update2")
+	c.Assert(history.Updates[1].Result, Equals, installsource.ResultFailed)
+	c.Assert(history.Errors["history-host3"], Not(Equals), "")
+}
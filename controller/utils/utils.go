@@ -3,6 +3,8 @@ package utils
 import (
 	"fmt"
 	"regexp"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -13,7 +15,9 @@ import (
 	"github.com/flynn/flynn/host/volume"
 	"github.com/flynn/flynn/pkg/attempt"
 	"github.com/flynn/flynn/pkg/cluster"
+	"github.com/flynn/flynn/pkg/installsource"
 	"github.com/flynn/flynn/pkg/stream"
+	"golang.org/x/net/context"
 )
 
 func JobConfig(f *ct.ExpandedFormation, name, hostID string, uuid string) *host.Job {
@@ -49,6 +53,10 @@ func JobConfig(f *ct.ExpandedFormation, name, hostID string, uuid string) *host.
 	metadata["flynn-controller.release"] = f.Release.ID
 	metadata["flynn-controller.formation"] = "true"
 	metadata["flynn-controller.type"] = name
+	if t.Omni {
+		// omni jobs stay on draining hosts, so hosts don't wait for them
+		metadata["flynn-controller.omni"] = "true"
+	}
 	job := &host.Job{
 		ID:       id,
 		Metadata: metadata,
@@ -96,6 +104,48 @@ func JobConfig(f *ct.ExpandedFormation, name, hostID string, uuid string) *host.
 	return job
 }
 
+// HasResourceEnvRefs returns whether any env values refer to resource env
+// vars (see ct.ResourceEnvRefPrefix).
+func HasResourceEnvRefs(env map[string]string) bool {
+	for _, v := range env {
+		if strings.HasPrefix(v, ct.ResourceEnvRefPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveResourceEnv replaces env values which refer to resource env vars
+// with the current values from the given resources, which should be those
+// provisioned for the job's app. Referring to any other resource is an error
+// so that apps can't read the credentials of resources they don't use.
+func ResolveResourceEnv(env map[string]string, resources []*ct.Resource) error {
+	for k, v := range env {
+		resourceID, name, ok, err := ct.ParseResourceEnvRef(v)
+		if err != nil {
+			return fmt.Errorf("error resolving %s: %s", k, err)
+		} else if !ok {
+			continue
+		}
+		var resource *ct.Resource
+		for _, r := range resources {
+			if r.ID == resourceID {
+				resource = r
+				break
+			}
+		}
+		if resource == nil {
+			return fmt.Errorf("error resolving %s: resource %s is not provisioned for the app", k, resourceID)
+		}
+		value, ok := resource.Env[name]
+		if !ok {
+			return fmt.Errorf("error resolving %s: resource %s has no env var %s", k, resourceID, name)
+		}
+		env[k] = value
+	}
+	return nil
+}
+
 // GetEntrypoint returns an image entrypoint for a process type from a list of
 // artifacts, first iterating through them and returning any entrypoint having
 // the exact type, then iterating through them and returning the artifact's
@@ -127,16 +177,17 @@ func GetEntrypoint(artifacts []*ct.Artifact, typ string) *ct.ImageEntrypoint {
 
 // SetupMountspecs populates job.Mountspecs using the layers from a list of
 // Flynn image artifacts, expecting each artifact to have a single rootfs entry
-// containing squashfs layers
+// for the cluster's architecture containing squashfs layers
 func SetupMountspecs(job *host.Job, artifacts []*ct.Artifact) {
 	for _, artifact := range artifacts {
 		if artifact.Type != ct.ArtifactTypeFlynn {
 			continue
 		}
-		if len(artifact.Manifest().Rootfs) != 1 {
+		archRootfs := artifact.Manifest().RootfsForArch(runtime.GOARCH)
+		if len(archRootfs) != 1 {
 			continue
 		}
-		rootfs := artifact.Manifest().Rootfs[0]
+		rootfs := archRootfs[0]
 		for _, layer := range rootfs.Layers {
 			if layer.Type != ct.ImageLayerTypeSquashfs {
 				continue
@@ -259,6 +310,8 @@ type HostClient interface {
 	VolumeCreator
 	ID() string
 	Tags() map[string]string
+	Profiles() []host.JobProfile
+	Draining() bool
 	Addr() string
 	AddJob(*host.Job) error
 	GetJob(id string) (*host.ActiveJob, error)
@@ -269,11 +322,15 @@ type HostClient interface {
 	ListActiveJobs() (map[string]host.ActiveJob, error)
 	StreamEvents(id string, ch chan *host.Event) (stream.Stream, error)
 	ListVolumes() ([]*volume.Info, error)
+	GetVolume(id string) (*volume.Info, error)
+	CreateSnapshot(volumeID string) (*volume.Info, error)
+	ForkVolume(snapID string, meta map[string]string) (*volume.Info, error)
 	StreamVolumes(ch chan *volume.Event) (stream.Stream, error)
 	GetStatus() (*host.HostStatus, error)
 	GetStats() (*host.HostResourceStats, error)
 	GetJobStats(jobID string) (*host.ContainerStats, error)
 	GetAllJobsStats() (*host.AllJobsStats, error)
+	UpdateHistory() ([]*installsource.UpdateRecord, error)
 	GetSinks() ([]*ct.Sink, error)
 	AddSink(*ct.Sink) error
 	RemoveSink(string) error
@@ -305,6 +362,18 @@ type ControllerClient interface {
 	VolumeList() ([]*ct.Volume, error)
 	PutVolume(*ct.Volume) error
 	StreamVolumes(since *time.Time, ch chan *ct.Volume) (stream.Stream, error)
+	AppResourceList(appID string) ([]*ct.Resource, error)
+	ResolveSecretEnv(appID, jobID string, env map[string]string) (map[string]string, error)
+}
+
+// HostClientWithContext returns a HostClient which makes requests with ctx,
+// sending the request ID and trace context it carries with each request, if
+// the client supports it.
+func HostClientWithContext(ctx context.Context, h HostClient) HostClient {
+	if c, ok := h.(*cluster.Host); ok {
+		return c.WithContext(ctx)
+	}
+	return h
 }
 
 func ClusterClientWrapper(c *cluster.Client) clusterClientWrapper {
@@ -350,3 +419,92 @@ func FormationTagsEqual(a, b map[string]map[string]string) bool {
 	}
 	return true
 }
+
+// ValidateFormationPlacement checks that every process type being scaled up
+// in the formation can be placed on at least one of the given hosts, that
+// being a host which has all of the process type's tags and supports all of
+// its job profiles, returning a validation error describing the first
+// process type which can't be placed.
+//
+// Hosts which don't advertise their profiles are assumed to support all of
+// them, and the check is skipped if there are no hosts.
+func ValidateFormationPlacement(hosts []HostClient, f *ct.Formation, release *ct.Release) error {
+	if len(hosts) == 0 {
+		return nil
+	}
+	types := make([]string, 0, len(f.Processes))
+	for typ, count := range f.Processes {
+		if count > 0 {
+			types = append(types, typ)
+		}
+	}
+	sort.Strings(types)
+	for _, typ := range types {
+		tags := f.Tags[typ]
+		var profiles []host.JobProfile
+		if proc, ok := release.Processes[typ]; ok {
+			profiles = proc.Profiles
+		}
+		var tagged []HostClient
+		for _, h := range hosts {
+			if hostHasTags(h, tags) {
+				tagged = append(tagged, h)
+			}
+		}
+		if len(tagged) == 0 {
+			return ct.ValidationError{
+				Field:   "tags",
+				Message: fmt.Sprintf("no hosts have the tags %s required by the %q process type", formatTags(tags), typ),
+			}
+		}
+		for _, p := range profiles {
+			supported := false
+			for _, h := range tagged {
+				if hostSupportsProfile(h, p) {
+					supported = true
+					break
+				}
+			}
+			if !supported {
+				msg := fmt.Sprintf("no hosts support the %q profile required by the %q process type", p, typ)
+				if len(tags) > 0 {
+					msg = fmt.Sprintf("no hosts with the tags %s support the %q profile required by the %q process type", formatTags(tags), p, typ)
+				}
+				return ct.ValidationError{Field: "processes", Message: msg}
+			}
+		}
+	}
+	return nil
+}
+
+func hostHasTags(h HostClient, tags map[string]string) bool {
+	hostTags := h.Tags()
+	for k, v := range tags {
+		if w, ok := hostTags[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
+
+func hostSupportsProfile(h HostClient, profile host.JobProfile) bool {
+	profiles := h.Profiles()
+	if profiles == nil {
+		return true
+	}
+	for _, p := range profiles {
+		if p == profile {
+			return true
+		}
+	}
+	return false
+}
+
+func formatTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -2,12 +2,17 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/flynn/flynn/controller/schema"
 	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/controller/utils"
+	"github.com/flynn/flynn/host/volume"
+	"github.com/flynn/flynn/pkg/cluster"
 	"github.com/flynn/flynn/pkg/ctxhelper"
 	"github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/sse"
@@ -44,9 +49,186 @@ func (c *controllerAPI) GetVolume(ctx context.Context, w http.ResponseWriter, re
 		respondWithError(w, err)
 		return
 	}
+	if req.URL.Query().Get("expand") == "true" {
+		expanded, err := c.expandVolume(ctx, volume)
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		httphelper.JSON(w, 200, expanded)
+		return
+	}
 	httphelper.JSON(w, 200, volume)
 }
 
+// volumeHost returns a client for the host the given volume is on.
+func (c *controllerAPI) volumeHost(ctx context.Context, vol *ct.Volume) (utils.HostClient, error) {
+	h, err := c.clusterClient.Host(vol.HostID)
+	if err != nil {
+		return nil, httphelper.JSONError{
+			Code:    httphelper.ServiceUnavailableErrorCode,
+			Message: fmt.Sprintf("host %s of volume %s is unavailable: %s", vol.HostID, vol.ID, err),
+			Retry:   true,
+		}
+	}
+	return utils.HostClientWithContext(ctx, h), nil
+}
+
+// expandVolume gets the disk usage and snapshots of the given volume from the
+// host it is on.
+func (c *controllerAPI) expandVolume(ctx context.Context, vol *ct.Volume) (*ct.ExpandedVolume, error) {
+	h, err := c.volumeHost(ctx, vol)
+	if err != nil {
+		return nil, err
+	}
+	info, err := h.GetVolume(vol.ID)
+	if err != nil {
+		return nil, err
+	}
+	vols, err := h.ListVolumes()
+	if err != nil {
+		return nil, err
+	}
+	expanded := &ct.ExpandedVolume{
+		Volume:    vol,
+		Size:      info.Size,
+		Snapshots: []*ct.VolumeSnapshot{},
+	}
+	for _, v := range vols {
+		if v.Meta[volume.SnapshotOfMetaKey] != vol.ID {
+			continue
+		}
+		// the size is only reported when getting a single volume
+		if snap, err := h.GetVolume(v.ID); err == nil {
+			v = snap
+		}
+		expanded.Snapshots = append(expanded.Snapshots, volumeSnapshot(vol, v))
+	}
+	sort.Slice(expanded.Snapshots, func(i, j int) bool {
+		return expanded.Snapshots[i].CreatedAt.Before(expanded.Snapshots[j].CreatedAt)
+	})
+	return expanded, nil
+}
+
+func volumeSnapshot(vol *ct.Volume, info *volume.Info) *ct.VolumeSnapshot {
+	return &ct.VolumeSnapshot{
+		ID:        info.ID,
+		VolumeID:  vol.ID,
+		HostID:    vol.HostID,
+		Size:      info.Size,
+		CreatedAt: info.CreatedAt,
+	}
+}
+
+func (c *controllerAPI) CreateVolumeSnapshot(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	vol, err := c.volumeRepo.Get(c.getApp(ctx).ID, params.ByName("volume_id"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if vol.State != ct.VolumeStateCreated {
+		respondWithError(w, ct.ValidationError{Message: fmt.Sprintf("volume is %s", vol.State)})
+		return
+	}
+	h, err := c.volumeHost(ctx, vol)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	snap, err := h.CreateSnapshot(vol.ID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, volumeSnapshot(vol, snap))
+}
+
+// RestoreVolume restores a volume from one of its snapshots by creating a
+// new volume from the snapshot and decommissioning the existing one, so
+// that the scheduler attaches the restored volume to the next job started
+// in place of the existing one.
+func (c *controllerAPI) RestoreVolume(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	app := c.getApp(ctx)
+	vol, err := c.volumeRepo.Get(app.ID, params.ByName("volume_id"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	var restoreReq ct.VolumeRestoreRequest
+	if err := httphelper.DecodeJSON(req, &restoreReq); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if restoreReq.SnapshotID == "" {
+		respondWithError(w, ct.ValidationError{Field: "snapshot", Message: "must be set"})
+		return
+	}
+	if vol.DecommissionedAt != nil {
+		respondWithError(w, ct.ValidationError{Message: "volume is decommissioned"})
+		return
+	}
+	if vol.DeleteOnStop {
+		respondWithError(w, ct.ValidationError{Message: "cannot restore an ephemeral volume"})
+		return
+	}
+
+	h, err := c.volumeHost(ctx, vol)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	snap, err := h.GetVolume(restoreReq.SnapshotID)
+	if err != nil && err != cluster.ErrNotFound {
+		respondWithError(w, err)
+		return
+	}
+	if err == cluster.ErrNotFound || snap.Meta[volume.SnapshotOfMetaKey] != vol.ID {
+		respondWithError(w, ct.ValidationError{Field: "snapshot", Message: "is not a snapshot of the volume"})
+		return
+	}
+
+	// give the new volume the same metadata as the existing one so the
+	// scheduler treats it as a volume of the same app process type
+	meta := make(map[string]string, len(vol.Meta)+5)
+	for k, v := range vol.Meta {
+		meta[k] = v
+	}
+	meta["flynn-controller.app"] = vol.AppID
+	meta["flynn-controller.release"] = vol.ReleaseID
+	meta["flynn-controller.type"] = vol.JobType
+	meta["flynn-controller.path"] = vol.Path
+	meta["flynn-controller.delete_on_stop"] = "false"
+	info, err := h.ForkVolume(snap.ID, meta)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	restored := &ct.Volume{
+		VolumeReq: vol.VolumeReq,
+		ID:        info.ID,
+		HostID:    vol.HostID,
+		Type:      info.Type,
+		State:     ct.VolumeStateCreated,
+		AppID:     vol.AppID,
+		ReleaseID: vol.ReleaseID,
+		JobType:   vol.JobType,
+		Meta:      info.Meta,
+	}
+	if err := c.volumeRepo.Add(restored); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := c.volumeRepo.Decommission(app.ID, vol); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, restored)
+}
+
 func (c *controllerAPI) PutVolume(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	var volume ct.Volume
 	if err := httphelper.DecodeJSON(req, &volume); err != nil {
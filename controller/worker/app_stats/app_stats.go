@@ -0,0 +1,143 @@
+// Package app_stats implements a worker which periodically records the
+// resource usage of app jobs so that it can be rolled up and graphed without
+// querying every host.
+package app_stats
+
+import (
+	"time"
+
+	"github.com/flynn/flynn/controller/data"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/controller/worker/periodic"
+	host "github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/pkg/cluster"
+	"github.com/flynn/flynn/pkg/postgres"
+	"github.com/flynn/que-go"
+	"github.com/inconshreveable/log15"
+)
+
+// JobType is the que job type of the app stats job.
+const JobType = "app_stats"
+
+// interval is how often the usage of app jobs is recorded
+const interval = 5 * time.Minute
+
+// retention is how long snapshots are kept for
+const retention = 30 * 24 * time.Hour
+
+// statsRepo is the subset of data.AppStatsRepo used by the worker.
+type statsRepo interface {
+	Add(snapshots []*ct.AppStatsSnapshot) error
+	DeleteBefore(t time.Time) error
+}
+
+// statsHost is the subset of cluster.Host used by the worker.
+type statsHost interface {
+	ID() string
+	GetAllJobsStats() (*host.AllJobsStats, error)
+	ListJobs() (map[string]host.ActiveJob, error)
+}
+
+type context struct {
+	repo         statsRepo
+	hosts        func() ([]statsHost, error)
+	scheduleNext func(job *que.Job) error
+	logger       log15.Logger
+}
+
+func JobHandler(db *postgres.DB, logger log15.Logger) func(*que.Job) error {
+	clusterClient := cluster.NewClient()
+	return (&context{
+		repo: data.NewAppStatsRepo(db),
+		hosts: func() ([]statsHost, error) {
+			hosts, err := clusterClient.Hosts()
+			if err != nil {
+				return nil, err
+			}
+			res := make([]statsHost, len(hosts))
+			for i, h := range hosts {
+				res[i] = h
+			}
+			return res, nil
+		},
+		scheduleNext: func(job *que.Job) error {
+			return periodic.ScheduleNext(db, job, interval)
+		},
+		logger: logger,
+	}).HandleAppStats
+}
+
+// Schedule enqueues the app stats job unless it is already queued, the job
+// then schedules its own subsequent runs.
+func Schedule(db *postgres.DB) error {
+	return periodic.Schedule(db, JobType)
+}
+
+func (c *context) HandleAppStats(job *que.Job) error {
+	log := c.logger.New("fn", "HandleAppStats", "job_id", job.ID)
+
+	// schedule the next run regardless of whether this one succeeds so
+	// that a host being unavailable only leaves a gap in the graphs
+	defer func() {
+		if err := c.scheduleNext(job); err != nil {
+			log.Error("error scheduling next run", "err", err)
+		}
+	}()
+
+	now := time.Now()
+	if err := c.record(now); err != nil {
+		log.Error("error recording app stats", "err", err)
+	}
+	if err := c.repo.DeleteBefore(now.Add(-retention)); err != nil {
+		log.Error("error deleting old app stats", "err", err)
+	}
+	return nil
+}
+
+// record stores a snapshot of the current usage of each app job on every
+// host, skipping hosts whose stats cannot be read and jobs which are not
+// run by the controller.
+func (c *context) record(now time.Time) error {
+	hosts, err := c.hosts()
+	if err != nil {
+		return err
+	}
+	var snapshots []*ct.AppStatsSnapshot
+	for _, h := range hosts {
+		log := c.logger.New("fn", "record", "host.id", h.ID())
+		stats, err := h.GetAllJobsStats()
+		if err != nil {
+			log.Warn("error getting jobs stats", "err", err)
+			continue
+		}
+		jobs, err := h.ListJobs()
+		if err != nil {
+			log.Warn("error listing jobs", "err", err)
+			continue
+		}
+		for _, s := range stats.Jobs {
+			job, ok := jobs[s.JobID]
+			if !ok || job.Job == nil {
+				continue
+			}
+			appID := job.Job.Metadata["flynn-controller.app"]
+			if appID == "" {
+				continue
+			}
+			snapshots = append(snapshots, &ct.AppStatsSnapshot{
+				AppID:           appID,
+				JobID:           s.JobID,
+				ProcessType:     job.Job.Metadata["flynn-controller.type"],
+				CPUUsagePercent: s.CPUUsagePercent,
+				MemoryBytes:     int64(s.MemoryUsageBytes),
+				NetworkRxBytes:  int64(s.NetworkRxBytes),
+				NetworkTxBytes:  int64(s.NetworkTxBytes),
+				CreatedAt:       now,
+			})
+		}
+	}
+	if len(snapshots) == 0 {
+		return nil
+	}
+	return c.repo.Add(snapshots)
+}
@@ -0,0 +1,123 @@
+package app_stats
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	host "github.com/flynn/flynn/host/types"
+	"github.com/flynn/que-go"
+	"github.com/inconshreveable/log15"
+)
+
+type fakeRepo struct {
+	added         []*ct.AppStatsSnapshot
+	deletedBefore time.Time
+}
+
+func (r *fakeRepo) Add(snapshots []*ct.AppStatsSnapshot) error {
+	r.added = append(r.added, snapshots...)
+	return nil
+}
+
+func (r *fakeRepo) DeleteBefore(t time.Time) error {
+	r.deletedBefore = t
+	return nil
+}
+
+type fakeHost struct {
+	id    string
+	stats []*host.ContainerStats
+	jobs  map[string]host.ActiveJob
+	err   error
+}
+
+func (h *fakeHost) ID() string { return h.id }
+
+func (h *fakeHost) GetAllJobsStats() (*host.AllJobsStats, error) {
+	if h.err != nil {
+		return nil, h.err
+	}
+	return &host.AllJobsStats{HostID: h.id, Jobs: h.stats}, nil
+}
+
+func (h *fakeHost) ListJobs() (map[string]host.ActiveJob, error) {
+	return h.jobs, nil
+}
+
+func appJob(id, appID, typ string) host.ActiveJob {
+	return host.ActiveJob{Job: &host.Job{ID: id, Metadata: map[string]string{
+		"flynn-controller.app":  appID,
+		"flynn-controller.type": typ,
+	}}}
+}
+
+func TestHandleAppStats(t *testing.T) {
+	hosts := []statsHost{
+		&fakeHost{
+			id: "host1",
+			stats: []*host.ContainerStats{
+				{JobID: "web1", CPUUsagePercent: 12.5, MemoryUsageBytes: 1024, NetworkRxBytes: 100, NetworkTxBytes: 200},
+				{JobID: "system", CPUUsagePercent: 1, MemoryUsageBytes: 1},
+				{JobID: "unknown", CPUUsagePercent: 1, MemoryUsageBytes: 1},
+			},
+			jobs: map[string]host.ActiveJob{
+				"web1":   appJob("web1", "app1", "web"),
+				"system": {Job: &host.Job{ID: "system"}},
+			},
+		},
+		&fakeHost{id: "host2", err: errors.New("host unavailable")},
+	}
+	repo := &fakeRepo{}
+	var jobs []*que.Job
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	c := &context{
+		repo:  repo,
+		hosts: func() ([]statsHost, error) { return hosts, nil },
+		scheduleNext: func(job *que.Job) error {
+			jobs = append(jobs, job)
+			return nil
+		},
+		logger: logger,
+	}
+
+	start := time.Now()
+	job := &que.Job{ID: 1, Type: JobType}
+	if err := c.HandleAppStats(job); err != nil {
+		t.Fatal(err)
+	}
+
+	// only jobs run by the controller are recorded, and hosts which fail
+	// are skipped
+	if len(repo.added) != 1 {
+		t.Fatalf("expected 1 snapshot, got %d", len(repo.added))
+	}
+	s := repo.added[0]
+	expected := &ct.AppStatsSnapshot{
+		AppID:           "app1",
+		JobID:           "web1",
+		ProcessType:     "web",
+		CPUUsagePercent: 12.5,
+		MemoryBytes:     1024,
+		NetworkRxBytes:  100,
+		NetworkTxBytes:  200,
+		CreatedAt:       s.CreatedAt,
+	}
+	if !reflect.DeepEqual(s, expected) {
+		t.Fatalf("expected snapshot %+v, got %+v", expected, s)
+	}
+	if s.CreatedAt.Before(start) {
+		t.Fatalf("expected snapshot to be taken after %s, got %s", start, s.CreatedAt)
+	}
+
+	// old snapshots are deleted and the next run is scheduled
+	if cutoff := s.CreatedAt.Add(-retention); !repo.deletedBefore.Equal(cutoff) {
+		t.Fatalf("expected snapshots before %s to be deleted, got %s", cutoff, repo.deletedBefore)
+	}
+	if len(jobs) != 1 || jobs[0] != job {
+		t.Fatalf("expected next run to be scheduled, got %+v", jobs)
+	}
+}
@@ -0,0 +1,198 @@
+package deployment
+
+import (
+	"fmt"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	worker "github.com/flynn/flynn/controller/worker/types"
+	router "github.com/flynn/flynn/router/types"
+	"github.com/inconshreveable/log15"
+)
+
+// canaryWeights are the percentages of requests sent to the new release in
+// turn by canary deployments before the old release is stopped
+var canaryWeights = []int{10, 25, 50}
+
+// canaryInterval is how long requests are shifted to the new release at each
+// weight before its jobs are checked
+var canaryInterval = time.Minute
+
+// canaryRoute is an HTTP route which is shifted to the new release, along with
+// the splits it had before the deploy.
+type canaryRoute struct {
+	route  *router.Route
+	splits []*router.RouteSplit
+}
+
+// deployCanary starts the new release alongside the old one, then sends it an
+// increasing share of the requests of the app's HTTP routes using splits
+// limited to the new release. The deploy fails and is rolled back if any of
+// the new release's jobs go down, otherwise the old release is stopped and
+// the splits removed.
+func (d *DeployJob) deployCanary() (err error) {
+	log := d.logger.New("fn", "deployCanary")
+	log.Info("starting canary deployment")
+
+	routes, err := d.canaryRoutes()
+	if err != nil {
+		log.Error("error listing app routes", "err", err)
+		return err
+	}
+	if len(routes) == 0 {
+		log.Info("no HTTP routes for the new release's services, deploying all at once")
+		return d.deployAllAtOnce()
+	}
+
+	// restore the routes' splits if the deploy fails so that the old
+	// release is sent all of the requests while it is rolled back
+	defer func() {
+		if err != nil {
+			d.restoreSplits(routes, log)
+		}
+	}()
+
+	// add the splits before scaling up so that the new release's jobs only
+	// get the split's share of requests as they come up
+	if err := d.setCanaryWeight(routes, canaryWeights[0], log); err != nil {
+		return err
+	}
+
+	d.newFormation.Processes = make(map[string]int, len(d.oldFormation.Processes))
+	for typ, count := range d.oldFormation.Processes {
+		// only scale new processes which still exist
+		if _, ok := d.newRelease.Processes[typ]; ok {
+			d.newFormation.Processes[typ] = count
+		}
+	}
+	log.Info("scaling up new formation", "release.id", d.NewReleaseID, "processes", d.newFormation.Processes)
+	if err := d.scaleNewRelease(); err != nil {
+		log.Error("error scaling up new formation", "release.id", d.NewReleaseID, "err", err)
+		return err
+	}
+
+	since := time.Now()
+	for i, weight := range canaryWeights {
+		if i > 0 {
+			if err := d.setCanaryWeight(routes, weight, log); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-d.stop:
+			return worker.ErrStopped
+		case <-time.After(canaryInterval):
+		}
+		if err := d.checkCanaryJobs(since); err != nil {
+			log.Error("new release failed whilst receiving requests", "weight", weight, "err", err)
+			return err
+		}
+	}
+
+	log.Info("scaling old formation to zero", "release.id", d.OldReleaseID)
+	for typ := range d.oldRelease.Processes {
+		d.oldFormation.Processes[typ] = 0
+	}
+	if err := d.scaleOldRelease(true); err != nil {
+		log.Error("error scaling old formation to zero", "release.id", d.OldReleaseID, "err", err)
+		return err
+	}
+
+	// the routes' services now only have instances of the new release,
+	// so the splits are no longer needed
+	d.restoreSplits(routes, log)
+	log.Info("finished canary deployment")
+	return nil
+}
+
+// canaryRoutes returns the app's HTTP routes for services of the new release.
+// Leader routes are skipped since they only send requests to one instance.
+func (d *DeployJob) canaryRoutes() ([]*canaryRoute, error) {
+	services := make(map[string]struct{})
+	for _, proc := range d.newRelease.Processes {
+		for _, port := range proc.Ports {
+			if port.Service != nil {
+				services[port.Service.Name] = struct{}{}
+			}
+		}
+	}
+	routes, err := d.client.AppRouteList(d.AppID)
+	if err != nil {
+		return nil, err
+	}
+	var res []*canaryRoute
+	for _, r := range routes {
+		if r.Type != "http" || r.Leader {
+			continue
+		}
+		if _, ok := services[r.Service]; !ok {
+			continue
+		}
+		// drop release splits of the route's own service, which are
+		// left over from a canary deploy which did not finish
+		splits := make([]*router.RouteSplit, 0, len(r.Splits))
+		for _, split := range r.Splits {
+			if split.Service != r.Service || split.Release == "" {
+				splits = append(splits, split)
+			}
+		}
+		res = append(res, &canaryRoute{route: r, splits: splits})
+	}
+	return res, nil
+}
+
+// setCanaryWeight sets the percentage of each route's requests which are sent
+// to the new release.
+func (d *DeployJob) setCanaryWeight(routes []*canaryRoute, weight int, log log15.Logger) error {
+	log.Info("sending requests to the new release", "release.id", d.NewReleaseID, "weight", weight)
+	for _, r := range routes {
+		splits := make([]*router.RouteSplit, len(r.splits), len(r.splits)+1)
+		copy(splits, r.splits)
+		splits = append(splits, &router.RouteSplit{
+			Service: r.route.Service,
+			Release: d.NewReleaseID,
+			Weight:  weight,
+		})
+		if _, err := d.client.PatchRoute(d.AppID, r.route.FormattedID(), &router.RoutePatch{Splits: &splits}); err != nil {
+			log.Error("error setting canary split", "route.id", r.route.FormattedID(), "err", err)
+			return fmt.Errorf("error setting canary split of route %s: %s", r.route.FormattedID(), err)
+		}
+	}
+	return nil
+}
+
+// restoreSplits sets the routes' splits back to those they had before the
+// deploy, logging rather than returning errors since the deploy has either
+// already failed or no longer needs them.
+func (d *DeployJob) restoreSplits(routes []*canaryRoute, log log15.Logger) {
+	for _, r := range routes {
+		splits := r.splits
+		if _, err := d.client.PatchRoute(d.AppID, r.route.FormattedID(), &router.RoutePatch{Splits: &splits}); err != nil {
+			log.Error("error restoring route splits", "route.id", r.route.FormattedID(), "err", err)
+		}
+	}
+}
+
+// checkCanaryJobs returns an error if any of the new release's formation jobs
+// have gone down since the given time, recording why they failed.
+func (d *DeployJob) checkCanaryJobs(since time.Time) error {
+	jobs, err := d.client.JobList(d.AppID)
+	if err != nil {
+		return err
+	}
+	var failed *ct.Job
+	for _, job := range jobs {
+		if job.ReleaseID != d.NewReleaseID || d.newFormation.Processes[job.Type] == 0 {
+			continue
+		}
+		if job.State != ct.JobStateDown || job.UpdatedAt == nil || job.UpdatedAt.Before(since) {
+			continue
+		}
+		d.recordJobFailure(job)
+		failed = job
+	}
+	if failed != nil {
+		return fmt.Errorf("%s job failed whilst receiving requests: %s", failed.Type, ct.NewDeploymentJobFailure(failed).Reason())
+	}
+	return nil
+}
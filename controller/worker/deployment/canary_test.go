@@ -0,0 +1,144 @@
+package deployment
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	controller "github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	host "github.com/flynn/flynn/host/types"
+	router "github.com/flynn/flynn/router/types"
+	"github.com/inconshreveable/log15"
+)
+
+// canaryClient is a controller client which records the route patches and
+// scale requests made by canary deployments.
+type canaryClient struct {
+	controller.Client
+
+	routes  []*router.Route
+	jobs    []*ct.Job
+	weights []int
+	splits  [][]*router.RouteSplit
+	scales  []string
+}
+
+func (c *canaryClient) AppRouteList(appID string) ([]*router.Route, error) {
+	return c.routes, nil
+}
+
+func (c *canaryClient) PatchRoute(appID, routeID string, patch *router.RoutePatch) (*router.Route, error) {
+	splits := *patch.Splits
+	c.splits = append(c.splits, splits)
+	for _, split := range splits {
+		if split.Release == "new" {
+			c.weights = append(c.weights, split.Weight)
+		}
+	}
+	return nil, nil
+}
+
+func (c *canaryClient) ScaleAppRelease(appID, releaseID string, opts ct.ScaleOptions) error {
+	c.scales = append(c.scales, releaseID)
+	return nil
+}
+
+func (c *canaryClient) JobList(appID string) ([]*ct.Job, error) {
+	return c.jobs, nil
+}
+
+func newCanaryJob(client *canaryClient) *DeployJob {
+	web := ct.ProcessType{Ports: []ct.Port{{Service: &host.Service{Name: "app-web"}}}}
+	d := &DeployJob{
+		Deployment:   &ct.Deployment{AppID: "app", OldReleaseID: "old", NewReleaseID: "new"},
+		client:       client,
+		deployEvents: make(chan ct.DeploymentEvent, 10),
+		logger:       log15.New(),
+		oldRelease:   &ct.Release{ID: "old", Processes: map[string]ct.ProcessType{"web": web}},
+		newRelease:   &ct.Release{ID: "new", Processes: map[string]ct.ProcessType{"web": web, "worker": {}}},
+		oldFormation: &ct.Formation{Processes: map[string]int{"web": 2}},
+		newFormation: &ct.Formation{Processes: map[string]int{}},
+		stop:         make(chan struct{}),
+	}
+	d.logger.SetHandler(log15.DiscardHandler())
+	return d
+}
+
+func TestDeployCanary(t *testing.T) {
+	defer func(d time.Duration) { canaryInterval = d }(canaryInterval)
+	canaryInterval = time.Millisecond
+
+	other := &router.RouteSplit{Service: "other", Weight: 5}
+	client := &canaryClient{routes: []*router.Route{
+		{ID: "1", Type: "http", Service: "app-web", Splits: []*router.RouteSplit{
+			other,
+			// left over from a previous canary deploy
+			{Service: "app-web", Release: "previous", Weight: 10},
+		}},
+		{ID: "2", Type: "http", Service: "app-web", Leader: true},
+		{ID: "3", Type: "http", Service: "app-admin"},
+		{ID: "4", Type: "tcp", Service: "app-web"},
+	}}
+	d := newCanaryJob(client)
+	if err := d.deployCanary(); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the first route is shifted to the new release, which is given
+	// each weight in turn before the old release is stopped and the
+	// route's other splits restored
+	if !reflect.DeepEqual(client.weights, canaryWeights) {
+		t.Fatalf("expected weights %v, got %v", canaryWeights, client.weights)
+	}
+	if len(client.splits) != len(canaryWeights)+1 {
+		t.Fatalf("expected %d route patches, got %d", len(canaryWeights)+1, len(client.splits))
+	}
+	if first := client.splits[0]; len(first) != 2 || first[0] != other {
+		t.Fatalf("expected the route's other splits to be kept, got %v", first)
+	}
+	if last := client.splits[len(client.splits)-1]; len(last) != 1 || last[0] != other {
+		t.Fatalf("expected the route's other splits to be restored, got %v", last)
+	}
+	if !reflect.DeepEqual(client.scales, []string{"new", "old"}) {
+		t.Fatalf("expected the new release to be scaled up before the old one is stopped, got %v", client.scales)
+	}
+	if d.newFormation.Processes["web"] != 2 || d.oldFormation.Processes["web"] != 0 {
+		t.Fatalf("unexpected formations, new: %v, old: %v", d.newFormation.Processes, d.oldFormation.Processes)
+	}
+}
+
+func TestDeployCanaryJobFailure(t *testing.T) {
+	defer func(d time.Duration) { canaryInterval = d }(canaryInterval)
+	canaryInterval = time.Millisecond
+
+	now := time.Now().Add(time.Hour)
+	client := &canaryClient{
+		routes: []*router.Route{{ID: "1", Type: "http", Service: "app-web"}},
+		jobs: []*ct.Job{
+			{ID: "job1", ReleaseID: "new", Type: "web", State: ct.JobStateDown, UpdatedAt: &now, HealthCheckFailed: true},
+			{ID: "job2", ReleaseID: "old", Type: "web", State: ct.JobStateDown, UpdatedAt: &now},
+		},
+	}
+	d := newCanaryJob(client)
+	err := d.deployCanary()
+	if err == nil || !strings.Contains(err.Error(), "web job failed whilst receiving requests") {
+		t.Fatalf("expected the deploy to fail, got %v", err)
+	}
+
+	// the deploy fails at the first weight, the old release is not stopped
+	// and the split is removed
+	if !reflect.DeepEqual(client.weights, canaryWeights[:1]) {
+		t.Fatalf("expected only the first weight, got %v", client.weights)
+	}
+	if last := client.splits[len(client.splits)-1]; len(last) != 0 {
+		t.Fatalf("expected the split to be removed, got %v", last)
+	}
+	if !reflect.DeepEqual(client.scales, []string{"new"}) {
+		t.Fatalf("expected only the new release to be scaled, got %v", client.scales)
+	}
+	if len(d.jobFailures) != 1 || d.jobFailures[0].JobID != "job1" {
+		t.Fatalf("expected the new release's job failure to be recorded, got %v", d.jobFailures)
+	}
+}
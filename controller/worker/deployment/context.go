@@ -9,6 +9,7 @@ import (
 	"github.com/flynn/flynn/controller/worker/types"
 	"github.com/flynn/flynn/pkg/attempt"
 	"github.com/flynn/flynn/pkg/postgres"
+	"github.com/flynn/flynn/pkg/tracing"
 	"github.com/flynn/que-go"
 	"github.com/inconshreveable/log15"
 )
@@ -45,6 +46,18 @@ func (c *context) HandleDeployment(job *que.Job) (e error) {
 		"app_id", deployment.AppID,
 		"strategy", deployment.Strategy,
 	)
+
+	// each deploy is the root of a new trace, which is passed to the
+	// scheduler with each scale request so that it and the hosts can
+	// attach job launch spans to it
+	span := tracing.Start("deploy", tracing.SpanContext{})
+	span.SetAttr("deployment.id", deployment.ID)
+	span.SetAttr("app.id", deployment.AppID)
+	span.SetAttr("release.id", deployment.NewReleaseID)
+	span.SetAttr("release.old_id", deployment.OldReleaseID)
+	span.SetAttr("deployment.strategy", deployment.Strategy)
+	defer span.Finish(&e)
+
 	// for recovery purposes, fetch old formation
 	log.Info("getting old formation")
 	f, err := c.client.GetFormation(deployment.AppID, deployment.OldReleaseID)
@@ -55,6 +68,14 @@ func (c *context) HandleDeployment(job *que.Job) (e error) {
 
 	events := make(chan ct.DeploymentEvent)
 	defer close(events)
+	j := &DeployJob{
+		Deployment:   deployment,
+		client:       c.client,
+		deployEvents: events,
+		logger:       c.logger,
+		stop:         job.Stop,
+		trace:        span.Context(),
+	}
 	go func() {
 		log.Info("watching deployment events")
 		for ev := range events {
@@ -85,6 +106,7 @@ func (c *context) HandleDeployment(job *que.Job) (e error) {
 		} else {
 			// rollback failed deploy
 			errMsg := e.Error()
+			span.SetError(e)
 			if IsSkipRollback(e) {
 				// ErrSkipRollback indicates the deploy failed in some way
 				// but no further action should be taken, so set the error
@@ -92,24 +114,17 @@ func (c *context) HandleDeployment(job *que.Job) (e error) {
 				e = nil
 			} else {
 				log.Warn("rolling back deployment due to error", "err", e)
-				e = c.rollback(log, deployment, f, job.Stop)
+				e = c.rollback(log, deployment, f, job.Stop, span.Context())
 			}
 			events <- ct.DeploymentEvent{
-				ReleaseID: deployment.NewReleaseID,
-				Status:    "failed",
-				Error:     errMsg,
+				ReleaseID:   deployment.NewReleaseID,
+				Status:      "failed",
+				Error:       errMsg,
+				JobFailures: j.jobFailures,
 			}
 		}
 	}()
 
-	j := &DeployJob{
-		Deployment:   deployment,
-		client:       c.client,
-		deployEvents: events,
-		logger:       c.logger,
-		stop:         job.Stop,
-	}
-
 	log.Info("performing deployment")
 	if err := j.Perform(); err != nil {
 		log.Error("error performing deployment", "err", err)
@@ -120,6 +135,12 @@ func (c *context) HandleDeployment(job *que.Job) (e error) {
 		log.Error("error setting the app release", "err", err)
 		return err
 	}
+
+	log.Info("recording old release resource usage")
+	if err := c.recordOldReleaseUsage(deployment, log); err != nil {
+		// just log the error, the usage is only informational
+		log.Error("error recording old release resource usage", "err", err)
+	}
 	log.Info("deployment complete")
 
 	log.Info("scheduling app garbage collection")
@@ -131,15 +152,18 @@ func (c *context) HandleDeployment(job *que.Job) (e error) {
 	return nil
 }
 
-func (c *context) rollback(l log15.Logger, deployment *ct.Deployment, original *ct.Formation, stop chan struct{}) error {
+func (c *context) rollback(l log15.Logger, deployment *ct.Deployment, original *ct.Formation, stop chan struct{}, trace tracing.SpanContext) (err error) {
 	log := l.New("fn", "rollback")
+	span := tracing.Start("deploy.rollback", trace)
+	defer span.Finish(&err)
 
 	log.Info("restoring the original formation", "release.id", original.ReleaseID)
 	timeout := 10 * time.Second
 	opts := ct.ScaleOptions{
-		Processes: original.Processes,
-		Timeout:   &timeout,
-		Stop:      stop,
+		Processes:   original.Processes,
+		Timeout:     &timeout,
+		Stop:        stop,
+		TraceParent: tracing.Traceparent(span),
 		JobEventCallback: func(job *ct.Job) error {
 			log.Info("got job event", "job.id", job.ID, "job.type", job.Type, "job.state", job.State)
 			return nil
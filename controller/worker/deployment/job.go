@@ -7,6 +7,7 @@ import (
 	controller "github.com/flynn/flynn/controller/client"
 	ct "github.com/flynn/flynn/controller/types"
 	worker "github.com/flynn/flynn/controller/worker/types"
+	"github.com/flynn/flynn/pkg/tracing"
 	"github.com/inconshreveable/log15"
 )
 
@@ -21,6 +22,14 @@ type DeployJob struct {
 	newFormation *ct.Formation
 	timeout      time.Duration
 	stop         chan struct{}
+
+	// trace is the context of the deploy span which scale spans are
+	// children of
+	trace tracing.SpanContext
+
+	// jobFailures records why new release jobs went down, and is
+	// included in the final deployment event if the deploy fails
+	jobFailures []*ct.DeploymentJobFailure
 }
 
 func (d *DeployJob) Perform() error {
@@ -41,6 +50,8 @@ func (d *DeployJob) Perform() error {
 		deployFunc = d.deploySirenia
 	case "discoverd-meta":
 		deployFunc = d.deployDiscoverdMeta
+	case "canary":
+		deployFunc = d.deployCanary
 	default:
 		err := UnknownStrategyError{d.Strategy}
 		log.Error("error validating deployment strategy", "err", err)
@@ -96,58 +107,97 @@ func (d *DeployJob) Perform() error {
 	return deployFunc()
 }
 
-func (d *DeployJob) scaleOldRelease(wait bool) error {
+func (d *DeployJob) scaleOldRelease(wait bool) (err error) {
+	span := d.startScaleSpan("deploy.scale_old_release", d.OldReleaseID, d.oldFormation.Processes)
+	defer span.Finish(&err)
 	opts := ct.ScaleOptions{
-		Processes:        d.oldFormation.Processes,
-		Timeout:          &d.timeout,
-		Stop:             d.stop,
-		NoWait:           !wait,
-		JobEventCallback: d.logJobEvent,
+		Processes:   d.oldFormation.Processes,
+		Timeout:     &d.timeout,
+		Stop:        d.stop,
+		NoWait:      !wait,
+		TraceParent: tracing.Traceparent(span),
+		JobEventCallback: func(job *ct.Job) error {
+			recordJobEvent(span, job)
+			return d.logJobEvent(job)
+		},
 	}
-	err := d.client.ScaleAppRelease(d.AppID, d.OldReleaseID, opts)
+	err = d.client.ScaleAppRelease(d.AppID, d.OldReleaseID, opts)
 	if err == ct.ErrScalingStopped {
 		err = worker.ErrStopped
 	}
 	return err
 }
 
+// startScaleSpan starts a span for scaling the given release to the given
+// processes.
+func (d *DeployJob) startScaleSpan(name, releaseID string, processes map[string]int) *tracing.Span {
+	span := tracing.Start(name, d.trace)
+	span.SetAttr("release.id", releaseID)
+	for typ, count := range processes {
+		span.SetAttr("processes."+typ, count)
+	}
+	return span
+}
+
+// recordJobEvent records the number of jobs which have reached each state
+// whilst scaling, so a trace shows how many jobs were started or failed.
+func recordJobEvent(span *tracing.Span, job *ct.Job) {
+	span.IncrAttr("jobs." + string(job.State))
+}
+
 // failedJobThreshold is the number of times new jobs can fail when scaling up
 // a new release before aborting the deploy
 const newJobFailureThreshold = 5
 
-func (d *DeployJob) scaleNewRelease() error {
+func (d *DeployJob) scaleNewRelease() (err error) {
+	span := d.startScaleSpan("deploy.scale_new_release", d.NewReleaseID, d.newFormation.Processes)
+	defer span.Finish(&err)
 	failures := 0
 	opts := ct.ScaleOptions{
-		Processes: d.newFormation.Processes,
-		Tags:      d.newFormation.Tags,
-		Timeout:   &d.timeout,
-		Stop:      d.stop,
+		Processes:   d.newFormation.Processes,
+		Tags:        d.newFormation.Tags,
+		Timeout:     &d.timeout,
+		Stop:        d.stop,
+		TraceParent: tracing.Traceparent(span),
 		JobEventCallback: func(job *ct.Job) error {
+			recordJobEvent(span, job)
 			d.logJobEvent(job)
 			// return an error if we get more than newJobFailureThreshold
 			// down events when scaling the new formation up
 			if job.State == ct.JobStateDown {
+				d.recordJobFailure(job)
 				failures++
 				if failures <= newJobFailureThreshold {
 					d.logger.Warn("ignoring down job event for new release", "count", failures, "err", job.HostError)
 					return nil
 				}
-				msg := "got down job event"
-				if job.HostError != nil {
-					msg = *job.HostError
-				}
-				return fmt.Errorf("%s job failed to start: %s", job.Type, msg)
+				return fmt.Errorf("%s job failed to start: %s", job.Type, ct.NewDeploymentJobFailure(job).Reason())
 			}
 			return nil
 		},
 	}
-	err := d.client.ScaleAppRelease(d.AppID, d.NewReleaseID, opts)
+	err = d.client.ScaleAppRelease(d.AppID, d.NewReleaseID, opts)
 	if err == ct.ErrScalingStopped {
 		err = worker.ErrStopped
 	}
 	return err
 }
 
+// recordJobFailure records the failure details of a down job from the new
+// release and emits them as a deployment event so they are visible while the
+// deploy is still running.
+func (d *DeployJob) recordJobFailure(job *ct.Job) {
+	f := ct.NewDeploymentJobFailure(job)
+	d.logger.Warn("new release job went down", "job.id", f.JobID, "job.type", f.JobType, "host.id", f.HostID, "reason", f.Reason())
+	d.jobFailures = append(d.jobFailures, f)
+	d.deployEvents <- ct.DeploymentEvent{
+		ReleaseID:   d.NewReleaseID,
+		JobType:     job.Type,
+		JobState:    job.State,
+		JobFailures: []*ct.DeploymentJobFailure{f},
+	}
+}
+
 func (d *DeployJob) logJobEvent(job *ct.Job) error {
 	d.logger.Info(
 		"got job event",
@@ -0,0 +1,55 @@
+package deployment
+
+import (
+	"testing"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/inconshreveable/log15"
+)
+
+func TestRecordJobFailure(t *testing.T) {
+	events := make(chan ct.DeploymentEvent, 2)
+	d := &DeployJob{
+		Deployment:   &ct.Deployment{NewReleaseID: "release1"},
+		deployEvents: events,
+		logger:       log15.New(),
+	}
+	d.logger.SetHandler(log15.DiscardHandler())
+
+	status := int32(137)
+	d.recordJobFailure(&ct.Job{
+		ID:         "host0-job1",
+		HostID:     "host0",
+		Type:       "web",
+		State:      ct.JobStateDown,
+		ExitStatus: &status,
+		OOMKilled:  true,
+	})
+	d.recordJobFailure(&ct.Job{
+		UUID:              "job2",
+		HostID:            "host1",
+		Type:              "web",
+		State:             ct.JobStateDown,
+		HealthCheckFailed: true,
+	})
+
+	expected := []string{
+		"web job host0-job1 on host host0: exited with status 137, killed for exceeding its memory limit",
+		"web job job2 on host host1: never passed its service health check",
+	}
+	if len(d.jobFailures) != len(expected) {
+		t.Fatalf("expected %d recorded failures, got %d", len(expected), len(d.jobFailures))
+	}
+	for i, s := range expected {
+		if got := d.jobFailures[i].String(); got != s {
+			t.Fatalf("expected failure %q, got %q", s, got)
+		}
+		e := <-events
+		if e.ReleaseID != "release1" || e.JobType != "web" || e.JobState != ct.JobStateDown {
+			t.Fatalf("unexpected deployment event %+v", e)
+		}
+		if len(e.JobFailures) != 1 || e.JobFailures[0] != d.jobFailures[i] {
+			t.Fatalf("expected the event to include failure %d, got %+v", i, e.JobFailures)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package deployment
+
+import (
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	host "github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/pkg/cluster"
+	"github.com/inconshreveable/log15"
+)
+
+// usageWindow is how long before a deployment started the old release's
+// stats samples are included in its usage, hosts keep up to an hour of
+// samples for each job
+const usageWindow = time.Hour
+
+// recordOldReleaseUsage summarises the resource usage of the old release's
+// jobs which were running during the deployment and stores it with the
+// deployment, so it can guide sizing the next release.
+func (c *context) recordOldReleaseUsage(deployment *ct.Deployment, log log15.Logger) error {
+	if deployment.OldReleaseID == "" || deployment.CreatedAt == nil {
+		return nil
+	}
+	jobs, err := c.client.JobList(deployment.AppID)
+	if err != nil {
+		return err
+	}
+	since := deployment.CreatedAt.Add(-usageWindow)
+	var oldJobs []*ct.Job
+	samples := make(map[string][]*host.ContainerStats)
+	hosts := make(map[string]*cluster.Host)
+	clusterClient := cluster.NewClient()
+	for _, job := range jobs {
+		if job.ReleaseID != deployment.OldReleaseID || job.HostID == "" || job.ID == "" {
+			continue
+		}
+		if job.State != ct.JobStateUp && (job.UpdatedAt == nil || job.UpdatedAt.Before(*deployment.CreatedAt)) {
+			continue
+		}
+		h, ok := hosts[job.HostID]
+		if !ok {
+			h, err = clusterClient.Host(job.HostID)
+			if err != nil {
+				log.Warn("error getting host for job stats", "host.id", job.HostID, "err", err)
+			}
+			hosts[job.HostID] = h
+		}
+		if h == nil {
+			continue
+		}
+		stats, err := h.GetJobStatsHistory(job.ID, since)
+		if err != nil {
+			log.Warn("error getting job stats history", "job.id", job.ID, "err", err)
+			continue
+		}
+		oldJobs = append(oldJobs, job)
+		samples[job.ID] = stats
+	}
+	usage := summarizeUsage(oldJobs, samples)
+	if len(usage) == 0 {
+		return nil
+	}
+	return c.execWithRetries("deployment_update_old_release_usage", deployment.ID, usage)
+}
+
+// summarizeUsage computes the average and peak CPU and memory usage of jobs
+// by process type from their stats samples.
+func summarizeUsage(jobs []*ct.Job, samples map[string][]*host.ContainerStats) map[string]*ct.ProcessUsage {
+	type totals struct {
+		cpu        float64
+		cpuSamples int
+		memory     uint64
+	}
+	usage := make(map[string]*ct.ProcessUsage)
+	sums := make(map[string]*totals)
+	for _, job := range jobs {
+		stats := samples[job.ID]
+		if len(stats) == 0 {
+			continue
+		}
+		u, ok := usage[job.Type]
+		if !ok {
+			u = &ct.ProcessUsage{}
+			usage[job.Type] = u
+			sums[job.Type] = &totals{}
+		}
+		t := sums[job.Type]
+		u.Jobs++
+		for i, s := range stats {
+			u.Samples++
+			// the first sample has no previous sample to calculate
+			// the CPU percentage from
+			if i > 0 {
+				t.cpu += s.CPUUsagePercent
+				t.cpuSamples++
+				if s.CPUUsagePercent > u.CPUPeakPercent {
+					u.CPUPeakPercent = s.CPUUsagePercent
+				}
+			}
+			t.memory += s.MemoryUsageBytes
+			if s.MemoryUsageBytes > u.MemoryPeakBytes {
+				u.MemoryPeakBytes = s.MemoryUsageBytes
+			}
+			if s.MemoryMaxUsage > u.MemoryPeakBytes {
+				u.MemoryPeakBytes = s.MemoryMaxUsage
+			}
+			if s.MemoryLimitBytes > u.MemoryLimitBytes {
+				u.MemoryLimitBytes = s.MemoryLimitBytes
+			}
+		}
+	}
+	for typ, u := range usage {
+		t := sums[typ]
+		if t.cpuSamples > 0 {
+			u.CPUAvgPercent = t.cpu / float64(t.cpuSamples)
+		}
+		u.MemoryAvgBytes = t.memory / uint64(u.Samples)
+	}
+	return usage
+}
@@ -0,0 +1,55 @@
+package deployment
+
+import (
+	"testing"
+
+	ct "github.com/flynn/flynn/controller/types"
+	host "github.com/flynn/flynn/host/types"
+)
+
+func TestSummarizeUsage(t *testing.T) {
+	jobs := []*ct.Job{
+		{ID: "host0-web1", Type: "web"},
+		{ID: "host1-web2", Type: "web"},
+		{ID: "host0-worker1", Type: "worker"},
+		{ID: "host0-web3", Type: "web"},
+	}
+	samples := map[string][]*host.ContainerStats{
+		"host0-web1": {
+			{MemoryUsageBytes: 100, MemoryLimitBytes: 1000},
+			{CPUUsagePercent: 10, MemoryUsageBytes: 200, MemoryLimitBytes: 1000},
+			{CPUUsagePercent: 30, MemoryUsageBytes: 300, MemoryMaxUsage: 500, MemoryLimitBytes: 1000},
+		},
+		"host1-web2": {
+			{MemoryUsageBytes: 400, MemoryLimitBytes: 1000},
+			{CPUUsagePercent: 50, MemoryUsageBytes: 400, MemoryLimitBytes: 1000},
+		},
+		"host0-worker1": {
+			{MemoryUsageBytes: 50},
+		},
+	}
+
+	usage := summarizeUsage(jobs, samples)
+	if len(usage) != 2 {
+		t.Fatalf("expected usage for 2 process types, got %d", len(usage))
+	}
+	web := usage["web"]
+	expected := ct.ProcessUsage{
+		Jobs:             2,
+		Samples:          5,
+		CPUAvgPercent:    30,
+		CPUPeakPercent:   50,
+		MemoryAvgBytes:   280,
+		MemoryPeakBytes:  500,
+		MemoryLimitBytes: 1000,
+	}
+	if *web != expected {
+		t.Fatalf("unexpected web usage %+v", *web)
+	}
+
+	// jobs with a single sample have no CPU usage
+	worker := usage["worker"]
+	if worker.Jobs != 1 || worker.CPUAvgPercent != 0 || worker.MemoryAvgBytes != 50 || worker.MemoryLimitBytes != 0 {
+		t.Fatalf("unexpected worker usage %+v", *worker)
+	}
+}
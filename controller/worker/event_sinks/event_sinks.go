@@ -0,0 +1,312 @@
+// Package event_sinks implements a worker which forwards cluster-wide
+// controller events such as deployments, scale changes, route changes and
+// managed certificate failures to the configured event sinks.
+package event_sinks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flynn/flynn/controller/data"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/controller/worker/periodic"
+	"github.com/flynn/flynn/pkg/postgres"
+	router "github.com/flynn/flynn/router/types"
+	"github.com/flynn/que-go"
+	"github.com/inconshreveable/log15"
+)
+
+// JobType is the que job type of the event sink job.
+const JobType = "event_sinks"
+
+const (
+	// interval is how often new events are forwarded
+	interval = 10 * time.Second
+
+	// batchSize is the maximum number of events forwarded to each sink
+	// per run
+	batchSize = 100
+
+	// maxEventAge is how long after it was created delivery of an event
+	// is retried before it is dropped so that a broken endpoint does not
+	// hold up a sink forever
+	maxEventAge = time.Hour
+
+	// deliveryTimeout is the timeout for delivering a single event
+	deliveryTimeout = 10 * time.Second
+)
+
+// sinkRepo is the subset of data.EventSinkRepo used by the worker.
+type sinkRepo interface {
+	List() ([]*ct.EventSink, error)
+	UpdateCursor(id string, lastEventID int64, lastError string) error
+}
+
+// eventRepo is the subset of data.EventRepo used by the worker.
+type eventRepo interface {
+	ListEvents(appIDs, objectTypes, objectIDs []string, beforeID *int64, sinceID *int64, count int) ([]*ct.Event, error)
+}
+
+// appRepo is the subset of data.AppRepo used by the worker.
+type appRepo interface {
+	Get(id string) (interface{}, error)
+}
+
+type context struct {
+	db     *postgres.DB
+	sinks  sinkRepo
+	events eventRepo
+	apps   appRepo
+	client *http.Client
+	logger log15.Logger
+}
+
+func JobHandler(db *postgres.DB, logger log15.Logger) func(*que.Job) error {
+	return (&context{
+		db:     db,
+		sinks:  data.NewEventSinkRepo(db),
+		events: data.NewEventRepo(db),
+		apps:   data.NewAppRepo(db, "", nil),
+		client: &http.Client{Timeout: deliveryTimeout},
+		logger: logger,
+	}).HandleEventSinks
+}
+
+// Schedule enqueues the event sink job unless it is already queued, the job
+// then schedules its own subsequent runs.
+func Schedule(db *postgres.DB) error {
+	return periodic.Schedule(db, JobType)
+}
+
+func (c *context) HandleEventSinks(job *que.Job) error {
+	log := c.logger.New("fn", "HandleEventSinks", "job_id", job.ID)
+
+	// schedule the next run regardless of whether this one succeeds so
+	// that events continue to be forwarded after transient errors
+	defer func() {
+		if err := periodic.ScheduleNext(c.db, job, interval); err != nil {
+			log.Error("error scheduling next run", "err", err)
+		}
+	}()
+
+	sinks, err := c.sinks.List()
+	if err != nil {
+		log.Error("error listing event sinks", "err", err)
+		return nil
+	}
+	appNames := make(map[string]string)
+	for _, sink := range sinks {
+		if err := c.forward(sink, appNames, time.Now()); err != nil {
+			log.Error("error forwarding events", "event_sink.id", sink.ID, "err", err)
+		}
+	}
+	return nil
+}
+
+// forward delivers the events created since a sink last forwarded one, in
+// order, stopping at the first failed delivery so it is retried on the next
+// run.
+func (c *context) forward(sink *ct.EventSink, appNames map[string]string, now time.Time) error {
+	log := c.logger.New("fn", "forward", "event_sink.id", sink.ID)
+
+	types := make([]string, len(sink.EventTypes))
+	for i, typ := range sink.EventTypes {
+		types[i] = string(typ)
+	}
+	sinceID := sink.LastEventID
+	events, err := c.events.ListEvents(nil, types, nil, nil, &sinceID, batchSize)
+	if err != nil {
+		return err
+	}
+
+	lastEventID := sink.LastEventID
+	lastError := sink.LastError
+	// events are listed newest first
+	for i := len(events) - 1; i >= 0; i-- {
+		event := events[i]
+		payload, err := buildPayload(event, c.appName(event.AppID, appNames))
+		if err != nil {
+			log.Error("error building event payload", "event.id", event.ID, "err", err)
+		} else if payload != nil {
+			if err := c.deliver(sink, payload); err != nil {
+				if event.CreatedAt == nil || now.Sub(*event.CreatedAt) < maxEventAge {
+					lastError = err.Error()
+					break
+				}
+				log.Error("dropping event after repeated delivery failures", "event.id", event.ID, "err", err)
+				lastError = fmt.Sprintf("dropped event %d: %s", event.ID, err)
+			} else {
+				lastError = ""
+			}
+		}
+		lastEventID = event.ID
+	}
+
+	if lastEventID == sink.LastEventID && lastError == sink.LastError {
+		return nil
+	}
+	return c.sinks.UpdateCursor(sink.ID, lastEventID, lastError)
+}
+
+// appName returns the name of the app with the given ID, or the ID if the
+// app cannot be found.
+func (c *context) appName(id string, names map[string]string) string {
+	if id == "" {
+		return ""
+	}
+	if name, ok := names[id]; ok {
+		return name
+	}
+	name := id
+	if app, err := c.apps.Get(id); err == nil {
+		name = app.(*ct.App).Name
+	}
+	names[id] = name
+	return name
+}
+
+// deliver posts a payload to a sink in the sink's format.
+func (c *context) deliver(sink *ct.EventSink, payload *ct.EventSinkPayload) error {
+	var body interface{} = payload
+	if sink.Format == ct.EventSinkFormatSlack {
+		body = map[string]string{"text": payload.Summary}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	res, err := c.client.Post(sink.URL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// buildPayload returns the payload to deliver for an event, or nil if the
+// event is not one which is forwarded (e.g. a deployment which is still in
+// progress). Certificate keys are removed from the event data.
+func buildPayload(event *ct.Event, appName string) (*ct.EventSinkPayload, error) {
+	payload := &ct.EventSinkPayload{
+		ID:         event.ID,
+		AppID:      event.AppID,
+		AppName:    appName,
+		ObjectType: event.ObjectType,
+		ObjectID:   event.ObjectID,
+		Op:         event.Op,
+		Data:       event.Data,
+		CreatedAt:  event.CreatedAt,
+	}
+	app := ""
+	if appName != "" {
+		app = " for " + appName
+	}
+
+	var data interface{}
+	switch event.ObjectType {
+	case ct.EventTypeDeployment:
+		var e ct.DeploymentEvent
+		if err := json.Unmarshal(event.Data, &e); err != nil {
+			return nil, err
+		}
+		// only forward the outcome of deployments rather than the
+		// progress of each job
+		if e.JobType != "" {
+			return nil, nil
+		}
+		switch e.Status {
+		case "complete":
+			payload.Summary = fmt.Sprintf("Deployment of release %s%s complete", e.ReleaseID, app)
+		case "failed":
+			payload.Summary = fmt.Sprintf("Deployment of release %s%s failed: %s", e.ReleaseID, app, e.Error)
+		default:
+			return nil, nil
+		}
+	case ct.EventTypeScaleRequest:
+		var req ct.ScaleRequest
+		if err := json.Unmarshal(event.Data, &req); err != nil {
+			return nil, err
+		}
+		if req.State != ct.ScaleRequestStateComplete {
+			return nil, nil
+		}
+		payload.Summary = "Scaled " + appName
+		if appName == "" {
+			payload.Summary = "Scaled app " + event.AppID
+		}
+		if req.NewProcesses != nil {
+			payload.Summary += " to " + formatProcesses(*req.NewProcesses)
+		}
+	case ct.EventTypeRoute, ct.EventTypeRouteDeletion:
+		var route router.Route
+		if err := json.Unmarshal(event.Data, &route); err != nil {
+			return nil, err
+		}
+		if event.ObjectType == ct.EventTypeRoute {
+			payload.Summary = fmt.Sprintf("Route %s updated%s", formatRoute(&route), app)
+		} else {
+			payload.Summary = fmt.Sprintf("Route %s removed%s", formatRoute(&route), app)
+		}
+		route.Certificate = nil
+		route.LegacyTLSCert = ""
+		route.LegacyTLSKey = ""
+		data = &route
+	case ct.EventTypeManagedCertificate:
+		var cert ct.ManagedCertificate
+		if err := json.Unmarshal(event.Data, &cert); err != nil {
+			return nil, err
+		}
+		if cert.Status != ct.ManagedCertificateStatusFailed {
+			return nil, nil
+		}
+		payload.Summary = fmt.Sprintf("Managed certificate for %s failed", cert.Domain)
+		if cert.LastError != nil {
+			payload.Summary += ": " + *cert.LastError
+		}
+		cert.Cert = ""
+		cert.Key = ""
+		cert.Certificate = nil
+		data = &cert
+	default:
+		return nil, nil
+	}
+
+	if data != nil {
+		sanitized, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		payload.Data = sanitized
+	}
+	return payload, nil
+}
+
+// formatProcesses formats process counts like "web=2, worker=1".
+func formatProcesses(procs map[string]int) string {
+	types := make([]string, 0, len(procs))
+	for typ := range procs {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	counts := make([]string, len(types))
+	for i, typ := range types {
+		counts[i] = fmt.Sprintf("%s=%d", typ, procs[typ])
+	}
+	return strings.Join(counts, ", ")
+}
+
+// formatRoute formats a route like "http:example.com/path" or "tcp:2222".
+func formatRoute(route *router.Route) string {
+	if route.Type == "tcp" {
+		return fmt.Sprintf("tcp:%d", route.Port)
+	}
+	return route.Type + ":" + route.Domain + route.Path
+}
@@ -0,0 +1,146 @@
+package event_sinks
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	router "github.com/flynn/flynn/router/types"
+	"github.com/inconshreveable/log15"
+)
+
+type fakeSinkRepo struct {
+	sinks []*ct.EventSink
+}
+
+func (r *fakeSinkRepo) List() ([]*ct.EventSink, error) { return r.sinks, nil }
+
+func (r *fakeSinkRepo) UpdateCursor(id string, lastEventID int64, lastError string) error {
+	for _, sink := range r.sinks {
+		if sink.ID == id {
+			sink.LastEventID = lastEventID
+			sink.LastError = lastError
+		}
+	}
+	return nil
+}
+
+type fakeEventRepo struct {
+	events []*ct.Event
+}
+
+func (r *fakeEventRepo) ListEvents(appIDs, objectTypes, objectIDs []string, beforeID *int64, sinceID *int64, count int) ([]*ct.Event, error) {
+	var events []*ct.Event
+	for i := len(r.events) - 1; i >= 0; i-- {
+		if e := r.events[i]; e.ID > *sinceID {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+type fakeAppRepo struct{}
+
+func (fakeAppRepo) Get(id string) (interface{}, error) {
+	return &ct.App{ID: id, Name: "myapp"}, nil
+}
+
+func newEvent(id int64, typ ct.EventType, createdAt time.Time, data interface{}) *ct.Event {
+	d, _ := json.Marshal(data)
+	return &ct.Event{ID: id, AppID: "app1", ObjectType: typ, ObjectID: "obj", Data: d, CreatedAt: &createdAt}
+}
+
+func TestForward(t *testing.T) {
+	var mtx sync.Mutex
+	var bodies []string
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if s, ok := body["text"].(string); ok {
+			bodies = append(bodies, s)
+		} else if data, err := json.Marshal(body); err == nil {
+			bodies = append(bodies, string(data))
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	lastError := "connection refused"
+	events := &fakeEventRepo{events: []*ct.Event{
+		newEvent(1, ct.EventTypeDeployment, now, &ct.DeploymentEvent{ReleaseID: "r1", Status: "running", JobType: "web"}),
+		newEvent(2, ct.EventTypeDeployment, now, &ct.DeploymentEvent{ReleaseID: "r1", Status: "complete"}),
+		newEvent(3, ct.EventTypeScaleRequest, now, &ct.ScaleRequest{State: ct.ScaleRequestStatePending}),
+		newEvent(4, ct.EventTypeScaleRequest, now, &ct.ScaleRequest{State: ct.ScaleRequestStateComplete, NewProcesses: &map[string]int{"worker": 1, "web": 2}}),
+		newEvent(5, ct.EventTypeManagedCertificate, now, &ct.ManagedCertificate{Domain: "example.com", Status: ct.ManagedCertificateStatusIssued, Key: "secret-key"}),
+		newEvent(6, ct.EventTypeManagedCertificate, now, &ct.ManagedCertificate{Domain: "example.com", Status: ct.ManagedCertificateStatusFailed, LastError: &lastError, Key: "secret-key"}),
+		newEvent(7, ct.EventTypeRoute, now, &router.Route{Type: "http", Domain: "example.com", Path: "/api", Certificate: &router.Certificate{Key: "secret-key"}}),
+	}}
+	sinks := &fakeSinkRepo{sinks: []*ct.EventSink{
+		{ID: "slack", URL: srv.URL, Format: ct.EventSinkFormatSlack},
+		{ID: "json", URL: srv.URL, Format: ct.EventSinkFormatJSON, LastEventID: 5},
+	}}
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	c := &context{sinks: sinks, events: events, apps: fakeAppRepo{}, client: http.DefaultClient, logger: logger}
+
+	// only the outcomes of deployments, completed scale requests, route
+	// changes and certificate failures are forwarded
+	c.forward(sinks.sinks[0], make(map[string]string), now)
+	expected := []string{
+		"Deployment of release r1 for myapp complete",
+		"Scaled myapp to web=2, worker=1",
+		"Managed certificate for example.com failed: connection refused",
+		"Route http:example.com/api updated for myapp",
+	}
+	if strings.Join(bodies, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected slack messages:\n%s", strings.Join(bodies, "\n"))
+	}
+	if sinks.sinks[0].LastEventID != 7 || sinks.sinks[0].LastError != "" {
+		t.Fatalf("unexpected cursor %d %q", sinks.sinks[0].LastEventID, sinks.sinks[0].LastError)
+	}
+
+	// JSON payloads start after the sink's cursor and have certificate
+	// keys removed
+	bodies = nil
+	c.forward(sinks.sinks[1], make(map[string]string), now)
+	if len(bodies) != 2 {
+		t.Fatalf("expected 2 deliveries, got %d", len(bodies))
+	}
+	for _, body := range bodies {
+		if strings.Contains(body, "secret-key") {
+			t.Fatalf("expected certificate key to be removed from %s", body)
+		}
+	}
+	var payload ct.EventSinkPayload
+	if err := json.Unmarshal([]byte(bodies[0]), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.ID != 6 || payload.AppName != "myapp" || payload.ObjectType != ct.EventTypeManagedCertificate {
+		t.Fatalf("unexpected payload %+v", payload)
+	}
+
+	// failed deliveries are retried until the event is too old
+	status = http.StatusInternalServerError
+	events.events = append(events.events, newEvent(8, ct.EventTypeRouteDeletion, now, &router.Route{Type: "tcp", Port: 2222}))
+	bodies = nil
+	c.forward(sinks.sinks[0], make(map[string]string), now)
+	if len(bodies) != 1 || sinks.sinks[0].LastEventID != 7 || sinks.sinks[0].LastError != "unexpected status 500" {
+		t.Fatalf("unexpected state after failed delivery %d %q", sinks.sinks[0].LastEventID, sinks.sinks[0].LastError)
+	}
+	c.forward(sinks.sinks[0], make(map[string]string), now.Add(2*maxEventAge))
+	if sinks.sinks[0].LastEventID != 8 || !strings.HasPrefix(sinks.sinks[0].LastError, "dropped event 8") {
+		t.Fatalf("unexpected state after dropped event %d %q", sinks.sinks[0].LastEventID, sinks.sinks[0].LastError)
+	}
+	if bodies[0] != "Route tcp:2222 removed for myapp" {
+		t.Fatalf("unexpected message %q", bodies[0])
+	}
+}
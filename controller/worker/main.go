@@ -7,15 +7,22 @@ import (
 
 	"github.com/flynn/flynn/controller/client"
 	"github.com/flynn/flynn/controller/data"
+	"github.com/flynn/flynn/controller/secrets"
 	"github.com/flynn/flynn/controller/worker/app_deletion"
 	"github.com/flynn/flynn/controller/worker/app_garbage_collection"
+	"github.com/flynn/flynn/controller/worker/app_stats"
 	"github.com/flynn/flynn/controller/worker/deployment"
 	"github.com/flynn/flynn/controller/worker/domain_migration"
+	"github.com/flynn/flynn/controller/worker/event_sinks"
 	"github.com/flynn/flynn/controller/worker/release_cleanup"
+	"github.com/flynn/flynn/controller/worker/retention"
+	"github.com/flynn/flynn/controller/worker/review_apps"
+	"github.com/flynn/flynn/controller/worker/secret_leases"
 	"github.com/flynn/flynn/discoverd/client"
 	"github.com/flynn/flynn/pkg/postgres"
 	"github.com/flynn/flynn/pkg/shutdown"
 	"github.com/flynn/flynn/pkg/status"
+	"github.com/flynn/flynn/pkg/tracing"
 	"github.com/flynn/que-go"
 	"github.com/inconshreveable/log15"
 )
@@ -27,6 +34,10 @@ var logger = log15.New("app", "worker")
 func main() {
 	log := logger.New("fn", "main")
 
+	if tracing.Init("controller-worker") {
+		shutdown.BeforeExit(tracing.Flush)
+	}
+
 	log.Info("creating controller client")
 	client, err := controller.NewClient("", os.Getenv("AUTH_KEY"))
 	if err != nil {
@@ -34,6 +45,12 @@ func main() {
 		shutdown.Fatal(err)
 	}
 
+	retentionConfig, err := retention.ConfigFromEnv()
+	if err != nil {
+		log.Error("error loading retention config", "err", err)
+		shutdown.Fatal(err)
+	}
+
 	log.Info("connecting to postgres")
 	db := postgres.Wait(nil, data.PrepareStatements)
 
@@ -64,11 +81,37 @@ func main() {
 			"domain_migration":       domain_migration.JobHandler(db, client, logger),
 			"release_cleanup":        release_cleanup.JobHandler(db, client, logger),
 			"app_garbage_collection": app_garbage_collection.JobHandler(db, client, logger),
+			retention.JobType:        retention.JobHandler(db, retentionConfig, logger),
+			secret_leases.JobType:    secret_leases.JobHandler(db, secrets.FromEnv(), logger),
+			event_sinks.JobType:      event_sinks.JobHandler(db, logger),
+			review_apps.JobType:      review_apps.JobHandler(db, logger),
+			app_stats.JobType:        app_stats.JobHandler(db, logger),
 		},
 		workerCount,
 	)
 	workers.Interval = 5 * time.Second
 
+	if err := retention.Schedule(db); err != nil {
+		log.Error("error scheduling retention job", "err", err)
+		shutdown.Fatal(err)
+	}
+	if err := secret_leases.Schedule(db); err != nil {
+		log.Error("error scheduling secret lease job", "err", err)
+		shutdown.Fatal(err)
+	}
+	if err := event_sinks.Schedule(db); err != nil {
+		log.Error("error scheduling event sink job", "err", err)
+		shutdown.Fatal(err)
+	}
+	if err := review_apps.Schedule(db); err != nil {
+		log.Error("error scheduling review app job", "err", err)
+		shutdown.Fatal(err)
+	}
+	if err := app_stats.Schedule(db); err != nil {
+		log.Error("error scheduling app stats job", "err", err)
+		shutdown.Fatal(err)
+	}
+
 	log.Info("starting workers", "count", workerCount, "interval", workers.Interval)
 	workers.Start()
 	shutdown.BeforeExit(func() { workers.Shutdown() })
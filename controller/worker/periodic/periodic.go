@@ -0,0 +1,56 @@
+// Package periodic schedules que jobs which run at an interval by enqueuing
+// their own next run, making sure that at most one run of each job type is
+// queued even when several workers schedule it at the same time.
+package periodic
+
+import (
+	"time"
+
+	"github.com/flynn/flynn/pkg/postgres"
+	"github.com/flynn/que-go"
+)
+
+// Schedule enqueues a run of the given job type unless one is already queued,
+// and is called when the worker starts.
+func Schedule(db *postgres.DB, jobType string) error {
+	return schedule(db, &que.Job{Type: jobType}, 0)
+}
+
+// ScheduleNext enqueues the next run of job after interval unless another run
+// is already queued. It is called by the job's handler, so job itself, which
+// is deleted once the handler returns, is not counted.
+func ScheduleNext(db *postgres.DB, job *que.Job, interval time.Duration) error {
+	return schedule(db, &que.Job{Type: job.Type, RunAt: time.Now().Add(interval)}, job.ID)
+}
+
+// lockClass is the advisory lock class used with a hash of the job type,
+// the two key form keeping the locks apart from those que takes on job IDs
+const lockClass = 0x71756570 // "quep"
+
+func schedule(db *postgres.DB, job *que.Job, running int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	// hold a lock on the job type until the transaction commits so that
+	// a concurrent caller checks for queued runs only once this one has
+	// been inserted
+	if err := tx.Exec("SELECT pg_advisory_xact_lock($1, hashtext($2))", lockClass, job.Type); err != nil {
+		tx.Rollback()
+		return err
+	}
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM que_jobs WHERE job_class = $1 AND job_id <> $2)", job.Type, running).Scan(&exists); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if exists {
+		return tx.Rollback()
+	}
+	if err := que.NewClient(db.ConnPool).EnqueueInTx(job, tx.Tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,163 @@
+// Package retention implements a worker which periodically prunes events,
+// deployments and managed certificate error history older than configurable
+// retention windows.
+package retention
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/flynn/flynn/controller/data"
+	"github.com/flynn/flynn/controller/worker/periodic"
+	"github.com/flynn/flynn/pkg/postgres"
+	"github.com/flynn/que-go"
+	"github.com/inconshreveable/log15"
+)
+
+// JobType is the que job type of the retention job.
+const JobType = "retention"
+
+// Config configures the retention windows, a window of zero disables
+// pruning of the corresponding records.
+type Config struct {
+	Events            time.Duration
+	Deployments       time.Duration
+	CertificateErrors time.Duration
+
+	// Interval is how often the retention job runs
+	Interval time.Duration
+
+	// BatchSize is the maximum number of rows deleted per statement, and
+	// BatchPause the delay between batches
+	BatchSize  int
+	BatchPause time.Duration
+}
+
+var DefaultConfig = Config{
+	Events:            90 * 24 * time.Hour,
+	Deployments:       90 * 24 * time.Hour,
+	CertificateErrors: 30 * 24 * time.Hour,
+	Interval:          time.Hour,
+	BatchSize:         1000,
+	BatchPause:        100 * time.Millisecond,
+}
+
+// ConfigFromEnv returns DefaultConfig overridden by the EVENT_RETENTION,
+// DEPLOYMENT_RETENTION, CERTIFICATE_ERROR_RETENTION, RETENTION_INTERVAL,
+// RETENTION_BATCH_SIZE and RETENTION_BATCH_PAUSE environment variables.
+func ConfigFromEnv() (*Config, error) {
+	config := DefaultConfig
+	for _, d := range []struct {
+		env string
+		val *time.Duration
+	}{
+		{"EVENT_RETENTION", &config.Events},
+		{"DEPLOYMENT_RETENTION", &config.Deployments},
+		{"CERTIFICATE_ERROR_RETENTION", &config.CertificateErrors},
+		{"RETENTION_INTERVAL", &config.Interval},
+		{"RETENTION_BATCH_PAUSE", &config.BatchPause},
+	} {
+		s := os.Getenv(d.env)
+		if s == "" {
+			continue
+		}
+		v, err := time.ParseDuration(s)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("retention: invalid %s %q", d.env, s)
+		}
+		*d.val = v
+	}
+	if s := os.Getenv("RETENTION_BATCH_SIZE"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("retention: invalid RETENTION_BATCH_SIZE %q", s)
+		}
+		config.BatchSize = n
+	}
+	if config.Interval == 0 {
+		return nil, fmt.Errorf("retention: RETENTION_INTERVAL must be positive")
+	}
+	return &config, nil
+}
+
+type context struct {
+	db     *postgres.DB
+	repo   *data.RetentionRepo
+	config *Config
+	logger log15.Logger
+}
+
+func JobHandler(db *postgres.DB, config *Config, logger log15.Logger) func(*que.Job) error {
+	return (&context{
+		db:     db,
+		repo:   data.NewRetentionRepo(db),
+		config: config,
+		logger: logger,
+	}).HandleRetention
+}
+
+// Schedule enqueues the retention job unless it is already queued, the job
+// then schedules its own subsequent runs.
+func Schedule(db *postgres.DB) error {
+	return periodic.Schedule(db, JobType)
+}
+
+func (c *context) HandleRetention(job *que.Job) error {
+	log := c.logger.New("fn", "HandleRetention", "job_id", job.ID)
+	log.Info("pruning records")
+
+	// schedule the next run regardless of whether this one succeeds so
+	// that pruning continues after transient errors
+	defer func() {
+		if err := periodic.ScheduleNext(c.db, job, c.config.Interval); err != nil {
+			log.Error("error scheduling next run", "err", err)
+		}
+	}()
+
+	now := time.Now()
+	for _, p := range []struct {
+		name   string
+		window time.Duration
+		prune  func(time.Time, int) (int64, error)
+	}{
+		{"deployments", c.config.Deployments, c.repo.PruneDeployments},
+		{"events", c.config.Events, c.repo.PruneEvents},
+		{"certificate_errors", c.config.CertificateErrors, c.repo.PruneCertificateErrors},
+	} {
+		if p.window == 0 {
+			continue
+		}
+		count, err := c.prune(job, now.Add(-p.window), p.prune)
+		if err != nil {
+			log.Error("error pruning records", "type", p.name, "deleted", count, "err", err)
+			return nil
+		}
+		if count > 0 {
+			log.Info("pruned records", "type", p.name, "deleted", count)
+		}
+	}
+	return nil
+}
+
+// prune calls fn repeatedly until it deletes less than a full batch,
+// pausing between batches, and returns the total number of rows deleted.
+func (c *context) prune(job *que.Job, before time.Time, fn func(time.Time, int) (int64, error)) (int64, error) {
+	var total int64
+	for {
+		n, err := fn(before, c.config.BatchSize)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n < int64(c.config.BatchSize) {
+			return total, nil
+		}
+		select {
+		case <-job.Stop:
+			return total, nil
+		case <-time.After(c.config.BatchPause):
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package retention
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	setenv := func(env map[string]string) {
+		for k, v := range env {
+			os.Setenv(k, v)
+		}
+	}
+	unsetenv := func(env map[string]string) {
+		for k := range env {
+			os.Unsetenv(k)
+		}
+	}
+
+	config, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *config != DefaultConfig {
+		t.Fatalf("expected default config, got %+v", config)
+	}
+
+	env := map[string]string{
+		"EVENT_RETENTION":      "720h",
+		"DEPLOYMENT_RETENTION": "0",
+		"RETENTION_BATCH_SIZE": "50",
+	}
+	setenv(env)
+	config, err = ConfigFromEnv()
+	unsetenv(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Events != 720*time.Hour {
+		t.Fatalf("expected event retention of 720h, got %s", config.Events)
+	}
+	if config.Deployments != 0 {
+		t.Fatalf("expected deployment retention to be disabled, got %s", config.Deployments)
+	}
+	if config.BatchSize != 50 {
+		t.Fatalf("expected batch size 50, got %d", config.BatchSize)
+	}
+
+	for _, env := range []map[string]string{
+		{"EVENT_RETENTION": "90d"},
+		{"CERTIFICATE_ERROR_RETENTION": "-1h"},
+		{"RETENTION_BATCH_SIZE": "0"},
+		{"RETENTION_INTERVAL": "0"},
+	} {
+		setenv(env)
+		_, err := ConfigFromEnv()
+		unsetenv(env)
+		if err == nil {
+			t.Fatalf("expected error for %v", env)
+		}
+	}
+}
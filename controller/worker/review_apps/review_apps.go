@@ -0,0 +1,104 @@
+// Package review_apps implements a worker which deletes review apps along
+// with their resources once they expire.
+package review_apps
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/flynn/flynn/controller/data"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/controller/worker/periodic"
+	"github.com/flynn/flynn/pkg/postgres"
+	"github.com/flynn/que-go"
+	"github.com/inconshreveable/log15"
+)
+
+// JobType is the que job type of the review app job.
+const JobType = "review_apps"
+
+// interval is how often expired review apps are checked for
+const interval = time.Minute
+
+// reviewAppRepo is the subset of data.ReviewAppRepo used by the worker.
+type reviewAppRepo interface {
+	ListExpired(now time.Time) ([]*ct.ReviewApp, error)
+	Remove(appID string) error
+}
+
+// appRepo is the subset of data.AppRepo used by the worker.
+type appRepo interface {
+	Get(id string) (interface{}, error)
+}
+
+type context struct {
+	db      *postgres.DB
+	repo    reviewAppRepo
+	apps    appRepo
+	enqueue func(*que.Job) error
+	logger  log15.Logger
+}
+
+func JobHandler(db *postgres.DB, logger log15.Logger) func(*que.Job) error {
+	return (&context{
+		db:      db,
+		repo:    data.NewReviewAppRepo(db),
+		apps:    data.NewAppRepo(db, "", nil),
+		enqueue: que.NewClient(db.ConnPool).Enqueue,
+		logger:  logger,
+	}).HandleReviewApps
+}
+
+// Schedule enqueues the review app job unless it is already queued, the job
+// then schedules its own subsequent runs.
+func Schedule(db *postgres.DB) error {
+	return periodic.Schedule(db, JobType)
+}
+
+func (c *context) HandleReviewApps(job *que.Job) error {
+	log := c.logger.New("fn", "HandleReviewApps", "job_id", job.ID)
+
+	// schedule the next run regardless of whether this one succeeds so
+	// that review apps continue to be deleted after transient errors
+	defer func() {
+		if err := periodic.ScheduleNext(c.db, job, interval); err != nil {
+			log.Error("error scheduling next run", "err", err)
+		}
+	}()
+
+	if err := c.deleteExpired(time.Now()); err != nil {
+		log.Error("error listing expired review apps", "err", err)
+	}
+	return nil
+}
+
+// deleteExpired enqueues the deletion of review apps which have expired,
+// removing them first so they are only deleted once.
+func (c *context) deleteExpired(now time.Time) error {
+	expired, err := c.repo.ListExpired(now)
+	if err != nil {
+		return err
+	}
+	for _, r := range expired {
+		log := c.logger.New("fn", "deleteExpired", "app.id", r.AppID, "app.name", r.AppName, "parent_app.id", r.ParentAppID, "branch", r.Branch)
+		app, err := c.apps.Get(r.AppID)
+		if err != nil {
+			log.Error("error getting review app", "err", err)
+			continue
+		}
+		args, err := json.Marshal(app)
+		if err != nil {
+			log.Error("error encoding review app", "err", err)
+			continue
+		}
+		if err := c.repo.Remove(r.AppID); err != nil {
+			log.Error("error removing review app", "err", err)
+			continue
+		}
+		log.Info("deleting expired review app", "expired_at", r.ExpiresAt)
+		if err := c.enqueue(&que.Job{Type: "app_deletion", Args: args}); err != nil {
+			log.Error("error enqueuing review app deletion", "err", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package review_apps
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/que-go"
+	"github.com/inconshreveable/log15"
+)
+
+type fakeRepo struct {
+	apps    []*ct.ReviewApp
+	removed []string
+}
+
+func (r *fakeRepo) ListExpired(now time.Time) ([]*ct.ReviewApp, error) {
+	var expired []*ct.ReviewApp
+	for _, app := range r.apps {
+		if !app.ExpiresAt.After(now) {
+			expired = append(expired, app)
+		}
+	}
+	return expired, nil
+}
+
+func (r *fakeRepo) Remove(appID string) error {
+	r.removed = append(r.removed, appID)
+	return nil
+}
+
+type fakeApps map[string]*ct.App
+
+func (a fakeApps) Get(id string) (interface{}, error) {
+	app, ok := a[id]
+	if !ok {
+		return nil, ct.ErrNotFound
+	}
+	return app, nil
+}
+
+func TestDeleteExpired(t *testing.T) {
+	now := time.Now()
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+	repo := &fakeRepo{apps: []*ct.ReviewApp{
+		{AppID: "expired", ParentAppID: "parent", Branch: "old", ExpiresAt: at(-time.Minute)},
+		{AppID: "live", ParentAppID: "parent", Branch: "new", ExpiresAt: at(time.Hour)},
+		{AppID: "missing", ParentAppID: "parent", Branch: "gone", ExpiresAt: at(-time.Hour)},
+	}}
+	apps := fakeApps{
+		"expired": {ID: "expired", Name: "parent-old"},
+		"live":    {ID: "live", Name: "parent-new"},
+	}
+	var jobs []*que.Job
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	c := &context{
+		repo:    repo,
+		apps:    apps,
+		enqueue: func(job *que.Job) error { jobs = append(jobs, job); return nil },
+		logger:  logger,
+	}
+	if err := c.deleteExpired(now); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(repo.removed) != 1 || repo.removed[0] != "expired" {
+		t.Fatalf("unexpected removed review apps %v", repo.removed)
+	}
+	if len(jobs) != 1 || jobs[0].Type != "app_deletion" {
+		t.Fatalf("unexpected jobs %v", jobs)
+	}
+	var app ct.App
+	if err := json.Unmarshal(jobs[0].Args, &app); err != nil {
+		t.Fatal(err)
+	}
+	if app.ID != "expired" {
+		t.Fatalf("expected deletion of expired app, got %q", app.ID)
+	}
+}
@@ -0,0 +1,137 @@
+// Package secret_leases implements a worker which renews the leases on
+// secrets resolved for jobs while the jobs are running, and revokes them once
+// the jobs have stopped.
+package secret_leases
+
+import (
+	"time"
+
+	"github.com/flynn/flynn/controller/data"
+	"github.com/flynn/flynn/controller/secrets"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/controller/worker/periodic"
+	"github.com/flynn/flynn/pkg/postgres"
+	"github.com/flynn/que-go"
+	"github.com/inconshreveable/log15"
+)
+
+// JobType is the que job type of the secret lease job.
+const JobType = "secret_leases"
+
+const (
+	// interval is how often leases are checked
+	interval = time.Minute
+
+	// renewBefore is how long before they expire leases are renewed
+	renewBefore = 5 * time.Minute
+
+	// renewIncrement is the lease duration requested when renewing
+	renewIncrement = 15 * time.Minute
+
+	// orphanAge is how long after it was created a lease is revoked if
+	// its job was never persisted, for example because the job failed to
+	// start
+	orphanAge = 10 * time.Minute
+)
+
+// leaseRepo is the subset of data.SecretLeaseRepo used by the worker.
+type leaseRepo interface {
+	List() ([]*ct.SecretLease, error)
+	UpdateExpiry(id string, expiresAt time.Time) error
+	Remove(id string) error
+}
+
+type context struct {
+	db        *postgres.DB
+	repo      leaseRepo
+	providers secrets.Providers
+	logger    log15.Logger
+}
+
+func JobHandler(db *postgres.DB, providers secrets.Providers, logger log15.Logger) func(*que.Job) error {
+	return (&context{
+		db:        db,
+		repo:      data.NewSecretLeaseRepo(db),
+		providers: providers,
+		logger:    logger,
+	}).HandleSecretLeases
+}
+
+// Schedule enqueues the secret lease job unless it is already queued, the
+// job then schedules its own subsequent runs.
+func Schedule(db *postgres.DB) error {
+	return periodic.Schedule(db, JobType)
+}
+
+func (c *context) HandleSecretLeases(job *que.Job) error {
+	log := c.logger.New("fn", "HandleSecretLeases", "job_id", job.ID)
+
+	// schedule the next run regardless of whether this one succeeds so
+	// that leases continue to be renewed after transient errors
+	defer func() {
+		if err := periodic.ScheduleNext(c.db, job, interval); err != nil {
+			log.Error("error scheduling next run", "err", err)
+		}
+	}()
+
+	if err := c.handleLeases(time.Now()); err != nil {
+		log.Error("error listing secret leases", "err", err)
+	}
+	return nil
+}
+
+// handleLeases renews the leases of running jobs which expire soon and
+// revokes the leases of jobs which have stopped.
+func (c *context) handleLeases(now time.Time) error {
+	leases, err := c.repo.List()
+	if err != nil {
+		return err
+	}
+	for _, lease := range leases {
+		log := c.logger.New("fn", "handleLeases", "lease.id", lease.ID, "app.id", lease.AppID, "job.id", lease.JobID)
+		provider, ok := c.providers[lease.Provider]
+		switch {
+		case !ok:
+			log.Warn("removing lease from unknown secrets provider", "provider", lease.Provider)
+			c.remove(log, lease)
+		case lease.ExpiresAt.Before(now):
+			c.remove(log, lease)
+		case jobStopped(lease, now):
+			log.Info("revoking secret lease of stopped job")
+			if err := provider.Revoke(lease.ID); err != nil {
+				log.Error("error revoking secret lease", "err", err)
+				continue
+			}
+			c.remove(log, lease)
+		case lease.Renewable && lease.ExpiresAt.Sub(now) < renewBefore:
+			d, err := provider.Renew(lease.ID, renewIncrement)
+			if err != nil {
+				log.Error("error renewing secret lease", "err", err)
+				continue
+			}
+			if err := c.repo.UpdateExpiry(lease.ID, now.Add(d)); err != nil {
+				log.Error("error updating secret lease", "err", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *context) remove(log log15.Logger, lease *ct.SecretLease) {
+	if err := c.repo.Remove(lease.ID); err != nil {
+		log.Error("error removing secret lease", "err", err)
+	}
+}
+
+// jobStopped returns whether the job a lease is for has stopped, or was never
+// persisted long after the lease was created.
+func jobStopped(lease *ct.SecretLease, now time.Time) bool {
+	switch lease.JobState {
+	case ct.JobStateDown, ct.JobStateCrashed, ct.JobStateFailed:
+		return true
+	case "":
+		return lease.CreatedAt != nil && now.Sub(*lease.CreatedAt) > orphanAge
+	default:
+		return false
+	}
+}
@@ -0,0 +1,90 @@
+package secret_leases
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flynn/flynn/controller/secrets"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/inconshreveable/log15"
+)
+
+type fakeRepo struct {
+	leases  []*ct.SecretLease
+	expiry  map[string]time.Time
+	removed []string
+}
+
+func (r *fakeRepo) List() ([]*ct.SecretLease, error) { return r.leases, nil }
+
+func (r *fakeRepo) UpdateExpiry(id string, expiresAt time.Time) error {
+	r.expiry[id] = expiresAt
+	return nil
+}
+
+func (r *fakeRepo) Remove(id string) error {
+	r.removed = append(r.removed, id)
+	return nil
+}
+
+type fakeProvider struct {
+	renewed []string
+	revoked []string
+}
+
+func (p *fakeProvider) Read(path string) (*secrets.Secret, error) { return nil, nil }
+
+func (p *fakeProvider) Renew(leaseID string, increment time.Duration) (time.Duration, error) {
+	p.renewed = append(p.renewed, leaseID)
+	return increment, nil
+}
+
+func (p *fakeProvider) Revoke(leaseID string) error {
+	p.revoked = append(p.revoked, leaseID)
+	return nil
+}
+
+func TestHandleLeases(t *testing.T) {
+	now := time.Now()
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+	repo := &fakeRepo{
+		expiry: make(map[string]time.Time),
+		leases: []*ct.SecretLease{
+			{ID: "expiring", Provider: "vault", JobState: ct.JobStateUp, Renewable: true, ExpiresAt: at(time.Minute), CreatedAt: at(-time.Hour)},
+			{ID: "not-renewable", Provider: "vault", JobState: ct.JobStateUp, ExpiresAt: at(time.Minute), CreatedAt: at(-time.Hour)},
+			{ID: "fresh", Provider: "vault", JobState: ct.JobStateUp, Renewable: true, ExpiresAt: at(time.Hour), CreatedAt: at(-time.Hour)},
+			{ID: "stopped", Provider: "vault", JobState: ct.JobStateDown, Renewable: true, ExpiresAt: at(time.Hour), CreatedAt: at(-time.Hour)},
+			{ID: "starting", Provider: "vault", Renewable: true, ExpiresAt: at(time.Hour), CreatedAt: at(-time.Minute)},
+			{ID: "orphan", Provider: "vault", Renewable: true, ExpiresAt: at(time.Hour), CreatedAt: at(-time.Hour)},
+			{ID: "expired", Provider: "vault", JobState: ct.JobStateUp, Renewable: true, ExpiresAt: at(-time.Minute), CreatedAt: at(-time.Hour)},
+			{ID: "unknown", Provider: "other", JobState: ct.JobStateUp, ExpiresAt: at(time.Hour), CreatedAt: at(-time.Hour)},
+		},
+	}
+	provider := &fakeProvider{}
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	c := &context{repo: repo, providers: secrets.Providers{"vault": provider}, logger: logger}
+	if err := c.handleLeases(now); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(provider.renewed, ",") != "expiring" {
+		t.Fatalf("unexpected renewed leases %v", provider.renewed)
+	}
+	if !repo.expiry["expiring"].Equal(now.Add(renewIncrement)) {
+		t.Fatalf("unexpected expiry %s", repo.expiry["expiring"])
+	}
+	sort.Strings(provider.revoked)
+	if strings.Join(provider.revoked, ",") != "orphan,stopped" {
+		t.Fatalf("unexpected revoked leases %v", provider.revoked)
+	}
+	sort.Strings(repo.removed)
+	if strings.Join(repo.removed, ",") != "expired,orphan,stopped,unknown" {
+		t.Fatalf("unexpected removed leases %v", repo.removed)
+	}
+}
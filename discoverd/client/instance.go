@@ -9,6 +9,16 @@ import (
 	"sync"
 )
 
+// HealthMetaKey is the instance metadata key used to report that an
+// instance's health check is failing. It is set to HealthStatusFailing by
+// the health registrar as soon as a check fails, before enough consecutive
+// failures have accumulated to unregister the instance, and is removed once
+// the check passes again.
+const HealthMetaKey = "DISCOVERD_HEALTH"
+
+// HealthStatusFailing is the HealthMetaKey value of a failing instance.
+const HealthStatusFailing = "failing"
+
 type EventKind uint
 
 const (
@@ -121,6 +131,11 @@ func (inst *Instance) Equal(other *Instance) bool {
 		mapEqual(inst.Meta, other.Meta)
 }
 
+// Failing returns whether the instance has reported a failing health check.
+func (inst *Instance) Failing() bool {
+	return inst.Meta[HealthMetaKey] == HealthStatusFailing
+}
+
 func (inst *Instance) Valid() error {
 	if err := inst.validProto(); err != nil {
 		return err
@@ -0,0 +1,210 @@
+package discoverd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/flynn/flynn/pkg/stream"
+)
+
+// MetaEvent is a typed event describing a change to a service's metadata,
+// as sent by WatchMeta. It is one of *LeaderEvent, *ServiceMetaEvent,
+// *InstanceMetaEvent or *MetaCurrentEvent.
+type MetaEvent interface {
+	ServiceName() string
+}
+
+// LeaderEvent is sent when the leader of a service changes. Leader is nil if
+// the service has no leader, for example if there are no instances
+// currently registered.
+type LeaderEvent struct {
+	Service string
+	Leader  *Instance
+}
+
+// ServiceMetaEvent is sent when the service metadata is set.
+type ServiceMetaEvent struct {
+	Service string
+	Meta    *ServiceMeta
+}
+
+// InstanceMetaEvent is sent when the metadata of a registered instance is
+// updated.
+type InstanceMetaEvent struct {
+	Service  string
+	Instance *Instance
+}
+
+// MetaCurrentEvent is sent once the current leader and service metadata have
+// been sent after connecting.
+type MetaCurrentEvent struct {
+	Service string
+}
+
+func (e *LeaderEvent) ServiceName() string       { return e.Service }
+func (e *ServiceMetaEvent) ServiceName() string  { return e.Service }
+func (e *InstanceMetaEvent) ServiceName() string { return e.Service }
+func (e *MetaCurrentEvent) ServiceName() string  { return e.Service }
+
+// MetaEventKinds are the event kinds streamed from the service meta
+// endpoint.
+const MetaEventKinds = EventKindLeader | EventKindServiceMeta | EventKindUpdate | EventKindCurrent
+
+// newMetaEvent converts a raw event into a typed MetaEvent, returning nil if
+// the event is not a metadata event.
+func newMetaEvent(e *Event) MetaEvent {
+	switch e.Kind {
+	case EventKindLeader:
+		return &LeaderEvent{Service: e.Service, Leader: e.Instance}
+	case EventKindServiceMeta:
+		return &ServiceMetaEvent{Service: e.Service, Meta: e.ServiceMeta}
+	case EventKindUpdate:
+		return &InstanceMetaEvent{Service: e.Service, Instance: e.Instance}
+	case EventKindCurrent:
+		return &MetaCurrentEvent{Service: e.Service}
+	default:
+		return nil
+	}
+}
+
+// WatchMeta streams typed leader, service metadata and instance metadata
+// events for the service to the given channel.
+//
+// Like Watch, it reconnects to the server on error. On reconnect, the
+// current leader and service metadata are only sent if they differ from the
+// most recently sent values.
+func (s *service) WatchMeta(ch chan MetaEvent) (stream.Stream, error) {
+	var events chan *Event
+	var stream stream.Stream
+	watch := NewWatch()
+	connect := func() (err error) {
+		events = make(chan *Event)
+		stream, err = s.client.Stream("GET", fmt.Sprintf("/services/%s/meta", s.name), nil, events)
+		if err != nil {
+			return err
+		}
+		watch.maybeSendState(WatchStateConnected)
+		return nil
+	}
+	if err := connect(); err != nil {
+		close(ch)
+		return nil, err
+	}
+	go func() {
+		defer func() { stream.Close() }()
+		defer close(ch)
+		isCurrent := false
+		sentLeader := false
+
+		for {
+			select {
+			case <-watch.done:
+				return
+			case event, ok := <-events:
+				if !ok {
+					isCurrent = false
+					watch.maybeSendState(WatchStateDisconnected)
+					if err := connectAttempts.Run(connect); err != nil {
+						watch.err = err
+						return
+					}
+					continue
+				}
+				switch event.Kind {
+				case EventKindCurrent:
+					isCurrent = true
+				case EventKindLeader:
+					// don't send duplicate leader events on reconnect
+					if !isCurrent && sentLeader && sameInstance(watch.leader, event.Instance) {
+						continue
+					}
+					watch.leader = event.Instance
+					sentLeader = true
+				case EventKindServiceMeta:
+					// don't send duplicate service meta events on reconnect
+					if !isCurrent && watch.serviceMeta != nil && event.ServiceMeta != nil && watch.serviceMeta.Index == event.ServiceMeta.Index {
+						continue
+					}
+					watch.serviceMeta = event.ServiceMeta
+				}
+				e := newMetaEvent(event)
+				if e == nil {
+					continue
+				}
+				select {
+				case ch <- e:
+				case <-watch.done:
+					return
+				}
+			}
+		}
+	}()
+	return watch, nil
+}
+
+func sameInstance(a, b *Instance) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.ID == b.ID
+}
+
+// followReconnectDelay is how long FollowLeader waits before reconnecting
+// after the metadata stream fails.
+var followReconnectDelay = 100 * time.Millisecond
+
+// FollowLeader sends the leader of the given service to the leaders channel
+// each time it changes, ignoring periods where the service has no leader.
+// Unlike Leaders and WatchMeta it never gives up reconnecting to the server,
+// making it suitable for components which need to track leadership for as
+// long as they are running. The leaders channel is closed when the returned
+// stream is closed.
+func FollowLeader(s Service, leaders chan *Instance) stream.Stream {
+	stream := stream.New()
+	go func() {
+		defer close(leaders)
+		var current *Instance
+		for {
+			events := make(chan MetaEvent)
+			metaStream, err := s.WatchMeta(events)
+			if err != nil {
+				select {
+				case <-stream.StopCh:
+					return
+				case <-time.After(followReconnectDelay):
+					continue
+				}
+			}
+		inner:
+			for {
+				select {
+				case event, ok := <-events:
+					if !ok {
+						break inner
+					}
+					e, ok := event.(*LeaderEvent)
+					if !ok || e.Leader == nil || sameInstance(current, e.Leader) {
+						continue
+					}
+					current = e.Leader
+					select {
+					case leaders <- e.Leader:
+					case <-stream.StopCh:
+						metaStream.Close()
+						return
+					}
+				case <-stream.StopCh:
+					metaStream.Close()
+					return
+				}
+			}
+			metaStream.Close()
+			select {
+			case <-stream.StopCh:
+				return
+			case <-time.After(followReconnectDelay):
+			}
+		}
+	}()
+	return stream
+}
@@ -15,6 +15,7 @@ type Service interface {
 	Addrs() ([]string, error)
 	Leaders(chan *Instance) (stream.Stream, error)
 	Watch(events chan *Event) (stream.Stream, error)
+	WatchMeta(events chan MetaEvent) (stream.Stream, error)
 	GetMeta() (*ServiceMeta, error)
 	SetMeta(*ServiceMeta) error
 	SetLeader(string) error
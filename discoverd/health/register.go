@@ -1,6 +1,7 @@
 package health
 
 import (
+	"fmt"
 	"sync"
 	"time"
 
@@ -26,7 +27,7 @@ type Registration struct {
 func (r *Registration) Register() discoverd.Heartbeater {
 	events := make(chan MonitorEvent)
 	hb := &heartbeater{Registration: r}
-	hb.stream = r.Monitor(r.Check, events)
+	hb.stream = r.Monitor(&reportingCheck{check: r.Check, hb: hb}, events)
 	if r.Logger != nil {
 		hb.l = r.Logger.New("component", "registrar", "addr", r.Instance.Addr)
 	}
@@ -38,11 +39,58 @@ type heartbeater struct {
 	stream stream.Stream
 
 	sync.Mutex
-	hb discoverd.Heartbeater
-	l  log15.Logger
+	hb      discoverd.Heartbeater
+	l       log15.Logger
+	failing bool
 	*Registration
 }
 
+// reportingCheck wraps a Check to report the result of each check in the
+// instance metadata, so that an instance which has started failing is
+// marked as such in discoverd before the monitor has seen enough
+// consecutive failures to unregister it.
+type reportingCheck struct {
+	check Check
+	hb    *heartbeater
+}
+
+func (c *reportingCheck) Check() error {
+	err := c.check.Check()
+	c.hb.setFailing(err != nil)
+	return err
+}
+
+func (c *reportingCheck) String() string {
+	return fmt.Sprint(c.check)
+}
+
+// setFailing updates the health metadata of the registered instance if the
+// failing state has changed.
+func (h *heartbeater) setFailing(failing bool) {
+	h.Lock()
+	defer h.Unlock()
+	if h.failing == failing {
+		return
+	}
+	h.failing = failing
+	meta := make(map[string]string, len(h.Instance.Meta)+1)
+	for k, v := range h.Instance.Meta {
+		meta[k] = v
+	}
+	if failing {
+		meta[discoverd.HealthMetaKey] = discoverd.HealthStatusFailing
+	} else {
+		delete(meta, discoverd.HealthMetaKey)
+	}
+	h.Instance.Meta = meta
+	if h.hb == nil {
+		return
+	}
+	if err := h.hb.SetMeta(meta); err != nil && h.l != nil {
+		h.l.Error("error updating health metadata", "failing", failing, "err", err)
+	}
+}
+
 func (h *heartbeater) run(events chan MonitorEvent) {
 	var stopRegister chan struct{}
 	for e := range events {
@@ -120,6 +168,14 @@ func (h *heartbeater) Addr() string {
 func (h *heartbeater) SetMeta(meta map[string]string) error {
 	h.Lock()
 	defer h.Unlock()
+	if h.failing {
+		m := make(map[string]string, len(meta)+1)
+		for k, v := range meta {
+			m[k] = v
+		}
+		m[discoverd.HealthMetaKey] = discoverd.HealthStatusFailing
+		meta = m
+	}
 	h.Instance.Meta = meta
 	if h.hb == nil {
 		return nil
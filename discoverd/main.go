@@ -57,10 +57,11 @@ type Main struct {
 	hb         discoverd.Heartbeater
 	mux        *mux.Mux
 
-	advertiseAddr string
-	dataDir       string
-	handler       *server.Handler
-	peers         []string
+	advertiseAddr     string
+	dataDir           string
+	dnsIncludeFailing bool
+	handler           *server.Handler
+	peers             []string
 
 	logger *log.Logger
 
@@ -101,6 +102,7 @@ func (m *Main) Run(args ...string) error {
 		return fmt.Errorf("set port slice: %s", err)
 	}
 	m.peers = httpPeers
+	m.dnsIncludeFailing = opt.DNSIncludeFailing
 
 	// Initialise the default client using the peer list
 	os.Setenv("DISCOVERD", strings.Join(opt.Peers, ","))
@@ -468,9 +470,10 @@ func (m *Main) openStore() error {
 // The store must already be open.
 func (m *Main) openDNSServer(addr string, recursors []string) error {
 	s := &server.DNSServer{
-		UDPAddr:   addr,
-		TCPAddr:   addr,
-		Recursors: recursors,
+		UDPAddr:        addr,
+		TCPAddr:        addr,
+		Recursors:      recursors,
+		IncludeFailing: m.dnsIncludeFailing,
 	}
 
 	// If store is available then attach it. Otherwise use a proxy.
@@ -569,6 +572,7 @@ func (m *Main) ParseFlags(args ...string) (Options, error) {
 	fs.StringVar(&opt.Addr, "addr", ":1111", "address to serve http and raft from")
 	fs.StringVar(&opt.DNSAddr, "dns-addr", "", "address to service DNS from")
 	fs.StringVar(&recursors, "recursors", "", "upstream recursive DNS servers")
+	fs.BoolVar(&opt.DNSIncludeFailing, "dns-include-failing", false, "include instances failing their health checks in DNS address responses")
 	fs.StringVar(&opt.Notify, "notify", "", "url to send webhook to after starting listener")
 	fs.BoolVar(&opt.WaitNetDNS, "wait-net-dns", false, "start DNS server after host network is configured")
 	if err := fs.Parse(args); err != nil {
@@ -605,6 +609,8 @@ type Options struct {
 	Recursors  []string // dns recursors
 	Notify     string   // notify URL
 	WaitNetDNS bool     // wait for the network DNS
+
+	DNSIncludeFailing bool // include failing instances in DNS responses
 }
 
 // TrimSpaceSlice returns a new slice of trimmed strings.
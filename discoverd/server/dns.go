@@ -27,6 +27,10 @@ type DNSServer struct {
 	Domain    string
 	Recursors []string
 
+	// IncludeFailing disables omitting instances with a failing health
+	// check from address record responses.
+	IncludeFailing bool
+
 	store   atomic.Value // *DNSStore
 	servers []*dns.Server
 }
@@ -283,6 +287,12 @@ func (d dnsAPI) ServiceLookup(w dns.ResponseWriter, req *dns.Msg) {
 		return
 	}
 
+	if qType != dns.TypeSRV && !d.IncludeFailing {
+		// don't hand out addresses of instances which are failing their
+		// health checks
+		instances = healthyInstances(instances)
+	}
+
 	addrs := make([]*addrData, 0, len(instances))
 	added := make(map[string]struct{}, len(instances))
 	for _, inst := range instances {
@@ -335,6 +345,22 @@ func (d dnsAPI) ServiceLookup(w dns.ResponseWriter, req *dns.Msg) {
 	}
 }
 
+// healthyInstances returns the instances which are not failing their health
+// checks. If every instance is failing then they are all returned, since a
+// possibly unhealthy address is more useful to a client than none.
+func healthyInstances(instances []*discoverd.Instance) []*discoverd.Instance {
+	healthy := make([]*discoverd.Instance, 0, len(instances))
+	for _, inst := range instances {
+		if !inst.Failing() {
+			healthy = append(healthy, inst)
+		}
+	}
+	if len(healthy) == 0 {
+		return instances
+	}
+	return healthy
+}
+
 func (d dnsAPI) soaRecord() dns.RR {
 	return &dns.SOA{
 		Hdr: dns.RR_Header{
@@ -209,6 +209,18 @@ func (s *DNSSuite) TestServiceLookup(c *C) {
 	copy(dupeAddrs, simpleAddrs)
 	dupeData[1], dupeAddrs[1] = fakeStaticInstance("tcp", "192.168.0.1", 85)
 
+	failingData := make([]*discoverd.Instance, 3)
+	failingAddrs := make([]testAddr, 3)
+	copy(failingData, simpleData)
+	copy(failingAddrs, simpleAddrs)
+	failingData[0], failingAddrs[0] = fakeStaticInstance("tcp", "192.168.0.6", 86)
+	failingData[0].Meta = map[string]string{discoverd.HealthMetaKey: discoverd.HealthStatusFailing}
+
+	allFailingData := make([]*discoverd.Instance, 1)
+	allFailingAddrs := make([]testAddr, 1)
+	allFailingData[0], allFailingAddrs[0] = fakeStaticInstance("tcp", "192.168.0.7", 87)
+	allFailingData[0].Meta = map[string]string{discoverd.HealthMetaKey: discoverd.HealthStatusFailing}
+
 	emptyAll := map[uint16][]testAddr{
 		dns.TypeA:    nil,
 		dns.TypeAAAA: nil,
@@ -281,6 +293,22 @@ func (s *DNSSuite) TestServiceLookup(c *C) {
 		dns.TypeSOA:  nil,
 		dns.TypeTXT:  nil,
 	}
+	failingQs := map[uint16][]testAddr{
+		dns.TypeA:    failingAddrs[1:],
+		dns.TypeAAAA: nil,
+		dns.TypeANY:  failingAddrs[1:],
+		dns.TypeSRV:  failingAddrs,
+		dns.TypeSOA:  nil,
+		dns.TypeTXT:  nil,
+	}
+	allFailingQs := map[uint16][]testAddr{
+		dns.TypeA:    allFailingAddrs,
+		dns.TypeAAAA: nil,
+		dns.TypeANY:  allFailingAddrs,
+		dns.TypeSRV:  allFailingAddrs,
+		dns.TypeSOA:  nil,
+		dns.TypeTXT:  nil,
+	}
 	leaderQs := map[uint16][]testAddr{
 		dns.TypeA:    simpleAddrs[:1],
 		dns.TypeAAAA: nil,
@@ -416,6 +444,18 @@ func (s *DNSSuite) TestServiceLookup(c *C) {
 			data:   dupeData,
 			qs:     dupeQs,
 		},
+		{
+			name:   "service with failing health check",
+			domain: "a.discoverd.",
+			data:   failingData,
+			qs:     failingQs,
+		},
+		{
+			name:   "service all failing health checks",
+			domain: "a.discoverd.",
+			data:   allFailingData,
+			qs:     allFailingQs,
+		},
 		{
 			name:   "leader",
 			domain: "leader.a.discoverd.",
@@ -256,8 +256,15 @@ func (h *Handler) servePutServiceMeta(w http.ResponseWriter, r *http.Request, pa
 	hh.JSON(w, 200, meta)
 }
 
-// serveGetServiceMeta returns the metadata for a service.
+// serveGetServiceMeta returns the metadata for a service, or streams leader,
+// service metadata and instance metadata changes if requested.
 func (h *Handler) serveGetServiceMeta(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	// Process as a stream if that's what the client wants.
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		h.serveStream(w, params, discoverd.MetaEventKinds)
+		return
+	}
+
 	// Read path parameter.
 	service := params.ByName("service")
 
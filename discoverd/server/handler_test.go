@@ -320,6 +320,39 @@ func TestHandler_GetServiceMeta(t *testing.T) {
 	}
 }
 
+// Ensure the handler can stream metadata events from a service.
+func TestHandler_GetServiceMeta_Stream(t *testing.T) {
+	h := NewHandler()
+	h.Store.SubscribeFn = func(service string, sendCurrent bool, kinds discoverd.EventKind, ch chan *discoverd.Event) stream.Stream {
+		if service != "abc" {
+			t.Fatalf("unexpected service: %s", service)
+		} else if sendCurrent != true {
+			t.Fatalf("unexpected send current: %v", sendCurrent)
+		} else if kinds != discoverd.MetaEventKinds {
+			t.Fatalf("unexpected kinds: %d", kinds)
+		}
+
+		// Send an event back to the stream.
+		ch <- &discoverd.Event{
+			Service:     service,
+			Kind:        discoverd.EventKindServiceMeta,
+			ServiceMeta: &discoverd.ServiceMeta{Index: 12, Data: json.RawMessage(`{"foo":"bar"}`)},
+		}
+		close(ch)
+		return chanStream(ch)
+	}
+
+	w := httptest.NewRecorder()
+	r := MustNewHTTPRequest("GET", "/services/abc/meta", nil)
+	r.Header.Set("Accept", "text/event-stream")
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", w.Code)
+	} else if w.Body.String() != `data: {"service":"abc","kind":"service_meta","service_meta":{"data":{"foo":"bar"},"index":12}}`+"\n\n" {
+		t.Fatalf("unexpected body: %s", w.Body.String())
+	}
+}
+
 // Ensure the handler returns an error if the service cannot be found.
 func TestHandler_GetServiceMeta_ErrNotFound(t *testing.T) {
 	h := NewHandler()
@@ -68,11 +68,14 @@ func run() error {
 	}
 
 	usage := `
-Usage: flynn-receiver <app> <rev> [-e <var>=<val>]... [-m <key>=<val>]...
+Usage: flynn-receiver [--review-app=<branch>] <app> <rev> [-e <var>=<val>]... [-m <key>=<val>]...
+       flynn-receiver --delete-review-app=<branch> <app>
 
 Options:
 	-e,--env <var>=<val>
 	-m,--meta <key>=<val>
+	--review-app=<branch>         deploy to the review app of <branch>, creating it if necessary
+	--delete-review-app=<branch>  delete the review app of <branch>
 `[1:]
 	args, _ := docopt.Parse(usage, nil, true, version.String(), false)
 
@@ -92,6 +95,31 @@ Options:
 	} else if err != nil {
 		return fmt.Errorf("Error retrieving app: %s", err)
 	}
+
+	if branch := args.String["--delete-review-app"]; branch != "" {
+		fmt.Printf("-----> Deleting review app for branch %s...\n", branch)
+		reviewApp, err := client.DeleteReviewApp(app.ID, branch)
+		if err == controller.ErrNotFound {
+			fmt.Println("=====> No review app to delete")
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("Error deleting review app: %s", err)
+		}
+		fmt.Printf("=====> Review app %s deleted\n", reviewApp.AppName)
+		return nil
+	}
+
+	var reviewApp *ct.ReviewApp
+	if branch := args.String["--review-app"]; branch != "" {
+		reviewApp, err = client.CreateReviewApp(app.ID, &ct.ReviewAppRequest{Branch: branch})
+		if err != nil {
+			return fmt.Errorf("Error creating review app: %s", err)
+		}
+		fmt.Printf("-----> Deploying branch %s to review app %s (expires %s)\n", branch, reviewApp.AppName, reviewApp.ExpiresAt.Format(time.RFC1123))
+		if app, err = client.GetApp(reviewApp.AppID); err != nil {
+			return fmt.Errorf("Error retrieving review app: %s", err)
+		}
+	}
 	prevRelease, err := client.GetAppRelease(app.Name)
 	if err == controller.ErrNotFound {
 		prevRelease = &ct.Release{}
@@ -293,6 +321,13 @@ Options:
 	}
 
 	fmt.Println("=====> Application deployed")
+	if reviewApp != nil {
+		for _, route := range reviewApp.Routes {
+			if route.Type == "http" {
+				fmt.Printf("=====> Review app available at http://%s%s\n", route.Domain, route.Path)
+			}
+		}
+	}
 	return nil
 }
 
@@ -25,6 +25,7 @@ import (
 
 	"github.com/flynn/flynn/controller/authorizer"
 	controller "github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/flynn/controller/utils"
 	"github.com/flynn/flynn/pkg/archiver"
 	"github.com/flynn/flynn/pkg/ctxhelper"
@@ -69,6 +70,10 @@ type gitService struct {
 
 type gitEnv struct {
 	App string
+
+	// ReviewApps is whether pushes to branches other than master or main
+	// are deployed to review apps
+	ReviewApps bool
 }
 
 // Routing table
@@ -129,7 +134,8 @@ func (h *gitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	defer os.RemoveAll(repoPath)
 
-	success := g.handleFunc(gitEnv{App: app.ID}, g.rpc, repoPath, w, r)
+	env := gitEnv{App: app.ID, ReviewApps: app.Meta[ct.ReviewAppsMetaKey] == "true"}
+	success := g.handleFunc(env, g.rpc, repoPath, w, r)
 	if success && g.rpc == "git-receive-pack" {
 		if err := uploadRepo(repoPath, app.ID); err != nil {
 			logError(w, "uploadRepo", err)
@@ -252,6 +258,9 @@ func gitCommand(env gitEnv, name string, args ...string) (*exec.Cmd, io.Reader)
 	cmd.Env = append(os.Environ(),
 		fmt.Sprintf("RECEIVE_APP=%s", env.App),
 	)
+	if env.ReviewApps {
+		cmd.Env = append(cmd.Env, "RECEIVE_REVIEW_APPS=true")
+	}
 
 	r, _ := cmd.StdoutPipe()
 	cmd.Stderr = cmd.Stdout
@@ -321,12 +330,24 @@ while read oldrev newrev refname; do
 	if [[ $refname = "refs/heads/master" ]] || [[ $refname = "refs/heads/main" ]]; then
 		git-archive-all $newrev | /bin/flynn-receiver "$RECEIVE_APP" "$newrev" --meta git=true --meta "git.commit=$newrev"| sed -u "s/^/"$'\e[1G\e[K'"/"
 		deploy_pushed=1
-		break
+		[[ -n "${RECEIVE_REVIEW_APPS}" ]] || break
+	elif [[ -n "${RECEIVE_REVIEW_APPS}" ]] && [[ $refname = refs/heads/* ]]; then
+		branch="${refname#refs/heads/}"
+		if [[ $newrev =~ ^0+$ ]]; then
+			/bin/flynn-receiver --delete-review-app="$branch" "$RECEIVE_APP" | sed -u "s/^/"$'\e[1G\e[K'"/"
+		else
+			git-archive-all $newrev | /bin/flynn-receiver --review-app="$branch" "$RECEIVE_APP" "$newrev" --meta git=true --meta "git.commit=$newrev" --meta "git.branch=$branch"| sed -u "s/^/"$'\e[1G\e[K'"/"
+		fi
+		deploy_pushed=1
 	fi
 done
 
 if [[ -z "${deploy_pushed}" ]]; then
-  echo "The push must include a change to the master or main branch to be deployed."
+  if [[ -n "${RECEIVE_REVIEW_APPS}" ]]; then
+    echo "The push must include a change to a branch to be deployed."
+  else
+    echo "The push must include a change to the master or main branch to be deployed."
+  fi
   exit 1
 fi
 `)
@@ -70,15 +70,9 @@ func (h *attachHandler) attach(req *host.AttachReq, conn io.ReadWriteCloser) {
 		job = h.state.GetJob(req.JobID)
 	}
 	w := bufio.NewWriter(conn)
-	writeError := func(err string) {
-		w.WriteByte(host.AttachError)
-		binary.Write(w, binary.BigEndian, uint32(len(err)))
-		w.WriteString(err)
-		w.Flush()
-	}
 	if job.Status == host.StatusFailed {
 		close(attachWait)
-		writeError(*job.Error)
+		writeAttachError(w, *job.Error)
 		return
 	}
 
@@ -87,7 +81,7 @@ func (h *attachHandler) attach(req *host.AttachReq, conn io.ReadWriteCloser) {
 	h.attachedMtx.Lock()
 	if _, ok := h.attached[job.Job.ID]; ok && job.Job.Config.DisableLog {
 		h.attachedMtx.Unlock()
-		writeError(host.ErrAttached.Error())
+		writeAttachError(w, host.ErrAttached.Error())
 		return
 	}
 	h.attached[job.Job.ID] = struct{}{}
@@ -212,7 +206,7 @@ func (h *attachHandler) attach(req *host.AttachReq, conn io.ReadWriteCloser) {
 		} else {
 			close(failed)
 			writeMtx.Lock()
-			writeError(err.Error())
+			writeAttachError(w, err.Error())
 			writeMtx.Unlock()
 			log.Error("attach error", "err", err)
 		}
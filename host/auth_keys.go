@@ -0,0 +1,246 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	host "github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/julienschmidt/httprouter"
+)
+
+// authKeySet is the set of keys accepted by the host API.
+//
+// Keys are configured with --auth-key (a comma separated list) and can be
+// rotated through the API, in which case the new key is added and existing
+// keys expire after a grace period so clients can switch over without any
+// requests failing. Rotations are persisted in the state DB, and expired keys
+// are kept so that a key retired by a rotation stays retired even if it is
+// still configured with --auth-key when the daemon restarts.
+//
+// Only the SHA-256 hashes of keys are kept, both in memory and in the state
+// DB, so that retired keys can't be recovered from either.
+type authKeySet struct {
+	mtx  sync.RWMutex
+	keys []*host.AuthKey
+	now  func() time.Time
+}
+
+func newAuthKeySet(keys []string) *authKeySet {
+	s := &authKeySet{now: time.Now}
+	for _, key := range keys {
+		if key = strings.TrimSpace(key); key != "" && s.find(authKeyHash(key)) == nil {
+			s.keys = append(s.keys, &host.AuthKey{Hash: authKeyHash(key), CreatedAt: s.now().UTC()})
+		}
+	}
+	return s
+}
+
+// parseAuthKeys splits a comma separated list of auth keys.
+func parseAuthKeys(s string) []string {
+	var keys []string
+	for _, key := range strings.Split(s, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (s *authKeySet) find(hash string) *host.AuthKey {
+	for _, k := range s.keys {
+		if k.Hash == hash {
+			return k
+		}
+	}
+	return nil
+}
+
+func (s *authKeySet) expired(k *host.AuthKey) bool {
+	return k.ExpiresAt != nil && !s.now().Before(*k.ExpiresAt)
+}
+
+// Enabled returns whether authentication is required, which is the case if
+// any keys were configured, even if they have all since expired.
+func (s *authKeySet) Enabled() bool {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return len(s.keys) > 0
+}
+
+// Valid returns whether key is one of the unexpired keys.
+func (s *authKeySet) Valid(key string) bool {
+	if key == "" {
+		return false
+	}
+	hash := authKeyHash(key)
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	valid := false
+	for _, k := range s.keys {
+		if subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hash)) == 1 && !s.expired(k) {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// Retired returns when key expired if it is one of the keys retired by a
+// rotation, so that clients still using it can be told to switch to the
+// current key rather than just being refused.
+func (s *authKeySet) Retired(key string) (time.Time, bool) {
+	if key == "" {
+		return time.Time{}, false
+	}
+	hash := authKeyHash(key)
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	for _, k := range s.keys {
+		if subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hash)) == 1 && s.expired(k) {
+			return *k.ExpiresAt, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Restore merges keys persisted by previous rotations into the set. The
+// expiry of persisted keys takes precedence over the configured keys.
+//
+// Keys persisted by older versions are replaced with their hashes, in which
+// case the keys are passed to persist so that they are no longer stored.
+func (s *authKeySet) Restore(keys []*host.AuthKey, persist func([]*host.AuthKey) error) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	converted := false
+	for _, key := range keys {
+		if key.Key != "" {
+			key.Hash = authKeyHash(key.Key)
+			key.Key = ""
+			converted = true
+		}
+		if k := s.find(key.Hash); k != nil {
+			k.CreatedAt = key.CreatedAt
+			k.ExpiresAt = key.ExpiresAt
+			continue
+		}
+		s.keys = append(s.keys, key)
+	}
+	if !converted {
+		return nil
+	}
+	return persist(s.keys)
+}
+
+var errAuthDisabled = errors.New("host API authentication is not enabled")
+
+// Rotate adds key to the set and expires the existing keys after grace. The
+// new set of keys is passed to persist before it is used.
+func (s *authKeySet) Rotate(key string, grace time.Duration, persist func([]*host.AuthKey) error) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if len(s.keys) == 0 {
+		return errAuthDisabled
+	}
+	hash := authKeyHash(key)
+	now := s.now().UTC()
+	expiry := now.Add(grace)
+	keys := make([]*host.AuthKey, 0, len(s.keys)+1)
+	found := false
+	for _, k := range s.keys {
+		k := *k
+		switch {
+		case k.Hash == hash:
+			found = true
+			k.ExpiresAt = nil
+		case k.ExpiresAt == nil || k.ExpiresAt.After(expiry):
+			k.ExpiresAt = &expiry
+		}
+		keys = append(keys, &k)
+	}
+	if !found {
+		keys = append(keys, &host.AuthKey{Hash: hash, CreatedAt: now})
+	}
+	if err := persist(keys); err != nil {
+		return err
+	}
+	s.keys = keys
+	return nil
+}
+
+// Info returns details of the unexpired keys.
+func (s *authKeySet) Info() []*host.AuthKeyInfo {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	info := make([]*host.AuthKeyInfo, 0, len(s.keys))
+	for _, k := range s.keys {
+		if s.expired(k) {
+			continue
+		}
+		info = append(info, &host.AuthKeyInfo{
+			Fingerprint: k.Hash[:12],
+			CreatedAt:   k.CreatedAt,
+			ExpiresAt:   k.ExpiresAt,
+		})
+	}
+	return info
+}
+
+// authKeyHash returns the hex encoded SHA-256 hash of a key.
+func authKeyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// authKeyFingerprint returns a prefix of the key's hash which identifies it
+// without exposing it.
+func authKeyFingerprint(key string) string {
+	return authKeyHash(key)[:12]
+}
+
+func (h *jobAPI) ListAuthKeys(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	httphelper.JSON(w, http.StatusOK, h.host.authKeys.Info())
+}
+
+func (h *jobAPI) RotateAuthKey(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := h.host.log.New("fn", "RotateAuthKey")
+
+	var req host.AuthKeyRotation
+	if err := httphelper.DecodeJSON(r, &req); err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	req.Key = strings.TrimSpace(req.Key)
+	if req.Key == "" {
+		httphelper.ValidationError(w, "key", "must be set")
+		return
+	}
+	if strings.Contains(req.Key, ",") {
+		httphelper.ValidationError(w, "key", "must not contain commas")
+		return
+	}
+	if req.GracePeriod < 0 {
+		httphelper.ValidationError(w, "grace_period", "must not be negative")
+		return
+	}
+	if req.GracePeriod == 0 {
+		req.GracePeriod = host.DefaultAuthKeyGracePeriod
+	}
+
+	log.Info("rotating auth key", "fingerprint", authKeyFingerprint(req.Key), "grace_period", req.GracePeriod)
+	if err := h.host.authKeys.Rotate(req.Key, req.GracePeriod, h.host.state.PersistAuthKeys); err != nil {
+		if err == errAuthDisabled {
+			httphelper.Error(w, httphelper.PreconditionFailedErr(err.Error()))
+			return
+		}
+		log.Error("error rotating auth key", "err", err)
+		httphelper.Error(w, err)
+		return
+	}
+	httphelper.JSON(w, http.StatusOK, h.host.authKeys.Info())
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	host "github.com/flynn/flynn/host/types"
+	. "github.com/flynn/go-check"
+)
+
+func (S) TestAuthKeyRotation(c *C) {
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	keys := newAuthKeySet(parseAuthKeys("key1, key2,"))
+	keys.now = func() time.Time { return now }
+	c.Assert(keys.Enabled(), Equals, true)
+	c.Assert(keys.Valid("key1"), Equals, true)
+	c.Assert(keys.Valid("key2"), Equals, true)
+	c.Assert(keys.Valid("key3"), Equals, false)
+	c.Assert(keys.Valid(""), Equals, false)
+
+	// a failure to persist leaves the keys unchanged
+	err := keys.Rotate("key3", time.Minute, func([]*host.AuthKey) error { return errors.New("boom") })
+	c.Assert(err, NotNil)
+	c.Assert(keys.Valid("key3"), Equals, false)
+
+	var persisted []*host.AuthKey
+	persist := func(k []*host.AuthKey) error {
+		persisted = k
+		return nil
+	}
+	c.Assert(keys.Rotate("key3", time.Minute, persist), IsNil)
+	c.Assert(persisted, HasLen, 3)
+	// only hashes of the keys are persisted
+	for _, k := range persisted {
+		c.Assert(k.Key, Equals, "")
+		c.Assert(k.Hash, HasLen, 64)
+	}
+
+	// all keys are valid during the grace period
+	now = now.Add(30 * time.Second)
+	for _, key := range []string{"key1", "key2", "key3"} {
+		c.Assert(keys.Valid(key), Equals, true)
+	}
+	c.Assert(keys.Info(), HasLen, 3)
+
+	// only the new key is valid afterwards
+	now = now.Add(30 * time.Second)
+	c.Assert(keys.Valid("key1"), Equals, false)
+	c.Assert(keys.Valid("key2"), Equals, false)
+	c.Assert(keys.Valid("key3"), Equals, true)
+	retiredAt, ok := keys.Retired("key1")
+	c.Assert(ok, Equals, true)
+	c.Assert(retiredAt.Equal(now), Equals, true)
+	_, ok = keys.Retired("key3")
+	c.Assert(ok, Equals, false)
+	_, ok = keys.Retired("unknown")
+	c.Assert(ok, Equals, false)
+	info := keys.Info()
+	c.Assert(info, HasLen, 1)
+	c.Assert(info[0].Fingerprint, Equals, authKeyFingerprint("key3"))
+	c.Assert(info[0].ExpiresAt, IsNil)
+
+	// retired keys stay retired after a restart with the old configuration
+	restored := newAuthKeySet([]string{"key1"})
+	restored.now = keys.now
+	c.Assert(restored.Restore(persisted, func([]*host.AuthKey) error {
+		c.Fatal("unexpected persist of hashed keys")
+		return nil
+	}), IsNil)
+	c.Assert(restored.Valid("key1"), Equals, false)
+	c.Assert(restored.Valid("key3"), Equals, true)
+
+	// rotating back to a retired key makes it valid again
+	c.Assert(restored.Rotate("key1", time.Minute, persist), IsNil)
+	c.Assert(restored.Valid("key1"), Equals, true)
+	c.Assert(restored.Valid("key3"), Equals, true)
+}
+
+func (S) TestAuthKeyRotationDisabled(c *C) {
+	keys := newAuthKeySet(nil)
+	c.Assert(keys.Enabled(), Equals, false)
+	c.Assert(keys.Valid("key"), Equals, false)
+	err := keys.Rotate("key", time.Minute, func([]*host.AuthKey) error { return nil })
+	c.Assert(err, Equals, errAuthDisabled)
+}
+
+func (S) TestAuthKeyRestorePlaintext(c *C) {
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	expiry := now.Add(-time.Minute)
+	keys := newAuthKeySet([]string{"key1"})
+	keys.now = func() time.Time { return now }
+
+	// keys persisted in plaintext by older versions are replaced with
+	// their hashes
+	var persisted []*host.AuthKey
+	err := keys.Restore([]*host.AuthKey{
+		{Key: "key1", CreatedAt: now, ExpiresAt: &expiry},
+		{Key: "key2", CreatedAt: now},
+	}, func(k []*host.AuthKey) error {
+		persisted = k
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(persisted, HasLen, 2)
+	for _, k := range persisted {
+		c.Assert(k.Key, Equals, "")
+	}
+	c.Assert(keys.Valid("key1"), Equals, false)
+	c.Assert(keys.Valid("key2"), Equals, true)
+	_, ok := keys.Retired("key1")
+	c.Assert(ok, Equals, true)
+}
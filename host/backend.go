@@ -22,6 +22,34 @@ type AttachRequest struct {
 	Stdin   io.Reader
 }
 
+// ExecRequest is a request to run a process in the container of a running
+// job.
+type ExecRequest struct {
+	JobID  string
+	Args   []string
+	Env    map[string]string
+	TTY    bool
+	Height uint16
+	Width  uint16
+
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// ExecProcess is a process started by Backend.Exec.
+type ExecProcess interface {
+	// Signal sends a Unix signal to the process.
+	Signal(sig int) error
+
+	// ResizeTTY resizes the process's TTY.
+	ResizeTTY(height, width uint16) error
+
+	// Wait waits for the process to exit and for its output to be written,
+	// returning its exit status.
+	Wait() (int, error)
+}
+
 type Backend interface {
 	Run(*host.Job, *RunConfig, *RateLimitBucket) error
 	Stop(string) error
@@ -30,6 +58,8 @@ type Backend interface {
 	DiscoverdDeregister(string) error
 	ResizeTTY(id string, height, width uint16) error
 	Attach(*AttachRequest) error
+	Exec(*ExecRequest) (ExecProcess, error)
+	JobRoot(id string) (string, error)
 	Cleanup([]string) error
 	UnmarshalState(map[string]*host.ActiveJob, map[string][]byte, []byte, host.LogBuffers) error
 	ConfigureNetworking(config *host.NetworkConfig) error
@@ -68,6 +98,8 @@ func (MockBackend) Signal(string, int) error                          { return n
 func (MockBackend) DiscoverdDeregister(string) error                  { return nil }
 func (MockBackend) ResizeTTY(id string, height, width uint16) error   { return nil }
 func (MockBackend) Attach(*AttachRequest) error                       { return nil }
+func (MockBackend) Exec(*ExecRequest) (ExecProcess, error)            { return nil, host.ErrJobNotRunning }
+func (MockBackend) JobRoot(string) (string, error)                    { return "", host.ErrJobNotRunning }
 func (MockBackend) Cleanup([]string) error                            { return nil }
 func (MockBackend) SetDefaultEnv(k, v string)                         {}
 func (MockBackend) ConfigureNetworking(*host.NetworkConfig) error     { return nil }
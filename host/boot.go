@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	discoverd "github.com/flynn/flynn/discoverd/client"
+	host "github.com/flynn/flynn/host/types"
+	sirenia "github.com/flynn/flynn/pkg/sirenia/client"
+	"github.com/inconshreveable/log15"
+)
+
+const (
+	// bootCheckInterval is how often unmet boot dependencies are checked
+	bootCheckInterval = 2 * time.Second
+
+	// bootDependencyTimeout is how long an app waits for its dependencies
+	// before its jobs are started anyway, so that a dependency which never
+	// becomes available does not prevent the rest of the cluster from
+	// recovering
+	bootDependencyTimeout = 5 * time.Minute
+)
+
+// systemAppDependencies declares the dependencies which must be available
+// before the resurrected jobs of each system app are started when the
+// daemon restarts. Apps which are not listed have no dependencies.
+var systemAppDependencies = map[string][]string{
+	"flannel":       {"discoverd"},
+	"postgres":      {"discoverd", "flannel"},
+	"mariadb":       {"discoverd", "flannel"},
+	"mongodb":       {"discoverd", "flannel"},
+	"redis":         {"discoverd", "flannel"},
+	"controller":    {"postgres"},
+	"router":        {"controller"},
+	"blobstore":     {"postgres"},
+	"gitreceive":    {"controller"},
+	"tarreceive":    {"controller"},
+	"acme":          {"controller"},
+	"logaggregator": {"discoverd", "flannel"},
+	"status":        {"controller"},
+	"dashboard":     {"controller"},
+}
+
+// bootDependencyCheck returns a function which checks whether a boot
+// dependency is available, returning an error describing why it is not.
+//
+// "discoverd" is available once the local discoverd is connected and has
+// elected a raft leader, databases are available once their sirenia leader
+// is read-write, and other dependencies once their discoverd service has at
+// least one instance.
+func bootDependencyCheck(dm *DiscoverdManager) func(dep string) error {
+	statusClient := &http.Client{Timeout: 5 * time.Second}
+	return func(dep string) error {
+		if !dm.localConnected() {
+			return errors.New("local discoverd is not connected")
+		}
+		switch dep {
+		case "discoverd":
+			if _, err := discoverd.NewClient().RaftLeader(); err != nil {
+				return fmt.Errorf("no raft leader: %s", err)
+			}
+			return nil
+		case "postgres", "mariadb", "mongodb":
+			leader, err := discoverd.NewService(dep).Leader()
+			if err != nil {
+				return fmt.Errorf("no leader: %s", err)
+			}
+			status, err := sirenia.NewClientWithHTTP(leader.Addr, statusClient).Status()
+			if err != nil {
+				return fmt.Errorf("error getting leader status: %s", err)
+			}
+			if status.Database == nil || !status.Database.ReadWrite {
+				return errors.New("leader is not read-write")
+			}
+			return nil
+		default:
+			instances, err := discoverd.NewService(dep).Instances()
+			if err != nil {
+				return err
+			}
+			if len(instances) == 0 {
+				return errors.New("no instances")
+			}
+			return nil
+		}
+	}
+}
+
+// waitBootDependency blocks until the given dependency is available,
+// returning false if stop is closed first.
+func waitBootDependency(check func(string) error, dep string, interval time.Duration, stop <-chan struct{}) bool {
+	for check(dep) != nil {
+		select {
+		case <-time.After(interval):
+		case <-stop:
+			return false
+		}
+	}
+	return true
+}
+
+// BootOrchestrator resurrects the persistent jobs which were not running when
+// the daemon restarted, starting the jobs of each app once the apps it
+// depends on are available rather than all at once, with the state of each
+// app reported in the host status.
+type BootOrchestrator struct {
+	dependencies map[string][]string
+	check        func(dep string) error
+	resurrect    func(job *host.Job) string
+	interval     time.Duration
+	timeout      time.Duration
+	stop         chan struct{}
+	log          log15.Logger
+
+	mtx    sync.Mutex
+	status *host.BootStatus
+}
+
+// NewBootOrchestrator creates a new orchestrator. Call Run() to resurrect
+// jobs.
+func NewBootOrchestrator(state *State, dm *DiscoverdManager, log log15.Logger) *BootOrchestrator {
+	return &BootOrchestrator{
+		dependencies: systemAppDependencies,
+		check:        bootDependencyCheck(dm),
+		resurrect:    state.Resurrect,
+		interval:     bootCheckInterval,
+		timeout:      bootDependencyTimeout,
+		stop:         make(chan struct{}),
+		log:          log.New("component", "boot"),
+	}
+}
+
+// Run resurrects the given jobs in dependency order, blocking until they
+// have all been started.
+func (b *BootOrchestrator) Run(jobs []*host.Job) {
+	start := time.Now()
+	groups := make(map[string][]*host.Job)
+	for _, job := range jobs {
+		app := job.Metadata["flynn-controller.app_name"]
+		groups[app] = append(groups[app], job)
+	}
+	apps := make([]string, 0, len(groups))
+	for app := range groups {
+		apps = append(apps, app)
+	}
+	sort.Strings(apps)
+
+	status := &host.BootStatus{StartedAt: start, Apps: make([]*host.BootAppStatus, len(apps))}
+	for i, app := range apps {
+		status.Apps[i] = &host.BootAppStatus{
+			App:       app,
+			State:     host.BootAppStateWaiting,
+			DependsOn: b.dependencies[app],
+			Since:     start,
+		}
+	}
+	b.mtx.Lock()
+	b.status = status
+	b.mtx.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(len(apps))
+	for i, app := range apps {
+		go func(app string, appStatus *host.BootAppStatus) {
+			defer wg.Done()
+			b.runApp(app, appStatus, groups[app], start)
+		}(app, status.Apps[i])
+	}
+	wg.Wait()
+
+	b.mtx.Lock()
+	finished := time.Now()
+	b.status.FinishedAt = &finished
+	b.mtx.Unlock()
+	b.log.Info("finished resurrecting jobs", "duration", finished.Sub(start))
+}
+
+// runApp waits for the dependencies of an app to be available and then
+// resurrects its jobs.
+func (b *BootOrchestrator) runApp(app string, status *host.BootAppStatus, jobs []*host.Job, start time.Time) {
+	log := b.log.New("app", app)
+	deps := b.dependencies[app]
+	state := host.BootAppStateStarted
+	for {
+		waiting := make(map[string]string)
+		for _, dep := range deps {
+			if err := b.check(dep); err != nil {
+				waiting[dep] = err.Error()
+			}
+		}
+		if len(waiting) == 0 {
+			break
+		}
+		b.mtx.Lock()
+		status.WaitingFor = waiting
+		b.mtx.Unlock()
+		if time.Since(start) >= b.timeout {
+			log.Warn("timed out waiting for dependencies, starting jobs anyway", "waiting_for", waiting)
+			state = host.BootAppStateTimedOut
+			break
+		}
+		log.Info("waiting for dependencies", "waiting_for", waiting)
+		select {
+		case <-time.After(b.interval):
+		case <-b.stop:
+			return
+		}
+	}
+
+	log.Info("resurrecting jobs", "count", len(jobs))
+	ids := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		ids = append(ids, b.resurrect(job))
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	status.State = state
+	status.Jobs = ids
+	status.Since = time.Now()
+	if state == host.BootAppStateStarted {
+		status.WaitingFor = nil
+	}
+}
+
+// Status returns the boot status reported in the host status, or nil if
+// jobs are not being resurrected.
+func (b *BootOrchestrator) Status() *host.BootStatus {
+	if b == nil {
+		return nil
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.status == nil {
+		return nil
+	}
+	status := *b.status
+	status.Apps = make([]*host.BootAppStatus, len(b.status.Apps))
+	for i, app := range b.status.Apps {
+		a := *app
+		a.Jobs = append([]string(nil), app.Jobs...)
+		if app.WaitingFor != nil {
+			a.WaitingFor = make(map[string]string, len(app.WaitingFor))
+			for k, v := range app.WaitingFor {
+				a.WaitingFor[k] = v
+			}
+		}
+		status.Apps[i] = &a
+	}
+	return &status
+}
+
+// Shutdown stops waiting for dependencies, leaving the jobs of apps which
+// are still waiting unstarted.
+func (b *BootOrchestrator) Shutdown() {
+	close(b.stop)
+}
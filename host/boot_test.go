@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	host "github.com/flynn/flynn/host/types"
+	. "github.com/flynn/go-check"
+	"github.com/inconshreveable/log15"
+)
+
+func (S) TestBootOrchestrator(c *C) {
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+
+	var mtx sync.Mutex
+	available := map[string]bool{}
+	var started []string
+	job := func(id, app string) *host.Job {
+		return &host.Job{ID: id, Metadata: map[string]string{"flynn-controller.app_name": app}}
+	}
+	b := &BootOrchestrator{
+		dependencies: map[string][]string{
+			"postgres":   {"discoverd"},
+			"controller": {"postgres"},
+			"router":     {"controller"},
+		},
+		check: func(dep string) error {
+			mtx.Lock()
+			defer mtx.Unlock()
+			if !available[dep] {
+				return errors.New("not available")
+			}
+			return nil
+		},
+		resurrect: func(job *host.Job) string {
+			mtx.Lock()
+			defer mtx.Unlock()
+			started = append(started, job.ID)
+			// a started app becomes available to the apps which
+			// depend on it
+			available[job.Metadata["flynn-controller.app_name"]] = true
+			return "new-" + job.ID
+		},
+		interval: time.Millisecond,
+		timeout:  time.Minute,
+		stop:     make(chan struct{}),
+		log:      logger,
+	}
+	c.Assert(b.Status(), IsNil)
+
+	done := make(chan struct{})
+	go func() {
+		b.Run([]*host.Job{
+			job("router", "router"),
+			job("controller-web", "controller"),
+			job("controller-scheduler", "controller"),
+			job("postgres", "postgres"),
+			job("discoverd", "discoverd"),
+		})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for jobs to be resurrected")
+	}
+
+	// each app is only started once the app it depends on is available
+	mtx.Lock()
+	c.Assert(started[0], Equals, "discoverd")
+	c.Assert(started[1], Equals, "postgres")
+	c.Assert(started[2:4], DeepEquals, []string{"controller-web", "controller-scheduler"})
+	c.Assert(started[4], Equals, "router")
+	mtx.Unlock()
+
+	status := b.Status()
+	c.Assert(status.FinishedAt, NotNil)
+	c.Assert(status.Apps, HasLen, 4)
+	controller := status.Apps[0]
+	c.Assert(controller.App, Equals, "controller")
+	c.Assert(controller.State, Equals, host.BootAppStateStarted)
+	c.Assert(controller.DependsOn, DeepEquals, []string{"postgres"})
+	c.Assert(controller.WaitingFor, IsNil)
+	c.Assert(controller.Jobs, DeepEquals, []string{"new-controller-web", "new-controller-scheduler"})
+}
+
+func (S) TestBootOrchestratorTimeout(c *C) {
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+
+	var started []string
+	b := &BootOrchestrator{
+		dependencies: map[string][]string{"controller": {"postgres"}},
+		check:        func(string) error { return errors.New("leader is not read-write") },
+		resurrect: func(job *host.Job) string {
+			started = append(started, job.ID)
+			return job.ID
+		},
+		interval: time.Millisecond,
+		timeout:  10 * time.Millisecond,
+		stop:     make(chan struct{}),
+		log:      logger,
+	}
+	b.Run([]*host.Job{{ID: "controller", Metadata: map[string]string{"flynn-controller.app_name": "controller"}}})
+
+	// jobs are started anyway once the timeout passes, with the unmet
+	// dependency left in the status
+	c.Assert(started, DeepEquals, []string{"controller"})
+	status := b.Status()
+	c.Assert(status.Apps, HasLen, 1)
+	c.Assert(status.Apps[0].State, Equals, host.BootAppStateTimedOut)
+	c.Assert(status.Apps[0].WaitingFor, DeepEquals, map[string]string{"postgres": "leader is not read-write"})
+}
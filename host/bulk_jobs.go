@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	host "github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/julienschmidt/httprouter"
+)
+
+// selectJobs returns the IDs of the jobs with one of the given statuses
+// whose metadata matches the selector, in order.
+func (h *Host) selectJobs(sel host.JobSelector, statuses ...host.JobStatus) []string {
+	var ids []string
+	for id, job := range h.state.GetActive() {
+		if job.Job == nil || !sel.Matches(job.Job.Metadata) {
+			continue
+		}
+		for _, status := range statuses {
+			if job.Status == status {
+				ids = append(ids, id)
+				break
+			}
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// bulkApply applies fn to each of the given jobs, recording those it fails
+// for in the result.
+func bulkApply(ids []string, fn func(id string) error) *host.BulkJobsResult {
+	res := &host.BulkJobsResult{Jobs: make([]string, 0, len(ids))}
+	for _, id := range ids {
+		if err := fn(id); err != nil {
+			if res.Errors == nil {
+				res.Errors = make(map[string]string)
+			}
+			res.Errors[id] = err.Error()
+			continue
+		}
+		res.Jobs = append(res.Jobs, id)
+	}
+	return res
+}
+
+// BulkStopJobs stops the starting and running jobs which match the
+// selector.
+func (h *Host) BulkStopJobs(sel host.JobSelector) *host.BulkJobsResult {
+	ids := h.selectJobs(sel, host.StatusStarting, host.StatusRunning)
+	h.log.Info("stopping jobs", "fn", "BulkStopJobs", "selector", sel.String(), "count", len(ids))
+	return bulkApply(ids, h.StopJob)
+}
+
+// BulkSignalJobs sends a signal to the running jobs which match the
+// selector.
+func (h *Host) BulkSignalJobs(sel host.JobSelector, sig int) *host.BulkJobsResult {
+	ids := h.selectJobs(sel, host.StatusRunning)
+	h.log.Info("signalling jobs", "fn", "BulkSignalJobs", "selector", sel.String(), "sig", sig, "count", len(ids))
+	return bulkApply(ids, func(id string) error { return h.SignalJob(id, sig) })
+}
+
+// BulkStop handles POST /host/jobs-bulk/stop by stopping the jobs which
+// match the selector in the request.
+func (h *jobAPI) BulkStop(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req host.BulkStopRequest
+	if err := httphelper.DecodeJSON(r, &req); err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	if err := req.Selector.Validate(); err != nil {
+		httphelper.ValidationError(w, "selector", err.Error())
+		return
+	}
+	httphelper.JSON(w, 200, h.host.BulkStopJobs(req.Selector))
+}
+
+// BulkSignal handles POST /host/jobs-bulk/signal by sending a signal to the
+// jobs which match the selector in the request.
+func (h *jobAPI) BulkSignal(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req host.BulkSignalRequest
+	if err := httphelper.DecodeJSON(r, &req); err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	if err := req.Selector.Validate(); err != nil {
+		httphelper.ValidationError(w, "selector", err.Error())
+		return
+	}
+	if req.Signal <= 0 {
+		httphelper.ValidationError(w, "signal", "must be a positive integer")
+		return
+	}
+	httphelper.JSON(w, 200, h.host.BulkSignalJobs(req.Selector, req.Signal))
+}
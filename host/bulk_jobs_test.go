@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+
+	host "github.com/flynn/flynn/host/types"
+	. "github.com/flynn/go-check"
+)
+
+func (S) TestJobSelector(c *C) {
+	sel, err := host.ParseJobSelector("app=web, release!=abc,formation,!omni")
+	c.Assert(err, IsNil)
+	c.Assert(sel, DeepEquals, host.JobSelector{
+		{Key: "app", Op: host.JobSelectorOpEqual, Value: "web"},
+		{Key: "release", Op: host.JobSelectorOpNotEqual, Value: "abc"},
+		{Key: "formation", Op: host.JobSelectorOpExists},
+		{Key: "omni", Op: host.JobSelectorOpNotExists},
+	})
+	c.Assert(sel.String(), Equals, "app=web,release!=abc,formation,!omni")
+
+	for _, t := range []struct {
+		meta  map[string]string
+		match bool
+	}{
+		{map[string]string{"app": "web", "release": "def", "formation": "true"}, true},
+		{map[string]string{"app": "web", "formation": "true"}, true},
+		{map[string]string{"app": "web", "release": "abc", "formation": "true"}, false},
+		{map[string]string{"app": "db", "release": "def", "formation": "true"}, false},
+		{map[string]string{"app": "web", "release": "def"}, false},
+		{map[string]string{"app": "web", "release": "def", "formation": "true", "omni": "true"}, false},
+		{nil, false},
+	} {
+		c.Assert(sel.Matches(t.meta), Equals, t.match, Commentf("meta: %v", t.meta))
+	}
+
+	// empty selectors and invalid requirements are rejected
+	_, err = host.ParseJobSelector(" , ")
+	c.Assert(err, NotNil)
+	_, err = host.ParseJobSelector("=web")
+	c.Assert(err, NotNil)
+	c.Assert(host.JobSelector{{Key: "app", Op: "~"}}.Validate(), NotNil)
+	c.Assert(host.JobSelector{{Key: "app", Op: host.JobSelectorOpExists, Value: "web"}}.Validate(), NotNil)
+}
+
+func (S) TestSelectJobs(c *C) {
+	state := NewState("abc123", filepath.Join(c.MkDir(), "host-state-db"))
+	c.Assert(state.OpenDB(), IsNil)
+	defer state.CloseDB()
+	for _, job := range []*host.Job{
+		{ID: "web1", Metadata: map[string]string{"app": "web", "release": "old"}},
+		{ID: "web2", Metadata: map[string]string{"app": "web", "release": "new"}},
+		{ID: "web3", Metadata: map[string]string{"app": "web", "release": "old"}},
+		{ID: "db", Metadata: map[string]string{"app": "db", "release": "old"}},
+	} {
+		c.Assert(state.AddJob(job), IsNil)
+	}
+	state.SetStatusRunning("web1")
+	state.SetStatusRunning("web2")
+	state.SetStatusRunning("db")
+	h := &Host{state: state}
+
+	sel := host.JobSelector{
+		{Key: "app", Op: host.JobSelectorOpEqual, Value: "web"},
+		{Key: "release", Op: host.JobSelectorOpNotEqual, Value: "new"},
+	}
+	c.Assert(h.selectJobs(sel, host.StatusRunning), DeepEquals, []string{"web1"})
+	c.Assert(h.selectJobs(sel, host.StatusStarting, host.StatusRunning), DeepEquals, []string{"web1", "web3"})
+
+	// failures are recorded without stopping the operation
+	res := bulkApply([]string{"web1", "web3"}, func(id string) error {
+		if id == "web1" {
+			return errors.New("boom")
+		}
+		return nil
+	})
+	c.Assert(res.Jobs, DeepEquals, []string{"web3"})
+	c.Assert(res.Errors, DeepEquals, map[string]string{"web1": "boom"})
+}
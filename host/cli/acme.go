@@ -19,6 +19,8 @@ import (
 	ct "github.com/flynn/flynn/controller/types"
 	discoverd "github.com/flynn/flynn/discoverd/client"
 	"github.com/flynn/flynn/pkg/dialer"
+	"github.com/flynn/flynn/router/acme"
+	router "github.com/flynn/flynn/router/types"
 	"github.com/flynn/go-docopt"
 )
 
@@ -30,7 +32,8 @@ const (
 func init() {
 	Register("acme", runACME, `
 usage: flynn-host acme
-       flynn-host acme configure --email=<email> [--agree-tos] [--staging] [--directory-url=<url>]
+       flynn-host acme configure --email=<email> [--agree-tos] [--staging] [--directory-url=<url>] [--eab-kid=<id> --eab-hmac-key=<key>]
+       flynn-host acme configure-dns (--webhook-url=<url> [--webhook-token=<token>] [--wildcard-default-domain] | --disable)
        flynn-host acme enable
        flynn-host acme disable
        flynn-host acme status
@@ -46,6 +49,7 @@ Commands:
     With no arguments, shows the current ACME configuration status.
 
     configure              Configure ACME with a contact email address
+    configure-dns          Configure the DNS webhook which completes DNS-01 challenges
     enable                 Enable ACME/Let's Encrypt for the cluster
     disable                Disable ACME/Let's Encrypt for the cluster
     status                 Show current ACME configuration status
@@ -57,10 +61,29 @@ Options:
     --agree-tos              Agree to the Let's Encrypt Terms of Service
     --staging                Use Let's Encrypt staging server (for testing, issues untrusted certs)
     --directory-url=<url>    ACME directory URL (defaults to Let's Encrypt production)
+    --eab-kid=<id>           External Account Binding key ID, for CAs which require one (e.g. ZeroSSL, Google Trust Services, step-ca)
+    --eab-hmac-key=<key>     External Account Binding HMAC key (base64url encoded, as provided by the CA)
+    --webhook-url=<url>      URL of a webhook which creates and removes DNS-01 challenge TXT records
+    --webhook-token=<token>  Bearer token to authenticate requests to the DNS webhook
+    --wildcard-default-domain  Issue one wildcard certificate for the default domain, used by new default app routes
+    --disable                Remove the DNS webhook and stop issuing the wildcard certificate
+
+Wildcard certificates can only be issued using the DNS-01 challenge, which
+needs a TXT record to be created in the domain's DNS. The cluster does not
+serve that DNS, so configure-dns sets a webhook which the ACME service sends
+{"fqdn": "...", "value": "..."} as a POST to <url>/present to create a
+record and to <url>/cleanup to remove it.
+
+With --wildcard-default-domain, default routes of new apps share a managed
+certificate for *.<default domain> rather than each ordering their own.
 
 Examples:
     $ flynn-host acme configure --email=admin@example.com --agree-tos
     $ flynn-host acme configure --email=admin@example.com --agree-tos --staging
+    $ flynn-host acme configure --email=admin@example.com --agree-tos \
+        --directory-url=https://acme.zerossl.com/v2/DV90 --eab-kid=<id> --eab-hmac-key=<key>
+    $ flynn-host acme configure-dns --webhook-url=https://dns.example.com/acme \
+        --webhook-token=<token> --wildcard-default-domain
     $ flynn-host acme enable
     $ flynn-host acme status
     $ flynn-host acme enable-system-routes
@@ -76,6 +99,8 @@ func runACME(args *docopt.Args) error {
 
 	if args.Bool["configure"] {
 		return runACMEConfigure(args, client)
+	} else if args.Bool["configure-dns"] {
+		return runACMEConfigureDNS(args, client)
 	} else if args.Bool["enable"] {
 		return runACMEEnable(client)
 	} else if args.Bool["disable"] {
@@ -98,29 +123,30 @@ func getControllerClient() (controller.Client, error) {
 		return nil, fmt.Errorf("no controller instances found")
 	}
 
-	// Create an HTTP client with a custom dialer that resolves .discoverd
-	// hostnames through the discoverd HTTP API, since the host's system DNS
-	// resolver (systemd-resolved) doesn't know about the .discoverd zone.
-	discoverdDial := func(network, addr string) (net.Conn, error) {
-		host, _, err := net.SplitHostPort(addr)
+	httpClient := &http.Client{Transport: &http.Transport{Dial: dialDiscoverd}}
+	return controller.NewClientWithHTTP("http://controller.discoverd", instances[0].Meta["AUTH_KEY"], httpClient)
+}
+
+// dialDiscoverd dials addr, resolving .discoverd hostnames through the
+// discoverd HTTP API, since the host's system DNS resolver
+// (systemd-resolved) doesn't know about the .discoverd zone.
+func dialDiscoverd(network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(host, ".discoverd") {
+		service := strings.TrimSuffix(host, ".discoverd")
+		addrs, err := discoverd.NewService(service).Addrs()
 		if err != nil {
 			return nil, err
 		}
-		if strings.HasSuffix(host, ".discoverd") {
-			service := strings.TrimSuffix(host, ".discoverd")
-			addrs, err := discoverd.NewService(service).Addrs()
-			if err != nil {
-				return nil, err
-			}
-			if len(addrs) == 0 {
-				return nil, fmt.Errorf("lookup %s: no such host", host)
-			}
-			addr = addrs[0]
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("lookup %s: no such host", host)
 		}
-		return dialer.Default.Dial(network, addr)
+		addr = addrs[0]
 	}
-	httpClient := &http.Client{Transport: &http.Transport{Dial: discoverdDial}}
-	return controller.NewClientWithHTTP("http://controller.discoverd", instances[0].Meta["AUTH_KEY"], httpClient)
+	return dialer.Default.Dial(network, addr)
 }
 
 func runACMEConfigure(args *docopt.Args, client controller.Client) error {
@@ -181,8 +207,22 @@ func runACMEConfigure(args *docopt.Args, client controller.Client) error {
 		contact = "mailto:" + contact
 	}
 
+	account := &acme.Account{
+		Contacts:             []string{contact},
+		TermsOfServiceAgreed: true,
+		EABKeyID:             args.String["--eab-kid"],
+		EABHMACKey:           args.String["--eab-hmac-key"],
+	}
+	if account.EABKeyID == "" && acmeClient.Directory().Meta.ExternalAccountRequired {
+		return fmt.Errorf("the ACME provider requires External Account Binding, set --eab-kid and --eab-hmac-key using the credentials from the provider")
+	}
+	opts, err := account.NewAccountOptions()
+	if err != nil {
+		return err
+	}
+
 	// Register the account
-	_, err = acmeClient.NewAccount(privKey, false, true, contact)
+	_, err = acmeClient.NewAccountOptions(privKey, opts...)
 	if err != nil {
 		return fmt.Errorf("error registering ACME account: %s", err)
 	}
@@ -202,6 +242,8 @@ func runACMEConfigure(args *docopt.Args, client controller.Client) error {
 	config.TermsOfServiceAgreed = true
 	config.DirectoryURL = directoryURL
 	config.AccountKey = keyPEM
+	config.EABKeyID = account.EABKeyID
+	config.EABHMACKey = account.EABHMACKey
 	config.Enabled = true // Auto-enable when configuring
 
 	if err := client.UpdateACMEConfig(config); err != nil {
@@ -287,15 +329,15 @@ func enableLetsEncryptOnSystemRoutes(client controller.Client) error {
 			continue
 		}
 
-		// Enable managed certificate for this route
+		// Enable managed certificate for this route, only changing
+		// the managed certificate domain so that other settings
+		// changed since the route was listed are kept
 		domain := route.Domain
-		route.ManagedCertificateDomain = &domain
-		route.Certificate = nil
-		route.LegacyTLSCert = ""
-		route.LegacyTLSKey = ""
-
-		routeID := fmt.Sprintf("%s/%s", route.Type, route.ID)
-		if err := client.UpdateRoute(app.Name, routeID, route); err != nil {
+		patch := &router.RoutePatch{
+			ManagedCertificateDomain: &domain,
+			UpdatedAt:                &route.UpdatedAt,
+		}
+		if _, err := client.PatchRoute(app.Name, route.FormattedID(), patch); err != nil {
 			fmt.Printf("  [error] %s: %s - %s\n", app.Name, route.Domain, err)
 			errorCount++
 			continue
@@ -322,6 +364,37 @@ func enableLetsEncryptOnSystemRoutes(client controller.Client) error {
 	return nil
 }
 
+func runACMEConfigureDNS(args *docopt.Args, client controller.Client) error {
+	config, err := client.GetACMEConfig()
+	if err != nil {
+		return fmt.Errorf("error getting ACME config: %s", err)
+	}
+
+	if args.Bool["--disable"] {
+		config.DNSWebhookURL = ""
+		config.DNSWebhookToken = ""
+		config.WildcardDefaultDomain = false
+		if err := client.UpdateACMEConfig(config); err != nil {
+			return fmt.Errorf("error removing DNS webhook: %s", err)
+		}
+		fmt.Println("DNS webhook removed, wildcard certificates will not be issued.")
+		return nil
+	}
+
+	config.DNSWebhookURL = args.String["--webhook-url"]
+	config.DNSWebhookToken = args.String["--webhook-token"]
+	config.WildcardDefaultDomain = args.Bool["--wildcard-default-domain"]
+	if err := client.UpdateACMEConfig(config); err != nil {
+		return fmt.Errorf("error configuring DNS webhook: %s", err)
+	}
+
+	fmt.Println("DNS webhook configured, wildcard certificates can now be issued using DNS-01 challenges.")
+	if config.WildcardDefaultDomain {
+		fmt.Println("Default routes of new apps will share a wildcard certificate for the default domain.")
+	}
+	return nil
+}
+
 func runACMEEnable(client controller.Client) error {
 	config, err := client.GetACMEConfig()
 	if err != nil {
@@ -393,6 +466,14 @@ func runACMEStatus(client controller.Client) error {
 		fmt.Fprintf(w, "Directory URL:\t%s (custom)\n", dirURL)
 	}
 
+	if config.EABKeyID != "" {
+		fmt.Fprintf(w, "EAB Key ID:\t%s\n", config.EABKeyID)
+	}
+	if config.DNSWebhookURL != "" {
+		fmt.Fprintf(w, "DNS Webhook:\t%s\n", config.DNSWebhookURL)
+		fmt.Fprintf(w, "Wildcard Default Domain:\t%t\n", config.WildcardDefaultDomain)
+	}
+
 	if config.UpdatedAt != nil {
 		fmt.Fprintf(w, "Last Updated:\t%s\n", config.UpdatedAt.Format(time.RFC3339))
 	}
@@ -504,14 +585,14 @@ func disableLetsEncryptOnSystemRoutes(client controller.Client) error {
 			continue
 		}
 
-		// Disable managed certificate for this route
-		route.ManagedCertificateDomain = nil
-		route.Certificate = nil
-		route.LegacyTLSCert = ""
-		route.LegacyTLSKey = ""
-
-		routeID := fmt.Sprintf("%s/%s", route.Type, route.ID)
-		if err := client.UpdateRoute(app.Name, routeID, route); err != nil {
+		// Disable managed certificate for this route, which also
+		// removes the certificate issued for it
+		noDomain := ""
+		patch := &router.RoutePatch{
+			ManagedCertificateDomain: &noDomain,
+			UpdatedAt:                &route.UpdatedAt,
+		}
+		if _, err := client.PatchRoute(app.Name, route.FormattedID(), patch); err != nil {
 			fmt.Printf("  [error] %s: %s - %s\n", app.Name, route.Domain, err)
 			errorCount++
 			continue
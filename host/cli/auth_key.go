@@ -0,0 +1,194 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	controller "github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	host "github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/pkg/cluster"
+	"github.com/flynn/flynn/pkg/random"
+	"github.com/flynn/go-docopt"
+)
+
+func init() {
+	Register("auth-key", runAuthKey, `
+usage: flynn-host auth-key
+       flynn-host auth-key rotate [--key=<key>] [--grace-period=<duration>] [--skip-apps]
+
+Manage the keys which authenticate requests to the host HTTP API.
+
+Commands:
+    With no arguments, lists the valid keys of each host.
+
+    rotate  Make a new key the auth key of every host, and set it as
+            FLYNN_HOST_AUTH_KEY of every app which has that variable set
+
+Options:
+    --key=<key>                 the new key (defaults to a random key)
+    --grace-period=<duration>   how long the previous keys remain valid [default: 10m]
+    --skip-apps                 only rotate the hosts' keys, leaving apps to be updated manually
+
+Apps are redeployed with the new key during the grace period, the controller
+last, so that they do not start failing once the previous keys expire. Hosts
+keep the rotated key across restarts, but hosts added later must be started
+with the new key (--auth-key or FLYNN_HOST_AUTH_KEY), and so must be any
+flynn-host commands run afterwards.
+
+Examples:
+
+	$ flynn-host auth-key
+	HOST    FINGERPRINT  CREATED               EXPIRES
+	host0   3f1c29e0a5b4  2024-05-01T12:00:00Z
+	host0   9a8e7d6c5b4a  2024-04-01T12:00:00Z  2024-05-01T12:10:00Z
+
+	$ flynn-host auth-key rotate
+	rotated the auth key of host0
+	setting FLYNN_HOST_AUTH_KEY of controller
+	rotated the host auth key, new key: 7d1e...
+`)
+}
+
+func runAuthKey(args *docopt.Args, client *cluster.Client) error {
+	if args.Bool["rotate"] {
+		return runAuthKeyRotate(args, client)
+	}
+	hosts, err := client.Hosts()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
+	defer w.Flush()
+	listRec(w, "HOST", "FINGERPRINT", "CREATED", "EXPIRES")
+	for _, h := range hosts {
+		keys, err := h.ListAuthKeys()
+		if err != nil {
+			return fmt.Errorf("error listing auth keys of %s: %s", h.ID(), err)
+		}
+		for _, k := range keys {
+			var expires string
+			if k.ExpiresAt != nil {
+				expires = k.ExpiresAt.Format(time.RFC3339)
+			}
+			listRec(w, h.ID(), k.Fingerprint, k.CreatedAt.Format(time.RFC3339), expires)
+		}
+	}
+	return nil
+}
+
+func runAuthKeyRotate(args *docopt.Args, client *cluster.Client) error {
+	grace := host.DefaultAuthKeyGracePeriod
+	if s := args.String["--grace-period"]; s != "" {
+		var err error
+		grace, err = time.ParseDuration(s)
+		if err != nil || grace <= 0 {
+			return fmt.Errorf("invalid --grace-period %q", s)
+		}
+	}
+	key := args.String["--key"]
+	if key == "" {
+		key = random.Hex(32)
+	}
+	deadline := time.Now().Add(grace)
+
+	hosts, err := client.Hosts()
+	if err != nil {
+		return err
+	}
+	for _, h := range hosts {
+		if _, err := h.RotateAuthKey(key, grace); err != nil {
+			// hosts which were already rotated accept the new key, so
+			// the rotation can be finished by rerunning with it
+			return fmt.Errorf("error rotating the auth key of %s: %s (rerun with --key=%s to finish the rotation)", h.ID(), err, key)
+		}
+		fmt.Printf("rotated the auth key of %s\n", h.ID())
+	}
+
+	if !args.Bool["--skip-apps"] {
+		if err := setAppsHostAuthKey(key, deadline); err != nil {
+			return fmt.Errorf("error setting FLYNN_HOST_AUTH_KEY of apps, the previous key expires at %s: %s", deadline.Format(time.RFC3339), err)
+		}
+	}
+
+	fmt.Printf("rotated the host auth key, new key: %s\n", key)
+	fmt.Println("set it as --auth-key or FLYNN_HOST_AUTH_KEY of hosts added later and of flynn-host commands")
+	if args.Bool["--skip-apps"] {
+		fmt.Printf("set it as FLYNN_HOST_AUTH_KEY of apps which use the host API before %s\n", deadline.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// setAppsHostAuthKey deploys every app which sets FLYNN_HOST_AUTH_KEY with the
+// given key, returning an error if the deploys do not finish by deadline.
+// The controller is deployed last since it runs the other deploys.
+func setAppsHostAuthKey(key string, deadline time.Time) error {
+	client, err := getControllerClient()
+	if err != nil {
+		return err
+	}
+	apps, err := client.AppList()
+	if err != nil {
+		return err
+	}
+	var last *ct.App
+	for _, app := range apps {
+		if app.Name == "controller" {
+			last = app
+			continue
+		}
+		if err := setAppHostAuthKey(client, app, key, deadline); err != nil {
+			return err
+		}
+	}
+	if last != nil {
+		return setAppHostAuthKey(client, last, key, deadline)
+	}
+	return nil
+}
+
+func setAppHostAuthKey(client controller.Client, app *ct.App, key string, deadline time.Time) error {
+	release, err := client.GetAppRelease(app.ID)
+	if err == controller.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !setHostAuthKeyEnv(release, key) {
+		return nil
+	}
+	fmt.Printf("setting FLYNN_HOST_AUTH_KEY of %s\n", app.Name)
+	release.ID = ""
+	if err := client.CreateRelease(app.ID, release); err != nil {
+		return err
+	}
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		return fmt.Errorf("grace period ended before %s was deployed", app.Name)
+	}
+	timeoutCh := make(chan struct{})
+	time.AfterFunc(timeout, func() { close(timeoutCh) })
+	if err := client.DeployAppRelease(app.ID, release.ID, timeoutCh); err != nil {
+		return fmt.Errorf("error deploying %s: %s", app.Name, err)
+	}
+	return nil
+}
+
+// setHostAuthKeyEnv sets FLYNN_HOST_AUTH_KEY to key in the release and process
+// envs which set it, returning whether any were changed.
+func setHostAuthKeyEnv(release *ct.Release, key string) bool {
+	changed := false
+	set := func(env map[string]string) {
+		if v, ok := env["FLYNN_HOST_AUTH_KEY"]; ok && v != key {
+			env["FLYNN_HOST_AUTH_KEY"] = key
+			changed = true
+		}
+	}
+	set(release.Env)
+	for _, proc := range release.Processes {
+		set(proc.Env)
+	}
+	return changed
+}
@@ -0,0 +1,37 @@
+package cli
+
+import (
+	"testing"
+
+	ct "github.com/flynn/flynn/controller/types"
+)
+
+func TestSetHostAuthKeyEnv(t *testing.T) {
+	release := &ct.Release{
+		Env: map[string]string{"FLYNN_HOST_AUTH_KEY": "old", "FOO": "bar"},
+		Processes: map[string]ct.ProcessType{
+			"web":    {Env: map[string]string{"FLYNN_HOST_AUTH_KEY": "old,older"}},
+			"worker": {},
+		},
+	}
+	if !setHostAuthKeyEnv(release, "new") {
+		t.Fatal("expected the release to be changed")
+	}
+	if release.Env["FLYNN_HOST_AUTH_KEY"] != "new" || release.Env["FOO"] != "bar" {
+		t.Fatalf("unexpected release env %v", release.Env)
+	}
+	if v := release.Processes["web"].Env["FLYNN_HOST_AUTH_KEY"]; v != "new" {
+		t.Fatalf("expected the web process key to be set, got %q", v)
+	}
+	if _, ok := release.Processes["worker"].Env["FLYNN_HOST_AUTH_KEY"]; ok {
+		t.Fatal("expected the key not to be added to processes without it")
+	}
+
+	// releases which already have the key, or do not use one, are unchanged
+	if setHostAuthKeyEnv(release, "new") {
+		t.Fatal("expected a release with the new key to be unchanged")
+	}
+	if setHostAuthKeyEnv(&ct.Release{Env: map[string]string{"FOO": "bar"}}, "new") {
+		t.Fatal("expected a release without the key to be unchanged")
+	}
+}
@@ -0,0 +1,160 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/host/downloader"
+	"github.com/flynn/flynn/pkg/ghrelease"
+	"github.com/flynn/go-docopt"
+	"github.com/inconshreveable/log15"
+)
+
+func init() {
+	Register("bundle", runBundle, `
+usage: flynn-host bundle create [--version=<ver>] [--out=<file>] [--github-repo=<repo>]
+
+Commands:
+    create  Download a release into a tarball for installing or updating air-gapped clusters
+
+Options:
+  --version=<ver>       version to bundle (defaults to the latest release)
+  --out=<file>          file to write the bundle to (defaults to flynn-<ver>.tar.gz)
+  --github-repo=<repo>  GitHub repository to download the release from [default: randy-girard/flynn]
+
+The bundle contains the binaries, config, images manifest and image layers
+of the release for the architecture of this host, in the same layout as a
+release tarball. Files are downloaded to a temporary directory (see TMPDIR)
+while the bundle is written.
+
+To update a cluster, copy the bundle to one of its hosts and run:
+
+    $ flynn-host update --tarball flynn-<ver>.tar.gz --all-nodes
+
+To install a new host, extract the bundle and download from it as a mirror:
+
+    $ tar xzf flynn-<ver>.tar.gz
+    $ flynn-host download --version <ver> --mirror-url file://$PWD/flynn-<ver>
+
+Examples:
+
+    $ flynn-host bundle create --version v20240127.0
+    bundled Flynn v20240127.0 (23 images, 148 layers) to flynn-v20240127.0.tar.gz
+`)
+}
+
+func runBundle(args *docopt.Args) error {
+	switch {
+	case args.Bool["create"]:
+		return runBundleCreate(args)
+	}
+	return nil
+}
+
+func runBundleCreate(args *docopt.Args) error {
+	log := log15.New()
+	repo := args.String["--github-repo"]
+
+	ver := args.String["--version"]
+	if ver == "" {
+		release, err := ghrelease.NewClient(repo, log).GetLatestRelease()
+		if err != nil {
+			return fmt.Errorf("error getting latest release: %s", err)
+		}
+		ver = release.TagName
+	}
+	out := args.String["--out"]
+	if out == "" {
+		out = "flynn-" + ver + ".tar.gz"
+	}
+
+	tmpDir, err := os.MkdirTemp("", "flynn-bundle-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	d := downloader.New(repo, nil, ver, log)
+	log.Info("downloading binaries", "version", ver)
+	binPaths, err := d.DownloadBinaries(filepath.Join(tmpDir, "bin"))
+	if err != nil {
+		return fmt.Errorf("error downloading binaries: %s", err)
+	}
+	log.Info("downloading config", "version", ver)
+	configPaths, err := d.DownloadConfig(filepath.Join(tmpDir, "config"))
+	if err != nil {
+		return fmt.Errorf("error downloading config: %s", err)
+	}
+	log.Info("downloading images manifest", "version", ver)
+	images, err := d.DownloadImagesManifest(tmpDir)
+	if err != nil {
+		return err
+	}
+	binaries, config := bundleFiles(binPaths, configPaths)
+
+	// layers are downloaded one at a time as they are written, and removed
+	// once written so only one is kept on disk alongside its copy
+	layerDir := filepath.Join(tmpDir, "layers")
+	openLayer := func(_ *ct.Artifact, layer *ct.ImageLayer) (io.ReadCloser, error) {
+		log.Info("downloading layer", "layer", layer.ID)
+		path, err := d.DownloadLayer(layer, layerDir)
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		return tempFile{f}, nil
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	layers, err := writeImageExport(f, ver, images, binaries, config, openLayer)
+	if err != nil {
+		f.Close()
+		os.Remove(out)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("bundled Flynn %s (%d images, %d layers) to %s\n", ver, len(images), layers, out)
+	return nil
+}
+
+// bundleFiles returns the binaries and config downloaded for a bundle keyed
+// by their name in the bundle, which is the name of the gzipped release asset
+// they were downloaded from.
+func bundleFiles(binPaths, configPaths map[string]string) (binaries, config map[string]string) {
+	binaries = make(map[string]string, len(binPaths))
+	for name, path := range binPaths {
+		switch name {
+		case "flynn-host", "flynn-init":
+			binaries[ghrelease.HostAssetName(name)+".gz"] = path
+		default:
+			binaries[name+".gz"] = path
+		}
+	}
+	config = make(map[string]string, len(configPaths))
+	for name, path := range configPaths {
+		config[name+".gz"] = path
+	}
+	return binaries, config
+}
+
+// tempFile is a file which is removed when it is closed
+type tempFile struct {
+	*os.File
+}
+
+func (f tempFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
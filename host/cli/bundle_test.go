@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/host/downloader"
+	"github.com/flynn/flynn/pkg/ghrelease"
+	"github.com/inconshreveable/log15"
+)
+
+func TestBundleFiles(t *testing.T) {
+	flynn := ghrelease.HostAssetName("flynn")
+	binaries, config := bundleFiles(
+		map[string]string{"flynn-host": "/tmp/bin/flynn-host.v1", "flynn-init": "/tmp/bin/flynn-init.v1", flynn: "/tmp/bin/" + flynn + ".v1"},
+		map[string]string{"bootstrap-manifest.json": "/tmp/config/bootstrap-manifest.json"},
+	)
+	expected := map[string]string{
+		ghrelease.HostAssetName("flynn-host") + ".gz": "/tmp/bin/flynn-host.v1",
+		ghrelease.HostAssetName("flynn-init") + ".gz": "/tmp/bin/flynn-init.v1",
+		flynn + ".gz": "/tmp/bin/" + flynn + ".v1",
+	}
+	if !reflect.DeepEqual(binaries, expected) {
+		t.Fatalf("expected binaries %v, got %v", expected, binaries)
+	}
+	if !reflect.DeepEqual(config, map[string]string{"bootstrap-manifest.json.gz": "/tmp/config/bootstrap-manifest.json"}) {
+		t.Fatalf("unexpected config %v", config)
+	}
+}
+
+func TestBundleMirror(t *testing.T) {
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "bootstrap-manifest.json")
+	if err := ioutil.WriteFile(manifest, []byte(`{"steps":[]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	openLayer := func(a *ct.Artifact, l *ct.ImageLayer) (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader("layer " + l.ID)), nil
+	}
+	var buf bytes.Buffer
+	images := map[string]*ct.Artifact{"controller": testImage("base")}
+	if _, err := writeImageExport(&buf, "v20260101.0", images, nil, map[string]string{"bootstrap-manifest.json.gz": manifest}, openLayer); err != nil {
+		t.Fatal(err)
+	}
+	tarball := filepath.Join(dir, "bundle.tar.gz")
+	if err := ioutil.WriteFile(tarball, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, contentDir, err := extractTarball(tarball, filepath.Join(dir, "extract"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an extracted bundle can be downloaded from as a mirror
+	log := log15.New()
+	log.SetHandler(log15.DiscardHandler())
+	d := downloader.NewWithBaseURL("file://"+contentDir, nil, "v20260101.0", log)
+	paths, err := d.DownloadConfig(filepath.Join(dir, "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(paths["bootstrap-manifest.json"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"steps":[]}` {
+		t.Fatalf("unexpected config contents %q", data)
+	}
+	info, err := os.Stat(filepath.Join(contentDir, "bootstrap-manifest.json.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0644 {
+		t.Fatalf("expected config to be written with mode 0644, got %o", mode)
+	}
+}
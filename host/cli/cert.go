@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/go-docopt"
+)
+
+func init() {
+	Register("cert", runCert, `
+usage: flynn-host cert list [--expiring=<duration>]
+
+Manage the TLS certificates of the cluster's routes.
+
+Commands:
+    list  List the manual and managed certificates of all routes, ordered by
+          expiry, along with the number of routes using them
+
+Options:
+    --expiring=<duration>  Only list certificates expiring within <duration>
+                           (e.g. 720h)
+
+Examples:
+
+    $ flynn-host cert list
+    $ flynn-host cert list --expiring=720h
+`)
+}
+
+func runCert(args *docopt.Args) error {
+	client, err := getControllerClient()
+	if err != nil {
+		return fmt.Errorf("error connecting to controller: %s", err)
+	}
+
+	var certs []*ct.CertificateSummary
+	if s := args.String["--expiring"]; s != "" {
+		within, err := time.ParseDuration(s)
+		if err != nil || within < 0 {
+			return fmt.Errorf("invalid --expiring duration %q", s)
+		}
+		certs, err = client.ListExpiringCertificates(within)
+		if err != nil {
+			return err
+		}
+	} else if certs, err = client.ListCertificates(); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "DOMAINS\tTYPE\tISSUER\tEXPIRES\tROUTES\tSHA256")
+	for _, cert := range certs {
+		typ := "manual"
+		if cert.Managed() {
+			typ = fmt.Sprintf("managed (%s)", cert.Status)
+		}
+		expires := "-"
+		if cert.ExpiresAt != nil {
+			expires = fmt.Sprintf("%s (%s)", cert.ExpiresAt.Format("2006-01-02"), daysLeft(*cert.ExpiresAt))
+		}
+		fingerprint := cert.SHA256
+		if len(fingerprint) > 16 {
+			fingerprint = fingerprint[:16]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n",
+			strings.Join(cert.Domains, ","),
+			typ,
+			valueOrNone(cert.Issuer),
+			expires,
+			len(cert.Routes),
+			valueOrNone(fingerprint),
+		)
+	}
+	return nil
+}
+
+// daysLeft formats the number of days until the given time
+func daysLeft(t time.Time) string {
+	days := int(time.Until(t).Hours() / 24)
+	if days < 0 {
+		return "expired"
+	}
+	return fmt.Sprintf("%dd left", days)
+}
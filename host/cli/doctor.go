@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/flynn/flynn/host/tuning"
+	"github.com/flynn/go-docopt"
+)
+
+func init() {
+	Register("doctor", runDoctor, `
+usage: flynn-host doctor [--fix]
+
+Check the kernel settings of this host for values which are unsuitable for
+running database appliances, such as transparent huge pages being enabled, a
+low vm.max_map_count or a high vm.swappiness.
+
+The same warnings are reported in the host status, and the daemon applies the
+recommended values on startup when run with --tune-kernel.
+
+Options:
+    --fix  apply the recommended value of each unsuitable setting
+
+Examples:
+
+  $ flynn-host doctor
+  SETTING               VALUE   RECOMMENDED  ISSUE
+  transparent_hugepage  always  never        transparent huge pages cause latency spikes and increased memory usage in MongoDB, PostgreSQL and Redis
+  vm.max_map_count      65530   262144       a low memory map limit can cause MongoDB to fail under load
+`)
+}
+
+func runDoctor(args *docopt.Args) error {
+	advisor := tuning.NewAdvisor("/")
+
+	if args.Bool["--fix"] {
+		fixed, err := advisor.Fix()
+		for _, w := range fixed {
+			fmt.Printf("set %s to %s (was %s)\n", w.Setting, w.Recommended, w.Value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	warnings := advisor.Check()
+	if len(warnings) == 0 {
+		fmt.Println("no issues found")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
+	defer w.Flush()
+	listRec(w, "SETTING", "VALUE", "RECOMMENDED", "ISSUE")
+	for _, warning := range warnings {
+		listRec(w, warning.Setting, warning.Value, warning.Recommended, warning.Message)
+	}
+	return nil
+}
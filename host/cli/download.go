@@ -1,9 +1,13 @@
 package cli
 
 import (
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"os"
 	"runtime"
+	"strconv"
+	"strings"
 
 	"github.com/docker/go-units"
 	ct "github.com/flynn/flynn/controller/types"
@@ -26,10 +30,18 @@ Options:
   -c --config-dir=<dir>    directory to download config files to [default: /etc/flynn]
   -v --volpath=<path>      directory to create volumes in [default: /var/lib/flynn/volumes]
   --github-repo=<repo>     GitHub repository for downloads [default: randy-girard/flynn]
+  --layer-concurrency=<n>  number of image layers to download in parallel [default: 4]
+  --mirror-url=<url>       base URL of a mirror of the release assets to download from instead of GitHub
+  --mirror-auth=<creds>    credentials for the mirror, either "username:password", an Authorization header value such as "Bearer <token>" or @<file> to read them from a file
+  --mirror-ca=<file>       file of PEM encoded CA certificates to trust when downloading from the mirror
+  --registry=<url>         registry to download image layers from before falling back to GitHub
   --version=<ver>          version to download (defaults to latest release)
   --zpool=<name>           name of ZFS pool to use [default: flynn-default]
 
-Download Flynn binaries, config and images from GitHub releases.`)
+Download Flynn binaries, config and images from GitHub releases, or from
+a private mirror of the release assets with --mirror-url (which requires
+--version). Without --mirror-url, --mirror-auth and --mirror-ca apply to
+GitHub, for downloading from a private repository.`)
 }
 
 func runDownload(args *docopt.Args) error {
@@ -41,9 +53,28 @@ func runDownload(args *docopt.Args) error {
 	zpoolName := args.String["--zpool"]
 	repo := args.String["--github-repo"]
 	targetVersion := args.String["--version"]
+	layerConcurrency, err := strconv.Atoi(args.String["--layer-concurrency"])
+	if err != nil || layerConcurrency < 1 {
+		return fmt.Errorf("invalid --layer-concurrency %q", args.String["--layer-concurrency"])
+	}
+
+	mirrorURL := strings.TrimSuffix(args.String["--mirror-url"], "/")
+	if mirrorURL != "" && targetVersion == "" {
+		return errors.New("--version is required with --mirror-url")
+	}
+	auth, rootCAs, err := parseMirrorOptions(args)
+	if err != nil {
+		return err
+	}
 
 	// Determine version to download
 	client := ghrelease.NewClient(repo, log)
+	if auth != nil {
+		client.SetAuth(auth)
+	}
+	if rootCAs != nil {
+		client.SetRootCAs(rootCAs)
+	}
 	var downloadVersion string
 	if targetVersion != "" {
 		downloadVersion = targetVersion
@@ -68,7 +99,23 @@ func runDownload(args *docopt.Args) error {
 	}
 
 	// Create downloader
-	d := downloader.New(repo, vman, downloadVersion, log)
+	var d *downloader.Downloader
+	if mirrorURL != "" {
+		log.Info("downloading from mirror", "url", mirrorURL)
+		d = downloader.NewWithBaseURL(mirrorURL, vman, downloadVersion, log)
+	} else {
+		d = downloader.New(repo, vman, downloadVersion, log)
+	}
+	if auth != nil {
+		d.SetAuth(auth)
+	}
+	if rootCAs != nil {
+		d.SetRootCAs(rootCAs)
+	}
+	if registry := args.String["--registry"]; registry != "" {
+		d.SetRegistry(registry)
+	}
+	d.SetLayerConcurrency(layerConcurrency)
 
 	// Download binaries
 	log.Info("downloading binaries", "dir", binDir)
@@ -111,17 +158,50 @@ func runDownload(args *docopt.Args) error {
 		return err
 	}
 
+	log.Info("download complete", "version", downloadVersion)
+	if mirrorURL != "" {
+		// install sources only record GitHub installations
+		fmt.Printf("Flynn %s downloaded successfully from %s\n", downloadVersion, mirrorURL)
+		return nil
+	}
+
 	// Record installation source
 	source := installsource.NewGitHubSource(repo, downloadVersion)
 	if err := installsource.Save(configDir, source); err != nil {
 		log.Warn("failed to save install-source.json", "err", err)
 	}
 
-	log.Info("download complete", "version", downloadVersion)
 	fmt.Printf("Flynn %s downloaded successfully from GitHub (%s)\n", downloadVersion, repo)
 	return nil
 }
 
+// parseMirrorOptions returns the credentials and CAs given with
+// --mirror-auth and --mirror-ca, which are nil if not given.
+func parseMirrorOptions(args *docopt.Args) (*ghrelease.Auth, *x509.CertPool, error) {
+	var auth *ghrelease.Auth
+	if creds := args.String["--mirror-auth"]; creds != "" {
+		if strings.HasPrefix(creds, "@") {
+			data, err := os.ReadFile(creds[1:])
+			if err != nil {
+				return nil, nil, fmt.Errorf("error reading --mirror-auth: %s", err)
+			}
+			creds = string(data)
+		}
+		var err error
+		if auth, err = ghrelease.ParseAuth(creds); err != nil {
+			return nil, nil, fmt.Errorf("invalid --mirror-auth: %s", err)
+		}
+	}
+	var rootCAs *x509.CertPool
+	if path := args.String["--mirror-ca"]; path != "" {
+		var err error
+		if rootCAs, err = ghrelease.LoadRootCAs(path); err != nil {
+			return nil, nil, fmt.Errorf("invalid --mirror-ca: %s", err)
+		}
+	}
+	return auth, rootCAs, nil
+}
+
 func initVolumeManager(volPath, zpoolName string, log log15.Logger) (*volumemanager.Manager, error) {
 	if runtime.GOOS != "linux" {
 		log.Warn("ZFS volume manager only available on Linux, skipping layer import")
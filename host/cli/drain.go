@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/flynn/flynn/pkg/cluster"
+	"github.com/flynn/go-docopt"
+)
+
+func init() {
+	Register("drain", runDrain, `
+usage: flynn-host drain [--timeout=<timeout>] [--no-wait] <hostid>
+       flynn-host drain --status <hostid>
+       flynn-host drain --cancel <hostid>
+
+Drain a host before updating or restarting it.
+
+A draining host is marked as unschedulable, and the scheduler moves its jobs
+to other hosts. Omni jobs and jobs using volumes stay on the host. The host
+waits up to the timeout for the jobs to stop.
+
+Options:
+	--timeout=<timeout>  how long to wait for jobs to move [default: 5m]
+	--no-wait            don't wait for the drain to complete
+	--status             show the progress of a drain
+	--cancel             stop draining the host, so jobs can be placed on it again
+`)
+}
+
+func runDrain(args *docopt.Args, client *cluster.Client) error {
+	h, err := client.Host(args.String["<hostid>"])
+	if err != nil {
+		return err
+	}
+
+	if args.Bool["--cancel"] {
+		if err := h.Undrain(); err != nil {
+			return err
+		}
+		fmt.Printf("host %s is no longer draining\n", h.ID())
+		return nil
+	}
+
+	if args.Bool["--status"] {
+		status, err := h.DrainStatus()
+		if err != nil {
+			return err
+		}
+		switch {
+		case status.TimedOut:
+			fmt.Printf("timed out draining host %s with %d jobs remaining: %s\n", h.ID(), len(status.Jobs), strings.Join(status.Jobs, " "))
+		case status.Done():
+			fmt.Printf("host %s drained in %s\n", h.ID(), status.CompletedAt.Sub(status.StartedAt))
+		default:
+			fmt.Printf("draining host %s, waiting for %d jobs to move\n", h.ID(), len(status.Jobs))
+		}
+		return nil
+	}
+
+	timeout, err := time.ParseDuration(args.String["--timeout"])
+	if err != nil || timeout <= 0 {
+		return fmt.Errorf("invalid timeout %q", args.String["--timeout"])
+	}
+	status, err := h.Drain(timeout)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("draining host %s, waiting for %d jobs to move\n", h.ID(), len(status.Jobs))
+	if args.Bool["--no-wait"] {
+		return nil
+	}
+
+	remaining := len(status.Jobs)
+	for !status.Done() {
+		time.Sleep(time.Second)
+		if status, err = h.DrainStatus(); err != nil {
+			return err
+		}
+		if n := len(status.Jobs); n != remaining && !status.Done() {
+			fmt.Printf("waiting for %d jobs to move\n", n)
+			remaining = n
+		}
+	}
+	if status.TimedOut {
+		return fmt.Errorf("timed out draining host %s with %d jobs remaining: %s", h.ID(), len(status.Jobs), strings.Join(status.Jobs, " "))
+	}
+	fmt.Printf("host %s drained in %s\n", h.ID(), status.CompletedAt.Sub(status.StartedAt))
+	return nil
+}
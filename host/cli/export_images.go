@@ -0,0 +1,364 @@
+package cli
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/ghrelease"
+	"github.com/flynn/flynn/pkg/verify"
+	"github.com/flynn/flynn/pkg/version"
+	"github.com/flynn/go-docopt"
+	"github.com/inconshreveable/log15"
+)
+
+func init() {
+	Register("export-images", runExportImages, `
+usage: flynn-host export-images [options] <file>
+
+Options:
+  -b --bin-dir=<dir>  directory containing the flynn-host and flynn-init binaries
+                      to include in the export [default: /usr/local/bin]
+  --version=<ver>     version to name the export (defaults to the version of flynn-host)
+  --system-only       only export the images of system apps
+
+Export the images referenced by the current release of each app, along with
+their layers, to a tarball.
+
+The tarball has the same layout as a release tarball, so it can be used to
+seed new hosts or to restore the images in another cluster with
+'flynn-host update --tarball', which makes it useful for cloning a cluster and
+for disaster recovery drills. Layers shared between images are only exported
+once, and are read from the local layer cache when present rather than
+fetched from the cluster. Only the layers and binaries for the architecture
+of this host are exported.
+
+System images are named after their component (e.g. "controller" or
+"slugrunner"), and other images after the app which references them.
+
+Examples:
+
+  $ flynn-host export-images /tmp/flynn-images.tar.gz
+  exported 23 images (148 layers) to /tmp/flynn-images.tar.gz
+`)
+}
+
+const (
+	layerCacheDir         = "/var/lib/flynn/layer-cache"
+	layerCacheURLTemplate = "file://" + layerCacheDir + "/{id}.squashfs"
+	exportImagesTimeout   = 30 * time.Minute
+)
+
+func runExportImages(args *docopt.Args) error {
+	log := log15.New()
+	ver := args.String["--version"]
+	if ver == "" {
+		ver = version.String()
+	}
+
+	client, err := getControllerClient()
+	if err != nil {
+		return err
+	}
+	images, err := currentImages(client, args.Bool["--system-only"])
+	if err != nil {
+		return err
+	}
+
+	binDir := args.String["--bin-dir"]
+	binaries := map[string]string{
+		ghrelease.HostAssetName("flynn-host") + ".gz": filepath.Join(binDir, "flynn-host"),
+		ghrelease.HostAssetName("flynn-init") + ".gz": filepath.Join(binDir, "flynn-init"),
+	}
+
+	httpClient := &http.Client{
+		Transport: &http.Transport{Dial: dialDiscoverd},
+		Timeout:   exportImagesTimeout,
+	}
+	openLayer := func(artifact *ct.Artifact, layer *ct.ImageLayer) (io.ReadCloser, error) {
+		return openImageLayer(httpClient, artifact, layer, log)
+	}
+
+	file := args.String["<file>"]
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	layers, err := writeImageExport(f, ver, images, binaries, nil, openLayer)
+	if err != nil {
+		f.Close()
+		os.Remove(file)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("exported %d images (%d layers) to %s\n", len(images), layers, file)
+	return nil
+}
+
+// currentImages returns the image artifacts referenced by the current release
+// of each app, keyed by the name they are exported with.
+func currentImages(client controller.Client, systemOnly bool) (map[string]*ct.Artifact, error) {
+	apps, err := client.AppList()
+	if err != nil {
+		return nil, err
+	}
+	images := make(map[string]*ct.Artifact)
+	for _, app := range apps {
+		if app.ReleaseID == "" || systemOnly && !app.System() {
+			continue
+		}
+		release, err := client.GetRelease(app.ReleaseID)
+		if err != nil {
+			return nil, fmt.Errorf("error getting release of app %s: %s", app.Name, err)
+		}
+		for i, id := range release.ArtifactIDs {
+			artifact, err := client.GetArtifact(id)
+			if err != nil {
+				return nil, fmt.Errorf("error getting artifact %s of app %s: %s", id, app.Name, err)
+			}
+			if artifact.Type != ct.ArtifactTypeFlynn {
+				continue
+			}
+			images[exportImageName(app, i, artifact)] = artifact
+		}
+	}
+	return images, nil
+}
+
+// exportImageName returns the name to export the artifact at the given index
+// of an app's release with. System images are named after their component so
+// that the export can be used to update system apps.
+func exportImageName(app *ct.App, index int, artifact *ct.Artifact) string {
+	if component := artifact.Meta["flynn.component"]; component != "" {
+		return component
+	}
+	if index == 0 {
+		return app.Name
+	}
+	return app.Name + "-" + strconv.Itoa(index)
+}
+
+// openImageLayer opens a layer from the local layer cache, falling back to
+// fetching it from the artifact's layer URL.
+func openImageLayer(httpClient *http.Client, artifact *ct.Artifact, layer *ct.ImageLayer, log log15.Logger) (io.ReadCloser, error) {
+	if f, err := os.Open(filepath.Join(layerCacheDir, layer.ID+".squashfs")); err == nil {
+		return f, nil
+	}
+	layerURL := artifact.LayerURL(layer)
+	u, err := url.Parse(layerURL)
+	if err != nil || layerURL == "" {
+		return nil, fmt.Errorf("layer %s has no URL", layer.ID)
+	}
+	log.Info("fetching layer", "layer", layer.ID, "url", layerURL)
+	switch u.Scheme {
+	case "file":
+		return os.Open(u.Path)
+	case "http", "https":
+		res, err := httpClient.Get(layerURL)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			return nil, fmt.Errorf("unexpected HTTP status fetching layer %s: %s", layer.ID, res.Status)
+		}
+		return res.Body, nil
+	default:
+		return nil, fmt.Errorf("unknown layer URI scheme: %s", u.Scheme)
+	}
+}
+
+// writeImageExport writes a gzipped tarball to w with the same layout as a
+// release tarball: an images manifest referencing the layer cache, each
+// distinct layer of the images, the gzipped binaries and config files (keyed
+// by their name in the tarball) and their checksums. It returns the number
+// of layers written.
+func writeImageExport(w io.Writer, ver string, images map[string]*ct.Artifact, binaries, config map[string]string, openLayer func(*ct.Artifact, *ct.ImageLayer) (io.ReadCloser, error)) (int, error) {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	dir := "flynn-" + ver
+	checksums := make(map[string]string)
+
+	addFile := func(name string, mode int64, size int64, r io.Reader) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    path.Join(dir, name),
+			Mode:    mode,
+			Size:    size,
+			ModTime: time.Now(),
+		}); err != nil {
+			return err
+		}
+		h := sha512.New()
+		if _, err := io.Copy(tw, io.TeeReader(r, h)); err != nil {
+			return err
+		}
+		checksums[name] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	}
+	addBuffered := func(name string, mode int64, write func(io.Writer) error) error {
+		tmp, err := ioutil.TempFile("", "flynn-export-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		if err := write(tmp); err != nil {
+			return err
+		}
+		size, err := tmp.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return addFile(name, mode, size, tmp)
+	}
+
+	if err := tw.WriteHeader(&tar.Header{Name: dir + "/", Mode: 0755, Typeflag: tar.TypeDir, ModTime: time.Now()}); err != nil {
+		return 0, err
+	}
+
+	// write the manifest with layers referencing the layer cache, which is
+	// where hosts store layers pulled from the tarball
+	manifest := make(map[string]*ct.Artifact, len(images))
+	names := make([]string, 0, len(images))
+	for name, a := range images {
+		manifest[name] = &ct.Artifact{
+			ID:               a.ID,
+			Type:             a.Type,
+			URI:              a.URI,
+			Meta:             a.Meta,
+			RawManifest:      a.RawManifest,
+			Hashes:           a.Hashes,
+			Size:             a.Size,
+			LayerURLTemplate: layerCacheURLTemplate,
+			CreatedAt:        a.CreatedAt,
+		}
+		names = append(names, name)
+	}
+	if err := addBuffered("images.json.gz", 0644, func(w io.Writer) error {
+		gz := gzip.NewWriter(w)
+		if err := json.NewEncoder(gz).Encode(manifest); err != nil {
+			return err
+		}
+		return gz.Close()
+	}); err != nil {
+		return 0, err
+	}
+
+	// write each distinct layer, verifying it against the manifest
+	sort.Strings(names)
+	written := make(map[string]struct{})
+	for _, name := range names {
+		artifact := images[name]
+		for _, rootfs := range artifact.Manifest().RootfsForArch(runtime.GOARCH) {
+			for _, layer := range rootfs.Layers {
+				if _, ok := written[layer.ID]; ok {
+					continue
+				}
+				if err := addBuffered(layer.ID+".squashfs", 0644, func(w io.Writer) error {
+					r, err := openLayer(artifact, layer)
+					if err != nil {
+						return fmt.Errorf("error opening layer %s of image %s: %s", layer.ID, name, err)
+					}
+					defer r.Close()
+					if layer.Length <= 0 || len(layer.Hashes) == 0 {
+						_, err = io.Copy(w, r)
+						return err
+					}
+					v, err := verify.NewVerifier(layer.Hashes, layer.Length)
+					if err != nil {
+						return err
+					}
+					if _, err := io.Copy(w, v.Reader(r)); err != nil {
+						return err
+					}
+					if err := v.Verify(); err != nil {
+						return fmt.Errorf("error verifying layer %s of image %s: %s", layer.ID, name, err)
+					}
+					return nil
+				}); err != nil {
+					return 0, err
+				}
+				written[layer.ID] = struct{}{}
+			}
+		}
+	}
+
+	// write the gzipped binaries so the export can also update hosts
+	addGzipped := func(files map[string]string, mode int64) error {
+		names := make([]string, 0, len(files))
+		for name := range files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := addBuffered(name, mode, func(w io.Writer) error {
+				f, err := os.Open(files[name])
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				gz := gzip.NewWriter(w)
+				if _, err := io.Copy(gz, f); err != nil {
+					return err
+				}
+				return gz.Close()
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := addGzipped(binaries, 0755); err != nil {
+		return 0, err
+	}
+	if err := addGzipped(config, 0644); err != nil {
+		return 0, err
+	}
+
+	checksumNames := make([]string, 0, len(checksums))
+	for name := range checksums {
+		checksumNames = append(checksumNames, name)
+	}
+	sort.Strings(checksumNames)
+	var sums []byte
+	for _, name := range checksumNames {
+		sums = append(sums, fmt.Sprintf("%s  %s\n", checksums[name], name)...)
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    path.Join(dir, "checksums.sha512"),
+		Mode:    0644,
+		Size:    int64(len(sums)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return 0, err
+	}
+	if _, err := tw.Write(sums); err != nil {
+		return 0, err
+	}
+
+	if err := tw.Close(); err != nil {
+		return 0, err
+	}
+	return len(written), gz.Close()
+}
@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	ct "github.com/flynn/flynn/controller/types"
+)
+
+func testImage(layers ...string) *ct.Artifact {
+	rootfs := &ct.ImageRootfs{}
+	for _, id := range layers {
+		rootfs.Layers = append(rootfs.Layers, &ct.ImageLayer{ID: id})
+	}
+	manifest, _ := json.Marshal(&ct.ImageManifest{Type: ct.ImageManifestTypeV1, Rootfs: []*ct.ImageRootfs{rootfs}})
+	return &ct.Artifact{
+		Type:             ct.ArtifactTypeFlynn,
+		RawManifest:      manifest,
+		LayerURLTemplate: "http://blobstore.discoverd/layers/{id}.squashfs",
+	}
+}
+
+func TestWriteImageExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export-images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bin := filepath.Join(dir, "flynn-host")
+	if err := ioutil.WriteFile(bin, []byte("binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	images := map[string]*ct.Artifact{
+		"controller": testImage("base", "controller"),
+		"myapp":      testImage("base", "myapp"),
+	}
+	opened := make(map[string]int)
+	openLayer := func(a *ct.Artifact, l *ct.ImageLayer) (io.ReadCloser, error) {
+		opened[l.ID]++
+		return ioutil.NopCloser(strings.NewReader("layer " + l.ID)), nil
+	}
+
+	var buf bytes.Buffer
+	layers, err := writeImageExport(&buf, "v20260101.0", images, map[string]string{"flynn-host-linux-amd64.gz": bin}, nil, openLayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layers != 3 {
+		t.Fatalf("expected 3 layers, got %d", layers)
+	}
+	if opened["base"] != 1 {
+		t.Fatalf("expected shared layer to be exported once, was opened %d times", opened["base"])
+	}
+
+	tarball := filepath.Join(dir, "export.tar.gz")
+	if err := ioutil.WriteFile(tarball, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	version, contentDir, err := extractTarball(tarball, filepath.Join(dir, "extract"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "v20260101.0" {
+		t.Fatalf("unexpected version %q", version)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(contentDir, "base.squashfs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "layer base" {
+		t.Fatalf("unexpected layer contents %q", data)
+	}
+
+	checksums, err := parseChecksums(filepath.Join(contentDir, "checksums.sha512"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"images.json.gz", "base.squashfs", "flynn-host-linux-amd64.gz"} {
+		sum, ok := checksums[name]
+		if !ok {
+			t.Fatalf("missing checksum for %s", name)
+		}
+		if err := verifyChecksum(filepath.Join(contentDir, name), sum); err != nil {
+			t.Fatalf("checksum of %s: %s", name, err)
+		}
+	}
+
+	f, err := os.Open(filepath.Join(contentDir, "images.json.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest map[string]*ct.Artifact
+	if err := json.NewDecoder(gz).Decode(&manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest) != 2 {
+		t.Fatalf("expected 2 images in manifest, got %d", len(manifest))
+	}
+	if url := manifest["myapp"].LayerURL(&ct.ImageLayer{ID: "myapp"}); url != "file:///var/lib/flynn/layer-cache/myapp.squashfs" {
+		t.Fatalf("unexpected layer URL %q", url)
+	}
+}
+
+func TestExportImageName(t *testing.T) {
+	app := &ct.App{Name: "myapp"}
+	for _, x := range []struct {
+		index    int
+		artifact *ct.Artifact
+		expected string
+	}{
+		{0, &ct.Artifact{Meta: map[string]string{"flynn.component": "slugrunner"}}, "slugrunner"},
+		{0, &ct.Artifact{}, "myapp"},
+		{1, &ct.Artifact{}, "myapp-1"},
+	} {
+		if name := exportImageName(app, x.index, x.artifact); name != x.expected {
+			t.Errorf("expected %q, got %q", x.expected, name)
+		}
+	}
+}
@@ -2,10 +2,10 @@ package cli
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
-	"crypto/sha512"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -22,6 +22,7 @@ import (
 	ct "github.com/flynn/flynn/controller/types"
 	discoverd "github.com/flynn/flynn/discoverd/client"
 	"github.com/flynn/flynn/host/downloader"
+	"github.com/flynn/flynn/host/types"
 	"github.com/flynn/flynn/pkg/cluster"
 	"github.com/flynn/flynn/pkg/dialer"
 	"github.com/flynn/flynn/pkg/ghrelease"
@@ -75,8 +76,9 @@ func clusterHostCount() (int, error) {
 	return len(hosts), nil
 }
 
-// runGitHubUpdate performs an update using GitHub Releases
-func runGitHubUpdate(args *docopt.Args, repo, configDir string, log log15.Logger) error {
+// runGitHubUpdate performs an update using GitHub Releases, taking the
+// newest release on the given channel unless --version is set
+func runGitHubUpdate(args *docopt.Args, repo string, channel ghrelease.Channel, configDir string, log log15.Logger) error {
 	client := ghrelease.NewClient(repo, log)
 	binDir := args.String["--bin-dir"]
 	targetVersion := args.String["--version"]
@@ -84,7 +86,9 @@ func runGitHubUpdate(args *docopt.Args, repo, configDir string, log log15.Logger
 	force := args.Bool["--force"]
 	skipImages := args.Bool["--skip-images"]
 	imagesOnly := args.Bool["--images-only"]
-	allNodes := args.Bool["--all-nodes"]
+	// --hosts targets an explicit subset of the cluster, so it implies
+	// pushing to (selected) remote hosts just like --all-nodes.
+	allNodes := args.Bool["--all-nodes"] || updateHostSelector != nil
 
 	if imagesOnly && !allNodes {
 		n, err := clusterHostCount()
@@ -96,17 +100,21 @@ func runGitHubUpdate(args *docopt.Args, repo, configDir string, log log15.Logger
 		}
 	}
 
+	if targetVersion == "" {
+		targetVersion = updateRun.resumeVersion()
+	}
+
 	currentVersion := version.String()
-	log.Info("checking for updates", "repo", repo, "current_version", currentVersion)
+	log.Info("checking for updates", "repo", repo, "channel", channel, "current_version", currentVersion)
 
-	// Get release (latest or specific version)
+	// Get release (newest on the channel or specific version)
 	var release *ghrelease.Release
 	var err error
 	if targetVersion != "" {
 		log.Info("fetching specific version", "version", targetVersion)
 		release, err = client.GetReleaseByTag(targetVersion)
 	} else {
-		release, err = client.GetLatestRelease()
+		release, err = client.GetChannelRelease(channel)
 	}
 	if err != nil {
 		log.Error("failed to get release info", "err", err)
@@ -115,11 +123,18 @@ func runGitHubUpdate(args *docopt.Args, repo, configDir string, log log15.Logger
 
 	log.Info("found release", "version", release.TagName, "published", release.PublishedAt)
 
-	// Check if update is needed
-	if !force && !ghrelease.CompareVersions(currentVersion, release.TagName) {
-		log.Info("already on latest version", "version", currentVersion)
+	// Check if update is needed, which it is when resuming even if this
+	// host has already been updated
+	if !force && !updateRun.resuming() && !ghrelease.CompareVersions(currentVersion, release.TagName) {
+		log.Info("already on latest version", "version", currentVersion, "channel", channel)
 		if checkOnly {
 			fmt.Printf("Already on latest version: %s\n", currentVersion)
+			return nil
+		}
+		// record an explicitly given channel so that later updates
+		// follow it even though there is nothing to update to yet
+		if args.String["--channel"] != "" {
+			saveInstallChannel(configDir, channel, log)
 		}
 		return nil
 	}
@@ -130,6 +145,9 @@ func runGitHubUpdate(args *docopt.Args, repo, configDir string, log log15.Logger
 	}
 
 	log.Info("updating to version", "version", release.TagName)
+	if err := updateRun.start(release.TagName, installsource.SourceGitHub, repo); err != nil {
+		return err
+	}
 
 	// Image rollout touches the whole cluster; require --all-nodes for multi-host,
 	// but a single registered host is always "all" peers.
@@ -156,64 +174,71 @@ func runGitHubUpdate(args *docopt.Args, repo, configDir string, log log15.Logger
 		}
 		defer os.RemoveAll(tmpDir)
 
-		// Download checksums first
-		checksumURL := ghrelease.GetReleaseURL(repo, release.TagName) + "/checksums.sha512"
-		checksumPath := filepath.Join(tmpDir, "checksums.sha512")
-		if err := client.DownloadFile(checksumURL, checksumPath); err != nil {
-			log.Error("failed to download checksums", "err", err)
-			return err
-		}
-
-		checksums, err := parseChecksums(checksumPath)
+		// Download and verify checksums first
+		checksums, err := client.DownloadChecksums(release.TagName, tmpDir)
 		if err != nil {
-			log.Error("failed to parse checksums", "err", err)
+			log.Error("failed to download checksums", "err", err)
 			return err
 		}
 
-		// Download and install binaries
-		binaries := []struct {
-			name     string
-			destName string
-		}{
-			{"flynn-host-linux-amd64.gz", "flynn-host"},
-			{"flynn-init-linux-amd64.gz", "flynn-init"},
-		}
+		// With --hosts, this host is only updated if it is selected
+		localSelected := localHostSelected(log)
+		if localSelected && updateRun.localHostDone(log) {
+			log.Info("skipping local binary update, already updated by the update being resumed")
+			fmt.Println("This host was already updated, skipping local binary update")
+		} else if localSelected {
+			updateRun.startHost()
+
+			// Download and install binaries
+			binaries := []struct {
+				name     string
+				destName string
+			}{
+				{ghrelease.HostAssetName("flynn-host") + ".gz", "flynn-host"},
+				{ghrelease.HostAssetName("flynn-init") + ".gz", "flynn-init"},
+			}
 
-		for _, bin := range binaries {
-			if err := downloadAndInstallBinary(client, repo, release.TagName, bin.name, bin.destName, tmpDir, binDir, checksums, log); err != nil {
-				return err
+			for _, bin := range binaries {
+				if err := downloadAndInstallBinary(client, repo, release.TagName, bin.name, bin.destName, tmpDir, binDir, checksums, log); err != nil {
+					return err
+				}
 			}
-		}
 
-		// Update install-source.json
-		source := installsource.NewGitHubSource(repo, release.TagName)
-		if err := installsource.Save(configDir, source); err != nil {
-			log.Warn("failed to update install-source.json", "err", err)
-			// Don't fail the update for this
-		}
+			// Update install-source.json
+			source := installsource.NewGitHubSource(repo, release.TagName)
+			source.Channel = string(channel)
+			if err := installsource.Save(configDir, source); err != nil {
+				log.Warn("failed to update install-source.json", "err", err)
+				// Don't fail the update for this
+			}
 
-		log.Info("binaries downloaded", "version", release.TagName)
-		fmt.Printf("Flynn binaries updated to %s\n", release.TagName)
+			log.Info("binaries downloaded", "version", release.TagName)
+			fmt.Printf("Flynn binaries updated to %s\n", release.TagName)
 
-		// Trigger zero-downtime daemon restart unless --no-restart was specified
-		if !args.Bool["--no-restart"] {
-			restarted, err := restartDaemon(binDir, log)
-			if err != nil {
-				return err
-			}
-			if restarted {
-				fmt.Printf("Flynn daemon restarted with version %s\n", release.TagName)
+			// Trigger zero-downtime daemon restart unless --no-restart was specified
+			if !args.Bool["--no-restart"] {
+				restarted, err := restartDaemon(binDir, log)
+				if err != nil {
+					return err
+				}
+				if restarted {
+					fmt.Printf("Flynn daemon restarted with version %s\n", release.TagName)
+					updateRun.markLocalHost(log)
+				}
+			} else {
+				log.Info("skipping daemon restart (--no-restart specified)")
+				fmt.Println("Daemon restart skipped. Restart manually to activate the new version.")
 			}
 		} else {
-			log.Info("skipping daemon restart (--no-restart specified)")
-			fmt.Println("Daemon restart skipped. Restart manually to activate the new version.")
+			log.Info("skipping local binary update, host not matched by --hosts", "hosts", updateHostSelector)
+			fmt.Printf("This host is not matched by --hosts=%s, skipping local binary update\n", updateHostSelector)
 		}
 
 		if allNodes {
 			// Wait for the cluster to settle after the local restart before
 			// touching remote hosts — same gates as the per-remote-host loop
 			// (health, discoverd host count, sirenia leaders, scheduler jobs).
-			if !args.Bool["--no-restart"] {
+			if !args.Bool["--no-restart"] && localSelected {
 				clusterClient := cluster.NewClient()
 				expectedHosts := expectedClusterHostCount(log)
 				if err := settleAfterHostRestart(hostRestartSettleOptions{
@@ -223,7 +248,7 @@ func runGitHubUpdate(args *docopt.Args, repo, configDir string, log log15.Logger
 					ExpectedHostCount: expectedHosts,
 					FatalClusterSize:  expectedHosts > 1,
 				}); err != nil {
-					return fmt.Errorf("cluster did not recover after local restart: %w", err)
+					return updateRun.fail("local host restart", fmt.Errorf("cluster did not recover after local restart: %w", err))
 				}
 			}
 
@@ -248,10 +273,30 @@ func runGitHubUpdate(args *docopt.Args, repo, configDir string, log log15.Logger
 		}
 	}
 
+	updateRun.finish()
 	log.Info("update complete", "version", release.TagName)
 	return nil
 }
 
+// saveInstallChannel records the release channel in install-source.json,
+// logging rather than returning errors since it does not affect the update.
+func saveInstallChannel(configDir string, channel ghrelease.Channel, log log15.Logger) {
+	source, err := installsource.Load(configDir)
+	if err != nil {
+		log.Warn("failed to load install-source.json, not recording channel", "err", err)
+		return
+	}
+	if source.Channel == string(channel) {
+		return
+	}
+	source.Channel = string(channel)
+	if err := installsource.Save(configDir, source); err != nil {
+		log.Warn("failed to update install-source.json", "err", err)
+		return
+	}
+	fmt.Printf("Updates will now follow the %s channel\n", channel)
+}
+
 // restartDaemon restarts the local flynn-host daemon using systemctl.
 // This ensures systemd properly tracks the new daemon process.
 // restartDaemon returns true if the daemon was actually restarted, false if
@@ -365,10 +410,27 @@ func updateRemoteBinaries(repo, binDir, configDir, version, baseURL string, noRe
 
 	log.Info("updating remote hosts", "total_hosts", len(hosts), "local_host", localHostID)
 
+	// prefer restarting hosts which are in their maintenance windows
+	hosts = orderHostsByMaintenance(hosts, log)
+
 	for _, h := range hosts {
 		if h.ID() == localHostID {
 			continue
 		}
+		if !updateHostSelector.MatchHost(h) {
+			log.Info("skipping remote host not matched by --hosts", "remote_host", h.ID())
+			continue
+		}
+		if updateRun.hostDone(h.ID()) {
+			log.Info("skipping remote host already updated by the update being resumed", "remote_host", h.ID())
+			continue
+		}
+
+		updateRun.startHost()
+		fail := func(step string, err error) error {
+			updateRun.recordHost(h.ID(), err)
+			return updateRun.fail(step, err)
+		}
 
 		hostLog := log.New("remote_host", h.ID())
 		hostLog.Info("pulling binaries on remote host")
@@ -377,18 +439,24 @@ func updateRemoteBinaries(repo, binDir, configDir, version, baseURL string, noRe
 		_, err := h.PullBinariesAndConfig(repo, binDir, configDir, version, baseURL, nil)
 		if err != nil {
 			hostLog.Error("failed to pull binaries on remote host", "err", err)
-			return expectedHostCount, fmt.Errorf("failed to update binaries on host %s: %w", h.ID(), err)
+			return expectedHostCount, fail("update binaries on "+h.ID(), fmt.Errorf("failed to update binaries on host %s: %w", h.ID(), err))
 		}
 		hostLog.Info("binaries updated on remote host")
 		fmt.Printf("Binaries updated on %s\n", h.ID())
 
 		if !noRestart {
+			if updateWaitForMaintenance {
+				if err := waitForMaintenanceWindow(h, hostLog); err != nil {
+					return expectedHostCount, fail("wait for maintenance window of "+h.ID(), err)
+				}
+			}
+
 			hostLog.Info("restarting daemon on remote host via systemctl")
 			fmt.Printf("Restarting flynn-host daemon on %s via systemctl...\n", h.ID())
 
 			if err := h.SystemctlRestart(); err != nil {
 				hostLog.Error("error requesting systemctl restart on remote host", "err", err)
-				return expectedHostCount, fmt.Errorf("failed to restart daemon on host %s: %w", h.ID(), err)
+				return expectedHostCount, fail("restart "+h.ID(), fmt.Errorf("failed to restart daemon on host %s: %w", h.ID(), err))
 			}
 
 			hostLog.Info("systemctl restart requested on remote host")
@@ -407,7 +475,11 @@ func updateRemoteBinaries(repo, binDir, configDir, version, baseURL string, noRe
 			// old process to actually die before polling.
 			time.Sleep(5 * time.Second)
 			if err := waitForRemoteDaemon(h, 3*time.Minute, hostLog); err != nil {
-				return expectedHostCount, fmt.Errorf("daemon on host %s did not become responsive after restart: %w", h.ID(), err)
+				return expectedHostCount, fail("restart "+h.ID(), fmt.Errorf("daemon on host %s did not become responsive after restart: %w", h.ID(), err))
+			}
+			if err := updateRun.check("verify version of "+h.ID(), checkHostVersion(h, version)); err != nil {
+				updateRun.recordHost(h.ID(), err)
+				return expectedHostCount, err
 			}
 
 			if err := settleAfterHostRestart(hostRestartSettleOptions{
@@ -418,9 +490,10 @@ func updateRemoteBinaries(repo, binDir, configDir, version, baseURL string, noRe
 				FatalClusterSize:  true,
 				InterHostDelay:    true,
 			}); err != nil {
-				return expectedHostCount, fmt.Errorf("cluster did not recover after restarting %s: %w", h.ID(), err)
+				return expectedHostCount, fail("settle after restarting "+h.ID(), fmt.Errorf("cluster did not recover after restarting %s: %w", h.ID(), err))
 			}
 		}
+		updateRun.markHost(h.ID())
 	}
 
 	if !noRestart && expectedHostCount > 1 {
@@ -431,7 +504,7 @@ func updateRemoteBinaries(repo, binDir, configDir, version, baseURL string, noRe
 			ExpectedHostCount: expectedHostCount,
 			FatalClusterSize:  false,
 		}); err != nil {
-			return expectedHostCount, err
+			return expectedHostCount, updateRun.fail("final cluster settle", err)
 		}
 	}
 
@@ -776,19 +849,37 @@ func parseChecksums(path string) (map[string]string, error) {
 	if err != nil {
 		return nil, err
 	}
+	return ghrelease.ParseChecksums(data), nil
+}
 
-	checksums := make(map[string]string)
-	for _, line := range strings.Split(string(data), "\n") {
-		parts := strings.Fields(line)
-		if len(parts) == 2 {
-			// Strip common prefixes from filename (*, ./, etc.)
-			filename := parts[1]
-			filename = strings.TrimPrefix(filename, "*")
-			filename = strings.TrimPrefix(filename, "./")
-			checksums[filename] = parts[0]
-		}
+// releasePublicKey returns the release signing key, it is a variable so it
+// can be replaced in tests.
+var releasePublicKey = ghrelease.PublicKey
+
+// verifyTarballChecksums reads the checksums in a tarball's content dir and
+// checks their signature. If a release signing key is configured then both
+// the checksums and their signature are required, otherwise the checksums
+// are used as is since tarballs exported from a cluster are not signed.
+func verifyTarballChecksums(contentDir string, log log15.Logger) (map[string]string, error) {
+	_, keyErr := releasePublicKey()
+	signed := keyErr != ghrelease.ErrNoPublicKey
+
+	checksums, err := os.ReadFile(filepath.Join(contentDir, ghrelease.ChecksumsName))
+	if os.IsNotExist(err) && signed {
+		return nil, fmt.Errorf("tarball has no %s", ghrelease.ChecksumsName)
+	} else if err != nil {
+		return nil, err
 	}
-	return checksums, nil
+	if !signed {
+		return ghrelease.VerifyChecksums(checksums, nil, log)
+	}
+	sig, err := os.ReadFile(filepath.Join(contentDir, ghrelease.ChecksumsSignatureName))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("tarball %s is not signed", ghrelease.ChecksumsName)
+	} else if err != nil {
+		return nil, err
+	}
+	return ghrelease.VerifyChecksums(checksums, sig, log)
 }
 
 // downloadAndInstallBinary downloads, verifies, and installs a single binary
@@ -825,22 +916,7 @@ func downloadAndInstallBinary(client *ghrelease.Client, repo, version, assetName
 
 // verifyChecksum verifies a file's SHA512 checksum
 func verifyChecksum(path, expected string) error {
-	f, err := os.Open(path)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	h := sha512.New()
-	if _, err := io.Copy(h, f); err != nil {
-		return err
-	}
-
-	actual := hex.EncodeToString(h.Sum(nil))
-	if actual != expected {
-		return fmt.Errorf("checksum mismatch: expected %s, got %s", expected, actual)
-	}
-	return nil
+	return ghrelease.VerifyChecksum(path, expected)
 }
 
 // decompressAndInstall decompresses a gzipped file and installs it atomically
@@ -878,35 +954,139 @@ func decompressAndInstall(gzPath, destPath string, log log15.Logger) error {
 
 const deployTimeout = 30 * time.Minute
 
-// updateImages downloads the images manifest, triggers image-layer pulls
-// on every cluster host in parallel, then deploys system apps via the
-// controller. If baseURL is non-empty, images are fetched from that URL
-// instead of GitHub. When force is true, system apps are redeployed even
-// if the image manifest matches the currently deployed artifact.
-// expectedHosts is the cluster size observed before any rolling restart;
-// when > 1, we wait for that many hosts to be visible in discoverd
-// before fanning out, so a partially-rejoined cluster doesn't silently
-// skip nodes.
-func updateImages(repo, configDir, targetVersion, baseURL string, force bool, expectedHosts int, log log15.Logger) error {
-	// Create downloader (without volume manager - we're just getting the manifest)
-	var d *downloader.Downloader
-	if baseURL != "" {
-		log.Info("downloading images manifest from base URL", "base_url", baseURL, "version", targetVersion)
-		d = downloader.NewWithBaseURL(baseURL, nil, targetVersion, log)
-	} else {
-		log.Info("downloading images manifest from GitHub", "repo", repo, "version", targetVersion)
-		d = downloader.New(repo, nil, targetVersion, log)
+// pullImagesOnHost pulls the images of a release on a host, retrying
+// transient failures. If manifest is set, it is sent as the images manifest
+// rather than the host downloading it.
+func pullImagesOnHost(h *cluster.Host, repo, configDir, targetVersion, baseURL string, manifest []byte, log log15.Logger) error {
+	hostLog := log.New("host", h.ID())
+	hostLog.Info("starting image pull on host")
+
+	// Retry image pulls up to 3 times to handle transient
+	// connection errors (e.g. "unexpected EOF" from network
+	// hiccups or host daemon instability after binary update).
+	// Layer downloads are idempotent — already-cached layers
+	// are skipped on retry.
+	const maxPullAttempts = 3
+	var lastErr error
+	for attempt := 1; attempt <= maxPullAttempts; attempt++ {
+		if attempt > 1 {
+			hostLog.Warn("retrying image pull", "attempt", attempt, "previous_err", lastErr)
+			time.Sleep(5 * time.Second)
+		}
+
+		// Create a channel to consume ImagePullInfo events
+		ch := make(chan *ct.ImagePullInfo)
+
+		// Trigger the pull on this host
+		var body io.Reader
+		if manifest != nil {
+			body = bytes.NewReader(manifest)
+		}
+		stream, err := h.PullImages(repo, configDir, targetVersion, baseURL, body, ch)
+		if err != nil {
+			hostLog.Error("error starting image pull", "err", err)
+			lastErr = fmt.Errorf("error pulling images on host %s: %w", h.ID(), err)
+			continue
+		}
+
+		// Consume all events from the channel, blocking until the
+		// stream is fully drained and the channel is closed.
+		// This must happen BEFORE calling stream.Err() because the
+		// stream's error is only set after the SSE decoder goroutine
+		// finishes and closes the channel.
+		for info := range ch {
+			if info.Type == ct.ImagePullTypeLayer {
+				hostLog.Debug("downloading layer", "layer", info.Layer.ID)
+			}
+		}
+
+		// Now it's safe to check for errors
+		if err := stream.Err(); err != nil {
+			hostLog.Error("image pull failed", "err", err)
+			lastErr = fmt.Errorf("image pull failed on host %s: %w", h.ID(), err)
+			continue
+		}
+
+		lastErr = nil
+		hostLog.Info("finished image pull on host")
+		break
 	}
 
-	// Download images manifest
-	images, err := d.DownloadImagesManifest(configDir)
-	if err != nil {
-		log.Error("error downloading images manifest", "err", err)
-		return err
+	return lastErr
+}
+
+// errNoLocalLayerSource is returned by updateImages when images cannot be
+// distributed from the local host's layer cache, in which case they must be
+// served to the cluster by a file server.
+var errNoLocalLayerSource = errors.New("local host cannot serve image layers to the cluster")
+
+// pullLocalLayerSource pulls images from a file:// base URL on the local
+// host and waits for it to advertise the layers of every image so that the
+// other hosts can fetch them from its layer cache, returning the local host.
+func pullLocalLayerSource(hosts []*cluster.Host, repo, configDir, targetVersion, baseURL string, images map[string]*ct.Artifact, log log15.Logger) (*cluster.Host, error) {
+	local := localClusterHost(log)
+	if local == nil {
+		return nil, fmt.Errorf("%w: could not find the local host", errNoLocalLayerSource)
+	}
+	selected := false
+	for _, h := range hosts {
+		if h.ID() == local.ID() {
+			selected = true
+		}
+	}
+	if !selected {
+		return nil, fmt.Errorf("%w: local host %s is not being updated", errNoLocalLayerSource, local.ID())
 	}
 
-	log.Info("downloaded images manifest", "num_images", len(images))
+	log.Info("pulling images on the local host to serve layers to the cluster", "host", local.ID())
+	if err := pullImagesOnHost(local, repo, configDir, targetVersion, baseURL, nil, log); err != nil {
+		return nil, fmt.Errorf("%w: %s", errNoLocalLayerSource, err)
+	}
 
+	needed := make(map[string]bool)
+	for _, artifact := range images {
+		if manifest := artifact.Manifest(); manifest != nil {
+			for _, rootfs := range manifest.Rootfs {
+				for _, layer := range rootfs.Layers {
+					needed[layer.ID] = true
+				}
+			}
+		}
+	}
+	// the advertised layers are updated in discoverd asynchronously
+	for start := time.Now(); time.Since(start) < 30*time.Second; time.Sleep(time.Second) {
+		instances, err := discoverd.GetInstances("flynn-host", 5*time.Second)
+		if err != nil {
+			continue
+		}
+		for _, inst := range instances {
+			if inst.Meta["id"] != local.ID() {
+				continue
+			}
+			advertised := make(map[string]bool)
+			for _, id := range strings.Split(inst.Meta[host.LayersMetaKey], ",") {
+				advertised[id] = true
+			}
+			missing := 0
+			for id := range needed {
+				if !advertised[id] {
+					missing++
+				}
+			}
+			if missing == 0 {
+				log.Info("local host is serving image layers", "host", local.ID(), "num_layers", len(needed))
+				return local, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: local host %s is not advertising the image layers", errNoLocalLayerSource, local.ID())
+}
+
+// pullClusterImages pulls the images in the manifest on every cluster host
+// matched by --hosts. A file:// base URL is pulled by the local host which
+// then serves the layers to the others, returning errNoLocalLayerSource
+// before any other host is updated if it cannot.
+func pullClusterImages(repo, configDir, targetVersion, baseURL string, images map[string]*ct.Artifact, expectedHosts int, log log15.Logger) error {
 	// Download image layers on ALL nodes in the cluster
 	// The images.json contains file:// URIs that reference local paths,
 	// so we need to download the actual layer files on every node before deploying
@@ -931,6 +1111,36 @@ func updateImages(repo, configDir, targetVersion, baseURL string, force bool, ex
 		log.Warn("found fewer hosts than expected for image pull", "num_hosts", len(hosts), "expected", expectedHosts)
 	}
 	log.Info("found cluster hosts", "num_hosts", len(hosts))
+	if updateHostSelector != nil {
+		hosts = updateHostSelector.filterHosts(hosts)
+		log.Info("restricting image pull to hosts matched by --hosts", "hosts", updateHostSelector, "num_hosts", len(hosts))
+		if len(hosts) == 0 {
+			return fmt.Errorf("no cluster hosts matched --hosts=%s", updateHostSelector)
+		}
+	}
+
+	// A file:// base URL is only readable by the local host, so it pulls
+	// the images first and the other hosts fetch the layers from its layer
+	// cache, given the manifest in the request as they cannot download it.
+	var manifest []byte
+	if strings.HasPrefix(baseURL, "file://") {
+		local, err := pullLocalLayerSource(hosts, repo, configDir, targetVersion, baseURL, images, log)
+		if err != nil {
+			return err
+		}
+		manifest, err = json.Marshal(images)
+		if err != nil {
+			return err
+		}
+		remaining := make([]*cluster.Host, 0, len(hosts)-1)
+		for _, h := range hosts {
+			if h.ID() != local.ID() {
+				remaining = append(remaining, h)
+			}
+		}
+		hosts = remaining
+		baseURL = ""
+	}
 
 	// Trigger image pull on all hosts in parallel
 	var wg sync.WaitGroup
@@ -940,59 +1150,8 @@ func updateImages(repo, configDir, targetVersion, baseURL string, force bool, ex
 		wg.Add(1)
 		go func(h *cluster.Host) {
 			defer wg.Done()
-
-			hostLog := log.New("host", h.ID())
-			hostLog.Info("starting image pull on host")
-
-			// Retry image pulls up to 3 times to handle transient
-			// connection errors (e.g. "unexpected EOF" from network
-			// hiccups or host daemon instability after binary update).
-			// Layer downloads are idempotent — already-cached layers
-			// are skipped on retry.
-			const maxPullAttempts = 3
-			var lastErr error
-			for attempt := 1; attempt <= maxPullAttempts; attempt++ {
-				if attempt > 1 {
-					hostLog.Warn("retrying image pull", "attempt", attempt, "previous_err", lastErr)
-					time.Sleep(5 * time.Second)
-				}
-
-				// Create a channel to consume ImagePullInfo events
-				ch := make(chan *ct.ImagePullInfo)
-
-				// Trigger the pull on this host
-				stream, err := h.PullImages(repo, configDir, targetVersion, baseURL, nil, ch)
-				if err != nil {
-					hostLog.Error("error starting image pull", "err", err)
-					lastErr = fmt.Errorf("error pulling images on host %s: %w", h.ID(), err)
-					continue
-				}
-
-				// Consume all events from the channel, blocking until the
-				// stream is fully drained and the channel is closed.
-				// This must happen BEFORE calling stream.Err() because the
-				// stream's error is only set after the SSE decoder goroutine
-				// finishes and closes the channel.
-				for info := range ch {
-					if info.Type == ct.ImagePullTypeLayer {
-						hostLog.Debug("downloading layer", "layer", info.Layer.ID)
-					}
-				}
-
-				// Now it's safe to check for errors
-				if err := stream.Err(); err != nil {
-					hostLog.Error("image pull failed", "err", err)
-					lastErr = fmt.Errorf("image pull failed on host %s: %w", h.ID(), err)
-					continue
-				}
-
-				lastErr = nil
-				hostLog.Info("finished image pull on host")
-				break
-			}
-
-			if lastErr != nil {
-				errChan <- lastErr
+			if err := pullImagesOnHost(h, repo, configDir, targetVersion, baseURL, manifest, log); err != nil {
+				errChan <- err
 			}
 		}(host)
 	}
@@ -1008,14 +1167,74 @@ func updateImages(repo, configDir, targetVersion, baseURL string, force bool, ex
 		}
 	}
 
+	return nil
+}
+
+// updateImages downloads the images manifest, triggers image-layer pulls
+// on every cluster host in parallel, then deploys system apps via the
+// controller. If baseURL is non-empty, images are fetched from that URL
+// instead of GitHub (see pullClusterImages for file:// base URLs). When
+// force is true, system apps are redeployed even if the image manifest
+// matches the currently deployed artifact. Each system app deploy is gated
+// on the cluster being healthy, and skipped if already deployed by the
+// update being resumed.
+// expectedHosts is the cluster size observed before any rolling restart;
+// when > 1, we wait for that many hosts to be visible in discoverd
+// before fanning out, so a partially-rejoined cluster doesn't silently
+// skip nodes.
+func updateImages(repo, configDir, targetVersion, baseURL string, force bool, expectedHosts int, log log15.Logger) error {
+	// Create downloader (without volume manager - we're just getting the manifest)
+	var d *downloader.Downloader
+	if baseURL != "" {
+		log.Info("downloading images manifest from base URL", "base_url", baseURL, "version", targetVersion)
+		d = downloader.NewWithBaseURL(baseURL, nil, targetVersion, log)
+	} else {
+		log.Info("downloading images manifest from GitHub", "repo", repo, "version", targetVersion)
+		d = downloader.New(repo, nil, targetVersion, log)
+	}
+
+	// Download images manifest
+	images, err := d.DownloadImagesManifest(configDir)
+	if err != nil {
+		log.Error("error downloading images manifest", "err", err)
+		return err
+	}
+
+	log.Info("downloaded images manifest", "num_images", len(images))
+
+	if updateRun.imagesPulled() {
+		log.Info("skipping image pull, already pulled by the update being resumed")
+	} else if err := pullClusterImages(repo, configDir, targetVersion, baseURL, images, expectedHosts, log); errors.Is(err, errNoLocalLayerSource) {
+		return err
+	} else if err != nil {
+		return updateRun.fail("pull images", err)
+	} else {
+		updateRun.markImagesPulled()
+	}
+
 	log.Info("finished downloading image layers on all nodes")
 
+	// System apps run across the whole cluster, so deploying them during a
+	// partial (--hosts) rollout would schedule new images onto hosts still
+	// running the old flynn-host. Defer until the rest of the fleet matches.
+	if updateHostSelector != nil {
+		log.Info("skipping system app deploy for partial host update", "hosts", updateHostSelector)
+		fmt.Println("Skipping system app deploy for a partial --hosts update. Once every host runs this version, run flynn-host update --all-nodes to deploy system apps.")
+		return nil
+	}
+
+	// System apps must only be deployed once every host runs the new
+	// flynn-host, as they may depend on its API.
+	log.Info("verifying flynn-host versions", "version", targetVersion)
+	if err := updateRun.check("verify host versions", checkClusterVersions(cluster.NewClient(), targetVersion)); err != nil {
+		return err
+	}
+
 	// Wait for cluster to be ready after daemon restart.
 	log.Info("waiting for cluster to be ready after daemon restart")
 	statuses, err := waitForClusterHealthy(10*time.Minute, log)
-	if err != nil {
-		log.Warn("cluster health check did not pass after retries, continuing with update", "err", err)
-		fmt.Printf("Warning: %s. The update will continue.\n", err)
+	if err := updateRun.check("cluster health before deploying system apps", err); err != nil {
+		return err
 	}
 
 	// Connect to controller
@@ -1103,17 +1322,17 @@ func updateImages(repo, configDir, targetVersion, baseURL string, force bool, ex
 	redisImage := images["redis"]
 	if err := createArtifactWithRetry("redis", redisImage); err != nil {
 		log.Error(err.Error())
-		return err
+		return updateRun.fail("create image artifacts", err)
 	}
 	slugRunner := images["slugrunner"]
 	if err := createArtifactWithRetry("slugrunner", slugRunner); err != nil {
 		log.Error(err.Error())
-		return err
+		return updateRun.fail("create image artifacts", err)
 	}
 	slugBuilder := images["slugbuilder"]
 	if err := createArtifactWithRetry("slugbuilder", slugBuilder); err != nil {
 		log.Error(err.Error())
-		return err
+		return updateRun.fail("create image artifacts", err)
 	}
 
 	// Deploy system apps in order
@@ -1132,6 +1351,10 @@ func updateImages(repo, configDir, targetVersion, baseURL string, force bool, ex
 			continue
 		}
 		appLog := log.New("name", appInfo.Name)
+		if updateRun.appDone(appInfo.Name) {
+			appLog.Info("skipping system app already deployed by the update being resumed")
+			continue
+		}
 		appLog.Info("starting deploy of system app")
 
 		app, err := client.GetApp(appInfo.Name)
@@ -1140,10 +1363,11 @@ func updateImages(repo, configDir, targetVersion, baseURL string, force bool, ex
 			continue
 		} else if err != nil {
 			appLog.Error("error getting app", "err", err)
-			return err
+			return updateRun.fail("deploy "+appInfo.Name, err)
 		}
 
 		var deployErr error
+		var skipped bool
 		for attempt := 1; ; attempt++ {
 			deployErr = deployApp(client, app, images[appInfo.Name], appInfo.UpdateRelease, force, appLog)
 			if deployErr == nil {
@@ -1152,6 +1376,7 @@ func updateImages(repo, configDir, targetVersion, baseURL string, force bool, ex
 			if e, ok := deployErr.(errDeploySkipped); ok {
 				appLog.Info("skipped deploy of system app", "reason", e.reason)
 				deployErr = nil
+				skipped = true
 				break
 			}
 			// Sirenia-based apps plus transient discoverd failures (e.g.
@@ -1164,7 +1389,7 @@ func updateImages(repo, configDir, targetVersion, baseURL string, force bool, ex
 				time.Sleep(updaterdeploy.TransientDeployRetryDelay())
 				continue
 			}
-			return deployErr
+			return updateRun.fail("deploy "+appInfo.Name, deployErr)
 		}
 		if deployErr != nil {
 			continue
@@ -1173,13 +1398,24 @@ func updateImages(repo, configDir, targetVersion, baseURL string, force bool, ex
 		if appInfo.Name == "postgres" || appInfo.Name == "mariadb" || appInfo.Name == "mongodb" {
 			updaterdeploy.WaitSireniaLeaderStable(appInfo.Name, appLog.New("after_system_app_deploy", appInfo.Name))
 		}
+		updateRun.markApp(appInfo.Name)
+		if skipped {
+			continue
+		}
+
+		// check the deploy left the cluster healthy before deploying the
+		// next app, which may depend on it
+		_, err = waitForClusterHealthy(updateHealthTimeout, appLog)
+		if err := updateRun.check("cluster health after deploying "+appInfo.Name, err); err != nil {
+			return err
+		}
 	}
 
 	// Deploy all other apps (Redis appliances and slugrunner apps)
 	apps, err := client.AppList()
 	if err != nil {
 		log.Error("error getting apps", "err", err)
-		return err
+		return updateRun.fail("list apps", err)
 	}
 
 	for _, app := range apps {
@@ -1192,7 +1428,7 @@ func updateImages(repo, configDir, targetVersion, baseURL string, force bool, ex
 					appLog.Info("skipped deploy of Redis app", "reason", e.reason)
 					continue
 				}
-				return err
+				return updateRun.fail("deploy "+app.Name, err)
 			}
 			appLog.Info("finished deploy of Redis app")
 			continue
@@ -1208,7 +1444,7 @@ func updateImages(repo, configDir, targetVersion, baseURL string, force bool, ex
 				appLog.Info("skipped deploy of app", "reason", e.reason)
 				continue
 			}
-			return err
+			return updateRun.fail("deploy "+app.Name, err)
 		}
 		appLog.Info("finished deploy of app")
 	}
@@ -1299,11 +1535,15 @@ func (d *jsonDecoderWrapper) Decode(v interface{}) error {
 // It extracts the tarball, installs binaries locally, restarts the daemon,
 // starts a temporary HTTP server to serve the extracted contents to all
 // cluster nodes, and then deploys system apps.
+//
+// If tarballPath is empty, the contents are instead fetched from the tarball
+// file servers already registered in discoverd (see --serve-tarball), so the
+// update can be driven from a node which does not hold the tarball.
 func runTarballUpdate(args *docopt.Args, tarballPath, configDir string, log log15.Logger) error {
 	binDir := args.String["--bin-dir"]
 	skipImages := args.Bool["--skip-images"]
 	imagesOnly := args.Bool["--images-only"]
-	allNodes := args.Bool["--all-nodes"]
+	allNodes := args.Bool["--all-nodes"] || updateHostSelector != nil
 	force := args.Bool["--force"]
 
 	if imagesOnly && !allNodes {
@@ -1316,11 +1556,16 @@ func runTarballUpdate(args *docopt.Args, tarballPath, configDir string, log log1
 		}
 	}
 
-	log.Info("starting tarball-based update", "tarball", tarballPath)
+	fromCluster := tarballPath == ""
+	if fromCluster {
+		log.Info("starting tarball-based update from cluster file servers", "service", tarballServiceName)
+	} else {
+		log.Info("starting tarball-based update", "tarball", tarballPath)
 
-	// Verify tarball exists
-	if _, err := os.Stat(tarballPath); err != nil {
-		return fmt.Errorf("tarball not found: %s", tarballPath)
+		// Verify tarball exists
+		if _, err := os.Stat(tarballPath); err != nil {
+			return fmt.Errorf("tarball not found: %s", tarballPath)
+		}
 	}
 
 	// Extract tarball to a temp directory
@@ -1330,12 +1575,35 @@ func runTarballUpdate(args *docopt.Args, tarballPath, configDir string, log log1
 	}
 	defer os.RemoveAll(extractDir)
 
-	log.Info("extracting tarball", "dest", extractDir)
-	tarballVersion, contentDir, err := extractTarball(tarballPath, extractDir)
-	if err != nil {
-		return fmt.Errorf("failed to extract tarball: %w", err)
+	var tarballVersion, contentDir string
+	if fromCluster {
+		tarballVersion, err = tarballServiceVersion()
+		if err != nil {
+			return err
+		}
+		contentDir = extractDir
+		if !imagesOnly {
+			required := []string{ghrelease.HostAssetName("flynn-host") + ".gz", ghrelease.HostAssetName("flynn-init") + ".gz"}
+			if err := fetchTarballContents(tarballVersion, contentDir, required, []string{ghrelease.ChecksumsName, ghrelease.ChecksumsSignatureName}, log); err != nil {
+				return err
+			}
+		}
+		log.Info("fetched tarball contents", "version", tarballVersion, "content_dir", contentDir)
+	} else {
+		log.Info("extracting tarball", "dest", extractDir)
+		tarballVersion, contentDir, err = extractTarball(tarballPath, extractDir)
+		if err != nil {
+			return fmt.Errorf("failed to extract tarball: %w", err)
+		}
+		log.Info("extracted tarball", "version", tarballVersion, "content_dir", contentDir)
+	}
+	location := tarballPath
+	if fromCluster {
+		location = downloader.ServiceBaseURL(tarballServiceName)
+	}
+	if err := updateRun.start(tarballVersion, installsource.SourceTarball, location); err != nil {
+		return err
 	}
-	log.Info("extracted tarball", "version", tarballVersion, "content_dir", contentDir)
 
 	rolloutCluster := allNodes
 	if !rolloutCluster && !skipImages {
@@ -1348,59 +1616,72 @@ func runTarballUpdate(args *docopt.Args, tarballPath, configDir string, log log1
 	// Update binaries unless --images-only was specified
 	if !imagesOnly {
 		// Parse checksums from the tarball contents
-		checksumPath := filepath.Join(contentDir, "checksums.sha512")
-		checksums, err := parseChecksums(checksumPath)
-		if err != nil {
+		checksums, err := verifyTarballChecksums(contentDir, log)
+		if os.IsNotExist(err) {
 			log.Warn("no checksums file in tarball, skipping verification", "err", err)
 			checksums = nil
+		} else if err != nil {
+			return err
 		}
 
-		// Install binaries from extracted files
-		binaries := []struct {
-			gzName   string
-			destName string
-		}{
-			{"flynn-host-linux-amd64.gz", "flynn-host"},
-			{"flynn-init-linux-amd64.gz", "flynn-init"},
-		}
-
-		for _, bin := range binaries {
-			gzPath := filepath.Join(contentDir, bin.gzName)
-			if _, err := os.Stat(gzPath); err != nil {
-				return fmt.Errorf("binary %s not found in tarball: %w", bin.gzName, err)
+		// With --hosts, this host is only updated if it is selected
+		if updateRun.localHostDone(log) {
+			log.Info("skipping local binary update, already updated by the update being resumed")
+			fmt.Println("This host was already updated, skipping local binary update")
+		} else if localHostSelected(log) {
+			updateRun.startHost()
+
+			// Install binaries from extracted files
+			binaries := []struct {
+				gzName   string
+				destName string
+			}{
+				{ghrelease.HostAssetName("flynn-host") + ".gz", "flynn-host"},
+				{ghrelease.HostAssetName("flynn-init") + ".gz", "flynn-init"},
 			}
 
-			// Verify checksum if available
-			if checksums != nil {
-				if expected, ok := checksums[bin.gzName]; ok {
-					if err := verifyChecksum(gzPath, expected); err != nil {
-						return fmt.Errorf("checksum verification failed for %s: %w", bin.gzName, err)
+			for _, bin := range binaries {
+				gzPath := filepath.Join(contentDir, bin.gzName)
+				if _, err := os.Stat(gzPath); err != nil {
+					return fmt.Errorf("binary %s not found in tarball: %w", bin.gzName, err)
+				}
+
+				// Verify checksum if available
+				if checksums != nil {
+					if expected, ok := checksums[bin.gzName]; ok {
+						if err := verifyChecksum(gzPath, expected); err != nil {
+							return fmt.Errorf("checksum verification failed for %s: %w", bin.gzName, err)
+						}
+						log.Info("checksum verified", "name", bin.gzName)
 					}
-					log.Info("checksum verified", "name", bin.gzName)
 				}
-			}
 
-			destPath := filepath.Join(binDir, bin.destName)
-			if err := decompressAndInstall(gzPath, destPath, log); err != nil {
-				return fmt.Errorf("failed to install %s: %w", bin.destName, err)
+				destPath := filepath.Join(binDir, bin.destName)
+				if err := decompressAndInstall(gzPath, destPath, log); err != nil {
+					return fmt.Errorf("failed to install %s: %w", bin.destName, err)
+				}
 			}
-		}
 
-		log.Info("binaries installed", "version", tarballVersion)
-		fmt.Printf("Flynn binaries installed from tarball (%s)\n", tarballVersion)
+			log.Info("binaries installed", "version", tarballVersion)
+			fmt.Printf("Flynn binaries installed from tarball (%s)\n", tarballVersion)
 
-		// Trigger zero-downtime daemon restart unless --no-restart was specified
-		if !args.Bool["--no-restart"] {
-			restarted, err := restartDaemon(binDir, log)
-			if err != nil {
-				return err
-			}
-			if restarted {
-				fmt.Printf("Flynn daemon restarted with version %s\n", tarballVersion)
+			// Trigger zero-downtime daemon restart unless --no-restart was specified
+			if !args.Bool["--no-restart"] {
+				restarted, err := restartDaemon(binDir, log)
+				if err != nil {
+					return err
+				}
+				if restarted {
+					fmt.Printf("Flynn daemon restarted with version %s\n", tarballVersion)
+					updateRun.markLocalHost(log)
+				}
+			} else {
+				log.Info("skipping daemon restart (--no-restart specified)")
+				fmt.Println("Daemon restart skipped. Restart manually to activate the new version.")
 			}
 		} else {
-			log.Info("skipping daemon restart (--no-restart specified)")
-			fmt.Println("Daemon restart skipped. Restart manually to activate the new version.")
+			log.Info("skipping local binary update, host not matched by --hosts", "hosts", updateHostSelector)
+			fmt.Printf("This host is not matched by --hosts=%s, skipping local binary update\n", updateHostSelector)
 		}
 
 		if !allNodes {
@@ -1410,31 +1691,37 @@ func runTarballUpdate(args *docopt.Args, tarballPath, configDir string, log log1
 	}
 
 	// Temporary HTTP server: only when pushing to other nodes or rolling out images cluster-wide.
+	// The server registers in discoverd so hosts fetch via the service name
+	// and fail over to any other registered server. When driving the update
+	// from the cluster's file servers, no local server is started at all.
 	needRemoteBinaries := !imagesOnly && allNodes
 	needImages := !skipImages && rolloutCluster
-	if needRemoteBinaries || needImages {
-		listener, err := net.Listen("tcp", ":0")
-		if err != nil {
-			return fmt.Errorf("failed to start HTTP server: %w", err)
-		}
-		defer listener.Close()
 
-		// Determine the coordinator's cluster-facing IP
-		coordinatorIP, err := getCoordinatorIP(log)
-		if err != nil {
-			return fmt.Errorf("failed to determine coordinator IP: %w", err)
+	// When only images are needed, the local host pulls them straight from
+	// the extracted tarball and serves the layers to the other hosts from
+	// its layer cache, so no file server is needed unless that fails.
+	if !fromCluster && needImages && !needRemoteBinaries {
+		err := updateImages("", configDir, tarballVersion, "file://"+contentDir, force, 0, log)
+		if errors.Is(err, errNoLocalLayerSource) {
+			log.Warn("cannot distribute images from the local host, starting file server", "err", err)
+		} else if err != nil {
+			return err
+		} else {
+			needImages = false
 		}
+	}
 
-		_, port, _ := net.SplitHostPort(listener.Addr().String())
-		baseURL := fmt.Sprintf("http://%s:%s", coordinatorIP, port)
-		log.Info("starting temporary HTTP file server", "base_url", baseURL, "serving", contentDir)
-
-		// Start serving files in a goroutine
-		srv := &http.Server{Handler: http.FileServer(http.Dir(contentDir))}
-		go srv.Serve(listener)
-		defer srv.Close()
-
-		fmt.Printf("Temporary file server started at %s\n", baseURL)
+	if needRemoteBinaries || needImages {
+		baseURL := downloader.ServiceBaseURL(tarballServiceName)
+		if !fromCluster {
+			srv, err := startTarballServer(contentDir, tarballVersion, log)
+			if err != nil {
+				return err
+			}
+			defer srv.Close()
+			baseURL = srv.baseURL
+			fmt.Printf("Temporary file server started at %s\n", srv.directURL)
+		}
 
 		// Propagate binaries to all other cluster nodes
 		var expectedHostCount int
@@ -1457,6 +1744,7 @@ func runTarballUpdate(args *docopt.Args, tarballPath, configDir string, log log1
 		fmt.Println("Skipping container images and system apps on this run. After flynn-host matches on every node, run the same tarball command with --all-nodes.")
 	}
 
+	updateRun.finish()
 	log.Info("tarball update complete", "version", tarballVersion)
 	fmt.Printf("Flynn updated to %s from tarball\n", tarballVersion)
 	return nil
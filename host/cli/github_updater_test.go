@@ -1,9 +1,14 @@
 package cli
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/flynn/flynn/pkg/cluster"
+	"github.com/flynn/flynn/pkg/ghrelease"
 	"github.com/inconshreveable/log15"
 )
 
@@ -95,3 +100,45 @@ func TestParseHostFromURL(t *testing.T) {
 	}
 }
 
+func TestVerifyTarballChecksums(t *testing.T) {
+	log := log15.New()
+	log.SetHandler(log15.DiscardHandler())
+	dir := t.TempDir()
+	write := func(name, data string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// without a key, unsigned checksums are used and missing checksums
+	// are reported so that verification can be skipped
+	defer func(f func() (ed25519.PublicKey, error)) { releasePublicKey = f }(releasePublicKey)
+	releasePublicKey = func() (ed25519.PublicKey, error) { return nil, ghrelease.ErrNoPublicKey }
+	if _, err := verifyTarballChecksums(dir, log); !os.IsNotExist(err) {
+		t.Fatalf("expected a not exist error, got %v", err)
+	}
+	write(ghrelease.ChecksumsName, "abc123  flynn-host.gz\n")
+	sums, err := verifyTarballChecksums(dir, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sums["flynn-host.gz"] != "abc123" {
+		t.Fatalf("unexpected checksums %v", sums)
+	}
+
+	// with a key, unsigned or missing checksums are rejected
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	releasePublicKey = func() (ed25519.PublicKey, error) { return pub, nil }
+	if _, err := verifyTarballChecksums(dir, log); err == nil || os.IsNotExist(err) {
+		t.Fatalf("expected an error for unsigned checksums, got %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, ghrelease.ChecksumsName)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifyTarballChecksums(dir, log); err == nil || os.IsNotExist(err) {
+		t.Fatalf("expected an error for missing checksums, got %v", err)
+	}
+}
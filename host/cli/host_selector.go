@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flynn/flynn/pkg/cluster"
+	"github.com/inconshreveable/log15"
+)
+
+// updateHostSelector restricts binary and image updates to a subset of the
+// cluster when --hosts is passed to `flynn-host update`. A nil selector
+// matches every host.
+var updateHostSelector *hostSelector
+
+// hostSelector matches cluster hosts by ID or by tag. It is parsed from a
+// comma-separated list where each entry is either a host ID or a key=value
+// tag; a host matches if it matches any entry.
+type hostSelector struct {
+	ids  map[string]struct{}
+	tags map[string]string
+}
+
+// parseHostSelector parses a --hosts value such as "host1,host2,role=canary".
+func parseHostSelector(s string) (*hostSelector, error) {
+	sel := &hostSelector{
+		ids:  make(map[string]struct{}),
+		tags: make(map[string]string),
+	}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if kv := strings.SplitN(entry, "=", 2); len(kv) == 2 {
+			if kv[0] == "" {
+				return nil, fmt.Errorf("invalid host tag selector %q", entry)
+			}
+			sel.tags[kv[0]] = kv[1]
+			continue
+		}
+		sel.ids[entry] = struct{}{}
+	}
+	if len(sel.ids) == 0 && len(sel.tags) == 0 {
+		return nil, fmt.Errorf("host selector %q matches no hosts", s)
+	}
+	return sel, nil
+}
+
+// Match returns whether the given host ID and tags are selected.
+func (s *hostSelector) Match(id string, tags map[string]string) bool {
+	if s == nil {
+		return true
+	}
+	if _, ok := s.ids[id]; ok {
+		return true
+	}
+	for k, v := range s.tags {
+		if tags[k] == v {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchHost returns whether the given cluster host is selected.
+func (s *hostSelector) MatchHost(h *cluster.Host) bool {
+	return s.Match(h.ID(), h.Tags())
+}
+
+// String returns the selector in --hosts form.
+func (s *hostSelector) String() string {
+	if s == nil {
+		return "all"
+	}
+	entries := make([]string, 0, len(s.ids)+len(s.tags))
+	for id := range s.ids {
+		entries = append(entries, id)
+	}
+	for k, v := range s.tags {
+		entries = append(entries, k+"="+v)
+	}
+	return strings.Join(entries, ",")
+}
+
+// filterHosts returns the hosts matched by the selector.
+func (s *hostSelector) filterHosts(hosts []*cluster.Host) []*cluster.Host {
+	if s == nil {
+		return hosts
+	}
+	filtered := make([]*cluster.Host, 0, len(hosts))
+	for _, h := range hosts {
+		if s.MatchHost(h) {
+			filtered = append(filtered, h)
+		}
+	}
+	return filtered
+}
+
+// localHostSelected returns whether the host this command is running on is
+// matched by updateHostSelector. If the local host cannot be identified it
+// is only considered selected when no selector is set.
+func localHostSelected(log log15.Logger) bool {
+	if updateHostSelector == nil {
+		return true
+	}
+	h := localClusterHost(log)
+	return h != nil && updateHostSelector.MatchHost(h)
+}
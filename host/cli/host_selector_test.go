@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/flynn/flynn/pkg/cluster"
+)
+
+func TestParseHostSelector(t *testing.T) {
+	sel, err := parseHostSelector("host1, role=canary,,host2")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, tc := range []struct {
+		id   string
+		tags map[string]string
+		want bool
+	}{
+		{"host1", nil, true},
+		{"host2", nil, true},
+		{"host3", nil, false},
+		{"host3", map[string]string{"role": "canary"}, true},
+		{"host3", map[string]string{"role": "db"}, false},
+	} {
+		if got := sel.Match(tc.id, tc.tags); got != tc.want {
+			t.Errorf("Match(%q, %v): got %v, want %v", tc.id, tc.tags, got, tc.want)
+		}
+	}
+}
+
+func TestParseHostSelectorInvalid(t *testing.T) {
+	for _, s := range []string{"", " , ", "=canary"} {
+		if _, err := parseHostSelector(s); err == nil {
+			t.Errorf("parseHostSelector(%q): expected error", s)
+		}
+	}
+}
+
+func TestHostSelectorNilMatchesAll(t *testing.T) {
+	var sel *hostSelector
+	hosts := []*cluster.Host{
+		cluster.NewHost("h1", "10.0.0.1:1113", nil, nil),
+		cluster.NewHost("h2", "10.0.0.2:1113", nil, nil),
+	}
+	if got := sel.filterHosts(hosts); len(got) != 2 {
+		t.Fatalf("expected nil selector to match all hosts, got %d", len(got))
+	}
+}
+
+func TestHostSelectorFilterHosts(t *testing.T) {
+	sel, err := parseHostSelector("h2")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	hosts := []*cluster.Host{
+		cluster.NewHost("h1", "10.0.0.1:1113", nil, nil),
+		cluster.NewHost("h2", "10.0.0.2:1113", nil, nil),
+	}
+	got := sel.filterHosts(hosts)
+	if len(got) != 1 || got[0].ID() != "h2" {
+		t.Fatalf("expected only h2, got %v", hostIDs(got))
+	}
+}
@@ -79,6 +79,9 @@ func printJobDesc(job *host.ActiveJob, out io.Writer, env bool, redactEnv []stri
 	listRec(w, "ExitStatus", exitStatus)
 	listRec(w, "Error", jobError)
 	listRec(w, "IP Address", job.InternalIP)
+	for _, phase := range job.StartPhases {
+		listRec(w, fmt.Sprintf("StartPhase[%s]", phase.Name), phase.Duration)
+	}
 	for i, m := range job.Job.Mountspecs {
 		listRec(w, fmt.Sprintf("Mountspec[%d]", i), m)
 	}
@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/pkg/cluster"
+	"github.com/flynn/go-docopt"
+)
+
+func init() {
+	Register("layer", runLayer, `
+usage: flynn-host layer gc [--retain=<n>] [--dry-run] [<hostid>...]
+
+Commands:
+    gc  Remove image layers which are no longer needed from the layer cache
+
+Options:
+    --retain=<n>  number of previously installed releases whose layers are kept (defaults to each host's --layer-gc-retain)
+    --dry-run     show which layers would be removed without removing them
+
+Layers are kept if they are used by the latest release installed on the host,
+by the given number of previously installed releases, or by a job on the host.
+Without any host IDs, layers are removed from every host in the cluster.
+
+Examples:
+
+    $ flynn-host layer gc --dry-run
+    host0: would remove 12 layers (1.2 GB), keeping 64 layers for releases v20240127.0, v20231215.1
+
+    $ flynn-host layer gc --retain=0 host0
+    host0: removed 23 layers (2.5 GB), keeping 53 layers for releases v20240127.0
+`)
+}
+
+func runLayer(args *docopt.Args, client *cluster.Client) error {
+	switch {
+	case args.Bool["gc"]:
+		return runLayerGC(args, client)
+	}
+	return nil
+}
+
+func runLayerGC(args *docopt.Args, client *cluster.Client) error {
+	req := &host.LayerGCRequest{DryRun: args.Bool["--dry-run"]}
+	if s := args.String["--retain"]; s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid --retain value %q", s)
+		}
+		req.Retain = &n
+	}
+
+	var hosts []*cluster.Host
+	if ids := args.All["<hostid>"].([]string); len(ids) > 0 {
+		for _, id := range ids {
+			h, err := client.Host(id)
+			if err != nil {
+				return err
+			}
+			hosts = append(hosts, h)
+		}
+	} else {
+		var err error
+		hosts, err = client.Hosts()
+		if err != nil {
+			return fmt.Errorf("could not list hosts: %s", err)
+		}
+		if len(hosts) == 0 {
+			return errors.New("no hosts found")
+		}
+	}
+
+	success := true
+	for _, h := range hosts {
+		res, err := h.GCLayers(req)
+		if err != nil {
+			success = false
+			fmt.Printf("%s: could not remove layers: %s\n", h.ID(), err)
+			continue
+		}
+		action := "removed"
+		if res.DryRun {
+			action = "would remove"
+		}
+		fmt.Printf("%s: %s %d layers (%s), keeping %d layers for releases %s\n",
+			h.ID(), action, len(res.Removed), units.HumanSize(float64(res.RemovedBytes)), res.Kept, strings.Join(res.Releases, ", "))
+		if len(res.RemovedReleases) > 0 {
+			fmt.Printf("%s: no longer keeping layers for releases %s\n", h.ID(), strings.Join(res.RemovedReleases, ", "))
+		}
+	}
+	if !success {
+		return errors.New("could not remove layers from all hosts")
+	}
+	return nil
+}
@@ -1,7 +1,6 @@
 package cli
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
 	"io"
@@ -9,7 +8,9 @@ import (
 	"strconv"
 
 	"github.com/flynn/flynn/host/types"
+	logagg "github.com/flynn/flynn/logaggregator/types"
 	"github.com/flynn/flynn/pkg/cluster"
+	"github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/go-docopt"
 )
 
@@ -17,7 +18,13 @@ func init() {
 	Register("log", runLog, `
 usage: flynn-host log [--init] [-f|--follow] [--lines=<number>] [--split-stderr] ID
 
-Get the logs of a job`)
+Get the logs of a job from the buffers of the host it ran on.
+
+Options:
+	--init              include flynn-host and init output
+	-f, --follow        follow the log until the job exits
+	--lines=<number>    number of lines to show, including init output, or 0 for all [default: 0]
+	--split-stderr      write the job's stderr to stderr`)
 }
 
 func runLog(args *docopt.Args, client *cluster.Client) error {
@@ -40,27 +47,36 @@ func runLog(args *docopt.Args, client *cluster.Client) error {
 		stderr = os.Stderr
 	}
 
-	if lines > 0 {
-		stdoutR, stdoutW := io.Pipe()
-		stderrR, stderrW := io.Pipe()
-
-		go func() {
-			getLog(hostID, jobID, client, false, args.Bool["--init"], stdoutW, stderrW)
-			stdoutW.Close()
-			stderrW.Close()
-		}()
-		tailLogs(stdoutR, stderrR, lines, os.Stdout, stderr)
-		return nil
+	hostClient, err := client.Host(hostID)
+	if err != nil {
+		return fmt.Errorf("could not connect to host %s: %s", hostID, err)
 	}
-	return getLog(
-		hostID,
-		jobID,
-		client,
-		args.Bool["-f"] || args.Bool["--follow"],
-		args.Bool["--init"],
-		os.Stdout,
-		stderr,
-	)
+	ch := make(chan *host.JobLogLine)
+	stream, err := hostClient.StreamJobLogs(jobID, lines, args.Bool["-f"] || args.Bool["--follow"], ch)
+	if err != nil {
+		if httphelper.IsObjectNotFoundError(err) {
+			return errors.New("no such job")
+		}
+		return err
+	}
+	defer stream.Close()
+	for line := range ch {
+		var w io.Writer
+		switch logagg.StreamType(line.Stream) {
+		case logagg.StreamTypeStdout:
+			w = os.Stdout
+		case logagg.StreamTypeStderr:
+			w = stderr
+		case logagg.StreamTypeInit:
+			if args.Bool["--init"] {
+				w = os.Stdout
+			}
+		}
+		if w != nil {
+			fmt.Fprintln(w, line.Msg)
+		}
+	}
+	return stream.Err()
 }
 
 func getLog(hostID, jobID string, client *cluster.Client, follow, init bool, stdout, stderr io.Writer) error {
@@ -92,87 +108,3 @@ func getLog(hostID, jobID string, client *cluster.Client, follow, init bool, std
 	_, err = attachClient.Receive(stdout, stderr)
 	return err
 }
-
-type LogLine struct {
-	Token   int
-	Content []byte
-}
-
-type LogRing struct {
-	logLines []*LogLine
-	start    int
-}
-
-func NewLogRing(capacity int) *LogRing {
-	return &LogRing{
-		logLines: make([]*LogLine, 0, capacity),
-	}
-}
-
-func (r *LogRing) Add(l *LogLine) {
-	if len(r.logLines) < cap(r.logLines) {
-		r.logLines = append(r.logLines, l)
-	} else {
-		r.logLines[r.start] = l
-		r.start++
-
-		if r.start == cap(r.logLines) {
-			r.start = 0
-		}
-	}
-}
-
-func (r *LogRing) Read() []*LogLine {
-	buf := make([]*LogLine, len(r.logLines))
-	if n := copy(buf, r.logLines[r.start:len(r.logLines)]); n < len(r.logLines) {
-		copy(buf[n:], r.logLines[:r.start])
-	}
-	return buf
-}
-
-func scanLogs(reader io.Reader, token int, output chan LogLine) {
-	scanner := bufio.NewScanner(reader)
-	for scanner.Scan() {
-		buf := make([]byte, len(scanner.Bytes())+1)
-		copy(buf, scanner.Bytes())
-		buf[len(buf)-1] = '\n'
-		output <- LogLine{Token: token, Content: buf}
-	}
-	close(output)
-}
-
-func tailLogs(stdoutR, stderrR io.Reader, lines int, stdoutW, stderrW io.Writer) {
-	gather1 := make(chan LogLine)
-	gather2 := make(chan LogLine)
-	r := NewLogRing(lines)
-	go scanLogs(stdoutR, 0, gather1)
-	go scanLogs(stderrR, 1, gather2)
-	for {
-		select {
-		case v, ok := <-gather1:
-			if ok {
-				r.Add(&v)
-			} else {
-				gather1 = nil
-			}
-		case v, ok := <-gather2:
-			if ok {
-				r.Add(&v)
-			} else {
-				gather2 = nil
-			}
-		}
-		if gather1 == nil && gather2 == nil {
-			break
-		}
-	}
-
-	for _, ll := range r.Read() {
-		switch ll.Token {
-		case 0:
-			stdoutW.Write(ll.Content)
-		case 1:
-			stderrW.Write(ll.Content)
-		}
-	}
-}
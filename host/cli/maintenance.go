@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	host "github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/pkg/cluster"
+	"github.com/inconshreveable/log15"
+)
+
+// updateWaitForMaintenance makes rolling updates wait for each host's
+// maintenance window before restarting it, set by --wait-for-maintenance.
+var updateWaitForMaintenance bool
+
+// maintenanceStatusFn gets the maintenance status of a host, it is a
+// variable so it can be replaced in tests.
+var maintenanceStatusFn = func(h *cluster.Host) (*host.MaintenanceStatus, error) {
+	status, err := h.GetStatus()
+	if err != nil {
+		return nil, err
+	}
+	return status.Maintenance, nil
+}
+
+// orderHostsByMaintenance returns the hosts ordered so that those currently
+// in a maintenance window are updated first, followed by hosts with windows
+// in the order their next window starts, followed by hosts without windows
+// in their original order.
+func orderHostsByMaintenance(hosts []*cluster.Host, log log15.Logger) []*cluster.Host {
+	type entry struct {
+		host   *cluster.Host
+		status *host.MaintenanceStatus
+	}
+	entries := make([]entry, len(hosts))
+	for i, h := range hosts {
+		status, err := maintenanceStatusFn(h)
+		if err != nil {
+			log.Warn("error getting host maintenance status", "host", h.ID(), "err", err)
+		}
+		entries[i] = entry{h, status}
+	}
+	rank := func(s *host.MaintenanceStatus) int {
+		switch {
+		case s == nil:
+			return 2
+		case s.Active:
+			return 0
+		default:
+			return 1
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		ri, rj := rank(entries[i].status), rank(entries[j].status)
+		if ri != rj {
+			return ri < rj
+		}
+		if ri == 1 && entries[i].status.Next != nil && entries[j].status.Next != nil {
+			return entries[i].status.Next.Before(*entries[j].status.Next)
+		}
+		return false
+	})
+	ordered := make([]*cluster.Host, len(entries))
+	for i, e := range entries {
+		ordered[i] = e.host
+	}
+	return ordered
+}
+
+// waitForMaintenanceWindow waits until the host is in one of its
+// maintenance windows. Hosts without windows are not waited for.
+func waitForMaintenanceWindow(h *cluster.Host, log log15.Logger) error {
+	for {
+		status, err := maintenanceStatusFn(h)
+		if err != nil {
+			return fmt.Errorf("error getting maintenance status of host %s: %w", h.ID(), err)
+		}
+		if status == nil || status.Active {
+			return nil
+		}
+		if status.Next == nil {
+			return fmt.Errorf("host %s has maintenance windows %v which never start", h.ID(), status.Windows)
+		}
+		wait := time.Until(*status.Next)
+		log.Info("waiting for maintenance window", "starts", status.Next, "wait", wait)
+		fmt.Printf("Waiting for the maintenance window on %s starting at %s...\n", h.ID(), status.Next.Format(time.RFC3339))
+		if wait < time.Second {
+			wait = time.Second
+		}
+		// check again at most every minute in case the windows change
+		// or the host restarts
+		if wait > time.Minute {
+			wait = time.Minute
+		}
+		time.Sleep(wait)
+	}
+}
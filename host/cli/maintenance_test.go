@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	host "github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/pkg/cluster"
+	"github.com/inconshreveable/log15"
+)
+
+func TestOrderHostsByMaintenance(t *testing.T) {
+	now := time.Now()
+	soon, later := now.Add(time.Hour), now.Add(2*time.Hour)
+	statuses := map[string]*host.MaintenanceStatus{
+		"later":  {Windows: []string{"0 4 * * * 1h"}, Next: &later},
+		"soon":   {Windows: []string{"0 3 * * * 1h"}, Next: &soon},
+		"active": {Windows: []string{"0 2 * * * 1h"}, Active: true, Until: &soon, Next: &later},
+	}
+	defer func(fn func(*cluster.Host) (*host.MaintenanceStatus, error)) { maintenanceStatusFn = fn }(maintenanceStatusFn)
+	maintenanceStatusFn = func(h *cluster.Host) (*host.MaintenanceStatus, error) {
+		if h.ID() == "down" {
+			return nil, errors.New("connection refused")
+		}
+		return statuses[h.ID()], nil
+	}
+
+	var hosts []*cluster.Host
+	for _, id := range []string{"none1", "later", "down", "soon", "none2", "active"} {
+		hosts = append(hosts, cluster.NewHost(id, id+":1113", nil, nil))
+	}
+	log := log15.New()
+	log.SetHandler(log15.DiscardHandler())
+
+	var ids []string
+	for _, h := range orderHostsByMaintenance(hosts, log) {
+		ids = append(ids, h.ID())
+	}
+	if expected := []string{"active", "soon", "later", "none1", "down", "none2"}; !reflect.DeepEqual(ids, expected) {
+		t.Fatalf("expected order %v, got %v", expected, ids)
+	}
+}
@@ -1,9 +1,11 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 
+	host "github.com/flynn/flynn/host/types"
 	"github.com/flynn/flynn/pkg/cluster"
 	"github.com/flynn/go-docopt"
 )
@@ -11,17 +13,28 @@ import (
 func init() {
 	Register("signal", runSignal, `
 usage: flynn-host signal ID SIGNAL
+       flynn-host signal --selector=<selector> SIGNAL [<hostid>...]
 
-Signal a job`)
+Signal a job.
+
+Options:
+	--selector=<selector>  signal the running jobs whose metadata matches the
+	                       selector rather than a job given by ID, on the given
+	                       hosts or every host if none are given
+
+See "flynn-host help stop" for the selector syntax.`)
 }
 
 func runSignal(args *docopt.Args, client *cluster.Client) error {
-	id := args.String["ID"]
 	sig, err := strconv.Atoi(args.String["SIGNAL"])
 	if err != nil {
 		fmt.Println("invalid value for SIGNAL")
 		return err
 	}
+	if s := args.String["--selector"]; s != "" {
+		return runBulkSignal(s, sig, args.All["<hostid>"].([]string), client)
+	}
+	id := args.String["ID"]
 	hostID, err := cluster.ExtractHostID(id)
 	if err != nil {
 		fmt.Println("could not parse", id)
@@ -39,3 +52,34 @@ func runSignal(args *docopt.Args, client *cluster.Client) error {
 	fmt.Printf("sent signal %d to %s successfully\n", sig, id)
 	return nil
 }
+
+func runBulkSignal(s string, sig int, hostIDs []string, client *cluster.Client) error {
+	sel, err := host.ParseJobSelector(s)
+	if err != nil {
+		return fmt.Errorf("invalid selector: %s", err)
+	}
+	hosts, err := selectorHosts(hostIDs, client)
+	if err != nil {
+		return err
+	}
+	success := true
+	for _, h := range hosts {
+		res, err := h.BulkSignalJobs(sel, sig)
+		if err != nil {
+			fmt.Printf("could not signal jobs on host %s: %s\n", h.ID(), err)
+			success = false
+			continue
+		}
+		for _, id := range res.Jobs {
+			fmt.Printf("sent signal %d to %s successfully\n", sig, id)
+		}
+		for id, err := range res.Errors {
+			fmt.Printf("could not signal job %s: %s\n", id, err)
+			success = false
+		}
+	}
+	if !success {
+		return errors.New("could not signal all jobs")
+	}
+	return nil
+}
@@ -0,0 +1,350 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/flynn/flynn/discoverd/client"
+	"github.com/flynn/flynn/pkg/dialer"
+	sirenia "github.com/flynn/flynn/pkg/sirenia/client"
+	"github.com/flynn/flynn/pkg/sirenia/state"
+	"github.com/flynn/flynn/pkg/sirenia/xlog"
+	"github.com/flynn/go-docopt"
+)
+
+func init() {
+	Register("sirenia", runSirenia, `
+usage: flynn-host sirenia status [--json] <service>
+
+Commands:
+    status  Display the status of each member of a Sirenia cluster
+
+Options:
+    --json  Output the status as JSON
+
+The status combines the cluster state stored in the service's discoverd
+metadata with the status reported by each member, and displays the role of
+each member, its xlog position and how far it lags behind the primary.
+
+For MongoDB clusters, the health of the replica set is also displayed,
+including each member's replication lag and how much of the oplog window
+remains before the member furthest behind would need a full resync.
+
+Examples:
+
+  $ flynn-host sirenia status postgres
+  SERVICE: postgres  GENERATION: 4
+
+  ROLE     ADDR            JOB ID                            XLOG        LAG        STATUS
+  primary  10.0.0.1:5432   host0-4bbd7d1b-...                0/3000148   -          read-write
+  sync     10.0.0.2:5432   host1-8f6b2e7c-...                0/3000148   0 bytes    replicating
+  async    10.0.0.3:5432   host2-0a1c9e44-...                0/3000060   232 bytes  replicating
+`)
+}
+
+func runSirenia(args *docopt.Args) error {
+	switch {
+	case args.Bool["status"]:
+		return runSireniaStatus(args)
+	}
+	return nil
+}
+
+// sireniaMember is the status of a member of a Sirenia cluster.
+type sireniaMember struct {
+	Role      state.Role          `json:"role"`
+	Instance  *discoverd.Instance `json:"instance"`
+	Status    *sirenia.Status     `json:"status,omitempty"`
+	Error     string              `json:"error,omitempty"`
+	XLog      xlog.Position       `json:"xlog,omitempty"`
+	Lag       string              `json:"lag,omitempty"`
+	LagAmount int64               `json:"lag_amount"`
+}
+
+type sireniaStatus struct {
+	Service string           `json:"service"`
+	State   *state.State     `json:"state"`
+	Members []*sireniaMember `json:"members"`
+}
+
+func runSireniaStatus(args *docopt.Args) error {
+	service := args.String["<service>"]
+	status, err := getSireniaStatus(service)
+	if err != nil {
+		return err
+	}
+
+	if args.Bool["--json"] {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(status)
+	}
+
+	s := status.State
+	fmt.Printf("SERVICE: %s  GENERATION: %d", service, s.Generation)
+	if s.Singleton {
+		fmt.Print("  SINGLETON")
+	}
+	if s.Freeze != nil {
+		fmt.Printf("  FROZEN: %s (%s)", s.Freeze.FrozenAt.Format(time.RFC3339), s.Freeze.Reason)
+	}
+	fmt.Print("\n\n")
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
+	listRec(w, "ROLE", "ADDR", "JOB ID", "XLOG", "LAG", "STATUS")
+	for _, m := range status.Members {
+		lag := m.Lag
+		if lag == "" {
+			lag = "-"
+		}
+		xlogPos := string(m.XLog)
+		if xlogPos == "" {
+			xlogPos = "-"
+		}
+		listRec(w, m.Role, m.Instance.Addr, m.Instance.Meta["FLYNN_JOB_ID"], xlogPos, lag, m.describe())
+	}
+	w.Flush()
+
+	if rs := status.replicaSet(); rs != nil {
+		printReplicaSet(rs)
+	}
+	return nil
+}
+
+// replicaSet returns the replica set health reported by the primary, or by
+// any member if the primary didn't report it.
+func (s *sireniaStatus) replicaSet() *sirenia.ReplicaSetStatus {
+	var rs *sirenia.ReplicaSetStatus
+	for _, m := range s.Members {
+		if m.Status == nil || m.Status.Database == nil || m.Status.Database.ReplicaSet == nil {
+			continue
+		}
+		if m.Role == state.RolePrimary {
+			return m.Status.Database.ReplicaSet
+		}
+		if rs == nil {
+			rs = m.Status.Database.ReplicaSet
+		}
+	}
+	return rs
+}
+
+func printReplicaSet(rs *sirenia.ReplicaSetStatus) {
+	fmt.Printf("\nREPLICA SET: %s", rs.Name)
+	if rs.LastElection != nil {
+		fmt.Printf("  LAST ELECTION: %s", rs.LastElection.Format(time.RFC3339))
+	}
+	fmt.Printf("  OPLOG WINDOW: %s (%s remaining)\n\n",
+		time.Duration(rs.OplogWindow)*time.Second,
+		time.Duration(rs.OplogWindowRemaining)*time.Second,
+	)
+	if rs.OplogWarning != "" {
+		fmt.Printf("WARNING: %s\n\n", rs.OplogWarning)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
+	defer w.Flush()
+	listRec(w, "MEMBER", "STATE", "HEALTHY", "OPTIME", "LAG", "LAST HEARTBEAT")
+	for _, m := range rs.Members {
+		optime, heartbeat := "-", "-"
+		if !m.Optime.IsZero() {
+			optime = m.Optime.Format(time.RFC3339)
+		}
+		if m.LastHeartbeat != nil {
+			heartbeat = units.HumanDuration(time.Since(*m.LastHeartbeat)) + " ago"
+		}
+		listRec(w, m.Name, m.State, m.Healthy, optime, time.Duration(m.Lag)*time.Second, heartbeat)
+	}
+}
+
+func (m *sireniaMember) describe() string {
+	if m.Error != "" {
+		return "error: " + m.Error
+	}
+	var desc []string
+	if m.Status.Peer != nil && m.Status.Peer.Role != m.Role {
+		desc = append(desc, fmt.Sprintf("peer reports role %s", m.Status.Peer.Role))
+	}
+	if m.Status.Peer != nil && m.Status.Peer.RetryPending != nil {
+		desc = append(desc, "retry pending")
+	}
+	switch db := m.Status.Database; {
+	case db == nil || !db.Running:
+		desc = append(desc, "not running")
+	case db.ReadWrite:
+		desc = append(desc, "read-write")
+	case m.Role == state.RolePrimary:
+		desc = append(desc, "read-only")
+	default:
+		desc = append(desc, "replicating")
+	}
+	return strings.Join(desc, ", ")
+}
+
+func getSireniaStatus(service string) (*sireniaStatus, error) {
+	s := discoverd.NewService(service)
+	meta, err := s.GetMeta()
+	if err != nil {
+		return nil, fmt.Errorf("error getting %s service metadata: %s", service, err)
+	}
+	if len(meta.Data) == 0 {
+		return nil, fmt.Errorf("%s has no sirenia cluster state", service)
+	}
+	clusterState := &state.State{}
+	if err := json.Unmarshal(meta.Data, clusterState); err != nil {
+		return nil, fmt.Errorf("error decoding %s cluster state: %s", service, err)
+	}
+	if clusterState.Primary == nil {
+		return nil, errors.New("cluster state has no primary")
+	}
+	instances, err := s.Instances()
+	if err != nil {
+		return nil, fmt.Errorf("error getting %s instances: %s", service, err)
+	}
+
+	status := &sireniaStatus{Service: service, State: clusterState}
+	seen := make(map[string]struct{})
+	add := func(role state.Role, inst *discoverd.Instance) {
+		if inst == nil {
+			return
+		}
+		if _, ok := seen[inst.ID]; ok {
+			return
+		}
+		seen[inst.ID] = struct{}{}
+		status.Members = append(status.Members, &sireniaMember{Role: role, Instance: inst})
+	}
+	add(state.RolePrimary, clusterState.Primary)
+	add(state.RoleSync, clusterState.Sync)
+	for _, inst := range clusterState.Async {
+		add(state.RoleAsync, inst)
+	}
+	for _, inst := range clusterState.Deposed {
+		add(state.RoleDeposed, inst)
+	}
+	// registered instances which are not in the cluster state
+	for _, inst := range instances {
+		add(state.RoleUnassigned, inst)
+	}
+
+	// use a short timeout and no retries so that unreachable members
+	// don't block the command
+	httpClient := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{Dial: dialer.Default.Dial},
+	}
+	for _, m := range status.Members {
+		st, err := sirenia.NewClientWithHTTP(m.Instance.Addr, httpClient).Status()
+		if err != nil {
+			m.Error = err.Error()
+			continue
+		}
+		m.Status = st
+		if st.Database != nil {
+			m.XLog = xlog.Position(st.Database.XLog)
+		}
+	}
+
+	primary := status.Members[0]
+	if primary.XLog == "" {
+		return status, nil
+	}
+	for _, m := range status.Members[1:] {
+		if m.XLog == "" {
+			continue
+		}
+		amount, unit, err := xlogLag(service, primary.XLog, m.XLog)
+		if err != nil {
+			continue
+		}
+		m.LagAmount = amount
+		m.Lag = fmt.Sprintf("%d %s", amount, unit)
+	}
+	return status, nil
+}
+
+// xlogLag returns how far the downstream xlog position is behind the
+// upstream one, along with the unit of the lag, which depends on the xlog
+// format of the given service:
+//
+//   - postgres: LSNs of the form "filepart/offset", lag in bytes
+//   - mariadb: GTIDs of the form "domain-server-sequence", lag in transactions
+//   - mongodb: optimes of the form (seconds << 32 | increment), lag in seconds
+func xlogLag(service string, upstream, downstream xlog.Position) (int64, string, error) {
+	switch {
+	case strings.HasPrefix(service, "postgres"):
+		up, err := parseLSN(upstream)
+		if err != nil {
+			return 0, "", err
+		}
+		down, err := parseLSN(downstream)
+		if err != nil {
+			return 0, "", err
+		}
+		return nonNegative(up - down), "bytes", nil
+	case strings.HasPrefix(service, "mariadb"), strings.HasPrefix(service, "mysql"):
+		up, err := parseGTIDSeq(upstream)
+		if err != nil {
+			return 0, "", err
+		}
+		down, err := parseGTIDSeq(downstream)
+		if err != nil {
+			return 0, "", err
+		}
+		return nonNegative(up - down), "txns", nil
+	case strings.HasPrefix(service, "mongodb"):
+		up, err := strconv.ParseInt(string(upstream), 10, 64)
+		if err != nil {
+			return 0, "", err
+		}
+		down, err := strconv.ParseInt(string(downstream), 10, 64)
+		if err != nil {
+			return 0, "", err
+		}
+		return nonNegative(up>>32 - down>>32), "seconds", nil
+	default:
+		return 0, "", fmt.Errorf("unknown xlog format for service %q", service)
+	}
+}
+
+func parseLSN(pos xlog.Position) (int64, error) {
+	parts := strings.SplitN(string(pos), "/", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("malformed xlog position %q", pos)
+	}
+	file, err := strconv.ParseUint(parts[0], 16, 32)
+	if err != nil {
+		return 0, err
+	}
+	offset, err := strconv.ParseUint(parts[1], 16, 32)
+	if err != nil {
+		return 0, err
+	}
+	return int64(file<<32 | offset), nil
+}
+
+func parseGTIDSeq(pos xlog.Position) (int64, error) {
+	if pos == "" {
+		return 0, nil
+	}
+	parts := strings.SplitN(string(pos), "-", 3)
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("malformed xlog position %q", pos)
+	}
+	return strconv.ParseInt(parts[2], 10, 64)
+}
+
+func nonNegative(n int64) int64 {
+	if n < 0 {
+		return 0
+	}
+	return n
+}
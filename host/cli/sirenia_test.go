@@ -0,0 +1,45 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/flynn/flynn/pkg/sirenia/xlog"
+)
+
+func TestXLogLag(t *testing.T) {
+	for _, x := range []struct {
+		service    string
+		upstream   xlog.Position
+		downstream xlog.Position
+		lag        int64
+		unit       string
+	}{
+		{"postgres", "0/3000148", "0/3000060", 232, "bytes"},
+		{"postgres", "1/00000010", "0/FFFFFFF0", 32, "bytes"},
+		{"postgres", "0/3000060", "0/3000148", 0, "bytes"},
+		{"mariadb", "0-1-105", "0-1-100", 5, "txns"},
+		{"mariadb", "0-1-5", "", 5, "txns"},
+		{"mongodb", xlog.Position("30064771073"), xlog.Position("21474836481"), 2, "seconds"},
+	} {
+		lag, unit, err := xlogLag(x.service, x.upstream, x.downstream)
+		if err != nil {
+			t.Fatalf("%s %s/%s: unexpected error: %s", x.service, x.upstream, x.downstream, err)
+		}
+		if lag != x.lag || unit != x.unit {
+			t.Fatalf("%s %s/%s: expected %d %s, got %d %s", x.service, x.upstream, x.downstream, x.lag, x.unit, lag, unit)
+		}
+	}
+
+	for _, x := range []struct {
+		service              string
+		upstream, downstream xlog.Position
+	}{
+		{"postgres", "0/3000148", "garbage"},
+		{"mariadb", "1-2", "0-1-100"},
+		{"redis", "1", "1"},
+	} {
+		if _, _, err := xlogLag(x.service, x.upstream, x.downstream); err == nil {
+			t.Fatalf("%s %s/%s: expected an error", x.service, x.upstream, x.downstream)
+		}
+	}
+}
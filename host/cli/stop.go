@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 
+	host "github.com/flynn/flynn/host/types"
 	"github.com/flynn/flynn/pkg/cluster"
 	"github.com/flynn/go-docopt"
 )
@@ -11,11 +12,26 @@ import (
 func init() {
 	Register("stop", runStop, `
 usage: flynn-host stop ID...
+       flynn-host stop --selector=<selector> [<hostid>...]
 
-Stop running jobs`)
+Stop running jobs.
+
+Options:
+	--selector=<selector>  stop the jobs whose metadata matches the selector
+	                       rather than jobs given by ID, on the given hosts or
+	                       every host if none are given
+
+A selector is a comma separated list of key=value, key!=value, key (the key is
+set) or !key (the key is not set) requirements, all of which must match, for
+example:
+
+	flynn-host stop --selector=flynn-controller.app_name=myapp,flynn-controller.release!=abc`)
 }
 
 func runStop(args *docopt.Args, client *cluster.Client) error {
+	if s := args.String["--selector"]; s != "" {
+		return runBulkStop(s, args.All["<hostid>"].([]string), client)
+	}
 	success := true
 	clients := make(map[string]*cluster.Host)
 	for _, id := range args.All["ID"].([]string) {
@@ -48,3 +64,51 @@ func runStop(args *docopt.Args, client *cluster.Client) error {
 	}
 	return nil
 }
+
+func runBulkStop(s string, hostIDs []string, client *cluster.Client) error {
+	sel, err := host.ParseJobSelector(s)
+	if err != nil {
+		return fmt.Errorf("invalid selector: %s", err)
+	}
+	hosts, err := selectorHosts(hostIDs, client)
+	if err != nil {
+		return err
+	}
+	success := true
+	for _, h := range hosts {
+		res, err := h.BulkStopJobs(sel)
+		if err != nil {
+			fmt.Printf("could not stop jobs on host %s: %s\n", h.ID(), err)
+			success = false
+			continue
+		}
+		for _, id := range res.Jobs {
+			fmt.Println(id, "stopped")
+		}
+		for id, err := range res.Errors {
+			fmt.Printf("could not stop job %s: %s\n", id, err)
+			success = false
+		}
+	}
+	if !success {
+		return errors.New("could not stop all jobs")
+	}
+	return nil
+}
+
+// selectorHosts returns clients for the given hosts, or for every host if
+// none are given.
+func selectorHosts(hostIDs []string, client *cluster.Client) ([]*cluster.Host, error) {
+	if len(hostIDs) == 0 {
+		return client.Hosts()
+	}
+	hosts := make([]*cluster.Host, len(hostIDs))
+	for i, id := range hostIDs {
+		h, err := client.Host(id)
+		if err != nil {
+			return nil, fmt.Errorf("could not connect to host %s: %s", id, err)
+		}
+		hosts[i] = h
+	}
+	return hosts, nil
+}
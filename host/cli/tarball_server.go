@@ -0,0 +1,182 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	discoverd "github.com/flynn/flynn/discoverd/client"
+	"github.com/flynn/flynn/host/downloader"
+	"github.com/inconshreveable/log15"
+)
+
+// tarballServiceName is the discoverd service temporary tarball file servers
+// register under. Hosts fetch update files via downloader.ServiceBaseURL so a
+// download fails over to another registered server rather than depending on
+// the single node which started the update.
+const tarballServiceName = "flynn-update-files"
+
+// tarballServer serves the extracted contents of a release tarball over HTTP
+// and advertises itself in discoverd for the duration of an update.
+type tarballServer struct {
+	srv *http.Server
+	hb  discoverd.Heartbeater
+
+	// directURL is the http://ip:port address of this server.
+	directURL string
+
+	// baseURL is the URL hosts should download from: the service URL if
+	// registration succeeded, otherwise directURL.
+	baseURL string
+}
+
+// startTarballServer starts serving contentDir on an ephemeral port and
+// registers the server in discoverd with the tarball version in its metadata.
+// If discoverd is unavailable the server is still started and baseURL falls
+// back to the server's own address.
+func startTarballServer(contentDir, version string, log log15.Logger) (*tarballServer, error) {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start HTTP server: %w", err)
+	}
+
+	// Determine the cluster-facing IP to advertise
+	ip, err := getCoordinatorIP(log)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to determine coordinator IP: %w", err)
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	addr := net.JoinHostPort(ip, port)
+
+	s := &tarballServer{
+		srv:       &http.Server{Handler: http.FileServer(http.Dir(contentDir))},
+		directURL: "http://" + addr,
+	}
+	s.baseURL = s.directURL
+	log.Info("starting temporary HTTP file server", "addr", addr, "serving", contentDir)
+	go s.srv.Serve(listener)
+
+	hb, err := discoverd.DefaultClient.AddServiceAndRegisterInstance(tarballServiceName, &discoverd.Instance{
+		Addr: addr,
+		Meta: map[string]string{downloader.VersionMetaKey: version},
+	})
+	if err != nil {
+		log.Warn("failed to register file server in discoverd, hosts will fetch from this node directly", "err", err)
+		return s, nil
+	}
+	s.hb = hb
+	s.baseURL = downloader.ServiceBaseURL(tarballServiceName)
+	log.Info("registered file server in discoverd", "service", tarballServiceName, "addr", addr)
+	return s, nil
+}
+
+// Close unregisters the server from discoverd and stops serving.
+func (s *tarballServer) Close() error {
+	if s.hb != nil {
+		s.hb.Close()
+	}
+	return s.srv.Close()
+}
+
+// tarballServiceInstances returns the registered tarball file servers, it is
+// a variable so it can be replaced in tests.
+var tarballServiceInstances = func() ([]*discoverd.Instance, error) {
+	return discoverd.GetInstances(tarballServiceName, 10*time.Second)
+}
+
+// tarballServiceVersion returns the version served by the registered tarball
+// file servers. It is an error for the servers to disagree on the version
+// since hosts would then pull a mix of releases.
+func tarballServiceVersion() (string, error) {
+	instances, err := tarballServiceInstances()
+	if err != nil {
+		return "", fmt.Errorf("no tarball file servers registered in discoverd (start one with --serve-tarball): %w", err)
+	}
+	versions := make(map[string]struct{})
+	for _, inst := range instances {
+		if v := inst.Meta[downloader.VersionMetaKey]; v != "" {
+			versions[v] = struct{}{}
+		}
+	}
+	if len(versions) == 0 {
+		return "", fmt.Errorf("registered tarball file servers do not advertise a version")
+	}
+	if len(versions) > 1 {
+		list := make([]string, 0, len(versions))
+		for v := range versions {
+			list = append(list, v)
+		}
+		sort.Strings(list)
+		return "", fmt.Errorf("registered tarball file servers are serving different versions: %s", strings.Join(list, ", "))
+	}
+	for v := range versions {
+		return v, nil
+	}
+	return "", nil
+}
+
+// fetchTarballContents downloads the named files from the registered tarball
+// file servers into destDir, mirroring the layout of an extracted tarball so
+// the rest of the update can run as if the tarball were local. Missing
+// optional files (such as checksums) are skipped.
+func fetchTarballContents(version, destDir string, required, optional []string, log log15.Logger) error {
+	d := downloader.NewWithBaseURL(downloader.ServiceBaseURL(tarballServiceName), nil, version, log)
+	for _, name := range required {
+		log.Info("fetching file from tarball file server", "name", name)
+		if err := d.FetchFile(name, filepath.Join(destDir, name)); err != nil {
+			return fmt.Errorf("failed to fetch %s: %w", name, err)
+		}
+	}
+	for _, name := range optional {
+		if err := d.FetchFile(name, filepath.Join(destDir, name)); err != nil {
+			log.Warn("failed to fetch optional file from tarball file server", "name", name, "err", err)
+		}
+	}
+	return nil
+}
+
+// runServeTarball extracts the tarball and serves its contents to the cluster
+// until interrupted, without updating anything. Running it on additional
+// nodes adds failover targets for hosts pulling an update, and lets the
+// update itself be driven from any node with --from-cluster-tarball.
+func runServeTarball(tarballPath string, log log15.Logger) error {
+	if _, err := os.Stat(tarballPath); err != nil {
+		return fmt.Errorf("tarball not found: %s", tarballPath)
+	}
+
+	extractDir, err := os.MkdirTemp("", "flynn-tarball-serve-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(extractDir)
+
+	log.Info("extracting tarball", "dest", extractDir)
+	version, contentDir, err := extractTarball(tarballPath, extractDir)
+	if err != nil {
+		return fmt.Errorf("failed to extract tarball: %w", err)
+	}
+
+	srv, err := startTarballServer(contentDir, version, log)
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+	if srv.hb == nil {
+		return fmt.Errorf("failed to register file server in discoverd; serving without registration would not be discoverable by other hosts")
+	}
+
+	fmt.Printf("Serving %s at %s (service %s). Press Ctrl-C to stop.\n", version, srv.directURL, tarballServiceName)
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	<-ch
+	log.Info("stopping tarball file server")
+	return nil
+}
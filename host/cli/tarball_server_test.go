@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	discoverd "github.com/flynn/flynn/discoverd/client"
+	"github.com/flynn/flynn/host/downloader"
+)
+
+func TestTarballServiceVersion(t *testing.T) {
+	defer func(f func() ([]*discoverd.Instance, error)) { tarballServiceInstances = f }(tarballServiceInstances)
+	instances := func(versions ...string) func() ([]*discoverd.Instance, error) {
+		return func() ([]*discoverd.Instance, error) {
+			res := make([]*discoverd.Instance, len(versions))
+			for i, v := range versions {
+				res[i] = &discoverd.Instance{Addr: "10.0.0.1:80", Meta: map[string]string{}}
+				if v != "" {
+					res[i].Meta[downloader.VersionMetaKey] = v
+				}
+			}
+			return res, nil
+		}
+	}
+
+	// servers which agree on the version, ignoring those which do not
+	// advertise one
+	tarballServiceInstances = instances("v1", "v1", "")
+	if v, err := tarballServiceVersion(); err != nil || v != "v1" {
+		t.Fatalf("expected v1, got %q (err: %v)", v, err)
+	}
+
+	// servers serving different versions are rejected
+	tarballServiceInstances = instances("v1", "v2")
+	if _, err := tarballServiceVersion(); err == nil || !strings.Contains(err.Error(), "v1, v2") {
+		t.Fatalf("expected a mixed version error, got %v", err)
+	}
+
+	tarballServiceInstances = instances("")
+	if _, err := tarballServiceVersion(); err == nil {
+		t.Fatal("expected an error when no server advertises a version")
+	}
+
+	tarballServiceInstances = func() ([]*discoverd.Instance, error) {
+		return nil, errors.New("service not found")
+	}
+	if _, err := tarballServiceVersion(); err == nil {
+		t.Fatal("expected an error when no servers are registered")
+	}
+}
@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/flynn/flynn/pkg/certgen"
+	"github.com/flynn/go-docopt"
+)
+
+func init() {
+	Register("tls", runTLS, `
+usage: flynn-host tls ca [--dir=<dir>]
+       flynn-host tls host [--dir=<dir>] <ip>...
+       flynn-host tls client [--dir=<dir>] <name>
+
+Manage certificates for mutual TLS between flynn-host and its clients.
+
+Commands:
+	ca      generate the cluster's host CA, writing ca.pem and ca-key.pem
+	host    issue a server certificate for a host with the given IPs,
+	        writing host.pem and host-key.pem
+	client  issue a client certificate for a host API client such as the
+	        controller or scheduler, writing <name>.pem and <name>-key.pem
+
+Options:
+	--dir=<dir>  directory containing the CA and to write certificates to [default: .]
+
+The CA key must be kept private, it is only needed to issue certificates.
+
+Hosts are configured with the --tls-cert, --tls-key, --tls-client-ca and
+--tls-require-client-cert daemon flags, for example:
+
+	flynn-host daemon --tls-cert host.pem --tls-key host-key.pem --tls-client-ca ca.pem
+
+Clients are configured with the PEM encoded CA, certificate and key in the
+FLYNN_HOST_TLS_CA, FLYNN_HOST_TLS_CLIENT_CERT and FLYNN_HOST_TLS_CLIENT_KEY
+environment variables, for example:
+
+	flynn -a controller env set \
+	  FLYNN_HOST_TLS_CA="$(cat ca.pem)" \
+	  FLYNN_HOST_TLS_CLIENT_CERT="$(cat controller.pem)" \
+	  FLYNN_HOST_TLS_CLIENT_KEY="$(cat controller-key.pem)"
+`)
+}
+
+func runTLS(args *docopt.Args) error {
+	dir := args.String["--dir"]
+	switch {
+	case args.Bool["ca"]:
+		caFile := filepath.Join(dir, "ca.pem")
+		if _, err := os.Stat(caFile); err == nil {
+			return fmt.Errorf("%s already exists, remove it to generate a new CA", caFile)
+		}
+		ca, err := certgen.Generate(certgen.Params{IsCA: true})
+		if err != nil {
+			return err
+		}
+		return writeCert(dir, "ca", ca)
+	case args.Bool["host"]:
+		ips := args.All["<ip>"].([]string)
+		for _, ip := range ips {
+			if net.ParseIP(ip) == nil {
+				return fmt.Errorf("invalid IP %q", ip)
+			}
+		}
+		return issueCert(dir, "host", certgen.Params{Hosts: ips})
+	case args.Bool["client"]:
+		name := args.String["<name>"]
+		if name == "ca" || name == "host" || filepath.Base(name) != name {
+			return fmt.Errorf("invalid client name %q", name)
+		}
+		return issueCert(dir, name, certgen.Params{Hosts: []string{name}, ClientAuth: true})
+	}
+	return nil
+}
+
+func issueCert(dir, name string, params certgen.Params) error {
+	certPEM, err := ioutil.ReadFile(filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		return fmt.Errorf("error reading CA, generate one with 'flynn-host tls ca': %s", err)
+	}
+	keyPEM, err := ioutil.ReadFile(filepath.Join(dir, "ca-key.pem"))
+	if err != nil {
+		return fmt.Errorf("error reading CA key: %s", err)
+	}
+	params.CA, err = certgen.Parse(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	cert, err := certgen.Generate(params)
+	if err != nil {
+		return err
+	}
+	return writeCert(dir, name, cert)
+}
+
+func writeCert(dir, name string, cert *certgen.Certificate) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+"-key.pem")
+	if err := ioutil.WriteFile(keyFile, []byte(cert.KeyPEM), 0600); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(certFile, []byte(cert.PEM), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s and %s (pin %s)\n", certFile, keyFile, cert.Pin)
+	return nil
+}
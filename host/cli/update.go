@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/flynn/flynn/pkg/ghrelease"
 	"github.com/flynn/flynn/pkg/installsource"
 	"github.com/flynn/go-docopt"
 	"github.com/inconshreveable/log15"
@@ -19,15 +20,27 @@ Options:
   --github-repo=<repo>           GitHub repository for updates [default: randy-girard/flynn]
   --check                        only check for updates, don't install
   --version=<ver>                update to a specific version
+  --channel=<channel>            release channel to update from, one of stable, beta
+                                 (prereleases) or nightly (nightly-* tags). Recorded
+                                 in install-source.json so later updates stay on the
+                                 channel; defaults to the recorded channel or stable.
   --force                        force update even if already on the latest version
   --no-restart                   only download binaries, don't restart the daemon
   --skip-images                  skip updating container images and system apps
   --images-only                  only update container images and system apps (skip binaries)
   --tarball=<path>               update from a local tarball instead of GitHub
+  --serve-tarball                serve the --tarball contents to the cluster (registered
+                                 in discoverd) until interrupted, without updating
+  --from-cluster-tarball         update from tarball contents already served by another
+                                 node (see --serve-tarball) instead of a local tarball
   --all-nodes                    update the entire cluster: push binaries to other
                                  hosts, pull images on every node, deploy system apps.
                                  Without this flag, only this host is updated (binaries
                                  locally; no cluster-wide image rollout).
+  --hosts=<selector>             only update the given hosts, as a comma-separated list
+                                 of host IDs and/or key=value host tags (e.g. to canary
+                                 a release on one node). Implies --all-nodes for the
+                                 selected hosts; system apps are not deployed.
   --health-timeout=<duration>    per-host wait for the cluster to report healthy
                                  between rolling restarts (e.g. 10m). Larger clusters
                                  or slow sirenia replication may need a longer timeout
@@ -36,10 +49,23 @@ Options:
                                  starting the next host's restart, to let the
                                  scheduler observe the restarted host coming back up
                                  and re-place jobs onto it (e.g. 30s).
+  --wait-for-maintenance         with --all-nodes or --hosts, restart each remote host
+                                 only during one of its maintenance windows (see
+                                 flynn-host daemon --maintenance-windows), waiting
+                                 for the window to start. Hosts without windows are
+                                 not waited for.
   --wait-jobs-timeout=<duration> per-host wait for the scheduler to place at least
                                  one app job back on the freshly restarted host before
                                  continuing. Non-fatal: logs a warning and continues
                                  on timeout (e.g. 3m).
+  --pause-on-failure             stop the update when the cluster is unhealthy after
+                                 restarting a host or deploying a system app, or a
+                                 host is not running the new version, rather than
+                                 warning and continuing. Fix the problem, then
+                                 continue the update with --resume.
+  --resume                       resume an update which was paused or failed, skipping
+                                 the hosts and system apps which were already updated.
+                                 Run it on the host which started the update.
 
 Update Flynn components using GitHub releases or a local tarball.
 
@@ -52,6 +78,15 @@ Use --all-nodes to roll the same binaries out to every cluster host, pull new
 container layers everywhere, and deploy updated system apps. Until then, image
 and system-app updates are skipped so you can update hosts manually in any order.
 
+Use --hosts to canary a new version on some hosts before rolling it out to the
+rest of the cluster. Binaries and images are only updated on matching hosts
+(including this one only if it matches). The controller's /cluster/versions
+endpoint reports the resulting mixed-version state until every host is updated.
+
+Remote hosts currently in a maintenance window are always updated first,
+followed by those whose next window starts soonest. Use --wait-for-maintenance
+to also hold each restart until the host's window starts.
+
 Use --skip-images with --all-nodes to update binaries on every node without
 touching container images. --images-only requires --all-nodes (image rollout is
 always cluster-wide).
@@ -59,7 +94,19 @@ always cluster-wide).
 When --tarball is specified, the update is performed from a local .tar.gz file
 (the same tarball produced by the release scripts) instead of GitHub. With
 --all-nodes, a temporary HTTP server is started on this node to serve the
-tarball contents to other cluster nodes.`)
+tarball contents to other cluster nodes. The server registers itself in
+discoverd so hosts fetch by service name and fail over between servers.
+
+Each host is restarted in turn, waiting for it to rejoin the cluster, for the
+cluster to report healthy and for the host to report the new version before
+restarting the next. Before deploying system apps, every host is checked to be
+running the new version, and the cluster is checked to be healthy after each
+system app deploy. The progress of the update is recorded in the config dir so
+that an update which stops can be resumed with --resume.
+
+Use --serve-tarball on one or more nodes to serve a tarball without updating,
+then run the update with --from-cluster-tarball from any node (including one
+without the tarball) to fetch everything from those servers.`)
 }
 
 // minVersion is the minimum version that can be updated from.
@@ -74,7 +121,7 @@ In order to update to this version a cluster backup/restore is required.
 Please see the updating documentation at https://flynn.io/docs/production#backup/restore.
 `[1:], minVersion)
 
-func runUpdate(args *docopt.Args) error {
+func runUpdate(args *docopt.Args) (err error) {
 	log := log15.New()
 	configDir := args.String["--config-dir"]
 
@@ -84,26 +131,66 @@ func runUpdate(args *docopt.Args) error {
 	if err := applyUpdateTimingFlags(args, log); err != nil {
 		return err
 	}
+	updateWaitForMaintenance = args.Bool["--wait-for-maintenance"]
+	if !args.Bool["--check"] && !args.Bool["--serve-tarball"] {
+		o, err := newUpdateOrchestrator(configDir, args.Bool["--resume"], args.Bool["--pause-on-failure"], log)
+		if err != nil {
+			return err
+		}
+		updateRun = o
+		defer func() { updateRun.recordHistory(err) }()
+	} else if args.Bool["--resume"] {
+		return fmt.Errorf("--resume cannot be combined with --check or --serve-tarball")
+	}
+	if raw := args.String["--hosts"]; raw != "" {
+		sel, err := parseHostSelector(raw)
+		if err != nil {
+			return fmt.Errorf("invalid value for --hosts: %w", err)
+		}
+		updateHostSelector = sel
+		log.Info("restricting update to selected hosts", "hosts", sel)
+	}
 
 	// If --tarball is specified, use tarball-based update
-	if tarballPath := args.String["--tarball"]; tarballPath != "" {
+	tarballPath := args.String["--tarball"]
+	if args.Bool["--serve-tarball"] {
+		if tarballPath == "" {
+			return fmt.Errorf("--serve-tarball requires --tarball")
+		}
+		return runServeTarball(tarballPath, log)
+	}
+	if args.Bool["--from-cluster-tarball"] {
+		if tarballPath != "" {
+			return fmt.Errorf("--from-cluster-tarball cannot be combined with --tarball")
+		}
+		return runTarballUpdate(args, "", configDir, log)
+	}
+	if tarballPath != "" {
 		return runTarballUpdate(args, tarballPath, configDir, log)
 	}
 
-	// Get repository from install-source.json or use default
+	// Get repository and channel from install-source.json or use defaults
 	repo := args.String["--github-repo"]
+	channelName := args.String["--channel"]
 	installSource, err := installsource.Load(configDir)
 	if err == nil {
-		log.Info("detected installation source", "source", installSource.Source, "version", installSource.Version)
+		log.Info("detected installation source", "source", installSource.Source, "version", installSource.Version, "channel", installSource.Channel)
 		if installSource.Repository != "" && repo == "randy-girard/flynn" {
 			// Use the repository from install-source.json if not explicitly overridden
 			repo = installSource.Repository
 		}
+		if channelName == "" {
+			channelName = installSource.Channel
+		}
 	} else {
 		log.Info("no install-source.json found, using default repository", "repo", repo)
 	}
+	channel, err := ghrelease.ParseChannel(channelName)
+	if err != nil {
+		return err
+	}
 
-	return runGitHubUpdate(args, repo, configDir, log)
+	return runGitHubUpdate(args, repo, channel, configDir, log)
 }
 
 // applyUpdateTimingFlags parses the optional --health-timeout,
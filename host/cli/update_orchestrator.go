@@ -0,0 +1,352 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flynn/flynn/pkg/cluster"
+	"github.com/flynn/flynn/pkg/installsource"
+	"github.com/flynn/flynn/pkg/random"
+	"github.com/flynn/flynn/pkg/version"
+	"github.com/inconshreveable/log15"
+)
+
+// updateProgressFile is the file in the config dir which records the
+// progress of an update so that it can be resumed with --resume
+const updateProgressFile = "update-progress.json"
+
+// updateProgress records the steps of an update which have completed.
+type updateProgress struct {
+	// Version is the version being updated to
+	Version string `json:"version"`
+	// Hosts are the hosts whose binaries have been updated and whose
+	// daemons have been restarted
+	Hosts []string `json:"hosts,omitempty"`
+	// ImagesPulled is set once images have been pulled on every host
+	ImagesPulled bool `json:"images_pulled,omitempty"`
+	// Apps are the system apps which have been deployed
+	Apps []string `json:"apps,omitempty"`
+	// FailedStep is the step the update stopped at, if any
+	FailedStep string    `json:"failed_step,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// updateRun tracks the progress of the current update. It is nil if the
+// update is not tracked, in which case failed health checks are only logged.
+var updateRun *updateOrchestrator
+
+// updateOrchestrator gates each step of an update on the cluster being
+// healthy, recording the completed steps so that an update which failed or
+// was paused can be resumed, and records each update in the update history.
+// Its methods may be called on a nil *updateOrchestrator.
+type updateOrchestrator struct {
+	configDir      string
+	path           string
+	pauseOnFailure bool
+	progress       *updateProgress
+	log            log15.Logger
+
+	// record is the history record of the update, and hostStarted when
+	// the host currently being updated was started
+	record      *installsource.UpdateRecord
+	hostStarted time.Time
+}
+
+// newUpdateOrchestrator returns an orchestrator recording progress in
+// configDir, loading the progress of a previous update if resume is set.
+func newUpdateOrchestrator(configDir string, resume, pauseOnFailure bool, log log15.Logger) (*updateOrchestrator, error) {
+	o := &updateOrchestrator{
+		configDir:      configDir,
+		path:           filepath.Join(configDir, updateProgressFile),
+		pauseOnFailure: pauseOnFailure,
+		log:            log,
+	}
+	data, err := os.ReadFile(o.path)
+	if os.IsNotExist(err) {
+		if resume {
+			return nil, fmt.Errorf("--resume: no update to resume (%s does not exist)", o.path)
+		}
+		return o, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var progress updateProgress
+	if err := json.Unmarshal(data, &progress); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %s", o.path, err)
+	}
+	if resume {
+		o.progress = &progress
+		log.Info("resuming update", "version", progress.Version, "hosts", progress.Hosts, "images_pulled", progress.ImagesPulled, "apps", progress.Apps, "failed_step", progress.FailedStep)
+		fmt.Printf("Resuming update to %s\n", progress.Version)
+	} else {
+		log.Warn("discarding progress of previous update, use --resume to continue it", "version", progress.Version, "failed_step", progress.FailedStep)
+	}
+	return o, nil
+}
+
+// resumeVersion returns the version of the update being resumed, if any.
+func (o *updateOrchestrator) resumeVersion() string {
+	if o == nil || o.progress == nil {
+		return ""
+	}
+	return o.progress.Version
+}
+
+// resuming returns whether a previous update is being resumed.
+func (o *updateOrchestrator) resuming() bool {
+	return o != nil && o.progress != nil
+}
+
+// start starts tracking an update to the given version from the given
+// source, checking that it matches the version of the update being resumed.
+func (o *updateOrchestrator) start(ver, source, location string) error {
+	if o == nil {
+		return nil
+	}
+	o.record = &installsource.UpdateRecord{
+		ID:          random.UUID(),
+		FromVersion: version.String(),
+		ToVersion:   ver,
+		Source:      source,
+		Location:    location,
+		Initiator:   installsource.Initiator(),
+		Resumed:     o.progress != nil,
+		StartedAt:   time.Now(),
+	}
+	if o.progress != nil {
+		if o.progress.Version != ver {
+			o.record = nil
+			return fmt.Errorf("--resume: the update being resumed is to %s, not %s", o.progress.Version, ver)
+		}
+		o.progress.FailedStep = ""
+		return nil
+	}
+	o.progress = &updateProgress{Version: ver}
+	o.save()
+	return nil
+}
+
+// hostDone returns whether the host has already been updated.
+func (o *updateOrchestrator) hostDone(id string) bool {
+	return o != nil && o.progress != nil && containsString(o.progress.Hosts, id)
+}
+
+// localHostDone returns whether this host has already been updated.
+func (o *updateOrchestrator) localHostDone(log log15.Logger) bool {
+	if !o.resuming() {
+		return false
+	}
+	h := localClusterHost(log)
+	return h != nil && o.hostDone(h.ID())
+}
+
+// startHost records that updating a host has started, so that the time it
+// took can be recorded in the update history.
+func (o *updateOrchestrator) startHost() {
+	if o == nil {
+		return
+	}
+	o.hostStarted = time.Now()
+}
+
+// recordHost records the result of updating the host in the update history.
+func (o *updateOrchestrator) recordHost(id string, err error) {
+	if o == nil || o.record == nil {
+		return
+	}
+	res := &installsource.HostUpdateResult{
+		ID:         id,
+		Result:     installsource.ResultSuccess,
+		StartedAt:  o.hostStarted,
+		FinishedAt: time.Now(),
+	}
+	if res.StartedAt.IsZero() {
+		res.StartedAt = res.FinishedAt
+	}
+	if err != nil {
+		res.Result = installsource.ResultFailed
+		res.Error = err.Error()
+	}
+	o.record.Hosts = append(o.record.Hosts, res)
+	o.hostStarted = time.Time{}
+}
+
+// markHost records that the host has been updated.
+func (o *updateOrchestrator) markHost(id string) {
+	if o == nil || o.progress == nil || o.hostDone(id) {
+		return
+	}
+	o.recordHost(id, nil)
+	o.progress.Hosts = append(o.progress.Hosts, id)
+	o.save()
+}
+
+// markLocalHost records that this host has been updated.
+func (o *updateOrchestrator) markLocalHost(log log15.Logger) {
+	if o == nil {
+		return
+	}
+	if h := localClusterHost(log); h != nil {
+		o.markHost(h.ID())
+	}
+}
+
+// imagesPulled returns whether images have already been pulled on every
+// host.
+func (o *updateOrchestrator) imagesPulled() bool {
+	return o != nil && o.progress != nil && o.progress.ImagesPulled
+}
+
+// markImagesPulled records that images have been pulled on every host.
+func (o *updateOrchestrator) markImagesPulled() {
+	if o == nil || o.progress == nil {
+		return
+	}
+	o.progress.ImagesPulled = true
+	o.save()
+}
+
+// appDone returns whether the system app has already been deployed.
+func (o *updateOrchestrator) appDone(name string) bool {
+	return o != nil && o.progress != nil && containsString(o.progress.Apps, name)
+}
+
+// markApp records that the system app has been deployed.
+func (o *updateOrchestrator) markApp(name string) {
+	if o == nil || o.progress == nil || o.appDone(name) {
+		return
+	}
+	o.progress.Apps = append(o.progress.Apps, name)
+	o.save()
+}
+
+// fail records that the update stopped at the given step and returns err.
+func (o *updateOrchestrator) fail(step string, err error) error {
+	if o == nil || o.progress == nil || err == nil {
+		return err
+	}
+	o.progress.FailedStep = step
+	o.save()
+	o.log.Error("update stopped", "step", step, "err", err)
+	fmt.Printf("Update stopped at %q. Once the problem is fixed, run flynn-host update --resume to continue.\n", step)
+	return err
+}
+
+// check gates the update on a health check, stopping the update if the
+// check failed and --pause-on-failure is set, and otherwise logging a
+// warning and continuing.
+func (o *updateOrchestrator) check(step string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if o != nil && o.pauseOnFailure {
+		return o.fail(step, fmt.Errorf("update paused: %w", err))
+	}
+	log := log15.New()
+	if o != nil {
+		log = o.log
+	}
+	log.Warn("health check failed, continuing with update", "step", step, "err", err)
+	fmt.Printf("Warning: %s. The update will continue.\n", err)
+	return nil
+}
+
+// finish removes the recorded progress once the update is complete.
+func (o *updateOrchestrator) finish() {
+	if o == nil || o.progress == nil {
+		return
+	}
+	if err := os.Remove(o.path); err != nil && !os.IsNotExist(err) {
+		o.log.Warn("failed to remove update progress", "path", o.path, "err", err)
+	}
+	o.progress = nil
+}
+
+// recordHistory appends the update to the update history with the given
+// result. Updates which never started, such as when already up to date, are
+// not recorded.
+func (o *updateOrchestrator) recordHistory(err error) {
+	if o == nil || o.record == nil {
+		return
+	}
+	r := o.record
+	o.record = nil
+	r.FinishedAt = time.Now()
+	r.Result = installsource.ResultSuccess
+	if err != nil {
+		r.Result = installsource.ResultFailed
+		r.Error = err.Error()
+		if o.progress != nil {
+			r.FailedStep = o.progress.FailedStep
+		}
+	}
+	if err := installsource.AppendHistory(o.configDir, r); err != nil {
+		o.log.Warn("failed to record update history", "err", err)
+	}
+}
+
+func (o *updateOrchestrator) save() {
+	o.progress.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(o.progress, "", "  ")
+	if err == nil {
+		err = os.WriteFile(o.path, data, 0644)
+	}
+	if err != nil {
+		o.log.Warn("failed to record update progress", "path", o.path, "err", err)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHostVersion returns an error if the host is not running the given
+// version of flynn-host. Development builds are not checked.
+func checkHostVersion(h *cluster.Host, want string) error {
+	status, err := h.GetStatus()
+	if err != nil {
+		return fmt.Errorf("error getting status of host %s: %s", h.ID(), err)
+	}
+	if !versionMatches(status.Version, want) {
+		return fmt.Errorf("host %s is running flynn-host %s, expected %s", h.ID(), status.Version, want)
+	}
+	return nil
+}
+
+// checkClusterVersions returns an error listing the hosts matched by --hosts
+// which are not running the given version of flynn-host.
+func checkClusterVersions(client *cluster.Client, want string) error {
+	hosts, err := client.Hosts()
+	if err != nil {
+		return fmt.Errorf("error discovering cluster hosts: %w", err)
+	}
+	var mismatched []string
+	for _, h := range updateHostSelector.filterHosts(hosts) {
+		if err := checkHostVersion(h, want); err != nil {
+			mismatched = append(mismatched, err.Error())
+		}
+	}
+	if len(mismatched) > 0 {
+		sort.Strings(mismatched)
+		return fmt.Errorf("hosts are not all running %s: %s", want, strings.Join(mismatched, "; "))
+	}
+	return nil
+}
+
+// versionMatches returns whether a host running version got is running the
+// wanted version, ignoring any "-<commit>" suffix and treating development
+// builds as matching.
+func versionMatches(got, want string) bool {
+	release := func(v string) string { return strings.SplitN(v, "-", 2)[0] }
+	return release(got) == release(want) || version.Parse(got).Dev || version.Parse(want).Dev
+}
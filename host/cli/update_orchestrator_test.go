@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/flynn/flynn/pkg/installsource"
+	"github.com/inconshreveable/log15"
+)
+
+func TestUpdateOrchestratorResume(t *testing.T) {
+	dir := t.TempDir()
+	log := log15.New()
+	log.SetHandler(log15.DiscardHandler())
+
+	if _, err := newUpdateOrchestrator(dir, true, false, log); err == nil {
+		t.Fatal("expected an error resuming without a recorded update")
+	}
+
+	o, err := newUpdateOrchestrator(dir, false, false, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.start("v20260101.0", installsource.SourceGitHub, "flynn/flynn"); err != nil {
+		t.Fatal(err)
+	}
+	o.startHost()
+	o.markHost("host0")
+	o.markHost("host0")
+	o.markImagesPulled()
+	o.markApp("discoverd")
+	expected := errors.New("deploy failed")
+	if err := o.fail("deploy controller", expected); err != expected {
+		t.Fatalf("expected %v, got %v", expected, err)
+	}
+	o.recordHistory(expected)
+
+	o, err = newUpdateOrchestrator(dir, true, false, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := o.resumeVersion(); v != "v20260101.0" {
+		t.Fatalf("expected to resume v20260101.0, got %q", v)
+	}
+	if !reflect.DeepEqual(o.progress.Hosts, []string{"host0"}) || !o.hostDone("host0") || o.hostDone("host1") {
+		t.Fatalf("unexpected hosts %v", o.progress.Hosts)
+	}
+	if !o.imagesPulled() || !o.appDone("discoverd") || o.appDone("controller") {
+		t.Fatalf("unexpected progress %+v", o.progress)
+	}
+	if o.progress.FailedStep != "deploy controller" {
+		t.Fatalf("expected failed step to be recorded, got %q", o.progress.FailedStep)
+	}
+	if err := o.start("v20260102.0", installsource.SourceGitHub, "flynn/flynn"); err == nil {
+		t.Fatal("expected an error resuming an update to a different version")
+	}
+	if err := o.start("v20260101.0", installsource.SourceGitHub, "flynn/flynn"); err != nil {
+		t.Fatal(err)
+	}
+
+	o.finish()
+	if _, err := os.Stat(filepath.Join(dir, updateProgressFile)); !os.IsNotExist(err) {
+		t.Fatalf("expected progress to be removed, got %v", err)
+	}
+	o.recordHistory(nil)
+	o.recordHistory(nil)
+
+	// each run of the update is recorded once in the history
+	history, err := installsource.LoadHistory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("expected 2 history records, got %d", len(history))
+	}
+	failed, resumed := history[0], history[1]
+	if failed.Result != installsource.ResultFailed || failed.Error != "deploy failed" || failed.FailedStep != "deploy controller" || failed.Resumed {
+		t.Fatalf("unexpected failed record %+v", failed)
+	}
+	if len(failed.Hosts) != 1 || failed.Hosts[0].ID != "host0" || failed.Hosts[0].Result != installsource.ResultSuccess {
+		t.Fatalf("unexpected host results %+v", failed.Hosts)
+	}
+	if resumed.Result != installsource.ResultSuccess || !resumed.Resumed || resumed.ToVersion != "v20260101.0" || resumed.Location != "flynn/flynn" {
+		t.Fatalf("unexpected resumed record %+v", resumed)
+	}
+	if resumed.ID == failed.ID {
+		t.Fatal("expected records to have unique IDs")
+	}
+}
+
+func TestUpdateOrchestratorCheck(t *testing.T) {
+	log := log15.New()
+	log.SetHandler(log15.DiscardHandler())
+	unhealthy := errors.New("cluster is unhealthy")
+
+	// without --pause-on-failure, failed checks are only logged
+	var o *updateOrchestrator
+	if err := o.check("cluster health", unhealthy); err != nil {
+		t.Fatalf("expected a nil orchestrator to continue, got %v", err)
+	}
+	if o.hostDone("host0") || o.appDone("controller") || o.imagesPulled() {
+		t.Fatal("expected a nil orchestrator to have no progress")
+	}
+	o, err := newUpdateOrchestrator(t.TempDir(), false, false, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.start("v20260101.0", installsource.SourceGitHub, "flynn/flynn")
+	if err := o.check("cluster health", unhealthy); err != nil {
+		t.Fatalf("expected the update to continue, got %v", err)
+	}
+
+	o, err = newUpdateOrchestrator(t.TempDir(), false, true, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.start("v20260101.0", installsource.SourceGitHub, "flynn/flynn")
+	if err := o.check("cluster health", unhealthy); !errors.Is(err, unhealthy) {
+		t.Fatalf("expected the update to pause, got %v", err)
+	}
+	if o.progress.FailedStep != "cluster health" {
+		t.Fatalf("expected paused step to be recorded, got %q", o.progress.FailedStep)
+	}
+}
+
+func TestVersionMatches(t *testing.T) {
+	for _, x := range []struct {
+		got, want string
+		expected  bool
+	}{
+		{"v20260101.0", "v20260101.0", true},
+		{"v20260101.0-abc123", "v20260101.0", true},
+		{"v20251201.0", "v20260101.0", false},
+		{"dev", "v20260101.0", true},
+	} {
+		if actual := versionMatches(x.got, x.want); actual != x.expected {
+			t.Errorf("versionMatches(%q, %q): expected %t, got %t", x.got, x.want, x.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,559 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	controller "github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	discoverd "github.com/flynn/flynn/discoverd/client"
+	host "github.com/flynn/flynn/host/types"
+	logaggc "github.com/flynn/flynn/logaggregator/client"
+	logagg "github.com/flynn/flynn/logaggregator/types"
+	"github.com/flynn/flynn/pkg/cluster"
+	"github.com/flynn/flynn/pkg/random"
+	"github.com/flynn/flynn/pkg/tlscert"
+	router "github.com/flynn/flynn/router/types"
+	"github.com/flynn/go-docopt"
+)
+
+func init() {
+	Register("verify-cluster", runVerifyCluster, `
+usage: flynn-host verify-cluster [options]
+
+Run an end-to-end smoke test of the cluster.
+
+A small test app is deployed and used to check that the main subsystems work,
+then the app and everything created for it is deleted. The result of each check
+is printed, and the command fails if any check fails.
+
+Checks:
+    deploy     create and deploy an app running a web process
+    route      add an HTTPS route for the app and make a request through the router
+    scale      scale the web process up and back down
+    logs       read the web process output from the log aggregator
+    run        run a one-off job and check its output
+    resources  provision and deprovision a resource
+
+Options:
+    --acme                 use a managed certificate for the route instead of
+                           a self-signed one, ACME must be configured (ideally
+                           with the staging directory to avoid rate limits)
+    --acme-timeout=SECS    seconds to wait for the certificate to be issued [default: 300]
+    --provider=NAME        resource provider to test [default: postgres]
+    --skip=CHECKS          comma separated list of checks to skip
+    --timeout=SECS         seconds to wait for each check [default: 120]
+    --keep                 keep the test app after the checks for debugging
+
+Examples:
+
+  $ flynn-host verify-cluster
+  CHECK      STATUS  DETAIL
+  deploy     pass    deployed verify-4a8c2f0e1b
+  route      pass    https://verify-4a8c2f0e1b.1.localflynn.com responded
+  scale      pass    scaled web to 2 processes and back
+  logs       pass    found web output in logs
+  run        pass    one-off job output matched
+  resources  pass    provisioned and removed a postgres resource
+  teardown   pass    deleted verify-4a8c2f0e1b
+`)
+}
+
+const (
+	verifyPass = "pass"
+	verifyFail = "fail"
+	verifySkip = "skip"
+)
+
+// verifyCheck is a check run by verify-cluster.
+type verifyCheck struct {
+	name string
+
+	// requires is the names of checks which must pass before this one is run
+	requires []string
+
+	// run runs the check, returning a detail message on success
+	run func() (string, error)
+}
+
+type verifyResult struct {
+	Check  string
+	Status string
+	Detail string
+}
+
+// runVerifyChecks runs the checks in order followed by teardown, skipping
+// checks which are in skip or whose requirements didn't pass. teardown is
+// always run.
+func runVerifyChecks(checks []verifyCheck, skip map[string]bool, teardown func() (string, error)) []verifyResult {
+	results := make([]verifyResult, 0, len(checks)+1)
+	passed := make(map[string]bool, len(checks))
+outer:
+	for _, check := range checks {
+		if skip[check.name] {
+			results = append(results, verifyResult{check.name, verifySkip, "skipped by --skip"})
+			continue
+		}
+		for _, name := range check.requires {
+			if !passed[name] {
+				results = append(results, verifyResult{check.name, verifySkip, fmt.Sprintf("requires %s", name)})
+				continue outer
+			}
+		}
+		detail, err := check.run()
+		if err != nil {
+			results = append(results, verifyResult{check.name, verifyFail, err.Error()})
+			continue
+		}
+		passed[check.name] = true
+		results = append(results, verifyResult{check.name, verifyPass, detail})
+	}
+	if teardown != nil {
+		detail, err := teardown()
+		if err != nil {
+			results = append(results, verifyResult{"teardown", verifyFail, err.Error()})
+		} else {
+			results = append(results, verifyResult{"teardown", verifyPass, detail})
+		}
+	}
+	return results
+}
+
+func runVerifyCluster(args *docopt.Args) error {
+	timeout, err := strconv.Atoi(args.String["--timeout"])
+	if err != nil || timeout <= 0 {
+		return fmt.Errorf("invalid --timeout value %q", args.String["--timeout"])
+	}
+	acmeTimeout, err := strconv.Atoi(args.String["--acme-timeout"])
+	if err != nil || acmeTimeout <= 0 {
+		return fmt.Errorf("invalid --acme-timeout value %q", args.String["--acme-timeout"])
+	}
+	skip := make(map[string]bool)
+	if s := args.String["--skip"]; s != "" {
+		for _, name := range strings.Split(s, ",") {
+			skip[strings.TrimSpace(name)] = true
+		}
+	}
+
+	client, err := getControllerClient()
+	if err != nil {
+		return fmt.Errorf("error connecting to controller: %s", err)
+	}
+	v := &clusterVerifier{
+		client:      client,
+		acme:        args.Bool["--acme"],
+		provider:    args.String["--provider"],
+		timeout:     time.Duration(timeout) * time.Second,
+		acmeTimeout: time.Duration(acmeTimeout) * time.Second,
+		marker:      "verify-cluster-" + random.String(8),
+	}
+
+	teardown := v.teardown
+	if args.Bool["--keep"] {
+		teardown = nil
+	}
+	results := runVerifyChecks(v.checks(), skip, teardown)
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
+	listRec(w, "CHECK", "STATUS", "DETAIL")
+	failed := 0
+	for _, r := range results {
+		listRec(w, r.Check, r.Status, r.Detail)
+		if r.Status == verifyFail {
+			failed++
+		}
+	}
+	w.Flush()
+	if args.Bool["--keep"] && v.app != nil {
+		fmt.Printf("\nkept test app %s\n", v.app.Name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(results))
+	}
+	return nil
+}
+
+// clusterVerifier runs the verify-cluster checks against a test app.
+type clusterVerifier struct {
+	client      controller.Client
+	acme        bool
+	provider    string
+	timeout     time.Duration
+	acmeTimeout time.Duration
+
+	// marker is written to stdout by the test app's processes so their
+	// output can be identified
+	marker string
+
+	app      *ct.App
+	release  *ct.Release
+	route    *router.Route
+	resource *ct.Resource
+}
+
+func (v *clusterVerifier) checks() []verifyCheck {
+	return []verifyCheck{
+		{name: "deploy", run: v.deploy},
+		{name: "route", requires: []string{"deploy"}, run: v.checkRoute},
+		{name: "scale", requires: []string{"deploy"}, run: v.checkScale},
+		{name: "logs", requires: []string{"deploy"}, run: v.checkLogs},
+		{name: "run", requires: []string{"deploy"}, run: v.checkRun},
+		{name: "resources", requires: []string{"deploy"}, run: v.checkResources},
+	}
+}
+
+// verifyWebScript is run by the test app's web process, it serves a page
+// containing ok with the busybox HTTP server.
+const verifyWebScript = `echo "$VERIFY_MARKER" && mkdir -p /tmp/www && echo ok > /tmp/www/index.html && exec httpd -f -p "$PORT" -h /tmp/www`
+
+// deploy creates the test app using the image of the status app, which is
+// based on busybox and exists in every cluster.
+func (v *clusterVerifier) deploy() (string, error) {
+	status, err := v.client.GetAppRelease("status")
+	if err != nil {
+		return "", fmt.Errorf("error getting status app release: %s", err)
+	}
+	if len(status.ArtifactIDs) == 0 {
+		return "", errors.New("status app release has no artifacts")
+	}
+
+	v.app = &ct.App{Name: "verify-" + random.String(10)}
+	if err := v.client.CreateApp(v.app); err != nil {
+		v.app = nil
+		return "", fmt.Errorf("error creating app: %s", err)
+	}
+	v.release = &ct.Release{
+		ArtifactIDs: status.ArtifactIDs[:1],
+		Env:         map[string]string{"VERIFY_MARKER": v.marker},
+		Processes: map[string]ct.ProcessType{
+			"web": {
+				Args: []string{"sh", "-c", verifyWebScript},
+				Ports: []ct.Port{{
+					Port:  8080,
+					Proto: "tcp",
+					Service: &host.Service{
+						Name:   v.app.Name + "-web",
+						Create: true,
+						Check:  &host.HealthCheck{Type: "tcp"},
+					},
+				}},
+			},
+		},
+	}
+	if err := v.client.CreateRelease(v.app.ID, v.release); err != nil {
+		return "", fmt.Errorf("error creating release: %s", err)
+	}
+	if err := v.client.SetAppRelease(v.app.ID, v.release.ID); err != nil {
+		return "", fmt.Errorf("error setting app release: %s", err)
+	}
+	if err := v.scale(1); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("deployed %s", v.app.Name), nil
+}
+
+func (v *clusterVerifier) scale(web int) error {
+	if err := v.client.ScaleAppRelease(v.app.ID, v.release.ID, ct.ScaleOptions{
+		Processes: map[string]int{"web": web},
+		Timeout:   &v.timeout,
+	}); err != nil {
+		return fmt.Errorf("error scaling web to %d: %s", web, err)
+	}
+	return nil
+}
+
+func (v *clusterVerifier) checkScale() (string, error) {
+	if err := v.scale(2); err != nil {
+		return "", err
+	}
+	jobs, err := v.client.JobList(v.app.ID)
+	if err != nil {
+		return "", fmt.Errorf("error listing jobs: %s", err)
+	}
+	up := 0
+	for _, job := range jobs {
+		if job.Type == "web" && job.State == ct.JobStateUp {
+			up++
+		}
+	}
+	if up != 2 {
+		return "", fmt.Errorf("expected 2 web jobs to be up, got %d", up)
+	}
+	if err := v.scale(1); err != nil {
+		return "", err
+	}
+	return "scaled web to 2 processes and back", nil
+}
+
+func (v *clusterVerifier) checkRoute() (string, error) {
+	controllerRelease, err := v.client.GetAppRelease("controller")
+	if err != nil {
+		return "", fmt.Errorf("error getting controller release: %s", err)
+	}
+	clusterDomain := controllerRelease.Env["DEFAULT_ROUTE_DOMAIN"]
+	if clusterDomain == "" {
+		return "", errors.New("could not determine cluster domain from controller")
+	}
+	domain := v.app.Name + "." + clusterDomain
+
+	v.route = router.HTTPRoute{
+		Service: v.app.Name + "-web",
+		Domain:  domain,
+	}.ToRoute()
+	tlsConfig := &tls.Config{ServerName: domain}
+	if v.acme {
+		config, err := v.client.GetACMEConfig()
+		if err != nil {
+			return "", fmt.Errorf("error getting ACME config: %s", err)
+		}
+		if !config.Enabled {
+			return "", errors.New("ACME is not enabled, see 'flynn-host acme'")
+		}
+		v.route.ManagedCertificateDomain = &domain
+		// certificates from the staging directory aren't trusted, so
+		// the presented certificate is compared with the issued one
+		tlsConfig.InsecureSkipVerify = true
+	} else {
+		cert, err := tlscert.Generate([]string{domain})
+		if err != nil {
+			return "", fmt.Errorf("error generating certificate: %s", err)
+		}
+		v.route.Certificate = &router.Certificate{Cert: cert.Cert, Key: cert.PrivateKey}
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM([]byte(cert.CACert))
+		tlsConfig.RootCAs = pool
+	}
+	if err := v.client.CreateRoute(v.app.ID, v.route); err != nil {
+		return "", fmt.Errorf("error creating route: %s", err)
+	}
+
+	var issued []byte
+	if v.acme {
+		if issued, err = v.waitForCertificate(domain); err != nil {
+			return "", err
+		}
+	}
+
+	addrs, err := discoverd.NewService("router-http").Addrs()
+	if err != nil || len(addrs) == 0 {
+		return "", fmt.Errorf("error discovering router: %v", err)
+	}
+	routerHost, _, _ := net.SplitHostPort(addrs[0])
+	httpClient := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+			Dial: func(network, _ string) (net.Conn, error) {
+				return net.DialTimeout(network, net.JoinHostPort(routerHost, "443"), 10*time.Second)
+			},
+		},
+	}
+	url := "https://" + domain
+	err = retryUntil(v.timeout, func() error {
+		res, err := httpClient.Get(url)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		if res.StatusCode != http.StatusOK || strings.TrimSpace(string(body)) != "ok" {
+			return fmt.Errorf("unexpected response from %s: %s", url, res.Status)
+		}
+		if issued != nil && (len(res.TLS.PeerCertificates) == 0 || !bytes.Equal(res.TLS.PeerCertificates[0].Raw, issued)) {
+			return fmt.Errorf("%s did not present the managed certificate", url)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s responded", url), nil
+}
+
+// waitForCertificate waits for the managed certificate for domain to be
+// issued and returns the DER encoded leaf certificate.
+func (v *clusterVerifier) waitForCertificate(domain string) ([]byte, error) {
+	var der []byte
+	err := retryUntil(v.acmeTimeout, func() error {
+		certs, err := v.client.ListManagedCertificates()
+		if err != nil {
+			return err
+		}
+		for _, cert := range certs {
+			if cert.Domain != domain {
+				continue
+			}
+			switch cert.Status {
+			case ct.ManagedCertificateStatusIssued:
+				block, _ := pem.Decode([]byte(cert.Cert))
+				if block == nil {
+					return errors.New("managed certificate is not PEM encoded")
+				}
+				der = block.Bytes
+				return nil
+			case ct.ManagedCertificateStatusFailed:
+				msg := "unknown error"
+				if cert.LastError != nil {
+					msg = *cert.LastError
+				}
+				return retryStop{fmt.Errorf("certificate issuance failed: %s", msg)}
+			}
+			if cert.RetryAfter != nil && time.Until(*cert.RetryAfter) > v.acmeTimeout {
+				return retryStop{fmt.Errorf("certificate issuance is rate limited by the ACME CA until %s", cert.RetryAfter.Format(time.RFC3339))}
+			}
+			return fmt.Errorf("certificate status is %s", cert.Status)
+		}
+		return errors.New("managed certificate not found")
+	})
+	return der, err
+}
+
+func (v *clusterVerifier) checkLogs() (string, error) {
+	err := retryUntil(v.timeout, func() error {
+		rc, err := v.client.GetAppLog(v.app.ID, &logagg.LogOpts{})
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		dec := json.NewDecoder(rc)
+		for {
+			var msg logaggc.Message
+			if err := dec.Decode(&msg); err == io.EOF {
+				return errors.New("web output not found in logs")
+			} else if err != nil {
+				return err
+			}
+			if msg.ProcessType == "web" && strings.Contains(msg.Msg, v.marker) {
+				return nil
+			}
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	return "found web output in logs", nil
+}
+
+func (v *clusterVerifier) checkRun() (string, error) {
+	rwc, err := v.client.RunJobAttached(v.app.ID, &ct.NewJob{
+		ReleaseID:  v.release.ID,
+		ReleaseEnv: true,
+		Args:       []string{"sh", "-c", `echo "$VERIFY_MARKER"`},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error running job: %s", err)
+	}
+	defer rwc.Close()
+	attachClient := cluster.NewAttachClient(rwc)
+	attachClient.CloseWrite()
+
+	type result struct {
+		status int
+		err    error
+	}
+	var stdout, stderr bytes.Buffer
+	done := make(chan result, 1)
+	go func() {
+		status, err := attachClient.Receive(&stdout, &stderr)
+		done <- result{status, err}
+	}()
+	select {
+	case res := <-done:
+		if res.err != nil {
+			return "", fmt.Errorf("error receiving job output: %s", res.err)
+		}
+		if res.status != 0 {
+			return "", fmt.Errorf("job exited with status %d: %s", res.status, strings.TrimSpace(stderr.String()))
+		}
+	case <-time.After(v.timeout):
+		return "", errors.New("timed out waiting for job to exit")
+	}
+	if strings.TrimSpace(stdout.String()) != v.marker {
+		return "", fmt.Errorf("unexpected job output %q", stdout.String())
+	}
+	return "one-off job output matched", nil
+}
+
+func (v *clusterVerifier) checkResources() (string, error) {
+	config := json.RawMessage(`{}`)
+	res, err := v.client.ProvisionResource(&ct.ResourceReq{
+		ProviderID: v.provider,
+		Apps:       []string{v.app.ID},
+		Config:     &config,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error provisioning %s resource: %s", v.provider, err)
+	}
+	v.resource = res
+	if len(res.Env) == 0 {
+		return "", fmt.Errorf("%s resource has no env", v.provider)
+	}
+	if _, err := v.client.DeleteResource(res.ProviderID, res.ID); err != nil {
+		return "", fmt.Errorf("error deprovisioning %s resource: %s", v.provider, err)
+	}
+	v.resource = nil
+	return fmt.Sprintf("provisioned and removed a %s resource", v.provider), nil
+}
+
+// teardown deletes everything created by the checks.
+func (v *clusterVerifier) teardown() (string, error) {
+	if v.app == nil {
+		return "nothing to delete", nil
+	}
+	var errs []string
+	if v.resource != nil {
+		if _, err := v.client.DeleteResource(v.resource.ProviderID, v.resource.ID); err != nil {
+			errs = append(errs, fmt.Sprintf("error deleting resource: %s", err))
+		}
+	}
+	if v.route != nil && v.route.ID != "" {
+		if err := v.client.DeleteRoute(v.app.ID, v.route.FormattedID()); err != nil {
+			errs = append(errs, fmt.Sprintf("error deleting route: %s", err))
+		}
+	}
+	if _, err := v.client.DeleteApp(v.app.ID); err != nil {
+		errs = append(errs, fmt.Sprintf("error deleting app: %s", err))
+	}
+	if len(errs) > 0 {
+		return "", errors.New(strings.Join(errs, ", "))
+	}
+	return fmt.Sprintf("deleted %s", v.app.Name), nil
+}
+
+// retryStop wraps an error which should stop retryUntil retrying.
+type retryStop struct {
+	error
+}
+
+// retryUntil calls f until it succeeds, it returns a retryStop error or
+// timeout elapses, returning the last error.
+func retryUntil(timeout time.Duration, f func() error) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		if stop, ok := err.(retryStop); ok {
+			return stop.error
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(time.Second)
+	}
+}
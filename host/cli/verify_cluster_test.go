@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRunVerifyChecks(t *testing.T) {
+	var ran []string
+	check := func(name string, err error, requires ...string) verifyCheck {
+		return verifyCheck{
+			name:     name,
+			requires: requires,
+			run: func() (string, error) {
+				ran = append(ran, name)
+				return name + " ok", err
+			},
+		}
+	}
+	results := runVerifyChecks([]verifyCheck{
+		check("deploy", nil),
+		check("route", errors.New("no route"), "deploy"),
+		check("proxy", nil, "route"),
+		check("logs", nil, "deploy"),
+		check("run", nil, "deploy"),
+	}, map[string]bool{"run": true}, func() (string, error) {
+		ran = append(ran, "teardown")
+		return "", errors.New("teardown failed")
+	})
+
+	if expected := []string{"deploy", "route", "logs", "teardown"}; !reflect.DeepEqual(ran, expected) {
+		t.Fatalf("expected checks %v to run, got %v", expected, ran)
+	}
+	expected := []verifyResult{
+		{"deploy", verifyPass, "deploy ok"},
+		{"route", verifyFail, "no route"},
+		{"proxy", verifySkip, "requires route"},
+		{"logs", verifyPass, "logs ok"},
+		{"run", verifySkip, "skipped by --skip"},
+		{"teardown", verifyFail, "teardown failed"},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Fatalf("expected results %v, got %v", expected, results)
+	}
+}
+
+func TestRetryUntilStop(t *testing.T) {
+	calls := 0
+	err := retryUntil(0, func() error {
+		calls++
+		return retryStop{errors.New("failed")}
+	})
+	if err == nil || err.Error() != "failed" || calls != 1 {
+		t.Fatalf("expected a single call returning failed, got %d calls and %v", calls, err)
+	}
+}
@@ -1,8 +1,14 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
 
+	"github.com/flynn/flynn/pkg/installsource"
 	"github.com/flynn/flynn/pkg/version"
 	"github.com/flynn/go-docopt"
 )
@@ -10,18 +16,102 @@ import (
 func init() {
 	Register("version", runVersion, `
 usage: flynn-host version [--release]
+       flynn-host version history [--cluster] [--json] [--config-dir=<dir>]
 
 Options:
-	--release   Print the release version
+	--release                Print the release version
+	--cluster                Show updates run from any host, via the controller
+	--json                   Print the history as JSON
+	-c --config-dir=<dir>    config directory the history is recorded in [default: /etc/flynn]
+
+Commands:
+	history  Show the history of updates run from this host
 
 Show current version.
+
+Every run of flynn-host update is recorded in install-history.json in the
+config directory of the host it was run from, with the versions, the source
+of the release, who ran it, the result and the time taken to update each host.
+
+Examples:
+
+	$ flynn-host version history
+	STARTED              FROM         TO           SOURCE  INITIATOR    RESULT   DURATION  ERROR
+	2026-01-05 10:02:11  v20251201.0  v20260101.0  github  admin@node1  success  6m12s
+	  host1                                                             success  2m3s
+	  host2                                                             success  2m9s
+
+	$ flynn-host version history --cluster --json
 `)
 }
 
-func runVersion(args *docopt.Args) {
+func runVersion(args *docopt.Args) error {
+	if args.Bool["history"] {
+		return runVersionHistory(args)
+	}
 	if args.Bool["--release"] {
 		fmt.Println(version.Release())
 	} else {
 		fmt.Println(version.String())
 	}
+	return nil
+}
+
+func runVersionHistory(args *docopt.Args) error {
+	var history []*installsource.UpdateRecord
+	if args.Bool["--cluster"] {
+		client, err := getControllerClient()
+		if err != nil {
+			return err
+		}
+		res, err := client.UpdateHistory()
+		if err != nil {
+			return fmt.Errorf("error getting cluster update history: %s", err)
+		}
+		ids := make([]string, 0, len(res.Errors))
+		for id := range res.Errors {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			fmt.Fprintf(os.Stderr, "WARN: could not get update history of %s: %s\n", id, res.Errors[id])
+		}
+		history = res.Updates
+	} else {
+		var err error
+		history, err = installsource.LoadHistory(args.String["--config-dir"])
+		if err != nil {
+			return err
+		}
+	}
+
+	if args.Bool["--json"] {
+		if history == nil {
+			history = []*installsource.UpdateRecord{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(history)
+	}
+	if len(history) == 0 {
+		fmt.Println("No updates recorded")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "STARTED\tFROM\tTO\tSOURCE\tINITIATOR\tRESULT\tDURATION\tERROR")
+	for _, r := range history {
+		result := r.Result
+		if r.Resumed {
+			result += " (resumed)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.StartedAt.Local().Format("2006-01-02 15:04:05"), r.FromVersion, r.ToVersion, r.Source,
+			r.Initiator, result, r.Duration().Round(time.Second), r.Error)
+		for _, h := range r.Hosts {
+			fmt.Fprintf(w, "  %s\t\t\t\t\t%s\t%s\t%s\n", h.ID, h.Result, h.Duration().Round(time.Second), h.Error)
+		}
+	}
+	return nil
 }
@@ -3,9 +3,11 @@ package cli
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 
+	host "github.com/flynn/flynn/host/types"
 	"github.com/flynn/flynn/pkg/cluster"
 	"github.com/flynn/flynn/pkg/random"
 	"github.com/flynn/go-docopt"
@@ -14,28 +16,54 @@ import (
 func init() {
 	Register("webhooks", runWebhooks, `
 usage: flynn-host webhooks
-       flynn-host webhooks add [-H <header>]... <url>
+       flynn-host webhooks add [-H <header>]... [--secret=<secret>] [--code=<code>]... [--min-severity=<severity>] [--max-attempts=<n>] <url>
        flynn-host webhooks remove <id>
+       flynn-host webhooks test <id>
+       flynn-host webhooks deliveries [--failed] <id>
+       flynn-host webhooks retry <id> [<delivery-id>...]
 
 Manage webhook notification endpoints across all hosts.
 
 Commands:
     With no arguments, lists all configured webhooks.
 
-    add       Add a webhook endpoint URL to all hosts
-    remove    Remove a webhook by ID from all hosts
+    add         Add a webhook endpoint URL to all hosts
+    remove      Remove a webhook by ID from all hosts
+    test        Send a synthetic test event to a webhook from each host
+    deliveries  List queued and failed deliveries to a webhook on each host
+    retry       Retry the given deliveries to a webhook, or all failed ones
 
 Options:
     -H, --header <header>  Header to send on every delivery, "Name: value".
                            May be repeated. Useful for shared-secret auth
                            (e.g. -H "X-Flynn-Webhook-Secret: ...").
+    --secret=<secret>      Sign deliveries with an X-Flynn-Signature header
+                           containing "sha256=" and the hex encoded
+                           HMAC-SHA256 of the payload using <secret>.
+                           Receivers should reject events whose timestamp
+                           is too old to prevent replays.
+    --code=<code>          Only deliver events with the given code. May be
+                           repeated, and a trailing "*" matches all codes
+                           with the prefix (e.g. --code "D*").
+    --min-severity=<severity>
+                           Only deliver events at least as severe as
+                           <severity> (info, warning, error or critical).
+    --max-attempts=<n>     Attempt deliveries up to <n> times with
+                           exponential backoff before moving them to the
+                           dead-letter log [default: 5].
+    --failed               Only list deliveries in the dead-letter log.
 
 Examples:
 
     $ flynn-host webhooks
     $ flynn-host webhooks add https://example.com/webhook
     $ flynn-host webhooks add -H "X-Flynn-Webhook-Secret: s3cret" https://example.com/webhook
+    $ flynn-host webhooks add --secret s3cret https://example.com/webhook
+    $ flynn-host webhooks add --code H21 --code "D*" https://example.com/webhook
     $ flynn-host webhooks remove abc-123
+    $ flynn-host webhooks test abc-123
+    $ flynn-host webhooks deliveries --failed abc-123
+    $ flynn-host webhooks retry abc-123
 `)
 }
 
@@ -45,6 +73,12 @@ func runWebhooks(args *docopt.Args, client *cluster.Client) error {
 		return runWebhooksAdd(args, client)
 	case args.Bool["remove"]:
 		return runWebhooksRemove(args, client)
+	case args.Bool["test"]:
+		return runWebhooksTest(args, client)
+	case args.Bool["deliveries"]:
+		return runWebhooksDeliveries(args, client)
+	case args.Bool["retry"]:
+		return runWebhooksRetry(args, client)
 	default:
 		return runWebhooksList(client)
 	}
@@ -59,7 +93,7 @@ func runWebhooksList(client *cluster.Client) error {
 	seen := make(map[string]bool)
 	w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
 	defer w.Flush()
-	listRec(w, "ID", "URL", "CREATED")
+	listRec(w, "ID", "URL", "SIGNED", "CODES", "MIN SEVERITY", "CREATED")
 	for _, h := range hosts {
 		webhooks, err := h.ListWebhooks()
 		if err != nil {
@@ -71,7 +105,7 @@ func runWebhooksList(client *cluster.Client) error {
 				continue
 			}
 			seen[wh.ID] = true
-			listRec(w, wh.ID, wh.URL, wh.CreatedAt.Format("2006-01-02 15:04:05"))
+			listRec(w, wh.ID, wh.URL, wh.HasSecret, strings.Join(wh.Codes, ","), wh.MinSeverity, wh.CreatedAt.Format("2006-01-02 15:04:05"))
 		}
 	}
 	return nil
@@ -87,10 +121,23 @@ func runWebhooksAdd(args *docopt.Args, client *cluster.Client) error {
 	if err != nil {
 		return err
 	}
-	id := random.UUID()
+	codes, _ := args.All["--code"].([]string)
+	maxAttempts, err := strconv.Atoi(args.String["--max-attempts"])
+	if err != nil || maxAttempts < 1 {
+		return fmt.Errorf("invalid --max-attempts %q", args.String["--max-attempts"])
+	}
+	wh := &host.WebhookConfig{
+		ID:          random.UUID(),
+		URL:         url,
+		Headers:     headers,
+		Secret:      args.String["--secret"],
+		Codes:       codes,
+		MinSeverity: args.String["--min-severity"],
+		MaxAttempts: maxAttempts,
+	}
 	var firstErr error
 	for _, h := range hosts {
-		if _, err := h.AddWebhook(id, url, headers); err != nil {
+		if _, err := h.AddWebhook(wh); err != nil {
 			fmt.Fprintf(os.Stderr, "error adding webhook on %s: %s\n", h.ID(), err)
 			if firstErr == nil {
 				firstErr = err
@@ -101,7 +148,7 @@ func runWebhooksAdd(args *docopt.Args, client *cluster.Client) error {
 	if firstErr != nil {
 		return firstErr
 	}
-	fmt.Printf("Webhook added: %s\n", id)
+	fmt.Printf("Webhook added: %s\n", wh.ID)
 	return nil
 }
 
@@ -144,3 +191,107 @@ func runWebhooksRemove(args *docopt.Args, client *cluster.Client) error {
 	return nil
 }
 
+
+func runWebhooksTest(args *docopt.Args, client *cluster.Client) error {
+	hosts, err := client.Hosts()
+	if err != nil {
+		return err
+	}
+	id := args.String["<id>"]
+	w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
+	defer w.Flush()
+	listRec(w, "HOST", "EVENT", "STATUS", "ERROR")
+	found, failed := false, false
+	for _, h := range hosts {
+		res, err := h.TestWebhook(id)
+		if err != nil {
+			// skip hosts that don't have this webhook
+			continue
+		}
+		found = true
+		status := ""
+		if res.StatusCode != 0 {
+			status = strconv.Itoa(res.StatusCode)
+		}
+		if res.Error != "" {
+			failed = true
+		}
+		listRec(w, h.ID(), res.EventID, status, res.Error)
+	}
+	if !found {
+		return fmt.Errorf("webhook %s not found", id)
+	} else if failed {
+		return fmt.Errorf("webhook %s test delivery failed", id)
+	}
+	return nil
+}
+
+func runWebhooksDeliveries(args *docopt.Args, client *cluster.Client) error {
+	hosts, err := client.Hosts()
+	if err != nil {
+		return err
+	}
+	var state host.WebhookDeliveryState
+	if args.Bool["--failed"] {
+		state = host.WebhookDeliveryStateFailed
+	}
+	id := args.String["<id>"]
+	w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
+	defer w.Flush()
+	listRec(w, "HOST", "ID", "EVENT", "CODE", "STATE", "ATTEMPTS", "NEXT ATTEMPT", "ERROR")
+	for _, h := range hosts {
+		deliveries, err := h.ListWebhookDeliveries(id, state)
+		if err != nil {
+			// skip hosts that don't have this webhook
+			continue
+		}
+		for _, d := range deliveries {
+			next := ""
+			if d.State == host.WebhookDeliveryStatePending {
+				next = d.NextAttemptAt.Format("2006-01-02 15:04:05")
+			}
+			listRec(w, h.ID(), d.ID, d.EventID, d.Code, d.State, d.Attempts, next, d.Error)
+		}
+	}
+	return nil
+}
+
+func runWebhooksRetry(args *docopt.Args, client *cluster.Client) error {
+	hosts, err := client.Hosts()
+	if err != nil {
+		return err
+	}
+	id := args.String["<id>"]
+	ids, _ := args.All["<delivery-id>"].([]string)
+	want := make(map[string]bool, len(ids))
+	for _, deliveryID := range ids {
+		want[deliveryID] = true
+	}
+	retried := 0
+	for _, h := range hosts {
+		// with no delivery IDs given, retry all failed deliveries
+		state := host.WebhookDeliveryStateFailed
+		if len(ids) > 0 {
+			state = ""
+		}
+		deliveries, err := h.ListWebhookDeliveries(id, state)
+		if err != nil {
+			continue
+		}
+		for _, d := range deliveries {
+			if len(ids) > 0 && !want[d.ID] {
+				continue
+			}
+			if _, err := h.RetryWebhookDelivery(id, d.ID); err != nil {
+				return fmt.Errorf("error retrying delivery %s on %s: %s", d.ID, h.ID(), err)
+			}
+			delete(want, d.ID)
+			retried++
+		}
+	}
+	for deliveryID := range want {
+		return fmt.Errorf("delivery %s not found", deliveryID)
+	}
+	fmt.Printf("Retrying %d deliveries\n", retried)
+	return nil
+}
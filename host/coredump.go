@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/go-units"
+	host "github.com/flynn/flynn/host/types"
+	"github.com/inconshreveable/log15"
+)
+
+const (
+	coreDumpRoot = "/var/lib/flynn/coredumps"
+
+	// coreDumpMountPath is where a job's core dump directory is mounted
+	// inside the container
+	coreDumpMountPath = "/.flynn-coredumps"
+
+	// corePattern is written to /proc/sys/kernel/core_pattern when a job
+	// enables core dumps. The kernel resolves the path in the mount
+	// namespace of the crashing process, so dumps from containers land in
+	// the job's core dump directory.
+	corePattern     = coreDumpMountPath + "/core.%e.%p.%t"
+	corePatternPath = "/proc/sys/kernel/core_pattern"
+
+	defaultCoreDumpMaxSize = 1 * units.GiB
+
+	// coreDumpRetention is how long core dumps are kept after a job exits
+	coreDumpRetention     = 24 * time.Hour
+	coreDumpPruneInterval = 10 * time.Minute
+)
+
+var ErrCoreDumpNotFound = errors.New("core dump not found")
+
+// CoreDumpManager manages the directories which jobs with core dumps enabled
+// dump cores into, and periodically removes dumps of jobs which have exited
+// or no longer exist, along with dumps exceeding a job's maximum size.
+type CoreDumpManager struct {
+	root  string
+	state *State
+	done  chan struct{}
+	log   log15.Logger
+
+	patternOnce sync.Once
+	patternErr  error
+}
+
+// NewCoreDumpManager creates a new manager. Call Run() to start pruning.
+func NewCoreDumpManager(root string, state *State, log log15.Logger) *CoreDumpManager {
+	return &CoreDumpManager{
+		root:  root,
+		state: state,
+		done:  make(chan struct{}),
+		log:   log.New("component", "coredumps"),
+	}
+}
+
+// Run periodically prunes core dumps. Should be called in a goroutine.
+func (m *CoreDumpManager) Run() {
+	ticker := time.NewTicker(coreDumpPruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.Prune()
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// Shutdown stops pruning.
+func (m *CoreDumpManager) Shutdown() {
+	close(m.done)
+}
+
+// Prepare creates the core dump directory for the given job and ensures the
+// kernel core pattern points at it, returning the directory.
+func (m *CoreDumpManager) Prepare(jobID string) (string, error) {
+	m.patternOnce.Do(func() { m.patternErr = m.setCorePattern() })
+	if m.patternErr != nil {
+		return "", m.patternErr
+	}
+	dir := m.jobDir(jobID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	// processes in the job may not run as root, so make the directory
+	// world writable (with the sticky bit set, like /tmp)
+	if err := os.Chmod(dir, 0777|os.ModeSticky); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+func (m *CoreDumpManager) setCorePattern() error {
+	current, err := ioutil.ReadFile(corePatternPath)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(current)) == corePattern {
+		return nil
+	}
+	m.log.Info("setting kernel core pattern", "pattern", corePattern, "previous", strings.TrimSpace(string(current)))
+	return ioutil.WriteFile(corePatternPath, []byte(corePattern), 0644)
+}
+
+func (m *CoreDumpManager) jobDir(jobID string) string {
+	return filepath.Join(m.root, jobID)
+}
+
+// List returns the core dumps captured from the given job, oldest first.
+func (m *CoreDumpManager) List(jobID string) ([]*host.CoreDump, error) {
+	dumps, err := listCoreDumps(m.jobDir(jobID))
+	if os.IsNotExist(err) {
+		return []*host.CoreDump{}, nil
+	}
+	return dumps, err
+}
+
+// Open opens the named core dump captured from the given job.
+func (m *CoreDumpManager) Open(jobID, name string) (*os.File, error) {
+	if !validCoreDumpName(name) {
+		return nil, ErrCoreDumpNotFound
+	}
+	f, err := os.Open(filepath.Join(m.jobDir(jobID), name))
+	if os.IsNotExist(err) {
+		return nil, ErrCoreDumpNotFound
+	}
+	return f, err
+}
+
+// Prune removes the core dumps of jobs which exited more than
+// coreDumpRetention ago or are no longer known to the host, and trims the
+// dumps of remaining jobs to the job's maximum size.
+func (m *CoreDumpManager) Prune() {
+	dirs, err := ioutil.ReadDir(m.root)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			m.log.Error("error reading core dump directory", "err", err)
+		}
+		return
+	}
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		jobID := d.Name()
+		log := m.log.New("job.id", jobID)
+		job := m.state.GetJob(jobID)
+		if job == nil || !job.EndedAt.IsZero() && time.Since(job.EndedAt) > coreDumpRetention {
+			log.Info("removing core dumps")
+			if err := os.RemoveAll(m.jobDir(jobID)); err != nil {
+				log.Error("error removing core dumps", "err", err)
+			}
+			continue
+		}
+		if err := trimCoreDumps(m.jobDir(jobID), coreDumpMaxSize(job.Job.Config.CoreDumps)); err != nil {
+			log.Error("error trimming core dumps", "err", err)
+		}
+	}
+}
+
+func coreDumpMaxSize(config *host.CoreDumpConfig) int64 {
+	if config == nil || config.MaxSize <= 0 {
+		return defaultCoreDumpMaxSize
+	}
+	return config.MaxSize
+}
+
+func validCoreDumpName(name string) bool {
+	return strings.HasPrefix(name, "core.") && filepath.Base(name) == name
+}
+
+func listCoreDumps(dir string) ([]*host.CoreDump, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	dumps := make([]*host.CoreDump, 0, len(files))
+	for _, f := range files {
+		if !f.Mode().IsRegular() || !validCoreDumpName(f.Name()) {
+			continue
+		}
+		dumps = append(dumps, &host.CoreDump{
+			Name:      f.Name(),
+			Size:      f.Size(),
+			CreatedAt: f.ModTime(),
+		})
+	}
+	sort.Slice(dumps, func(i, j int) bool {
+		return dumps[i].CreatedAt.Before(dumps[j].CreatedAt)
+	})
+	return dumps, nil
+}
+
+// trimCoreDumps removes the oldest core dumps in dir until their total size
+// is no more than maxSize.
+func trimCoreDumps(dir string, maxSize int64) error {
+	dumps, err := listCoreDumps(dir)
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, d := range dumps {
+		total += d.Size
+	}
+	for _, d := range dumps {
+		if total <= maxSize {
+			break
+		}
+		if err := os.Remove(filepath.Join(dir, d.Name)); err != nil {
+			return err
+		}
+		total -= d.Size
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/flynn/go-check"
+	"github.com/inconshreveable/log15"
+)
+
+func (S) TestTrimCoreDumps(c *C) {
+	dir, err := ioutil.TempDir("", "coredumps")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	for i, name := range []string{"core.app.1.100", "core.app.2.200", "core.app.3.300"} {
+		path := filepath.Join(dir, name)
+		c.Assert(ioutil.WriteFile(path, make([]byte, 100), 0644), IsNil)
+		mtime := now.Add(time.Duration(i) * time.Minute)
+		c.Assert(os.Chtimes(path, mtime, mtime), IsNil)
+	}
+	// files which aren't core dumps are ignored
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "other"), make([]byte, 1000), 0644), IsNil)
+
+	c.Assert(trimCoreDumps(dir, 250), IsNil)
+	dumps, err := listCoreDumps(dir)
+	c.Assert(err, IsNil)
+	c.Assert(dumps, HasLen, 2)
+	c.Assert(dumps[0].Name, Equals, "core.app.2.200")
+	c.Assert(dumps[1].Name, Equals, "core.app.3.300")
+	c.Assert(dumps[1].Size, Equals, int64(100))
+}
+
+func (S) TestOpenCoreDump(c *C) {
+	dir, err := ioutil.TempDir("", "coredumps")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	m := NewCoreDumpManager(dir, nil, log15.New())
+	c.Assert(os.MkdirAll(filepath.Join(dir, "job1"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "job1", "core.app.1.100"), []byte("core"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "secret"), []byte("secret"), 0644), IsNil)
+
+	f, err := m.Open("job1", "core.app.1.100")
+	c.Assert(err, IsNil)
+	f.Close()
+
+	for _, name := range []string{"../secret", "core.app.2.200", "core/../../secret"} {
+		_, err := m.Open("job1", name)
+		c.Assert(err, Equals, ErrCoreDumpNotFound)
+	}
+
+	dumps, err := m.List("job2")
+	c.Assert(err, IsNil)
+	c.Assert(dumps, HasLen, 0)
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"sync"
 	"sync/atomic"
 
@@ -16,7 +17,7 @@ import (
 
 var discoverdLogger = log15.New("component", "discoverd-manager")
 
-func NewDiscoverdManager(backend Backend, sinkManager *logmux.SinkManager, hostID, publishAddr string, tags map[string]string) *DiscoverdManager {
+func NewDiscoverdManager(backend Backend, sinkManager *logmux.SinkManager, hostID, publishAddr string, tags map[string]string, profiles []host.JobProfile) *DiscoverdManager {
 	d := &DiscoverdManager{
 		backend:     backend,
 		sinkManager: sinkManager,
@@ -28,6 +29,11 @@ func NewDiscoverdManager(backend Backend, sinkManager *logmux.SinkManager, hostI
 	for k, v := range tags {
 		d.inst.Meta[host.TagPrefix+k] = v
 	}
+	names := make([]string, len(profiles))
+	for i, p := range profiles {
+		names[i] = string(p)
+	}
+	d.inst.Meta[host.ProfilesMetaKey] = strings.Join(names, ",")
 	d.local.Store(false)
 	return d
 }
@@ -188,3 +194,35 @@ func (d *DiscoverdManager) UpdateTags(tags map[string]string) error {
 	}
 	return d.hb.SetMeta(d.inst.Meta)
 }
+
+// SetDraining sets or removes host.DrainMetaKey in the host's discoverd
+// metadata.
+func (d *DiscoverdManager) SetDraining(draining bool) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if draining {
+		d.inst.Meta[host.DrainMetaKey] = "true"
+	} else {
+		delete(d.inst.Meta, host.DrainMetaKey)
+	}
+	if d.hb == nil {
+		return nil
+	}
+	return d.hb.SetMeta(d.inst.Meta)
+}
+
+// SetLayers sets host.LayersMetaKey in the host's discoverd metadata to the
+// given layer IDs, removing it if there are none.
+func (d *DiscoverdManager) SetLayers(ids []string) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if len(ids) > 0 {
+		d.inst.Meta[host.LayersMetaKey] = strings.Join(ids, ",")
+	} else {
+		delete(d.inst.Meta, host.LayersMetaKey)
+	}
+	if d.hb == nil {
+		return nil
+	}
+	return d.hb.SetMeta(d.inst.Meta)
+}
@@ -6,7 +6,6 @@ import (
 
 	discoverd "github.com/flynn/flynn/discoverd/client"
 	"github.com/flynn/flynn/pkg/shutdown"
-	"github.com/flynn/flynn/pkg/stream"
 	"github.com/inconshreveable/log15"
 )
 
@@ -35,47 +34,17 @@ func (d *discoverdWrapper) Register() (bool, error) {
 		log.Error("error registering with service discovery", "err", err)
 		return false, err
 	}
-	shutdown.BeforeExit(func() { hb.Close() })
+	shutdown.AddHook(shutdown.Hook{Name: "deregister-cluster-monitor", Func: func() { hb.Close() }})
 
 	selfAddr := hb.Addr()
 	log = log.New("self.addr", selfAddr)
 
-	service := discoverd.NewService(serviceName)
-	var leaders chan *discoverd.Instance
-	var stream stream.Stream
-	connect := func() (err error) {
-		log.Info("connecting service leader stream")
-		leaders = make(chan *discoverd.Instance)
-		stream, err = service.Leaders(leaders)
-		if err != nil {
-			log.Error("error connecting service leader stream", "err", err)
-		}
-		return
-	}
-	if err := connect(); err != nil {
-		return false, err
-	}
-
+	leaders := make(chan *discoverd.Instance)
+	discoverd.FollowLeader(discoverd.NewService(serviceName), leaders)
 	go func() {
-	outer:
-		for {
-			for leader := range leaders {
-				if leader == nil {
-					// a nil leader indicates there are no instances for
-					// the service, ignore and wait for an actual leader
-					log.Warn("received nil leader event")
-					continue
-				}
-				log.Info("received leader event", "leader.addr", leader.Addr)
-				d.leader <- leader.Addr == selfAddr
-			}
-			log.Warn("service leader stream disconnected", "err", stream.Err())
-			for {
-				if err := connect(); err == nil {
-					continue outer
-				}
-				time.Sleep(100 * time.Millisecond)
-			}
+		for leader := range leaders {
+			log.Info("received leader event", "leader.addr", leader.Addr)
+			d.leader <- leader.Addr == selfAddr
 		}
 	}()
 
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	host "github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/host/volume"
+	"github.com/inconshreveable/log15"
+)
+
+const (
+	// diskPressurePath is the directory whose filesystem is watched for
+	// disk pressure, which holds the host's volumes and image layers
+	diskPressurePath = "/var/lib/flynn"
+
+	// diskPressureInterval is how often disk usage is checked
+	diskPressureInterval = 10 * time.Second
+
+	// diskPressureRecoveryMargin is how many percentage points below the
+	// reject threshold disk usage must drop before new jobs are accepted
+	// again, so that the host does not flap around the threshold
+	diskPressureRecoveryMargin = 2
+)
+
+// diskPressureThresholds configures the DiskPressureWatcher, with a zero
+// value disabling the corresponding action.
+type diskPressureThresholds struct {
+	// RejectPercent is the disk usage percentage above which new jobs are
+	// rejected and unused image layers are removed
+	RejectPercent float64
+
+	// EvictPercent is the disk usage percentage above which the lowest
+	// priority jobs are stopped, one per check, until usage drops below
+	// it
+	EvictPercent float64
+}
+
+// DiskPressureWatcher protects the host from running out of disk space,
+// which crashes the daemon and corrupts job state. When disk usage crosses
+// the reject threshold it stops accepting new jobs and removes image layers
+// which no job is using, and when it crosses the evict threshold it stops
+// the lowest priority jobs so the scheduler moves them to other hosts.
+type DiskPressureWatcher struct {
+	thresholds diskPressureThresholds
+	webhooks   *WebhookDispatcher
+	done       chan struct{}
+	log        log15.Logger
+
+	// usage returns the used and total bytes of the watched filesystem
+	usage func() (used, total uint64, err error)
+
+	// jobs returns the active jobs on the host
+	jobs func() map[string]*host.ActiveJob
+
+	// volumes returns the volumes on the host, and destroyVolume removes
+	// one of them
+	volumes       func() map[string]volume.Volume
+	destroyVolume func(id string) error
+
+	// stopJob stops a job which is being evicted
+	stopJob func(id string) error
+
+	mtx         sync.Mutex
+	usedPercent float64
+	rejecting   bool
+	since       time.Time
+	evicted     []string
+}
+
+// NewDiskPressureWatcher creates a new watcher. Call Run() to start
+// watching.
+func NewDiskPressureWatcher(h *Host, webhooks *WebhookDispatcher, thresholds diskPressureThresholds, log log15.Logger) *DiskPressureWatcher {
+	return &DiskPressureWatcher{
+		thresholds:    thresholds,
+		webhooks:      webhooks,
+		done:          make(chan struct{}),
+		log:           log.New("component", "disk-pressure"),
+		usage:         func() (uint64, uint64, error) { return diskUsage(diskPressurePath) },
+		jobs:          h.state.GetActive,
+		volumes:       h.vman.Volumes,
+		destroyVolume: h.vman.DestroyVolume,
+		stopJob:       h.StopJob,
+	}
+}
+
+// diskUsage returns the used and total bytes of the filesystem containing
+// path.
+func diskUsage(path string) (used, total uint64, err error) {
+	var statfs syscall.Statfs_t
+	if err := syscall.Statfs(path, &statfs); err != nil {
+		return 0, 0, err
+	}
+	total = statfs.Blocks * uint64(statfs.Bsize)
+	return total - statfs.Bfree*uint64(statfs.Bsize), total, nil
+}
+
+// Run periodically checks disk usage. Should be called in a goroutine.
+func (w *DiskPressureWatcher) Run() {
+	ticker := time.NewTicker(diskPressureInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := w.Check(time.Now()); err != nil {
+				w.log.Error("error checking disk usage", "err", err)
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// Shutdown stops watching.
+func (w *DiskPressureWatcher) Shutdown() {
+	close(w.done)
+}
+
+// Rejecting returns whether new jobs are being rejected due to disk pressure.
+func (w *DiskPressureWatcher) Rejecting() bool {
+	if w == nil {
+		return false
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.rejecting
+}
+
+// Status returns the disk pressure status reported in the host status.
+func (w *DiskPressureWatcher) Status() *host.DiskPressureStatus {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	status := &host.DiskPressureStatus{
+		UsedPercent:   w.usedPercent,
+		RejectPercent: w.thresholds.RejectPercent,
+		EvictPercent:  w.thresholds.EvictPercent,
+		Rejecting:     w.rejecting,
+		EvictedJobs:   append([]string(nil), w.evicted...),
+	}
+	if w.rejecting {
+		since := w.since
+		status.Since = &since
+	}
+	return status
+}
+
+// Check compares disk usage against the thresholds, rejecting new jobs and
+// removing unused layers if it has crossed the reject threshold, evicting a
+// job if it is above the evict threshold, and accepting jobs again once it
+// has dropped back below the reject threshold.
+func (w *DiskPressureWatcher) Check(now time.Time) error {
+	used, total, err := w.usage()
+	if err != nil {
+		return err
+	}
+	if total == 0 {
+		return nil
+	}
+	percent := float64(used) / float64(total) * 100
+	metadata := func() map[string]string {
+		return map[string]string{
+			"resource":          "disk_reject",
+			"path":              diskPressurePath,
+			"disk_used_percent": formatFloat(percent),
+			"disk_used_bytes":   strconv.FormatUint(used, 10),
+			"disk_total_bytes":  strconv.FormatUint(total, 10),
+			"threshold_percent": formatFloat(w.thresholds.RejectPercent),
+		}
+	}
+
+	w.mtx.Lock()
+	w.usedPercent = percent
+	rejecting := w.rejecting
+	w.mtx.Unlock()
+
+	if t := w.thresholds.RejectPercent; t > 0 {
+		switch {
+		case !rejecting && percent > t:
+			w.setRejecting(true, now)
+			w.log.Warn("disk usage critical, rejecting new jobs", "used_percent", percent)
+			removed := w.removeUnusedLayers()
+			meta := metadata()
+			meta["layers_removed"] = strconv.Itoa(removed)
+			w.send(host.CodeDiskCritical, fmt.Sprintf("Disk usage above %s%%, rejecting new jobs", formatFloat(t)), host.SeverityCritical, "", nil, meta)
+		case rejecting && percent < t-diskPressureRecoveryMargin:
+			w.setRejecting(false, now)
+			w.log.Info("disk usage recovered, accepting new jobs", "used_percent", percent)
+			w.send(host.CodeResourceRecovered, "Host disk pressure relieved, accepting new jobs", host.SeverityInfo, "", nil, metadata())
+		}
+	}
+
+	if t := w.thresholds.EvictPercent; t > 0 && percent <= t {
+		w.mtx.Lock()
+		if !w.rejecting {
+			w.evicted = nil
+		}
+		w.mtx.Unlock()
+	} else if t > 0 {
+		job := w.evictionCandidate()
+		if job == nil {
+			w.log.Warn("disk usage above evict threshold but no jobs can be evicted", "used_percent", percent)
+			return nil
+		}
+		log := w.log.New("job.id", job.Job.ID)
+		log.Warn("evicting job due to disk pressure", "used_percent", percent)
+		if err := w.stopJob(job.Job.ID); err != nil {
+			log.Error("error evicting job", "err", err)
+			return nil
+		}
+		w.mtx.Lock()
+		w.evicted = append(w.evicted, job.Job.ID)
+		w.mtx.Unlock()
+		meta := metadata()
+		meta["threshold_percent"] = formatFloat(t)
+		w.send(host.CodeJobEvicted, fmt.Sprintf("Job evicted as disk usage is above %s%%", formatFloat(t)), host.SeverityCritical, job.Job.ID, job, meta)
+	}
+	return nil
+}
+
+func (w *DiskPressureWatcher) setRejecting(rejecting bool, now time.Time) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.rejecting = rejecting
+	w.since = now
+	if !rejecting {
+		w.evicted = nil
+	}
+}
+
+func (w *DiskPressureWatcher) send(code, description, severity, jobID string, job *host.ActiveJob, metadata map[string]string) {
+	if w.webhooks != nil {
+		w.webhooks.Send(code, description, severity, jobID, job, metadata)
+	}
+}
+
+// removeUnusedLayers destroys the squashfs layer volumes which are not
+// mounted by any active job, other than system images which are needed to
+// recover the cluster, returning how many were removed.
+func (w *DiskPressureWatcher) removeUnusedLayers() int {
+	inUse := make(map[string]struct{})
+	for _, job := range w.jobs() {
+		for _, m := range job.Job.Mountspecs {
+			inUse[m.ID] = struct{}{}
+		}
+	}
+	removed := 0
+	for id, vol := range w.volumes() {
+		info := vol.Info()
+		if info.Type != volume.VolumeTypeSquashfs || info.Meta["flynn.system-image"] == "true" {
+			continue
+		}
+		if _, ok := inUse[id]; ok {
+			continue
+		}
+		if err := w.destroyVolume(id); err != nil {
+			w.log.Error("error removing unused layer", "volume.id", id, "err", err)
+			continue
+		}
+		removed++
+	}
+	w.log.Info("removed unused layers", "count", removed)
+	return removed
+}
+
+// evictionCandidate returns the lowest priority job which can be evicted,
+// or nil if there are none. System jobs and jobs with data volumes are never
+// evicted, background jobs such as builds are evicted before user jobs, and
+// the most recently started jobs are evicted first.
+func (w *DiskPressureWatcher) evictionCandidate() *host.ActiveJob {
+	w.mtx.Lock()
+	evicted := make(map[string]struct{}, len(w.evicted))
+	for _, id := range w.evicted {
+		evicted[id] = struct{}{}
+	}
+	w.mtx.Unlock()
+
+	var candidates []*host.ActiveJob
+	for _, job := range w.jobs() {
+		if job.Job.Partition == "system" || job.Job.Metadata["flynn-system-app"] == "true" || len(job.Job.Config.Volumes) > 0 {
+			continue
+		}
+		// jobs which have been evicted may take a while to stop
+		if _, ok := evicted[job.Job.ID]; ok || job.ForceStop {
+			continue
+		}
+		candidates = append(candidates, job)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if bg := a.Job.Partition == "background"; bg != (b.Job.Partition == "background") {
+			return bg
+		}
+		return a.StartedAt.After(b.StartedAt)
+	})
+	return candidates[0]
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"time"
+
+	host "github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/host/volume"
+	. "github.com/flynn/go-check"
+	"github.com/inconshreveable/log15"
+)
+
+type fakeLayer struct {
+	volume.Volume
+	info *volume.Info
+}
+
+func (v *fakeLayer) Info() *volume.Info { return v.info }
+
+func (S) TestDiskPressureWatcher(c *C) {
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	d := NewWebhookDispatcher("abc123", nil, logger)
+
+	now := time.Now()
+	jobs := map[string]*host.ActiveJob{
+		"system":   {Job: &host.Job{ID: "system", Partition: "system"}, StartedAt: now.Add(-time.Minute)},
+		"database": {Job: &host.Job{ID: "database", Partition: "user", Config: host.ContainerConfig{Volumes: []host.VolumeBinding{{VolumeID: "data"}}}}, StartedAt: now},
+		"web-old":  {Job: &host.Job{ID: "web-old", Partition: "user", Mountspecs: []*host.Mountspec{{ID: "layer-used"}}}, StartedAt: now.Add(-time.Hour)},
+		"web-new":  {Job: &host.Job{ID: "web-new", Partition: "user"}, StartedAt: now.Add(-time.Minute)},
+		"build":    {Job: &host.Job{ID: "build", Partition: "background"}, StartedAt: now.Add(-time.Hour)},
+	}
+	layers := map[string]volume.Volume{
+		"layer-used":   &fakeLayer{info: &volume.Info{ID: "layer-used", Type: volume.VolumeTypeSquashfs}},
+		"layer-unused": &fakeLayer{info: &volume.Info{ID: "layer-unused", Type: volume.VolumeTypeSquashfs}},
+		"layer-system": &fakeLayer{info: &volume.Info{ID: "layer-system", Type: volume.VolumeTypeSquashfs, Meta: map[string]string{"flynn.system-image": "true"}}},
+		"data":         &fakeLayer{info: &volume.Info{ID: "data", Type: volume.VolumeTypeExt2}},
+	}
+	var used uint64
+	var destroyed, stopped []string
+	w := &DiskPressureWatcher{
+		thresholds:    diskPressureThresholds{RejectPercent: 90, EvictPercent: 95},
+		webhooks:      d,
+		log:           logger,
+		usage:         func() (uint64, uint64, error) { return used, 100, nil },
+		jobs:          func() map[string]*host.ActiveJob { return jobs },
+		volumes:       func() map[string]volume.Volume { return layers },
+		destroyVolume: func(id string) error { destroyed = append(destroyed, id); return nil },
+		stopJob:       func(id string) error { stopped = append(stopped, id); return nil },
+	}
+	events := func() []*host.WebhookEvent {
+		var events []*host.WebhookEvent
+		for {
+			select {
+			case e := <-d.events:
+				events = append(events, e)
+			default:
+				return events
+			}
+		}
+	}
+
+	used = 50
+	c.Assert(w.Check(now), IsNil)
+	c.Assert(w.Rejecting(), Equals, false)
+	c.Assert(events(), HasLen, 0)
+
+	// crossing the reject threshold rejects jobs and removes unused layers
+	used = 92
+	c.Assert(w.Check(now), IsNil)
+	c.Assert(w.Rejecting(), Equals, true)
+	c.Assert(destroyed, DeepEquals, []string{"layer-unused"})
+	c.Assert(stopped, HasLen, 0)
+	e := events()
+	c.Assert(e, HasLen, 1)
+	c.Assert(e[0].Code, Equals, host.CodeDiskCritical)
+	c.Assert(e[0].Severity, Equals, host.SeverityCritical)
+	c.Assert(e[0].Metadata["layers_removed"], Equals, "1")
+
+	// crossing the evict threshold evicts background jobs, then the most
+	// recently started user jobs, one per check
+	used = 97
+	for _, id := range []string{"build", "web-new", "web-old"} {
+		c.Assert(w.Check(now), IsNil)
+		c.Assert(stopped[len(stopped)-1], Equals, id)
+		e := events()
+		c.Assert(e, HasLen, 1)
+		c.Assert(e[0].Code, Equals, host.CodeJobEvicted)
+		c.Assert(e[0].JobID, Equals, id)
+	}
+	c.Assert(w.Check(now), IsNil)
+	c.Assert(stopped, HasLen, 3)
+	c.Assert(w.Status().EvictedJobs, DeepEquals, []string{"build", "web-new", "web-old"})
+
+	// jobs are accepted again once usage is below the reject threshold by
+	// the recovery margin
+	used = 89
+	c.Assert(w.Check(now), IsNil)
+	c.Assert(w.Rejecting(), Equals, true)
+	c.Assert(events(), HasLen, 0)
+	used = 80
+	c.Assert(w.Check(now), IsNil)
+	c.Assert(w.Rejecting(), Equals, false)
+	e = events()
+	c.Assert(e, HasLen, 1)
+	c.Assert(e[0].Code, Equals, host.CodeResourceRecovered)
+	c.Assert(w.Status().EvictedJobs, HasLen, 0)
+}
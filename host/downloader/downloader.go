@@ -2,15 +2,22 @@ package downloader
 
 import (
 	"compress/gzip"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	ct "github.com/flynn/flynn/controller/types"
+	discoverd "github.com/flynn/flynn/discoverd/client"
 	"github.com/flynn/flynn/host/volume"
 	volumemanager "github.com/flynn/flynn/host/volume/manager"
 	"github.com/flynn/flynn/pkg/ghrelease"
@@ -19,32 +26,97 @@ import (
 )
 
 const (
-	maxDownloadRetries  = 5
-	initialRetryDelay   = 2 * time.Second
-	maxRetryDelay       = 30 * time.Second
-	retryBackoffFactor  = 2
+	maxDownloadRetries = 5
+	initialRetryDelay  = 2 * time.Second
+	maxRetryDelay      = 30 * time.Second
+	retryBackoffFactor = 2
+
+	// DefaultLayerConcurrency is the number of image layers downloaded in
+	// parallel unless set with SetLayerConcurrency
+	DefaultLayerConcurrency = 4
 )
 
+// layerCacheDir is the directory downloaded layers are stored in
+var layerCacheDir = "/var/lib/flynn/layer-cache"
+
+// layerClient downloads layers, with no overall timeout as layers may be
+// large and partial downloads are resumed rather than discarded
+var layerClient = &http.Client{Transport: newLayerTransport()}
+
+func newLayerTransport() http.RoundTripper {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = ghrelease.DefaultTimeout
+	return t
+}
+
+// ServiceURLScheme is the scheme of base URLs which name a discoverd service
+// rather than a fixed host (e.g. discoverd+http://flynn-update-files). Files
+// are fetched over plain HTTP from each registered instance in turn until one
+// succeeds, so a tarball update survives the loss of any single file server.
+const ServiceURLScheme = "discoverd+http"
+
+// VersionMetaKey is the instance metadata key file servers use to advertise
+// the release version they serve. Instances serving a different version are
+// ignored when resolving a service base URL.
+const VersionMetaKey = "FLYNN_VERSION"
+
+// ServiceBaseURL returns a base URL which resolves to the instances of the
+// given discoverd service.
+func ServiceBaseURL(service string) string {
+	return ServiceURLScheme + "://" + service
+}
+
 // binaries maps the asset name in the release to the local binary name
-// The release uses OS/arch suffixed names for host binaries
+// The release uses OS/arch suffixed names for host binaries, and the binaries
+// for the architecture of this host are downloaded
 var binaries = map[string]string{
-	"flynn-host-linux-amd64": "flynn-host",
-	"flynn-linux-amd64":      "flynn-linux-amd64",
-	"flynn-init-linux-amd64": "flynn-init",
+	ghrelease.HostAssetName("flynn-host"): "flynn-host",
+	ghrelease.HostAssetName("flynn"):      ghrelease.HostAssetName("flynn"),
+	ghrelease.HostAssetName("flynn-init"): "flynn-init",
 }
 
 var config = []string{
 	"bootstrap-manifest.json",
 }
 
+// releasePublicKey returns the release signing key, it is a variable so it
+// can be replaced in tests.
+var releasePublicKey = ghrelease.PublicKey
+
+// serviceInstances returns the instances of a discoverd service, it is a
+// variable so it can be replaced in tests.
+var serviceInstances = func(service string) ([]*discoverd.Instance, error) {
+	return discoverd.GetInstances(service, 10*time.Second)
+}
+
 // Downloader downloads versioned files from GitHub releases or a custom base URL
 type Downloader struct {
-	client  *ghrelease.Client
-	repo    string
-	baseURL string // if set, use this instead of GitHub release URLs
-	vman    *volumemanager.Manager
-	version string
-	log     log15.Logger
+	client   *ghrelease.Client
+	repo     string
+	baseURL  string // if set, use this instead of GitHub release URLs
+	registry string // if set, layers are first fetched from this registry
+	vman     *volumemanager.Manager
+	version  string
+	log      log15.Logger
+
+	// layerConcurrency is the number of layers downloaded in parallel
+	layerConcurrency int
+
+	// layerPeers, if set, returns the base URLs of peers which serve a
+	// layer from their layer cache
+	layerPeers func(layerID string) []string
+
+	// auth, if set, authenticates requests to the base URL
+	auth *ghrelease.Auth
+
+	// fileClient and layerHTTPClient, if set, replace http.DefaultClient
+	// and layerClient to trust the CAs configured with SetRootCAs
+	fileClient      *http.Client
+	layerHTTPClient *http.Client
+
+	checksumsOnce sync.Once
+	checksums     map[string]string
+	checksumsErr  error
 }
 
 // New creates a new Downloader that uses GitHub releases
@@ -70,6 +142,81 @@ func NewWithBaseURL(baseURL string, vman *volumemanager.Manager, version string,
 	}
 }
 
+// SetRegistry configures the downloader to fetch image layers from the
+// registry system app at the given URL, falling back to the release assets
+// if a layer is not available there.
+func (d *Downloader) SetRegistry(url string) {
+	d.registry = strings.TrimSuffix(url, "/")
+}
+
+// SetLayerConcurrency sets the number of image layers downloaded in
+// parallel, with values less than one meaning DefaultLayerConcurrency.
+func (d *Downloader) SetLayerConcurrency(n int) {
+	d.layerConcurrency = n
+}
+
+// SetLayerPeers configures the downloader to first try fetching layers from
+// the peers returned by fn, which returns the base URLs of the peers serving
+// the given layer at /host/layers/:id.
+func (d *Downloader) SetLayerPeers(fn func(layerID string) []string) {
+	d.layerPeers = fn
+}
+
+// SetAuth configures the downloader to authenticate requests to its base URL
+// with auth, for downloading from a private mirror. Without a base URL,
+// requests to GitHub are authenticated instead.
+func (d *Downloader) SetAuth(auth *ghrelease.Auth) {
+	d.auth = auth
+	if d.client != nil {
+		d.client.SetAuth(auth)
+	}
+}
+
+// SetRootCAs configures the downloader to trust the given CAs when
+// downloading over HTTPS, for mirrors with certificates signed by an
+// internal CA.
+func (d *Downloader) SetRootCAs(rootCAs *x509.CertPool) {
+	d.fileClient = &http.Client{Transport: ghrelease.NewTransport(rootCAs)}
+	t := ghrelease.NewTransport(rootCAs)
+	t.ResponseHeaderTimeout = ghrelease.DefaultTimeout
+	d.layerHTTPClient = &http.Client{Transport: t}
+	if d.client != nil {
+		d.client.SetRootCAs(rootCAs)
+	}
+}
+
+// newRequest returns a GET request for url, authenticated if it is for a
+// file under the base URL.
+func (d *Downloader) newRequest(url string) (*http.Request, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if d.auth != nil && d.baseURL != "" && strings.HasPrefix(url, d.baseURL+"/") {
+		d.auth.Apply(req)
+	}
+	return req, nil
+}
+
+// LayerPath returns the path of a layer in the layer cache.
+func LayerPath(id string) string {
+	return filepath.Join(layerCacheDir, id+".squashfs")
+}
+
+// CachedLayers returns the IDs of the layers in the layer cache.
+func CachedLayers() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(layerCacheDir, "*.squashfs"))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(paths))
+	for i, path := range paths {
+		ids[i] = strings.TrimSuffix(filepath.Base(path), ".squashfs")
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
 // assetURL returns the download URL for a given filename.
 // If a base URL is configured, it uses that; otherwise it constructs a GitHub release URL.
 func (d *Downloader) assetURL(filename string) string {
@@ -97,8 +244,8 @@ func (d *Downloader) DownloadBinaries(dir string) (map[string]string, error) {
 		}
 		paths[localName] = path
 	}
-	// symlink flynn to flynn-linux-amd64
-	if err := symlink("flynn-linux-amd64."+d.version, filepath.Join(dir, "flynn")); err != nil {
+	// symlink flynn to flynn-linux-<arch>
+	if err := symlink(ghrelease.HostAssetName("flynn")+"."+d.version, filepath.Join(dir, "flynn")); err != nil {
 		return nil, err
 	}
 	return paths, nil
@@ -132,10 +279,13 @@ func (d *Downloader) downloadWithRetry(assetURL, destPath string) error {
 		if d.client != nil {
 			err = d.client.DownloadFile(assetURL, destPath)
 		} else {
-			err = downloadFileHTTP(assetURL, destPath)
+			err = d.downloadHTTP(assetURL, destPath)
 		}
 		if err == nil {
 			return nil
+		} else if os.IsNotExist(err) {
+			// a local file which does not exist will not appear
+			return err
 		}
 		lastErr = err
 		if attempt < maxDownloadRetries {
@@ -150,11 +300,85 @@ func (d *Downloader) downloadWithRetry(assetURL, destPath string) error {
 	return fmt.Errorf("download failed after %d attempts: %s", maxDownloadRetries, lastErr)
 }
 
+// FetchFile downloads a single named file from the configured source to
+// destPath without decompressing it.
+func (d *Downloader) FetchFile(name, destPath string) error {
+	return d.downloadWithRetry(d.assetURL(name), destPath)
+}
+
+// downloadHTTP downloads a file over plain HTTP. If the URL uses
+// ServiceURLScheme, the named discoverd service is resolved and each instance
+// serving d.version is tried in turn, and file:// URLs are copied from the
+// local filesystem.
+func (d *Downloader) downloadHTTP(rawURL, destPath string) error {
+	if path, ok := localFilePath(rawURL); ok {
+		return copyLocalFile(path, destPath)
+	}
+	return d.eachServiceURL(rawURL, func(u string) error {
+		return d.downloadFileHTTP(u, destPath)
+	})
+}
+
+// eachServiceURL calls fn with rawURL, or if it uses ServiceURLScheme, with
+// the URL of each instance of the named service serving d.version in turn
+// until fn succeeds.
+func (d *Downloader) eachServiceURL(rawURL string, fn func(url string) error) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != ServiceURLScheme {
+		return fn(rawURL)
+	}
+	addrs, err := d.serviceAddrs(u.Host)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, addr := range addrs {
+		target := *u
+		target.Scheme = "http"
+		target.Host = addr
+		if err := fn(target.String()); err != nil {
+			d.log.Warn("download from file server failed, trying next instance", "service", u.Host, "addr", addr, "err", err)
+			lastErr = err
+			continue
+		}
+		return nil
+	}
+	return lastErr
+}
+
+// serviceAddrs returns the addresses of the instances of the given discoverd
+// service which serve d.version.
+func (d *Downloader) serviceAddrs(service string) ([]string, error) {
+	instances, err := serviceInstances(service)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving file server service %q: %s", service, err)
+	}
+	addrs := make([]string, 0, len(instances))
+	for _, inst := range instances {
+		if v, ok := inst.Meta[VersionMetaKey]; ok && d.version != "" && v != d.version {
+			continue
+		}
+		addrs = append(addrs, inst.Addr)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no instances of file server service %q are serving version %s", service, d.version)
+	}
+	return addrs, nil
+}
+
 // downloadFileHTTP downloads a file from a URL to the specified path using
 // a plain HTTP client. Used when no ghrelease.Client is available (e.g.,
 // when downloading from a local tarball HTTP server).
-func downloadFileHTTP(url, destPath string) error {
-	resp, err := http.Get(url)
+func (d *Downloader) downloadFileHTTP(url, destPath string) error {
+	req, err := d.newRequest(url)
+	if err != nil {
+		return err
+	}
+	client := d.fileClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to download: %w", err)
 	}
@@ -188,9 +412,120 @@ func downloadFileHTTP(url, destPath string) error {
 	return os.Rename(tmpPath, destPath)
 }
 
+// localFilePath returns the path of a file:// URL.
+func localFilePath(rawURL string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "file" {
+		return "", false
+	}
+	return u.Path, true
+}
+
+// copyLocalFile copies a local file to destPath, writing to a temporary file
+// which is renamed once complete.
+func copyLocalFile(path, destPath string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(destPath), ".download-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer func() {
+		tmp.Close()
+		os.Remove(tmpPath)
+	}()
+	if _, err := io.Copy(tmp, src); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	return os.Rename(tmpPath, destPath)
+}
+
+// downloadResumable downloads a file from a URL to the specified path via a
+// .partial file alongside it. The partial file is kept if the download fails
+// so that the next attempt can resume it using a Range request, falling back
+// to downloading the whole file if the server does not support ranges.
+func (d *Downloader) downloadResumable(url, destPath string) error {
+	if path, ok := localFilePath(url); ok {
+		return copyLocalFile(path, destPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	partPath := destPath + ".partial"
+	f, err := os.OpenFile(partPath, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open partial file: %w", err)
+	}
+	defer f.Close()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to seek partial file: %w", err)
+	}
+
+	req, err := d.newRequest(url)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	client := d.layerHTTPClient
+	if client == nil {
+		client = layerClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		var start int64
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-", &start); err != nil || start != offset {
+			os.Remove(partPath)
+			return fmt.Errorf("unexpected Content-Range %q resuming download", resp.Header.Get("Content-Range"))
+		}
+	case resp.StatusCode == http.StatusOK:
+		// the server sent the whole file, so start again
+		if err := f.Truncate(0); err != nil {
+			return fmt.Errorf("failed to truncate partial file: %w", err)
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek partial file: %w", err)
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// the partial file is already complete, which is checked
+		// when the file is verified
+	default:
+		return fmt.Errorf("download failed with status %d", resp.StatusCode)
+	}
+
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		if _, err := io.Copy(f, resp.Body); err != nil {
+			return fmt.Errorf("failed to write file: %w", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close partial file: %w", err)
+	}
+	return os.Rename(partPath, destPath)
+}
+
 // downloadGzippedBinary downloads a gzipped binary from GitHub releases, decompresses it,
 // and creates a versioned file with a symlink. The assetName is the name in the release
-// (e.g., flynn-host-linux-amd64) and localName is the local binary name (e.g., flynn-host).
+// (e.g., flynn-host-linux-arm64) and localName is the local binary name (e.g., flynn-host).
 func (d *Downloader) downloadGzippedBinary(assetName, localName, dir string) (string, error) {
 	// Construct the asset URL
 	gzName := assetName + ".gz"
@@ -202,6 +537,9 @@ func (d *Downloader) downloadGzippedBinary(assetName, localName, dir string) (st
 		return "", fmt.Errorf("error downloading %s: %s", assetName, err)
 	}
 	defer os.Remove(tmpPath)
+	if err := d.verifyAsset(gzName, tmpPath); err != nil {
+		return "", err
+	}
 
 	// Open and decompress
 	gzFile, err := os.Open(tmpPath)
@@ -262,6 +600,9 @@ func (d *Downloader) downloadGzippedFile(name, dir string) (string, error) {
 		return "", fmt.Errorf("error downloading %s: %s", name, err)
 	}
 	defer os.Remove(tmpPath)
+	if err := d.verifyAsset(assetName, tmpPath); err != nil {
+		return "", err
+	}
 
 	// Open and decompress
 	gzFile, err := os.Open(tmpPath)
@@ -294,6 +635,74 @@ func (d *Downloader) downloadGzippedFile(name, dir string) (string, error) {
 	return destPath, nil
 }
 
+// verifyAsset checks a downloaded release asset against the checksums of
+// the release, so that nothing is installed from a release whose checksums
+// are not signed by the release signing key.
+func (d *Downloader) verifyAsset(name, path string) error {
+	d.checksumsOnce.Do(func() {
+		d.checksums, d.checksumsErr = d.downloadChecksums()
+	})
+	if d.checksumsErr != nil {
+		return d.checksumsErr
+	}
+	if d.checksums == nil {
+		return nil
+	}
+	expected, ok := d.checksums[name]
+	if !ok {
+		return fmt.Errorf("no checksum found for %s", name)
+	}
+	if err := ghrelease.VerifyChecksum(path, expected); err != nil {
+		return fmt.Errorf("error verifying %s: %s", name, err)
+	}
+	return nil
+}
+
+// downloadChecksums downloads and verifies the checksums of the release.
+//
+// If a release signing key is configured then the checksums and their
+// signature are required wherever they are downloaded from. Otherwise,
+// tarballs served from a base URL may have been exported from a cluster
+// rather than built as a release, so verification is skipped if they have
+// no checksums.
+func (d *Downloader) downloadChecksums() (map[string]string, error) {
+	_, keyErr := releasePublicKey()
+	signed := keyErr != ghrelease.ErrNoPublicKey
+
+	dir, err := os.MkdirTemp("", "flynn-checksums-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	checksumsPath := filepath.Join(dir, ghrelease.ChecksumsName)
+	if err := d.downloadWithRetry(d.assetURL(ghrelease.ChecksumsName), checksumsPath); err != nil {
+		if d.baseURL != "" && !signed {
+			d.log.Warn("no checksums available, skipping verification", "err", err)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error downloading %s: %s", ghrelease.ChecksumsName, err)
+	}
+	checksums, err := os.ReadFile(checksumsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// only fetch the signature if there is a key to check it with
+	if !signed {
+		return ghrelease.VerifyChecksums(checksums, nil, d.log)
+	}
+	sigPath := filepath.Join(dir, ghrelease.ChecksumsSignatureName)
+	if err := d.downloadWithRetry(d.assetURL(ghrelease.ChecksumsSignatureName), sigPath); err != nil {
+		return nil, fmt.Errorf("error downloading %s: %s", ghrelease.ChecksumsSignatureName, err)
+	}
+	sig, err := os.ReadFile(sigPath)
+	if err != nil {
+		return nil, err
+	}
+	return ghrelease.VerifyChecksums(checksums, sig, d.log)
+}
+
 // symlink creates a symlink, removing any existing file/symlink first
 func symlink(target, link string) error {
 	os.Remove(link)
@@ -310,6 +719,9 @@ func (d *Downloader) DownloadImagesManifest(configDir string) (map[string]*ct.Ar
 		return nil, fmt.Errorf("error downloading images manifest: %s", err)
 	}
 	defer os.Remove(manifestPath)
+	if err := d.verifyAsset("images.json.gz", manifestPath); err != nil {
+		return nil, err
+	}
 
 	// Decompress manifest
 	gzFile, err := os.Open(manifestPath)
@@ -336,40 +748,28 @@ func (d *Downloader) DownloadImagesManifest(configDir string) (map[string]*ct.Ar
 // DownloadImages downloads container images from GitHub releases.
 // It downloads the images manifest and then downloads each layer.
 func (d *Downloader) DownloadImages(configDir string, ch chan *ct.ImagePullInfo) error {
-	defer close(ch)
-
-	// Download images manifest
-	manifestURL := d.assetURL("images.json.gz")
-	manifestPath := filepath.Join(configDir, "images.json.gz.tmp")
-	if err := d.downloadWithRetry(manifestURL, manifestPath); err != nil {
-		return fmt.Errorf("error downloading images manifest: %s", err)
-	}
-	defer os.Remove(manifestPath)
-
-	// Decompress manifest
-	gzFile, err := os.Open(manifestPath)
+	images, err := d.DownloadImagesManifest(configDir)
 	if err != nil {
+		close(ch)
 		return err
 	}
-	defer gzFile.Close()
-
-	gz, err := gzip.NewReader(gzFile)
-	if err != nil {
-		return fmt.Errorf("error creating gzip reader for images manifest: %s", err)
-	}
-	defer gz.Close()
+	return d.DownloadManifestImages(images, ch)
+}
 
-	// Parse manifest
-	var images map[string]*ct.Artifact
-	if err := json.NewDecoder(gz).Decode(&images); err != nil {
-		return fmt.Errorf("error parsing images manifest: %s", err)
-	}
+// DownloadManifestImages downloads the layers of the images in an images
+// manifest which has already been fetched, importing them into the volume
+// manager if there is one.
+func (d *Downloader) DownloadManifestImages(images map[string]*ct.Artifact, ch chan *ct.ImagePullInfo) error {
+	defer close(ch)
 
-	// Download each image's layers
-	layerCacheDir := "/var/lib/flynn/layer-cache"
+	// Download each image's layers, recording the manifest first so
+	// that the layers are not garbage collected
 	if err := os.MkdirAll(layerCacheDir, 0755); err != nil {
 		return fmt.Errorf("error creating layer cache dir: %s", err)
 	}
+	if err := SaveManifest(d.version, images); err != nil {
+		return fmt.Errorf("error recording images manifest: %s", err)
+	}
 
 	for name, artifact := range images {
 		ch <- &ct.ImagePullInfo{
@@ -377,69 +777,175 @@ func (d *Downloader) DownloadImages(configDir string, ch chan *ct.ImagePullInfo)
 			Name:     name,
 			Artifact: artifact,
 		}
+	}
+
+	// Import layers into the volume manager one at a time as they are
+	// downloaded
+	var importMtx sync.Mutex
+	return d.downloadLayers(d.pendingLayers(images, d.log), func(l *pendingLayer) error {
+		ch <- &ct.ImagePullInfo{
+			Type:  ct.ImagePullTypeLayer,
+			Name:  l.image,
+			Layer: l.layer,
+		}
+
+		// Download layer
+		if err := d.downloadLayer(l.layer, layerCacheDir); err != nil {
+			return fmt.Errorf("error downloading layer %s: %s", l.layer.ID, err)
+		}
 
-		manifest := artifact.Manifest()
+		// Import layer into volume manager (best-effort).
+		// During a zero-downtime daemon restart, the volume
+		// manager's DB may be temporarily closed. Since the
+		// layer file is already on disk, the import can safely
+		// be skipped — the volume manager will discover it on
+		// the next restart or when the layer is first used.
+		if d.vman != nil {
+			importMtx.Lock()
+			defer importMtx.Unlock()
+			if err := d.importLayer(l.layer, l.path); err != nil {
+				if err == volumemanager.ErrDBClosed || err == volumemanager.ErrVolumeExists {
+					d.log.Warn("skipping layer import", "layer", l.layer.ID, "reason", err)
+				} else {
+					return fmt.Errorf("error importing layer %s: %s", l.layer.ID, err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// pendingLayer is a layer which needs downloading, along with the first
+// image which uses it and the path it is cached at.
+type pendingLayer struct {
+	image string
+	layer *ct.ImageLayer
+	path  string
+}
+
+// pendingLayers returns the layers of the given images for this host's
+// architecture which are not already in the layer cache, with each layer
+// appearing once.
+func (d *Downloader) pendingLayers(images map[string]*ct.Artifact, log log15.Logger) []*pendingLayer {
+	names := make([]string, 0, len(images))
+	for name := range images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var layers []*pendingLayer
+	seen := make(map[string]bool)
+	for _, name := range names {
+		manifest := images[name].Manifest()
 		if manifest == nil {
 			continue
 		}
 
-		for _, rootfs := range manifest.Rootfs {
+		// only download the layers for this host's architecture
+		for _, rootfs := range manifest.RootfsForArch(runtime.GOARCH) {
 			for _, layer := range rootfs.Layers {
+				if seen[layer.ID] {
+					continue
+				}
+				seen[layer.ID] = true
+
 				// Check if layer already exists and has the expected size.
 				// A truncated file (from a previous interrupted download)
 				// must be re-downloaded to avoid "verify: data too short"
 				// errors when the layer is later mounted.
-				layerPath := filepath.Join(layerCacheDir, layer.ID+".squashfs")
+				layerPath := LayerPath(layer.ID)
 				if fi, err := os.Stat(layerPath); err == nil {
 					if layer.Length > 0 && fi.Size() != layer.Length {
-						d.log.Warn("cached layer has wrong size, re-downloading", "layer", layer.ID, "expected", layer.Length, "actual", fi.Size())
+						log.Warn("cached layer has wrong size, re-downloading", "layer", layer.ID, "expected", layer.Length, "actual", fi.Size())
 						os.Remove(layerPath)
 					} else {
 						continue // Layer already cached
 					}
 				}
+				layers = append(layers, &pendingLayer{image: name, layer: layer, path: layerPath})
+			}
+		}
+	}
+	return layers
+}
 
-				ch <- &ct.ImagePullInfo{
-					Type:  ct.ImagePullTypeLayer,
-					Name:  name,
-					Layer: layer,
-				}
-
-				// Download layer
-				if err := d.downloadLayer(layer, layerCacheDir); err != nil {
-					return fmt.Errorf("error downloading layer %s: %s", layer.ID, err)
-				}
+// downloadLayers calls fn for each of the given layers using a pool of
+// d.layerConcurrency workers, stopping once fn fails and returning the
+// first error.
+func (d *Downloader) downloadLayers(layers []*pendingLayer, fn func(*pendingLayer) error) error {
+	workers := d.layerConcurrency
+	if workers < 1 {
+		workers = DefaultLayerConcurrency
+	}
+	if workers > len(layers) {
+		workers = len(layers)
+	}
 
-				// Import layer into volume manager (best-effort).
-				// During a zero-downtime daemon restart, the volume
-				// manager's DB may be temporarily closed. Since the
-				// layer file is already on disk, the import can safely
-				// be skipped — the volume manager will discover it on
-				// the next restart or when the layer is first used.
-				if d.vman != nil {
-					if err := d.importLayer(layer, layerPath); err != nil {
-						if err == volumemanager.ErrDBClosed || err == volumemanager.ErrVolumeExists {
-							d.log.Warn("skipping layer import", "layer", layer.ID, "reason", err)
-						} else {
-							return fmt.Errorf("error importing layer %s: %s", layer.ID, err)
-						}
-					}
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	queue := make(chan *pendingLayer)
+	failed := make(chan struct{})
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for l := range queue {
+				if err := fn(l); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						close(failed)
+					})
 				}
 			}
+		}()
+	}
+
+enqueue:
+	for _, l := range layers {
+		select {
+		case queue <- l:
+		case <-failed:
+			break enqueue
 		}
 	}
+	close(queue)
+	wg.Wait()
+	return firstErr
+}
 
-	return nil
+// DownloadLayer downloads a layer of the release to dir, verifying it against
+// its size and hashes, and returns its path.
+func (d *Downloader) DownloadLayer(layer *ct.ImageLayer, dir string) (string, error) {
+	if err := d.downloadLayer(layer, dir); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, layer.ID+".squashfs"), nil
 }
 
 // downloadLayer downloads a single layer from GitHub releases and verifies
 // its integrity using the expected size and cryptographic hashes from the
-// image manifest. If verification fails, the file is deleted and the
-// download is retried with exponential backoff.
+// image manifest. Failed downloads are retried with exponential backoff,
+// resuming from where the previous attempt stopped, and if verification
+// fails the file is deleted and downloaded again from the start.
 func (d *Downloader) downloadLayer(layer *ct.ImageLayer, cacheDir string) error {
 	layerURL := d.assetURL(layer.ID + ".squashfs")
 	destPath := filepath.Join(cacheDir, layer.ID+".squashfs")
 
+	if d.downloadPeerLayer(layer, destPath) {
+		return nil
+	}
+
+	if d.registry != "" {
+		err := d.downloadRegistryLayer(layer, destPath)
+		if err == nil {
+			return nil
+		}
+		d.log.Warn("error downloading layer from registry, falling back to release assets", "layer", layer.ID, "err", err)
+	}
+
 	var lastErr error
 	delay := initialRetryDelay
 	for attempt := 1; attempt <= maxDownloadRetries; attempt++ {
@@ -452,13 +958,13 @@ func (d *Downloader) downloadLayer(layer *ct.ImageLayer, cacheDir string) error
 			}
 		}
 
-		var dlErr error
-		if d.client != nil {
-			dlErr = d.client.DownloadFile(layerURL, destPath)
-		} else {
-			dlErr = downloadFileHTTP(layerURL, destPath)
-		}
-		if dlErr != nil {
+		// partial downloads are resumed by the next attempt
+		dlErr := d.eachServiceURL(layerURL, func(u string) error {
+			return d.downloadResumable(u, destPath)
+		})
+		if os.IsNotExist(dlErr) {
+			return dlErr
+		} else if dlErr != nil {
 			lastErr = dlErr
 			continue
 		}
@@ -476,6 +982,40 @@ func (d *Downloader) downloadLayer(layer *ct.ImageLayer, cacheDir string) error
 	return fmt.Errorf("download failed after %d attempts: %s", maxDownloadRetries, lastErr)
 }
 
+// downloadPeerLayer tries to download a layer from each peer serving it,
+// returning whether one succeeded. Peers are only used for layers with a
+// known size and hashes, as they are fetched without authentication.
+func (d *Downloader) downloadPeerLayer(layer *ct.ImageLayer, destPath string) bool {
+	if d.layerPeers == nil || layer.Length <= 0 || len(layer.Hashes) == 0 {
+		return false
+	}
+	for _, peer := range d.layerPeers(layer.ID) {
+		err := d.downloadResumable(fmt.Sprintf("%s/host/layers/%s", peer, layer.ID), destPath)
+		if err == nil {
+			if err = verifyLayerFile(destPath, layer.Length, layer.Hashes); err == nil {
+				d.log.Info("downloaded layer from peer", "layer", layer.ID, "peer", peer)
+				return true
+			}
+			os.Remove(destPath)
+		}
+		d.log.Warn("error downloading layer from peer, trying next source", "layer", layer.ID, "peer", peer, "err", err)
+	}
+	return false
+}
+
+// downloadRegistryLayer downloads a layer from the configured registry and
+// verifies it, removing the file if verification fails.
+func (d *Downloader) downloadRegistryLayer(layer *ct.ImageLayer, destPath string) error {
+	if err := d.downloadHTTP(fmt.Sprintf("%s/layers/%s.squashfs", d.registry, layer.ID), destPath); err != nil {
+		return err
+	}
+	if err := verifyLayerFile(destPath, layer.Length, layer.Hashes); err != nil {
+		os.Remove(destPath)
+		return err
+	}
+	return nil
+}
+
 // verifyLayerFile opens a downloaded layer file and verifies its size and
 // cryptographic hashes match the expected values from the image manifest.
 // Returns nil if no verification data is available (size <= 0 or no hashes).
@@ -501,50 +1041,20 @@ func verifyLayerFile(path string, expectedSize int64, hashes map[string]string)
 // DownloadImageLayers downloads layers for a set of images from GitHub releases.
 // This is used during updates to ensure layers are available before deploying.
 func (d *Downloader) DownloadImageLayers(images map[string]*ct.Artifact, log log15.Logger) error {
-	layerCacheDir := "/var/lib/flynn/layer-cache"
 	if err := os.MkdirAll(layerCacheDir, 0755); err != nil {
 		return fmt.Errorf("error creating layer cache dir: %s", err)
 	}
-
-	// Track unique layers to avoid downloading duplicates
-	downloadedLayers := make(map[string]bool)
-
-	for name, artifact := range images {
-		manifest := artifact.Manifest()
-		if manifest == nil {
-			continue
-		}
-
-		for _, rootfs := range manifest.Rootfs {
-			for _, layer := range rootfs.Layers {
-				// Skip if already downloaded in this session
-				if downloadedLayers[layer.ID] {
-					continue
-				}
-
-				// Check if layer already exists on disk and has the expected size.
-				// A truncated file must be re-downloaded.
-				layerPath := filepath.Join(layerCacheDir, layer.ID+".squashfs")
-				if fi, err := os.Stat(layerPath); err == nil {
-					if layer.Length > 0 && fi.Size() != layer.Length {
-						log.Warn("cached layer has wrong size, re-downloading", "layer", layer.ID, "expected", layer.Length, "actual", fi.Size())
-						os.Remove(layerPath)
-					} else {
-						downloadedLayers[layer.ID] = true
-						continue // Layer already cached
-					}
-				}
-
-				log.Info("downloading layer", "image", name, "layer", layer.ID)
-				if err := d.downloadLayer(layer, layerCacheDir); err != nil {
-					return fmt.Errorf("error downloading layer %s for image %s: %s", layer.ID, name, err)
-				}
-				downloadedLayers[layer.ID] = true
-			}
-		}
+	if err := SaveManifest(d.version, images); err != nil {
+		return fmt.Errorf("error recording images manifest: %s", err)
 	}
 
-	return nil
+	return d.downloadLayers(d.pendingLayers(images, log), func(l *pendingLayer) error {
+		log.Info("downloading layer", "image", l.image, "layer", l.layer.ID)
+		if err := d.downloadLayer(l.layer, layerCacheDir); err != nil {
+			return fmt.Errorf("error downloading layer %s for image %s: %s", l.layer.ID, l.image, err)
+		}
+		return nil
+	})
 }
 
 // importLayer imports a downloaded layer into the volume manager
@@ -0,0 +1,405 @@
+package downloader
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	discoverd "github.com/flynn/flynn/discoverd/client"
+	"github.com/flynn/flynn/pkg/ghrelease"
+	"github.com/inconshreveable/log15"
+)
+
+func TestDownloadResumable(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	var ranges []string
+	var mtx sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mtx.Unlock()
+		http.ServeContent(w, r, "layer", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "layer.squashfs")
+
+	// simulate an interrupted download
+	if err := os.WriteFile(dest+".partial", data[:4000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Downloader{}).downloadResumable(srv.URL, dest); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("expected %d bytes, got %d", len(data), len(got))
+	}
+	if _, err := os.Stat(dest + ".partial"); !os.IsNotExist(err) {
+		t.Fatalf("expected partial file to be removed, got %v", err)
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=4000-" {
+		t.Fatalf("expected a single request for bytes=4000-, got %q", ranges)
+	}
+
+	// a complete partial file is renamed without downloading it again
+	if err := os.WriteFile(dest+".partial", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Downloader{}).downloadResumable(srv.URL, dest); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) {
+		t.Fatalf("expected %d bytes, got %d", len(data), len(got))
+	}
+}
+
+func TestDownloadResumableWithoutRanges(t *testing.T) {
+	data := bytes.Repeat([]byte("abcdef"), 100)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	dest := filepath.Join(t.TempDir(), "layer.squashfs")
+	if err := os.WriteFile(dest+".partial", []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Downloader{}).downloadResumable(srv.URL, dest); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) {
+		t.Fatalf("expected the whole file to be downloaded again, got %q", got)
+	}
+}
+
+func TestDownloadMirrorAuth(t *testing.T) {
+	auths := make(map[string]string)
+	var mtx sync.Mutex
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		auths[r.URL.Path] = r.Header.Get("Authorization")
+		mtx.Unlock()
+		w.Write([]byte("data"))
+	}))
+	defer srv.Close()
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(srv.Certificate())
+	d := NewWithBaseURL(srv.URL+"/mirror", nil, "v1", nil)
+	d.SetAuth(&ghrelease.Auth{Header: "Bearer secret"})
+	d.SetRootCAs(rootCAs)
+
+	dir := t.TempDir()
+	if err := d.downloadHTTP(d.assetURL("images.json.gz"), filepath.Join(dir, "images.json.gz")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.downloadResumable(d.assetURL("layer1.squashfs"), filepath.Join(dir, "layer1.squashfs")); err != nil {
+		t.Fatal(err)
+	}
+	// credentials are only sent to the mirror
+	if err := d.downloadResumable(srv.URL+"/host/layers/layer2", filepath.Join(dir, "layer2.squashfs")); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"/mirror/images.json.gz":  "Bearer secret",
+		"/mirror/layer1.squashfs": "Bearer secret",
+		"/host/layers/layer2":     "",
+	}
+	if !reflect.DeepEqual(auths, expected) {
+		t.Fatalf("expected %v, got %v", expected, auths)
+	}
+}
+
+func TestDownloadLocalFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "layer1.squashfs")
+	data := bytes.Repeat([]byte("layer"), 100)
+	if err := os.WriteFile(src, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(dir, "cache", "layer1.squashfs")
+	if err := (&Downloader{}).downloadResumable("file://"+src, dest); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) {
+		t.Fatalf("expected %d bytes, got %d", len(data), len(got))
+	}
+
+	// missing local files are not retried
+	d := &Downloader{}
+	start := time.Now()
+	if err := d.downloadWithRetry("file://"+filepath.Join(dir, "missing"), dest); !os.IsNotExist(err) {
+		t.Fatalf("expected a not exist error, got %v", err)
+	}
+	if time.Since(start) >= initialRetryDelay {
+		t.Fatal("expected a missing local file not to be retried")
+	}
+}
+
+func TestDownloadLayers(t *testing.T) {
+	layers := make([]*pendingLayer, 20)
+	for i := range layers {
+		layers[i] = &pendingLayer{layer: &ct.ImageLayer{}}
+	}
+
+	d := &Downloader{}
+	d.SetLayerConcurrency(3)
+	var active, maxActive, count int32
+	err := d.downloadLayers(layers, func(*pendingLayer) error {
+		n := atomic.AddInt32(&active, 1)
+		for {
+			m := atomic.LoadInt32(&maxActive)
+			if n <= m || atomic.CompareAndSwapInt32(&maxActive, m, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&active, -1)
+		atomic.AddInt32(&count, 1)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 20 {
+		t.Fatalf("expected 20 layers to be downloaded, got %d", count)
+	}
+	if maxActive > 3 {
+		t.Fatalf("expected at most 3 concurrent downloads, got %d", maxActive)
+	}
+
+	// the first error stops further layers being downloaded
+	count = 0
+	expected := errors.New("download failed")
+	err = d.downloadLayers(layers, func(*pendingLayer) error {
+		atomic.AddInt32(&count, 1)
+		return expected
+	})
+	if err != expected {
+		t.Fatalf("expected %v, got %v", expected, err)
+	}
+	if count == 20 {
+		t.Fatal("expected downloads to stop after an error")
+	}
+}
+
+func TestDownloadPeerLayer(t *testing.T) {
+	data := bytes.Repeat([]byte("layer"), 1000)
+	sum := sha512.Sum512_256(data)
+	layer := &ct.ImageLayer{
+		ID:     "layer1",
+		Length: int64(len(data)),
+		Hashes: map[string]string{"sha512_256": hex.EncodeToString(sum[:])},
+	}
+
+	var paths []string
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer good.Close()
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("x"), len(data)))
+	}))
+	defer bad.Close()
+
+	log := log15.New()
+	log.SetHandler(log15.DiscardHandler())
+	d := &Downloader{log: log}
+	d.SetLayerPeers(func(id string) []string {
+		if id != layer.ID {
+			return nil
+		}
+		return []string{bad.URL, good.URL}
+	})
+
+	// layers which fail verification are discarded and the next peer tried
+	dest := filepath.Join(t.TempDir(), "layer1.squashfs")
+	if !d.downloadPeerLayer(layer, dest) {
+		t.Fatal("expected layer to be downloaded from a peer")
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) {
+		t.Fatal("expected downloaded layer to match")
+	}
+	if len(paths) != 1 || paths[0] != "/host/layers/layer1" {
+		t.Fatalf("unexpected requests to peer: %q", paths)
+	}
+
+	// layers without hashes are not fetched from peers
+	if d.downloadPeerLayer(&ct.ImageLayer{ID: layer.ID, Length: layer.Length}, dest) {
+		t.Fatal("expected unverifiable layer not to be downloaded from a peer")
+	}
+}
+
+func TestVerifyAssetRequiresSignature(t *testing.T) {
+	dir := t.TempDir()
+	asset := filepath.Join(dir, "images.json.gz")
+	if err := os.WriteFile(asset, []byte("images"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	log := log15.New()
+	log.SetHandler(log15.DiscardHandler())
+	newDownloader := func() *Downloader {
+		return NewWithBaseURL("file://"+dir, nil, "v1", log)
+	}
+
+	defer func(f func() (ed25519.PublicKey, error)) { releasePublicKey = f }(releasePublicKey)
+	releasePublicKey = func() (ed25519.PublicKey, error) { return nil, ghrelease.ErrNoPublicKey }
+
+	// without a key, a base URL without checksums is not verified
+	if err := newDownloader().verifyAsset("images.json.gz", asset); err != nil {
+		t.Fatal(err)
+	}
+
+	// with a key, missing checksums or a missing signature are rejected
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	releasePublicKey = func() (ed25519.PublicKey, error) { return pub, nil }
+	if err := newDownloader().verifyAsset("images.json.gz", asset); err == nil {
+		t.Fatal("expected an error for missing checksums")
+	}
+	sum := sha512.Sum512([]byte("images"))
+	checksums := hex.EncodeToString(sum[:]) + "  images.json.gz\n"
+	if err := os.WriteFile(filepath.Join(dir, ghrelease.ChecksumsName), []byte(checksums), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := newDownloader().verifyAsset("images.json.gz", asset); err == nil {
+		t.Fatal("expected an error for unsigned checksums")
+	}
+}
+
+func TestServiceBaseURL(t *testing.T) {
+	d := NewWithBaseURL(ServiceBaseURL("flynn-update-files"), nil, "v1", nil)
+	if got, want := d.assetURL("images.json.gz"), "discoverd+http://flynn-update-files/images.json.gz"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestDownloadServiceFailover(t *testing.T) {
+	var failed, mismatched int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&failed, 1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&mismatched, 1)
+		w.Write([]byte("v0"))
+	}))
+	defer other.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/images.json.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("v1"))
+	}))
+	defer healthy.Close()
+
+	defer func(f func(string) ([]*discoverd.Instance, error)) { serviceInstances = f }(serviceInstances)
+	var resolved []string
+	serviceInstances = func(service string) ([]*discoverd.Instance, error) {
+		resolved = append(resolved, service)
+		return []*discoverd.Instance{
+			{Addr: failing.Listener.Addr().String(), Meta: map[string]string{VersionMetaKey: "v1"}},
+			{Addr: other.Listener.Addr().String(), Meta: map[string]string{VersionMetaKey: "v0"}},
+			{Addr: healthy.Listener.Addr().String(), Meta: map[string]string{VersionMetaKey: "v1"}},
+		}, nil
+	}
+
+	log := log15.New()
+	log.SetHandler(log15.DiscardHandler())
+	d := NewWithBaseURL(ServiceBaseURL("flynn-update-files"), nil, "v1", log)
+	dest := filepath.Join(t.TempDir(), "images.json.gz")
+	if err := d.downloadHTTP(d.assetURL("images.json.gz"), dest); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dest); string(got) != "v1" {
+		t.Fatalf("expected the file from the healthy server, got %q", got)
+	}
+	if !reflect.DeepEqual(resolved, []string{"flynn-update-files"}) {
+		t.Fatalf("expected flynn-update-files to be resolved, got %v", resolved)
+	}
+
+	// the failing server is tried first and the server with another
+	// version is skipped
+	if n := atomic.LoadInt32(&failed); n != 1 {
+		t.Fatalf("expected 1 request to the failing server, got %d", n)
+	}
+	if n := atomic.LoadInt32(&mismatched); n != 0 {
+		t.Fatalf("expected no requests to the server with another version, got %d", n)
+	}
+
+	// the error from the last instance is returned if they all fail
+	serviceInstances = func(string) ([]*discoverd.Instance, error) {
+		return []*discoverd.Instance{{Addr: failing.Listener.Addr().String()}}, nil
+	}
+	if err := d.downloadHTTP(d.assetURL("images.json.gz"), dest); err == nil {
+		t.Fatal("expected an error when every instance fails")
+	}
+}
+
+func TestServiceAddrs(t *testing.T) {
+	defer func(f func(string) ([]*discoverd.Instance, error)) { serviceInstances = f }(serviceInstances)
+	serviceInstances = func(string) ([]*discoverd.Instance, error) {
+		return []*discoverd.Instance{
+			{Addr: "10.0.0.1:80", Meta: map[string]string{VersionMetaKey: "v1"}},
+			{Addr: "10.0.0.2:80", Meta: map[string]string{VersionMetaKey: "v2"}},
+			{Addr: "10.0.0.3:80"},
+		}, nil
+	}
+
+	// instances which do not advertise a version are not filtered
+	addrs, err := (&Downloader{version: "v1"}).serviceAddrs("svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"10.0.0.1:80", "10.0.0.3:80"}; !reflect.DeepEqual(addrs, expected) {
+		t.Fatalf("expected %v, got %v", expected, addrs)
+	}
+
+	// without a version every instance is used
+	addrs, err = (&Downloader{}).serviceAddrs("svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 3 {
+		t.Fatalf("expected 3 addrs, got %v", addrs)
+	}
+
+	// it is an error for no instance to serve the version
+	serviceInstances = func(string) ([]*discoverd.Instance, error) {
+		return []*discoverd.Instance{{Addr: "10.0.0.2:80", Meta: map[string]string{VersionMetaKey: "v2"}}}, nil
+	}
+	if _, err := (&Downloader{version: "v1"}).serviceAddrs("svc"); err == nil {
+		t.Fatal("expected an error when no instance serves the version")
+	}
+	serviceInstances = func(string) ([]*discoverd.Instance, error) {
+		return nil, errors.New("service not found")
+	}
+	if _, err := (&Downloader{version: "v1"}).serviceAddrs("svc"); err == nil {
+		t.Fatal("expected an error when the service cannot be resolved")
+	}
+}
@@ -0,0 +1,243 @@
+package downloader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	host "github.com/flynn/flynn/host/types"
+)
+
+const (
+	// DefaultLayerRetention is the number of previously installed releases
+	// whose layers are kept by GCLayers in addition to the latest
+	DefaultLayerRetention = 1
+
+	// layerGCGracePeriod is how long a file must have been in the layer
+	// cache before it is removed, so that layers being downloaded by
+	// another process are not removed before their manifest is recorded
+	layerGCGracePeriod = time.Hour
+
+	// manifestsDir is the directory in the layer cache which the image
+	// manifests of installed releases are recorded in
+	manifestsDir = "manifests"
+)
+
+// ErrNoManifests is returned by GCLayers if no installed image manifests
+// have been recorded, in which case it is not known which layers are needed.
+var ErrNoManifests = errors.New("no installed image manifests recorded, not removing layers")
+
+// layerCacheMtx prevents manifests being recorded while layers are collected
+var layerCacheMtx sync.Mutex
+
+// SaveManifest records the image manifest of an installed release so that
+// its layers are kept by GCLayers.
+func SaveManifest(version string, images map[string]*ct.Artifact) error {
+	layerCacheMtx.Lock()
+	defer layerCacheMtx.Unlock()
+
+	dir := filepath.Join(layerCacheDir, manifestsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(images)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, manifestName(version))
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// manifestName returns the file name of the manifest of the given release
+func manifestName(version string) string {
+	if version == "" || strings.ContainsAny(version, `/\`) || strings.HasPrefix(version, ".") {
+		version = "unversioned"
+	}
+	return version + ".json"
+}
+
+type installedManifest struct {
+	version string
+	path    string
+	modTime time.Time
+}
+
+// installedManifests returns the recorded manifests, most recently
+// installed first.
+func installedManifests() ([]*installedManifest, error) {
+	entries, err := os.ReadDir(filepath.Join(layerCacheDir, manifestsDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var manifests []*installedManifest
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, &installedManifest{
+			version: strings.TrimSuffix(entry.Name(), ".json"),
+			path:    filepath.Join(layerCacheDir, manifestsDir, entry.Name()),
+			modTime: info.ModTime(),
+		})
+	}
+	sort.Slice(manifests, func(i, j int) bool {
+		return manifests[i].modTime.After(manifests[j].modTime)
+	})
+	return manifests, nil
+}
+
+// manifestLayers adds the IDs of the layers of each image in the manifest
+// at path to ids.
+func manifestLayers(path string, ids map[string]bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var images map[string]*ct.Artifact
+	if err := json.Unmarshal(data, &images); err != nil {
+		return fmt.Errorf("error parsing image manifest %s: %s", path, err)
+	}
+	for _, artifact := range images {
+		manifest := artifact.Manifest()
+		if manifest == nil {
+			continue
+		}
+		for _, rootfs := range manifest.Rootfs {
+			for _, layer := range rootfs.Layers {
+				ids[layer.ID] = true
+			}
+		}
+	}
+	return nil
+}
+
+// ManifestLayer returns the layer with the given ID from the most recently
+// installed manifest which contains it, or nil if no recorded manifest
+// contains the layer.
+func ManifestLayer(id string) (*ct.ImageLayer, error) {
+	layerCacheMtx.Lock()
+	defer layerCacheMtx.Unlock()
+
+	manifests, err := installedManifests()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range manifests {
+		data, err := os.ReadFile(m.path)
+		if err != nil {
+			return nil, err
+		}
+		var images map[string]*ct.Artifact
+		if err := json.Unmarshal(data, &images); err != nil {
+			return nil, fmt.Errorf("error parsing image manifest %s: %s", m.path, err)
+		}
+		for _, artifact := range images {
+			manifest := artifact.Manifest()
+			if manifest == nil {
+				continue
+			}
+			for _, rootfs := range manifest.Rootfs {
+				for _, layer := range rootfs.Layers {
+					if layer.ID == id {
+						return layer, nil
+					}
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// GCLayers removes the layers in the layer cache which are not used by the
+// latest installed release, the given number of previously installed
+// releases or the given in use layers, along with the manifests of older
+// releases and stale partial downloads. If dryRun is set, the layers which
+// would be removed are returned without removing them.
+func GCLayers(retain int, inUse map[string]bool, dryRun bool) (*host.LayerGCResult, error) {
+	if retain < 0 {
+		return nil, fmt.Errorf("invalid layer retention %d", retain)
+	}
+
+	layerCacheMtx.Lock()
+	defer layerCacheMtx.Unlock()
+
+	manifests, err := installedManifests()
+	if err != nil {
+		return nil, err
+	}
+	if len(manifests) == 0 {
+		return nil, ErrNoManifests
+	}
+
+	res := &host.LayerGCResult{DryRun: dryRun}
+	keep := make(map[string]bool, len(inUse))
+	for id := range inUse {
+		keep[id] = true
+	}
+	for i, m := range manifests {
+		if i <= retain {
+			if err := manifestLayers(m.path, keep); err != nil {
+				return nil, err
+			}
+			res.Releases = append(res.Releases, m.version)
+			continue
+		}
+		res.RemovedReleases = append(res.RemovedReleases, m.version)
+		if !dryRun {
+			if err := os.Remove(m.path); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	entries, err := os.ReadDir(layerCacheDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".squashfs") && !strings.HasSuffix(name, ".squashfs.partial") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		id := strings.TrimSuffix(strings.TrimSuffix(name, ".partial"), ".squashfs")
+		if keep[id] || time.Since(info.ModTime()) < layerGCGracePeriod {
+			if !strings.HasSuffix(name, ".partial") {
+				res.Kept++
+			}
+			continue
+		}
+		if !dryRun {
+			if err := os.Remove(filepath.Join(layerCacheDir, name)); err != nil {
+				return nil, err
+			}
+			// remove any image config cached alongside the layer
+			os.Remove(filepath.Join(layerCacheDir, id+".json"))
+		}
+		if !strings.HasSuffix(name, ".partial") {
+			res.Removed = append(res.Removed, id)
+		}
+		res.RemovedBytes += info.Size()
+	}
+	return res, nil
+}
@@ -0,0 +1,131 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+)
+
+func imagesWithLayers(ids ...string) map[string]*ct.Artifact {
+	layers := make([]*ct.ImageLayer, len(ids))
+	for i, id := range ids {
+		layers[i] = &ct.ImageLayer{ID: id}
+	}
+	manifest := &ct.ImageManifest{
+		Type:   ct.ImageManifestTypeV1,
+		Rootfs: []*ct.ImageRootfs{{Layers: layers}},
+	}
+	return map[string]*ct.Artifact{
+		"image": {Type: ct.ArtifactTypeFlynn, RawManifest: manifest.RawManifest()},
+	}
+}
+
+func TestGCLayers(t *testing.T) {
+	layerCacheDir = t.TempDir()
+	defer func() { layerCacheDir = "/var/lib/flynn/layer-cache" }()
+
+	if _, err := GCLayers(1, nil, false); err != ErrNoManifests {
+		t.Fatalf("expected ErrNoManifests without manifests, got %v", err)
+	}
+
+	// record three releases, oldest first
+	old := time.Now().Add(-2 * layerGCGracePeriod)
+	for i, release := range []struct {
+		version string
+		layers  []string
+	}{
+		{"v1", []string{"a", "b"}},
+		{"v2", []string{"b", "c"}},
+		{"v3", []string{"c", "d"}},
+	} {
+		if err := SaveManifest(release.version, imagesWithLayers(release.layers...)); err != nil {
+			t.Fatal(err)
+		}
+		mtime := old.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(filepath.Join(layerCacheDir, manifestsDir, release.version+".json"), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"a.squashfs", "a.json", "b.squashfs", "c.squashfs", "d.squashfs", "job.squashfs", "e.squashfs.partial"} {
+		path := filepath.Join(layerCacheDir, name)
+		if err := os.WriteFile(path, make([]byte, 10), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// recently downloaded layers are kept
+	if err := os.WriteFile(filepath.Join(layerCacheDir, "new.squashfs"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	inUse := map[string]bool{"job": true}
+	res, err := GCLayers(1, inUse, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res.Removed, []string{"a"}) {
+		t.Fatalf("expected a dry run to report removing layer a, got %v", res.Removed)
+	}
+	if _, err := os.Stat(filepath.Join(layerCacheDir, "a.squashfs")); err != nil {
+		t.Fatalf("expected a dry run not to remove layers, got %v", err)
+	}
+
+	res, err = GCLayers(0, inUse, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(res.Removed)
+	if !reflect.DeepEqual(res.Removed, []string{"a", "b"}) {
+		t.Fatalf("expected layers a and b to be removed, got %v", res.Removed)
+	}
+	if res.RemovedBytes != 30 {
+		t.Fatalf("expected 30 bytes to be removed, got %d", res.RemovedBytes)
+	}
+	if res.Kept != 4 {
+		t.Fatalf("expected 4 layers to be kept, got %d", res.Kept)
+	}
+	if !reflect.DeepEqual(res.Releases, []string{"v3"}) || !reflect.DeepEqual(res.RemovedReleases, []string{"v2", "v1"}) {
+		t.Fatalf("unexpected releases %v, removed %v", res.Releases, res.RemovedReleases)
+	}
+	ids, err := CachedLayers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []string{"c", "d", "job", "new"}) {
+		t.Fatalf("unexpected cached layers %v", ids)
+	}
+	for _, name := range []string{"a.json", "e.squashfs.partial", filepath.Join(manifestsDir, "v1.json")} {
+		if _, err := os.Stat(filepath.Join(layerCacheDir, name)); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed, got %v", name, err)
+		}
+	}
+}
+
+func TestManifestLayer(t *testing.T) {
+	layerCacheDir = t.TempDir()
+	defer func() { layerCacheDir = "/var/lib/flynn/layer-cache" }()
+
+	if layer, err := ManifestLayer("a"); err != nil || layer != nil {
+		t.Fatalf("expected no layer without manifests, got %v, %v", layer, err)
+	}
+	if err := SaveManifest("v1", imagesWithLayers("a", "b")); err != nil {
+		t.Fatal(err)
+	}
+	layer, err := ManifestLayer("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layer == nil || layer.ID != "b" {
+		t.Fatalf("expected layer b, got %v", layer)
+	}
+	if layer, err := ManifestLayer("c"); err != nil || layer != nil {
+		t.Fatalf("expected no layer c, got %v, %v", layer, err)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	host "github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/julienschmidt/httprouter"
+)
+
+// drainCheckInterval is how often a draining host checks whether the
+// scheduler has moved its jobs to other hosts.
+var drainCheckInterval = time.Second
+
+// hostDrainer tracks draining the host, which marks it as unschedulable so
+// that the scheduler moves its jobs to other hosts before it is updated or
+// restarted.
+type hostDrainer struct {
+	mtx    sync.Mutex
+	status *host.DrainStatus
+
+	// stop is closed to stop waiting for the current drain to complete
+	stop chan struct{}
+}
+
+// Status returns a copy of the drain status, or nil if the host is not
+// draining.
+func (d *hostDrainer) Status() *host.DrainStatus {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return d.statusLocked()
+}
+
+func (d *hostDrainer) statusLocked() *host.DrainStatus {
+	if d.status == nil {
+		return nil
+	}
+	status := *d.status
+	status.Jobs = append([]string(nil), d.status.Jobs...)
+	return &status
+}
+
+// Drain starts draining the host if it is not already draining, waiting up to
+// timeout for the jobs the scheduler moves to other hosts to stop.
+func (h *Host) Drain(timeout time.Duration) (*host.DrainStatus, error) {
+	d := &h.drainer
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.status != nil && !d.status.Done() {
+		return d.statusLocked(), nil
+	}
+	if h.discMan != nil {
+		if err := h.discMan.SetDraining(true); err != nil {
+			return nil, err
+		}
+	}
+	h.log.Info("draining host", "timeout", timeout)
+	d.status = &host.DrainStatus{StartedAt: time.Now(), Jobs: h.drainJobs()}
+	d.stop = make(chan struct{})
+	go h.waitForDrain(timeout, d.stop)
+	return d.statusLocked(), nil
+}
+
+// Undrain stops draining the host, making it schedulable again.
+func (h *Host) Undrain() error {
+	d := &h.drainer
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.stop != nil {
+		close(d.stop)
+		d.stop = nil
+	}
+	d.status = nil
+	h.log.Info("undraining host")
+	if h.discMan != nil {
+		return h.discMan.SetDraining(false)
+	}
+	return nil
+}
+
+func (h *Host) waitForDrain(timeout time.Duration, stop chan struct{}) {
+	log := h.log.New("fn", "waitForDrain")
+	ticker := time.NewTicker(drainCheckInterval)
+	defer ticker.Stop()
+	deadline := time.After(timeout)
+
+	d := &h.drainer
+	var timedOut bool
+wait:
+	for jobs := h.drainJobs(); len(jobs) > 0; jobs = h.drainJobs() {
+		d.mtx.Lock()
+		if d.stop != stop {
+			d.mtx.Unlock()
+			return
+		}
+		d.status.Jobs = jobs
+		d.mtx.Unlock()
+
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			timedOut = true
+			break wait
+		case <-stop:
+			return
+		}
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.stop != stop {
+		return
+	}
+	now := time.Now()
+	d.status.CompletedAt = &now
+	d.status.TimedOut = timedOut
+	if !timedOut {
+		d.status.Jobs = nil
+	}
+	d.stop = nil
+
+	description := "host drained"
+	severity := host.SeverityInfo
+	if timedOut {
+		log.Warn("timed out draining host", "jobs", d.status.Jobs)
+		description = fmt.Sprintf("timed out draining host with %d jobs remaining", len(d.status.Jobs))
+		severity = host.SeverityWarning
+	} else {
+		log.Info("host drained", "duration", now.Sub(d.status.StartedAt))
+	}
+	if h.webhookDispatcher != nil {
+		h.webhookDispatcher.Send(host.CodeDaemonDrained, description, severity, "", nil, map[string]string{
+			"timed_out": fmt.Sprint(timedOut),
+			"jobs":      strings.Join(d.status.Jobs, ","),
+		})
+	}
+}
+
+// drainJobs returns the IDs of active jobs which the scheduler moves to
+// other hosts when the host drains, which are formation jobs which are not
+// omnipresent and have no volumes.
+func (h *Host) drainJobs() []string {
+	var ids []string
+	for id, job := range h.state.GetActive() {
+		meta := job.Job.Metadata
+		if meta["flynn-controller.formation"] != "true" || meta["flynn-controller.omni"] == "true" {
+			continue
+		}
+		if len(job.Job.Config.Volumes) > 0 {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Drain handles POST /host/drain by starting to drain the host.
+func (h *jobAPI) Drain(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req host.DrainRequest
+	if r.ContentLength != 0 {
+		if err := httphelper.DecodeJSON(r, &req); err != nil {
+			httphelper.Error(w, err)
+			return
+		}
+	}
+	if req.Timeout < 0 {
+		httphelper.ValidationError(w, "timeout", "must not be negative")
+		return
+	}
+	if req.Timeout == 0 {
+		req.Timeout = host.DefaultDrainTimeout
+	}
+	status, err := h.host.Drain(req.Timeout)
+	if err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, status)
+}
+
+// GetDrainStatus handles GET /host/drain by returning the drain status.
+func (h *jobAPI) GetDrainStatus(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	status := h.host.drainer.Status()
+	if status == nil {
+		httphelper.ObjectNotFoundError(w, "host is not draining")
+		return
+	}
+	httphelper.JSON(w, 200, status)
+}
+
+// Undrain handles DELETE /host/drain by stopping draining the host.
+func (h *jobAPI) Undrain(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := h.host.Undrain(); err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	w.WriteHeader(200)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"path/filepath"
+	"time"
+
+	host "github.com/flynn/flynn/host/types"
+	. "github.com/flynn/go-check"
+	"github.com/inconshreveable/log15"
+)
+
+func (S) TestDrain(c *C) {
+	defer func(interval time.Duration) { drainCheckInterval = interval }(drainCheckInterval)
+	drainCheckInterval = 10 * time.Millisecond
+
+	state := NewState("abc123", filepath.Join(c.MkDir(), "host-state-db"))
+	c.Assert(state.OpenDB(), IsNil)
+	defer state.CloseDB()
+	formation := map[string]string{"flynn-controller.formation": "true"}
+	for _, job := range []*host.Job{
+		{ID: "web", Metadata: formation},
+		{ID: "omni", Metadata: map[string]string{"flynn-controller.formation": "true", "flynn-controller.omni": "true"}},
+		{ID: "db", Metadata: formation, Config: host.ContainerConfig{Volumes: []host.VolumeBinding{{Target: "/data"}}}},
+		{ID: "oneoff"},
+	} {
+		c.Assert(state.AddJob(job), IsNil)
+		state.SetStatusRunning(job.ID)
+	}
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	h := &Host{state: state, log: logger}
+	waitDone := func() *host.DrainStatus {
+		for i := 0; i < 100; i++ {
+			if status := h.drainer.Status(); status.Done() {
+				return status
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		c.Fatal("timed out waiting for drain")
+		return nil
+	}
+
+	// only jobs the scheduler moves to other hosts are waited for
+	status, err := h.Drain(time.Minute)
+	c.Assert(err, IsNil)
+	c.Assert(status.Jobs, DeepEquals, []string{"web"})
+	c.Assert(status.Done(), Equals, false)
+
+	// draining again returns the current drain
+	again, err := h.Drain(time.Minute)
+	c.Assert(err, IsNil)
+	c.Assert(again.StartedAt, Equals, status.StartedAt)
+
+	state.SetStatusDone("web", 0)
+	status = waitDone()
+	c.Assert(status.TimedOut, Equals, false)
+	c.Assert(status.Jobs, HasLen, 0)
+
+	c.Assert(h.Undrain(), IsNil)
+	c.Assert(h.drainer.Status(), IsNil)
+
+	// the drain times out if jobs don't stop
+	c.Assert(state.AddJob(&host.Job{ID: "web2", Metadata: formation}), IsNil)
+	state.SetStatusRunning("web2")
+	_, err = h.Drain(50 * time.Millisecond)
+	c.Assert(err, IsNil)
+	status = waitDone()
+	c.Assert(status.TimedOut, Equals, true)
+	c.Assert(status.Jobs, DeepEquals, []string{"web2"})
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/inconshreveable/log15"
+	"github.com/julienschmidt/httprouter"
+)
+
+// execHandler runs commands in the containers of running jobs, streaming the
+// command's stdin, stdout and stderr over the connection using the same
+// framing as attach.
+type execHandler struct {
+	state   *State
+	backend Backend
+	logger  log15.Logger
+}
+
+func newExecHandler(state *State, backend Backend, logger log15.Logger) *execHandler {
+	return &execHandler{state: state, backend: backend, logger: logger}
+}
+
+func (h *execHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var execReq host.ExecReq
+	if err := httphelper.DecodeJSON(req, &execReq); err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	if len(execReq.Cmd) == 0 {
+		httphelper.ValidationError(w, "cmd", "must not be empty")
+		return
+	}
+	id := ps.ByName("id")
+	job := h.state.GetJob(id)
+	if job == nil {
+		httphelper.ObjectNotFoundError(w, "job not found")
+		return
+	}
+	if job.Status != host.StatusRunning {
+		httphelper.Error(w, httphelper.JSONError{
+			Code:    httphelper.PreconditionFailedErrorCode,
+			Message: host.ErrJobNotRunning.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Connection", "upgrade")
+	w.Header().Set("Upgrade", "flynn-attach/0")
+	w.WriteHeader(http.StatusSwitchingProtocols)
+
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	h.exec(id, &execReq, conn)
+}
+
+func (h *execHandler) exec(jobID string, req *host.ExecReq, conn io.ReadWriteCloser) {
+	defer conn.Close()
+	log := h.logger.New("fn", "exec", "job.id", jobID, "cmd", req.Cmd)
+
+	w := bufio.NewWriter(conn)
+	writeMtx := &sync.Mutex{}
+
+	opts := &ExecRequest{
+		JobID:  jobID,
+		Args:   req.Cmd,
+		Env:    req.Env,
+		TTY:    req.TTY,
+		Height: req.Height,
+		Width:  req.Width,
+	}
+	var stdinW *io.PipeWriter
+	if req.Flags&host.AttachFlagStdin != 0 {
+		opts.Stdin, stdinW = io.Pipe()
+	}
+	if req.Flags&host.AttachFlagStdout != 0 {
+		opts.Stdout = newFrameWriter(1, w, writeMtx)
+	}
+	if req.Flags&host.AttachFlagStderr != 0 {
+		opts.Stderr = newFrameWriter(2, w, writeMtx)
+	}
+
+	// hold the write lock until the success byte has been written so that
+	// output frames aren't written before it
+	writeMtx.Lock()
+	log.Info("starting")
+	proc, err := h.backend.Exec(opts)
+	if err != nil {
+		log.Error("error starting exec process", "err", err)
+		writeAttachError(w, err.Error())
+		writeMtx.Unlock()
+		if stdinW != nil {
+			stdinW.Close()
+		}
+		return
+	}
+	w.WriteByte(host.AttachSuccess)
+	w.Flush()
+	writeMtx.Unlock()
+
+	go h.readFrames(conn, proc, stdinW, req.TTY, log)
+
+	status, err := proc.Wait()
+	writeMtx.Lock()
+	defer writeMtx.Unlock()
+	if err != nil {
+		log.Error("error waiting for exec process", "err", err)
+		writeAttachError(w, err.Error())
+		return
+	}
+	log.Info("finished", "status", status)
+	w.WriteByte(host.AttachExit)
+	binary.Write(w, binary.BigEndian, uint32(status))
+	w.Flush()
+}
+
+// readFrames reads stdin, signal and resize frames from the client until the
+// connection is closed.
+func (h *execHandler) readFrames(conn io.Reader, proc ExecProcess, stdinW *io.PipeWriter, tty bool, log log15.Logger) {
+	defer func() {
+		if stdinW != nil {
+			stdinW.Close()
+		}
+	}()
+
+	r := bufio.NewReader(conn)
+	var buf [4]byte
+	for {
+		frameType, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		switch frameType {
+		case host.AttachData:
+			stream, err := r.ReadByte()
+			if err != nil || stream != 0 || stdinW == nil {
+				return
+			}
+			if _, err := io.ReadFull(r, buf[:]); err != nil {
+				return
+			}
+			length := int64(binary.BigEndian.Uint32(buf[:]))
+			if length == 0 {
+				stdinW.Close()
+				stdinW = nil
+				continue
+			}
+			if _, err := io.CopyN(stdinW, r, length); err != nil {
+				return
+			}
+		case host.AttachSignal:
+			if _, err := io.ReadFull(r, buf[:]); err != nil {
+				return
+			}
+			signal := int(binary.BigEndian.Uint32(buf[:]))
+			log.Info("signaling", "signal", signal)
+			if err := proc.Signal(signal); err != nil {
+				log.Error("error signalling exec process", "err", err)
+				return
+			}
+		case host.AttachResize:
+			if !tty {
+				return
+			}
+			if _, err := io.ReadFull(r, buf[:]); err != nil {
+				return
+			}
+			height := binary.BigEndian.Uint16(buf[:])
+			width := binary.BigEndian.Uint16(buf[2:])
+			if err := proc.ResizeTTY(height, width); err != nil {
+				log.Error("error resizing tty", "err", err)
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// writeAttachError writes an error frame to w.
+func writeAttachError(w *bufio.Writer, err string) {
+	w.WriteByte(host.AttachError)
+	binary.Write(w, binary.BigEndian, uint32(len(err)))
+	w.WriteString(err)
+	w.Flush()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+
+	"github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/pkg/cluster"
+	. "github.com/flynn/go-check"
+	"github.com/inconshreveable/log15"
+)
+
+// echoBackend runs exec processes which copy stdin to stdout and exit with
+// status 3.
+type echoBackend struct {
+	MockBackend
+}
+
+func (echoBackend) Exec(req *ExecRequest) (ExecProcess, error) {
+	p := &echoProcess{done: make(chan struct{})}
+	go func() {
+		io.Copy(req.Stdout, req.Stdin)
+		close(p.done)
+	}()
+	return p, nil
+}
+
+type echoProcess struct {
+	done chan struct{}
+}
+
+func (p *echoProcess) Signal(int) error                     { return nil }
+func (p *echoProcess) ResizeTTY(height, width uint16) error { return nil }
+func (p *echoProcess) Wait() (int, error) {
+	<-p.done
+	return 3, nil
+}
+
+func (S) TestExec(c *C) {
+	state := NewState("abc123", filepath.Join(c.MkDir(), "host-state-db"))
+	c.Assert(state.OpenDB(), IsNil)
+	defer state.CloseDB()
+	c.Assert(state.AddJob(&host.Job{ID: "a"}), IsNil)
+	state.SetStatusRunning("a")
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	h := newExecHandler(state, echoBackend{}, logger)
+
+	server, client := net.Pipe()
+	go h.exec("a", &host.ExecReq{
+		Cmd:   []string{"cat"},
+		Flags: host.AttachFlagStdin | host.AttachFlagStdout | host.AttachFlagStderr,
+	}, server)
+
+	attachState := make([]byte, 1)
+	_, err := io.ReadFull(client, attachState)
+	c.Assert(err, IsNil)
+	c.Assert(attachState[0], Equals, host.AttachSuccess)
+
+	attachClient := cluster.NewAttachClient(client)
+	go func() {
+		attachClient.Write([]byte("hello"))
+		attachClient.CloseWrite()
+	}()
+	var stdout bytes.Buffer
+	status, err := attachClient.Receive(&stdout, ioutil.Discard)
+	c.Assert(err, IsNil)
+	c.Assert(status, Equals, 3)
+	c.Assert(stdout.String(), Equals, "hello")
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// The files of a job are copied by flynn-host running as root on the host,
+// while processes in the job can change its filesystem at the same time, so
+// paths are never resolved and then used later. Instead every file is opened
+// relative to a file descriptor of the job's root with openat2(2)
+// RESOLVE_IN_ROOT, or relative to a directory opened that way without
+// following symlinks, and then read, written and chowned using the resulting
+// file descriptor.
+
+// maxArchiveID is the largest uid or gid an archive can give its entries,
+// excluding the overflow IDs and (uid_t)-1 which chown treats as no change.
+const maxArchiveID = 65533
+
+// copyFromRoot writes a tar archive of the file or directory at path inside
+// root to w. Entries are named relative to the parent of path, so copying
+// /var/log produces entries named log, log/syslog etc.
+//
+// Symlinks are resolved within root, so a job can't use them to read files
+// outside its filesystem.
+func copyFromRoot(root, path string, w io.Writer) error {
+	rootFd, err := openRoot(root)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(rootFd)
+
+	src, err := openInRoot(rootFd, path, unix.O_PATH)
+	if err != nil {
+		return err
+	}
+	info, err := src.Stat()
+	src.Close()
+	if err != nil {
+		return err
+	}
+	var f *os.File
+	switch {
+	case info.IsDir():
+		f, err = openInRoot(rootFd, path, unix.O_RDONLY|unix.O_DIRECTORY)
+	case info.Mode().IsRegular():
+		f, err = openInRoot(rootFd, path, unix.O_RDONLY|unix.O_NONBLOCK)
+	default:
+		return fmt.Errorf("%s is not a file or directory", path)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	name := filepath.Base(filepath.Clean("/" + path))
+	if name == "/" {
+		name = "."
+	}
+	tw := tar.NewWriter(w)
+	if err := writeTarEntry(tw, f, name); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// writeTarEntry writes the open file or directory f to tw as name, followed
+// by the contents of directories.
+func writeTarEntry(tw *tar.Writer, f *os.File, name string) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() && !info.Mode().IsRegular() {
+		// the file was replaced since it was checked
+		return nil
+	}
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = filepath.ToSlash(name)
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		_, err := io.Copy(tw, io.LimitReader(f, hdr.Size))
+		return err
+	}
+
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return err
+	}
+	dirFd := int(f.Fd())
+	for _, child := range names {
+		childName := filepath.Join(name, child)
+		var st unix.Stat_t
+		if err := unix.Fstatat(dirFd, child, &st, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+			if err == unix.ENOENT {
+				continue
+			}
+			return &os.PathError{Op: "lstat", Path: childName, Err: err}
+		}
+		var flags int
+		switch st.Mode & unix.S_IFMT {
+		case unix.S_IFLNK:
+			if err := writeTarSymlink(tw, dirFd, child, childName); err != nil {
+				return err
+			}
+			continue
+		case unix.S_IFDIR:
+			flags = unix.O_RDONLY | unix.O_DIRECTORY
+		case unix.S_IFREG:
+			// O_NONBLOCK so that a file replaced with a pipe can't
+			// block the copy, it is then skipped by its type
+			flags = unix.O_RDONLY | unix.O_NONBLOCK
+		default:
+			// skip devices, sockets and pipes
+			continue
+		}
+		fd, err := unix.Openat(dirFd, child, flags|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return &os.PathError{Op: "open", Path: childName, Err: err}
+		}
+		childFile := os.NewFile(uintptr(fd), childName)
+		err = writeTarEntry(tw, childFile, childName)
+		childFile.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTarSymlink writes the symlink name in the directory dirFd to tw.
+func writeTarSymlink(tw *tar.Writer, dirFd int, name, entryName string) error {
+	buf := make([]byte, unix.PathMax)
+	n, err := unix.Readlinkat(dirFd, name, buf)
+	if err != nil {
+		return &os.PathError{Op: "readlink", Path: entryName, Err: err}
+	}
+	var st unix.Stat_t
+	if err := unix.Fstatat(dirFd, name, &st, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return &os.PathError{Op: "lstat", Path: entryName, Err: err}
+	}
+	return tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeSymlink,
+		Name:     filepath.ToSlash(entryName),
+		Linkname: string(buf[:n]),
+		Mode:     int64(st.Mode & 07777),
+		Uid:      int(st.Uid),
+		Gid:      int(st.Gid),
+	})
+}
+
+// extractToRoot extracts the tar archive read from r into the directory dir
+// inside root, creating dir if it doesn't exist.
+//
+// Every path is resolved within root, including those created by earlier
+// entries in the archive, so neither symlinks in the job's filesystem nor in
+// the archive can be used to write files outside it.
+func extractToRoot(root, dir string, r io.Reader) error {
+	rootFd, err := openRoot(root)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(rootFd)
+
+	dirFile, err := mkdirAllInRoot(rootFd, dir)
+	if err != nil {
+		return err
+	}
+	dirFile.Close()
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir, tar.TypeReg, tar.TypeSymlink:
+		default:
+			// skip hard links, devices and other special files
+			continue
+		}
+		name := filepath.Join(dir, hdr.Name)
+		rel, err := filepath.Rel(dir, name)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			return fmt.Errorf("invalid path in archive: %q", hdr.Name)
+		} else if rel == "." {
+			continue
+		}
+		if hdr.Uid < 0 || hdr.Uid > maxArchiveID || hdr.Gid < 0 || hdr.Gid > maxArchiveID {
+			return fmt.Errorf("invalid owner %d:%d of %q in archive", hdr.Uid, hdr.Gid, hdr.Name)
+		}
+		if err := extractEntry(rootFd, name, hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// extractEntry creates the archive entry hdr at name inside the root rootFd.
+func extractEntry(rootFd int, name string, hdr *tar.Header, r io.Reader) error {
+	// resolve the parent within the root so that an existing symlink in
+	// place of the entry is replaced rather than followed
+	parent, err := mkdirAllInRoot(rootFd, filepath.Dir(name))
+	if err != nil {
+		return err
+	}
+	defer parent.Close()
+	parentFd := int(parent.Fd())
+	base := filepath.Base(name)
+
+	// remove anything in the way of the entry other than an existing
+	// directory, so symlinks are never followed
+	var st unix.Stat_t
+	if err := unix.Fstatat(parentFd, base, &st, unix.AT_SYMLINK_NOFOLLOW); err == nil {
+		isDir := st.Mode&unix.S_IFMT == unix.S_IFDIR
+		if !(isDir && hdr.Typeflag == tar.TypeDir) {
+			var flags int
+			if isDir {
+				flags = unix.AT_REMOVEDIR
+			}
+			if err := unix.Unlinkat(parentFd, base, flags); err != nil {
+				return &os.PathError{Op: "remove", Path: name, Err: err}
+			}
+		}
+	}
+
+	mode := uint32(os.FileMode(hdr.Mode).Perm())
+	var fd int
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := unix.Mkdirat(parentFd, base, mode); err != nil && err != unix.EEXIST {
+			return &os.PathError{Op: "mkdir", Path: name, Err: err}
+		}
+		fd, err = unix.Openat(parentFd, base, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	case tar.TypeReg:
+		fd, err = unix.Openat(parentFd, base, unix.O_CREAT|unix.O_EXCL|unix.O_WRONLY|unix.O_NOFOLLOW|unix.O_CLOEXEC, mode)
+	case tar.TypeSymlink:
+		if err := unix.Symlinkat(hdr.Linkname, parentFd, base); err != nil {
+			return &os.PathError{Op: "symlink", Path: name, Err: err}
+		}
+		if err := unix.Fchownat(parentFd, base, hdr.Uid, hdr.Gid, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+			return &os.PathError{Op: "lchown", Path: name, Err: err}
+		}
+		return nil
+	}
+	if err != nil {
+		return &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	f := os.NewFile(uintptr(fd), name)
+	defer f.Close()
+	if hdr.Typeflag == tar.TypeReg {
+		if _, err := io.Copy(f, r); err != nil {
+			return err
+		}
+	}
+	if err := f.Chown(hdr.Uid, hdr.Gid); err != nil {
+		return err
+	}
+	return f.Chmod(os.FileMode(mode))
+}
+
+// openRoot opens the root directory of a job for use with openInRoot.
+func openRoot(root string) (int, error) {
+	fd, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, &os.PathError{Op: "open", Path: root, Err: err}
+	}
+	return fd, nil
+}
+
+// openInRoot opens path inside the root rootFd, resolving symlinks and ".."
+// as if rootFd were the filesystem root.
+func openInRoot(rootFd int, path string, flags int) (*os.File, error) {
+	rel := strings.TrimPrefix(filepath.Clean("/"+path), "/")
+	if rel == "" {
+		rel = "."
+	}
+	fd, err := unix.Openat2(rootFd, rel, &unix.OpenHow{
+		Flags:   uint64(flags | unix.O_CLOEXEC),
+		Resolve: unix.RESOLVE_IN_ROOT | unix.RESOLVE_NO_MAGICLINKS,
+	})
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// mkdirAllInRoot creates the directory path inside the root rootFd along
+// with any missing parents, returning it opened with O_PATH.
+func mkdirAllInRoot(rootFd int, path string) (*os.File, error) {
+	return mkdirAllInRootDepth(rootFd, path, 0)
+}
+
+// maxSymlinkDepth limits the dangling symlinks followed by mkdirAllInRoot,
+// like the kernel's limit on nested symlinks
+const maxSymlinkDepth = 40
+
+func mkdirAllInRootDepth(rootFd int, path string, depth int) (*os.File, error) {
+	if depth > maxSymlinkDepth {
+		return nil, &os.PathError{Op: "mkdir", Path: path, Err: unix.ELOOP}
+	}
+	path = strings.TrimPrefix(filepath.Clean("/"+path), "/")
+	dir, err := openInRoot(rootFd, ".", unix.O_PATH|unix.O_DIRECTORY)
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return dir, nil
+	}
+	var prefix string
+	for _, part := range strings.Split(path, "/") {
+		parent := prefix
+		prefix = filepath.Join(prefix, part)
+		next, err := openInRoot(rootFd, prefix, unix.O_PATH|unix.O_DIRECTORY)
+		if os.IsNotExist(err) {
+			// create the directory in its parent, which was opened
+			// within the root, then open it again within the root
+			// in case it has been replaced
+			err = unix.Mkdirat(int(dir.Fd()), part, 0755)
+			if err == unix.EEXIST {
+				// a dangling symlink, create its target within
+				// the root
+				err = mkdirSymlinkTarget(rootFd, int(dir.Fd()), parent, part, depth)
+			}
+			if err != nil && err != unix.EEXIST {
+				dir.Close()
+				return nil, &os.PathError{Op: "mkdir", Path: prefix, Err: err}
+			}
+			next, err = openInRoot(rootFd, prefix, unix.O_PATH|unix.O_DIRECTORY)
+		}
+		dir.Close()
+		if err != nil {
+			return nil, err
+		}
+		dir = next
+	}
+	return dir, nil
+}
+
+// mkdirSymlinkTarget creates the directory the symlink name in the directory
+// dirFd, at path parent inside the root, points to.
+func mkdirSymlinkTarget(rootFd, dirFd int, parent, name string, depth int) error {
+	buf := make([]byte, unix.PathMax)
+	n, err := unix.Readlinkat(dirFd, name, buf)
+	if err != nil {
+		return err
+	}
+	target := string(buf[:n])
+	if !filepath.IsAbs(target) {
+		target = filepath.Join("/", parent, target)
+	}
+	f, err := mkdirAllInRootDepth(rootFd, target, depth+1)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
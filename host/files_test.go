@@ -0,0 +1,93 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/flynn/go-check"
+)
+
+func (S) TestCopyFilesRoundTrip(c *C) {
+	src := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(src, "var/log/app"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(src, "var/log/app/app.log"), []byte("started"), 0640), IsNil)
+	c.Assert(os.Symlink("app.log", filepath.Join(src, "var/log/app/current")), IsNil)
+
+	var buf bytes.Buffer
+	c.Assert(copyFromRoot(src, "/var/log/app", &buf), IsNil)
+
+	dst := c.MkDir()
+	c.Assert(extractToRoot(dst, "/tmp", &buf), IsNil)
+	data, err := ioutil.ReadFile(filepath.Join(dst, "tmp/app/app.log"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "started")
+	info, err := os.Stat(filepath.Join(dst, "tmp/app/app.log"))
+	c.Assert(err, IsNil)
+	c.Assert(info.Mode().Perm(), Equals, os.FileMode(0640))
+	link, err := os.Readlink(filepath.Join(dst, "tmp/app/current"))
+	c.Assert(err, IsNil)
+	c.Assert(link, Equals, "app.log")
+
+	c.Assert(os.IsNotExist(copyFromRoot(src, "/missing", ioutil.Discard)), Equals, true)
+}
+
+func (S) TestCopyFilesStaysInRoot(c *C) {
+	outside := c.MkDir()
+	root := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644), IsNil)
+
+	// symlinks in the job's filesystem are resolved within the root
+	c.Assert(os.Symlink(outside, filepath.Join(root, "escape")), IsNil)
+	c.Assert(os.IsNotExist(copyFromRoot(root, "/escape/secret", ioutil.Discard)), Equals, true)
+
+	// archives can't write outside the root by traversal or via a symlink
+	// they create
+	archive := func(entries ...*tar.Header) io.Reader {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, hdr := range entries {
+			hdr.Uid, hdr.Gid = os.Getuid(), os.Getgid()
+			c.Assert(tw.WriteHeader(hdr), IsNil)
+			if hdr.Size > 0 {
+				tw.Write(bytes.Repeat([]byte("x"), int(hdr.Size)))
+			}
+		}
+		c.Assert(tw.Close(), IsNil)
+		return &buf
+	}
+	c.Assert(extractToRoot(root, "/app", archive(
+		&tar.Header{Name: "../secret", Typeflag: tar.TypeReg, Mode: 0644, Size: 1},
+	)), NotNil)
+	c.Assert(extractToRoot(root, "/", archive(
+		&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: outside},
+		&tar.Header{Name: "link/secret", Typeflag: tar.TypeReg, Mode: 0644, Size: 1},
+	)), IsNil)
+	data, err := ioutil.ReadFile(filepath.Join(outside, "secret"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "secret")
+
+	// existing symlinks are replaced rather than written through
+	c.Assert(extractToRoot(root, "/", archive(
+		&tar.Header{Name: "escape", Typeflag: tar.TypeReg, Mode: 0644, Size: 1},
+	)), IsNil)
+	info, err := os.Lstat(filepath.Join(root, "escape"))
+	c.Assert(err, IsNil)
+	c.Assert(info.Mode().IsRegular(), Equals, true)
+}
+
+func (S) TestCopyFilesInvalidOwner(c *C) {
+	root := c.MkDir()
+	for _, owner := range [][2]int{{-1, 0}, {0, -1}, {65534, 0}, {0, 1 << 32}} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		c.Assert(tw.WriteHeader(&tar.Header{Name: "file", Typeflag: tar.TypeReg, Mode: 0644, Uid: owner[0], Gid: owner[1]}), IsNil)
+		c.Assert(tw.Close(), IsNil)
+		c.Assert(extractToRoot(root, "/", &buf), ErrorMatches, "invalid owner .*")
+		_, err := os.Lstat(filepath.Join(root, "file"))
+		c.Assert(os.IsNotExist(err), Equals, true)
+	}
+}
@@ -94,22 +94,20 @@ func (f *ClusterFixer) StartScheduler(client controller.Client, cf *ct.Formation
 
 func (f *ClusterFixer) KillSchedulers() error {
 	f.l.Info("killing any running schedulers to prevent interference")
+	sel := host.JobSelector{
+		{Key: "flynn-controller.app_name", Op: host.JobSelectorOpEqual, Value: "controller"},
+		{Key: "flynn-controller.type", Op: host.JobSelectorOpEqual, Value: "scheduler"},
+	}
 	for _, h := range f.hosts {
-		jobs, err := h.ListJobs()
+		res, err := h.BulkStopJobs(sel)
 		if err != nil {
-			return fmt.Errorf("error listing jobs from %s: %s", h.ID(), err)
+			return fmt.Errorf("error stopping scheduler jobs on %s: %s", h.ID(), err)
 		}
-		for _, j := range jobs {
-			if j.Job.Metadata["flynn-controller.app_name"] != "controller" || j.Job.Metadata["flynn-controller.type"] != "scheduler" {
-				continue
-			}
-			if j.Status != host.StatusRunning && j.Status != host.StatusStarting {
-				continue
-			}
-			if err := h.StopJob(j.Job.ID); err != nil {
-				f.l.Error("error stopping scheduler job", "id", j.Job.ID, "error", err)
-			}
-			f.l.Info("stopped scheduler instance", "job.id", j.Job.ID)
+		for id, err := range res.Errors {
+			f.l.Error("error stopping scheduler job", "id", id, "error", err)
+		}
+		for _, id := range res.Jobs {
+			f.l.Info("stopped scheduler instance", "job.id", id)
 		}
 	}
 	return nil
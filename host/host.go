@@ -15,17 +15,21 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/docker/go-units"
 	"github.com/flynn/flynn/bootstrap/discovery"
 	discoverd "github.com/flynn/flynn/discoverd/client"
 	"github.com/flynn/flynn/host/cli"
 	"github.com/flynn/flynn/host/config"
 	"github.com/flynn/flynn/host/logmux"
+	"github.com/flynn/flynn/host/tuning"
 	host "github.com/flynn/flynn/host/types"
 	"github.com/flynn/flynn/host/volume"
 	volumeapi "github.com/flynn/flynn/host/volume/api"
 	volumemanager "github.com/flynn/flynn/host/volume/manager"
-	zfsVolume "github.com/flynn/flynn/host/volume/zfs"
+	dirVolume "github.com/flynn/flynn/host/volume/dir"
+	"github.com/flynn/flynn/pkg/maintenance"
 	"github.com/flynn/flynn/pkg/shutdown"
+	"github.com/flynn/flynn/pkg/tracing"
 	"github.com/flynn/flynn/pkg/version"
 	"github.com/flynn/go-docopt"
 	"github.com/inconshreveable/log15"
@@ -49,7 +53,7 @@ options:
   --tags=TAGS                host tags (comma separated list of KEY=VAL pairs, used for job constraints in the scheduler)
   --force                    kill all containers booted by flynn-host before starting
   --volpath=PATH             directory to create volumes in [default: /var/lib/flynn/volumes]
-  --vol-provider=VOL         volume provider [default: zfs]
+  --vol-provider=VOL         volume provider, zfs or dir (defaults to dir in lite mode, zfs otherwise)
   --backend=BACKEND          runner backend [default: libcontainer]
   --flynn-init=PATH          path to flynn-init binary [default: /usr/local/bin/flynn-init]
   --log-dir=DIR              directory to store job logs [default: /var/log/flynn]
@@ -60,11 +64,35 @@ options:
   --bridge-name=NAME         network bridge name [default: flynnbr0]
   --no-resurrect             disable cluster resurrection
   --max-job-concurrency=NUM  maximum number of jobs to start concurrently
+  --overcommit-ratio=RATIO   reject jobs which would reserve more than this multiple of the host's memory or CPU, 0 to disable [default: 0]
   --partitions=PARTITIONS    specify resource partitions for host [default: system=cpu_shares:4096 background=cpu_shares:4096 user=cpu_shares:8192]
   --init-log-level=LEVEL     containerinit log level [default: info]
   --zpool-name=NAME          zpool name
   --enable-dhcp              enable DHCP server (useful to provide container IPs to VMs running in Flynn jobs)
-  --auth-key=KEY             authentication key for host HTTP API (or set FLYNN_HOST_AUTH_KEY env)
+  --register-resolved        register discoverd DNS with systemd-resolved on the bridge so host tools can resolve .discoverd names
+  --auth-key=KEYS            comma separated authentication keys for host HTTP API (or set FLYNN_HOST_AUTH_KEY env)
+  --tls-cert=FILE            serve the host HTTP API over TLS using this certificate (see 'flynn-host tls')
+  --tls-key=FILE             private key of --tls-cert
+  --tls-client-ca=FILE       authenticate clients presenting certificates signed by this CA
+  --tls-require-client-cert  reject requests without a client certificate signed by --tls-client-ca
+  --rate-limit-read=NUM      GET requests per minute allowed from each client without a valid auth key, 0 to disable [default: 300]
+  --rate-limit-write=NUM     other requests per minute allowed from each client without a valid auth key, 0 to disable [default: 60]
+  --tune-kernel              apply recommended kernel settings for database appliances (see 'flynn-host doctor')
+  --lite                     run a single-node host using directory volumes and a local bridge instead of ZFS and flannel
+  --lite-subnet=CIDR         bridge address and subnet for containers in lite mode [default: 100.100.0.1/24]
+  --disk-pressure-threshold=PERCENT  send a webhook event when disk usage exceeds PERCENT, 0 to disable [default: 90]
+  --load-pressure-threshold=LOAD     send a webhook event when the 5 minute load average per CPU stays above LOAD for 5 minutes, 0 to disable [default: 2]
+  --memory-pressure-floor=SIZE       send a webhook event when available memory drops below SIZE, 0 to disable [default: 256MB]
+  --disk-reject-threshold=PERCENT    stop accepting new jobs and remove unused image layers when usage of /var/lib/flynn exceeds PERCENT, 0 to disable [default: 95]
+  --disk-evict-threshold=PERCENT     stop the lowest priority jobs while usage of /var/lib/flynn exceeds PERCENT, 0 to disable [default: 0]
+  --layer-gc-interval=DURATION       how often to remove image layers which are no longer needed from the layer cache, 0 to disable [default: 24h]
+  --layer-gc-retain=NUM              number of previously installed releases whose image layers are kept in the layer cache [default: 1]
+  --metrics-export-url=URL           push host and job metrics to this remote-write or InfluxDB write endpoint
+  --metrics-export-format=FORMAT     format of --metrics-export-url, remote-write or influxdb [default: remote-write]
+  --metrics-export-interval=DURATION how often to push metrics to --metrics-export-url [default: 30s]
+  --metrics-export-token=TOKEN       bearer token sent to --metrics-export-url (or set FLYNN_HOST_METRICS_EXPORT_TOKEN env)
+  --maintenance-windows=WINDOWS  semicolon separated maintenance windows during which the host is preferred for
+                             restarts and updates, each "[TZ=zone] <cron schedule> <duration>" (e.g. "TZ=Europe/London 0 2 * * SAT,SUN 3h")
 	`)
 }
 
@@ -96,6 +124,7 @@ Commands:
   daemon                     Start the daemon
   update                     Update Flynn components
   download                   Download container images
+  export-images              Export the images of current releases to a tarball
   bootstrap                  Bootstrap layer 1
   inspect                    Get low-level information about a job
   log                        Get the logs of a job
@@ -116,6 +145,7 @@ Commands:
   cli-add-command            Get the 'flynn cluster add' command to manage this cluster
   volume                     Manage volumes on the Flynn node
   acme                       Manage ACME/Let's Encrypt configuration
+  doctor                     Check kernel settings for database appliances
 
 See 'flynn-host help <command>' for more information on a specific command.
 `
@@ -191,12 +221,24 @@ func runDaemon(args *docopt.Args) {
 	discoveryService := args.String["--discovery-service"]
 	bridgeName := args.String["--bridge-name"]
 	enableDHCP := args.Bool["--enable-dhcp"]
+	resolvedDNS := args.Bool["--register-resolved"]
+	tuneKernel := args.Bool["--tune-kernel"]
+	lite := args.Bool["--lite"] || liteBuild
+
+	maintenanceWindows, err := maintenance.ParseList(args.String["--maintenance-windows"])
+	if err != nil {
+		shutdown.Fatalf("error parsing --maintenance-windows: %s", err)
+	}
 
 	logger, err := setupLogger(logDir, logFile)
 	if err != nil {
 		shutdown.Fatalf("error setting up logger: %s", err)
 	}
 
+	if tracing.Init("flynn-host") {
+		shutdown.BeforeExit(tracing.Flush)
+	}
+
 	initLogLevel, err := log15.LvlFromString(args.String["--init-log-level"])
 	if err != nil {
 		shutdown.Fatalf("error setting init log level: %s", err)
@@ -216,9 +258,82 @@ func runDaemon(args *docopt.Args) {
 		maxJobConcurrency = m
 	}
 
+	overcommitRatio, err := strconv.ParseFloat(args.String["--overcommit-ratio"], 64)
+	if err != nil || overcommitRatio < 0 {
+		shutdown.Fatalf("invalid --overcommit-ratio value %q", args.String["--overcommit-ratio"])
+	}
+
+	var thresholds resourceThresholds
+	thresholds.DiskPercent, err = strconv.ParseFloat(args.String["--disk-pressure-threshold"], 64)
+	if err != nil || thresholds.DiskPercent < 0 || thresholds.DiskPercent > 100 {
+		shutdown.Fatalf("invalid --disk-pressure-threshold value %q", args.String["--disk-pressure-threshold"])
+	}
+	thresholds.LoadPerCPU, err = strconv.ParseFloat(args.String["--load-pressure-threshold"], 64)
+	if err != nil || thresholds.LoadPerCPU < 0 {
+		shutdown.Fatalf("invalid --load-pressure-threshold value %q", args.String["--load-pressure-threshold"])
+	}
+	memoryFloor, err := units.RAMInBytes(args.String["--memory-pressure-floor"])
+	if err != nil || memoryFloor < 0 {
+		shutdown.Fatalf("invalid --memory-pressure-floor value %q", args.String["--memory-pressure-floor"])
+	}
+	thresholds.MemoryFloor = uint64(memoryFloor)
+
+	var diskThresholds diskPressureThresholds
+	for flag, percent := range map[string]*float64{"--disk-reject-threshold": &diskThresholds.RejectPercent, "--disk-evict-threshold": &diskThresholds.EvictPercent} {
+		*percent, err = strconv.ParseFloat(args.String[flag], 64)
+		if err != nil || *percent < 0 || *percent > 100 {
+			shutdown.Fatalf("invalid %s value %q", flag, args.String[flag])
+		}
+	}
+
+	layerGCInterval, err := time.ParseDuration(args.String["--layer-gc-interval"])
+	if err != nil || layerGCInterval < 0 {
+		shutdown.Fatalf("invalid --layer-gc-interval value %q", args.String["--layer-gc-interval"])
+	}
+	layerGCRetain, err := strconv.Atoi(args.String["--layer-gc-retain"])
+	if err != nil || layerGCRetain < 0 {
+		shutdown.Fatalf("invalid --layer-gc-retain value %q", args.String["--layer-gc-retain"])
+	}
+
+	var metricsExport *metricsExportConfig
+	if u := args.String["--metrics-export-url"]; u != "" {
+		metricsExport = &metricsExportConfig{
+			URL:    u,
+			Format: args.String["--metrics-export-format"],
+			Token:  args.String["--metrics-export-token"],
+		}
+		if metricsExport.Token == "" {
+			metricsExport.Token = os.Getenv("FLYNN_HOST_METRICS_EXPORT_TOKEN")
+		}
+		metricsExport.Interval, err = time.ParseDuration(args.String["--metrics-export-interval"])
+		if err != nil {
+			shutdown.Fatalf("invalid --metrics-export-interval value %q", args.String["--metrics-export-interval"])
+		}
+		if err := metricsExport.Validate(); err != nil {
+			shutdown.Fatalf("invalid metrics export config: %s", err)
+		}
+	}
+
 	zpoolName := args.String["--zpool-name"]
-	if zpoolName == "" {
-		zpoolName = zfsVolume.DefaultDatasetName
+
+	if volProvider == "" {
+		volProvider = "zfs"
+		if lite {
+			volProvider = "dir"
+		}
+	}
+
+	// lite mode runs a single host which configures its own network rather
+	// than waiting for flannel to allocate it a subnet
+	var liteNetwork *host.NetworkConfig
+	if lite {
+		if discoveryToken != "" || discoveryService != "" || len(peerIPs) > 0 {
+			shutdown.Fatal("joining a cluster is not supported in lite mode")
+		}
+		if _, _, err := net.ParseCIDR(args.String["--lite-subnet"]); err != nil {
+			shutdown.Fatalf("invalid --lite-subnet: %s", err)
+		}
+		liteNetwork = &host.NetworkConfig{Subnet: args.String["--lite-subnet"], MTU: 1500}
 	}
 
 	if path, err := filepath.Abs(flynnInit); err == nil {
@@ -261,6 +376,21 @@ func runDaemon(args *docopt.Args) {
 		log.Info("using external IP " + externalIP)
 	}
 
+	advisor := tuning.NewAdvisor("/")
+	if tuneKernel {
+		log.Info("applying recommended kernel settings")
+		fixed, err := advisor.Fix()
+		for _, w := range fixed {
+			log.Info("applied kernel setting", "setting", w.Setting, "previous", w.Value, "value", w.Recommended)
+		}
+		if err != nil {
+			log.Error("error applying kernel settings", "err", err)
+		}
+	}
+	for _, w := range advisor.Check() {
+		log.Warn("unsuitable kernel setting", "setting", w.Setting, "value", w.Value, "recommended", w.Recommended, "reason", w.Message)
+	}
+
 	publishAddr := net.JoinHostPort(externalIP, httpPort)
 	if discoveryToken != "" {
 		// TODO: retry
@@ -285,10 +415,12 @@ func runDaemon(args *docopt.Args) {
 	switch volProvider {
 	case "zfs":
 		newVolProvider = func() (volume.Provider, error) {
-			return zfsVolume.NewProvider(&zfsVolume.ProviderConfig{
-				DatasetName: zpoolName,
-				Make:        zfsVolume.DefaultMakeDev(volPath, log),
-				WorkingDir:  filepath.Join(volPath, "zfs"),
+			return newZFSProvider(zpoolName, volPath, log)
+		}
+	case "dir":
+		newVolProvider = func() (volume.Provider, error) {
+			return dirVolume.NewProvider(&dirVolume.ProviderConfig{
+				WorkingDir: filepath.Join(volPath, "dir"),
 			})
 		}
 	case "mock":
@@ -339,14 +471,35 @@ func runDaemon(args *docopt.Args) {
 	if authKey == "" {
 		authKey = os.Getenv("FLYNN_HOST_AUTH_KEY")
 	}
-	if authKey != "" {
-		log.Info("host HTTP API authentication enabled")
+	var rateLimits hostRateLimits
+	for flag, limit := range map[string]*int{"--rate-limit-read": &rateLimits.Read, "--rate-limit-write": &rateLimits.Write} {
+		n, err := strconv.Atoi(args.String[flag])
+		if err != nil || n < 0 {
+			shutdown.Fatalf("invalid %s value %q", flag, args.String[flag])
+		}
+		*limit = n
+	}
+	authKeys := newAuthKeySet(parseAuthKeys(authKey))
+	hostTLS, err := loadHostTLS(args.String["--tls-cert"], args.String["--tls-key"], args.String["--tls-client-ca"], args.Bool["--tls-require-client-cert"])
+	if err != nil {
+		shutdown.Fatalf("error configuring TLS: %s", err)
+	}
+	if authKeys.Enabled() || (hostTLS != nil && hostTLS.requireClientCert) {
+		log.Info("host HTTP API authentication enabled", "rate_limit.read", rateLimits.Read, "rate_limit.write", rateLimits.Write)
 	} else {
 		log.Warn("host HTTP API authentication disabled (set --auth-key or FLYNN_HOST_AUTH_KEY)")
 	}
+	if hostTLS != nil {
+		log.Info("host HTTP API TLS enabled", "client_certs", hostTLS.config.ClientCAs != nil, "require_client_cert", hostTLS.requireClientCert)
+	}
 
-	discoverdManager := NewDiscoverdManager(backend, sman, hostID, publishAddr, tags)
+	profiles := supportedJobProfiles()
+	log.Info("detected supported job profiles", "profiles", profiles)
+	discoverdManager := NewDiscoverdManager(backend, sman, hostID, publishAddr, tags, profiles)
 	publishURL := "http://" + publishAddr
+	if hostTLS != nil {
+		publishURL = "https://" + publishAddr
+	}
 	webhookDisp := NewWebhookDispatcher(hostID, state, logger)
 	go webhookDisp.Run()
 	shutdown.BeforeExit(func() {
@@ -355,6 +508,32 @@ func runDaemon(args *docopt.Args) {
 	})
 	state.webhookDispatcher = webhookDisp
 
+	coreDumps := NewCoreDumpManager(coreDumpRoot, state, logger)
+	go coreDumps.Run()
+	shutdown.BeforeExit(coreDumps.Shutdown)
+
+	reserver, err := newResourceReserver(overcommitRatio)
+	if err != nil {
+		shutdown.Fatal(err)
+	}
+	if reserver != nil {
+		log.Info("enforcing job resource reservations", "overcommit_ratio", overcommitRatio, "memory", reserver.memory, "cpu", reserver.cpu)
+	}
+
+	statsHistory := NewStatsHistory(backend, logger)
+	go statsHistory.Run()
+	shutdown.BeforeExit(statsHistory.Shutdown)
+
+	if metricsExport != nil {
+		metricsExporter := NewMetricsExporter(hostID, *metricsExport, backend, state, logger)
+		go metricsExporter.Run()
+		shutdown.BeforeExit(metricsExporter.Shutdown)
+	}
+
+	resourceMonitor := NewResourceMonitor(backend, webhookDisp, thresholds, logger)
+	go resourceMonitor.Run()
+	shutdown.BeforeExit(resourceMonitor.Shutdown)
+
 	host := &Host{
 		id:  hostID,
 		url: publishURL,
@@ -370,9 +549,29 @@ func runDaemon(args *docopt.Args) {
 		volAPI: 					 volumeapi.NewHTTPAPI(vman),
 		discMan:					 discoverdManager,
 		log:    					 logger.New("host.id", hostID),
-		authKey:					 authKey,
-		webhookDispatcher: webhookDisp,
-		maxJobConcurrency: maxJobConcurrency,
+		authKeys:           authKeys,
+		tls:                hostTLS,
+		rateLimits:         rateLimits,
+		webhookDispatcher:  webhookDisp,
+		maxJobConcurrency:  maxJobConcurrency,
+		reserver:           reserver,
+		coreDumps:          coreDumps,
+		statsHistory:       statsHistory,
+		logMux:             mux,
+		maintenanceWindows: maintenanceWindows,
+	}
+	if resolvedDNS {
+		host.resolvedLink = bridgeName
+	}
+	if diskThresholds.RejectPercent > 0 || diskThresholds.EvictPercent > 0 {
+		host.diskWatcher = NewDiskPressureWatcher(host, webhookDisp, diskThresholds, logger)
+		go host.diskWatcher.Run()
+		shutdown.BeforeExit(host.diskWatcher.Shutdown)
+	}
+	host.layerGC = NewLayerGC(host, layerGCRetain, layerGCInterval, logger)
+	if layerGCInterval > 0 {
+		go host.layerGC.Run()
+		shutdown.BeforeExit(host.layerGC.Shutdown)
 	}
 	backend.SetHost(host)
 
@@ -386,10 +585,13 @@ func runDaemon(args *docopt.Args) {
 		// keep the same tags as the parent
 		discoverdManager.UpdateTags(host.status.Tags)
 	}
+	host.advertiseLayers()
 	pid := os.Getpid()
 	log.Info("setting host status PID", "pid", pid)
 	host.status.PID = pid
 	host.status.Version = version.String()
+	host.status.Profiles = profiles
+	host.status.Lite = lite
 	if len(os.Args) > 2 {
 		host.status.Flags = os.Args[2:]
 	}
@@ -401,7 +603,15 @@ func runDaemon(args *docopt.Args) {
 		shutdown.Fatal(err)
 	}
 	host.listener = l
-	shutdown.BeforeExit(func() { host.Close() })
+	// stop serving requests once the host is no longer advertised in
+	// discoverd, but before the state databases are closed by the
+	// BeforeExit functions
+	shutdown.AddHook(shutdown.Hook{
+		Name:    "close-http",
+		After:   []string{"deregister-cluster-monitor", "deregister-discovery-service"},
+		Timeout: 10 * time.Second,
+		Func:    func() { host.Close() },
+	})
 
 	// if we have a control socket FD, wait for a "resume" message before
 	// opening state DBs and serving requests.
@@ -439,6 +649,10 @@ func runDaemon(args *docopt.Args) {
 		log.Error("error opening state databases", "err", err)
 		shutdown.Fatal(err)
 	}
+	if err := authKeys.Restore(state.AuthKeys(), state.PersistAuthKeys); err != nil {
+		log.Error("error replacing persisted auth keys with their hashes", "err", err)
+		shutdown.Fatal(err)
+	}
 
 	// stopJobs stops all jobs, leaving discoverd until the end so other
 	// jobs can unregister themselves on shutdown.
@@ -470,6 +684,10 @@ func runDaemon(args *docopt.Args) {
 		log.Error("error restoring state", "err", err)
 		shutdown.Fatal(err)
 	}
+	if !args.Bool["--no-resurrect"] && len(resurrect) > 0 {
+		host.boot = NewBootOrchestrator(state, host.discMan, logger)
+		shutdown.BeforeExit(host.boot.Shutdown)
+	}
 	// Intentionally do NOT stop jobs or unregister from discoverd on exit.
 	// Job containers are independent processes (no Pdeathsig) and the systemd
 	// unit uses KillMode=process, so they survive the daemon exiting. Leaving
@@ -481,6 +699,11 @@ func runDaemon(args *docopt.Args) {
 	// which tore down every container on each restart and forced a full
 	// resurrection (postgres re-clone, sirenia re-election, etc.).
 
+	if liteNetwork != nil {
+		log.Info("configuring lite mode network", "subnet", liteNetwork.Subnet)
+		host.ConfigureNetworking(liteNetwork)
+	}
+
 	log.Info("serving HTTP requests")
 	host.ServeHTTP()
 	webhookDisp.Send("D10", "Daemon started", "info", "", nil, nil)
@@ -535,7 +758,7 @@ func runDaemon(args *docopt.Args) {
 			log.Error("error registering with service discovery", "err", err)
 			shutdown.Fatal(err)
 		}
-		shutdown.BeforeExit(func() { hb.Close() })
+		shutdown.AddHook(shutdown.Hook{Name: "deregister-discovery-service", Func: func() { hb.Close() }})
 
 		log.Info("determining cluster size", "service", discoveryService)
 		meta, err := discoverd.NewService(discoveryService).GetMeta()
@@ -571,9 +794,9 @@ func runDaemon(args *docopt.Args) {
 		log.Info("no cluster peers available")
 	}
 
-	if !args.Bool["--no-resurrect"] {
-		log.Info("resurrecting jobs")
-		resurrect()
+	if host.boot != nil {
+		log.Info("resurrecting jobs", "count", len(resurrect))
+		go host.boot.Run(resurrect)
 	}
 
 	monitor := NewMonitor(host.discMan, externalIP, logger)
@@ -1,10 +1,12 @@
 package main
 
 import (
-	"crypto/subtle"
+	"bufio"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"os"
@@ -12,17 +14,21 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/flynn/host/downloader"
 	"github.com/flynn/flynn/host/logmux"
+	"github.com/flynn/flynn/host/tuning"
 	host "github.com/flynn/flynn/host/types"
 	volumeapi "github.com/flynn/flynn/host/volume/api"
 	volumemanager "github.com/flynn/flynn/host/volume/manager"
 	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/pkg/installsource"
 	"github.com/flynn/flynn/pkg/keepalive"
+	"github.com/flynn/flynn/pkg/maintenance"
 	"github.com/flynn/flynn/pkg/random"
 	"github.com/flynn/flynn/pkg/shutdown"
 	"github.com/flynn/flynn/pkg/sse"
@@ -46,14 +52,56 @@ type Host struct {
 	discoverdOnce sync.Once
 	networkOnce   sync.Once
 
+	// resolvedLink is the link to register the discoverd DNS server on
+	// with systemd-resolved, or empty if registration is disabled
+	resolvedLink       string
+	resolvedMtx        sync.Mutex
+	resolvedRegistered string
+
 	listener net.Listener
 
 	maxJobConcurrency uint64
 
-	authKey string
-	
+	// reserver rejects jobs which would oversubscribe the host, and is
+	// nil if reservations are not enforced
+	reserver *resourceReserver
+
+	authKeys *authKeySet
+
+	// tls is set if the API is served over TLS
+	tls *hostTLS
+
+	// rateLimits are applied to clients without a valid auth key by
+	// rateLimiter, which is only set when authentication is enabled
+	rateLimits  hostRateLimits
+	rateLimiter *hostRateLimiter
+
 	webhookDispatcher *WebhookDispatcher
 
+	coreDumps *CoreDumpManager
+
+	statsHistory *StatsHistory
+
+	// logMux buffers job output so it can be streamed with GetJobLogs
+	logMux *logmux.Mux
+
+	drainer hostDrainer
+
+	// diskWatcher rejects and evicts jobs when the host is low on disk
+	// space, and is nil if disabled
+	diskWatcher *DiskPressureWatcher
+
+	// layerGC removes unused layers from the layer cache
+	layerGC *LayerGC
+
+	// boot resurrects persistent jobs in dependency order after the
+	// daemon restarts, and is nil if resurrection is disabled
+	boot *BootOrchestrator
+
+	// maintenanceWindows are reported in the host status so updaters can
+	// prefer restarting the host during them
+	maintenanceWindows []*maintenance.Window
+
 	log log15.Logger
 }
 
@@ -66,20 +114,19 @@ func hostAuthKeyFromRequest(r *http.Request) string {
 	return key
 }
 
-// authKeyValid reports whether key matches the configured host API secret.
+// authKeyValid reports whether key matches one of the host API secrets.
 func (h *Host) authKeyValid(key string) bool {
-	if h.authKey == "" || key == "" || len(key) != len(h.authKey) {
-		return false
-	}
-	return subtle.ConstantTimeCompare([]byte(key), []byte(h.authKey)) == 1
+	return h.authKeys.Valid(key)
 }
 
 // authMiddleware wraps an http.Handler and requires a valid Auth-Key header
-// or Basic auth password matching the host's authKey. If no authKey is
-// configured, all requests are allowed (backwards compatibility).
+// or Basic auth password matching one of the host's auth keys, or a client
+// certificate signed by the host CA if TLS client verification is enabled.
+// If neither is configured, all requests are allowed (backwards
+// compatibility).
 func (h *Host) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if h.authKey == "" {
+		if !h.authEnabled() {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -90,86 +137,31 @@ func (h *Host) authMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		if !h.authKeyValid(hostAuthKeyFromRequest(r)) {
-			w.Header().Set("WWW-Authenticate", `Basic realm="flynn-host"`)
-			httphelper.Error(w, httphelper.JSONError{
-				Code:    httphelper.UnauthorizedErrorCode,
-				Message: "authentication required",
-			})
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
-}
-
-// SEC-017: perIPRateLimiter tracks request counts per client IP to prevent
-// API abuse and denial-of-service attacks.
-type perIPRateLimiter struct {
-	mu       sync.Mutex
-	requests map[string]int
-	limit    int
-	window   time.Duration
-}
-
-func newPerIPRateLimiter(limit int, window time.Duration) *perIPRateLimiter {
-	rl := &perIPRateLimiter{
-		requests: make(map[string]int),
-		limit:    limit,
-		window:   window,
-	}
-	go func() {
-		for range time.Tick(window) {
-			rl.mu.Lock()
-			rl.requests = make(map[string]int)
-			rl.mu.Unlock()
-		}
-	}()
-	return rl
-}
-
-func (rl *perIPRateLimiter) Allow(ip string) bool {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-	rl.requests[ip]++
-	return rl.requests[ip] <= rl.limit
-}
-
-func (h *Host) rateLimitMiddleware(next http.Handler) http.Handler {
-	// Without host HTTP authentication, every client looks the same to us and the
-	// controller issues many requests from one address — a global limit breaks
-	// flynn run, pg psql, deploys, etc. Enable FLYNN_HOST_AUTH_KEY on flynn-host
-	// (and the same value on the controller) to apply limits only to clients that
-	// do not present the key.
-	if h.authKey == "" {
-		return next
-	}
-	limiter := newPerIPRateLimiter(100, time.Minute) // 100 requests per minute per IP
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Exempt health checks from rate limiting
-		if r.URL.Path == "/host/status" && r.Method == "GET" {
-			next.ServeHTTP(w, r)
-			return
-		}
-		// Requests authenticated with the host API key are trusted (controller,
-		// CLI via controller, internal tooling). Per-IP limits still apply to
-		// missing or wrong credentials so brute-force attempts remain throttled.
-		if h.authKey != "" && h.authKeyValid(hostAuthKeyFromRequest(r)) {
+		// Allow peers to fetch cached layers
+		if strings.HasPrefix(r.URL.Path, layerPathPrefix) && r.Method == "GET" {
 			next.ServeHTTP(w, r)
 			return
 		}
-		ip, _, _ := net.SplitHostPort(r.RemoteAddr)
-		if ip == "" {
-			ip = r.RemoteAddr
-		}
-		if !limiter.Allow(ip) {
+
+		if !h.authenticated(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="flynn-host"`)
+			msg := "authentication required"
+			key := hostAuthKeyFromRequest(r)
+			if retiredAt, ok := h.authKeys.Retired(key); ok {
+				// a client which was not given the new key
+				// after a rotation will fail every request, so
+				// say why rather than just refusing it
+				fingerprint := authKeyFingerprint(key)
+				h.log.Error("request used a retired auth key, update the client's FLYNN_HOST_AUTH_KEY", "fingerprint", fingerprint, "retired_at", retiredAt, "remote_addr", r.RemoteAddr, "path", r.URL.Path)
+				msg = fmt.Sprintf("auth key %s was retired by a rotation at %s, set FLYNN_HOST_AUTH_KEY to the current key (see 'flynn-host auth-key rotate')", fingerprint, retiredAt.Format(time.RFC3339))
+			}
 			httphelper.Error(w, httphelper.JSONError{
-				Code:    httphelper.RatelimitedErrorCode,
-				Message: "too many requests, try again later",
-				Retry:   true,
+				Code:    httphelper.UnauthorizedErrorCode,
+				Message: msg,
 			})
 			return
 		}
+
 		next.ServeHTTP(w, r)
 	})
 }
@@ -311,6 +303,36 @@ func (h *Host) ConfigureDiscoverd(config *host.DiscoverdConfig) {
 	if config.URL != "" {
 		h.volAPI.ConfigureClusterClient(config.URL)
 	}
+
+	if config.DNS != "" && h.resolvedLink != "" {
+		h.registerResolved(config.DNS)
+	}
+}
+
+// registerResolved registers the discoverd DNS server with systemd-resolved
+// as the server for the .discoverd routing domain. Failures are logged but
+// not fatal since the host itself does not depend on it.
+func (h *Host) registerResolved(dnsAddr string) {
+	log := h.log.New("fn", "registerResolved", "link", h.resolvedLink, "dns", dnsAddr)
+
+	h.resolvedMtx.Lock()
+	defer h.resolvedMtx.Unlock()
+	if h.resolvedRegistered == dnsAddr {
+		return
+	}
+	log.Info("registering discoverd DNS with systemd-resolved")
+	if err := registerResolved(h.resolvedLink, dnsAddr); err != nil {
+		log.Error("error registering discoverd DNS with systemd-resolved", "err", err)
+		return
+	}
+	if h.resolvedRegistered == "" {
+		shutdown.BeforeExit(func() {
+			if err := unregisterResolved(h.resolvedLink); err != nil {
+				log.Error("error unregistering discoverd DNS from systemd-resolved", "err", err)
+			}
+		})
+	}
+	h.resolvedRegistered = dnsAddr
 }
 
 type jobAPI struct {
@@ -331,7 +353,7 @@ func (h *jobAPI) ListJobs(w http.ResponseWriter, r *http.Request, ps httprouter.
 	} else {
 		jobs = h.host.state.Get()
 	}
-	httphelper.JSON(w, 200, jobs)
+	httphelper.ConditionalJSON(w, r, jobs)
 }
 
 func (h *jobAPI) GetJob(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -393,9 +415,25 @@ func (h *jobAPI) SignalJob(w http.ResponseWriter, r *http.Request, ps httprouter
 	w.WriteHeader(200)
 }
 
+// PullImages handles POST /host/pull/images by downloading the images of a
+// release. If the request has a body, it is the images manifest of the
+// release, which is used instead of downloading the manifest so that hosts
+// can pull a release whose layers are served by their peers.
 func (h *jobAPI) PullImages(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	log := h.host.log.New("fn", "PullImages")
+	body, err := io.ReadAll(r.Body)
 	r.Body.Close()
+	if err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	var images map[string]*ct.Artifact
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &images); err != nil {
+			httphelper.ValidationError(w, "", fmt.Sprintf("invalid images manifest: %s", err))
+			return
+		}
+	}
 
 	query := r.URL.Query()
 	repo := query.Get("repository")
@@ -416,7 +454,15 @@ func (h *jobAPI) PullImages(w http.ResponseWriter, r *http.Request, ps httproute
 		d = downloader.New(repo, h.host.vman, query.Get("version"), log)
 		log.Info("pulling images from GitHub", "repo", repo, "version", query.Get("version"))
 	}
-	if err := d.DownloadImages(query.Get("config-dir"), info); err != nil {
+	d.SetLayerPeers(h.host.layerPeers())
+	if images != nil {
+		err = d.DownloadManifestImages(images, info)
+	} else {
+		err = d.DownloadImages(query.Get("config-dir"), info)
+	}
+	// advertise the downloaded layers even if some failed
+	h.host.advertiseLayers()
+	if err != nil {
 		log.Error("error pulling images", "err", err)
 		stream.CloseWithError(err)
 		return
@@ -525,6 +571,19 @@ func (h *jobAPI) AddJob(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 		return
 	}
 
+	// keep accepting system jobs under disk pressure so that the
+	// cluster can recover
+	if h.host.diskWatcher.Rejecting() && !isSystemJob {
+		log.Warn("rejecting job due to disk pressure")
+		httphelper.Error(w, httphelper.JSONError{
+			Code:    httphelper.ServiceUnavailableErrorCode,
+			Message: "host disk usage is critical, not accepting new jobs",
+			Retry:   true,
+		})
+		h.addJobRateLimitBucket.Put()
+		return
+	}
+
 	if len(job.Mountspecs) == 0 {
 		log.Warn("rejecting job as no mountspecs set")
 		httphelper.ValidationError(w, "mountspecs", "must be set")
@@ -540,7 +599,7 @@ func (h *jobAPI) AddJob(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 		return
 	}
 
-	if err := h.host.state.AddJob(job); err != nil {
+	if err := h.host.reserver.AddJob(h.host.state, job); err != nil {
 		log.Error("error adding job to state database", "err", err)
 		if err == ErrJobExists {
 			httphelper.ConflictError(w, err.Error())
@@ -599,8 +658,53 @@ func (h *jobAPI) ConfigureNetworking(w http.ResponseWriter, r *http.Request, _ h
 
 func (h *jobAPI) GetStatus(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	h.host.statusMtx.RLock()
-	defer h.host.statusMtx.RUnlock()
-	httphelper.JSON(w, 200, &h.host.status)
+	status := *h.host.status
+	h.host.statusMtx.RUnlock()
+	status.Warnings = tuning.NewAdvisor("/").Check()
+	if h.host.rateLimiter != nil {
+		status.RateLimits = h.host.rateLimiter.Status()
+	}
+	if len(h.host.maintenanceWindows) > 0 {
+		status.Maintenance = maintenanceStatus(h.host.maintenanceWindows, time.Now())
+	}
+	status.Drain = h.host.drainer.Status()
+	if h.host.reserver != nil {
+		status.Reservations = h.host.reserver.Status(h.host.state)
+	}
+	if h.host.diskWatcher != nil {
+		status.DiskPressure = h.host.diskWatcher.Status()
+	}
+	status.Boot = h.host.boot.Status()
+	httphelper.JSON(w, 200, &status)
+}
+
+// GetUpdateHistory returns the cluster updates which were run from this host.
+func (h *jobAPI) GetUpdateHistory(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	history, err := installsource.LoadHistory(installsource.DefaultConfigDir)
+	if err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	if history == nil {
+		history = []*installsource.UpdateRecord{}
+	}
+	httphelper.JSON(w, 200, history)
+}
+
+func maintenanceStatus(windows []*maintenance.Window, now time.Time) *host.MaintenanceStatus {
+	status := &host.MaintenanceStatus{Windows: make([]string, len(windows))}
+	for i, w := range windows {
+		status.Windows[i] = w.String()
+	}
+	active, until, next := maintenance.Status(windows, now)
+	status.Active = active
+	if active {
+		status.Until = &until
+	}
+	if !next.IsZero() {
+		status.Next = &next
+	}
+	return status
 }
 
 // GetJobStats returns runtime resource usage stats for a specific job/container.
@@ -615,7 +719,150 @@ func (h *jobAPI) GetJobStats(w http.ResponseWriter, r *http.Request, ps httprout
 		return
 	}
 
-	httphelper.JSON(w, 200, stats)
+	httphelper.ConditionalJSON(w, r, stats)
+}
+
+// GetJobStatsHistory returns the recorded stats samples of a job taken after
+// the optional RFC3339 since parameter, or streams them followed by new
+// samples if the client accepts an event stream.
+func (h *jobAPI) GetJobStatsHistory(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	log := h.host.log.New("fn", "GetJobStatsHistory", "job.id", id)
+
+	var since time.Time
+	if s := r.FormValue("since"); s != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			httphelper.ValidationError(w, "since", "must be an RFC3339 timestamp")
+			return
+		}
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		log.Info("streaming job stats")
+		ch := h.host.statsHistory.Subscribe(id, since)
+		defer h.host.statsHistory.Unsubscribe(id, ch)
+		sse.ServeStream(w, ch, log)
+		return
+	}
+
+	httphelper.ConditionalJSON(w, r, h.host.statsHistory.Get(id, since))
+}
+
+// GetJobFiles streams a tar archive of the file or directory at the path given
+// in the query string from a running job's filesystem.
+func (h *jobAPI) GetJobFiles(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		httphelper.ValidationError(w, "path", "must be set")
+		return
+	}
+	root, ok := h.jobRoot(w, id)
+	if !ok {
+		return
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(copyFromRoot(root, path, pw))
+	}()
+	defer pr.Close()
+
+	// read the start of the archive before writing the response header so
+	// that errors such as a missing path are returned as errors
+	buf := bufio.NewReader(pr)
+	if _, err := buf.Peek(1); err != nil {
+		if os.IsNotExist(err) {
+			httphelper.ObjectNotFoundError(w, fmt.Sprintf("%s does not exist", path))
+			return
+		}
+		h.host.log.Error("error copying files from job", "fn", "GetJobFiles", "job.id", id, "path", path, "err", err)
+		httphelper.Error(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.WriteHeader(http.StatusOK)
+	buf.WriteTo(w)
+}
+
+// PutJobFiles extracts the tar archive in the request body into the directory
+// at the path given in the query string, or the root, of a running job's
+// filesystem.
+func (h *jobAPI) PutJobFiles(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		path = "/"
+	}
+	root, ok := h.jobRoot(w, id)
+	if !ok {
+		return
+	}
+	if err := extractToRoot(root, path, r.Body); err != nil {
+		h.host.log.Error("error copying files to job", "fn", "PutJobFiles", "job.id", id, "path", path, "err", err)
+		httphelper.Error(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// jobRoot returns the root filesystem path of the given running job, writing
+// an error response and returning false if the job is not running.
+func (h *jobAPI) jobRoot(w http.ResponseWriter, id string) (string, bool) {
+	job := h.host.state.GetJob(id)
+	if job == nil {
+		httphelper.ObjectNotFoundError(w, ErrNotFound.Error())
+		return "", false
+	}
+	root, err := h.host.backend.JobRoot(id)
+	if job.Status != host.StatusRunning || err == host.ErrJobNotRunning {
+		httphelper.Error(w, httphelper.JSONError{
+			Code:    httphelper.PreconditionFailedErrorCode,
+			Message: host.ErrJobNotRunning.Error(),
+		})
+		return "", false
+	} else if err != nil {
+		httphelper.Error(w, err)
+		return "", false
+	}
+	return root, true
+}
+
+// ListCoreDumps lists the core dumps captured from a job.
+func (h *jobAPI) ListCoreDumps(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	if h.host.state.GetJob(id) == nil {
+		httphelper.ObjectNotFoundError(w, ErrNotFound.Error())
+		return
+	}
+	dumps, err := h.host.coreDumps.List(id)
+	if err != nil {
+		h.host.log.Error("error listing core dumps", "fn", "ListCoreDumps", "job.id", id, "err", err)
+		httphelper.Error(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, dumps)
+}
+
+// GetCoreDump streams a core dump captured from a job.
+func (h *jobAPI) GetCoreDump(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	if h.host.state.GetJob(id) == nil {
+		httphelper.ObjectNotFoundError(w, ErrNotFound.Error())
+		return
+	}
+	f, err := h.host.coreDumps.Open(id, ps.ByName("name"))
+	if err == ErrCoreDumpNotFound {
+		httphelper.ObjectNotFoundError(w, err.Error())
+		return
+	} else if err != nil {
+		h.host.log.Error("error opening core dump", "fn", "GetCoreDump", "job.id", id, "err", err)
+		httphelper.Error(w, err)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", time.Time{}, f)
 }
 
 // GetAllJobsStats returns runtime resource usage stats for all jobs on this host.
@@ -629,7 +876,7 @@ func (h *jobAPI) GetAllJobsStats(w http.ResponseWriter, r *http.Request, _ httpr
 		return
 	}
 
-	httphelper.JSON(w, 200, stats)
+	httphelper.ConditionalJSON(w, r, stats)
 }
 
 // GetHostStats returns aggregated resource usage stats for the host.
@@ -643,7 +890,7 @@ func (h *jobAPI) GetHostStats(w http.ResponseWriter, r *http.Request, _ httprout
 		return
 	}
 
-	httphelper.JSON(w, 200, stats)
+	httphelper.ConditionalJSON(w, r, stats)
 }
 
 func (h *jobAPI) UpdateTags(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
@@ -669,40 +916,26 @@ func (h *Host) UpdateTags(tags map[string]string) error {
 	return nil
 }
 
-func checkPort(port host.Port) bool {
-	l, err := net.Listen(port.Proto, fmt.Sprintf(":%d", port.Port))
-	if err != nil {
-		return false
-	}
-	l.Close()
-	return true
-}
-
 func (h *jobAPI) ResourceCheck(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	var req host.ResourceCheck
 	if err := httphelper.DecodeJSON(r, &req); err != nil {
 		httphelper.Error(w, err)
 		return
 	}
-	var conflicts []host.Port
-	for _, p := range req.Ports {
-		if p.Proto == "" {
-			p.Proto = "tcp"
-		}
-		if !checkPort(p) {
-			conflicts = append(conflicts, p)
-		}
+	failed, err := newResourceChecker(h.host).Check(&req)
+	if err != nil {
+		httphelper.Error(w, err)
+		return
 	}
-	if len(conflicts) > 0 {
-		resp := host.ResourceCheck{Ports: conflicts}
-		detail, err := json.Marshal(resp)
+	if failed != nil {
+		detail, err := json.Marshal(failed)
 		if err != nil {
 			httphelper.Error(w, err)
 			return
 		}
 		httphelper.JSON(w, 409, &httphelper.JSONError{
 			Code:    httphelper.ConflictErrorCode,
-			Message: "Conflicting resources found",
+			Message: "Conflicting or insufficient resources found",
 			Detail:  detail,
 		})
 		return
@@ -773,20 +1006,40 @@ func (h *jobAPI) RegisterRoutes(r *httprouter.Router) error {
 	r.PUT("/host/jobs/:id/discoverd-deregister", h.DiscoverdDeregisterJob)
 	r.PUT("/host/jobs/:id/signal/:signal", h.SignalJob)
 	r.GET("/host/jobs/:id/stats", h.GetJobStats)
+	r.GET("/host/jobs/:id/stats/history", h.GetJobStatsHistory)
+	r.GET("/host/jobs/:id/logs", h.GetJobLogs)
+	r.GET("/host/jobs/:id/files", h.GetJobFiles)
+	r.PUT("/host/jobs/:id/files", h.PutJobFiles)
+	r.GET("/host/jobs/:id/coredumps", h.ListCoreDumps)
+	r.GET("/host/jobs/:id/coredumps/:name", h.GetCoreDump)
 	r.POST("/host/pull/images", h.PullImages)
+	r.GET(layerPathPrefix+":id", h.GetLayer)
+	r.POST("/host/layer-gc", h.GCLayers)
 	r.POST("/host/pull/binaries", h.PullBinariesAndConfig)
 	r.POST("/host/discoverd", h.ConfigureDiscoverd)
 	r.POST("/host/network", h.ConfigureNetworking)
 	r.GET("/host/status", h.GetStatus)
 	r.GET("/host/stats", h.GetHostStats)
 	r.GET("/host/jobs-stats", h.GetAllJobsStats)
+	r.POST("/host/jobs-bulk/stop", h.BulkStop)
+	r.POST("/host/jobs-bulk/signal", h.BulkSignal)
+	r.GET("/metrics", h.Metrics)
 	r.POST("/host/resource-check", h.ResourceCheck)
 	r.POST("/host/update", h.Update)
+	r.GET("/host/update-history", h.GetUpdateHistory)
 	r.POST("/host/systemctl-restart", h.SystemctlRestart)
 	r.POST("/host/tags", h.UpdateTags)
+	r.POST("/host/drain", h.Drain)
+	r.GET("/host/drain", h.GetDrainStatus)
+	r.DELETE("/host/drain", h.Undrain)
 	r.POST("/host/webhooks", h.AddWebhook)
 	r.GET("/host/webhooks", h.ListWebhooks)
 	r.DELETE("/host/webhooks/:id", h.RemoveWebhook)
+	r.POST("/host/webhooks/:id/test", h.TestWebhook)
+	r.GET("/host/webhooks/:id/deliveries", h.ListWebhookDeliveries)
+	r.POST("/host/webhooks/:id/deliveries/:delivery_id/retry", h.RetryWebhookDelivery)
+	r.GET("/host/auth-keys", h.ListAuthKeys)
+	r.POST("/host/auth-keys/rotate", h.RotateAuthKey)
 	return nil
 }
 
@@ -795,6 +1048,11 @@ func (h *jobAPI) AddWebhook(w http.ResponseWriter, r *http.Request, ps httproute
 		ID      string            `json:"id"`
 		URL     string            `json:"url"`
 		Headers map[string]string `json:"headers,omitempty"`
+		Secret  string            `json:"secret,omitempty"`
+
+		Codes       []string `json:"codes,omitempty"`
+		MinSeverity string   `json:"min_severity,omitempty"`
+		MaxAttempts int      `json:"max_attempts,omitempty"`
 	}
 	if err := httphelper.DecodeJSON(r, &input); err != nil {
 		httphelper.Error(w, err)
@@ -804,25 +1062,43 @@ func (h *jobAPI) AddWebhook(w http.ResponseWriter, r *http.Request, ps httproute
 		httphelper.ValidationError(w, "url", "url is required")
 		return
 	}
+	if input.MinSeverity != "" && host.SeverityLevel(input.MinSeverity) < 0 {
+		httphelper.ValidationError(w, "min_severity", "must be one of info, warning, error or critical")
+		return
+	}
+	if input.MaxAttempts < 0 {
+		httphelper.ValidationError(w, "max_attempts", "must not be negative")
+		return
+	}
 	id := input.ID
 	if id == "" {
 		id = random.UUID()
 	}
 	wh := &host.WebhookConfig{
-		ID:        id,
-		URL:       input.URL,
-		Headers:   input.Headers,
-		CreatedAt: time.Now().UTC(),
+		ID:          id,
+		URL:         input.URL,
+		Headers:     input.Headers,
+		Secret:      input.Secret,
+		Codes:       input.Codes,
+		MinSeverity: input.MinSeverity,
+		MaxAttempts: input.MaxAttempts,
+		CreatedAt:   time.Now().UTC(),
 	}
 	if err := h.host.state.AddWebhook(wh); err != nil {
 		httphelper.Error(w, err)
 		return
 	}
+	wh.HasSecret = wh.Secret != ""
+	wh.Secret = ""
 	httphelper.JSON(w, http.StatusOK, wh)
 }
 
 func (h *jobAPI) ListWebhooks(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	webhooks := h.host.state.ListWebhooks()
+	for _, wh := range webhooks {
+		wh.HasSecret = wh.Secret != ""
+		wh.Secret = ""
+	}
 	httphelper.JSON(w, http.StatusOK, webhooks)
 }
 
@@ -835,10 +1111,67 @@ func (h *jobAPI) RemoveWebhook(w http.ResponseWriter, r *http.Request, ps httpro
 	w.WriteHeader(http.StatusOK)
 }
 
+func (h *jobAPI) TestWebhook(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	for _, wh := range h.host.state.ListWebhooks() {
+		if wh.ID == id {
+			httphelper.JSON(w, http.StatusOK, h.host.webhookDispatcher.Test(wh))
+			return
+		}
+	}
+	httphelper.ObjectNotFoundError(w, fmt.Sprintf("webhook %s does not exist", id))
+}
+
+// ListWebhookDeliveries lists the queued and dead-lettered deliveries to a
+// webhook, optionally filtered by the state query parameter.
+func (h *jobAPI) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	if !h.webhookExists(id) {
+		httphelper.ObjectNotFoundError(w, fmt.Sprintf("webhook %s does not exist", id))
+		return
+	}
+	state := host.WebhookDeliveryState(r.URL.Query().Get("state"))
+	deliveries := make([]*host.WebhookDelivery, 0)
+	for _, d := range h.host.state.ListWebhookDeliveries(id) {
+		if state == "" || d.State == state {
+			deliveries = append(deliveries, d)
+		}
+	}
+	httphelper.JSON(w, http.StatusOK, deliveries)
+}
+
+// RetryWebhookDelivery re-queues a delivery to be attempted immediately.
+func (h *jobAPI) RetryWebhookDelivery(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("delivery_id")
+	delivery, err := h.host.state.GetWebhookDelivery(id)
+	if err == ErrWebhookDeliveryNotFound || err == nil && delivery.WebhookID != ps.ByName("id") {
+		httphelper.ObjectNotFoundError(w, fmt.Sprintf("webhook delivery %s does not exist", id))
+		return
+	} else if err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	if err := h.host.webhookDispatcher.Retry(delivery); err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	httphelper.JSON(w, http.StatusOK, delivery)
+}
+
+func (h *jobAPI) webhookExists(id string) bool {
+	for _, wh := range h.host.state.ListWebhooks() {
+		if wh.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
 func (h *Host) ServeHTTP() {
 	r := httprouter.New()
 
 	r.POST("/attach", newAttachHandler(h.state, h.backend, h.log).ServeHTTP)
+	r.POST("/host/jobs/:id/exec", newExecHandler(h.state, h.backend, h.log).ServeHTTP)
 
 	jobAPI := &jobAPI{
 		host:                  h,
@@ -852,7 +1185,13 @@ func (h *Host) ServeHTTP() {
 
 	// SEC-017: when host auth is enabled, per-IP limit applies only to clients
 	// without a valid Auth-Key / Basic password (controller path is exempt).
-	go http.Serve(h.listener, h.rateLimitMiddleware(h.authMiddleware(httphelper.ContextInjector("host", httphelper.NewRequestLogger(r)))))
+	l := h.listener
+	if h.tls != nil {
+		// wrap the listener here rather than when creating it so that
+		// the underlying socket can still be passed to child processes
+		l = tls.NewListener(l, h.tls.config)
+	}
+	go http.Serve(l, h.rateLimitMiddleware(h.authMiddleware(httphelper.ContextInjector("host", httphelper.NewRequestLogger(r)))))
 }
 
 func (h *Host) OpenDBs() error {
@@ -884,6 +1223,9 @@ func (h *Host) CloseLogs() (host.LogBuffers, error) {
 }
 
 func (h *Host) Close() error {
+	if h.rateLimiter != nil {
+		h.rateLimiter.Close()
+	}
 	if h.listener != nil {
 		return h.listener.Close()
 	}
@@ -911,6 +1253,10 @@ func newHTTPListener(addr string) (net.Listener, error) {
 // RateLimitBucket implements a Token Bucket using a buffered channel
 type RateLimitBucket struct {
 	ch chan struct{}
+
+	// rejected counts calls to Take which failed because the bucket was
+	// empty, it must be accessed atomically
+	rejected uint64
 }
 
 func NewRateLimitBucket(size uint64) *RateLimitBucket {
@@ -924,6 +1270,7 @@ func (r *RateLimitBucket) Take() bool {
 	case r.ch <- struct{}{}:
 		return true
 	default:
+		atomic.AddUint64(&r.rejected, 1)
 		return false
 	}
 }
@@ -937,3 +1284,19 @@ func (r *RateLimitBucket) Wait() {
 func (r *RateLimitBucket) Put() {
 	<-r.ch
 }
+
+// InUse returns the number of tokens currently taken
+func (r *RateLimitBucket) InUse() int {
+	return len(r.ch)
+}
+
+// Size returns the total number of tokens
+func (r *RateLimitBucket) Size() int {
+	return cap(r.ch)
+}
+
+// Rejected returns the number of times Take failed because no tokens were
+// available
+func (r *RateLimitBucket) Rejected() uint64 {
+	return atomic.LoadUint64(&r.rejected)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+
+	host "github.com/flynn/flynn/host/types"
+	. "github.com/flynn/go-check"
+	"github.com/inconshreveable/log15"
+	"github.com/julienschmidt/httprouter"
+)
+
+func (S) TestListJobsConditionalGet(c *C) {
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	state := NewState("abc123", filepath.Join(c.MkDir(), "host-state-db"))
+	c.Assert(state.OpenDB(), IsNil)
+	defer state.CloseDB()
+	for i := 0; i < 20; i++ {
+		c.Assert(state.AddJob(&host.Job{ID: fmt.Sprintf("abc123-job%d", i)}), IsNil)
+	}
+
+	r := httprouter.New()
+	(&jobAPI{host: &Host{state: state, log: logger}}).RegisterRoutes(r)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	// disable transparent decompression to check the response encoding
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	get := func(etag string) *http.Response {
+		req, err := http.NewRequest("GET", srv.URL+"/host/jobs", nil)
+		c.Assert(err, IsNil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		res, err := client.Do(req)
+		c.Assert(err, IsNil)
+		return res
+	}
+
+	res := get("")
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	c.Assert(res.Header.Get("Content-Encoding"), Equals, "gzip")
+	etag := res.Header.Get("ETag")
+	c.Assert(etag, Not(Equals), "")
+	gz, err := gzip.NewReader(res.Body)
+	c.Assert(err, IsNil)
+	var jobs map[string]*host.ActiveJob
+	c.Assert(json.NewDecoder(gz).Decode(&jobs), IsNil)
+	res.Body.Close()
+	c.Assert(jobs, HasLen, 20)
+
+	// unchanged jobs are not sent again
+	res = get(etag)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusNotModified)
+	c.Assert(res.Header.Get("ETag"), Equals, etag)
+
+	// changed jobs are
+	c.Assert(state.AddJob(&host.Job{ID: "abc123-new"}), IsNil)
+	res = get(etag)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	c.Assert(res.Header.Get("ETag"), Not(Equals), etag)
+}
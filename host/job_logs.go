@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	host "github.com/flynn/flynn/host/types"
+	logutils "github.com/flynn/flynn/logaggregator/utils"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/pkg/sse"
+	"github.com/flynn/flynn/pkg/syslog/rfc5424"
+	"github.com/julienschmidt/httprouter"
+)
+
+// GetJobLogs returns a job's output from the host's log buffers, starting
+// with the last tail lines (host.DefaultJobLogTail by default, or all lines
+// if zero). Lines are streamed if the client accepts an event stream, and
+// followed until the job exits if follow is true.
+func (h *jobAPI) GetJobLogs(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	log := h.host.log.New("fn", "GetJobLogs", "job.id", id)
+
+	job := h.host.state.GetJob(id)
+	if job == nil {
+		httphelper.ObjectNotFoundError(w, ErrNotFound.Error())
+		return
+	}
+
+	tail := host.DefaultJobLogTail
+	if s := r.FormValue("tail"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			httphelper.ValidationError(w, "tail", "must be a non-negative integer")
+			return
+		}
+		tail = n
+	}
+	follow := r.FormValue("follow") == "true"
+	streaming := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	if follow && !streaming {
+		httphelper.ValidationError(w, "follow", "requires an event stream")
+		return
+	}
+	if h.host.logMux == nil {
+		httphelper.Error(w, errors.New("job logs are not available"))
+		return
+	}
+
+	msgs := make(chan *rfc5424.Message)
+	stream, err := h.host.logMux.StreamJobLog(job.Job.Metadata["flynn-controller.app"], id, tail, follow, msgs)
+	if err != nil {
+		log.Error("error streaming job logs", "err", err)
+		httphelper.Error(w, err)
+		return
+	}
+	defer stream.Close()
+
+	if !streaming {
+		lines := make([]*host.JobLogLine, 0, tail)
+		for msg := range msgs {
+			lines = append(lines, jobLogLine(msg))
+		}
+		if err := stream.Err(); err != nil {
+			log.Error("error reading job logs", "err", err)
+			httphelper.Error(w, err)
+			return
+		}
+		httphelper.JSON(w, 200, lines)
+		return
+	}
+
+	log.Info("streaming job logs", "tail", tail, "follow", follow)
+	ch := make(chan *host.JobLogLine)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(ch)
+		for msg := range msgs {
+			select {
+			case ch <- jobLogLine(msg):
+			case <-done:
+				return
+			}
+		}
+	}()
+	sse.ServeStream(w, ch, log)
+}
+
+func jobLogLine(msg *rfc5424.Message) *host.JobLogLine {
+	return &host.JobLogLine{
+		Timestamp: msg.Timestamp,
+		Stream:    string(logutils.StreamType(msg)),
+		Msg:       string(msg.Msg),
+	}
+}
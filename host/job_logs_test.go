@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"time"
+
+	"github.com/flynn/flynn/host/logmux"
+	host "github.com/flynn/flynn/host/types"
+	logagg "github.com/flynn/flynn/logaggregator/types"
+	"github.com/flynn/flynn/pkg/random"
+	"github.com/flynn/flynn/pkg/sse"
+	. "github.com/flynn/go-check"
+	"github.com/inconshreveable/log15"
+	"github.com/julienschmidt/httprouter"
+)
+
+func (S) TestGetJobLogs(c *C) {
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+
+	state := NewState("abc123", filepath.Join(c.MkDir(), "host-state-db"))
+	c.Assert(state.OpenDB(), IsNil)
+	defer state.CloseDB()
+
+	appID := random.UUID()
+	jobID := "abc123-" + random.UUID()
+	c.Assert(state.AddJob(&host.Job{ID: jobID, Metadata: map[string]string{"flynn-controller.app": appID}}), IsNil)
+
+	mux := logmux.New("abc123", c.MkDir(), logger)
+	config := &logmux.Config{AppID: appID, HostID: "abc123", JobID: jobID}
+	stdoutR, stdoutW := io.Pipe()
+	stdout := mux.Follow(stdoutR, "", logagg.MsgIDStdout, config)
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(stdoutW, "line %d\n", i)
+	}
+
+	h := &Host{state: state, logMux: mux, log: logger}
+	r := httprouter.New()
+	(&jobAPI{host: h}).RegisterRoutes(r)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	get := func(query string) []*host.JobLogLine {
+		res, err := http.Get(srv.URL + "/host/jobs/" + jobID + "/logs" + query)
+		c.Assert(err, IsNil)
+		defer res.Body.Close()
+		c.Assert(res.StatusCode, Equals, http.StatusOK)
+		var lines []*host.JobLogLine
+		c.Assert(json.NewDecoder(res.Body).Decode(&lines), IsNil)
+		return lines
+	}
+	msgs := func(lines []*host.JobLogLine) []string {
+		res := make([]string, len(lines))
+		for i, l := range lines {
+			c.Assert(l.Stream, Equals, "stdout")
+			res[i] = l.Msg
+		}
+		return res
+	}
+
+	// wait for the lines to be written to the log file
+	for i := 0; i < 100 && len(get("?tail=0")) < 5; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(msgs(get("?tail=0")), DeepEquals, []string{"line 0", "line 1", "line 2", "line 3", "line 4"})
+	c.Assert(msgs(get("?tail=2")), DeepEquals, []string{"line 3", "line 4"})
+	c.Assert(msgs(get("")), HasLen, 5)
+
+	for _, query := range []string{"?tail=-1", "?tail=x", "?follow=true"} {
+		res, err := http.Get(srv.URL + "/host/jobs/" + jobID + "/logs" + query)
+		c.Assert(err, IsNil)
+		res.Body.Close()
+		c.Assert(res.StatusCode, Equals, http.StatusBadRequest)
+	}
+	res, err := http.Get(srv.URL + "/host/jobs/missing/logs")
+	c.Assert(err, IsNil)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusNotFound)
+
+	// following streams the tail followed by new lines until the job exits
+	req, err := http.NewRequest("GET", srv.URL+"/host/jobs/"+jobID+"/logs?tail=2&follow=true", nil)
+	c.Assert(err, IsNil)
+	req.Header.Set("Accept", "text/event-stream")
+	res, err = http.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	defer res.Body.Close()
+	dec := sse.NewDecoder(bufio.NewReader(res.Body))
+	next := func() string {
+		var line host.JobLogLine
+		c.Assert(dec.Decode(&line), IsNil)
+		return line.Msg
+	}
+	c.Assert(next(), Equals, "line 3")
+	c.Assert(next(), Equals, "line 4")
+	fmt.Fprintln(stdoutW, "line 5")
+	c.Assert(next(), Equals, "line 5")
+	stdoutW.Close()
+	stdout.Close()
+	var line host.JobLogLine
+	c.Assert(dec.Decode(&line), Equals, io.EOF)
+}
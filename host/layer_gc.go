@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/flynn/flynn/host/downloader"
+	host "github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/inconshreveable/log15"
+	"github.com/julienschmidt/httprouter"
+)
+
+// LayerGC removes layers from the layer cache which are no longer used by
+// installed releases or jobs on the host.
+type LayerGC struct {
+	host     *Host
+	retain   int
+	interval time.Duration
+	done     chan struct{}
+	log      log15.Logger
+}
+
+// NewLayerGC returns a LayerGC which keeps the layers of the given number of
+// previously installed releases, collecting every interval when run.
+func NewLayerGC(h *Host, retain int, interval time.Duration, log log15.Logger) *LayerGC {
+	return &LayerGC{
+		host:     h,
+		retain:   retain,
+		interval: interval,
+		done:     make(chan struct{}),
+		log:      log.New("component", "layer-gc"),
+	}
+}
+
+// Run collects unused layers every interval until Shutdown is called.
+func (g *LayerGC) Run() {
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := g.Collect(nil, false); err == downloader.ErrNoManifests {
+				g.log.Info("skipping layer garbage collection", "reason", err)
+			} else if err != nil {
+				g.log.Error("error collecting unused layers", "err", err)
+			}
+		case <-g.done:
+			return
+		}
+	}
+}
+
+// Shutdown stops collecting.
+func (g *LayerGC) Shutdown() {
+	close(g.done)
+}
+
+// Collect removes the layers which are not used by the latest installed
+// release, retain previously installed releases (defaulting to the
+// configured retention) or any job in the host state.
+func (g *LayerGC) Collect(retain *int, dryRun bool) (*host.LayerGCResult, error) {
+	n := g.retain
+	if retain != nil {
+		n = *retain
+	}
+	inUse := make(map[string]bool)
+	for _, job := range g.host.state.Get() {
+		if job.Job == nil {
+			continue
+		}
+		for _, m := range job.Job.Mountspecs {
+			inUse[m.ID] = true
+		}
+	}
+	res, err := downloader.GCLayers(n, inUse, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	g.log.Info("collected unused layers", "retain", n, "dry_run", dryRun, "removed", len(res.Removed), "removed_bytes", res.RemovedBytes, "kept", res.Kept)
+	if !dryRun && len(res.Removed) > 0 {
+		g.host.advertiseLayers()
+	}
+	return res, nil
+}
+
+// GCLayers handles POST /host/layer-gc by removing unused layers from the
+// layer cache.
+func (h *jobAPI) GCLayers(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req host.LayerGCRequest
+	if err := httphelper.DecodeJSON(r, &req); err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	if req.Retain != nil && *req.Retain < 0 {
+		httphelper.ValidationError(w, "retain", "must not be negative")
+		return
+	}
+	res, err := h.host.layerGC.Collect(req.Retain, req.DryRun)
+	if err == downloader.ErrNoManifests {
+		httphelper.Error(w, httphelper.PreconditionFailedErr(err.Error()))
+		return
+	} else if err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, res)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flynn/flynn/discoverd/client"
+	"github.com/flynn/flynn/host/downloader"
+	host "github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/julienschmidt/httprouter"
+)
+
+// layerPathPrefix is the path prefix of layers served to peers, which is
+// exempt from authentication as layers are public release assets which
+// peers verify against the image manifest
+const layerPathPrefix = "/host/layers/"
+
+// validLayerID returns whether id is safe to use as a layer file name
+func validLayerID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// GetLayer handles GET /host/layers/:id by serving a layer from the layer
+// cache, supporting Range requests so peers can resume downloads. The size
+// of the layer and its hashes from the recorded image manifests are set in
+// the LayerSizeHeader and LayerHashesHeader headers.
+func (h *jobAPI) GetLayer(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	if !validLayerID(id) {
+		httphelper.ValidationError(w, "id", "is invalid")
+		return
+	}
+	f, err := os.Open(downloader.LayerPath(id))
+	if os.IsNotExist(err) {
+		httphelper.ObjectNotFoundError(w, "layer not found")
+		return
+	} else if err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set(host.LayerSizeHeader, strconv.FormatInt(info.Size(), 10))
+	if layer, err := downloader.ManifestLayer(id); err != nil {
+		h.host.log.Error("error looking up layer in image manifests", "fn", "GetLayer", "layer", id, "err", err)
+	} else if layer != nil && len(layer.Hashes) > 0 {
+		w.Header().Set(host.LayerHashesHeader, formatLayerHashes(layer.Hashes))
+	}
+	http.ServeContent(w, r, "", time.Time{}, f)
+}
+
+// formatLayerHashes formats layer hashes for the LayerHashesHeader header
+func formatLayerHashes(hashes map[string]string) string {
+	pairs := make([]string, 0, len(hashes))
+	for algo, hash := range hashes {
+		pairs = append(pairs, algo+"="+hash)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// advertiseLayers advertises the layers in the layer cache in the host's
+// discoverd metadata so that peers can fetch them from this host. Layers
+// are not advertised if the host API uses TLS, as peers fetch them over
+// plain HTTP.
+func (h *Host) advertiseLayers() {
+	if h.tls != nil || h.discMan == nil {
+		return
+	}
+	ids, err := downloader.CachedLayers()
+	if err != nil {
+		h.log.Error("error listing cached layers", "fn", "advertiseLayers", "err", err)
+		return
+	}
+	if err := h.discMan.SetLayers(ids); err != nil {
+		h.log.Error("error advertising cached layers", "fn", "advertiseLayers", "err", err)
+	}
+}
+
+// layerPeers returns a function which returns the base URLs of the other
+// hosts advertising a layer, looking up the hosts in discoverd on first
+// use. If the lookup fails, no peers are returned.
+func (h *Host) layerPeers() func(layerID string) []string {
+	var once sync.Once
+	peers := make(map[string][]string)
+	return func(layerID string) []string {
+		once.Do(func() {
+			instances, err := discoverd.GetInstances("flynn-host", 5*time.Second)
+			if err != nil {
+				h.log.Warn("error looking up layer peers", "fn", "layerPeers", "err", err)
+				return
+			}
+			sort.Slice(instances, func(i, j int) bool { return instances[i].Addr < instances[j].Addr })
+			for _, inst := range instances {
+				if inst.Meta["id"] == h.id || inst.Meta[host.LayersMetaKey] == "" {
+					continue
+				}
+				for _, id := range strings.Split(inst.Meta[host.LayersMetaKey], ",") {
+					peers[id] = append(peers[id], "http://"+inst.Addr)
+				}
+			}
+		})
+		return peers[layerID]
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	. "github.com/flynn/go-check"
+)
+
+func (S) TestValidLayerID(c *C) {
+	for _, id := range []string{"5b3e8d0c2b6b4a1f", "layer_1-a"} {
+		c.Assert(validLayerID(id), Equals, true, Commentf("id = %q", id))
+	}
+	for _, id := range []string{"", "..", "../secret", "a/b", "a.squashfs"} {
+		c.Assert(validLayerID(id), Equals, false, Commentf("id = %q", id))
+	}
+}
+
+func (S) TestFormatLayerHashes(c *C) {
+	c.Assert(formatLayerHashes(map[string]string{"sha512_256": "abc", "sha256": "def"}), Equals, "sha256=def,sha512_256=abc")
+}
@@ -41,6 +41,7 @@ import (
 	"github.com/flynn/flynn/pkg/shutdown"
 	"github.com/flynn/flynn/pkg/syslog/rfc5424"
 	"github.com/flynn/flynn/pkg/term"
+	"github.com/flynn/flynn/pkg/tracing"
 	"github.com/flynn/flynn/pkg/verify"
 	"github.com/golang/groupcache/singleflight"
 	"github.com/inconshreveable/log15"
@@ -50,6 +51,7 @@ import (
 	"github.com/opencontainers/runc/libcontainer/apparmor"
 	"github.com/opencontainers/runc/libcontainer/configs"
 	"github.com/opencontainers/runc/libcontainer/seccomp"
+	"github.com/opencontainers/runc/libcontainer/utils"
 	"github.com/rancher/sparse-tools/sparse"
 	"github.com/vishvananda/netlink"
 )
@@ -60,6 +62,14 @@ const (
 	defaultPartition  = "user"
 	defaultMemory     = 1 * units.GiB
 	RLIMIT_NPROC      = 6
+
+	// jobLogTailTimeout bounds how long to wait for a crashed job's log
+	// streams to drain before capturing its final output
+	jobLogTailTimeout = 2 * time.Second
+
+	// execOutputTimeout bounds how long to wait for the output of an exec
+	// process with a TTY after it has exited
+	execOutputTimeout = 2 * time.Second
 )
 
 // safeClientConfigFromFile wraps dns.ClientConfigFromFile with panic recovery
@@ -199,6 +209,15 @@ type Container struct {
 	l         *LibcontainerBackend
 	done      chan struct{}
 
+	// startSpan traces the container starting, it is nil if the job
+	// is not being traced
+	startSpan *tracing.Span
+
+	// createdAt is when the container was created, and is used to record
+	// the time taken for the job to be ready. It is zero for containers
+	// restored after a daemon restart.
+	createdAt time.Time
+
 	// Memory limit tracking
 	softLimitBytes    uint64 // Soft memory limit (memory.high)
 	softLimitLogged  bool   // Whether we've already logged soft limit breach
@@ -442,6 +461,13 @@ func (l *LibcontainerBackend) Run(job *host.Job, runConfig *RunConfig, rateLimit
 
 	log.Info("starting job", "job.args", job.Config.Args)
 
+	// trace the job start if it was launched as part of a traced
+	// operation (e.g. a deploy)
+	span := tracing.StartFromMetadata("host.run_job", job.Metadata)
+	span.SetAttr("job.id", job.ID)
+	span.SetAttr("host.id", l.State.id)
+	defer span.Finish(&err)
+
 	defer func() {
 		if err != nil {
 			l.State.SetStatusFailed(job.ID, err)
@@ -475,6 +501,13 @@ func (l *LibcontainerBackend) Run(job *host.Job, runConfig *RunConfig, rateLimit
 		return fmt.Errorf("host: invalid job partition %q", job.Partition)
 	}
 
+	phaseStart := time.Now()
+	endPhase := func(name string) {
+		now := time.Now()
+		l.State.AddStartPhase(job.ID, name, now.Sub(phaseStart))
+		phaseStart = now
+	}
+
 	wait := func(ch chan struct{}) {
 		if rateLimitBucket != nil {
 			// unblock the rate limiter whilst waiting
@@ -489,6 +522,7 @@ func (l *LibcontainerBackend) Run(job *host.Job, runConfig *RunConfig, rateLimit
 	if _, ok := job.Config.Env["DISCOVERD"]; !ok {
 		wait(l.discoverdConfigured)
 	}
+	endPhase(host.JobStartPhaseWait)
 
 	if runConfig == nil {
 		runConfig = &RunConfig{}
@@ -531,11 +565,15 @@ func (l *LibcontainerBackend) Run(job *host.Job, runConfig *RunConfig, rateLimit
 			return err
 		}
 	}
+	mountSpan := span.Child("host.mount_layers")
+	mountSpan.SetAttr("layers", len(job.Mountspecs))
 	rootMount, diffDir, err := l.rootOverlayMount(job)
+	mountSpan.Finish(&err)
 	if err != nil {
 		log.Error("error setting up rootfs", "err", err)
 		return err
 	}
+	endPhase(host.JobStartPhaseLayers)
 
 	container.RootPath = rootPath
 	container.TmpPath = tmpPath
@@ -786,6 +824,22 @@ func (l *LibcontainerBackend) Run(job *host.Job, runConfig *RunConfig, rateLimit
 		config.Mounts = append(config.Mounts, bindMount(vol.Location(), v.Target, v.Writeable))
 	}
 
+	if job.Config.CoreDumps != nil {
+		dir, err := l.host.coreDumps.Prepare(job.ID)
+		if err != nil {
+			log.Error("error preparing core dump directory", "err", err)
+			return err
+		}
+		config.Mounts = append(config.Mounts, bindMount(dir, coreDumpMountPath, true))
+		// limit the size of each dump to the job's total core dump size
+		maxSize := uint64(coreDumpMaxSize(job.Config.CoreDumps))
+		config.Rlimits = append(config.Rlimits, configs.Rlimit{
+			Type: syscall.RLIMIT_CORE,
+			Hard: maxSize,
+			Soft: maxSize,
+		})
+	}
+
 	// mutating job state, take state write lock
 	l.State.mtx.Lock()
 	if job.Config.Env == nil {
@@ -942,6 +996,15 @@ func (l *LibcontainerBackend) Run(job *host.Job, runConfig *RunConfig, rateLimit
 		config.Cgroups.Resources.CpuWeight = cpuWeight
 	}
 
+	// the start span ends once containerinit reports that the job's
+	// process is running (see Container.watch)
+	container.startSpan = span.Child("host.start_container")
+	defer func() {
+		if err != nil {
+			container.startSpan.Finish(&err)
+		}
+	}()
+
 	c, err := l.factory.Create(job.ID, config)
 	if err != nil {
 		return err
@@ -992,6 +1055,8 @@ func (l *LibcontainerBackend) Run(job *host.Job, runConfig *RunConfig, rateLimit
 		return err
 	}
 	l.State.SetContainerPID(job.ID, pid)
+	endPhase(host.JobStartPhaseCreate)
+	container.createdAt = phaseStart
 
 	container.container = c
 	container.softLimitBytes = softLimitBytes
@@ -1212,6 +1277,7 @@ func (c *Container) watch(ready chan<- error, buffer host.LogBuffer) error {
 		c.l.cpuSampleMtx.Unlock()
 		c.cleanup()
 		close(c.done)
+		c.startSpan.End()
 	}()
 
 	var symlinked bool
@@ -1238,6 +1304,7 @@ func (c *Container) watch(ready chan<- error, buffer host.LogBuffer) error {
 	}
 	if err != nil {
 		log.Error("error connecting to container", "err", err)
+		c.startSpan.SetError(err)
 		readyErr(err)
 		c.l.State.SetStatusFailed(c.job.ID, errors.New("failed to connect to container"))
 		return err
@@ -1265,6 +1332,7 @@ func (c *Container) watch(ready chan<- error, buffer host.LogBuffer) error {
 			defer logger.Close()
 			for range notifyOOM {
 				logger.Crit("FATAL: Container hard memory limit (2x configured limit) exceeded - container killed due to vastly exceeding memory limits")
+				c.l.State.SetOOMKilled(c.job.ID)
 				if wd := c.l.host.webhookDispatcher; wd != nil {
 					wd.Send(host.CodeMemoryHard, "Hard memory limit exceeded (OOM kill)", host.SeverityCritical, c.job.ID, nil, map[string]string{
 						"soft_limit_bytes": fmt.Sprintf("%d", c.softLimitBytes),
@@ -1298,7 +1366,11 @@ func (c *Container) watch(ready chan<- error, buffer host.LogBuffer) error {
 			log.Info("resumed")
 		case containerinit.StateRunning:
 			log.Info("container running")
+			if !c.createdAt.IsZero() {
+				c.l.State.AddStartPhase(c.job.ID, host.JobStartPhaseReady, time.Since(c.createdAt))
+			}
 			c.l.State.SetStatusRunning(c.job.ID)
+			c.startSpan.End()
 
 			// if the job was stopped before it started, exit
 			if c.l.State.GetJob(c.job.ID).ForceStop {
@@ -1307,10 +1379,17 @@ func (c *Container) watch(ready chan<- error, buffer host.LogBuffer) error {
 		case containerinit.StateExited:
 			log.Info("container exited", "status", change.ExitStatus)
 			c.Client.Resume()
+			if change.ExitStatus != 0 {
+				// attach the final output so crash events carry
+				// context without a separate log query
+				c.l.State.SetLogTail(c.job.ID, c.l.LogMux.JobLogTail(c.job.ID, jobLogTailTimeout))
+			}
 			c.l.State.SetStatusDone(c.job.ID, change.ExitStatus)
 			return nil
 		case containerinit.StateFailed:
 			log.Info("container failed to start")
+			c.startSpan.SetError(errors.New("container failed to start"))
+			c.startSpan.End()
 			c.Client.Resume()
 			c.l.State.SetStatusFailed(c.job.ID, errors.New("container failed to start"))
 			return nil
@@ -1622,6 +1701,153 @@ func (l *LibcontainerBackend) Attach(req *AttachRequest) (err error) {
 	return io.EOF
 }
 
+// JobRoot returns the path to the root filesystem of a running job as seen
+// from its mount namespace, so it includes the job's volumes.
+func (l *LibcontainerBackend) JobRoot(id string) (string, error) {
+	container, err := l.getContainer(id)
+	if err != nil || container.container == nil {
+		return "", host.ErrJobNotRunning
+	}
+	state, err := container.container.State()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("/proc/%d/root", state.InitProcessPid), nil
+}
+
+// Exec runs a process in the container of a running job, with the same
+// environment, user and working directory as the job's process.
+func (l *LibcontainerBackend) Exec(req *ExecRequest) (ExecProcess, error) {
+	container, err := l.getContainer(req.JobID)
+	if err != nil || container.container == nil {
+		return nil, host.ErrJobNotRunning
+	}
+	job := container.job
+
+	env := map[string]string{
+		"PATH": "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		"TERM": "xterm",
+		"HOME": "/",
+	}
+	l.envMtx.RLock()
+	for _, e := range []map[string]string{l.defaultEnv, job.Config.Env, {"HOSTNAME": container.Hostname}, req.Env} {
+		for k, v := range e {
+			env[k] = v
+		}
+	}
+	l.envMtx.RUnlock()
+
+	var uid, gid uint32
+	if job.Config.Uid != nil {
+		uid = *job.Config.Uid
+	}
+	if job.Config.Gid != nil {
+		gid = *job.Config.Gid
+	}
+	noNewPriv := true
+	process := &libcontainer.Process{
+		Args:            req.Args,
+		Env:             make([]string, 0, len(env)),
+		User:            fmt.Sprintf("%d:%d", uid, gid),
+		Cwd:             job.Config.WorkingDir,
+		NoNewPrivileges: &noNewPriv,
+	}
+	if process.Cwd == "" {
+		process.Cwd = "/"
+	}
+	for k, v := range env {
+		process.Env = append(process.Env, k+"="+v)
+	}
+
+	p := &execProcess{process: process, done: make(chan struct{})}
+	if !req.TTY {
+		process.Stdin = req.Stdin
+		process.Stdout = req.Stdout
+		process.Stderr = req.Stderr
+		if err := container.container.Run(process); err != nil {
+			return nil, err
+		}
+		close(p.done)
+		return p, nil
+	}
+
+	// receive the pty master from the container over a socket pair
+	parent, child, err := utils.NewSockPair("console")
+	if err != nil {
+		return nil, err
+	}
+	defer parent.Close()
+	process.ConsoleSocket = child
+	err = container.container.Run(process)
+	child.Close()
+	if err != nil {
+		return nil, err
+	}
+	pty, err := utils.RecvFd(parent)
+	if err != nil {
+		process.Signal(syscall.SIGKILL)
+		process.Wait()
+		return nil, err
+	}
+	p.pty = pty
+	if err := p.ResizeTTY(req.Height, req.Width); err != nil {
+		l.Logger.Error("error setting exec TTY size", "fn", "Exec", "job.id", req.JobID, "err", err)
+	}
+	if req.Stdin != nil {
+		go io.Copy(pty, req.Stdin)
+	}
+	go func() {
+		defer close(p.done)
+		out := req.Stdout
+		if out == nil {
+			out = ioutil.Discard
+		}
+		// reading the pty returns EIO once the process has exited
+		io.Copy(out, pty)
+	}()
+	return p, nil
+}
+
+// execProcess is a process started in a container by LibcontainerBackend.Exec.
+type execProcess struct {
+	process *libcontainer.Process
+	pty     *os.File
+
+	// done is closed once the process's TTY output has been copied
+	done chan struct{}
+}
+
+func (p *execProcess) Signal(sig int) error {
+	return p.process.Signal(syscall.Signal(sig))
+}
+
+func (p *execProcess) ResizeTTY(height, width uint16) error {
+	if p.pty == nil {
+		return errors.New("exec process doesn't have a TTY")
+	}
+	return term.SetWinsize(p.pty.Fd(), &term.Winsize{Height: height, Width: width})
+}
+
+func (p *execProcess) Wait() (int, error) {
+	state, err := p.process.Wait()
+	// processes started in the background by the exec process may keep
+	// the TTY open, so don't wait indefinitely for its output
+	select {
+	case <-p.done:
+	case <-time.After(execOutputTimeout):
+	}
+	if p.pty != nil {
+		p.pty.Close()
+	}
+	if state == nil {
+		return -1, err
+	}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal()), nil
+	}
+	return state.ExitCode(), nil
+}
+
 func (l *LibcontainerBackend) Cleanup(except []string) error {
 	log := l.Logger.New("fn", "Cleanup")
 	shouldSkip := func(id string) bool {
@@ -0,0 +1,19 @@
+//go:build lite
+// +build lite
+
+package main
+
+import (
+	"errors"
+
+	"github.com/flynn/flynn/host/volume"
+	"github.com/inconshreveable/log15"
+)
+
+// liteBuild is true for binaries built with the lite tag, which default to
+// lite mode and leave out ZFS support.
+const liteBuild = true
+
+func newZFSProvider(zpoolName, volPath string, log log15.Logger) (volume.Provider, error) {
+	return nil, errors.New("ZFS support is not included in lite builds, use --vol-provider=dir")
+}
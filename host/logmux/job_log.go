@@ -0,0 +1,101 @@
+package logmux
+
+import (
+	"github.com/flynn/flynn/logaggregator/utils"
+	"github.com/flynn/flynn/pkg/stream"
+	"github.com/flynn/flynn/pkg/syslog/rfc5424"
+)
+
+// StreamJobLog streams the last tail messages logged by a job, or all of them
+// if tail is not positive, followed by new messages until the job exits if
+// follow is set.
+func (m *Mux) StreamJobLog(appID, jobID string, tail int, follow bool, ch chan<- *rfc5424.Message) (stream.Stream, error) {
+	if tail <= 0 {
+		return m.StreamLog(appID, jobID, true, follow, ch)
+	}
+
+	history := make(chan *rfc5424.Message)
+	hs, err := m.StreamLog(appID, jobID, true, false, history)
+	if err != nil {
+		return nil, err
+	}
+
+	s := stream.New()
+	go func() {
+		defer close(ch)
+
+		// keep the last tail messages of the history in a ring buffer
+		lines := make([]*rfc5424.Message, tail)
+		var n int
+	read:
+		for {
+			select {
+			case msg, ok := <-history:
+				if !ok {
+					break read
+				}
+				lines[n%tail] = msg
+				n++
+			case <-s.StopCh:
+				hs.Close()
+				return
+			}
+		}
+		if err := hs.Err(); err != nil {
+			s.Error = err
+			return
+		}
+
+		var last *utils.HostCursor
+		start := 0
+		if n > tail {
+			start = n - tail
+		}
+		for i := start; i < n; i++ {
+			msg := lines[i%tail]
+			select {
+			case ch <- msg:
+			case <-s.StopCh:
+				return
+			}
+			if c, err := utils.ParseHostCursor(msg); err == nil {
+				last = c
+			}
+		}
+		if !follow {
+			return
+		}
+
+		// stream the history again so that no messages are missed between
+		// reading it and following, skipping those already sent
+		msgs := make(chan *rfc5424.Message)
+		fs, err := m.StreamLog(appID, jobID, true, true, msgs)
+		if err != nil {
+			s.Error = err
+			return
+		}
+		defer fs.Close()
+		for {
+			select {
+			case msg, ok := <-msgs:
+				if !ok {
+					s.Error = fs.Err()
+					return
+				}
+				if last != nil {
+					if c, err := utils.ParseHostCursor(msg); err == nil && !c.After(*last) {
+						continue
+					}
+				}
+				select {
+				case ch <- msg:
+				case <-s.StopCh:
+					return
+				}
+			case <-s.StopCh:
+				return
+			}
+		}
+	}()
+	return s, nil
+}
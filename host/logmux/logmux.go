@@ -51,6 +51,10 @@ type Mux struct {
 
 	appLogsMtx sync.Mutex
 	appLogs    map[string]*appLog
+
+	// tails stores the most recent output of each job, see JobLogTail
+	tailsMtx sync.Mutex
+	tails    map[string]*logTail
 }
 
 const firehoseApp = "_all"
@@ -64,6 +68,7 @@ func New(hostID, logDir string, logger log15.Logger) *Mux {
 		jobStarts:   make(map[string]chan struct{}),
 		subscribers: make(map[string]map[chan message]struct{}),
 		appLogs:     make(map[string]*appLog),
+		tails:       make(map[string]*logTail),
 	}
 }
 
@@ -317,6 +322,7 @@ func (m *Mux) Follow(r io.ReadCloser, buffer string, msgID logagg.MsgID, config
 		// we created the wg, so create a goroutine to clean up
 		go func() {
 			wg.Wait()
+			m.expireJobTail(config.JobID)
 			m.jobsMtx.Lock()
 			defer m.jobsMtx.Unlock()
 			delete(m.jobWaits, config.JobID)
@@ -330,7 +336,14 @@ func (m *Mux) Follow(r io.ReadCloser, buffer string, msgID logagg.MsgID, config
 		delete(m.jobStarts, config.JobID)
 	}
 
-	go s.follow(r, buffer, config.AppID, hdr, wg)
+	// only application output is useful crash context, so the host's own
+	// init logs are not retained in the job's log tail
+	var tail *logTail
+	if msgID == logagg.MsgIDStdout || msgID == logagg.MsgIDStderr {
+		tail = m.jobTail(config.JobID)
+	}
+
+	go s.follow(r, buffer, config.AppID, hdr, tail, wg)
 	return s
 }
 
@@ -349,7 +362,7 @@ func (s *LogStream) Close() string {
 	return s.buf
 }
 
-func (s *LogStream) follow(r io.Reader, buffer, appID string, h *rfc5424.Header, wg *sync.WaitGroup) {
+func (s *LogStream) follow(r io.Reader, buffer, appID string, h *rfc5424.Header, tail *logTail, wg *sync.WaitGroup) {
 	defer wg.Done()
 	defer close(s.done)
 	l := s.m.appLog(appID)
@@ -379,6 +392,10 @@ func (s *LogStream) follow(r io.Reader, buffer, appID string, h *rfc5424.Header,
 			line = line[:len(line)-1]
 		}
 
+		if tail != nil {
+			tail.Add(string(line))
+		}
+
 		msg := rfc5424.NewMessage(h, line)
 		cursor := &utils.HostCursor{
 			Time: msg.Timestamp,
@@ -492,6 +509,10 @@ func (m *Mux) streamWithHistory(appID, jobID string, follow bool, ch chan<- *rfc
 		return nil, err
 	}
 	if len(logs) == 0 {
+		if !follow {
+			close(ch)
+			return stream.New(), nil
+		}
 		return m.followLog(appID, jobID, ch)
 	}
 
@@ -0,0 +1,112 @@
+package logmux
+
+import (
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// JobLogTailLines is the number of trailing stdout/stderr lines retained per
+// job so they can be attached to the job record if it crashes.
+const JobLogTailLines = 100
+
+// JobLogTailLineLength is the maximum length in bytes of a retained line,
+// longer lines being truncated so that the tail stays small enough to be
+// stored with the job.
+const JobLogTailLineLength = 1024
+
+// jobLogTailRetention is how long a job's log tail is kept after all of its
+// log streams have closed, giving the backend time to collect it once the
+// exit status is known. It is a variable so that tests can shorten it.
+var jobLogTailRetention = time.Minute
+
+// logTail is a fixed size ring buffer of a job's most recent log lines.
+type logTail struct {
+	mtx   sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+func newLogTail(size int) *logTail {
+	return &logTail{lines: make([]string, size)}
+}
+
+// Add appends a line, truncated to JobLogTailLineLength, replacing the oldest
+// line once the buffer is full.
+func (t *logTail) Add(line string) {
+	if len(line) > JobLogTailLineLength {
+		// truncate on a rune boundary so the line stays valid UTF-8
+		n := JobLogTailLineLength
+		for n > 0 && !utf8.RuneStart(line[n]) {
+			n--
+		}
+		line = line[:n]
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.lines[t.next] = line
+	t.next = (t.next + 1) % len(t.lines)
+	if t.next == 0 {
+		t.full = true
+	}
+}
+
+// Lines returns the buffered lines, oldest first.
+func (t *logTail) Lines() []string {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if !t.full {
+		return append([]string(nil), t.lines[:t.next]...)
+	}
+	res := make([]string, 0, len(t.lines))
+	res = append(res, t.lines[t.next:]...)
+	return append(res, t.lines[:t.next]...)
+}
+
+// jobTail returns the log tail for the given job, creating it if necessary.
+func (m *Mux) jobTail(jobID string) *logTail {
+	m.tailsMtx.Lock()
+	defer m.tailsMtx.Unlock()
+	t, ok := m.tails[jobID]
+	if !ok {
+		t = newLogTail(JobLogTailLines)
+		m.tails[jobID] = t
+	}
+	return t
+}
+
+// expireJobTail removes a job's log tail after jobLogTailRetention.
+func (m *Mux) expireJobTail(jobID string) {
+	time.AfterFunc(jobLogTailRetention, func() {
+		m.tailsMtx.Lock()
+		defer m.tailsMtx.Unlock()
+		delete(m.tails, jobID)
+	})
+}
+
+// JobLogTail returns up to JobLogTailLines of the job's most recent stdout and
+// stderr output, oldest first. It waits up to timeout for the job's log
+// streams to close so that output written just before the job exited is
+// included.
+func (m *Mux) JobLogTail(jobID string, timeout time.Duration) []string {
+	stop := make(chan struct{})
+	defer close(stop)
+	m.jobsMtx.Lock()
+	_, following := m.jobWaits[jobID]
+	m.jobsMtx.Unlock()
+	if following {
+		select {
+		case <-m.jobDoneCh(jobID, stop):
+		case <-time.After(timeout):
+		}
+	}
+
+	m.tailsMtx.Lock()
+	t, ok := m.tails[jobID]
+	m.tailsMtx.Unlock()
+	if !ok {
+		return nil
+	}
+	return t.Lines()
+}
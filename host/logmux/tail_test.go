@@ -0,0 +1,118 @@
+package logmux
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	logagg "github.com/flynn/flynn/logaggregator/types"
+	"github.com/inconshreveable/log15"
+)
+
+func TestLogTailWraparound(t *testing.T) {
+	tail := newLogTail(3)
+	if lines := tail.Lines(); len(lines) != 0 {
+		t.Fatalf("expected no lines, got %v", lines)
+	}
+	tail.Add("1")
+	tail.Add("2")
+	if lines := tail.Lines(); !reflect.DeepEqual(lines, []string{"1", "2"}) {
+		t.Fatalf("unexpected lines before the buffer is full: %v", lines)
+	}
+	tail.Add("3")
+	if lines := tail.Lines(); !reflect.DeepEqual(lines, []string{"1", "2", "3"}) {
+		t.Fatalf("unexpected lines when the buffer is full: %v", lines)
+	}
+
+	// the oldest lines are replaced, and lines are still returned oldest
+	// first
+	tail.Add("4")
+	tail.Add("5")
+	if lines := tail.Lines(); !reflect.DeepEqual(lines, []string{"3", "4", "5"}) {
+		t.Fatalf("unexpected lines after wrapping around: %v", lines)
+	}
+	tail.Add("6")
+	if lines := tail.Lines(); !reflect.DeepEqual(lines, []string{"4", "5", "6"}) {
+		t.Fatalf("unexpected lines after wrapping around the whole buffer: %v", lines)
+	}
+
+	// the returned lines are a copy
+	lines := tail.Lines()
+	lines[0] = "x"
+	if lines := tail.Lines(); lines[0] != "4" {
+		t.Fatalf("expected Lines to return a copy, got %v", lines)
+	}
+}
+
+func TestLogTailTruncatesLongLines(t *testing.T) {
+	tail := newLogTail(3)
+	exact := strings.Repeat("a", JobLogTailLineLength)
+	tail.Add(exact)
+	tail.Add(exact + "b")
+	// a multi-byte rune straddling the limit is dropped rather than split
+	tail.Add(strings.Repeat("a", JobLogTailLineLength-1) + "é")
+
+	lines := tail.Lines()
+	if lines[0] != exact {
+		t.Fatalf("expected a line of the maximum length to be kept, got %d bytes", len(lines[0]))
+	}
+	if lines[1] != exact {
+		t.Fatalf("expected a long line to be truncated, got %d bytes", len(lines[1]))
+	}
+	if lines[2] != exact[:JobLogTailLineLength-1] {
+		t.Fatalf("expected the line to be truncated before the rune, got %d bytes", len(lines[2]))
+	}
+}
+
+func TestJobLogTailExpiry(t *testing.T) {
+	defer func(d time.Duration) { jobLogTailRetention = d }(jobLogTailRetention)
+	jobLogTailRetention = 50 * time.Millisecond
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	mux := New("host", t.TempDir(), logger)
+	config := &Config{AppID: "app", HostID: "host", JobID: "job"}
+
+	stdoutR, stdoutW := io.Pipe()
+	stdout := mux.Follow(stdoutR, "", logagg.MsgIDStdout, config)
+	initR, initW := io.Pipe()
+	initLog := mux.Follow(initR, "", logagg.MsgIDInit, config)
+	for i := 0; i < 3; i++ {
+		fmt.Fprintf(stdoutW, "line %d\n", i)
+	}
+	fmt.Fprintln(initW, "init output")
+
+	// only application output is included in the tail
+	expected := []string{"line 0", "line 1", "line 2"}
+	deadline := time.Now().Add(5 * time.Second)
+	for lines := mux.JobLogTail("job", 0); !reflect.DeepEqual(lines, expected); lines = mux.JobLogTail("job", 0) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %v, got %v", expected, lines)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the tail is kept for the retention period once the job's streams
+	// have closed, then removed
+	closed := time.Now()
+	stdoutW.Close()
+	initW.Close()
+	stdout.Close()
+	initLog.Close()
+	deadline = closed.Add(5 * time.Second)
+	for mux.JobLogTail("job", 0) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the job's log tail to expire")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if elapsed := time.Since(closed); elapsed < jobLogTailRetention {
+		t.Fatalf("expected the tail to be kept for %s, it expired after %s", jobLogTailRetention, elapsed)
+	}
+	if lines := mux.JobLogTail("unknown", 0); lines != nil {
+		t.Fatalf("expected no tail for an unknown job, got %v", lines)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	host "github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Metrics exports host, job and daemon metrics in the Prometheus text
+// exposition format so the host can be scraped directly.
+func (h *jobAPI) Metrics(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	log := h.host.log.New("fn", "Metrics")
+
+	hostStats, err := h.host.backend.GetHostStats()
+	if err != nil {
+		log.Error("error getting host stats", "err", err)
+		httphelper.Error(w, err)
+		return
+	}
+	jobStats, err := h.host.backend.GetAllJobsStats()
+	if err != nil {
+		log.Error("error getting job stats", "err", err)
+		httphelper.Error(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	writeMetrics(bw, h.host.id, hostStats, jobStats, h.host.state.Get(), h.addJobRateLimitBucket)
+	bw.Flush()
+}
+
+// metric is a single Prometheus metric family.
+type metric struct {
+	name string
+	typ  string
+	help string
+}
+
+// jobMetrics are exported for each job, labelled with the job ID and the
+// controller app and process type if set.
+var jobMetrics = []struct {
+	metric
+	value func(*host.ContainerStats) float64
+}{
+	{metric{"flynn_job_cpu_usage_seconds_total", "counter", "Total CPU time consumed by the job."},
+		func(s *host.ContainerStats) float64 { return float64(s.CPUUsageNanoseconds) / 1e9 }},
+	{metric{"flynn_job_cpu_throttled_periods_total", "counter", "Number of CPU periods in which the job was throttled."},
+		func(s *host.ContainerStats) float64 { return float64(s.CPUThrottledPeriods) }},
+	{metric{"flynn_job_cpu_throttled_seconds_total", "counter", "Total time the job was throttled for."},
+		func(s *host.ContainerStats) float64 { return float64(s.CPUThrottledTimeNs) / 1e9 }},
+	{metric{"flynn_job_memory_usage_bytes", "gauge", "Memory used by the job."},
+		func(s *host.ContainerStats) float64 { return float64(s.MemoryUsageBytes) }},
+	{metric{"flynn_job_memory_limit_bytes", "gauge", "Memory limit of the job."},
+		func(s *host.ContainerStats) float64 { return float64(s.MemoryLimitBytes) }},
+	{metric{"flynn_job_memory_max_usage_bytes", "gauge", "Maximum memory used by the job."},
+		func(s *host.ContainerStats) float64 { return float64(s.MemoryMaxUsage) }},
+	{metric{"flynn_job_memory_cache_bytes", "gauge", "Page cache memory used by the job."},
+		func(s *host.ContainerStats) float64 { return float64(s.MemoryCacheBytes) }},
+	{metric{"flynn_job_memory_rss_bytes", "gauge", "Resident memory used by the job."},
+		func(s *host.ContainerStats) float64 { return float64(s.MemoryRSSBytes) }},
+	{metric{"flynn_job_network_receive_bytes_total", "counter", "Bytes received by the job."},
+		func(s *host.ContainerStats) float64 { return float64(s.NetworkRxBytes) }},
+	{metric{"flynn_job_network_transmit_bytes_total", "counter", "Bytes transmitted by the job."},
+		func(s *host.ContainerStats) float64 { return float64(s.NetworkTxBytes) }},
+	{metric{"flynn_job_network_receive_packets_total", "counter", "Packets received by the job."},
+		func(s *host.ContainerStats) float64 { return float64(s.NetworkRxPackets) }},
+	{metric{"flynn_job_network_transmit_packets_total", "counter", "Packets transmitted by the job."},
+		func(s *host.ContainerStats) float64 { return float64(s.NetworkTxPackets) }},
+	{metric{"flynn_job_io_read_bytes_total", "counter", "Bytes read from block devices by the job."},
+		func(s *host.ContainerStats) float64 { return float64(s.IOReadBytes) }},
+	{metric{"flynn_job_io_write_bytes_total", "counter", "Bytes written to block devices by the job."},
+		func(s *host.ContainerStats) float64 { return float64(s.IOWriteBytes) }},
+	{metric{"flynn_job_pids", "gauge", "Number of processes in the job."},
+		func(s *host.ContainerStats) float64 { return float64(s.PIDsCurrent) }},
+	{metric{"flynn_job_pids_limit", "gauge", "Process limit of the job."},
+		func(s *host.ContainerStats) float64 { return float64(s.PIDsLimit) }},
+}
+
+// hostMetrics are exported once, labelled with the host ID.
+var hostMetrics = []struct {
+	metric
+	value func(*host.HostResourceStats) float64
+}{
+	{metric{"flynn_host_cpu_usage_percent", "gauge", "CPU usage of the host."},
+		func(s *host.HostResourceStats) float64 { return s.CPUUsagePercent }},
+	{metric{"flynn_host_cpus", "gauge", "Number of CPUs on the host."},
+		func(s *host.HostResourceStats) float64 { return float64(s.CPUCount) }},
+	{metric{"flynn_host_memory_total_bytes", "gauge", "Total memory of the host."},
+		func(s *host.HostResourceStats) float64 { return float64(s.MemoryTotalBytes) }},
+	{metric{"flynn_host_memory_used_bytes", "gauge", "Memory used on the host."},
+		func(s *host.HostResourceStats) float64 { return float64(s.MemoryUsedBytes) }},
+	{metric{"flynn_host_memory_available_bytes", "gauge", "Memory available on the host."},
+		func(s *host.HostResourceStats) float64 { return float64(s.MemoryAvailableBytes) }},
+	{metric{"flynn_host_disk_total_bytes", "gauge", "Total size of the host's container disk."},
+		func(s *host.HostResourceStats) float64 { return float64(s.DiskTotalBytes) }},
+	{metric{"flynn_host_disk_used_bytes", "gauge", "Used space on the host's container disk."},
+		func(s *host.HostResourceStats) float64 { return float64(s.DiskUsedBytes) }},
+	{metric{"flynn_host_network_receive_bytes_total", "counter", "Bytes received by the host."},
+		func(s *host.HostResourceStats) float64 { return float64(s.NetworkRxBytes) }},
+	{metric{"flynn_host_network_transmit_bytes_total", "counter", "Bytes transmitted by the host."},
+		func(s *host.HostResourceStats) float64 { return float64(s.NetworkTxBytes) }},
+	{metric{"flynn_host_load1", "gauge", "One minute load average of the host."},
+		func(s *host.HostResourceStats) float64 { return s.LoadAvg1 }},
+	{metric{"flynn_host_load5", "gauge", "Five minute load average of the host."},
+		func(s *host.HostResourceStats) float64 { return s.LoadAvg5 }},
+	{metric{"flynn_host_load15", "gauge", "Fifteen minute load average of the host."},
+		func(s *host.HostResourceStats) float64 { return s.LoadAvg15 }},
+	{metric{"flynn_host_uptime_seconds", "gauge", "Uptime of the host."},
+		func(s *host.HostResourceStats) float64 { return s.UptimeSeconds }},
+	{metric{"flynn_host_jobs_running", "gauge", "Number of running jobs on the host."},
+		func(s *host.HostResourceStats) float64 { return float64(s.RunningJobsCount) }},
+	{metric{"flynn_host_jobs", "gauge", "Number of jobs known to the backend."},
+		func(s *host.HostResourceStats) float64 { return float64(s.TotalJobsCount) }},
+}
+
+func writeMetrics(w io.Writer, hostID string, hostStats *host.HostResourceStats, jobStats *host.AllJobsStats, jobs map[string]*host.ActiveJob, addJobBucket *RateLimitBucket) {
+	hostLabels := [][2]string{{"host", hostID}}
+
+	if hostStats != nil {
+		for _, m := range hostMetrics {
+			writeMetricHeader(w, m.metric)
+			writeSample(w, m.name, hostLabels, m.value(hostStats))
+		}
+	}
+
+	if jobStats != nil && len(jobStats.Jobs) > 0 {
+		stats := make([]*host.ContainerStats, len(jobStats.Jobs))
+		copy(stats, jobStats.Jobs)
+		sort.Slice(stats, func(i, j int) bool { return stats[i].JobID < stats[j].JobID })
+		labels := make([][][2]string, len(stats))
+		for i, s := range stats {
+			labels[i] = jobLabels(hostID, s.JobID, jobs[s.JobID])
+		}
+		for _, m := range jobMetrics {
+			writeMetricHeader(w, m.metric)
+			for i, s := range stats {
+				writeSample(w, m.name, labels[i], m.value(s))
+			}
+		}
+	}
+
+	if addJobBucket != nil {
+		writeMetricHeader(w, metric{"flynn_host_add_job_in_progress", "gauge", "Number of AddJob requests currently starting jobs."})
+		writeSample(w, "flynn_host_add_job_in_progress", hostLabels, float64(addJobBucket.InUse()))
+		writeMetricHeader(w, metric{"flynn_host_add_job_limit", "gauge", "Maximum number of concurrent AddJob requests."})
+		writeSample(w, "flynn_host_add_job_limit", hostLabels, float64(addJobBucket.Size()))
+		writeMetricHeader(w, metric{"flynn_host_add_job_rejected_total", "counter", "AddJob requests rejected because the concurrency limit was reached."})
+		writeSample(w, "flynn_host_add_job_rejected_total", hostLabels, float64(addJobBucket.Rejected()))
+	}
+}
+
+func jobLabels(hostID, jobID string, job *host.ActiveJob) [][2]string {
+	labels := [][2]string{{"host", hostID}, {"job_id", jobID}}
+	if job == nil || job.Job == nil {
+		return labels
+	}
+	if app := job.Job.Metadata["flynn-controller.app_name"]; app != "" {
+		labels = append(labels, [2]string{"app", app})
+	}
+	if typ := job.Job.Metadata["flynn-controller.type"]; typ != "" {
+		labels = append(labels, [2]string{"type", typ})
+	}
+	return labels
+}
+
+func writeMetricHeader(w io.Writer, m metric) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+}
+
+func writeSample(w io.Writer, name string, labels [][2]string, value float64) {
+	io.WriteString(w, name)
+	if len(labels) > 0 {
+		io.WriteString(w, "{")
+		for i, l := range labels {
+			if i > 0 {
+				io.WriteString(w, ",")
+			}
+			fmt.Fprintf(w, `%s="%s"`, l[0], escapeLabelValue(l[1]))
+		}
+		io.WriteString(w, "}")
+	}
+	fmt.Fprintf(w, " %s\n", strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// escapeLabelValue escapes a label value as required by the exposition
+// format, which differs from Go quoting for non-ASCII characters.
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	host "github.com/flynn/flynn/host/types"
+	"github.com/golang/snappy"
+	"github.com/inconshreveable/log15"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// MetricsExportRemoteWrite pushes samples using the Prometheus
+	// remote-write protocol
+	MetricsExportRemoteWrite = "remote-write"
+
+	// MetricsExportInfluxDB pushes samples using the InfluxDB line protocol
+	MetricsExportInfluxDB = "influxdb"
+
+	metricsExportTimeout = 30 * time.Second
+
+	// metricsExportBufferSize is the number of collections buffered while
+	// the endpoint is unavailable, one hour at the default interval, after
+	// which the oldest collections are dropped
+	metricsExportBufferSize = 120
+
+	// metricsExportMaxBatch is the maximum number of buffered collections
+	// sent in a single request
+	metricsExportMaxBatch = 10
+)
+
+// metricsExportConfig configures pushing host and job metrics to an
+// external time-series database.
+type metricsExportConfig struct {
+	URL      string
+	Format   string
+	Interval time.Duration
+	Token    string
+}
+
+func (c *metricsExportConfig) Validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid URL %q", c.URL)
+	}
+	switch c.Format {
+	case MetricsExportRemoteWrite, MetricsExportInfluxDB:
+	default:
+		return fmt.Errorf("unknown format %q (expected %q or %q)", c.Format, MetricsExportRemoteWrite, MetricsExportInfluxDB)
+	}
+	if c.Interval <= 0 {
+		return fmt.Errorf("invalid interval %s", c.Interval)
+	}
+	return nil
+}
+
+// metricSample is a single value of a metric at a point in time.
+type metricSample struct {
+	name   string
+	labels [][2]string
+	value  float64
+	time   time.Time
+}
+
+// MetricsExporter periodically collects the same host and job metrics served
+// at /metrics and pushes them to a remote endpoint, so that sites can retain
+// metrics without scraping every host. Collections are buffered in memory
+// while the endpoint is unavailable and sent oldest first once it recovers.
+type MetricsExporter struct {
+	hostID  string
+	config  metricsExportConfig
+	backend Backend
+	state   *State
+	client  *http.Client
+	done    chan struct{}
+	log     log15.Logger
+
+	buffer [][]*metricSample
+}
+
+// NewMetricsExporter creates a new exporter. Call Run() to start exporting.
+func NewMetricsExporter(hostID string, config metricsExportConfig, backend Backend, state *State, log log15.Logger) *MetricsExporter {
+	return &MetricsExporter{
+		hostID:  hostID,
+		config:  config,
+		backend: backend,
+		state:   state,
+		client:  &http.Client{Timeout: metricsExportTimeout},
+		done:    make(chan struct{}),
+		log:     log.New("component", "metrics-exporter", "url", redactURL(config.URL), "format", config.Format),
+	}
+}
+
+// Run periodically collects and pushes metrics. Should be called in a
+// goroutine.
+func (e *MetricsExporter) Run() {
+	e.log.Info("metrics exporter started", "interval", e.config.Interval)
+	ticker := time.NewTicker(e.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.collect(time.Now())
+			e.flush()
+		case <-e.done:
+			return
+		}
+	}
+}
+
+// Shutdown stops exporting, discarding any buffered collections.
+func (e *MetricsExporter) Shutdown() {
+	close(e.done)
+}
+
+// collect adds the current host and job metrics to the buffer, dropping the
+// oldest collection if it is full.
+func (e *MetricsExporter) collect(now time.Time) {
+	hostStats, err := e.backend.GetHostStats()
+	if err != nil {
+		e.log.Error("error getting host stats", "err", err)
+		return
+	}
+	jobStats, err := e.backend.GetAllJobsStats()
+	if err != nil {
+		e.log.Error("error getting job stats", "err", err)
+		return
+	}
+	samples := collectMetricSamples(e.hostID, hostStats, jobStats, e.state.Get(), now)
+	if len(samples) == 0 {
+		return
+	}
+	if len(e.buffer) >= metricsExportBufferSize {
+		e.log.Warn("metrics export buffer full, dropping oldest samples", "time", e.buffer[0][0].time)
+		e.buffer = e.buffer[1:]
+	}
+	e.buffer = append(e.buffer, samples)
+}
+
+// flush pushes buffered collections in batches, stopping at the first
+// failure so the remaining collections are retried on the next tick.
+func (e *MetricsExporter) flush() {
+	for len(e.buffer) > 0 {
+		n := len(e.buffer)
+		if n > metricsExportMaxBatch {
+			n = metricsExportMaxBatch
+		}
+		var batch []*metricSample
+		for _, samples := range e.buffer[:n] {
+			batch = append(batch, samples...)
+		}
+		if err := e.push(batch); err != nil {
+			e.log.Error("error pushing metrics", "err", err, "buffered", len(e.buffer))
+			return
+		}
+		e.buffer = e.buffer[n:]
+	}
+}
+
+func (e *MetricsExporter) push(samples []*metricSample) error {
+	var body []byte
+	var contentType string
+	switch e.config.Format {
+	case MetricsExportRemoteWrite:
+		body = snappy.Encode(nil, encodeRemoteWrite(samples))
+		contentType = "application/x-protobuf"
+	case MetricsExportInfluxDB:
+		var buf bytes.Buffer
+		writeInfluxLines(&buf, samples)
+		body = buf.Bytes()
+		contentType = "text/plain; charset=utf-8"
+	}
+	req, err := http.NewRequest("POST", e.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if e.config.Format == MetricsExportRemoteWrite {
+		req.Header.Set("Content-Encoding", "snappy")
+		req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	}
+	if e.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+e.config.Token)
+	}
+	res, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("unexpected status %d: %s", res.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// collectMetricSamples returns the host and job metrics exported at
+// /metrics as samples taken at the given time.
+func collectMetricSamples(hostID string, hostStats *host.HostResourceStats, jobStats *host.AllJobsStats, jobs map[string]*host.ActiveJob, now time.Time) []*metricSample {
+	var samples []*metricSample
+	if hostStats != nil {
+		hostLabels := [][2]string{{"host", hostID}}
+		for _, m := range hostMetrics {
+			samples = append(samples, &metricSample{m.name, hostLabels, m.value(hostStats), now})
+		}
+	}
+	if jobStats != nil {
+		for _, s := range jobStats.Jobs {
+			labels := jobLabels(hostID, s.JobID, jobs[s.JobID])
+			for _, m := range jobMetrics {
+				samples = append(samples, &metricSample{m.name, labels, m.value(s), now})
+			}
+		}
+	}
+	return samples
+}
+
+// encodeRemoteWrite encodes samples as a Prometheus remote-write
+// WriteRequest protobuf message, with one time series per sample.
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func encodeRemoteWrite(samples []*metricSample) []byte {
+	var req []byte
+	for _, s := range samples {
+		// labels must be sorted by name
+		labels := append([][2]string{{"__name__", s.name}}, s.labels...)
+		sort.Slice(labels, func(i, j int) bool { return labels[i][0] < labels[j][0] })
+
+		var ts []byte
+		for _, l := range labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l[0])
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l[1])
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.time.UnixNano()/int64(time.Millisecond)))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	return req
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `)
+	influxTagEscaper         = strings.NewReplacer(`,`, `\,`, ` `, `\ `, `=`, `\=`)
+)
+
+// writeInfluxLines writes samples in the InfluxDB line protocol, using the
+// metric name as the measurement, the labels as tags and a single "value"
+// field, with nanosecond timestamps.
+func writeInfluxLines(w io.Writer, samples []*metricSample) {
+	for _, s := range samples {
+		io.WriteString(w, influxMeasurementEscaper.Replace(s.name))
+		for _, l := range s.labels {
+			if l[1] == "" {
+				continue
+			}
+			fmt.Fprintf(w, ",%s=%s", influxTagEscaper.Replace(l[0]), influxTagEscaper.Replace(l[1]))
+		}
+		fmt.Fprintf(w, " value=%s %d\n", strconv.FormatFloat(s.value, 'g', -1, 64), s.time.UnixNano())
+	}
+}
+
+// redactURL removes any password from a URL so it can be logged.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
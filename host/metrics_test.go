@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+
+	host "github.com/flynn/flynn/host/types"
+	. "github.com/flynn/go-check"
+)
+
+func (S) TestWriteMetrics(c *C) {
+	bucket := NewRateLimitBucket(2)
+	c.Assert(bucket.Take(), Equals, true)
+	c.Assert(bucket.Take(), Equals, true)
+	c.Assert(bucket.Take(), Equals, false)
+
+	jobs := map[string]*host.ActiveJob{
+		"job1": {Job: &host.Job{ID: "job1", Metadata: map[string]string{
+			"flynn-controller.app_name": `my"app`,
+			"flynn-controller.type":     "web",
+		}}},
+	}
+	var buf bytes.Buffer
+	writeMetrics(&buf, "host0",
+		&host.HostResourceStats{CPUCount: 4, LoadAvg1: 0.5},
+		&host.AllJobsStats{Jobs: []*host.ContainerStats{
+			{JobID: "job2", CPUUsageNanoseconds: 1500000000},
+			{JobID: "job1", MemoryUsageBytes: 1024},
+		}},
+		jobs, bucket,
+	)
+	out := buf.String()
+
+	for _, line := range []string{
+		`flynn_host_cpus{host="host0"} 4`,
+		`flynn_host_load1{host="host0"} 0.5`,
+		`flynn_job_memory_usage_bytes{host="host0",job_id="job1",app="my\"app",type="web"} 1024`,
+		`flynn_job_cpu_usage_seconds_total{host="host0",job_id="job2"} 1.5`,
+		`flynn_host_add_job_in_progress{host="host0"} 2`,
+		`flynn_host_add_job_limit{host="host0"} 2`,
+		`flynn_host_add_job_rejected_total{host="host0"} 1`,
+		"# TYPE flynn_job_cpu_usage_seconds_total counter",
+	} {
+		c.Assert(strings.Contains(out, line+"\n"), Equals, true, Commentf("missing %q in:\n%s", line, out))
+	}
+
+	// each family is written once with all its samples together
+	c.Assert(strings.Count(out, "# TYPE flynn_job_memory_usage_bytes "), Equals, 1)
+	c.Assert(strings.Index(out, `flynn_job_pids{host="host0",job_id="job1"`) < strings.Index(out, `flynn_job_pids{host="host0",job_id="job2"`), Equals, true)
+}
@@ -47,24 +47,12 @@ func NewMonitor(dm *DiscoverdManager, addr string, logger log15.Logger) *Monitor
 	}
 }
 
-func (m *Monitor) waitDiscoverd() {
-	for {
-		if m.dm.localConnected() {
-			m.discClient = discoverd.NewClient()
-			break
-		}
-		time.Sleep(retryInterval)
-	}
-}
-
-func (m *Monitor) waitRaftLeader() {
-	for {
-		_, err := m.discClient.RaftLeader()
-		if err == nil {
-			break
-		}
-		time.Sleep(retryInterval)
+func (m *Monitor) waitDiscoverd() bool {
+	if !waitBootDependency(bootDependencyCheck(m.dm), "discoverd", retryInterval, m.shutdownCh) {
+		return false
 	}
+	m.discClient = discoverd.NewClient()
+	return true
 }
 
 func (m *Monitor) waitEnabled() {
@@ -103,11 +91,10 @@ func (m *Monitor) waitRegister() {
 
 func (m *Monitor) Run() {
 	log := monitorLogger.New("fn", "Run")
-	log.Info("waiting for discoverd")
-	m.waitDiscoverd()
-
-	log.Info("waiting for raft leader")
-	m.waitRaftLeader()
+	log.Info("waiting for discoverd and raft leader")
+	if !m.waitDiscoverd() {
+		return
+	}
 
 	// we can connect the leader election wrapper now
 	m.discoverd = newDiscoverdWrapper(m.addr+":1113", m.logger)
@@ -0,0 +1,25 @@
+//go:build !lite
+// +build !lite
+
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/flynn/flynn/host/volume"
+	zfsVolume "github.com/flynn/flynn/host/volume/zfs"
+	"github.com/inconshreveable/log15"
+)
+
+const liteBuild = false
+
+func newZFSProvider(zpoolName, volPath string, log log15.Logger) (volume.Provider, error) {
+	if zpoolName == "" {
+		zpoolName = zfsVolume.DefaultDatasetName
+	}
+	return zfsVolume.NewProvider(&zfsVolume.ProviderConfig{
+		DatasetName: zpoolName,
+		Make:        zfsVolume.DefaultMakeDev(volPath, log),
+		WorkingDir:  filepath.Join(volPath, "zfs"),
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -20,6 +21,32 @@ var jobProfiles = map[host.JobProfile]jobProfileFn{
 	host.JobProfileLoop: jobProfileLoop,
 }
 
+// jobProfileDevices are the device files which must exist for a host to
+// support each job profile
+var jobProfileDevices = map[host.JobProfile][]string{
+	host.JobProfileZFS:  {"/sys/class/misc/zfs/dev"},
+	host.JobProfileKVM:  {"/sys/class/misc/kvm/dev", "/sys/class/misc/tun/dev"},
+	host.JobProfileLoop: {"/dev/loop-control"},
+}
+
+// supportedJobProfiles returns the job profiles the host supports, which
+// are advertised in discoverd so the controller can reject formations
+// needing profiles no host supports.
+func supportedJobProfiles() []host.JobProfile {
+	profiles := make([]host.JobProfile, 0, len(jobProfileDevices))
+outer:
+	for profile, devices := range jobProfileDevices {
+		for _, path := range devices {
+			if _, err := os.Stat(path); err != nil {
+				continue outer
+			}
+		}
+		profiles = append(profiles, profile)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i] < profiles[j] })
+	return profiles
+}
+
 const zfsVolMajor = 230
 
 func jobProfileZFS(job *host.Job) error {
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	host "github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/pkg/httphelper"
+)
+
+// rateLimitWindow is the window request limits are applied over.
+const rateLimitWindow = time.Minute
+
+// SEC-017: perIPRateLimiter limits the number of requests each client IP can
+// make per window to prevent API abuse and denial-of-service attacks.
+//
+// It uses a sliding window counter, estimating the number of requests in the
+// last window from the counts of the current and previous fixed windows, so
+// clients can't burst to twice the limit across a window boundary.
+type perIPRateLimiter struct {
+	mu      sync.Mutex
+	clients map[string]*windowCounter
+	limit   int
+	window  time.Duration
+	now     func() time.Time
+
+	// rejected must be accessed atomically
+	rejected uint64
+}
+
+type windowCounter struct {
+	start    time.Time
+	current  int
+	previous int
+}
+
+func newPerIPRateLimiter(limit int, window time.Duration) *perIPRateLimiter {
+	return &perIPRateLimiter{
+		clients: make(map[string]*windowCounter),
+		limit:   limit,
+		window:  window,
+		now:     time.Now,
+	}
+}
+
+// Allow records a request from ip, returning whether it is within the limit.
+// Rejected requests are not counted, so clients which back off regain access
+// once their earlier requests leave the window.
+func (rl *perIPRateLimiter) Allow(ip string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := rl.now()
+	c, ok := rl.clients[ip]
+	if !ok {
+		c = &windowCounter{start: now.Truncate(rl.window)}
+		rl.clients[ip] = c
+	}
+	c.advance(now, rl.window)
+	if c.estimate(now, rl.window) >= float64(rl.limit) {
+		atomic.AddUint64(&rl.rejected, 1)
+		return false
+	}
+	c.current++
+	return true
+}
+
+// advance moves the counter's windows forward to the one containing now.
+func (c *windowCounter) advance(now time.Time, window time.Duration) {
+	start := now.Truncate(window)
+	switch {
+	case !start.After(c.start):
+		return
+	case start.Sub(c.start) == window:
+		c.previous = c.current
+	default:
+		c.previous = 0
+	}
+	c.current = 0
+	c.start = start
+}
+
+// estimate returns the estimated number of requests in the window ending at
+// now, weighting the previous window's count by how much of it overlaps.
+func (c *windowCounter) estimate(now time.Time, window time.Duration) float64 {
+	overlap := 1 - float64(now.Sub(c.start))/float64(window)
+	return float64(c.previous)*overlap + float64(c.current)
+}
+
+// prune removes clients which have made no requests in the last two windows.
+func (rl *perIPRateLimiter) prune() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := rl.now()
+	for ip, c := range rl.clients {
+		c.advance(now, rl.window)
+		if c.current == 0 && c.previous == 0 {
+			delete(rl.clients, ip)
+		}
+	}
+}
+
+// Status returns the limit and current state of the limiter.
+func (rl *perIPRateLimiter) Status() *host.RateLimitStatus {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := rl.now()
+	status := &host.RateLimitStatus{
+		Limit:    rl.limit,
+		Window:   rl.window.String(),
+		Rejected: atomic.LoadUint64(&rl.rejected),
+	}
+	for _, c := range rl.clients {
+		c.advance(now, rl.window)
+		if c.current == 0 && c.previous == 0 {
+			continue
+		}
+		status.Clients++
+		if c.estimate(now, rl.window) >= float64(rl.limit) {
+			status.Limited++
+		}
+	}
+	return status
+}
+
+// hostRateLimits are the per-IP request limits for unauthenticated clients,
+// configured with daemon flags. A limit of zero disables limiting.
+type hostRateLimits struct {
+	// Read applies to GET and HEAD requests
+	Read int
+
+	// Write applies to all other requests, which are typically more
+	// expensive
+	Write int
+}
+
+// hostRateLimiter applies separate limits to read and write requests.
+type hostRateLimiter struct {
+	read, write *perIPRateLimiter
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+func newHostRateLimiter(limits hostRateLimits) *hostRateLimiter {
+	l := &hostRateLimiter{done: make(chan struct{})}
+	if limits.Read > 0 {
+		l.read = newPerIPRateLimiter(limits.Read, rateLimitWindow)
+	}
+	if limits.Write > 0 {
+		l.write = newPerIPRateLimiter(limits.Write, rateLimitWindow)
+	}
+	go func() {
+		ticker := time.NewTicker(rateLimitWindow)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for _, rl := range []*perIPRateLimiter{l.read, l.write} {
+					if rl != nil {
+						rl.prune()
+					}
+				}
+			case <-l.done:
+				return
+			}
+		}
+	}()
+	return l
+}
+
+func (l *hostRateLimiter) limiter(r *http.Request) *perIPRateLimiter {
+	if r.Method == "GET" || r.Method == "HEAD" {
+		return l.read
+	}
+	return l.write
+}
+
+// Allow returns whether the request is within the limits.
+func (l *hostRateLimiter) Allow(r *http.Request) bool {
+	rl := l.limiter(r)
+	if rl == nil {
+		return true
+	}
+	ip, _, _ := net.SplitHostPort(r.RemoteAddr)
+	if ip == "" {
+		ip = r.RemoteAddr
+	}
+	return rl.Allow(ip)
+}
+
+// Status returns the state of the read and write limiters.
+func (l *hostRateLimiter) Status() *host.RateLimitsStatus {
+	status := &host.RateLimitsStatus{}
+	if l.read != nil {
+		status.Read = l.read.Status()
+	}
+	if l.write != nil {
+		status.Write = l.write.Status()
+	}
+	return status
+}
+
+// Close stops pruning idle clients.
+func (l *hostRateLimiter) Close() {
+	l.closeOnce.Do(func() { close(l.done) })
+}
+
+func (h *Host) rateLimitMiddleware(next http.Handler) http.Handler {
+	// Without host HTTP authentication, every client looks the same to us and the
+	// controller issues many requests from one address — a global limit breaks
+	// flynn run, pg psql, deploys, etc. Enable FLYNN_HOST_AUTH_KEY on flynn-host
+	// (and the same value on the controller) to apply limits only to clients that
+	// do not present the key.
+	if !h.authEnabled() {
+		return next
+	}
+	limiter := newHostRateLimiter(h.rateLimits)
+	h.rateLimiter = limiter
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Exempt health checks from rate limiting
+		if r.URL.Path == "/host/status" && r.Method == "GET" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Requests authenticated with the host API key or a client certificate
+		// are trusted (controller, CLI via controller, internal tooling). Per-IP
+		// limits still apply to missing or wrong credentials so brute-force
+		// attempts remain throttled.
+		if h.authenticated(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !limiter.Allow(r) {
+			httphelper.Error(w, httphelper.JSONError{
+				Code:    httphelper.RatelimitedErrorCode,
+				Message: "too many requests, try again later",
+				Retry:   true,
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
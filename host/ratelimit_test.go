@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	. "github.com/flynn/go-check"
+)
+
+func (S) TestPerIPRateLimiterSlidingWindow(c *C) {
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	rl := newPerIPRateLimiter(10, time.Minute)
+	rl.now = func() time.Time { return now }
+
+	allowed := func(n int) int {
+		count := 0
+		for i := 0; i < n; i++ {
+			if rl.Allow("10.0.0.1") {
+				count++
+			}
+		}
+		return count
+	}
+
+	// use the whole limit at the end of a window
+	now = now.Add(59 * time.Second)
+	c.Assert(allowed(20), Equals, 10)
+	c.Assert(rl.Allow("10.0.0.2"), Equals, true)
+
+	// at the window boundary the previous requests still count, so the
+	// client can't burst to twice the limit
+	now = now.Add(time.Second)
+	c.Assert(allowed(20), Equals, 0)
+
+	// half way through the next window half of them have expired
+	now = now.Add(30 * time.Second)
+	c.Assert(allowed(20), Equals, 5)
+
+	// and after two windows the client has its full limit again
+	now = now.Add(2 * time.Minute)
+	c.Assert(allowed(20), Equals, 10)
+
+	status := rl.Status()
+	c.Assert(status.Limit, Equals, 10)
+	c.Assert(status.Clients, Equals, 1)
+	c.Assert(status.Limited, Equals, 1)
+	c.Assert(status.Rejected, Equals, uint64(55))
+
+	// idle clients are pruned
+	now = now.Add(2 * time.Minute)
+	rl.prune()
+	c.Assert(rl.clients, HasLen, 0)
+}
+
+func (S) TestHostRateLimiterClasses(c *C) {
+	l := newHostRateLimiter(hostRateLimits{Read: 2, Write: 0})
+	defer l.Close()
+
+	get := &http.Request{Method: "GET", RemoteAddr: "10.0.0.1:1234"}
+	put := &http.Request{Method: "PUT", RemoteAddr: "10.0.0.1:1234"}
+	c.Assert(l.Allow(get), Equals, true)
+	c.Assert(l.Allow(get), Equals, true)
+	c.Assert(l.Allow(get), Equals, false)
+
+	// write requests are not limited when the limit is zero
+	for i := 0; i < 5; i++ {
+		c.Assert(l.Allow(put), Equals, true)
+	}
+
+	status := l.Status()
+	c.Assert(status.Read, NotNil)
+	c.Assert(status.Read.Rejected, Equals, uint64(1))
+	c.Assert(status.Write, IsNil)
+}
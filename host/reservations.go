@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/docker/go-units"
+	"github.com/flynn/flynn/host/resource"
+	host "github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/pkg/httphelper"
+)
+
+// resourceReserver admits jobs to the host only if the memory and CPU they
+// reserve, along with the reservations of the active jobs, would not exceed
+// the host's capacity multiplied by the overcommit ratio.
+type resourceReserver struct {
+	ratio  float64
+	memory int64 // bytes
+	cpu    int64 // milliCPU
+
+	// mtx is held while checking and adding a job so that concurrent
+	// AddJob requests cannot together oversubscribe the host
+	mtx sync.Mutex
+}
+
+// newResourceReserver returns a reserver using the memory and CPU of the
+// host, or nil if ratio is zero and reservations are not enforced.
+func newResourceReserver(ratio float64) (*resourceReserver, error) {
+	if ratio == 0 {
+		return nil, nil
+	}
+	memory, err := readMemTotal()
+	if err != nil {
+		return nil, fmt.Errorf("error reading host memory: %s", err)
+	}
+	return &resourceReserver{
+		ratio:  ratio,
+		memory: memory,
+		cpu:    int64(runtime.NumCPU()) * 1000,
+	}, nil
+}
+
+// readMemTotal returns the total memory of the host in bytes.
+func readMemTotal() (int64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 || fields[0] != "MemTotal:" {
+			continue
+		}
+		kb, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return kb * units.KiB, nil
+	}
+	if err := s.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("MemTotal missing from /proc/meminfo")
+}
+
+// jobReservation returns the memory and CPU a job reserves, which is the
+// request of each resource or its limit if no request is set.
+func jobReservation(job *host.Job) (memory, cpu int64) {
+	amount := func(typ resource.Type) int64 {
+		spec, ok := job.Resources[typ]
+		if !ok {
+			return 0
+		} else if spec.Request != nil {
+			return *spec.Request
+		} else if spec.Limit != nil {
+			return *spec.Limit
+		}
+		return 0
+	}
+	return amount(resource.TypeMemory), amount(resource.TypeCPU)
+}
+
+// Status returns the reservations of the active jobs in state.
+func (r *resourceReserver) Status(state *State) *host.ReservationStatus {
+	status := &host.ReservationStatus{
+		OvercommitRatio: r.ratio,
+		Memory:          host.ResourceReservation{Capacity: r.memory, Limit: r.limit(r.memory)},
+		CPU:             host.ResourceReservation{Capacity: r.cpu, Limit: r.limit(r.cpu)},
+	}
+	for _, job := range state.GetActive() {
+		memory, cpu := jobReservation(job.Job)
+		status.Memory.Reserved += memory
+		status.CPU.Reserved += cpu
+	}
+	return status
+}
+
+// FreeMemory returns how much memory jobs can still reserve, which is the
+// limit less the reservations of the active jobs in state. If reservations
+// are not enforced, the limit is the host's total memory.
+func (r *resourceReserver) FreeMemory(state *State) (int64, error) {
+	if r == nil {
+		memory, err := readMemTotal()
+		if err != nil {
+			return 0, err
+		}
+		r = &resourceReserver{ratio: 1, memory: memory}
+	}
+	status := r.Status(state)
+	free := status.Memory.Limit - status.Memory.Reserved
+	if free < 0 {
+		free = 0
+	}
+	return free, nil
+}
+
+func (r *resourceReserver) limit(capacity int64) int64 {
+	return int64(math.Floor(float64(capacity) * r.ratio))
+}
+
+// AddJob adds the job to state, returning an insufficient resources error
+// instead if reserving its resources would oversubscribe the host.
+func (r *resourceReserver) AddJob(state *State, job *host.Job) error {
+	if r == nil {
+		return state.AddJob(job)
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	status := r.Status(state)
+	memory, cpu := jobReservation(job)
+	for _, res := range []struct {
+		typ         resource.Type
+		requested   int64
+		reservation host.ResourceReservation
+	}{
+		{resource.TypeMemory, memory, status.Memory},
+		{resource.TypeCPU, cpu, status.CPU},
+	} {
+		if res.requested == 0 || res.reservation.Reserved+res.requested <= res.reservation.Limit {
+			continue
+		}
+		detail, _ := json.Marshal(&host.InsufficientResourcesDetail{
+			Resource:  res.typ,
+			Requested: res.requested,
+			Reserved:  res.reservation.Reserved,
+			Limit:     res.reservation.Limit,
+		})
+		return httphelper.JSONError{
+			Code: httphelper.InsufficientResourcesErrorCode,
+			Message: fmt.Sprintf(
+				"insufficient %s: job requests %d with %d of %d reserved",
+				res.typ, res.requested, res.reservation.Reserved, res.reservation.Limit,
+			),
+			Detail: detail,
+		}
+	}
+	return state.AddJob(job)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+
+	"github.com/docker/go-units"
+	"github.com/flynn/flynn/host/resource"
+	host "github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/pkg/typeconv"
+	. "github.com/flynn/go-check"
+)
+
+func (S) TestResourceReservations(c *C) {
+	state := NewState("abc123", filepath.Join(c.MkDir(), "host-state-db"))
+	c.Assert(state.OpenDB(), IsNil)
+	defer state.CloseDB()
+
+	r := &resourceReserver{ratio: 1.5, memory: 2 * units.GiB, cpu: 2000}
+	newJob := func(id string, memory, cpu int64) *host.Job {
+		job := &host.Job{ID: id, Resources: resource.Resources{}}
+		if memory > 0 {
+			job.Resources.SetLimit(resource.TypeMemory, memory)
+		}
+		if cpu > 0 {
+			job.Resources[resource.TypeCPU] = resource.Spec{Limit: typeconv.Int64Ptr(cpu)}
+		}
+		return job
+	}
+
+	// jobs can reserve up to the capacity multiplied by the ratio
+	c.Assert(r.AddJob(state, newJob("job1", 2*units.GiB, 1000)), IsNil)
+	c.Assert(r.AddJob(state, newJob("job2", 1*units.GiB, 1000)), IsNil)
+	status := r.Status(state)
+	c.Assert(status.Memory, DeepEquals, host.ResourceReservation{Capacity: 2 * units.GiB, Limit: 3 * units.GiB, Reserved: 3 * units.GiB})
+	c.Assert(status.CPU, DeepEquals, host.ResourceReservation{Capacity: 2000, Limit: 3000, Reserved: 2000})
+
+	// jobs which would oversubscribe the host are rejected
+	err := r.AddJob(state, newJob("job3", 1, 0))
+	c.Assert(httphelper.IsInsufficientResourcesError(err), Equals, true)
+	var detail host.InsufficientResourcesDetail
+	c.Assert(json.Unmarshal(err.(httphelper.JSONError).Detail, &detail), IsNil)
+	c.Assert(detail, DeepEquals, host.InsufficientResourcesDetail{
+		Resource:  resource.TypeMemory,
+		Requested: 1,
+		Reserved:  3 * units.GiB,
+		Limit:     3 * units.GiB,
+	})
+	err = r.AddJob(state, newJob("job3", 0, 1001))
+	c.Assert(httphelper.IsInsufficientResourcesError(err), Equals, true)
+	c.Assert(state.GetJob("job3"), IsNil)
+
+	// jobs without reservations are always admitted
+	c.Assert(r.AddJob(state, newJob("job3", 0, 0)), IsNil)
+
+	// stopped jobs release their reservations
+	state.SetStatusDone("job1", 0)
+	c.Assert(r.AddJob(state, newJob("job4", 2*units.GiB, 1000)), IsNil)
+
+	// a nil reserver doesn't enforce reservations
+	var disabled *resourceReserver
+	c.Assert(disabled.AddJob(state, newJob("job5", 100*units.GiB, 0)), IsNil)
+}
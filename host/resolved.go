@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+)
+
+// resolvedRoutingDomain is the systemd-resolved routing domain registered for
+// the discoverd DNS server. The "~" prefix means it is only used to route
+// queries for names under .discoverd and is not added to the search list.
+const resolvedRoutingDomain = "~discoverd"
+
+// registerResolved configures systemd-resolved to send queries for
+// .discoverd names to the discoverd DNS server at dnsAddr via the given
+// link, so that tools running on the host can resolve service names with
+// the system resolver.
+func registerResolved(link, dnsAddr string) error {
+	if _, err := exec.LookPath("resolvectl"); err != nil {
+		return fmt.Errorf("resolvectl not found, is systemd-resolved installed? %s", err)
+	}
+	server := dnsAddr
+	if host, port, err := net.SplitHostPort(dnsAddr); err == nil && port == "53" {
+		server = host
+	}
+	if err := resolvectl("dns", link, server); err != nil {
+		return err
+	}
+	return resolvectl("domain", link, resolvedRoutingDomain)
+}
+
+// unregisterResolved removes the DNS configuration added to the given link
+// by registerResolved.
+func unregisterResolved(link string) error {
+	return resolvectl("revert", link)
+}
+
+func resolvectl(args ...string) error {
+	out, err := exec.Command("resolvectl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("resolvectl %s: %s: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
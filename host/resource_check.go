@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	host "github.com/flynn/flynn/host/types"
+)
+
+// resourceChecker checks whether the host has the resources requested by a
+// ResourceCheck available.
+type resourceChecker struct {
+	portFree     func(port host.Port) bool
+	freeMemory   func() (int64, error)
+	freeSpace    func(provider string) (free int64, ok bool, err error)
+	deviceExists func(path string) bool
+}
+
+func newResourceChecker(h *Host) *resourceChecker {
+	return &resourceChecker{
+		portFree:     checkPort,
+		freeMemory:   func() (int64, error) { return h.reserver.FreeMemory(h.state) },
+		freeSpace:    h.vman.FreeSpace,
+		deviceExists: deviceExists,
+	}
+}
+
+func checkPort(port host.Port) bool {
+	l, err := net.Listen(port.Proto, fmt.Sprintf(":%d", port.Port))
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
+
+func deviceExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeDevice != 0
+}
+
+// Check returns the requested resources which are not available, or nil if
+// they all are.
+func (r *resourceChecker) Check(req *host.ResourceCheck) (*host.ResourceCheck, error) {
+	failed := &host.ResourceCheck{}
+	for _, p := range req.Ports {
+		if p.Proto == "" {
+			p.Proto = "tcp"
+		}
+		if !r.portFree(p) {
+			failed.Ports = append(failed.Ports, p)
+		}
+	}
+	if req.Memory > 0 {
+		free, err := r.freeMemory()
+		if err != nil {
+			return nil, err
+		}
+		if req.Memory > free {
+			failed.Insufficient = append(failed.Insufficient, &host.ResourceShortfall{
+				Resource:  "memory",
+				Requested: req.Memory,
+				Available: free,
+			})
+		}
+	}
+	if req.VolumeSize > 0 {
+		provider := req.VolumeProvider
+		if provider == "" {
+			provider = "default"
+		}
+		// providers which don't report their free space can't be
+		// checked, so they are assumed to have enough
+		free, ok, err := r.freeSpace(provider)
+		if err != nil {
+			return nil, err
+		}
+		if ok && req.VolumeSize > free {
+			failed.Insufficient = append(failed.Insufficient, &host.ResourceShortfall{
+				Resource:  "volume_size",
+				Requested: req.VolumeSize,
+				Available: free,
+			})
+		}
+	}
+	for _, path := range req.Devices {
+		if !r.deviceExists(path) {
+			failed.Devices = append(failed.Devices, path)
+		}
+	}
+	if len(failed.Ports) == 0 && len(failed.Insufficient) == 0 && len(failed.Devices) == 0 {
+		return nil, nil
+	}
+	return failed, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+
+	"github.com/docker/go-units"
+	"github.com/flynn/flynn/host/resource"
+	host "github.com/flynn/flynn/host/types"
+	volumemanager "github.com/flynn/flynn/host/volume/manager"
+	. "github.com/flynn/go-check"
+)
+
+func (S) TestResourceCheck(c *C) {
+	r := &resourceChecker{
+		portFree:   func(port host.Port) bool { return port.Port != 80 },
+		freeMemory: func() (int64, error) { return 2 * units.GiB, nil },
+		freeSpace: func(provider string) (int64, bool, error) {
+			switch provider {
+			case "default":
+				return 10 * units.GiB, true, nil
+			case "unreported":
+				return 0, false, nil
+			default:
+				return 0, false, volumemanager.ErrNoSuchProvider
+			}
+		},
+		deviceExists: func(path string) bool { return path == "/dev/fuse" },
+	}
+
+	// requests which the host can satisfy pass
+	failed, err := r.Check(&host.ResourceCheck{
+		Ports:      []host.Port{{Port: 8080}},
+		Memory:     2 * units.GiB,
+		VolumeSize: 10 * units.GiB,
+		Devices:    []string{"/dev/fuse"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(failed, IsNil)
+
+	// only the resources which are not available are returned
+	failed, err = r.Check(&host.ResourceCheck{
+		Ports:      []host.Port{{Port: 80}, {Port: 8080}},
+		Memory:     3 * units.GiB,
+		VolumeSize: 11 * units.GiB,
+		Devices:    []string{"/dev/fuse", "/dev/kvm"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(failed, DeepEquals, &host.ResourceCheck{
+		Ports: []host.Port{{Port: 80, Proto: "tcp"}},
+		Insufficient: []*host.ResourceShortfall{
+			{Resource: "memory", Requested: 3 * units.GiB, Available: 2 * units.GiB},
+			{Resource: "volume_size", Requested: 11 * units.GiB, Available: 10 * units.GiB},
+		},
+		Devices: []string{"/dev/kvm"},
+	})
+
+	// providers which don't report free space are not checked
+	failed, err = r.Check(&host.ResourceCheck{VolumeSize: units.TiB, VolumeProvider: "unreported"})
+	c.Assert(err, IsNil)
+	c.Assert(failed, IsNil)
+
+	// unknown providers are an error
+	_, err = r.Check(&host.ResourceCheck{VolumeSize: 1, VolumeProvider: "missing"})
+	c.Assert(err, Equals, volumemanager.ErrNoSuchProvider)
+
+	// errors reading free memory are returned
+	r.freeMemory = func() (int64, error) { return 0, errors.New("meminfo unavailable") }
+	_, err = r.Check(&host.ResourceCheck{Memory: 1})
+	c.Assert(err, ErrorMatches, "meminfo unavailable")
+}
+
+func (S) TestFreeMemory(c *C) {
+	state := NewState("abc123", filepath.Join(c.MkDir(), "host-state-db"))
+	c.Assert(state.OpenDB(), IsNil)
+	defer state.CloseDB()
+
+	r := &resourceReserver{ratio: 1.5, memory: 2 * units.GiB, cpu: 2000}
+	job := &host.Job{ID: "job1", Resources: resource.Resources{}}
+	job.Resources.SetLimit(resource.TypeMemory, 2*units.GiB)
+	c.Assert(r.AddJob(state, job), IsNil)
+
+	// free memory is the overcommitted limit less the reservations
+	free, err := r.FreeMemory(state)
+	c.Assert(err, IsNil)
+	c.Assert(free, Equals, int64(units.GiB))
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	host "github.com/flynn/flynn/host/types"
+	"github.com/inconshreveable/log15"
+)
+
+const (
+	// resourceMonitorInterval is how often host resource usage is sampled
+	resourceMonitorInterval = 30 * time.Second
+
+	// loadPressureDuration is how long the load average must stay above
+	// the threshold before it is reported
+	loadPressureDuration = 5 * time.Minute
+)
+
+// resourceThresholds configures when the ResourceMonitor reports pressure,
+// with a zero value disabling the corresponding check.
+type resourceThresholds struct {
+	// DiskPercent is the disk usage percentage above which disk pressure
+	// is reported
+	DiskPercent float64
+
+	// LoadPerCPU is the 5 minute load average per CPU above which
+	// sustained load is reported
+	LoadPerCPU float64
+
+	// MemoryFloor is the number of available memory bytes below which
+	// memory pressure is reported
+	MemoryFloor uint64
+}
+
+// ResourceMonitor periodically samples host resource usage and sends webhook
+// events when the host comes under disk, load or memory pressure, and when
+// the pressure is relieved. Events are only sent on transitions so that a
+// host which stays under pressure does not flood webhooks.
+type ResourceMonitor struct {
+	backend    Backend
+	webhooks   *WebhookDispatcher
+	thresholds resourceThresholds
+	done       chan struct{}
+	log        log15.Logger
+
+	// pressure is the set of resources currently under pressure
+	pressure map[string]bool
+
+	// loadHighSince is when the load average last went above the
+	// threshold, or zero if it is below it
+	loadHighSince time.Time
+}
+
+// NewResourceMonitor creates a new monitor. Call Run() to start sampling.
+func NewResourceMonitor(backend Backend, webhooks *WebhookDispatcher, thresholds resourceThresholds, log log15.Logger) *ResourceMonitor {
+	return &ResourceMonitor{
+		backend:    backend,
+		webhooks:   webhooks,
+		thresholds: thresholds,
+		done:       make(chan struct{}),
+		log:        log.New("component", "resource-monitor"),
+		pressure:   make(map[string]bool),
+	}
+}
+
+// Run periodically samples host resource usage. Should be called in a
+// goroutine.
+func (m *ResourceMonitor) Run() {
+	ticker := time.NewTicker(resourceMonitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			stats, err := m.backend.GetHostStats()
+			if err != nil {
+				m.log.Error("error getting host stats", "err", err)
+				continue
+			}
+			if stats != nil {
+				m.Check(stats, time.Now())
+			}
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// Shutdown stops sampling.
+func (m *ResourceMonitor) Shutdown() {
+	close(m.done)
+}
+
+// Check compares a sample of host resource usage against the thresholds,
+// sending events for resources which have come under or are no longer
+// under pressure.
+func (m *ResourceMonitor) Check(stats *host.HostResourceStats, now time.Time) {
+	if t := m.thresholds.DiskPercent; t > 0 && stats.DiskTotalBytes > 0 {
+		used := float64(stats.DiskUsedBytes) / float64(stats.DiskTotalBytes) * 100
+		m.update("disk", used > t, host.CodeDiskPressure, fmt.Sprintf("Disk usage above %s%%", formatFloat(t)), host.SeverityWarning, map[string]string{
+			"disk_used_percent": formatFloat(used),
+			"disk_used_bytes":   strconv.FormatUint(stats.DiskUsedBytes, 10),
+			"disk_total_bytes":  strconv.FormatUint(stats.DiskTotalBytes, 10),
+			"threshold_percent": formatFloat(t),
+		})
+	}
+
+	if t := m.thresholds.LoadPerCPU; t > 0 && stats.CPUCount > 0 {
+		load := stats.LoadAvg5 / float64(stats.CPUCount)
+		if load <= t {
+			m.loadHighSince = time.Time{}
+		} else if m.loadHighSince.IsZero() {
+			m.loadHighSince = now
+		}
+		sustained := !m.loadHighSince.IsZero() && now.Sub(m.loadHighSince) >= loadPressureDuration
+		// only report relief once the load has dropped below the
+		// threshold rather than whenever it is not yet sustained
+		if sustained || load <= t {
+			m.update("load", sustained, host.CodeLoadPressure, fmt.Sprintf("Load average per CPU above %s for %s", formatFloat(t), loadPressureDuration), host.SeverityWarning, map[string]string{
+				"load_avg_5":   formatFloat(stats.LoadAvg5),
+				"cpu_count":    strconv.Itoa(stats.CPUCount),
+				"threshold":    formatFloat(t),
+				"load_per_cpu": formatFloat(load),
+			})
+		}
+	}
+
+	if t := m.thresholds.MemoryFloor; t > 0 && stats.MemoryTotalBytes > 0 {
+		m.update("memory", stats.MemoryAvailableBytes < t, host.CodeMemoryPressure, "Available memory below floor", host.SeverityError, map[string]string{
+			"memory_available_bytes": strconv.FormatUint(stats.MemoryAvailableBytes, 10),
+			"memory_total_bytes":     strconv.FormatUint(stats.MemoryTotalBytes, 10),
+			"floor_bytes":            strconv.FormatUint(t, 10),
+		})
+	}
+}
+
+// update records whether a resource is under pressure, sending an event with
+// the given code if it has just come under pressure or a CodeResourceRecovered
+// event if it no longer is.
+func (m *ResourceMonitor) update(resource string, underPressure bool, code, description, severity string, metadata map[string]string) {
+	if underPressure == m.pressure[resource] {
+		return
+	}
+	m.pressure[resource] = underPressure
+	metadata["resource"] = resource
+	if underPressure {
+		m.log.Warn("host resource under pressure", "resource", resource, "code", code)
+		m.webhooks.Send(code, description, severity, "", nil, metadata)
+	} else {
+		m.log.Info("host resource pressure relieved", "resource", resource)
+		m.webhooks.Send(host.CodeResourceRecovered, fmt.Sprintf("Host %s pressure relieved", resource), host.SeverityInfo, "", nil, metadata)
+	}
+}
+
+// formatFloat formats f for event metadata, rounded to two decimal places
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(math.Round(f*100)/100, 'f', -1, 64)
+}
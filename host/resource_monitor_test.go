@@ -0,0 +1,80 @@
+package main
+
+import (
+	"time"
+
+	host "github.com/flynn/flynn/host/types"
+	. "github.com/flynn/go-check"
+	"github.com/inconshreveable/log15"
+)
+
+func (S) TestResourceMonitor(c *C) {
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	d := NewWebhookDispatcher("abc123", nil, logger)
+	m := NewResourceMonitor(nil, d, resourceThresholds{DiskPercent: 90, LoadPerCPU: 2, MemoryFloor: 100}, logger)
+
+	events := func() []*host.WebhookEvent {
+		var events []*host.WebhookEvent
+		for {
+			select {
+			case e := <-d.events:
+				events = append(events, e)
+			default:
+				return events
+			}
+		}
+	}
+	stats := func(diskUsed uint64, load float64, memAvailable uint64) *host.HostResourceStats {
+		return &host.HostResourceStats{
+			DiskTotalBytes:       100,
+			DiskUsedBytes:        diskUsed,
+			CPUCount:             2,
+			LoadAvg5:             load,
+			MemoryTotalBytes:     1000,
+			MemoryAvailableBytes: memAvailable,
+		}
+	}
+	now := time.Now()
+
+	m.Check(stats(50, 1, 500), now)
+	c.Assert(events(), HasLen, 0)
+
+	// disk and memory pressure are reported immediately, and only once
+	m.Check(stats(95, 1, 50), now)
+	e := events()
+	c.Assert(e, HasLen, 2)
+	c.Assert(e[0].Code, Equals, host.CodeDiskPressure)
+	c.Assert(e[0].Severity, Equals, host.SeverityWarning)
+	c.Assert(e[0].Metadata["disk_used_percent"], Equals, "95")
+	c.Assert(e[1].Code, Equals, host.CodeMemoryPressure)
+	c.Assert(e[1].Metadata["memory_available_bytes"], Equals, "50")
+	m.Check(stats(96, 1, 40), now)
+	c.Assert(events(), HasLen, 0)
+
+	// load pressure is only reported once sustained
+	m.Check(stats(96, 5, 40), now)
+	c.Assert(events(), HasLen, 0)
+	m.Check(stats(96, 5, 40), now.Add(time.Minute))
+	c.Assert(events(), HasLen, 0)
+	m.Check(stats(96, 5, 40), now.Add(loadPressureDuration))
+	e = events()
+	c.Assert(e, HasLen, 1)
+	c.Assert(e[0].Code, Equals, host.CodeLoadPressure)
+	c.Assert(e[0].Metadata["load_per_cpu"], Equals, "2.5")
+
+	// relief is reported for each resource
+	m.Check(stats(50, 1, 500), now.Add(2*loadPressureDuration))
+	e = events()
+	c.Assert(e, HasLen, 3)
+	for _, event := range e {
+		c.Assert(event.Code, Equals, host.CodeResourceRecovered)
+		c.Assert(event.Severity, Equals, host.SeverityInfo)
+	}
+	c.Assert([]string{e[0].Metadata["resource"], e[1].Metadata["resource"], e[2].Metadata["resource"]}, DeepEquals, []string{"disk", "load", "memory"})
+
+	// a brief load spike is not reported
+	m.Check(stats(50, 5, 500), now.Add(3*loadPressureDuration))
+	m.Check(stats(50, 1, 500), now.Add(3*loadPressureDuration+time.Minute))
+	c.Assert(events(), HasLen, 0)
+}
@@ -10,6 +10,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -17,12 +18,17 @@ import (
 	"github.com/boltdb/bolt"
 	"github.com/flynn/flynn/host/types"
 	"github.com/flynn/flynn/pkg/cluster"
+	"github.com/flynn/flynn/pkg/random"
 )
 
 // ErrJobExists is returned when attempting to add a job to the state with an
 // ID which already exists
 var ErrJobExists = errors.New("job already exists")
 
+// ErrWebhookDeliveryNotFound is returned when getting a webhook delivery
+// which is not in the queue or dead-letter log
+var ErrWebhookDeliveryNotFound = errors.New("webhook delivery not found")
+
 // maxDownJobsPerApp is the max number of done/crashed/failed jobs that will be
 // stored per app.
 var maxDownJobsPerApp = 25
@@ -43,8 +49,15 @@ type State struct {
 
 	downJobsLRU map[string]*list.List // app ID -> down jobs list, used for garbage collection
 
-	listeners map[string]map[chan host.Event]struct{} // job id -> listener list (ID "all" gets all events)
+	listeners map[string]map[chan host.Event]*eventListener // job id -> listener list (ID "all" gets all events)
 	listenMtx sync.RWMutex
+
+	// events are numbered by eventSeq within eventEpoch and queued on each
+	// listener in that order while holding eventMtx
+	eventEpoch string
+	eventSeq   uint64
+	eventMtx   sync.Mutex
+
 	attachers map[string]map[chan struct{}]struct{}
 
 	stateFilePath string
@@ -63,9 +76,10 @@ func NewState(id string, stateFilePath string) *State {
 		stateFilePath: stateFilePath,
 		jobs:          make(map[string]*host.ActiveJob),
 		downJobsLRU:   make(map[string]*list.List),
-		listeners:     make(map[string]map[chan host.Event]struct{}),
+		listeners:     make(map[string]map[chan host.Event]*eventListener),
 		attachers:     make(map[string]map[chan struct{}]struct{}),
 		dbCond:        sync.NewCond(&sync.Mutex{}),
+		eventEpoch:    random.UUID(),
 	}
 }
 
@@ -73,7 +87,7 @@ func NewState(id string, stateFilePath string) *State {
 	Restore prior state from the save location defined at construction time.
 	If the state save file is empty, nothing is loaded, and no error is returned.
 */
-func (s *State) Restore(backend Backend, buffers host.LogBuffers) (func(), error) {
+func (s *State) Restore(backend Backend, buffers host.LogBuffers) ([]*host.Job, error) {
 	if err := s.Acquire(); err != nil {
 		return nil, err
 	}
@@ -140,28 +154,22 @@ func (s *State) Restore(backend Backend, buffers host.LogBuffers) (func(), error
 		return nil, fmt.Errorf("could not restore from host persistence db: %s", err)
 	}
 
-	return func() {
-		if len(resurrect) == 0 {
-			return
-		}
-		var wg sync.WaitGroup
-		wg.Add(len(resurrect))
-		for _, job := range resurrect {
-			go func(job *host.Job) {
-				// generate a new job id, this is a new job
-				newJob := job.Dup()
-				newJob.ID = cluster.GenerateJobID(s.id, "")
-				if _, ok := newJob.Config.Env["FLYNN_JOB_ID"]; ok {
-					newJob.Config.Env["FLYNN_JOB_ID"] = newJob.ID
-				}
-				log.Printf("resurrecting %s as %s", job.ID, newJob.ID)
-				s.AddJob(newJob)
-				backend.Run(newJob, nil, nil)
-				wg.Done()
-			}(job)
-		}
-		wg.Wait()
-	}, nil
+	return resurrect, nil
+}
+
+// Resurrect starts a new copy of a persistent job which was not running
+// when the state was restored, returning the new job's ID.
+func (s *State) Resurrect(job *host.Job) string {
+	// generate a new job id, this is a new job
+	newJob := job.Dup()
+	newJob.ID = cluster.GenerateJobID(s.id, "")
+	if _, ok := newJob.Config.Env["FLYNN_JOB_ID"]; ok {
+		newJob.Config.Env["FLYNN_JOB_ID"] = newJob.ID
+	}
+	log.Printf("resurrecting %s as %s", job.ID, newJob.ID)
+	s.AddJob(newJob)
+	s.backend.Run(newJob, nil, nil)
+	return newJob.ID
 }
 
 // OpenDB opens and initialises the persistence DB, if not already open.
@@ -190,6 +198,8 @@ func (s *State) OpenDB() error {
 		tx.CreateBucketIfNotExists([]byte("backend-global"))
 		tx.CreateBucketIfNotExists([]byte("persistent-jobs"))
 		tx.CreateBucketIfNotExists([]byte("webhooks"))
+		tx.CreateBucketIfNotExists([]byte("webhook-deliveries"))
+		tx.CreateBucketIfNotExists([]byte("auth-keys"))
 		return nil
 	}); err != nil {
 		return fmt.Errorf("could not initialize host persistence db: %s", err)
@@ -382,6 +392,9 @@ func (s *State) AddJob(j *host.Job) error {
 		HostID:    s.id,
 		CreatedAt: time.Now().UTC(),
 	}
+	if j.StartPhases != nil {
+		job.StartPhases = append([]host.JobStartPhase(nil), j.StartPhases...)
+	}
 	s.jobs[j.ID] = job
 	s.sendEvent(job, host.JobEventCreate)
 	s.persist(j.ID)
@@ -471,6 +484,16 @@ func (s *State) SetForceStop(jobID string) {
 	s.persist(jobID)
 }
 
+// AddStartPhase records the time spent in a phase of starting a job, which is
+// persisted and included in events once the job is running.
+func (s *State) AddStartPhase(jobID, name string, duration time.Duration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if job, ok := s.jobs[jobID]; ok {
+		job.StartPhases = append(job.StartPhases, host.JobStartPhase{Name: name, Duration: duration})
+	}
+}
+
 func (s *State) SetStatusRunning(jobID string) {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
@@ -514,6 +537,25 @@ func (s *State) SetStatusDone(jobID string, exitStatus int) {
 	}
 }
 
+// SetLogTail attaches the last lines of a job's output to the job, and should
+// be called before SetStatusDone so the stop event includes them.
+func (s *State) SetLogTail(jobID string, lines []string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if job, ok := s.jobs[jobID]; ok {
+		job.LogTail = lines
+	}
+}
+
+// SetOOMKilled records that the job was killed by the kernel OOM killer.
+func (s *State) SetOOMKilled(jobID string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if job, ok := s.jobs[jobID]; ok {
+		job.OOMKilled = true
+	}
+}
+
 func (s *State) SetStatusFailed(jobID string, err error) {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
@@ -574,29 +616,93 @@ func (s *State) WaitAttach(jobID string) {
 }
 
 func (s *State) AddListener(jobID string) chan host.Event {
-	ch := make(chan host.Event)
+	l := newEventListener()
 	s.listenMtx.Lock()
 	if _, ok := s.listeners[jobID]; !ok {
-		s.listeners[jobID] = make(map[chan host.Event]struct{})
+		s.listeners[jobID] = make(map[chan host.Event]*eventListener)
 	}
-	s.listeners[jobID][ch] = struct{}{}
+	s.listeners[jobID][l.ch] = l
 	s.listenMtx.Unlock()
-	return ch
+	go l.deliver()
+	return l.ch
 }
 
 func (s *State) RemoveListener(jobID string, ch chan host.Event) {
-	go func() {
-		// drain to prevent deadlock while removing the listener
-		for range ch {
-		}
-	}()
 	s.listenMtx.Lock()
+	l, ok := s.listeners[jobID][ch]
 	delete(s.listeners[jobID], ch)
 	if len(s.listeners[jobID]) == 0 {
 		delete(s.listeners, jobID)
 	}
 	s.listenMtx.Unlock()
-	close(ch)
+	if ok {
+		l.stop()
+	}
+}
+
+// eventListener queues events for a single listener and delivers them in
+// order from its own goroutine, so that a listener which is slow to receive
+// does not hold up delivery to the others.
+type eventListener struct {
+	ch   chan host.Event
+	done chan struct{}
+
+	mtx     sync.Mutex
+	cond    *sync.Cond
+	queue   []host.Event
+	stopped bool
+}
+
+func newEventListener() *eventListener {
+	l := &eventListener{
+		ch:   make(chan host.Event),
+		done: make(chan struct{}),
+	}
+	l.cond = sync.NewCond(&l.mtx)
+	return l
+}
+
+// push queues an event for delivery without blocking
+func (l *eventListener) push(e host.Event) {
+	l.mtx.Lock()
+	l.queue = append(l.queue, e)
+	l.mtx.Unlock()
+	l.cond.Signal()
+}
+
+// stop discards any queued events and closes the listener channel once the
+// delivery goroutine has exited
+func (l *eventListener) stop() {
+	l.mtx.Lock()
+	l.stopped = true
+	l.mtx.Unlock()
+	close(l.done)
+	l.cond.Signal()
+}
+
+func (l *eventListener) deliver() {
+	defer close(l.ch)
+	for {
+		l.mtx.Lock()
+		for len(l.queue) == 0 && !l.stopped {
+			l.cond.Wait()
+		}
+		if l.stopped {
+			l.queue = nil
+			l.mtx.Unlock()
+			return
+		}
+		e := l.queue[0]
+		l.queue[0] = host.Event{}
+		l.queue = l.queue[1:]
+		l.mtx.Unlock()
+
+		select {
+		case l.ch <- e:
+		case <-l.done:
+			return
+		}
+	}
 }
 
 func (s *State) SendCleanupEvent(jobID string) {
@@ -608,18 +714,24 @@ func (s *State) SendCleanupEvent(jobID string) {
 }
 
 func (s *State) sendEvent(job *host.ActiveJob, event host.JobEventType) {
-	j := job.Dup()
-	go func() {
-		s.listenMtx.RLock()
-		defer s.listenMtx.RUnlock()
-		e := host.Event{JobID: job.Job.ID, Job: j, Event: event}
-		for ch := range s.listeners["all"] {
-			ch <- e
-		}
-		for ch := range s.listeners[job.Job.ID] {
-			ch <- e
-		}
-	}()
+	s.eventMtx.Lock()
+	s.eventSeq++
+	e := host.Event{
+		JobID: job.Job.ID,
+		Job:   job.Dup(),
+		Event: event,
+		Seq:   s.eventSeq,
+		Epoch: s.eventEpoch,
+	}
+	s.listenMtx.RLock()
+	for _, l := range s.listeners["all"] {
+		l.push(e)
+	}
+	for _, l := range s.listeners[e.JobID] {
+		l.push(e)
+	}
+	s.listenMtx.RUnlock()
+	s.eventMtx.Unlock()
 
 	// Dispatch webhook events for job lifecycle changes
 	if s.webhookDispatcher != nil {
@@ -685,7 +797,25 @@ func (s *State) RemoveWebhook(id string) error {
 	}
 	defer s.Release()
 	return s.stateDB.Update(func(tx *bolt.Tx) error {
-		return tx.Bucket([]byte("webhooks")).Delete([]byte(id))
+		if err := tx.Bucket([]byte("webhooks")).Delete([]byte(id)); err != nil {
+			return err
+		}
+		// remove the webhook's queued and dead-lettered deliveries
+		b := tx.Bucket([]byte("webhook-deliveries"))
+		var ids [][]byte
+		b.ForEach(func(k, v []byte) error {
+			d := &host.WebhookDelivery{}
+			if err := json.Unmarshal(v, d); err != nil || d.WebhookID == id {
+				ids = append(ids, k)
+			}
+			return nil
+		})
+		for _, k := range ids {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
 }
 
@@ -711,3 +841,109 @@ func (s *State) ListWebhooks() []*host.WebhookConfig {
 	})
 	return webhooks
 }
+
+// PutWebhookDelivery persists a webhook delivery, replacing any existing
+// delivery with the same ID.
+func (s *State) PutWebhookDelivery(d *host.WebhookDelivery) error {
+	if err := s.Acquire(); err != nil {
+		return err
+	}
+	defer s.Release()
+	return s.stateDB.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte("webhook-deliveries")).Put([]byte(d.ID), data)
+	})
+}
+
+// GetWebhookDelivery returns the webhook delivery with the given ID.
+func (s *State) GetWebhookDelivery(id string) (*host.WebhookDelivery, error) {
+	if err := s.Acquire(); err != nil {
+		return nil, err
+	}
+	defer s.Release()
+	var d *host.WebhookDelivery
+	err := s.stateDB.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte("webhook-deliveries")).Get([]byte(id))
+		if v == nil {
+			return ErrWebhookDeliveryNotFound
+		}
+		d = &host.WebhookDelivery{}
+		return json.Unmarshal(v, d)
+	})
+	return d, err
+}
+
+// RemoveWebhookDelivery removes a webhook delivery by ID.
+func (s *State) RemoveWebhookDelivery(id string) error {
+	if err := s.Acquire(); err != nil {
+		return err
+	}
+	defer s.Release()
+	return s.stateDB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("webhook-deliveries")).Delete([]byte(id))
+	})
+}
+
+// ListWebhookDeliveries returns the queued and dead-lettered deliveries to
+// the webhook with the given ID, or to all webhooks if the ID is empty,
+// oldest first.
+func (s *State) ListWebhookDeliveries(webhookID string) []*host.WebhookDelivery {
+	var deliveries []*host.WebhookDelivery
+	if err := s.Acquire(); err != nil {
+		return deliveries
+	}
+	defer s.Release()
+	s.stateDB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("webhook-deliveries")).ForEach(func(k, v []byte) error {
+			d := &host.WebhookDelivery{}
+			if err := json.Unmarshal(v, d); err != nil {
+				return nil // skip corrupt entries
+			}
+			if webhookID == "" || d.WebhookID == webhookID {
+				deliveries = append(deliveries, d)
+			}
+			return nil
+		})
+	})
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt)
+	})
+	return deliveries
+}
+
+// PersistAuthKeys persists the host API auth keys after a rotation.
+func (s *State) PersistAuthKeys(keys []*host.AuthKey) error {
+	if err := s.Acquire(); err != nil {
+		return err
+	}
+	defer s.Release()
+	data, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	return s.stateDB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("auth-keys")).Put([]byte("keys"), data)
+	})
+}
+
+// AuthKeys returns the host API auth keys persisted by the last rotation.
+func (s *State) AuthKeys() []*host.AuthKey {
+	var keys []*host.AuthKey
+	if s.stateDB == nil {
+		return keys
+	}
+	s.stateDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("auth-keys"))
+		if b == nil {
+			return nil
+		}
+		if data := b.Get([]byte("keys")); data != nil {
+			json.Unmarshal(data, &keys)
+		}
+		return nil
+	})
+	return keys
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/flynn/flynn/host/types"
 	. "github.com/flynn/go-check"
@@ -97,3 +98,113 @@ func (S) TestStateExclusiveVolumes(c *C) {
 	c.Assert(addJob("job4", "vol1", "vol2"), ErrorMatches, "volumes in use: vol1")
 	c.Assert(addJob("job4", "vol2", "vol3"), IsNil)
 }
+
+func (S) TestStateStartPhases(c *C) {
+	state := NewState("abc123", filepath.Join(c.MkDir(), "host-state-db"))
+	c.Assert(state.OpenDB(), IsNil)
+	defer state.CloseDB()
+
+	events := state.AddListener("a")
+	defer state.RemoveListener("a", events)
+
+	job := &host.Job{ID: "a", StartPhases: []host.JobStartPhase{{Name: host.JobStartPhaseSchedule, Duration: time.Second}}}
+	c.Assert(state.AddJob(job), IsNil)
+	c.Assert((<-events).Event, Equals, host.JobEventCreate)
+	state.AddStartPhase("a", host.JobStartPhaseLayers, 2*time.Second)
+	state.AddStartPhase("a", host.JobStartPhaseReady, 3*time.Second)
+	state.SetStatusRunning("a")
+
+	e := <-events
+	c.Assert(e.Event, Equals, host.JobEventStart)
+	c.Assert(e.Job.StartPhases, DeepEquals, []host.JobStartPhase{
+		{Name: host.JobStartPhaseSchedule, Duration: time.Second},
+		{Name: host.JobStartPhaseLayers, Duration: 2 * time.Second},
+		{Name: host.JobStartPhaseReady, Duration: 3 * time.Second},
+	})
+
+	// the job's own phases are not modified
+	c.Assert(job.StartPhases, HasLen, 1)
+}
+
+func (S) TestStateEventSequence(c *C) {
+	state := NewState("abc123", filepath.Join(c.MkDir(), "host-state-db"))
+	c.Assert(state.OpenDB(), IsNil)
+	defer state.CloseDB()
+
+	events := state.AddListener("all")
+	defer state.RemoveListener("all", events)
+
+	// events are delivered in the order they were sent with consecutive
+	// sequence numbers, even across jobs
+	for _, id := range []string{"a", "b", "c"} {
+		c.Assert(state.AddJob(&host.Job{ID: id}), IsNil)
+		state.SetStatusRunning(id)
+	}
+	var epoch string
+	for i, expected := range []struct {
+		jobID string
+		event host.JobEventType
+	}{
+		{"a", host.JobEventCreate}, {"a", host.JobEventStart},
+		{"b", host.JobEventCreate}, {"b", host.JobEventStart},
+		{"c", host.JobEventCreate}, {"c", host.JobEventStart},
+	} {
+		e := <-events
+		c.Assert(e.JobID, Equals, expected.jobID)
+		c.Assert(e.Event, Equals, expected.event)
+		c.Assert(e.Seq, Equals, uint64(i+1))
+		if epoch == "" {
+			epoch = e.Epoch
+		}
+		c.Assert(e.Epoch, Equals, epoch)
+	}
+	c.Assert(epoch, Not(Equals), "")
+}
+
+func (S) TestStateBlockedListener(c *C) {
+	state := NewState("abc123", filepath.Join(c.MkDir(), "host-state-db"))
+	c.Assert(state.OpenDB(), IsNil)
+	defer state.CloseDB()
+
+	// a listener which never receives should not block sending events nor
+	// their delivery to other listeners
+	blocked := state.AddListener("all")
+	events := state.AddListener("a")
+	defer state.RemoveListener("a", events)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Assert(state.AddJob(&host.Job{ID: "a"}), IsNil)
+		state.SetStatusRunning("a")
+		state.SetStatusDone("a", 0)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out sending events")
+	}
+	for _, expected := range []host.JobEventType{host.JobEventCreate, host.JobEventStart, host.JobEventStop} {
+		select {
+		case e := <-events:
+			c.Assert(e.Event, Equals, expected)
+		case <-time.After(5 * time.Second):
+			c.Fatalf("timed out waiting for %s event", expected)
+		}
+	}
+
+	// removing the blocked listener discards its queued events and closes
+	// its channel
+	state.RemoveListener("all", blocked)
+	select {
+	case _, ok := <-blocked:
+		if ok {
+			// the event being sent when the listener was removed may
+			// still be received before the channel is closed
+			_, ok = <-blocked
+		}
+		c.Assert(ok, Equals, false)
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for the listener to be closed")
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	host "github.com/flynn/flynn/host/types"
+	"github.com/inconshreveable/log15"
+)
+
+const (
+	// statsHistoryInterval is how often job stats are sampled
+	statsHistoryInterval = 10 * time.Second
+
+	// statsHistorySize is the number of samples kept for each job, 60
+	// minutes at the default interval
+	statsHistorySize = 360
+
+	// statsStreamBuffer is the number of new samples buffered for each
+	// stream, samples are dropped for streams which fall further behind
+	statsStreamBuffer = 64
+)
+
+// StatsHistory periodically samples the stats of all jobs and keeps recent
+// samples in memory so that clients can get a time series rather than a
+// single point-in-time sample. History is not kept across daemon restarts.
+type StatsHistory struct {
+	backend  Backend
+	interval time.Duration
+	size     int
+	done     chan struct{}
+	log      log15.Logger
+
+	mtx       sync.RWMutex
+	jobs      map[string]*statsRing
+	listeners map[string]map[chan *host.ContainerStats]struct{}
+}
+
+// NewStatsHistory creates a new StatsHistory. Call Run() to start sampling.
+func NewStatsHistory(backend Backend, log log15.Logger) *StatsHistory {
+	return &StatsHistory{
+		backend:   backend,
+		interval:  statsHistoryInterval,
+		size:      statsHistorySize,
+		done:      make(chan struct{}),
+		log:       log.New("component", "stats-history"),
+		jobs:      make(map[string]*statsRing),
+		listeners: make(map[string]map[chan *host.ContainerStats]struct{}),
+	}
+}
+
+// Run periodically samples job stats. Should be called in a goroutine.
+func (s *StatsHistory) Run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			stats, err := s.backend.GetAllJobsStats()
+			if err != nil {
+				s.log.Error("error getting job stats", "err", err)
+				continue
+			}
+			if stats != nil {
+				s.Record(stats.Jobs, time.Now())
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Shutdown stops sampling.
+func (s *StatsHistory) Shutdown() {
+	close(s.done)
+}
+
+// Record adds a sample for each job, sends them to any streams and removes
+// the history of jobs which have not been sampled for the whole window.
+func (s *StatsHistory) Record(samples []*host.ContainerStats, now time.Time) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, sample := range samples {
+		ring, ok := s.jobs[sample.JobID]
+		if !ok {
+			ring = newStatsRing(s.size)
+			s.jobs[sample.JobID] = ring
+		}
+		ring.Add(sample)
+		for ch := range s.listeners[sample.JobID] {
+			select {
+			case ch <- sample:
+			default:
+			}
+		}
+	}
+	expiry := now.Add(-time.Duration(s.size) * s.interval)
+	for id, ring := range s.jobs {
+		if last := ring.Last(); last != nil && last.Timestamp.Before(expiry) {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// Get returns the samples for the given job taken after since, oldest first.
+func (s *StatsHistory) Get(jobID string, since time.Time) []*host.ContainerStats {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.get(jobID, since)
+}
+
+func (s *StatsHistory) get(jobID string, since time.Time) []*host.ContainerStats {
+	ring, ok := s.jobs[jobID]
+	if !ok {
+		return []*host.ContainerStats{}
+	}
+	return ring.Since(since)
+}
+
+// Subscribe returns a channel which receives the samples for the given job
+// taken after since followed by new samples as they are taken. The channel
+// must be passed to Unsubscribe once the caller is done with it.
+func (s *StatsHistory) Subscribe(jobID string, since time.Time) chan *host.ContainerStats {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	history := s.get(jobID, since)
+	ch := make(chan *host.ContainerStats, len(history)+statsStreamBuffer)
+	for _, sample := range history {
+		ch <- sample
+	}
+	if _, ok := s.listeners[jobID]; !ok {
+		s.listeners[jobID] = make(map[chan *host.ContainerStats]struct{})
+	}
+	s.listeners[jobID][ch] = struct{}{}
+	return ch
+}
+
+// Unsubscribe stops sending samples to a channel returned by Subscribe.
+func (s *StatsHistory) Unsubscribe(jobID string, ch chan *host.ContainerStats) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if l, ok := s.listeners[jobID]; ok {
+		delete(l, ch)
+		if len(l) == 0 {
+			delete(s.listeners, jobID)
+		}
+	}
+}
+
+// statsRing is a fixed size ring buffer of samples, oldest first.
+type statsRing struct {
+	samples []*host.ContainerStats
+	start   int
+	count   int
+}
+
+func newStatsRing(size int) *statsRing {
+	return &statsRing{samples: make([]*host.ContainerStats, size)}
+}
+
+func (r *statsRing) Add(sample *host.ContainerStats) {
+	if r.count < len(r.samples) {
+		r.samples[(r.start+r.count)%len(r.samples)] = sample
+		r.count++
+		return
+	}
+	r.samples[r.start] = sample
+	r.start = (r.start + 1) % len(r.samples)
+}
+
+func (r *statsRing) Last() *host.ContainerStats {
+	if r.count == 0 {
+		return nil
+	}
+	return r.samples[(r.start+r.count-1)%len(r.samples)]
+}
+
+func (r *statsRing) Since(since time.Time) []*host.ContainerStats {
+	res := make([]*host.ContainerStats, 0, r.count)
+	for i := 0; i < r.count; i++ {
+		sample := r.samples[(r.start+i)%len(r.samples)]
+		if sample.Timestamp.After(since) {
+			res = append(res, sample)
+		}
+	}
+	return res
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"time"
+
+	host "github.com/flynn/flynn/host/types"
+	. "github.com/flynn/go-check"
+	"github.com/inconshreveable/log15"
+)
+
+func (S) TestStatsHistory(c *C) {
+	h := NewStatsHistory(nil, log15.New())
+	h.size = 3
+
+	start := time.Now()
+	sample := func(id string, i int) *host.ContainerStats {
+		return &host.ContainerStats{JobID: id, Timestamp: start.Add(time.Duration(i) * h.interval), CPUUsageNanoseconds: uint64(i)}
+	}
+	cpu := func(samples []*host.ContainerStats) []uint64 {
+		res := make([]uint64, len(samples))
+		for i, s := range samples {
+			res[i] = s.CPUUsageNanoseconds
+		}
+		return res
+	}
+
+	for i := 0; i < 5; i++ {
+		h.Record([]*host.ContainerStats{sample("job1", i)}, start.Add(time.Duration(i)*h.interval))
+	}
+	// only the last size samples are kept, oldest first
+	c.Assert(cpu(h.Get("job1", time.Time{})), DeepEquals, []uint64{2, 3, 4})
+	c.Assert(cpu(h.Get("job1", start.Add(3*h.interval))), DeepEquals, []uint64{4})
+	c.Assert(h.Get("job2", time.Time{}), HasLen, 0)
+
+	// subscribers get the history followed by new samples
+	ch := h.Subscribe("job1", start.Add(2*h.interval))
+	h.Record([]*host.ContainerStats{sample("job1", 5), sample("job2", 5)}, start.Add(5*h.interval))
+	for _, expected := range []uint64{3, 4, 5} {
+		select {
+		case s := <-ch:
+			c.Assert(s.CPUUsageNanoseconds, Equals, expected)
+		default:
+			c.Fatalf("expected sample %d", expected)
+		}
+	}
+	h.Unsubscribe("job1", ch)
+	h.Record([]*host.ContainerStats{sample("job1", 6)}, start.Add(6*h.interval))
+	c.Assert(ch, HasLen, 0)
+
+	// jobs which stop being sampled are removed once their samples expire
+	h.Record(nil, start.Add(10*h.interval))
+	c.Assert(h.Get("job1", time.Time{}), HasLen, 0)
+	c.Assert(h.Get("job2", time.Time{}), HasLen, 0)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/flynn/flynn/pkg/certgen"
+	. "github.com/flynn/go-check"
+)
+
+func (S) TestHostTLSClientCerts(c *C) {
+	dir, err := ioutil.TempDir("", "host-tls")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	ca, err := certgen.Generate(certgen.Params{IsCA: true})
+	c.Assert(err, IsNil)
+	otherCA, err := certgen.Generate(certgen.Params{IsCA: true})
+	c.Assert(err, IsNil)
+	serverCert, err := certgen.Generate(certgen.Params{Hosts: []string{"127.0.0.1"}, CA: ca})
+	c.Assert(err, IsNil)
+	clientCert, err := certgen.Generate(certgen.Params{Hosts: []string{"controller"}, CA: ca, ClientAuth: true})
+	c.Assert(err, IsNil)
+	otherClientCert, err := certgen.Generate(certgen.Params{Hosts: []string{"controller"}, CA: otherCA, ClientAuth: true})
+	c.Assert(err, IsNil)
+
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		c.Assert(ioutil.WriteFile(path, []byte(data), 0600), IsNil)
+		return path
+	}
+	certFile := write("host.pem", serverCert.PEM)
+	keyFile := write("host-key.pem", serverCert.KeyPEM)
+	caFile := write("ca.pem", ca.PEM)
+
+	_, err = loadHostTLS("", "", caFile, false)
+	c.Assert(err, NotNil)
+	_, err = loadHostTLS(certFile, keyFile, "", true)
+	c.Assert(err, NotNil)
+
+	for _, require := range []bool{false, true} {
+		hostTLS, err := loadHostTLS(certFile, keyFile, caFile, require)
+		c.Assert(err, IsNil)
+		h := &Host{authKeys: newAuthKeySet([]string{"key"}), tls: hostTLS}
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		c.Assert(err, IsNil)
+		srv := &http.Server{Handler: h.authMiddleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))}
+		go srv.Serve(tls.NewListener(l, hostTLS.config))
+
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM([]byte(ca.PEM))
+		request := func(path, key string, cert *certgen.Certificate) int {
+			config := &tls.Config{RootCAs: pool}
+			if cert != nil {
+				pair, err := tls.X509KeyPair([]byte(cert.PEM), []byte(cert.KeyPEM))
+				c.Assert(err, IsNil)
+				config.Certificates = []tls.Certificate{pair}
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+			req, err := http.NewRequest("GET", "https://"+l.Addr().String()+path, nil)
+			c.Assert(err, IsNil)
+			if key != "" {
+				req.Header.Set("Auth-Key", key)
+			}
+			res, err := client.Do(req)
+			if err != nil {
+				// the handshake fails for certificates from other CAs
+				return 0
+			}
+			res.Body.Close()
+			return res.StatusCode
+		}
+
+		c.Assert(request("/host/status", "", nil), Equals, http.StatusOK)
+		c.Assert(request("/host/jobs", "", clientCert), Equals, http.StatusOK)
+		c.Assert(request("/host/jobs", "", nil), Equals, http.StatusUnauthorized)
+		c.Assert(request("/host/jobs", "", otherClientCert), Equals, 0)
+		if require {
+			c.Assert(request("/host/jobs", "key", nil), Equals, http.StatusUnauthorized)
+		} else {
+			c.Assert(request("/host/jobs", "key", nil), Equals, http.StatusOK)
+		}
+		srv.Close()
+	}
+}
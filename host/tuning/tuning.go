@@ -0,0 +1,140 @@
+// Package tuning checks kernel settings which affect the performance and
+// stability of the database appliances, and optionally applies the
+// recommended values.
+package tuning
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	host "github.com/flynn/flynn/host/types"
+)
+
+// tunable is a kernel setting read from and written to a file in /proc or
+// /sys.
+type tunable struct {
+	setting     string
+	path        string
+	recommended string
+	message     string
+
+	// value extracts the current value from the contents of the file
+	value func(string) string
+
+	// ok returns whether the current value is acceptable
+	ok func(string) bool
+}
+
+var tunables = []*tunable{
+	{
+		setting:     "transparent_hugepage",
+		path:        "sys/kernel/mm/transparent_hugepage/enabled",
+		recommended: "never",
+		message:     "transparent huge pages cause latency spikes and increased memory usage in MongoDB, PostgreSQL and Redis",
+		value:       selectedOption,
+		ok:          func(v string) bool { return v != "always" },
+	},
+	{
+		setting:     "vm.max_map_count",
+		path:        "proc/sys/vm/max_map_count",
+		recommended: "262144",
+		message:     "a low memory map limit can cause MongoDB to fail under load",
+		value:       strings.TrimSpace,
+		ok:          atLeast(262144),
+	},
+	{
+		setting:     "vm.swappiness",
+		path:        "proc/sys/vm/swappiness",
+		recommended: "1",
+		message:     "swapping database memory to disk severely degrades performance",
+		value:       strings.TrimSpace,
+		ok:          atMost(10),
+	},
+}
+
+// selectedOption returns the selected option of a sysfs setting, which is
+// formatted like "always [madvise] never".
+func selectedOption(s string) string {
+	for _, opt := range strings.Fields(s) {
+		if strings.HasPrefix(opt, "[") && strings.HasSuffix(opt, "]") {
+			return strings.Trim(opt, "[]")
+		}
+	}
+	return strings.TrimSpace(s)
+}
+
+func atLeast(min int64) func(string) bool {
+	return func(v string) bool {
+		n, err := strconv.ParseInt(v, 10, 64)
+		return err != nil || n >= min
+	}
+}
+
+func atMost(max int64) func(string) bool {
+	return func(v string) bool {
+		n, err := strconv.ParseInt(v, 10, 64)
+		return err != nil || n <= max
+	}
+}
+
+// Advisor checks the kernel settings of the host.
+type Advisor struct {
+	root string
+}
+
+// NewAdvisor returns an Advisor which reads /proc and /sys relative to root,
+// which is "/" for the running host.
+func NewAdvisor(root string) *Advisor {
+	return &Advisor{root: root}
+}
+
+// Check returns a warning for each setting which doesn't have an acceptable
+// value. Settings which cannot be read (e.g. because the kernel doesn't
+// support them) are skipped.
+func (a *Advisor) Check() []*host.HostWarning {
+	var warnings []*host.HostWarning
+	for _, t := range tunables {
+		if w := a.check(t); w != nil {
+			warnings = append(warnings, w)
+		}
+	}
+	return warnings
+}
+
+func (a *Advisor) check(t *tunable) *host.HostWarning {
+	data, err := ioutil.ReadFile(filepath.Join(a.root, t.path))
+	if err != nil {
+		return nil
+	}
+	value := t.value(string(data))
+	if t.ok(value) {
+		return nil
+	}
+	return &host.HostWarning{
+		Setting:     t.setting,
+		Value:       value,
+		Recommended: t.recommended,
+		Message:     t.message,
+	}
+}
+
+// Fix sets each setting which doesn't have an acceptable value to its
+// recommended value, returning the warnings which were fixed.
+func (a *Advisor) Fix() ([]*host.HostWarning, error) {
+	var fixed []*host.HostWarning
+	for _, t := range tunables {
+		w := a.check(t)
+		if w == nil {
+			continue
+		}
+		path := filepath.Join(a.root, t.path)
+		if err := ioutil.WriteFile(path, []byte(t.recommended), 0644); err != nil {
+			return fixed, fmt.Errorf("error setting %s: %s", t.setting, err)
+		}
+		fixed = append(fixed, w)
+	}
+	return fixed, nil
+}
@@ -0,0 +1,76 @@
+package tuning
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeSetting(t *testing.T, root, path, value string) {
+	path = filepath.Join(root, path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(value), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAdvisor(t *testing.T) {
+	root, err := ioutil.TempDir("", "tuning")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	writeSetting(t, root, "sys/kernel/mm/transparent_hugepage/enabled", "[always] madvise never\n")
+	writeSetting(t, root, "proc/sys/vm/max_map_count", "65530\n")
+	writeSetting(t, root, "proc/sys/vm/swappiness", "1\n")
+
+	a := NewAdvisor(root)
+	warnings := a.Check()
+	if len(warnings) != 2 {
+		t.Fatalf("expected 2 warnings, got %d", len(warnings))
+	}
+	if w := warnings[0]; w.Setting != "transparent_hugepage" || w.Value != "always" || w.Recommended != "never" {
+		t.Fatalf("unexpected warning: %+v", w)
+	}
+	if w := warnings[1]; w.Setting != "vm.max_map_count" || w.Value != "65530" {
+		t.Fatalf("unexpected warning: %+v", w)
+	}
+
+	fixed, err := a.Fix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixed) != 2 {
+		t.Fatalf("expected 2 fixed settings, got %d", len(fixed))
+	}
+	data, err := ioutil.ReadFile(filepath.Join(root, "proc/sys/vm/max_map_count"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "262144" {
+		t.Fatalf("expected max_map_count to be set, got %q", data)
+	}
+
+	// sysfs reports the selected option in brackets after a write, so
+	// simulate that before checking again
+	writeSetting(t, root, "sys/kernel/mm/transparent_hugepage/enabled", "always madvise [never]\n")
+	if warnings := a.Check(); len(warnings) != 0 {
+		t.Fatalf("expected no warnings after fixing, got %+v", warnings)
+	}
+}
+
+func TestCheckMissingSettings(t *testing.T) {
+	root, err := ioutil.TempDir("", "tuning")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	if warnings := NewAdvisor(root).Check(); len(warnings) != 0 {
+		t.Fatalf("expected no warnings for missing settings, got %+v", warnings)
+	}
+}
@@ -0,0 +1,154 @@
+package host
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// JobSelectorOp is how a JobSelectorRequirement compares job metadata.
+type JobSelectorOp string
+
+const (
+	// JobSelectorOpEqual matches jobs whose metadata value for the key
+	// equals the requirement's value
+	JobSelectorOpEqual JobSelectorOp = "="
+
+	// JobSelectorOpNotEqual matches jobs whose metadata value for the key
+	// does not equal the requirement's value, including jobs without the
+	// key
+	JobSelectorOpNotEqual JobSelectorOp = "!="
+
+	// JobSelectorOpExists matches jobs which have the key
+	JobSelectorOpExists JobSelectorOp = "exists"
+
+	// JobSelectorOpNotExists matches jobs which do not have the key
+	JobSelectorOpNotExists JobSelectorOp = "!exists"
+)
+
+// JobSelectorRequirement is a condition on a job metadata key.
+type JobSelectorRequirement struct {
+	Key   string        `json:"key"`
+	Op    JobSelectorOp `json:"op"`
+	Value string        `json:"value,omitempty"`
+}
+
+// JobSelector selects the jobs whose metadata meets all of its
+// requirements.
+type JobSelector []*JobSelectorRequirement
+
+// ParseJobSelector parses a comma separated list of requirements, each of
+// which is key=value, key!=value, key (the key exists) or !key (the key
+// does not exist), for example
+// "flynn-controller.app=abc,flynn-controller.release!=def".
+func ParseJobSelector(s string) (JobSelector, error) {
+	var sel JobSelector
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		var r *JobSelectorRequirement
+		if kv := strings.SplitN(entry, "!=", 2); len(kv) == 2 {
+			r = &JobSelectorRequirement{Key: kv[0], Op: JobSelectorOpNotEqual, Value: kv[1]}
+		} else if kv := strings.SplitN(entry, "=", 2); len(kv) == 2 {
+			r = &JobSelectorRequirement{Key: kv[0], Op: JobSelectorOpEqual, Value: kv[1]}
+		} else if strings.HasPrefix(entry, "!") {
+			r = &JobSelectorRequirement{Key: entry[1:], Op: JobSelectorOpNotExists}
+		} else {
+			r = &JobSelectorRequirement{Key: entry, Op: JobSelectorOpExists}
+		}
+		sel = append(sel, r)
+	}
+	return sel, sel.Validate()
+}
+
+// Validate checks that the selector has at least one requirement, so that
+// it cannot select every job by mistake, and that each requirement has a
+// key and a known operator.
+func (s JobSelector) Validate() error {
+	if len(s) == 0 {
+		return errors.New("selector must have at least one requirement")
+	}
+	for _, r := range s {
+		if r == nil || r.Key == "" {
+			return errors.New("selector requirement key must be set")
+		}
+		switch r.Op {
+		case JobSelectorOpEqual, JobSelectorOpNotEqual:
+		case JobSelectorOpExists, JobSelectorOpNotExists:
+			if r.Value != "" {
+				return fmt.Errorf("selector requirement %q %s must not have a value", r.Key, r.Op)
+			}
+		default:
+			return fmt.Errorf("unknown selector operator %q", r.Op)
+		}
+	}
+	return nil
+}
+
+// Matches returns whether the given job metadata meets all of the
+// selector's requirements.
+func (s JobSelector) Matches(meta map[string]string) bool {
+	for _, r := range s {
+		v, ok := meta[r.Key]
+		switch r.Op {
+		case JobSelectorOpEqual:
+			if !ok || v != r.Value {
+				return false
+			}
+		case JobSelectorOpNotEqual:
+			if ok && v == r.Value {
+				return false
+			}
+		case JobSelectorOpExists:
+			if !ok {
+				return false
+			}
+		case JobSelectorOpNotExists:
+			if ok {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (s JobSelector) String() string {
+	entries := make([]string, len(s))
+	for i, r := range s {
+		switch r.Op {
+		case JobSelectorOpExists:
+			entries[i] = r.Key
+		case JobSelectorOpNotExists:
+			entries[i] = "!" + r.Key
+		default:
+			entries[i] = r.Key + string(r.Op) + r.Value
+		}
+	}
+	return strings.Join(entries, ",")
+}
+
+// BulkStopRequest is the request body of POST /host/jobs-bulk/stop.
+type BulkStopRequest struct {
+	Selector JobSelector `json:"selector"`
+}
+
+// BulkSignalRequest is the request body of POST /host/jobs-bulk/signal.
+type BulkSignalRequest struct {
+	Selector JobSelector `json:"selector"`
+	Signal   int         `json:"signal"`
+}
+
+// BulkJobsResult is the result of a bulk job operation.
+type BulkJobsResult struct {
+	// Jobs are the IDs of the selected jobs which the operation was
+	// applied to
+	Jobs []string `json:"jobs"`
+
+	// Errors maps the IDs of selected jobs which the operation failed
+	// for to the error
+	Errors map[string]string `json:"errors,omitempty"`
+}
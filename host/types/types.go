@@ -1,6 +1,7 @@
 package host
 
 import (
+	"encoding/json"
 	"errors"
 	"os"
 	"time"
@@ -11,6 +12,24 @@ import (
 // TagPrefix is the prefix added to tags in discoverd instance metadata
 const TagPrefix = "tag:"
 
+// ProfilesMetaKey is the discoverd instance metadata key hosts use to
+// advertise the job profiles they support, as a comma separated list
+const ProfilesMetaKey = "profiles"
+
+// LayersMetaKey is the discoverd instance metadata key hosts use to
+// advertise the image layers they serve from their layer cache at
+// /host/layers/:id, as a comma separated list of layer IDs
+const LayersMetaKey = "layers"
+
+// LayerSizeHeader and LayerHashesHeader are set on layers served from
+// /host/layers/:id to the size of the layer in bytes and, if the layer is
+// in a recorded image manifest, its hashes as a comma separated list of
+// algorithm=hex pairs
+const (
+	LayerSizeHeader   = "Flynn-Layer-Size"
+	LayerHashesHeader = "Flynn-Layer-Hashes"
+)
+
 const DiffPath = "/.container-diff"
 
 type Job struct {
@@ -30,8 +49,44 @@ type Job struct {
 	Resurrect bool `json:"resurrect,omitempty"`
 
 	Profiles []JobProfile `json:"profiles,omitempty"`
+
+	// StartPhases are the phases of starting the job which happened
+	// before it was added to the host (e.g. scheduling), and are included
+	// in the ActiveJob's StartPhases
+	StartPhases []JobStartPhase `json:"start_phases,omitempty"`
+}
+
+// JobStartPhase is the time spent in one phase of starting a job.
+type JobStartPhase struct {
+	Name     string        `json:"name"`
+	Duration time.Duration `json:"duration"`
 }
 
+// Job start phases, in the order they happen
+const (
+	// JobStartPhaseSchedule is the time taken to place the job on a host
+	JobStartPhaseSchedule = "schedule"
+
+	// JobStartPhaseVolumes is the time taken to create the job's volumes
+	JobStartPhaseVolumes = "volumes"
+
+	// JobStartPhaseWait is the time the host waited for networking,
+	// discoverd or start concurrency before starting the job
+	JobStartPhaseWait = "wait"
+
+	// JobStartPhaseLayers is the time taken to fetch and mount the job's
+	// image layers
+	JobStartPhaseLayers = "layers"
+
+	// JobStartPhaseCreate is the time taken to configure and create the
+	// container
+	JobStartPhaseCreate = "create"
+
+	// JobStartPhaseReady is the time from the container being created to
+	// the job's process running
+	JobStartPhaseReady = "ready"
+)
+
 func (j *Job) Dup() *Job {
 	job := *j
 
@@ -54,6 +109,9 @@ func (j *Job) Dup() *Job {
 		return res
 	}
 	job.Metadata = dupMap(j.Metadata)
+	if j.StartPhases != nil {
+		job.StartPhases = append([]JobStartPhase(nil), j.StartPhases...)
+	}
 	job.Config.Args = dupSlice(j.Config.Args)
 	job.Config.Env = dupMap(j.Config.Env)
 	if j.Config.Ports != nil {
@@ -68,6 +126,10 @@ func (j *Job) Dup() *Job {
 			job.Config.Mounts[i] = m
 		}
 	}
+	if j.Config.CoreDumps != nil {
+		coreDumps := *j.Config.CoreDumps
+		job.Config.CoreDumps = &coreDumps
+	}
 
 	return &job
 }
@@ -145,6 +207,23 @@ type ContainerConfig struct {
 	AllowedDevices     *[]*Device        `json:"allowed_devices,omitempty"`
 	AutoCreatedDevices *[]*Device        `json:"auto_created_devices,omitempty"`
 	WriteableCgroups   bool              `json:"writeable_cgroups,omitempty"`
+	CoreDumps          *CoreDumpConfig   `json:"core_dumps,omitempty"`
+}
+
+// CoreDumpConfig enables capturing core dumps of crashing processes in a job,
+// which are then available from the host API until they are cleaned up.
+type CoreDumpConfig struct {
+	// MaxSize is the maximum total size in bytes of the core dumps kept for
+	// the job, with the oldest dumps being removed to stay within it, and
+	// also limits the size of each individual dump (defaults to 1GiB)
+	MaxSize int64 `json:"max_size,omitempty"`
+}
+
+// CoreDump is a core dump captured from a job.
+type CoreDump struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 // Apply 'y' to 'x', returning a new structure.  'y' trumps.
@@ -195,6 +274,9 @@ func (x ContainerConfig) Merge(y ContainerConfig) ContainerConfig {
 	if y.HostPIDNamespace {
 		x.HostPIDNamespace = y.HostPIDNamespace
 	}
+	if y.CoreDumps != nil {
+		x.CoreDumps = y.CoreDumps
+	}
 	return x
 }
 
@@ -264,6 +346,15 @@ type Event struct {
 	Event JobEventType `json:"event,omitempty"`
 	JobID string       `json:"job_id,omitempty"`
 	Job   *ActiveJob   `json:"job,omitempty"`
+
+	// Seq is a sequence number which increases by one for each event
+	// sent by the host across all jobs, so that consumers of the "all"
+	// stream can detect missed and duplicated events
+	Seq uint64 `json:"seq,omitempty"`
+
+	// Epoch identifies the sequence Seq belongs to, and changes whenever
+	// the sequence restarts (e.g. when the daemon restarts)
+	Epoch string `json:"epoch,omitempty"`
 }
 
 type ActiveJob struct {
@@ -278,6 +369,18 @@ type ActiveJob struct {
 	EndedAt    time.Time `json:"ended_at,omitempty"`
 	ExitStatus *int      `json:"exit_status,omitempty"`
 	Error      *string   `json:"error,omitempty"`
+
+	// LogTail is the last lines of the job's stdout and stderr, captured
+	// when the job exits with a non-zero status.
+	LogTail []string `json:"log_tail,omitempty"`
+
+	// OOMKilled is set if the job was killed for exceeding its hard memory
+	// limit.
+	OOMKilled bool `json:"oom_killed,omitempty"`
+
+	// StartPhases is the time spent in each phase of starting the job,
+	// which is complete once the job is running.
+	StartPhases []JobStartPhase `json:"start_phases,omitempty"`
 }
 
 func (j *ActiveJob) Dup() *ActiveJob {
@@ -289,6 +392,12 @@ func (j *ActiveJob) Dup() *ActiveJob {
 	if j.Error != nil {
 		*job.Error = *j.Error
 	}
+	if j.LogTail != nil {
+		job.LogTail = append([]string(nil), j.LogTail...)
+	}
+	if j.StartPhases != nil {
+		job.StartPhases = append([]JobStartPhase(nil), j.StartPhases...)
+	}
 	return &job
 }
 
@@ -304,6 +413,17 @@ type AttachReq struct {
 	Width  uint16     `json:"width,omitempty"`
 }
 
+// ExecReq is a request to run a command in the container of a running job,
+// the streams of which are attached using the same framing as AttachReq.
+type ExecReq struct {
+	Cmd    []string          `json:"cmd"`
+	Env    map[string]string `json:"env,omitempty"`
+	TTY    bool              `json:"tty,omitempty"`
+	Flags  AttachFlag        `json:"flags,omitempty"`
+	Height uint16            `json:"height,omitempty"`
+	Width  uint16            `json:"width,omitempty"`
+}
+
 type AttachFlag uint8
 
 const (
@@ -367,6 +487,275 @@ type HostStatus struct {
 	Network   *NetworkConfig    `json:"network,omitempty"`
 	Version   string            `json:"version"`
 	Flags     []string          `json:"flags"`
+	Profiles  []JobProfile      `json:"profiles,omitempty"`
+	Warnings  []*HostWarning    `json:"warnings,omitempty"`
+
+	// Lite is true for single-node hosts running in lite mode, which
+	// configure their own network and don't need flannel
+	Lite bool `json:"lite,omitempty"`
+
+	// Maintenance is set if the host has maintenance windows configured
+	Maintenance *MaintenanceStatus `json:"maintenance,omitempty"`
+
+	// RateLimits is set if requests from unauthenticated clients are
+	// rate limited
+	RateLimits *RateLimitsStatus `json:"rate_limits,omitempty"`
+
+	// Drain is set if the host is being or has been drained
+	Drain *DrainStatus `json:"drain,omitempty"`
+
+	// Reservations is set if the host rejects jobs which would reserve
+	// more memory or CPU than it allows
+	Reservations *ReservationStatus `json:"reservations,omitempty"`
+
+	// DiskPressure is set if the host rejects jobs or evicts jobs when
+	// disk usage is too high
+	DiskPressure *DiskPressureStatus `json:"disk_pressure,omitempty"`
+
+	// Boot is set once the daemon has restored its state, and describes
+	// the resurrection of system app jobs in dependency order
+	Boot *BootStatus `json:"boot,omitempty"`
+}
+
+// BootStatus describes the resurrection of jobs after the daemon restarts,
+// which starts each system app only once the apps it depends on are
+// available.
+type BootStatus struct {
+	StartedAt  time.Time        `json:"started_at"`
+	FinishedAt *time.Time       `json:"finished_at,omitempty"`
+	Apps       []*BootAppStatus `json:"apps"`
+}
+
+type BootAppState string
+
+const (
+	// BootAppStateWaiting means the app's dependencies are not yet met
+	BootAppStateWaiting BootAppState = "waiting"
+
+	// BootAppStateStarted means the app's jobs have been started
+	BootAppStateStarted BootAppState = "started"
+
+	// BootAppStateTimedOut means the app's jobs were started without
+	// its dependencies being met, after waiting for the boot timeout
+	BootAppStateTimedOut BootAppState = "timed_out"
+)
+
+// BootAppStatus describes the resurrection of the jobs of a single app.
+type BootAppStatus struct {
+	App       string       `json:"app"`
+	State     BootAppState `json:"state"`
+	DependsOn []string     `json:"depends_on,omitempty"`
+
+	// WaitingFor lists the dependencies which were not met when last
+	// checked, with the reason each is not met
+	WaitingFor map[string]string `json:"waiting_for,omitempty"`
+
+	Jobs  []string  `json:"jobs"`
+	Since time.Time `json:"since"`
+}
+
+// DiskPressureStatus describes how the host protects itself from running out
+// of disk space.
+type DiskPressureStatus struct {
+	// UsedPercent is the disk usage percentage when it was last checked
+	UsedPercent float64 `json:"used_percent"`
+
+	// RejectPercent is the usage above which new jobs are rejected, or
+	// zero if jobs are never rejected
+	RejectPercent float64 `json:"reject_percent"`
+
+	// EvictPercent is the usage above which jobs are evicted, or zero if
+	// jobs are never evicted
+	EvictPercent float64 `json:"evict_percent"`
+
+	// Rejecting is whether new jobs are being rejected, since Since
+	Rejecting bool       `json:"rejecting"`
+	Since     *time.Time `json:"since,omitempty"`
+
+	// EvictedJobs are the jobs evicted while under disk pressure
+	EvictedJobs []string `json:"evicted_jobs,omitempty"`
+}
+
+// ReservationStatus describes the memory and CPU reserved by the active jobs
+// on a host, which AddJob does not allow to exceed the host's capacity
+// multiplied by the overcommit ratio.
+type ReservationStatus struct {
+	OvercommitRatio float64             `json:"overcommit_ratio"`
+	Memory          ResourceReservation `json:"memory"`
+	CPU             ResourceReservation `json:"cpu"`
+}
+
+// ResourceReservation describes the reservations of a resource, in bytes for
+// memory and milliCPU for CPU.
+type ResourceReservation struct {
+	// Capacity is the amount of the resource the host has
+	Capacity int64 `json:"capacity"`
+
+	// Limit is the capacity multiplied by the overcommit ratio
+	Limit int64 `json:"limit"`
+
+	// Reserved is the amount reserved by active jobs
+	Reserved int64 `json:"reserved"`
+}
+
+// InsufficientResourcesDetail is the detail of the error returned when adding
+// a job which would reserve more of a resource than the host allows.
+type InsufficientResourcesDetail struct {
+	Resource  resource.Type `json:"resource"`
+	Requested int64         `json:"requested"`
+	Reserved  int64         `json:"reserved"`
+	Limit     int64         `json:"limit"`
+}
+
+// DrainMetaKey is the discoverd instance metadata key set by hosts which are
+// draining, so that the scheduler stops placing jobs on them and moves their
+// jobs to other hosts
+const DrainMetaKey = "drain"
+
+// DefaultDrainTimeout is how long a host waits for its jobs to be moved to
+// other hosts when draining if no timeout is given.
+const DefaultDrainTimeout = 5 * time.Minute
+
+// DrainRequest is the request body of POST /host/drain.
+type DrainRequest struct {
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+// DrainStatus describes the progress of draining a host.
+type DrainStatus struct {
+	StartedAt   time.Time  `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+
+	// TimedOut is set if the drain completed before all jobs which can
+	// be moved to other hosts had stopped
+	TimedOut bool `json:"timed_out,omitempty"`
+
+	// Jobs are the IDs of the running jobs the host is waiting for the
+	// scheduler to move to other hosts
+	Jobs []string `json:"jobs,omitempty"`
+}
+
+// LayerGCRequest is the request body of POST /host/layer-gc.
+type LayerGCRequest struct {
+	// Retain is the number of previously installed releases whose layers
+	// are kept in addition to the latest, defaulting to the host's
+	// --layer-gc-retain
+	Retain *int `json:"retain,omitempty"`
+
+	// DryRun reports what would be removed without removing it
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// LayerGCResult describes the layers removed from a host's layer cache.
+type LayerGCResult struct {
+	// Removed are the IDs of the removed layers, and RemovedBytes their
+	// total size
+	Removed      []string `json:"removed,omitempty"`
+	RemovedBytes int64    `json:"removed_bytes"`
+
+	// Kept is the number of layers kept in the cache
+	Kept int `json:"kept"`
+
+	// Releases are the versions of the installed releases whose layers
+	// were kept, newest first, and RemovedReleases those which are no
+	// longer retained
+	Releases        []string `json:"releases,omitempty"`
+	RemovedReleases []string `json:"removed_releases,omitempty"`
+
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// Done returns whether the drain has completed.
+func (s *DrainStatus) Done() bool {
+	return s.CompletedAt != nil
+}
+
+// RateLimitsStatus describes the host API rate limits for read and write
+// requests, which are nil if not limited.
+type RateLimitsStatus struct {
+	Read  *RateLimitStatus `json:"read,omitempty"`
+	Write *RateLimitStatus `json:"write,omitempty"`
+}
+
+// RateLimitStatus describes the state of a per-client rate limit.
+type RateLimitStatus struct {
+	// Limit is the number of requests allowed from each client per window
+	Limit int `json:"limit"`
+
+	// Window is the duration the limit applies to
+	Window string `json:"window"`
+
+	// Clients is the number of clients which made requests recently
+	Clients int `json:"clients"`
+
+	// Limited is the number of clients currently at the limit
+	Limited int `json:"limited"`
+
+	// Rejected is the total number of rejected requests
+	Rejected uint64 `json:"rejected"`
+}
+
+// AuthKey is a key accepted by the host API. Keys replaced by a rotation
+// expire once their grace period has passed.
+type AuthKey struct {
+	// Hash is the hex encoded SHA-256 hash of the key, the key itself
+	// is not stored
+	Hash string `json:"hash,omitempty"`
+
+	// Key is the key itself, as stored by older versions, which is
+	// replaced with its hash when loaded
+	Key string `json:"key,omitempty"`
+
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// AuthKeyRotation is a request to make Key the host's auth key. The existing
+// keys remain valid for GracePeriod so that clients can switch to the new key
+// without any requests failing.
+type AuthKeyRotation struct {
+	Key string `json:"key"`
+
+	// GracePeriod is how long existing keys remain valid, defaulting to
+	// DefaultAuthKeyGracePeriod if zero
+	GracePeriod time.Duration `json:"grace_period,omitempty"`
+}
+
+// DefaultAuthKeyGracePeriod is how long keys replaced by a rotation remain
+// valid if the rotation doesn't specify a grace period.
+const DefaultAuthKeyGracePeriod = 10 * time.Minute
+
+// AuthKeyInfo describes a valid host auth key without exposing it.
+type AuthKeyInfo struct {
+	// Fingerprint is a prefix of the hex encoded SHA-256 hash of the key
+	Fingerprint string     `json:"fingerprint"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// MaintenanceStatus describes a host's maintenance windows, during which it
+// is preferred for restarts and updates.
+type MaintenanceStatus struct {
+	// Windows are the window specs the host was configured with
+	Windows []string `json:"windows"`
+
+	// Active is true if the host is currently in a maintenance window
+	Active bool `json:"active"`
+
+	// Until is when the active window ends
+	Until *time.Time `json:"until,omitempty"`
+
+	// Next is when the next window starts
+	Next *time.Time `json:"next,omitempty"`
+}
+
+// HostWarning describes a host setting which is unsuitable for running
+// database appliances.
+type HostWarning struct {
+	Setting     string `json:"setting"`
+	Value       string `json:"value"`
+	Recommended string `json:"recommended"`
+	Message     string `json:"message"`
 }
 
 type JobEventType string
@@ -379,8 +768,40 @@ const (
 	JobEventCleanup JobEventType = "cleanup"
 )
 
+// ResourceCheck requests that a host check it has the given resources
+// available before a job using them is placed on it. The response to a
+// failed check is a ResourceCheck listing only the resources which are not
+// available.
 type ResourceCheck struct {
+	// Ports are ports which must not already be bound on the host
 	Ports []Port `json:"ports,omitempty"`
+
+	// Memory is the memory in bytes which would be reserved, which must
+	// not exceed the host's free schedulable memory
+	Memory int64 `json:"memory,omitempty"`
+
+	// VolumeSize is the total size in bytes of the volumes which would be
+	// created, which must not exceed the free space of VolumeProvider,
+	// the "default" provider if not set
+	VolumeSize     int64  `json:"volume_size,omitempty"`
+	VolumeProvider string `json:"volume_provider,omitempty"`
+
+	// Devices are the paths of devices which must exist on the host
+	Devices []string `json:"devices,omitempty"`
+
+	// Insufficient is set in the response to a failed check to the
+	// requested amounts of memory and volume space which the host does
+	// not have
+	Insufficient []*ResourceShortfall `json:"insufficient,omitempty"`
+}
+
+// ResourceShortfall describes a requested amount of a resource which a host
+// does not have available.
+type ResourceShortfall struct {
+	// Resource is either "memory" or "volume_size"
+	Resource  string `json:"resource"`
+	Requested int64  `json:"requested"`
+	Available int64  `json:"available"`
 }
 
 type Command struct {
@@ -394,6 +815,21 @@ type LogBuffers map[string]LogBuffer
 
 type LogBuffer map[string]string
 
+// JobLogLine is a line of a job's output streamed by the host API.
+type JobLogLine struct {
+	Timestamp time.Time `json:"timestamp"`
+
+	// Stream is the stream the line was written to, either "stdout",
+	// "stderr" or "init"
+	Stream string `json:"stream"`
+
+	Msg string `json:"msg"`
+}
+
+// DefaultJobLogTail is the number of lines of a job's log returned by
+// default when streaming it from the host API.
+const DefaultJobLogTail = 100
+
 // ContainerStats contains runtime resource usage for a container/job.
 // These stats are collected from cgroups and network interfaces.
 type ContainerStats struct {
@@ -479,10 +915,63 @@ type AllJobsStats struct {
 
 // WebhookConfig represents a configured webhook endpoint
 type WebhookConfig struct {
-	ID        string            `json:"id"`
-	URL       string            `json:"url"`
-	Headers   map[string]string `json:"headers,omitempty"`
-	CreatedAt time.Time         `json:"created_at"`
+	ID      string            `json:"id"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Secret, if set, is used to sign deliveries (see SignWebhook). It is
+	// omitted when listing webhooks, with HasSecret set instead.
+	Secret    string `json:"secret,omitempty"`
+	HasSecret bool   `json:"has_secret,omitempty"`
+
+	// Codes and MinSeverity filter the events delivered to the webhook
+	// (see Matches), with all events delivered if neither is set
+	Codes       []string  `json:"codes,omitempty"`
+	MinSeverity string    `json:"min_severity,omitempty"`
+
+	// MaxAttempts is the number of times a delivery is attempted before
+	// it is moved to the dead-letter log, with a default of 5 if zero
+	MaxAttempts int       `json:"max_attempts,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// WebhookTestResult is the outcome of delivering a synthetic test event to a
+// webhook endpoint
+type WebhookTestResult struct {
+	WebhookID  string `json:"webhook_id"`
+	EventID    string `json:"event_id"`
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+type WebhookDeliveryState string
+
+const (
+	// WebhookDeliveryStatePending deliveries are queued to be attempted
+	// at NextAttemptAt
+	WebhookDeliveryStatePending WebhookDeliveryState = "pending"
+
+	// WebhookDeliveryStateFailed deliveries have exhausted their attempts
+	// or were rejected by the endpoint, and are kept in the dead-letter
+	// log until they are retried or the webhook is removed
+	WebhookDeliveryStateFailed WebhookDeliveryState = "failed"
+)
+
+// WebhookDelivery is a persisted delivery of an event to a webhook which has
+// not yet succeeded. Successful deliveries are removed from the queue.
+type WebhookDelivery struct {
+	ID            string               `json:"id"`
+	WebhookID     string               `json:"webhook_id"`
+	EventID       string               `json:"event_id"`
+	Code          string               `json:"code"`
+	State         WebhookDeliveryState `json:"state"`
+	Attempts      int                  `json:"attempts"`
+	StatusCode    int                  `json:"status_code,omitempty"`
+	Error         string               `json:"error,omitempty"`
+	Payload       json.RawMessage      `json:"payload"`
+	NextAttemptAt time.Time            `json:"next_attempt_at"`
+	CreatedAt     time.Time            `json:"created_at"`
+	UpdatedAt     time.Time            `json:"updated_at"`
 }
 
 // WebhookEvent is the payload sent to webhook endpoints. The embedded Job is
@@ -519,6 +1008,7 @@ type WebhookJob struct {
 	EndedAt    time.Time `json:"ended_at,omitempty"`
 	ExitStatus *int      `json:"exit_status,omitempty"`
 	Error      *string   `json:"error,omitempty"`
+	LogTail    []string  `json:"log_tail,omitempty"`
 }
 
 // Webhook event severity levels
@@ -543,6 +1033,16 @@ const (
 	CodeMemoryHard     = "H21" // Hard memory limit exceeded (OOM kill)
 )
 
+// H3x-codes: Host resource pressure events
+const (
+	CodeDiskPressure      = "H30" // Host disk usage above threshold
+	CodeLoadPressure      = "H31" // Host load average sustained above threshold
+	CodeMemoryPressure    = "H32" // Host available memory below floor
+	CodeResourceRecovered = "H33" // Host resource no longer under pressure
+	CodeDiskCritical      = "H34" // Host disk usage above reject threshold, rejecting new jobs
+	CodeJobEvicted        = "H35" // Job evicted due to disk pressure
+)
+
 // R-codes: Runtime events
 const (
 	CodeMountFailure   = "R10" // Squashfs mount/verification failure
@@ -553,4 +1053,6 @@ const (
 	CodeDaemonStart    = "D10" // Daemon started
 	CodeDaemonShutdown = "D11" // Daemon shutting down
 	CodeDaemonUpdate   = "D12" // Daemon zero-downtime update initiated
+	CodeDaemonDrained  = "D13" // Daemon finished draining jobs
+	CodeWebhookTest    = "D14" // Synthetic event sent to test a webhook
 )
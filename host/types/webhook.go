@@ -0,0 +1,90 @@
+package host
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// WebhookSignatureHeader is the header containing the signature of webhook
+// deliveries to webhooks which have a secret.
+const WebhookSignatureHeader = "X-Flynn-Signature"
+
+// DefaultWebhookTolerance is the maximum age of events accepted by
+// VerifyWebhook when no tolerance is given.
+const DefaultWebhookTolerance = 5 * time.Minute
+
+var (
+	ErrWebhookSignatureInvalid = errors.New("host: invalid webhook signature")
+	ErrWebhookEventExpired     = errors.New("host: webhook event timestamp outside of tolerance")
+)
+
+// SeverityLevel returns the level of a webhook event severity, which is
+// higher for more severe events, or -1 if the severity is not known.
+func SeverityLevel(severity string) int {
+	switch severity {
+	case SeverityInfo:
+		return 0
+	case SeverityWarning:
+		return 1
+	case SeverityError:
+		return 2
+	case SeverityCritical:
+		return 3
+	default:
+		return -1
+	}
+}
+
+// Matches reports whether an event should be delivered to the webhook. If
+// Codes is set, the event code must match one of them, either exactly or by
+// prefix if the code ends with "*" (e.g. "D*" matches all daemon events). If
+// MinSeverity is set, the event must be at least as severe.
+func (wh *WebhookConfig) Matches(event *WebhookEvent) bool {
+	if wh.MinSeverity != "" && SeverityLevel(event.Severity) < SeverityLevel(wh.MinSeverity) {
+		return false
+	}
+	if len(wh.Codes) == 0 {
+		return true
+	}
+	for _, code := range wh.Codes {
+		if code == event.Code || (strings.HasSuffix(code, "*") && strings.HasPrefix(event.Code, strings.TrimSuffix(code, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// SignWebhook returns the value of the X-Flynn-Signature header for a
+// delivery of payload to a webhook with the given secret, which is
+// "sha256=" followed by the hex encoded HMAC-SHA256 of the payload.
+func SignWebhook(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the signature of a webhook delivery and returns the
+// decoded event. As the signed payload includes the event timestamp, events
+// older than tolerance (or DefaultWebhookTolerance if zero) are rejected to
+// prevent captured deliveries from being replayed.
+func VerifyWebhook(secret string, payload []byte, signature string, tolerance time.Duration) (*WebhookEvent, error) {
+	if !strings.HasPrefix(signature, "sha256=") || !hmac.Equal([]byte(signature), []byte(SignWebhook(secret, payload))) {
+		return nil, ErrWebhookSignatureInvalid
+	}
+	var event WebhookEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+	if tolerance == 0 {
+		tolerance = DefaultWebhookTolerance
+	}
+	if age := time.Since(event.Timestamp); age > tolerance || age < -tolerance {
+		return nil, ErrWebhookEventExpired
+	}
+	return &event, nil
+}
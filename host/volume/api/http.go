@@ -43,6 +43,7 @@ func (api *HTTPAPI) RegisterRoutes(r *httprouter.Router) {
 	r.GET("/storage/volumes/:volume_id", api.Inspect)
 	r.DELETE("/storage/volumes/:volume_id", api.Destroy)
 	r.PUT("/storage/volumes/:volume_id/snapshot", api.Snapshot)
+	r.POST("/storage/volumes/:volume_id/fork", api.Fork)
 	// takes host and volID parameters, triggers a send on the remote host and give it a list of snaps already here, and pipes it into recv
 	r.POST("/storage/volumes/:volume_id/pull_snapshot", api.Pull)
 	// responds with a snapshot stream binary.  only works on snapshots, takes 'haves' parameters, usually called by a node that's servicing a 'pull_snapshot' request
@@ -133,7 +134,14 @@ func (api *HTTPAPI) Inspect(w http.ResponseWriter, r *http.Request, ps httproute
 		return
 	}
 
-	httphelper.JSON(w, 200, vol.Info())
+	info := *vol.Info()
+	size, err := api.vman.Usage(volumeID)
+	if err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	info.Size = size
+	httphelper.JSON(w, 200, &info)
 }
 
 func (api *HTTPAPI) Destroy(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -170,6 +178,32 @@ func (api *HTTPAPI) Snapshot(w http.ResponseWriter, r *http.Request, ps httprout
 	httphelper.JSON(w, 200, snap.Info())
 }
 
+// Fork creates a new volume from a snapshot, with the metadata given in the
+// request body.
+func (api *HTTPAPI) Fork(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	volumeID := ps.ByName("volume_id")
+
+	var req volume.ForkRequest
+	if err := httphelper.DecodeJSON(r, &req); err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+
+	vol, err := api.vman.ForkVolume(volumeID, req.Meta)
+	if err != nil {
+		switch err {
+		case volume.ErrNoSuchVolume:
+			httphelper.ObjectNotFoundError(w, fmt.Sprintf("no volume with id %q", volumeID))
+			return
+		default:
+			httphelper.Error(w, err)
+			return
+		}
+	}
+
+	httphelper.JSON(w, 200, vol.Info())
+}
+
 func (api *HTTPAPI) Pull(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	cluster := api.cluster.Load().(*cluster.Client)
 	if cluster == nil {
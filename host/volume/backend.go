@@ -31,6 +31,18 @@ type Provider interface {
 	RestoreVolumeState(volumeInfo *Info, data json.RawMessage) (Volume, error)
 }
 
+// UsageReporter is implemented by providers which can report the disk space
+// used by their volumes.
+type UsageReporter interface {
+	Usage(Volume) (int64, error)
+}
+
+// FreeSpaceReporter is implemented by providers which can report the disk
+// space available for new volumes.
+type FreeSpaceReporter interface {
+	FreeSpace() (int64, error)
+}
+
 type ProviderSpec struct {
 	// ID used by the API to specify this provider
 	ID string `json:"id"`
@@ -0,0 +1,410 @@
+// Package dir implements a volume provider which stores volumes as plain
+// directories, for hosts without ZFS such as laptops and CI machines.
+//
+// Snapshots and forks are full copies, so they are only suitable for small
+// volumes.
+package dir
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/flynn/flynn/host/volume"
+	"github.com/flynn/flynn/pkg/random"
+)
+
+type dirVolume struct {
+	info       *volume.Info
+	provider   *Provider
+	path       string
+	snapshot   bool
+	filesystem *volume.Filesystem
+}
+
+type Provider struct {
+	config  *ProviderConfig
+	volumes map[string]*dirVolume
+}
+
+// ProviderConfig describes the dir provider config used at provider setup
+// time. `volume.ProviderSpec.Config` is deserialized to this for dir, and it
+// is the output of `MarshalGlobalState`.
+type ProviderConfig struct {
+	// WorkingDir specifies the directory volumes are created in.
+	WorkingDir string `json:"working_dir"`
+}
+
+func NewProvider(config *ProviderConfig) (volume.Provider, error) {
+	if config.WorkingDir == "" {
+		config.WorkingDir = "/var/lib/flynn/volumes/dir/"
+	}
+	for _, dir := range []string{"data", "images"} {
+		if err := os.MkdirAll(filepath.Join(config.WorkingDir, dir), 0755); err != nil {
+			return nil, err
+		}
+	}
+	for _, typ := range volume.VolumeTypes {
+		if err := os.MkdirAll(filepath.Join(config.WorkingDir, "mnt", string(typ)), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &Provider{
+		config:  config,
+		volumes: make(map[string]*dirVolume),
+	}, nil
+}
+
+func (p *Provider) Kind() string {
+	return "dir"
+}
+
+func (p *Provider) NewVolume(info *volume.Info) (volume.Volume, error) {
+	if info == nil {
+		info = &volume.Info{}
+	}
+	if info.ID == "" {
+		info.ID = random.UUID()
+	}
+	info.Type = volume.VolumeTypeData
+	info.CreatedAt = time.Now()
+	v := &dirVolume{
+		info:     info,
+		provider: p,
+		path:     p.dataPath(info),
+	}
+	if err := os.Mkdir(v.path, 0755); err != nil {
+		return nil, err
+	}
+	p.volumes[info.ID] = v
+	return v, nil
+}
+
+// ImportFilesystem writes the filesystem image to a file and loop mounts it.
+func (p *Provider) ImportFilesystem(fs *volume.Filesystem) (volume.Volume, error) {
+	if fs.ID == "" {
+		fs.ID = random.UUID()
+	}
+	info := fs.Info()
+	info.CreatedAt = time.Now()
+	v := &dirVolume{
+		info:       info,
+		provider:   p,
+		path:       p.mountPath(info),
+		filesystem: fs,
+	}
+
+	f, err := os.OpenFile(p.imagePath(info), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(f, fs.Data)
+	f.Close()
+	if err == nil && n != fs.Size {
+		err = io.ErrShortWrite
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	if err := os.MkdirAll(v.path, 0755); err != nil {
+		p.destroy(v)
+		return nil, err
+	}
+	if err := p.mountImage(v); err != nil {
+		p.destroy(v)
+		return nil, err
+	}
+
+	p.volumes[fs.ID] = v
+	return v, nil
+}
+
+func (p *Provider) owns(vol volume.Volume) (*dirVolume, error) {
+	dvol := p.volumes[vol.Info().ID]
+	if dvol == nil {
+		return nil, fmt.Errorf("volume does not belong to this provider")
+	}
+	if dvol != vol { // these pointers should be canonical
+		panic(fmt.Errorf("volume does not belong to this provider"))
+	}
+	return dvol, nil
+}
+
+func (p *Provider) dataPath(info *volume.Info) string {
+	return filepath.Join(p.config.WorkingDir, "data", info.ID)
+}
+
+func (p *Provider) mountPath(info *volume.Info) string {
+	return filepath.Join(p.config.WorkingDir, "mnt", string(info.Type), info.ID)
+}
+
+func (p *Provider) imagePath(info *volume.Info) string {
+	return filepath.Join(p.config.WorkingDir, "images", info.ID)
+}
+
+func (p *Provider) DestroyVolume(v volume.Volume) error {
+	vol, err := p.owns(v)
+	if err != nil {
+		return err
+	}
+	return p.destroy(vol)
+}
+
+func (p *Provider) destroy(vol *dirVolume) error {
+	if vol.filesystem != nil {
+		if mounted, _ := isMount(vol.path); mounted {
+			if err := syscall.Unmount(vol.path, 0); err != nil {
+				return err
+			}
+		}
+		os.Remove(vol.path)
+		if err := os.Remove(p.imagePath(vol.info)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else if err := os.RemoveAll(vol.path); err != nil {
+		return err
+	}
+	delete(p.volumes, vol.info.ID)
+	return nil
+}
+
+func (p *Provider) CreateSnapshot(vol volume.Volume) (volume.Volume, error) {
+	dvol, err := p.owns(vol)
+	if err != nil {
+		return nil, err
+	}
+	return p.copyVolume(dvol, true)
+}
+
+func (p *Provider) ForkVolume(vol volume.Volume) (volume.Volume, error) {
+	dvol, err := p.owns(vol)
+	if err != nil {
+		return nil, err
+	}
+	if !vol.IsSnapshot() {
+		return nil, fmt.Errorf("can only fork a snapshot")
+	}
+	return p.copyVolume(dvol, false)
+}
+
+// copyVolume creates a new volume containing a copy of the data in vol.
+func (p *Provider) copyVolume(vol *dirVolume, snapshot bool) (*dirVolume, error) {
+	if vol.filesystem != nil {
+		return nil, errors.New("cannot copy a filesystem image volume")
+	}
+	info := &volume.Info{ID: random.UUID(), Type: vol.info.Type, CreatedAt: time.Now()}
+	v := &dirVolume{
+		info:     info,
+		provider: p,
+		path:     p.dataPath(info),
+		snapshot: snapshot,
+	}
+	if err := os.Mkdir(v.path, 0755); err != nil {
+		return nil, err
+	}
+	if err := run(exec.Command("cp", "-a", vol.path+"/.", v.path)); err != nil {
+		os.RemoveAll(v.path)
+		return nil, fmt.Errorf("could not copy volume: %s", err)
+	}
+	p.volumes[info.ID] = v
+	return v, nil
+}
+
+// Usage returns the total size of the files in the volume.
+func (p *Provider) Usage(vol volume.Volume) (int64, error) {
+	dvol, err := p.owns(vol)
+	if err != nil {
+		return 0, err
+	}
+	if dvol.filesystem != nil {
+		info, err := os.Stat(p.imagePath(dvol.info))
+		if err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
+	}
+	var size int64
+	err = filepath.Walk(dvol.path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// ListHaves returns no haves as snapshots are always sent in full.
+func (p *Provider) ListHaves(vol volume.Volume) ([]json.RawMessage, error) {
+	if _, err := p.owns(vol); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// SendSnapshot writes the contents of the snapshot to output as a tar archive.
+func (p *Provider) SendSnapshot(vol volume.Volume, haves []json.RawMessage, output io.Writer) error {
+	dvol, err := p.owns(vol)
+	if err != nil {
+		return err
+	}
+	if !vol.IsSnapshot() {
+		return fmt.Errorf("can only send a snapshot")
+	}
+	cmd := exec.Command("tar", "-C", dvol.path, "-c", ".")
+	cmd.Stdout = output
+	return run(cmd)
+}
+
+// ReceiveSnapshot replaces the contents of vol with the tar archive read from
+// input and returns a snapshot of the result.
+func (p *Provider) ReceiveSnapshot(vol volume.Volume, input io.Reader) (volume.Volume, error) {
+	dvol, err := p.owns(vol)
+	if err != nil {
+		return nil, err
+	}
+	if dvol.filesystem != nil || dvol.snapshot {
+		return nil, fmt.Errorf("can only receive into a data volume")
+	}
+	entries, err := ioutil.ReadDir(dvol.path)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dvol.path, entry.Name())); err != nil {
+			return nil, err
+		}
+	}
+	cmd := exec.Command("tar", "-C", dvol.path, "-x")
+	cmd.Stdin = input
+	if err := run(cmd); err != nil {
+		return nil, fmt.Errorf("tar rejected snapshot data: %s", err)
+	}
+	return p.copyVolume(dvol, true)
+}
+
+func (v *dirVolume) Provider() volume.Provider {
+	return v.provider
+}
+
+func (v *dirVolume) Location() string {
+	return v.path
+}
+
+func (p *Provider) MarshalGlobalState() (json.RawMessage, error) {
+	return json.Marshal(p.config)
+}
+
+type dirVolumeRecord struct {
+	Path       string             `json:"path"`
+	Snapshot   bool               `json:"snapshot,omitempty"`
+	Filesystem *volume.Filesystem `json:"filesystem,omitempty"`
+}
+
+func (p *Provider) MarshalVolumeState(volumeID string) (json.RawMessage, error) {
+	vol := p.volumes[volumeID]
+	return json.Marshal(dirVolumeRecord{
+		Path:       vol.path,
+		Snapshot:   vol.snapshot,
+		Filesystem: vol.filesystem,
+	})
+}
+
+func (p *Provider) RestoreVolumeState(volInfo *volume.Info, data json.RawMessage) (volume.Volume, error) {
+	record := &dirVolumeRecord{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, fmt.Errorf("cannot restore volume %q: %s", volInfo.ID, err)
+	}
+	v := &dirVolume{
+		info:       volInfo,
+		provider:   p,
+		path:       record.Path,
+		snapshot:   record.Snapshot,
+		filesystem: record.Filesystem,
+	}
+	existsPath := v.path
+	if v.filesystem != nil {
+		existsPath = p.imagePath(volInfo)
+	}
+	if _, err := os.Stat(existsPath); os.IsNotExist(err) {
+		return nil, volume.ErrNoSuchVolume
+	}
+	if v.filesystem != nil {
+		if err := os.MkdirAll(v.path, 0755); err != nil {
+			return nil, err
+		}
+		if err := p.mountImage(v); err != nil {
+			return nil, err
+		}
+	}
+	p.volumes[volInfo.ID] = v
+	return v, nil
+}
+
+func (v *dirVolume) Info() *volume.Info {
+	return v.info
+}
+
+func (v *dirVolume) IsSnapshot() bool {
+	return v.snapshot
+}
+
+// mountImage loop mounts the image file of a filesystem volume if it is not
+// already mounted.
+func (p *Provider) mountImage(vol *dirVolume) error {
+	mounted, err := isMount(vol.path)
+	if err != nil {
+		return fmt.Errorf("could not mount: %s", err)
+	} else if mounted {
+		return nil
+	}
+	opts := "loop"
+	if vol.filesystem.MountFlags&syscall.MS_RDONLY != 0 {
+		opts += ",ro"
+	}
+	cmd := exec.Command("mount", "-t", string(vol.filesystem.Type), "-o", opts, p.imagePath(vol.info), vol.path)
+	if err := run(cmd); err != nil {
+		return fmt.Errorf("could not mount: %s", err)
+	}
+	return nil
+}
+
+func isMount(path string) (bool, error) {
+	pathStat, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	parentStat, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		return false, err
+	}
+	pathDev := pathStat.Sys().(*syscall.Stat_t).Dev
+	parentDev := parentStat.Sys().(*syscall.Stat_t).Dev
+	return pathDev != parentDev, nil
+}
+
+// run runs cmd, including its stderr in any returned error.
+func run(cmd *exec.Cmd) error {
+	var buf bytes.Buffer
+	cmd.Stderr = &buf
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s (%s)", err, strings.TrimSpace(buf.String()))
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package dir
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flynn/flynn/host/volume"
+	. "github.com/flynn/go-check"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type DirSuite struct {
+	provider volume.Provider
+}
+
+var _ = Suite(&DirSuite{})
+
+func (s *DirSuite) SetUpTest(c *C) {
+	var err error
+	s.provider, err = NewProvider(&ProviderConfig{WorkingDir: c.MkDir()})
+	c.Assert(err, IsNil)
+}
+
+func writeFile(c *C, vol volume.Volume, name, data string) {
+	c.Assert(ioutil.WriteFile(filepath.Join(vol.Location(), name), []byte(data), 0644), IsNil)
+}
+
+func assertFile(c *C, vol volume.Volume, name, data string) {
+	actual, err := ioutil.ReadFile(filepath.Join(vol.Location(), name))
+	c.Assert(err, IsNil)
+	c.Assert(string(actual), Equals, data)
+}
+
+func (s *DirSuite) TestSnapshotAndFork(c *C) {
+	vol, err := s.provider.NewVolume(nil)
+	c.Assert(err, IsNil)
+	c.Assert(vol.IsSnapshot(), Equals, false)
+	writeFile(c, vol, "foo", "bar")
+
+	snap, err := s.provider.CreateSnapshot(vol)
+	c.Assert(err, IsNil)
+	c.Assert(snap.IsSnapshot(), Equals, true)
+	assertFile(c, snap, "foo", "bar")
+
+	// changes to the volume don't affect the snapshot
+	writeFile(c, vol, "foo", "baz")
+	assertFile(c, snap, "foo", "bar")
+
+	_, err = s.provider.ForkVolume(vol)
+	c.Assert(err, NotNil)
+	fork, err := s.provider.ForkVolume(snap)
+	c.Assert(err, IsNil)
+	c.Assert(fork.IsSnapshot(), Equals, false)
+	assertFile(c, fork, "foo", "bar")
+
+	size, err := s.provider.(volume.UsageReporter).Usage(fork)
+	c.Assert(err, IsNil)
+	c.Assert(size, Equals, int64(3))
+
+	c.Assert(s.provider.DestroyVolume(vol), IsNil)
+	_, err = os.Stat(vol.Location())
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *DirSuite) TestSendReceiveSnapshot(c *C) {
+	vol, err := s.provider.NewVolume(nil)
+	c.Assert(err, IsNil)
+	writeFile(c, vol, "foo", "bar")
+	snap, err := s.provider.CreateSnapshot(vol)
+	c.Assert(err, IsNil)
+
+	var buf bytes.Buffer
+	c.Assert(s.provider.SendSnapshot(snap, nil, &buf), IsNil)
+
+	dst, err := s.provider.NewVolume(nil)
+	c.Assert(err, IsNil)
+	writeFile(c, dst, "stale", "data")
+	received, err := s.provider.ReceiveSnapshot(dst, &buf)
+	c.Assert(err, IsNil)
+	c.Assert(received.IsSnapshot(), Equals, true)
+	assertFile(c, received, "foo", "bar")
+	assertFile(c, dst, "foo", "bar")
+	_, err = os.Stat(filepath.Join(dst.Location(), "stale"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *DirSuite) TestPersistence(c *C) {
+	vol, err := s.provider.NewVolume(nil)
+	c.Assert(err, IsNil)
+	snap, err := s.provider.CreateSnapshot(vol)
+	c.Assert(err, IsNil)
+
+	global, err := s.provider.MarshalGlobalState()
+	c.Assert(err, IsNil)
+	data, err := s.provider.MarshalVolumeState(snap.Info().ID)
+	c.Assert(err, IsNil)
+
+	config := &ProviderConfig{}
+	c.Assert(json.Unmarshal(global, config), IsNil)
+	provider, err := NewProvider(config)
+	c.Assert(err, IsNil)
+	restored, err := provider.RestoreVolumeState(snap.Info(), data)
+	c.Assert(err, IsNil)
+	c.Assert(restored.IsSnapshot(), Equals, true)
+	c.Assert(restored.Location(), Equals, snap.Location())
+
+	// volumes whose data is gone are reported as not existing
+	c.Assert(os.RemoveAll(snap.Location()), IsNil)
+	_, err = provider.RestoreVolumeState(snap.Info(), data)
+	c.Assert(err, Equals, volume.ErrNoSuchVolume)
+}
@@ -4,20 +4,19 @@ import (
 	"encoding/json"
 
 	"github.com/flynn/flynn/host/volume"
-	"github.com/flynn/flynn/host/volume/zfs"
+	"github.com/flynn/flynn/host/volume/dir"
 )
 
 func NewProvider(pspec *volume.ProviderSpec) (provider volume.Provider, err error) {
 	switch pspec.Kind {
 	case "zfs":
-		config := &zfs.ProviderConfig{}
+		return newZFSProvider(pspec.Config)
+	case "dir":
+		config := &dir.ProviderConfig{}
 		if err := json.Unmarshal(pspec.Config, config); err != nil {
 			return nil, err
 		}
-		if provider, err = zfs.NewProvider(config); err != nil {
-			return
-		}
-		return
+		return dir.NewProvider(config)
 	default:
 		return nil, volume.UnknownProviderKind
 	}
@@ -0,0 +1,16 @@
+//go:build lite
+// +build lite
+
+package volumemanager
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/flynn/flynn/host/volume"
+)
+
+// lite builds leave out ZFS support, see host/lite.go
+func newZFSProvider(json.RawMessage) (volume.Provider, error) {
+	return nil, errors.New("volume: ZFS support is not included in lite builds")
+}
@@ -0,0 +1,19 @@
+//go:build !lite
+// +build !lite
+
+package volumemanager
+
+import (
+	"encoding/json"
+
+	"github.com/flynn/flynn/host/volume"
+	"github.com/flynn/flynn/host/volume/zfs"
+)
+
+func newZFSProvider(data json.RawMessage) (volume.Provider, error) {
+	config := &zfs.ProviderConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	return zfs.NewProvider(config)
+}
@@ -298,13 +298,20 @@ func (m *Manager) CreateSnapshot(id string) (volume.Volume, error) {
 	if err != nil {
 		return nil, err
 	}
-	m.volumes[snap.Info().ID] = snap
+	info := snap.Info()
+	if info.Meta == nil {
+		info.Meta = make(map[string]string, 1)
+	}
+	info.Meta[volume.SnapshotOfMetaKey] = id
+	m.volumes[info.ID] = snap
 	m.persist(func(tx *bolt.Tx) error { return m.persistVolume(tx, snap) })
 	m.sendEvent(snap, volume.EventTypeCreate)
 	return snap, nil
 }
 
-func (m *Manager) ForkVolume(id string) (volume.Volume, error) {
+// ForkVolume creates a new volume from the snapshot with the given ID, with
+// the given metadata.
+func (m *Manager) ForkVolume(id string, meta map[string]string) (volume.Volume, error) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	vol := m.volumes[id]
@@ -319,12 +326,47 @@ func (m *Manager) ForkVolume(id string) (volume.Volume, error) {
 	if err != nil {
 		return nil, err
 	}
+	vol2.Info().Meta = meta
 	m.volumes[vol2.Info().ID] = vol2
 	m.persist(func(tx *bolt.Tx) error { return m.persistVolume(tx, vol2) })
 	m.sendEvent(vol2, volume.EventTypeCreate)
 	return vol2, nil
 }
 
+// Usage returns the disk space used by the volume with the given ID, or
+// zero if its provider doesn't report usage.
+func (m *Manager) Usage(id string) (int64, error) {
+	m.mutex.Lock()
+	vol := m.volumes[id]
+	m.mutex.Unlock()
+	if vol == nil {
+		return 0, volume.ErrNoSuchVolume
+	}
+	r, ok := vol.Provider().(volume.UsageReporter)
+	if !ok {
+		return 0, nil
+	}
+	return r.Usage(vol)
+}
+
+// FreeSpace returns the disk space available for new volumes from the
+// provider with the given ID, with ok false if the provider doesn't report
+// it.
+func (m *Manager) FreeSpace(providerID string) (free int64, ok bool, err error) {
+	m.mutex.Lock()
+	p, exists := m.providers[providerID]
+	m.mutex.Unlock()
+	if !exists {
+		return 0, false, ErrNoSuchProvider
+	}
+	r, ok := p.(volume.FreeSpaceReporter)
+	if !ok {
+		return 0, false, nil
+	}
+	free, err = r.FreeSpace()
+	return free, err == nil, err
+}
+
 func (m *Manager) ListHaves(id string) ([]json.RawMessage, error) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
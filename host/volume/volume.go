@@ -43,8 +43,21 @@ type Info struct {
 	Type      VolumeType        `json:"type"`
 	Meta      map[string]string `json:"meta,omitempty"`
 	CreatedAt time.Time         `json:"created_at"`
+
+	// Size is the disk space used by the volume in bytes, which is only
+	// set when inspecting a single volume whose provider reports usage
+	Size int64 `json:"size,omitempty"`
+}
+
+// ForkRequest is a request to create a new volume from a snapshot.
+type ForkRequest struct {
+	Meta map[string]string `json:"meta,omitempty"`
 }
 
+// SnapshotOfMetaKey is the metadata key set on snapshots to the ID of the
+// volume they were taken from
+const SnapshotOfMetaKey = "flynn-volume.snapshot_of"
+
 type VolumeType string
 
 const (
@@ -375,7 +375,7 @@ func (p *Provider) CreateSnapshot(vol volume.Volume) (volume.Volume, error) {
 		return nil, err
 	}
 	id := random.UUID()
-	info := &volume.Info{ID: id, Type: vol.Info().Type}
+	info := &volume.Info{ID: id, Type: vol.Info().Type, CreatedAt: time.Now()}
 	snap := &zfsVolume{
 		info:      info,
 		provider:  zvol.provider,
@@ -462,7 +462,7 @@ func (p *Provider) ForkVolume(vol volume.Volume) (volume.Volume, error) {
 		return nil, fmt.Errorf("can only fork a snapshot")
 	}
 	id := random.UUID()
-	info := &volume.Info{ID: id, Type: vol.Info().Type}
+	info := &volume.Info{ID: id, Type: vol.Info().Type, CreatedAt: time.Now()}
 	v2 := &zfsVolume{
 		info:      info,
 		provider:  zvol.provider,
@@ -479,6 +479,30 @@ func (p *Provider) ForkVolume(vol volume.Volume) (volume.Volume, error) {
 	return v2, nil
 }
 
+// Usage returns the disk space used by the volume, which for a snapshot is
+// the space which would be freed by destroying it.
+func (p *Provider) Usage(vol volume.Volume) (int64, error) {
+	zvol, err := p.owns(vol)
+	if err != nil {
+		return 0, err
+	}
+	ds, err := zfs.GetDataset(zvol.dataset.Name)
+	if err != nil {
+		return 0, err
+	}
+	return int64(ds.Used), nil
+}
+
+// FreeSpace returns the disk space available to the provider's dataset for
+// new volumes.
+func (p *Provider) FreeSpace() (int64, error) {
+	ds, err := zfs.GetDataset(p.dataset.Name)
+	if err != nil {
+		return 0, err
+	}
+	return int64(ds.Avail), nil
+}
+
 type zfsHaves struct {
 	SnapID string `json:"snap_id"`
 }
@@ -3,7 +3,9 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/flynn/flynn/host/types"
@@ -12,18 +14,25 @@ import (
 )
 
 const (
-	webhookBufferSize  = 256
-	webhookTimeout     = 5 * time.Second
-	webhookMaxRetries  = 2
-	webhookRetryDelay  = 1 * time.Second
+	webhookBufferSize         = 256
+	webhookTimeout            = 5 * time.Second
+	webhookDefaultMaxAttempts = 5
+	webhookRetryDelay         = 1 * time.Second
+	webhookMaxRetryDelay      = 10 * time.Minute
+	webhookQueueInterval      = 1 * time.Second
+	webhookMaxDeadLetters     = 100
 )
 
 // WebhookDispatcher dispatches webhook events to configured endpoints.
 // It runs in its own goroutine and uses a buffered channel to avoid blocking event producers.
+// Deliveries are persisted in the state DB and retried with exponential
+// backoff, with deliveries which exhaust their attempts kept in a
+// dead-letter log so they can be inspected and retried.
 type WebhookDispatcher struct {
 	hostID string
 	state  *State
 	events chan *host.WebhookEvent
+	kick   chan struct{}
 	done   chan struct{}
 	log    log15.Logger
 	client *http.Client
@@ -35,6 +44,7 @@ func NewWebhookDispatcher(hostID string, state *State, log log15.Logger) *Webhoo
 		hostID: hostID,
 		state:  state,
 		events: make(chan *host.WebhookEvent, webhookBufferSize),
+		kick:   make(chan struct{}, 1),
 		done:   make(chan struct{}),
 		log:    log.New("component", "webhook-dispatcher"),
 		client: &http.Client{Timeout: webhookTimeout},
@@ -44,6 +54,7 @@ func NewWebhookDispatcher(hostID string, state *State, log log15.Logger) *Webhoo
 // Run starts the dispatcher loop. Should be called in a goroutine.
 func (d *WebhookDispatcher) Run() {
 	d.log.Info("webhook dispatcher started")
+	go d.runQueue()
 	for {
 		select {
 		case event, ok := <-d.events:
@@ -59,13 +70,29 @@ func (d *WebhookDispatcher) Run() {
 	}
 }
 
+// runQueue attempts queued deliveries as they become due, including any
+// which were queued before the daemon restarted.
+func (d *WebhookDispatcher) runQueue() {
+	ticker := time.NewTicker(webhookQueueInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-d.kick:
+		case <-d.done:
+			return
+		}
+		d.processQueue(time.Now())
+	}
+}
+
 // Shutdown stops the dispatcher.
 func (d *WebhookDispatcher) Shutdown() {
 	close(d.done)
 }
 
 // Send enqueues a webhook event for delivery. It is non-blocking; if the
-// buffer is full the event is dropped. The full ActiveJob is reduced to a
+// buffer is full the event is queued from a new goroutine instead. The full ActiveJob is reduced to a
 // WebhookJob and the flynn-* env vars are surfaced as top-level fields so
 // the outbound payload never carries container env, mounts, volumes or argv.
 func (d *WebhookDispatcher) Send(code, description, severity string, jobID string, job *host.ActiveJob, metadata map[string]string) {
@@ -94,7 +121,8 @@ func (d *WebhookDispatcher) Send(code, description, severity string, jobID strin
 	select {
 	case d.events <- event:
 	default:
-		d.log.Warn("webhook event buffer full, dropping event", "code", code, "event_id", event.EventID)
+		d.log.Warn("webhook event buffer full, queueing event in the background", "code", code, "event_id", event.EventID)
+		go d.dispatch(event)
 	}
 }
 
@@ -124,10 +152,14 @@ func sanitizeJobForWebhook(j *host.ActiveJob) *host.WebhookJob {
 		v := *j.Error
 		wj.Error = &v
 	}
+	if j.LogTail != nil {
+		wj.LogTail = append([]string(nil), j.LogTail...)
+	}
 	return wj
 }
 
-// dispatch sends an event to all configured webhooks.
+// dispatch queues a delivery of an event to all configured webhooks whose
+// filters match it.
 func (d *WebhookDispatcher) dispatch(event *host.WebhookEvent) {
 	webhooks := d.state.ListWebhooks()
 	if len(webhooks) == 0 {
@@ -141,48 +173,200 @@ func (d *WebhookDispatcher) dispatch(event *host.WebhookEvent) {
 	}
 
 	for _, wh := range webhooks {
-		go d.deliver(wh, payload, event.EventID)
-	}
-}
-
-// deliver sends the payload to a single webhook endpoint with retry logic.
-// Any headers configured on the webhook are applied to each request; the
-// Content-Type header is always set to application/json.
-func (d *WebhookDispatcher) deliver(wh *host.WebhookConfig, payload []byte, eventID string) {
-	var lastErr error
-	for attempt := 0; attempt <= webhookMaxRetries; attempt++ {
-		if attempt > 0 {
-			time.Sleep(webhookRetryDelay)
+		if !wh.Matches(event) {
+			continue
 		}
-		req, err := http.NewRequest("POST", wh.URL, bytes.NewReader(payload))
-		if err != nil {
-			d.log.Error("webhook request build failed", "url", wh.URL, "event_id", eventID, "err", err)
-			return
+		now := time.Now().UTC()
+		delivery := &host.WebhookDelivery{
+			ID:            random.UUID(),
+			WebhookID:     wh.ID,
+			EventID:       event.EventID,
+			Code:          event.Code,
+			State:         host.WebhookDeliveryStatePending,
+			Payload:       payload,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
 		}
-		req.Header.Set("Content-Type", "application/json")
-		for k, v := range wh.Headers {
-			req.Header.Set(k, v)
+		if err := d.state.PutWebhookDelivery(delivery); err != nil {
+			d.log.Error("error queueing webhook delivery", "url", wh.URL, "event_id", event.EventID, "err", err)
 		}
-		resp, err := d.client.Do(req)
-		if err != nil {
-			lastErr = err
-			d.log.Warn("webhook delivery failed", "url", wh.URL, "event_id", eventID, "attempt", attempt+1, "err", err)
+	}
+	d.wake()
+}
+
+// wake triggers processing of the delivery queue without waiting for the
+// next tick.
+func (d *WebhookDispatcher) wake() {
+	select {
+	case d.kick <- struct{}{}:
+	default:
+	}
+}
+
+// Test synchronously delivers a synthetic event to a single webhook without
+// retrying, so operators can check an endpoint is reachable and accepts
+// deliveries.
+func (d *WebhookDispatcher) Test(wh *host.WebhookConfig) *host.WebhookTestResult {
+	event := &host.WebhookEvent{
+		EventID:     random.UUID(),
+		Timestamp:   time.Now().UTC(),
+		HostID:      d.hostID,
+		Code:        host.CodeWebhookTest,
+		Description: "Webhook test delivery",
+		Severity:    host.SeverityInfo,
+		Metadata:    map[string]string{"webhook_id": wh.ID},
+	}
+	res := &host.WebhookTestResult{WebhookID: wh.ID, EventID: event.EventID}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	status, err := d.post(wh, payload)
+	res.StatusCode = status
+	if err != nil {
+		res.Error = err.Error()
+	} else if status < 200 || status >= 300 {
+		res.Error = fmt.Sprintf("unexpected status %d", status)
+	}
+	d.log.Info("webhook test delivered", "url", wh.URL, "event_id", event.EventID, "status", status, "err", res.Error)
+	return res
+}
+
+// post sends the payload to a webhook endpoint, returning the response
+// status code. Any headers configured on the webhook are applied to the
+// request; the Content-Type header is always set to application/json, and
+// the payload is signed if the webhook has a secret.
+func (d *WebhookDispatcher) post(wh *host.WebhookConfig, payload []byte) (int, error) {
+	req, err := http.NewRequest("POST", wh.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range wh.Headers {
+		req.Header.Set(k, v)
+	}
+	if wh.Secret != "" {
+		req.Header.Set(host.WebhookSignatureHeader, host.SignWebhook(wh.Secret, payload))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// processQueue concurrently attempts all pending deliveries which are due at
+// the given time, waiting for the attempts to finish. Deliveries to webhooks
+// which have since been removed are discarded.
+func (d *WebhookDispatcher) processQueue(now time.Time) {
+	webhooks := make(map[string]*host.WebhookConfig)
+	for _, wh := range d.state.ListWebhooks() {
+		webhooks[wh.ID] = wh
+	}
+	var wg sync.WaitGroup
+	for _, delivery := range d.state.ListWebhookDeliveries("") {
+		wh, ok := webhooks[delivery.WebhookID]
+		if !ok {
+			d.state.RemoveWebhookDelivery(delivery.ID)
 			continue
 		}
-		resp.Body.Close()
-		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-			return // success
+		if delivery.State != host.WebhookDeliveryStatePending || delivery.NextAttemptAt.After(now) {
+			continue
 		}
-		d.log.Warn("webhook delivery non-2xx response", "url", wh.URL, "event_id", eventID, "attempt", attempt+1, "status", resp.StatusCode)
-		if resp.StatusCode >= 400 && resp.StatusCode < 500 {
-			return // client error, don't retry
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.attempt(wh, delivery)
+		}()
+	}
+	wg.Wait()
+}
+
+// attempt makes a single attempt to send a queued delivery. Successful
+// deliveries are removed from the queue, and failed ones are either
+// rescheduled with exponential backoff or, if they were rejected with a
+// client error or have exhausted their attempts, moved to the dead-letter
+// log.
+func (d *WebhookDispatcher) attempt(wh *host.WebhookConfig, delivery *host.WebhookDelivery) {
+	status, err := d.post(wh, delivery.Payload)
+	delivery.Attempts++
+	delivery.StatusCode = status
+	delivery.UpdatedAt = time.Now().UTC()
+	log := d.log.New("url", wh.URL, "event_id", delivery.EventID, "delivery_id", delivery.ID, "attempt", delivery.Attempts)
+	if err == nil && status >= 200 && status < 300 {
+		if err := d.state.RemoveWebhookDelivery(delivery.ID); err != nil {
+			log.Error("error removing webhook delivery", "err", err)
 		}
-		lastErr = nil // server error, will retry
+		return
 	}
-	if lastErr != nil {
-		d.log.Error("webhook delivery exhausted retries", "url", wh.URL, "event_id", eventID, "err", lastErr)
+	if err != nil {
+		delivery.Error = err.Error()
 	} else {
-		d.log.Error("webhook delivery exhausted retries", "url", wh.URL, "event_id", eventID)
+		delivery.Error = fmt.Sprintf("unexpected status %d", status)
+	}
+
+	maxAttempts := wh.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = webhookDefaultMaxAttempts
+	}
+	if status >= 400 && status < 500 || delivery.Attempts >= maxAttempts {
+		log.Error("webhook delivery failed, moving to dead-letter log", "err", delivery.Error)
+		delivery.State = host.WebhookDeliveryStateFailed
+	} else {
+		delivery.NextAttemptAt = delivery.UpdatedAt.Add(webhookBackoff(delivery.Attempts))
+		log.Warn("webhook delivery failed, will retry", "err", delivery.Error, "next_attempt_at", delivery.NextAttemptAt)
+	}
+	if err := d.state.PutWebhookDelivery(delivery); err != nil {
+		log.Error("error updating webhook delivery", "err", err)
+		return
+	}
+	if delivery.State == host.WebhookDeliveryStateFailed {
+		d.pruneDeadLetters(wh.ID)
+	}
+}
+
+// webhookBackoff returns the delay before the next attempt of a delivery
+// which has failed the given number of times, doubling from
+// webhookRetryDelay up to webhookMaxRetryDelay.
+func webhookBackoff(attempts int) time.Duration {
+	delay := webhookRetryDelay
+	for i := 1; i < attempts && delay < webhookMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > webhookMaxRetryDelay {
+		delay = webhookMaxRetryDelay
+	}
+	return delay
+}
+
+// pruneDeadLetters removes the oldest failed deliveries to a webhook so at
+// most webhookMaxDeadLetters are kept.
+func (d *WebhookDispatcher) pruneDeadLetters(webhookID string) {
+	var failed []*host.WebhookDelivery
+	for _, delivery := range d.state.ListWebhookDeliveries(webhookID) {
+		if delivery.State == host.WebhookDeliveryStateFailed {
+			failed = append(failed, delivery)
+		}
+	}
+	for i := 0; i < len(failed)-webhookMaxDeadLetters; i++ {
+		d.state.RemoveWebhookDelivery(failed[i].ID)
 	}
 }
 
+// Retry re-queues a delivery, typically from the dead-letter log, to be
+// attempted immediately with a fresh set of attempts.
+func (d *WebhookDispatcher) Retry(delivery *host.WebhookDelivery) error {
+	now := time.Now().UTC()
+	delivery.State = host.WebhookDeliveryStatePending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = now
+	delivery.UpdatedAt = now
+	if err := d.state.PutWebhookDelivery(delivery); err != nil {
+		return err
+	}
+	d.wake()
+	return nil
+}
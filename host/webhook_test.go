@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	host "github.com/flynn/flynn/host/types"
+	. "github.com/flynn/go-check"
+	"github.com/inconshreveable/log15"
+	"github.com/julienschmidt/httprouter"
+)
+
+func (S) TestWebhookTestDelivery(c *C) {
+	state := NewState("abc123", filepath.Join(c.MkDir(), "host-state-db"))
+	c.Assert(state.OpenDB(), IsNil)
+	defer state.CloseDB()
+
+	events := make(chan *host.WebhookEvent, 1)
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.Header.Get("X-Secret"), Equals, "s3cret")
+		var event host.WebhookEvent
+		c.Assert(json.NewDecoder(r.Body).Decode(&event), IsNil)
+		events <- &event
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	d := NewWebhookDispatcher("abc123", state, logger)
+	wh := &host.WebhookConfig{ID: "wh1", URL: srv.URL, Headers: map[string]string{"X-Secret": "s3cret"}}
+
+	res := d.Test(wh)
+	c.Assert(res.Error, Equals, "")
+	c.Assert(res.StatusCode, Equals, http.StatusNoContent)
+	event := <-events
+	c.Assert(event.EventID, Equals, res.EventID)
+	c.Assert(event.Code, Equals, host.CodeWebhookTest)
+	c.Assert(event.HostID, Equals, "abc123")
+	c.Assert(event.Metadata["webhook_id"], Equals, "wh1")
+
+	// non-2xx responses are reported as failures
+	status = http.StatusForbidden
+	res = d.Test(wh)
+	<-events
+	c.Assert(res.StatusCode, Equals, http.StatusForbidden)
+	c.Assert(res.Error, Equals, "unexpected status 403")
+
+	// connection errors are reported without a status
+	srv.Close()
+	res = d.Test(wh)
+	c.Assert(res.StatusCode, Equals, 0)
+	c.Assert(res.Error, Not(Equals), "")
+}
+
+func (S) TestWebhookSignature(c *C) {
+	type delivery struct {
+		payload   []byte
+		signature string
+	}
+	deliveries := make(chan *delivery, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, _ := ioutil.ReadAll(r.Body)
+		deliveries <- &delivery{payload, r.Header.Get(host.WebhookSignatureHeader)}
+	}))
+	defer srv.Close()
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	d := NewWebhookDispatcher("abc123", nil, logger)
+
+	// deliveries to webhooks without a secret are not signed
+	c.Assert(d.Test(&host.WebhookConfig{ID: "unsigned", URL: srv.URL}).Error, Equals, "")
+	c.Assert((<-deliveries).signature, Equals, "")
+
+	res := d.Test(&host.WebhookConfig{ID: "signed", URL: srv.URL, Secret: "s3cret"})
+	c.Assert(res.Error, Equals, "")
+	del := <-deliveries
+	event, err := host.VerifyWebhook("s3cret", del.payload, del.signature, 0)
+	c.Assert(err, IsNil)
+	c.Assert(event.EventID, Equals, res.EventID)
+
+	// the wrong secret or a modified payload is rejected
+	_, err = host.VerifyWebhook("wrong", del.payload, del.signature, 0)
+	c.Assert(err, Equals, host.ErrWebhookSignatureInvalid)
+	_, err = host.VerifyWebhook("s3cret", append(del.payload, ' '), del.signature, 0)
+	c.Assert(err, Equals, host.ErrWebhookSignatureInvalid)
+
+	// replayed events are rejected
+	old, _ := json.Marshal(&host.WebhookEvent{EventID: "old", Timestamp: time.Now().Add(-10 * time.Minute)})
+	_, err = host.VerifyWebhook("s3cret", old, host.SignWebhook("s3cret", old), 0)
+	c.Assert(err, Equals, host.ErrWebhookEventExpired)
+	_, err = host.VerifyWebhook("s3cret", old, host.SignWebhook("s3cret", old), time.Hour)
+	c.Assert(err, IsNil)
+}
+
+func (S) TestWebhookFilter(c *C) {
+	for _, t := range []struct {
+		codes       []string
+		minSeverity string
+		code        string
+		severity    string
+		matches     bool
+	}{
+		{code: host.CodeJobStart, severity: host.SeverityInfo, matches: true},
+		{codes: []string{host.CodeMemoryHard}, code: host.CodeMemoryHard, severity: host.SeverityCritical, matches: true},
+		{codes: []string{host.CodeMemoryHard}, code: host.CodeJobStart, severity: host.SeverityInfo, matches: false},
+		{codes: []string{host.CodeMemoryHard, "D*"}, code: host.CodeDaemonStart, severity: host.SeverityInfo, matches: true},
+		{codes: []string{"D*"}, code: host.CodeJobCrash, severity: host.SeverityError, matches: false},
+		{minSeverity: host.SeverityError, code: host.CodeJobCrash, severity: host.SeverityError, matches: true},
+		{minSeverity: host.SeverityError, code: host.CodeMemoryHard, severity: host.SeverityCritical, matches: true},
+		{minSeverity: host.SeverityError, code: host.CodeMemorySoft, severity: host.SeverityWarning, matches: false},
+		{codes: []string{"H*"}, minSeverity: host.SeverityError, code: host.CodeJobStart, severity: host.SeverityInfo, matches: false},
+	} {
+		wh := &host.WebhookConfig{Codes: t.codes, MinSeverity: t.minSeverity}
+		event := &host.WebhookEvent{Code: t.code, Severity: t.severity}
+		c.Assert(wh.Matches(event), Equals, t.matches, Commentf("%v %q %s %s", t.codes, t.minSeverity, t.code, t.severity))
+	}
+
+	// the dispatcher only delivers events to webhooks which match them
+	state := NewState("abc123", filepath.Join(c.MkDir(), "host-state-db"))
+	c.Assert(state.OpenDB(), IsNil)
+	defer state.CloseDB()
+	deliveries := make(chan string, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deliveries <- r.URL.Path
+	}))
+	defer srv.Close()
+	c.Assert(state.AddWebhook(&host.WebhookConfig{ID: "all", URL: srv.URL + "/all"}), IsNil)
+	c.Assert(state.AddWebhook(&host.WebhookConfig{ID: "oom", URL: srv.URL + "/oom", Codes: []string{host.CodeMemoryHard}}), IsNil)
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	d := NewWebhookDispatcher("abc123", state, logger)
+	d.dispatch(&host.WebhookEvent{Code: host.CodeJobStart, Severity: host.SeverityInfo})
+	d.processQueue(time.Now())
+	c.Assert(<-deliveries, Equals, "/all")
+	d.dispatch(&host.WebhookEvent{Code: host.CodeMemoryHard, Severity: host.SeverityCritical})
+	d.processQueue(time.Now())
+	paths := []string{<-deliveries, <-deliveries}
+	sort.Strings(paths)
+	c.Assert(paths, DeepEquals, []string{"/all", "/oom"})
+	select {
+	case path := <-deliveries:
+		c.Fatalf("unexpected delivery to %s", path)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func (S) TestWebhookDeliveryQueue(c *C) {
+	state := NewState("abc123", filepath.Join(c.MkDir(), "host-state-db"))
+	c.Assert(state.OpenDB(), IsNil)
+	defer state.CloseDB()
+
+	var mtx sync.Mutex
+	status := http.StatusInternalServerError
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		attempts++
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	c.Assert(state.AddWebhook(&host.WebhookConfig{ID: "wh1", URL: srv.URL, MaxAttempts: 2}), IsNil)
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	d := NewWebhookDispatcher("abc123", state, logger)
+	h := &Host{state: state, log: logger, webhookDispatcher: d}
+	r := httprouter.New()
+	(&jobAPI{host: h}).RegisterRoutes(r)
+	api := httptest.NewServer(r)
+	defer api.Close()
+	listDeliveries := func(query string) []*host.WebhookDelivery {
+		res, err := http.Get(api.URL + "/host/webhooks/wh1/deliveries" + query)
+		c.Assert(err, IsNil)
+		defer res.Body.Close()
+		c.Assert(res.StatusCode, Equals, http.StatusOK)
+		var deliveries []*host.WebhookDelivery
+		c.Assert(json.NewDecoder(res.Body).Decode(&deliveries), IsNil)
+		return deliveries
+	}
+
+	// failed deliveries are persisted and retried with backoff
+	d.dispatch(&host.WebhookEvent{EventID: "event1", Code: host.CodeJobCrash, Severity: host.SeverityError})
+	d.processQueue(time.Now())
+	deliveries := listDeliveries("")
+	c.Assert(deliveries, HasLen, 1)
+	delivery := deliveries[0]
+	c.Assert(delivery.EventID, Equals, "event1")
+	c.Assert(delivery.State, Equals, host.WebhookDeliveryStatePending)
+	c.Assert(delivery.Attempts, Equals, 1)
+	c.Assert(delivery.StatusCode, Equals, http.StatusInternalServerError)
+	c.Assert(delivery.NextAttemptAt.After(delivery.UpdatedAt), Equals, true)
+	d.processQueue(time.Now())
+	c.Assert(attempts, Equals, 1)
+
+	// deliveries which exhaust their attempts are moved to the dead-letter log
+	d.processQueue(time.Now().Add(time.Hour))
+	c.Assert(attempts, Equals, 2)
+	c.Assert(listDeliveries("?state=pending"), HasLen, 0)
+	deliveries = listDeliveries("?state=failed")
+	c.Assert(deliveries, HasLen, 1)
+	c.Assert(deliveries[0].Attempts, Equals, 2)
+	d.processQueue(time.Now().Add(24 * time.Hour))
+	c.Assert(attempts, Equals, 2)
+
+	// retried deliveries are removed from the queue once they succeed
+	mtx.Lock()
+	status = http.StatusOK
+	mtx.Unlock()
+	res, err := http.Post(api.URL+"/host/webhooks/wh1/deliveries/"+delivery.ID+"/retry", "application/json", nil)
+	c.Assert(err, IsNil)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusOK)
+	d.processQueue(time.Now())
+	c.Assert(attempts, Equals, 3)
+	c.Assert(listDeliveries(""), HasLen, 0)
+
+	res, err = http.Post(api.URL+"/host/webhooks/wh1/deliveries/"+delivery.ID+"/retry", "application/json", nil)
+	c.Assert(err, IsNil)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusNotFound)
+
+	c.Assert(webhookBackoff(1), Equals, webhookRetryDelay)
+	c.Assert(webhookBackoff(3), Equals, 4*webhookRetryDelay)
+	c.Assert(webhookBackoff(100), Equals, webhookMaxRetryDelay)
+}
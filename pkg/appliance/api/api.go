@@ -0,0 +1,275 @@
+// Package api implements the HTTP API which database appliances expose to
+// the controller for provisioning and deprovisioning databases, so that each
+// appliance only needs to provide the database specific parts as a Driver.
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	discoverd "github.com/flynn/flynn/discoverd/client"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/pkg/random"
+	"github.com/flynn/flynn/pkg/resource"
+	"github.com/flynn/flynn/pkg/shutdown"
+	sirenia "github.com/flynn/flynn/pkg/sirenia/client"
+	"github.com/flynn/flynn/pkg/sirenia/scale"
+	"github.com/inconshreveable/log15"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Database identifies a provisioned database and the user which owns it.
+type Database struct {
+	User     string
+	Password string
+	Name     string
+}
+
+// ResourceID returns the ID of the resource for the database, of the form
+// /databases/user:name.
+func (d *Database) ResourceID() string {
+	return fmt.Sprintf("/databases/%s:%s", d.User, d.Name)
+}
+
+// ParseResourceID returns the database identified by a resource ID returned
+// by ResourceID, which has no password.
+func ParseResourceID(id string) (*Database, error) {
+	parts := strings.SplitN(strings.TrimPrefix(id, "/databases/"), ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid resource ID %q", id)
+	}
+	return &Database{User: parts[0], Name: parts[1]}, nil
+}
+
+// Driver implements the database specific hooks of an appliance API.
+type Driver interface {
+	// CreateDatabase creates the database and its owning user, returning
+	// the environment variables apps use to connect to it
+	CreateDatabase(ctx context.Context, db *Database) (map[string]string, error)
+
+	// DropDatabase removes the database and its owning user
+	DropDatabase(ctx context.Context, db *Database) error
+
+	// Ping checks that the database is accepting queries
+	Ping(ctx context.Context) error
+}
+
+// Config configures an appliance API.
+type Config struct {
+	// Service is the discoverd service of the database, with the API
+	// being registered as Service-api
+	Service string
+
+	// Port is the port the database leader listens on
+	Port string
+
+	// Driver implements the database specific hooks
+	Driver Driver
+
+	// Sirenia is whether the database is a Sirenia cluster which starts
+	// out dormant, in which case it is scaled up before the first database
+	// is provisioned, pings succeed until it has been scaled, and
+	// POST /scale-down is served
+	Sirenia bool
+
+	// ProcessType is the process type of the Sirenia cluster's database
+	// processes, e.g. mariadb
+	ProcessType string
+
+	// App, ControllerKey and Singleton are used to scale the Sirenia
+	// cluster, defaulting to FLYNN_APP_ID, CONTROLLER_KEY and SINGLETON
+	App           string
+	ControllerKey string
+	Singleton     string
+
+	// Routes, if set, is called to add appliance specific routes
+	Routes func(router *httprouter.Router)
+
+	Logger log15.Logger
+}
+
+// API serves the appliance API.
+type API struct {
+	conf Config
+
+	mtx      sync.Mutex
+	scaledUp bool
+}
+
+// New returns an API with the given config.
+func New(conf Config) *API {
+	if conf.App == "" {
+		conf.App = os.Getenv("FLYNN_APP_ID")
+	}
+	if conf.ControllerKey == "" {
+		conf.ControllerKey = os.Getenv("CONTROLLER_KEY")
+	}
+	if conf.Singleton == "" {
+		conf.Singleton = os.Getenv("SINGLETON")
+	}
+	if conf.Logger == nil {
+		conf.Logger = log15.New("app", conf.Service+"-web")
+	}
+	return &API{conf: conf}
+}
+
+// Run serves the API on $PORT (defaulting to 3000), registering it with
+// discoverd, and exits if it stops serving.
+func Run(conf Config) {
+	defer shutdown.Exit()
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "3000"
+	}
+	addr := ":" + port
+
+	hb, err := discoverd.AddServiceAndRegister(conf.Service+"-api", addr)
+	if err != nil {
+		shutdown.Fatal(err)
+	}
+	shutdown.BeforeExit(func() { hb.Close() })
+
+	shutdown.Fatal(http.ListenAndServe(addr, New(conf).Handler()))
+}
+
+// Handler returns the HTTP handler of the API.
+func (a *API) Handler() http.Handler {
+	router := httprouter.New()
+	router.POST("/databases", a.createDatabase)
+	router.DELETE("/databases", a.dropDatabase)
+	router.GET("/ping", a.ping)
+	if a.conf.Sirenia {
+		router.POST("/scale-down", a.scaleDown)
+	}
+	if a.conf.Routes != nil {
+		a.conf.Routes(router)
+	}
+	return httphelper.ContextInjector(a.conf.Service+"-api", httphelper.NewRequestLogger(router))
+}
+
+// ServiceHost returns the discoverd hostname of the database leader.
+func (a *API) ServiceHost() string {
+	return fmt.Sprintf("leader.%s.discoverd", a.conf.Service)
+}
+
+// ServiceAddr returns the address of the database leader.
+func (a *API) ServiceAddr() string {
+	return a.ServiceHost() + ":" + a.conf.Port
+}
+
+// Logger returns the logger of the API.
+func (a *API) Logger() log15.Logger {
+	return a.conf.Logger
+}
+
+func (a *API) createDatabase(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	// Ensure the cluster has been scaled up before attempting to create a database.
+	if err := a.ScaleUp(httphelper.RequestIDContext(req)); err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+
+	db := &Database{User: random.Hex(16), Password: random.Hex(16), Name: random.Hex(16)}
+	env, err := a.conf.Driver.CreateDatabase(req.Context(), db)
+	if err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, resource.Resource{ID: db.ResourceID(), Env: env})
+}
+
+func (a *API) dropDatabase(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	db, err := ParseResourceID(req.FormValue("id"))
+	if err != nil {
+		httphelper.ValidationError(w, "id", "is invalid")
+		return
+	}
+	if err := a.conf.Driver.DropDatabase(req.Context(), db); err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	w.WriteHeader(200)
+}
+
+func (a *API) ping(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if a.conf.Sirenia {
+		logger := a.conf.Logger.New("fn", "ping")
+		logger.Info("checking status", "host", a.ServiceHost())
+		if status, err := sirenia.NewClient(a.ServiceAddr()).Status(); err == nil && status.Database != nil && status.Database.ReadWrite {
+			logger.Info("database is up, skipping scale check")
+		} else {
+			scaled, err := scale.CheckScale(httphelper.RequestIDContext(req), a.conf.App, a.conf.ControllerKey, a.conf.ProcessType, a.conf.Logger)
+			if err != nil {
+				httphelper.Error(w, err)
+				return
+			}
+
+			// Cluster has yet to be scaled, return healthy
+			if !scaled {
+				w.WriteHeader(200)
+				return
+			}
+		}
+	}
+
+	if err := a.conf.Driver.Ping(req.Context()); err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	w.WriteHeader(200)
+}
+
+// scaleDown handles a request to POST /scale-down, safely removing async
+// members until the cluster has the requested number of processes.
+func (a *API) scaleDown(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var data struct {
+		Processes int `json:"processes"`
+	}
+	if err := httphelper.DecodeJSON(req, &data); err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	if data.Processes < scale.MinClusterSize {
+		httphelper.ValidationError(w, "processes", fmt.Sprintf("must be at least %d", scale.MinClusterSize))
+		return
+	}
+
+	// prevent concurrent scale operations
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if err := scale.ScaleDown(httphelper.RequestIDContext(req), a.conf.App, a.conf.ControllerKey, a.ServiceAddr(), a.conf.ProcessType, data.Processes, a.conf.Logger); err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	w.WriteHeader(200)
+}
+
+// ScaleUp scales up a dormant Sirenia cluster, doing nothing if it has
+// already been scaled up or the database is not a Sirenia cluster.
+func (a *API) ScaleUp(ctx context.Context) error {
+	if !a.conf.Sirenia {
+		return nil
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	// Ignore if already scaled up.
+	if a.scaledUp {
+		return nil
+	}
+
+	if err := scale.ScaleUp(ctx, a.conf.App, a.conf.ControllerKey, a.ServiceAddr(), a.conf.ProcessType, a.conf.Singleton, a.conf.Logger); err != nil {
+		return err
+	}
+
+	// Mark as successfully scaled up.
+	a.scaledUp = true
+	return nil
+}
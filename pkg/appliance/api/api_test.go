@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/flynn/flynn/pkg/resource"
+	"github.com/inconshreveable/log15"
+)
+
+type fakeDriver struct {
+	created []*Database
+	dropped []*Database
+	pingErr error
+}
+
+func (d *fakeDriver) CreateDatabase(ctx context.Context, db *Database) (map[string]string, error) {
+	d.created = append(d.created, db)
+	return map[string]string{"DATABASE_URL": "fake://" + db.User + ":" + db.Password + "@host/" + db.Name}, nil
+}
+
+func (d *fakeDriver) DropDatabase(ctx context.Context, db *Database) error {
+	d.dropped = append(d.dropped, db)
+	return nil
+}
+
+func (d *fakeDriver) Ping(ctx context.Context) error {
+	return d.pingErr
+}
+
+func TestAPI(t *testing.T) {
+	driver := &fakeDriver{}
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	srv := httptest.NewServer(New(Config{Service: "fake", Port: "1234", Driver: driver, Logger: logger}).Handler())
+	defer srv.Close()
+
+	// provisioning creates a database with random credentials
+	res, err := http.Post(srv.URL+"/databases", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r resource.Resource
+	err = json.NewDecoder(res.Body).Decode(&r)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(driver.created) != 1 {
+		t.Fatalf("expected 1 database to be created, got %d", len(driver.created))
+	}
+	db := driver.created[0]
+	if db.User == "" || db.Password == "" || db.Name == "" {
+		t.Fatalf("expected random credentials, got %+v", db)
+	}
+	if r.ID != "/databases/"+db.User+":"+db.Name || r.Env["DATABASE_URL"] == "" {
+		t.Fatalf("unexpected resource %+v", r)
+	}
+
+	// deprovisioning drops the database identified by the resource ID
+	del := func(id string) int {
+		req, _ := http.NewRequest("DELETE", srv.URL+"/databases?id="+url.QueryEscape(id), nil)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	if status := del(r.ID); status != 200 {
+		t.Fatalf("expected status 200, got %d", status)
+	}
+	if len(driver.dropped) != 1 || driver.dropped[0].User != db.User || driver.dropped[0].Name != db.Name {
+		t.Fatalf("unexpected dropped databases %+v", driver.dropped)
+	}
+	for _, id := range []string{"", "/databases/user", "/databases/user:", "/databases/:db"} {
+		if status := del(id); status != 400 {
+			t.Fatalf("%q: expected status 400, got %d", id, status)
+		}
+	}
+
+	// pings check the database
+	ping := func() int {
+		res, err := http.Get(srv.URL + "/ping")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	if status := ping(); status != 200 {
+		t.Fatalf("expected status 200, got %d", status)
+	}
+	driver.pingErr = errors.New("database down")
+	if status := ping(); status != 500 {
+		t.Fatalf("expected status 500, got %d", status)
+	}
+
+	// scaling down is only served for Sirenia clusters
+	res, err = http.Post(srv.URL+"/scale-down", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 404 {
+		t.Fatalf("expected status 404, got %d", res.StatusCode)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"crypto/x509/pkix"
 	"encoding/base64"
 	"encoding/pem"
+	"errors"
 	"math/big"
 	"net"
 	"time"
@@ -18,6 +19,10 @@ type Params struct {
 	Hosts []string
 	IsCA  bool
 	CA    *Certificate
+
+	// ClientAuth generates a certificate for authenticating clients rather
+	// than servers, with Hosts[0] as the client name
+	ClientAuth bool
 }
 
 type Certificate struct {
@@ -62,6 +67,9 @@ func Generate(p Params) (*Certificate, error) {
 		template.Subject.CommonName = p.Hosts[0]
 		template.KeyUsage = x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
 		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		if p.ClientAuth {
+			template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		}
 	}
 
 	for _, host := range p.Hosts {
@@ -103,3 +111,28 @@ func Generate(p Params) (*Certificate, error) {
 
 	return cert, nil
 }
+
+// Parse parses a PEM encoded certificate and RSA private key, for example to
+// load a CA generated by Generate.
+func Parse(certPEM, keyPEM []byte) (*Certificate, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil || certBlock.Type != "CERTIFICATE" {
+		return nil, errors.New("certgen: invalid certificate PEM")
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil || keyBlock.Type != "RSA PRIVATE KEY" {
+		return nil, errors.New("certgen: invalid RSA private key PEM")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(certBlock.Bytes)
+	return &Certificate{
+		Pin:    base64.StdEncoding.EncodeToString(h[:]),
+		PEM:    string(certPEM),
+		DER:    certBlock.Bytes,
+		KeyPEM: string(keyPEM),
+		Key:    key,
+	}, nil
+}
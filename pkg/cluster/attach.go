@@ -38,22 +38,7 @@ func (c *Host) Attach(req *host.AttachReq, wait bool) (AttachClient, error) {
 		case host.AttachSuccess:
 			return nil
 		case host.AttachError:
-			errBytes, err := ioutil.ReadAll(rwc)
-			rwc.Close()
-			if err != nil {
-				return err
-			}
-			if len(errBytes) >= 4 {
-				errBytes = errBytes[4:]
-			}
-			errMsg := string(errBytes)
-			switch errMsg {
-			case host.ErrJobNotRunning.Error():
-				return host.ErrJobNotRunning
-			case host.ErrAttached.Error():
-				return host.ErrAttached
-			}
-			return errors.New(errMsg)
+			return readAttachError(rwc)
 		default:
 			rwc.Close()
 			return fmt.Errorf("cluster: unknown attach state: %d", attachState)
@@ -84,6 +69,51 @@ func (c *Host) Attach(req *host.AttachReq, wait bool) (AttachClient, error) {
 	return NewAttachClient(rwc), handleState()
 }
 
+// Exec runs a command in the container of the running job with the given ID
+// and returns an attach client connected to the command's streams. The exit
+// status returned by the client's Receive method is that of the command.
+func (c *Host) Exec(jobID string, req *host.ExecReq) (AttachClient, error) {
+	rwc, err := c.c.Hijack("POST", "/host/jobs/"+jobID+"/exec", http.Header{"Upgrade": {"flynn-attach/0"}}, req)
+	if err != nil {
+		return nil, err
+	}
+	state := make([]byte, 1)
+	if _, err := rwc.Read(state); err != nil {
+		rwc.Close()
+		return nil, err
+	}
+	switch state[0] {
+	case host.AttachSuccess:
+		return NewAttachClient(rwc), nil
+	case host.AttachError:
+		return nil, readAttachError(rwc)
+	default:
+		rwc.Close()
+		return nil, fmt.Errorf("cluster: unknown exec state: %d", state[0])
+	}
+}
+
+// readAttachError reads the error following an AttachError state byte and
+// closes the connection.
+func readAttachError(rwc io.ReadCloser) error {
+	errBytes, err := ioutil.ReadAll(rwc)
+	rwc.Close()
+	if err != nil {
+		return err
+	}
+	if len(errBytes) >= 4 {
+		errBytes = errBytes[4:]
+	}
+	errMsg := string(errBytes)
+	switch errMsg {
+	case host.ErrJobNotRunning.Error():
+		return host.ErrJobNotRunning
+	case host.ErrAttached.Error():
+		return host.ErrAttached
+	}
+	return errors.New(errMsg)
+}
+
 // NewAttachClient wraps conn in an implementation of AttachClient.
 func NewAttachClient(conn io.ReadWriteCloser) AttachClient {
 	return &attachClient{conn: conn, w: bufio.NewWriter(conn)}
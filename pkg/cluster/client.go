@@ -95,6 +95,8 @@ func (c *Client) Hosts() ([]*Host, error) {
 					c.h,
 					HostTagsFromMeta(inst.Meta),
 				)
+				hosts[i].profiles = HostProfilesFromMeta(inst.Meta)
+				_, hosts[i].draining = inst.Meta[host.DrainMetaKey]
 			}
 			return hosts, nil
 		}
@@ -116,6 +118,22 @@ func HostTagsFromMeta(meta map[string]string) map[string]string {
 	return tags
 }
 
+// HostProfilesFromMeta returns the job profiles advertised in the given host
+// metadata, or nil if the host doesn't advertise them.
+func HostProfilesFromMeta(meta map[string]string) []host.JobProfile {
+	v, ok := meta[host.ProfilesMetaKey]
+	if !ok {
+		return nil
+	}
+	profiles := []host.JobProfile{}
+	for _, name := range strings.Split(v, ",") {
+		if name != "" {
+			profiles = append(profiles, host.JobProfile(name))
+		}
+	}
+	return profiles
+}
+
 func (c *Client) StreamHostEvents(ch chan *discoverd.Event) (stream.Stream, error) {
 	return c.s.Watch(ch)
 }
@@ -6,24 +6,30 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
 	"time"
 
+	"golang.org/x/net/context"
+
 	ct "github.com/flynn/flynn/controller/types"
 	host "github.com/flynn/flynn/host/types"
 	"github.com/flynn/flynn/host/volume"
 	"github.com/flynn/flynn/pkg/httpclient"
+	"github.com/flynn/flynn/pkg/installsource"
 	"github.com/flynn/flynn/pkg/stream"
 )
 
 // Host is a client for a host daemon.
 type Host struct {
-	id   string
-	tags map[string]string
-	c    *httpclient.Client
+	id       string
+	tags     map[string]string
+	profiles []host.JobProfile
+	draining bool
+	c        *httpclient.Client
 }
 
 // NewHost creates a new Host that uses client to communicate with it.
@@ -32,21 +38,63 @@ func NewHost(id string, addr string, h *http.Client, tags map[string]string) *Ho
 	if h == nil {
 		h = http.DefaultClient
 	}
-	if !strings.HasPrefix(addr, "http") {
-		addr = "http://" + addr
+	tlsConfig, tlsErr := HostTLSConfig()
+	c := &httpclient.Client{
+		ErrNotFound: ErrNotFound,
+		URL:         hostURL(addr, tlsConfig != nil || tlsErr != nil),
+		HTTP:        h,
+		Key:         hostAuthKey(),
+	}
+	if tlsErr != nil {
+		// don't fall back to plain HTTP, fail requests with the error
+		c.HTTP = &http.Client{Transport: errTransport{tlsErr}}
+		c.HijackDial = func(string, string) (net.Conn, error) { return nil, tlsErr }
+	} else if tlsConfig != nil {
+		c.HTTP = hostTLSClient(h)
+		c.HijackDial = hostTLSDial(tlsConfig)
 	}
 	return &Host{
 		id:   id,
 		tags: tags,
-		c: &httpclient.Client{
-			ErrNotFound: ErrNotFound,
-			URL:         addr,
-			HTTP:        h,
-			Key:         os.Getenv("FLYNN_HOST_AUTH_KEY"),
-		},
+		c:    c,
 	}
 }
 
+// hostAuthKey returns the key to authenticate with host APIs. The
+// FLYNN_HOST_AUTH_KEY environment variable may contain a comma separated list
+// of keys, as accepted by flynn-host, in which case the first is used.
+func hostAuthKey() string {
+	return strings.TrimSpace(strings.SplitN(os.Getenv("FLYNN_HOST_AUTH_KEY"), ",", 2)[0])
+}
+
+// WithAuthKey returns a copy of the client which authenticates with the given
+// key, for example after rotating the host's auth key.
+func (c *Host) WithAuthKey(key string) *Host {
+	copy := *c
+	client := *c.c
+	client.Key = key
+	copy.c = &client
+	return &copy
+}
+
+// WithRequestID returns a copy of the client which sends the given request
+// ID with each request, so that requests made while serving another request
+// can be traced back to it.
+func (c *Host) WithRequestID(id string) *Host {
+	copy := *c
+	copy.c = c.c.WithRequestID(id)
+	return &copy
+}
+
+// WithContext returns a copy of the client which makes requests with the
+// given context, sending the request ID and trace context it carries with
+// each request and aborting them once it is done.
+func (c *Host) WithContext(ctx context.Context) *Host {
+	copy := *c
+	copy.c = c.c.WithContext(ctx)
+	return &copy
+}
+
 // ID returns the ID of the host this client communicates with.
 func (c *Host) ID() string {
 	return c.id
@@ -57,6 +105,18 @@ func (c *Host) Tags() map[string]string {
 	return c.tags
 }
 
+// Profiles returns the job profiles the host supports, or nil if the host
+// doesn't advertise them (i.e. it is running an older version).
+func (c *Host) Profiles() []host.JobProfile {
+	return c.profiles
+}
+
+// Draining returns whether the host was draining when it was looked up, in
+// which case jobs should not be placed on it.
+func (c *Host) Draining() bool {
+	return c.draining
+}
+
 // Addr returns the IP/port that the host API is listening on.
 func (c *Host) Addr() string {
 	u, err := url.Parse(c.c.URL)
@@ -86,6 +146,91 @@ func (c *Host) GetJobStats(jobID string) (*host.ContainerStats, error) {
 	return &res, err
 }
 
+// GetJobStatsHistory returns the stats samples recorded for a job on this
+// host after since, oldest first.
+func (c *Host) GetJobStatsHistory(jobID string, since time.Time) ([]*host.ContainerStats, error) {
+	var res []*host.ContainerStats
+	err := c.c.Get(jobStatsHistoryPath(jobID, since), &res)
+	return res, err
+}
+
+// StreamJobStats streams the stats samples recorded for a job on this host
+// after since, followed by new samples as they are taken.
+func (c *Host) StreamJobStats(jobID string, since time.Time, ch chan *host.ContainerStats) (stream.Stream, error) {
+	return c.c.Stream("GET", jobStatsHistoryPath(jobID, since), nil, ch)
+}
+
+func jobStatsHistoryPath(jobID string, since time.Time) string {
+	path := fmt.Sprintf("/host/jobs/%s/stats/history", jobID)
+	if !since.IsZero() {
+		path += "?since=" + url.QueryEscape(since.Format(time.RFC3339Nano))
+	}
+	return path
+}
+
+// GetJobLogs returns the last tail lines of a job's output buffered on this
+// host, or all of them if tail is zero.
+func (c *Host) GetJobLogs(jobID string, tail int) ([]*host.JobLogLine, error) {
+	var res []*host.JobLogLine
+	err := c.c.Get(jobLogsPath(jobID, tail, false), &res)
+	return res, err
+}
+
+// StreamJobLogs streams the last tail lines of a job's output buffered on
+// this host, or all of them if tail is zero, followed by new lines until the
+// job exits if follow is set.
+func (c *Host) StreamJobLogs(jobID string, tail int, follow bool, ch chan *host.JobLogLine) (stream.Stream, error) {
+	return c.c.Stream("GET", jobLogsPath(jobID, tail, follow), nil, ch)
+}
+
+func jobLogsPath(jobID string, tail int, follow bool) string {
+	path := fmt.Sprintf("/host/jobs/%s/logs?tail=%d", jobID, tail)
+	if follow {
+		path += "&follow=true"
+	}
+	return path
+}
+
+// ListCoreDumps lists the core dumps captured from a job on this host.
+func (c *Host) ListCoreDumps(jobID string) ([]*host.CoreDump, error) {
+	var res []*host.CoreDump
+	err := c.c.Get(fmt.Sprintf("/host/jobs/%s/coredumps", jobID), &res)
+	return res, err
+}
+
+// GetCoreDump returns the contents of a core dump captured from a job on this
+// host.
+func (c *Host) GetCoreDump(jobID, name string) (io.ReadCloser, error) {
+	res, err := c.c.RawReq("GET", fmt.Sprintf("/host/jobs/%s/coredumps/%s", jobID, name), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// CopyFromJob returns a tar archive of the file or directory at path in the
+// filesystem of a running job on this host.
+func (c *Host) CopyFromJob(jobID, path string) (io.ReadCloser, error) {
+	query := url.Values{"path": {path}}
+	res, err := c.c.RawReq("GET", fmt.Sprintf("/host/jobs/%s/files?%s", jobID, query.Encode()), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// CopyToJob extracts the tar archive read from archive into the directory at
+// path in the filesystem of a running job on this host.
+func (c *Host) CopyToJob(jobID, path string, archive io.Reader) error {
+	header := http.Header{"Content-Type": {"application/x-tar"}}
+	query := url.Values{"path": {path}}
+	res, err := c.c.RawReq("PUT", fmt.Sprintf("/host/jobs/%s/files?%s", jobID, query.Encode()), header, archive, nil)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
 // GetAllJobsStats returns stats for all jobs on this host.
 func (c *Host) GetAllJobsStats() (*host.AllJobsStats, error) {
 	var res host.AllJobsStats
@@ -148,6 +293,20 @@ func (c *Host) SignalJob(id string, sig int) error {
 	return c.c.Put(fmt.Sprintf("/host/jobs/%s/signal/%d", id, sig), nil, nil)
 }
 
+// BulkStopJobs stops the jobs on the host whose metadata matches the
+// selector.
+func (c *Host) BulkStopJobs(sel host.JobSelector) (*host.BulkJobsResult, error) {
+	var res host.BulkJobsResult
+	return &res, c.c.Post("/host/jobs-bulk/stop", &host.BulkStopRequest{Selector: sel}, &res)
+}
+
+// BulkSignalJobs sends a signal to the running jobs on the host whose
+// metadata matches the selector.
+func (c *Host) BulkSignalJobs(sel host.JobSelector, sig int) (*host.BulkJobsResult, error) {
+	var res host.BulkJobsResult
+	return &res, c.c.Post("/host/jobs-bulk/signal", &host.BulkSignalRequest{Selector: sel, Signal: sig}, &res)
+}
+
 // DiscoverdDeregisterJob requests a job to deregister from service discovery.
 func (c *Host) DiscoverdDeregisterJob(id string) error {
 	return c.c.Put(fmt.Sprintf("/host/jobs/%s/discoverd-deregister", id), nil, nil)
@@ -198,6 +357,13 @@ func (c *Host) CreateSnapshot(volumeID string) (*volume.Info, error) {
 	return &res, err
 }
 
+// ForkVolume creates a new volume with the given metadata from a snapshot.
+func (c *Host) ForkVolume(snapID string, meta map[string]string) (*volume.Info, error) {
+	var res volume.Info
+	err := c.c.Post(fmt.Sprintf("/storage/volumes/%s/fork", snapID), &volume.ForkRequest{Meta: meta}, &res)
+	return &res, err
+}
+
 // PullSnapshot requests the host pull a snapshot from another host onto one of
 // its volumes. Returns the info for the new snapshot.
 func (c *Host) PullSnapshot(receiveVolID string, sourceHostID string, sourceSnapID string) (*volume.Info, error) {
@@ -291,6 +457,43 @@ func (c *Host) UpdateTags(tags map[string]string) error {
 	return c.c.Post("/host/tags", tags, nil)
 }
 
+// Drain starts draining the host, which makes the scheduler move its jobs to
+// other hosts, waiting up to timeout for them to stop. A zero timeout uses
+// host.DefaultDrainTimeout.
+func (c *Host) Drain(timeout time.Duration) (*host.DrainStatus, error) {
+	var res host.DrainStatus
+	err := c.c.Post("/host/drain", &host.DrainRequest{Timeout: timeout}, &res)
+	return &res, err
+}
+
+// DrainStatus returns the status of draining the host, returning an object
+// not found error if the host is not draining.
+func (c *Host) DrainStatus() (*host.DrainStatus, error) {
+	var res host.DrainStatus
+	err := c.c.Get("/host/drain", &res)
+	return &res, err
+}
+
+// Undrain stops draining the host so jobs can be placed on it again.
+func (c *Host) Undrain() error {
+	return c.c.Delete("/host/drain")
+}
+
+// GCLayers removes image layers which are no longer needed from the host's
+// layer cache.
+func (c *Host) GCLayers(req *host.LayerGCRequest) (*host.LayerGCResult, error) {
+	var res host.LayerGCResult
+	err := c.c.Post("/host/layer-gc", req, &res)
+	return &res, err
+}
+
+// UpdateHistory returns the cluster updates which were run from this host,
+// oldest first.
+func (c *Host) UpdateHistory() ([]*installsource.UpdateRecord, error) {
+	var history []*installsource.UpdateRecord
+	return history, c.c.Get("/host/update-history", &history)
+}
+
 func (c *Host) GetSinks() ([]*ct.Sink, error) {
 	var sinks []*ct.Sink
 	return sinks, c.c.Get("/sinks", &sinks)
@@ -310,17 +513,13 @@ func (c *Host) RemoveSink(id string) error {
 	return c.c.Delete("/sinks/" + id)
 }
 
-// AddWebhook registers a webhook endpoint on the host.
-// If id is empty, the server generates one. Headers, if non-nil, are sent
-// on every webhook delivery (e.g. an auth token).
-func (c *Host) AddWebhook(id, url string, headers map[string]string) (*host.WebhookConfig, error) {
-	input := struct {
-		ID      string            `json:"id,omitempty"`
-		URL     string            `json:"url"`
-		Headers map[string]string `json:"headers,omitempty"`
-	}{ID: id, URL: url, Headers: headers}
-	var wh host.WebhookConfig
-	return &wh, c.c.Post("/host/webhooks", &input, &wh)
+// AddWebhook registers a webhook endpoint on the host. If wh.ID is empty,
+// the server generates one. Headers, if set, are sent on every webhook
+// delivery (e.g. an auth token), deliveries are signed if Secret is set and
+// only events matching Codes and MinSeverity are delivered.
+func (c *Host) AddWebhook(wh *host.WebhookConfig) (*host.WebhookConfig, error) {
+	var res host.WebhookConfig
+	return &res, c.c.Post("/host/webhooks", wh, &res)
 }
 
 // ListWebhooks returns all configured webhooks on the host.
@@ -333,3 +532,43 @@ func (c *Host) ListWebhooks() ([]*host.WebhookConfig, error) {
 func (c *Host) RemoveWebhook(id string) error {
 	return c.c.Delete(fmt.Sprintf("/host/webhooks/%s", id))
 }
+
+// TestWebhook sends a synthetic event to the webhook with the given ID and
+// returns the result of the delivery.
+func (c *Host) TestWebhook(id string) (*host.WebhookTestResult, error) {
+	var res host.WebhookTestResult
+	return &res, c.c.Post(fmt.Sprintf("/host/webhooks/%s/test", id), nil, &res)
+}
+
+// ListWebhookDeliveries returns the queued and dead-lettered deliveries to
+// the webhook with the given ID, filtered by state if it is not empty.
+func (c *Host) ListWebhookDeliveries(id string, state host.WebhookDeliveryState) ([]*host.WebhookDelivery, error) {
+	path := fmt.Sprintf("/host/webhooks/%s/deliveries", id)
+	if state != "" {
+		path += "?state=" + string(state)
+	}
+	var deliveries []*host.WebhookDelivery
+	return deliveries, c.c.Get(path, &deliveries)
+}
+
+// RetryWebhookDelivery re-queues a webhook delivery to be attempted
+// immediately.
+func (c *Host) RetryWebhookDelivery(webhookID, deliveryID string) (*host.WebhookDelivery, error) {
+	var res host.WebhookDelivery
+	return &res, c.c.Post(fmt.Sprintf("/host/webhooks/%s/deliveries/%s/retry", webhookID, deliveryID), nil, &res)
+}
+
+// ListAuthKeys returns details of the auth keys the host currently accepts.
+func (c *Host) ListAuthKeys() ([]*host.AuthKeyInfo, error) {
+	var keys []*host.AuthKeyInfo
+	return keys, c.c.Get("/host/auth-keys", &keys)
+}
+
+// RotateAuthKey makes key the host's auth key, with the existing keys
+// remaining valid for the grace period (host.DefaultAuthKeyGracePeriod if
+// zero). Callers should switch to the new key, using WithAuthKey, before the
+// grace period ends.
+func (c *Host) RotateAuthKey(key string, grace time.Duration) ([]*host.AuthKeyInfo, error) {
+	var keys []*host.AuthKeyInfo
+	return keys, c.c.Post("/host/auth-keys/rotate", &host.AuthKeyRotation{Key: key, GracePeriod: grace}, &keys)
+}
@@ -0,0 +1,116 @@
+package cluster
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/flynn/flynn/pkg/tlsconfig"
+)
+
+// Host API clients use mutual TLS if the cluster's host CA is set in the
+// FLYNN_HOST_TLS_CA environment variable, presenting the client certificate
+// and key in FLYNN_HOST_TLS_CLIENT_CERT and FLYNN_HOST_TLS_CLIENT_KEY if set.
+// All three are PEM encoded (see 'flynn-host tls').
+var (
+	hostTLSOnce      sync.Once
+	hostTLSConfig    *tls.Config
+	hostTLSTransport *http.Transport
+	hostTLSErr       error
+)
+
+// HostTLSConfig returns the TLS config for connecting to host APIs, or nil
+// if the host CA is not set.
+func HostTLSConfig() (*tls.Config, error) {
+	hostTLSOnce.Do(func() {
+		hostTLSConfig, hostTLSErr = hostTLSConfigFromEnv()
+		if hostTLSErr != nil {
+			hostTLSErr = fmt.Errorf("cluster: error loading host TLS config: %s", hostTLSErr)
+			return
+		}
+		if hostTLSConfig != nil {
+			hostTLSTransport = http.DefaultTransport.(*http.Transport).Clone()
+			hostTLSTransport.TLSClientConfig = hostTLSConfig
+		}
+	})
+	return hostTLSConfig, hostTLSErr
+}
+
+func hostTLSConfigFromEnv() (*tls.Config, error) {
+	caPEM := os.Getenv("FLYNN_HOST_TLS_CA")
+	if caPEM == "" {
+		return nil, nil
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(caPEM)) {
+		return nil, errors.New("invalid FLYNN_HOST_TLS_CA")
+	}
+	config := tlsconfig.SecureCiphers(&tls.Config{RootCAs: pool})
+	certPEM, keyPEM := os.Getenv("FLYNN_HOST_TLS_CLIENT_CERT"), os.Getenv("FLYNN_HOST_TLS_CLIENT_KEY")
+	if certPEM != "" || keyPEM != "" {
+		cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// hostTLSClient returns a client which makes requests like h but using the
+// host TLS config. Clients using the default transport share one TLS
+// transport so that connections are reused, while those with their own
+// transport get a copy of it using the TLS config.
+func hostTLSClient(h *http.Client) *http.Client {
+	transport := hostTLSTransport
+	if t, ok := h.Transport.(*http.Transport); ok && t != http.DefaultTransport {
+		transport = t.Clone()
+		transport.TLSClientConfig = hostTLSConfig
+	}
+	c := *h
+	c.Transport = transport
+	return &c
+}
+
+// errTransport fails every request with err, so that hosts are still
+// created when the host TLS config is invalid but report why they can't be
+// used.
+type errTransport struct {
+	err error
+}
+
+func (t errTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, t.err
+}
+
+// hostTLSDial returns a dial function for hijacked connections which uses
+// the given TLS config.
+func hostTLSDial(config *tls.Config) func(string, string) (net.Conn, error) {
+	return func(network, addr string) (net.Conn, error) {
+		conn, err := net.Dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		c := config.Clone()
+		c.ServerName, _, _ = net.SplitHostPort(addr)
+		return tls.Client(conn, c), nil
+	}
+}
+
+// hostURL returns the URL of the host API at addr, using HTTPS if the host
+// CA is set.
+func hostURL(addr string, tls bool) string {
+	if !strings.HasPrefix(addr, "http") {
+		addr = "http://" + addr
+	}
+	if tls {
+		addr = "https://" + strings.TrimPrefix(addr, "http://")
+	}
+	return addr
+}
@@ -0,0 +1,86 @@
+package ghrelease
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Channel is a stream of releases which updates are taken from
+type Channel string
+
+const (
+	// ChannelStable follows full releases, i.e. GitHub's latest release
+	ChannelStable Channel = "stable"
+	// ChannelBeta follows the newest release including prereleases
+	ChannelBeta Channel = "beta"
+	// ChannelNightly follows releases tagged nightly-*
+	ChannelNightly Channel = "nightly"
+
+	// DefaultChannel is the channel used when none is configured
+	DefaultChannel = ChannelStable
+
+	// nightlyTagPrefix is the tag prefix of nightly releases
+	nightlyTagPrefix = "nightly-"
+)
+
+// ParseChannel returns the channel with the given name, with an empty name
+// being the default channel.
+func ParseChannel(name string) (Channel, error) {
+	switch c := Channel(name); c {
+	case "":
+		return DefaultChannel, nil
+	case ChannelStable, ChannelBeta, ChannelNightly:
+		return c, nil
+	default:
+		return "", fmt.Errorf("unknown release channel %q, must be one of stable, beta or nightly", name)
+	}
+}
+
+// GetChannelRelease fetches the newest release on the given channel
+func (c *Client) GetChannelRelease(channel Channel) (*Release, error) {
+	if channel == ChannelStable {
+		return c.GetLatestRelease()
+	}
+	releases, err := c.ListReleases()
+	if err != nil {
+		return nil, err
+	}
+	release := SelectChannelRelease(releases, channel)
+	if release == nil {
+		return nil, fmt.Errorf("no releases found on the %s channel", channel)
+	}
+	return release, nil
+}
+
+// SelectChannelRelease returns the most recently published release on the
+// given channel, or nil if there are none. Drafts are never selected, the
+// stable channel excludes prereleases, and nightly releases are only on the
+// nightly channel.
+func SelectChannelRelease(releases []Release, channel Channel) *Release {
+	var newest *Release
+	for i := range releases {
+		r := &releases[i]
+		if r.Draft || !r.OnChannel(channel) {
+			continue
+		}
+		if newest == nil || r.PublishedAt.After(newest.PublishedAt) {
+			newest = r
+		}
+	}
+	return newest
+}
+
+// OnChannel returns whether the release is on the given channel
+func (r *Release) OnChannel(channel Channel) bool {
+	nightly := strings.HasPrefix(r.TagName, nightlyTagPrefix)
+	switch channel {
+	case ChannelStable:
+		return !nightly && !r.Prerelease
+	case ChannelBeta:
+		return !nightly
+	case ChannelNightly:
+		return nightly
+	default:
+		return false
+	}
+}
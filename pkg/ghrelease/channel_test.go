@@ -0,0 +1,50 @@
+package ghrelease
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSelectChannelRelease(t *testing.T) {
+	day := func(n int) time.Time { return time.Date(2024, 1, n, 0, 0, 0, 0, time.UTC) }
+	releases := []Release{
+		{TagName: "v20240101.0", PublishedAt: day(1)},
+		{TagName: "v20240102.0", PublishedAt: day(2), Prerelease: true},
+		{TagName: "nightly-20240103", PublishedAt: day(3), Prerelease: true},
+		{TagName: "v20240104.0", PublishedAt: day(4), Draft: true},
+	}
+	for channel, expected := range map[Channel]string{
+		ChannelStable:  "v20240101.0",
+		ChannelBeta:    "v20240102.0",
+		ChannelNightly: "nightly-20240103",
+	} {
+		r := SelectChannelRelease(releases, channel)
+		if r == nil || r.TagName != expected {
+			t.Fatalf("%s: expected %s, got %+v", channel, expected, r)
+		}
+	}
+	if r := SelectChannelRelease(releases[:2], ChannelNightly); r != nil {
+		t.Fatalf("expected no nightly release, got %s", r.TagName)
+	}
+}
+
+func TestParseChannel(t *testing.T) {
+	if c, err := ParseChannel(""); err != nil || c != ChannelStable {
+		t.Fatalf("expected default channel stable, got %q %v", c, err)
+	}
+	if c, err := ParseChannel("beta"); err != nil || c != ChannelBeta {
+		t.Fatalf("expected beta, got %q %v", c, err)
+	}
+	if _, err := ParseChannel("edge"); err == nil {
+		t.Fatal("expected error for unknown channel")
+	}
+}
+
+func TestCompareVersionsAcrossChannels(t *testing.T) {
+	if !CompareVersions("v20240101.0", "nightly-20240103") {
+		t.Fatal("expected newer nightly to be an update")
+	}
+	if CompareVersions("nightly-20240103", "v20240101.0") {
+		t.Fatal("expected older stable release not to be an update")
+	}
+}
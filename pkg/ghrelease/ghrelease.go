@@ -9,7 +9,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
+	"runtime"
 	"time"
 
 	"github.com/inconshreveable/log15"
@@ -47,6 +47,7 @@ type Asset struct {
 type Client struct {
 	repo       string // e.g., "flynn/flynn"
 	httpClient *http.Client
+	auth       *Auth
 	log        log15.Logger
 }
 
@@ -73,13 +74,12 @@ func (c *Client) GetReleaseByTag(tag string) (*Release, error) {
 
 // ListReleases fetches all releases (for channel support)
 func (c *Client) ListReleases() ([]Release, error) {
-	url := fmt.Sprintf("%s/repos/%s/releases", GitHubAPIBase, c.repo)
+	url := fmt.Sprintf("%s/repos/%s/releases?per_page=100", GitHubAPIBase, c.repo)
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := c.newRequest(url)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", UserAgent)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -110,24 +110,17 @@ func (c *Client) CheckForUpdate(currentVersion string) (*Release, bool, error) {
 	return latest, hasUpdate, nil
 }
 
-// CompareVersions returns true if latestVersion is newer than currentVersion
-func CompareVersions(currentVersion, latestVersion string) bool {
-	// Strip 'v' prefix for comparison
-	current := strings.TrimPrefix(currentVersion, "v")
-	latest := strings.TrimPrefix(latestVersion, "v")
-
-	// Simple string comparison works for date-based versions like "20240127.0"
-	// For more complex versioning, consider using a semver library
-	return latest > current
-}
-
 // DownloadAsset downloads a release asset to the specified directory
 func (c *Client) DownloadAsset(asset *Asset, destDir string) (string, error) {
 	destPath := filepath.Join(destDir, asset.Name)
 
 	c.log.Info("downloading asset", "name", asset.Name, "size", asset.Size)
 
-	resp, err := c.httpClient.Get(asset.BrowserDownloadURL)
+	req, err := c.newRequest(asset.BrowserDownloadURL)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("failed to download asset: %w", err)
 	}
@@ -163,6 +156,19 @@ func (r *Release) GetAssetByName(name string) *Asset {
 	return nil
 }
 
+// HostAssetName returns the name of the release asset of a Linux binary
+// (e.g. flynn-host) for the architecture this binary was built for, e.g.
+// flynn-host-linux-arm64.
+func HostAssetName(name string) string {
+	return HostAssetNameForArch(name, runtime.GOARCH)
+}
+
+// HostAssetNameForArch returns the name of the release asset of a Linux
+// binary for the given architecture.
+func HostAssetNameForArch(name, arch string) string {
+	return fmt.Sprintf("%s-linux-%s", name, arch)
+}
+
 // GetReleaseURL returns the download URL for a specific release
 func GetReleaseURL(repo, version string) string {
 	return fmt.Sprintf("https://github.com/%s/releases/download/%s", repo, version)
@@ -170,11 +176,10 @@ func GetReleaseURL(repo, version string) string {
 
 // getRelease is a helper to fetch a single release from a URL
 func (c *Client) getRelease(url string) (*Release, error) {
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := c.newRequest(url)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", UserAgent)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -202,7 +207,11 @@ func (c *Client) getRelease(url string) (*Release, error) {
 func (c *Client) DownloadFile(url, destPath string) error {
 	c.log.Info("downloading file", "url", url, "dest", destPath)
 
-	resp, err := c.httpClient.Get(url)
+	req, err := c.newRequest(url)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to download: %w", err)
 	}
@@ -0,0 +1,44 @@
+package ghrelease
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseAuth(t *testing.T) {
+	for _, test := range []struct {
+		creds  string
+		header string
+	}{
+		{"Bearer abc123", "Bearer abc123"},
+		{"user:pass", "Basic dXNlcjpwYXNz"},
+		{"user:pa:ss\n", "Basic dXNlcjpwYTpzcw=="},
+	} {
+		auth, err := ParseAuth(test.creds)
+		if err != nil {
+			t.Fatalf("error parsing %q: %s", test.creds, err)
+		}
+		req, _ := http.NewRequest("GET", "https://mirror.example.com/images.json.gz", nil)
+		auth.Apply(req)
+		if got := req.Header.Get("Authorization"); got != test.header {
+			t.Fatalf("expected Authorization %q for %q, got %q", test.header, test.creds, got)
+		}
+	}
+	for _, creds := range []string{"", "token", ":pass"} {
+		if _, err := ParseAuth(creds); err == nil {
+			t.Fatalf("expected an error parsing %q", creds)
+		}
+	}
+}
+
+func TestLoadRootCAsWithoutCertificates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRootCAs(path); err == nil {
+		t.Fatal("expected an error loading a file without certificates")
+	}
+}
@@ -0,0 +1,146 @@
+package ghrelease
+
+import (
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/inconshreveable/log15"
+)
+
+const (
+	// ChecksumsName is the name of the release asset listing the SHA512
+	// checksums of the other assets
+	ChecksumsName = "checksums.sha512"
+	// ChecksumsSignatureName is the name of the release asset holding the
+	// base64 encoded Ed25519 signature of the checksums
+	ChecksumsSignatureName = ChecksumsName + ".sig"
+)
+
+// publicKey is the base64 encoded Ed25519 public key which release checksums
+// are signed with, set at build time (see builder/go-wrapper.sh)
+var publicKey string
+
+// ErrNoPublicKey is returned by PublicKey when no release signing key has
+// been configured
+var ErrNoPublicKey = errors.New("no release signing key configured")
+
+// PublicKey returns the public key which release checksums are signed with.
+// It can only be set at build time so that it cannot be replaced by anyone
+// able to set the environment of an updater.
+func PublicKey() (ed25519.PublicKey, error) {
+	key := publicKey
+	if key == "" {
+		return nil, ErrNoPublicKey
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil || len(data) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid release signing key %q", key)
+	}
+	return ed25519.PublicKey(data), nil
+}
+
+// VerifySignature checks that sig is a base64 encoded Ed25519 signature of
+// data by the given key.
+func VerifySignature(key ed25519.PublicKey, data, sig []byte) error {
+	s, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil {
+		return fmt.Errorf("invalid signature: %s", err)
+	}
+	if !ed25519.Verify(key, data, s) {
+		return errors.New("signature verification failed")
+	}
+	return nil
+}
+
+// VerifyChecksums checks the signature of a release's checksums and returns
+// them by asset name. Unsigned checksums are rejected unless no release
+// signing key is configured, in which case the signature is not checked.
+func VerifyChecksums(checksums, sig []byte, log log15.Logger) (map[string]string, error) {
+	key, err := PublicKey()
+	if err == ErrNoPublicKey {
+		log.Warn("no release signing key configured, not verifying checksums signature")
+		return ParseChecksums(checksums), nil
+	} else if err != nil {
+		return nil, err
+	}
+	if sig == nil {
+		return nil, fmt.Errorf("%s is not signed", ChecksumsName)
+	}
+	if err := VerifySignature(key, checksums, sig); err != nil {
+		return nil, fmt.Errorf("error verifying %s: %s", ChecksumsName, err)
+	}
+	log.Info("checksums signature verified")
+	return ParseChecksums(checksums), nil
+}
+
+// DownloadChecksums downloads the checksums of the release with the given
+// tag to dir, along with their signature if a release signing key is
+// configured, and returns them once verified.
+func (c *Client) DownloadChecksums(tag, dir string) (map[string]string, error) {
+	baseURL := GetReleaseURL(c.repo, tag)
+	checksumsPath := filepath.Join(dir, ChecksumsName)
+	if err := c.DownloadFile(baseURL+"/"+ChecksumsName, checksumsPath); err != nil {
+		return nil, err
+	}
+	checksums, err := os.ReadFile(checksumsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var sig []byte
+	if _, err := PublicKey(); err != ErrNoPublicKey {
+		sigPath := filepath.Join(dir, ChecksumsSignatureName)
+		if err := c.DownloadFile(baseURL+"/"+ChecksumsSignatureName, sigPath); err != nil {
+			return nil, fmt.Errorf("error downloading %s: %s", ChecksumsSignatureName, err)
+		}
+		if sig, err = os.ReadFile(sigPath); err != nil {
+			return nil, err
+		}
+	}
+	return VerifyChecksums(checksums, sig, c.log)
+}
+
+// ParseChecksums parses the output of sha512sum, returning the checksums by
+// file name.
+func ParseChecksums(data []byte) map[string]string {
+	checksums := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		parts := strings.Fields(line)
+		if len(parts) == 2 {
+			// Strip common prefixes from filename (*, ./, etc.)
+			filename := parts[1]
+			filename = strings.TrimPrefix(filename, "*")
+			filename = strings.TrimPrefix(filename, "./")
+			checksums[filename] = parts[0]
+		}
+	}
+	return checksums
+}
+
+// VerifyChecksum verifies a file's SHA512 checksum
+func VerifyChecksum(path, expected string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha512.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+
+	actual := hex.EncodeToString(h.Sum(nil))
+	if actual != expected {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", expected, actual)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package ghrelease
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/inconshreveable/log15"
+)
+
+func setPublicKey(t *testing.T, key string) {
+	prev := publicKey
+	publicKey = key
+	t.Cleanup(func() { publicKey = prev })
+}
+
+func TestVerifyChecksums(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := log15.New()
+	log.SetHandler(log15.DiscardHandler())
+
+	checksums := []byte("abc123  ./flynn-host-linux-amd64.gz\ndef456  *images.json.gz\n")
+	sign := func(data []byte) []byte {
+		return []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, data)) + "\n")
+	}
+
+	// without a key, checksums are used unverified
+	setPublicKey(t, "")
+	sums, err := VerifyChecksums(checksums, nil, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sums["flynn-host-linux-amd64.gz"] != "abc123" || sums["images.json.gz"] != "def456" {
+		t.Fatalf("unexpected checksums %v", sums)
+	}
+
+	// with a key, the signature is required and must match
+	setPublicKey(t, base64.StdEncoding.EncodeToString(pub))
+	if _, err := VerifyChecksums(checksums, sign(checksums), log); err != nil {
+		t.Fatalf("expected valid signature, got %s", err)
+	}
+	if _, err := VerifyChecksums(checksums, nil, log); err == nil {
+		t.Fatal("expected error for unsigned checksums")
+	}
+	tampered := append([]byte("000000  ./flynn-host-linux-amd64.gz\n"), checksums...)
+	if _, err := VerifyChecksums(tampered, sign(checksums), log); err == nil {
+		t.Fatal("expected error for tampered checksums")
+	}
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	if _, err := VerifyChecksums(checksums, []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(other, checksums))), log); err == nil {
+		t.Fatal("expected error for signature by another key")
+	}
+
+	setPublicKey(t, "not-a-key")
+	if _, err := VerifyChecksums(checksums, sign(checksums), log); err == nil {
+		t.Fatal("expected error for invalid key")
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "asset")
+	if err := os.WriteFile(path, []byte("flynn"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha512.Sum512([]byte("flynn"))
+	if err := VerifyChecksum(path, hex.EncodeToString(sum[:])); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyChecksum(path, strings.Repeat("0", 128)); err == nil {
+		t.Fatal("expected checksum mismatch")
+	}
+}
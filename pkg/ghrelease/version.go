@@ -0,0 +1,144 @@
+package ghrelease
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a parsed release version, either date based (e.g. v20240127.0
+// or nightly-20240127) or semantic (e.g. v2.1.0 or v2.1.0-rc.1)
+type Version struct {
+	// Segments are the dot separated numbers of the version, e.g.
+	// [20240127 0] or [2 1 0]
+	Segments []int
+	// Prerelease are the dot separated identifiers following a '-', e.g.
+	// [rc 1]
+	Prerelease []string
+	// Date is whether the version is date based, i.e. its first segment
+	// is an eight digit date
+	Date bool
+}
+
+// ParseVersion parses a release tag, ignoring a leading 'v' or 'nightly-'
+// and any '+' build metadata.
+func ParseVersion(s string) (*Version, error) {
+	v := trimVersionPrefix(s)
+	if i := strings.IndexByte(v, '+'); i >= 0 {
+		v = v[:i]
+	}
+	var prerelease []string
+	if i := strings.IndexByte(v, '-'); i >= 0 {
+		v, prerelease = v[:i], strings.Split(v[i+1:], ".")
+	}
+	if v == "" {
+		return nil, fmt.Errorf("invalid version %q", s)
+	}
+
+	version := &Version{}
+	for i, seg := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(seg)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid version %q", s)
+		}
+		if i == 0 {
+			version.Date = len(seg) == 8
+		}
+		version.Segments = append(version.Segments, n)
+	}
+	for _, id := range prerelease {
+		if id == "" {
+			return nil, fmt.Errorf("invalid version %q", s)
+		}
+	}
+	version.Prerelease = prerelease
+	return version, nil
+}
+
+// Compare returns -1, 0 or 1 depending on whether v is older than, the same
+// as or newer than other.
+//
+// Date based versions are older than semantic versions, as releases moved
+// from being tagged by date to semantic versions. Otherwise segments are
+// compared numerically with missing segments being zero, and a prerelease
+// is older than its release, with prereleases ordered as in Semantic
+// Versioning.
+func (v *Version) Compare(other *Version) int {
+	if v.Date != other.Date {
+		if v.Date {
+			return -1
+		}
+		return 1
+	}
+	for i := 0; i < len(v.Segments) || i < len(other.Segments); i++ {
+		if c := compareInts(segment(v.Segments, i), segment(other.Segments, i)); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(v.Prerelease) == 0 && len(other.Prerelease) == 0:
+		return 0
+	case len(v.Prerelease) == 0:
+		return 1
+	case len(other.Prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.Prerelease) && i < len(other.Prerelease); i++ {
+		if c := comparePrereleaseIDs(v.Prerelease[i], other.Prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(v.Prerelease), len(other.Prerelease))
+}
+
+// CompareVersions returns true if latestVersion is newer than
+// currentVersion. Versions which cannot be parsed, such as dev builds, are
+// compared as strings.
+func CompareVersions(currentVersion, latestVersion string) bool {
+	current, err := ParseVersion(currentVersion)
+	if err != nil {
+		return trimVersionPrefix(latestVersion) > trimVersionPrefix(currentVersion)
+	}
+	latest, err := ParseVersion(latestVersion)
+	if err != nil {
+		return trimVersionPrefix(latestVersion) > trimVersionPrefix(currentVersion)
+	}
+	return latest.Compare(current) > 0
+}
+
+func trimVersionPrefix(v string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(v, nightlyTagPrefix), "v")
+}
+
+func segment(segments []int, i int) int {
+	if i < len(segments) {
+		return segments[i]
+	}
+	return 0
+}
+
+// comparePrereleaseIDs compares prerelease identifiers, with numeric
+// identifiers compared numerically and ordered before alphanumeric ones.
+func comparePrereleaseIDs(a, b string) int {
+	an, aErr := strconv.Atoi(a)
+	bn, bErr := strconv.Atoi(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		return compareInts(an, bn)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
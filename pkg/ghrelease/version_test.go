@@ -0,0 +1,64 @@
+package ghrelease
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	for _, x := range []struct {
+		current, latest string
+		newer           bool
+	}{
+		// date based versions
+		{"v20240127.0", "v20240127.1", true},
+		{"v20240127.9", "v20240127.10", true},
+		{"v20240127.1", "v20240127.1", false},
+		{"v20240128.0", "v20240127.3", false},
+		{"v20240127.0", "nightly-20240128", true},
+		{"nightly-20240128", "v20240127.0", false},
+
+		// semantic versions
+		{"v2.0.0", "v2.0.1", true},
+		{"v2.9.0", "v2.10.0", true},
+		{"v2.1", "v2.1.0", false},
+		{"v2.1.0", "v2.1", false},
+		{"v10.0.0", "v9.9.9", false},
+		{"v2.0.0+build.1", "v2.0.0+build.2", false},
+
+		// semantic versions are newer than date based versions
+		{"v20240127.0", "v2.0.0", true},
+		{"v2.0.0", "v20991231.0", false},
+
+		// prereleases
+		{"v2.0.0-rc.1", "v2.0.0", true},
+		{"v2.0.0", "v2.0.0-rc.1", false},
+		{"v2.0.0-alpha", "v2.0.0-alpha.1", true},
+		{"v2.0.0-alpha.1", "v2.0.0-alpha.beta", true},
+		{"v2.0.0-beta.2", "v2.0.0-beta.11", true},
+		{"v2.0.0-beta.11", "v2.0.0-rc.1", true},
+		{"v2.0.0-rc.1", "v1.9.9", false},
+
+		// unparseable versions are compared as strings
+		{"dev", "v20240127.0", false},
+	} {
+		if newer := CompareVersions(x.current, x.latest); newer != x.newer {
+			t.Errorf("CompareVersions(%q, %q): expected %t, got %t", x.current, x.latest, x.newer, newer)
+		}
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	v, err := ParseVersion("v2.1.0-rc.1+build.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Date || len(v.Segments) != 3 || v.Segments[1] != 1 || len(v.Prerelease) != 2 || v.Prerelease[0] != "rc" {
+		t.Fatalf("unexpected version %+v", v)
+	}
+	if v, err := ParseVersion("nightly-20240127"); err != nil || !v.Date {
+		t.Fatalf("expected date based version, got %+v %v", v, err)
+	}
+	for _, s := range []string{"", "v", "dev", "v1..0", "v1.x", "v1.0-", "v1.0-rc..1"} {
+		if _, err := ParseVersion(s); err == nil {
+			t.Errorf("expected error parsing %q", s)
+		}
+	}
+}
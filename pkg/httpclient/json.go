@@ -13,9 +13,11 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/stream"
+	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 )
 
@@ -42,6 +44,49 @@ type Client struct {
 	Host        string
 	HTTP        *http.Client
 	HijackDial  DialFunc
+
+	// RequestID, if set, is sent in the X-Request-ID header of each
+	// request so the request can be correlated with the one which caused
+	// it (see WithRequestID).
+	RequestID string
+
+	// StreamIdleTimeout, if set, is how long streams wait to receive any
+	// data, including keep-alives, before closing with ErrStreamIdle (or
+	// reconnecting in the case of resuming streams), so that a server
+	// which dies without closing the connection does not stall them.
+	StreamIdleTimeout time.Duration
+
+	// ctx, if set, is the context of each request so that requests and
+	// streams are aborted when it is cancelled or its deadline expires
+	// (see WithContext).
+	ctx context.Context
+}
+
+// WithRequestID returns a copy of the client which sends the given request
+// ID with each request.
+func (c *Client) WithRequestID(id string) *Client {
+	copy := *c
+	copy.RequestID = id
+	return &copy
+}
+
+// WithContext returns a copy of the client which makes requests with the
+// given context, so that requests, including streams, are cancelled when
+// the context is done, and carry the request ID and trace context of the
+// request it was derived from (see httphelper.InjectHeaders).
+func (c *Client) WithContext(ctx context.Context) *Client {
+	copy := *c
+	copy.ctx = ctx
+	return &copy
+}
+
+// Context returns the context requests are made with, or
+// context.Background() if none has been set.
+func (c *Client) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
 }
 
 func ToJSON(v interface{}) (io.Reader, error) {
@@ -67,6 +112,9 @@ func (c *Client) prepareReq(method, rawurl string, header http.Header, in interf
 	if err != nil {
 		return nil, err
 	}
+	if c.ctx != nil {
+		req = req.WithContext(c.ctx)
+	}
 	if header == nil {
 		header = make(http.Header)
 	}
@@ -74,6 +122,12 @@ func (c *Client) prepareReq(method, rawurl string, header http.Header, in interf
 		header.Set("Content-Type", "application/json")
 	}
 	req.Header = header
+	if c.RequestID != "" && header.Get(httphelper.RequestIDHeader) == "" {
+		header.Set(httphelper.RequestIDHeader, c.RequestID)
+	}
+	if c.ctx != nil {
+		httphelper.InjectHeaders(c.ctx, header)
+	}
 	if c.Key != "" {
 		req.SetBasicAuth("", c.Key)
 	}
@@ -184,6 +238,12 @@ func (c *Client) Hijack(method, path string, header http.Header, in interface{})
 	if err != nil {
 		return nil, err
 	}
+	if deadline, ok := c.Context().Deadline(); ok {
+		// bound the upgrade with the context deadline, but not the
+		// hijacked connection itself
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
 	clientconn := httputil.NewClientConn(conn, nil)
 	req, err := c.prepareReq(method, c.URL+path, header, in)
 	if err != nil {
@@ -255,9 +315,13 @@ func (c *Client) ResumingStream(method, path string, ch interface{}) (stream.Str
 			"Last-Event-Id": []string{strconv.FormatInt(lastID, 10)},
 		}
 		res, err := c.RawReqWithHTTP(method, path, header, nil, nil, &httpClient)
+		if err != nil && c.ctx != nil && c.ctx.Err() != nil {
+			// don't reconnect once the context is done
+			return nil, c.ctx.Err(), false
+		}
 		return res, err, err != c.ErrNotFound
 	}
-	return ResumingStream(connect, ch)
+	return ResumingStreamWithIdleTimeout(connect, ch, c.StreamIdleTimeout)
 }
 
 func (c *Client) StreamWithHeader(method, path string, header http.Header, in, out interface{}) (stream.Stream, error) {
@@ -266,7 +330,7 @@ func (c *Client) StreamWithHeader(method, path string, header http.Header, in, o
 	if err != nil {
 		return nil, err
 	}
-	return Stream(res, out), nil
+	return StreamWithIdleTimeout(res, out, c.StreamIdleTimeout), nil
 }
 
 func (c *Client) Send(method, path string, in, out interface{}) error {
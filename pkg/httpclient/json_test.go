@@ -0,0 +1,130 @@
+package httpclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/flynn/flynn/pkg/ctxhelper"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"golang.org/x/net/context"
+)
+
+func TestContextRequestID(t *testing.T) {
+	ids := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ids <- req.Header.Get(httphelper.RequestIDHeader)
+	}))
+	defer srv.Close()
+	c := &Client{URL: srv.URL, HTTP: http.DefaultClient}
+
+	// a client with a context sends the request ID it carries
+	ctx := ctxhelper.NewContextRequestID(context.Background(), "ctx-id")
+	if err := c.WithContext(ctx).Get("/", nil); err != nil {
+		t.Fatal(err)
+	}
+	if id := <-ids; id != "ctx-id" {
+		t.Fatalf("expected request ID %q, got %q", "ctx-id", id)
+	}
+
+	// an explicit request ID takes precedence
+	if err := c.WithContext(ctx).WithRequestID("explicit-id").Get("/", nil); err != nil {
+		t.Fatal(err)
+	}
+	if id := <-ids; id != "explicit-id" {
+		t.Fatalf("expected request ID %q, got %q", "explicit-id", id)
+	}
+
+	// no request ID is sent without one
+	if err := c.Get("/", nil); err != nil {
+		t.Fatal(err)
+	}
+	if id := <-ids; id != "" {
+		t.Fatalf("expected no request ID, got %q", id)
+	}
+}
+
+func TestContextCancelAbortsRequest(t *testing.T) {
+	received := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		received <- struct{}{}
+		// hang until the client goes away
+		<-req.Context().Done()
+	}))
+	defer srv.Close()
+	c := &Client{URL: srv.URL, HTTP: http.DefaultClient}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-received
+		cancel()
+	}()
+	errs := make(chan error)
+	go func() { errs <- c.WithContext(ctx).Get("/", nil) }()
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("expected the cancelled request to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the cancelled request to return")
+	}
+
+	// a request with a context which is already done is not sent
+	if err := c.WithContext(ctx).Get("/", nil); err == nil {
+		t.Fatal("expected a request with a done context to fail")
+	}
+	select {
+	case <-received:
+		t.Fatal("expected a request with a done context not to be sent")
+	default:
+	}
+}
+
+func TestResumingStreamStopsOnContextDone(t *testing.T) {
+	var connects int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&connects, 1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"id\":1}\n\n"))
+		w.(http.Flusher).Flush()
+		<-req.Context().Done()
+	}))
+	defer srv.Close()
+	c := &Client{URL: srv.URL, HTTP: http.DefaultClient}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan *testEvent)
+	stream, err := c.WithContext(ctx).ResumingStream("GET", "/", events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	select {
+	case e := <-events:
+		if e.ID != 1 {
+			t.Fatalf("unexpected event %v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+
+	// cancelling the context ends the stream rather than reconnecting
+	cancel()
+	select {
+	case e, ok := <-events:
+		if ok {
+			t.Fatalf("expected the stream to end, got event %v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the stream to end")
+	}
+	if err := stream.Err(); err != context.Canceled {
+		t.Fatalf("expected the stream error to be context.Canceled, got %v", err)
+	}
+	if n := atomic.LoadInt32(&connects); n != 1 {
+		t.Fatalf("expected 1 connection, got %d", n)
+	}
+}
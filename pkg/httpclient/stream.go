@@ -2,10 +2,12 @@ package httpclient
 
 import (
 	"bufio"
+	"errors"
 	"io"
 	"net/http"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/flynn/flynn/pkg/attempt"
@@ -23,6 +25,19 @@ import (
 	Closing the returned `stream.Stream` shuts down the worker.
 */
 func Stream(res *http.Response, outputCh interface{}) stream.Stream {
+	return StreamWithIdleTimeout(res, outputCh, 0)
+}
+
+// ErrStreamIdle is the error of a stream which received no data, including
+// keep-alives, within its idle timeout.
+var ErrStreamIdle = errors.New("httpclient: no data received within stream idle timeout")
+
+// StreamWithIdleTimeout is like Stream but, if idleTimeout is non-zero,
+// closes the stream with ErrStreamIdle when no data is read from the response
+// for that long. Servers using pkg/sse send a keep-alive every
+// sse.KeepAliveInterval, so this detects a server which has died without the
+// connection being closed.
+func StreamWithIdleTimeout(res *http.Response, outputCh interface{}, idleTimeout time.Duration) stream.Stream {
 	stream := stream.New()
 
 	var chanValue reflect.Value
@@ -49,7 +64,13 @@ func Stream(res *http.Response, outputCh interface{}) stream.Stream {
 			res.Body.Close()
 		}()
 
-		r := bufio.NewReader(res.Body)
+		var body io.Reader = res.Body
+		if idleTimeout > 0 {
+			idle := newIdleReader(res.Body, idleTimeout)
+			defer idle.Stop()
+			body = idle
+		}
+		r := bufio.NewReader(body)
 		dec := sse.NewDecoder(r)
 		for {
 			msg := reflect.New(msgType)
@@ -88,7 +109,46 @@ var connectAttempts = attempt.Strategy{
 	Delay: 100 * time.Millisecond,
 }
 
+// idleReader closes the underlying response body if no data is read from it
+// within the timeout, causing the pending Read to return ErrStreamIdle.
+type idleReader struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	expired int32
+}
+
+func newIdleReader(body io.ReadCloser, timeout time.Duration) *idleReader {
+	r := &idleReader{body: body, timeout: timeout}
+	r.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&r.expired, 1)
+		body.Close()
+	})
+	return r
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	if err != nil && atomic.LoadInt32(&r.expired) == 1 {
+		err = ErrStreamIdle
+	}
+	return n, err
+}
+
+func (r *idleReader) Stop() {
+	r.timer.Stop()
+}
+
 func ResumingStream(connect func(int64) (*http.Response, error, bool), outputCh interface{}) (stream.Stream, error) {
+	return ResumingStreamWithIdleTimeout(connect, outputCh, 0)
+}
+
+// ResumingStreamWithIdleTimeout is like ResumingStream but reconnects if no
+// data is received for idleTimeout (see StreamWithIdleTimeout).
+func ResumingStreamWithIdleTimeout(connect func(int64) (*http.Response, error, bool), outputCh interface{}, idleTimeout time.Duration) (stream.Stream, error) {
 	stream := stream.New()
 	firstErr := make(chan error)
 	go func() {
@@ -120,7 +180,7 @@ func ResumingStream(connect func(int64) (*http.Response, error, bool), outputCh
 				return
 			}
 			chanValue := reflect.MakeChan(outValue.Type(), 0)
-			s := Stream(res, chanValue)
+			s := StreamWithIdleTimeout(res, chanValue, idleTimeout)
 		loop:
 			for {
 				chosen, v, ok := reflect.Select([]reflect.SelectCase{
@@ -0,0 +1,55 @@
+package httpclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type testEvent struct {
+	ID int64 `json:"id"`
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	hang := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"id\":1}\n\n"))
+		w.(http.Flusher).Flush()
+		// stop sending data without closing the connection, like a
+		// server which has died
+		<-hang
+	}))
+	defer srv.Close()
+	defer close(hang)
+
+	res, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan *testEvent)
+	stream := StreamWithIdleTimeout(res, events, 100*time.Millisecond)
+	defer stream.Close()
+
+	var received []*testEvent
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e, ok := <-events:
+			if ok {
+				received = append(received, e)
+				continue
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for idle stream to close")
+		}
+		break
+	}
+	if len(received) != 1 || received[0].ID != 1 {
+		t.Fatalf("unexpected events %v", received)
+	}
+	if stream.Err() != ErrStreamIdle {
+		t.Fatalf("expected ErrStreamIdle, got %v", stream.Err())
+	}
+}
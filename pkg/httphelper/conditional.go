@@ -0,0 +1,94 @@
+package httphelper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// gzipMinSize is the minimum size of a response body which ConditionalJSON
+// compresses, as compressing small bodies is not worth the overhead.
+const gzipMinSize = 1024
+
+// ConditionalJSON is like JSON with a 200 status, but sets a weak ETag
+// computed from the encoded body, responds with 304 Not Modified if the
+// request's If-None-Match header matches it, and gzips large bodies if the
+// client accepts it. It is intended for endpoints which clients poll and
+// which often return the same large response.
+func ConditionalJSON(w http.ResponseWriter, req *http.Request, v interface{}) {
+	// Encode nil slices as `[]` instead of `null`
+	if rv := reflect.ValueOf(v); rv.Type().Kind() == reflect.Slice && rv.IsNil() {
+		v = []struct{}{}
+	}
+
+	result, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	sum := sha256.Sum256(result)
+	etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+	header := w.Header()
+	header.Set("ETag", etag)
+	header.Set("Vary", "Accept-Encoding")
+	if etagMatches(req.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	header.Set("Content-Type", "application/json")
+	if len(result) >= gzipMinSize && acceptsGzip(req) {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(result)
+		gz.Close()
+		result = buf.Bytes()
+		header.Set("Content-Encoding", "gzip")
+	}
+	header.Set("Content-Length", strconv.Itoa(len(result)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(result)
+}
+
+// etagMatches reports whether an If-None-Match header matches etag using
+// weak comparison.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding header allows
+// gzip, ignoring it if it has a zero quality value.
+func acceptsGzip(req *http.Request) bool {
+	for _, enc := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(enc, ";")
+		if strings.TrimSpace(params[0]) != "gzip" {
+			continue
+		}
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -40,6 +40,10 @@ const (
 	RatelimitedErrorCode        ErrorCode = "ratelimited"
 	ServiceUnavailableErrorCode ErrorCode = "service_unavailable"
 	RequestBodyTooBigErrorCode  ErrorCode = "request_body_too_big"
+
+	// InsufficientResourcesErrorCode is returned when a request would
+	// reserve more resources than are available
+	InsufficientResourcesErrorCode ErrorCode = "insufficient_resources"
 )
 
 var ErrRequestBodyTooBig = errors.New("httphelper: request body too big")
@@ -58,6 +62,8 @@ var errorResponseCodes = map[ErrorCode]int{
 	UnknownErrorCode:            500,
 	RatelimitedErrorCode:        429,
 	ServiceUnavailableErrorCode: 503,
+
+	InsufficientResourcesErrorCode: 409,
 }
 
 type JSONError struct {
@@ -93,6 +99,12 @@ func IsForbidden(err error) bool {
 	return isJSONErrorWithCode(err, ForbiddenErrorCode)
 }
 
+// IsInsufficientResourcesError reports whether err is a JSONError carrying
+// InsufficientResourcesErrorCode (HTTP 409).
+func IsInsufficientResourcesError(err error) bool {
+	return isJSONErrorWithCode(err, InsufficientResourcesErrorCode)
+}
+
 // IsRetryableError indicates whether a HTTP request can be safely retried.
 func IsRetryableError(err error) bool {
 	e, ok := err.(JSONError)
@@ -144,16 +156,38 @@ func WrapHandler(handler HandlerFunc) httprouter.Handle {
 	}
 }
 
+// ContextInjector wraps handler to inject the request ID and component name
+// into the request context. The request ID is taken from the X-Request-ID
+// header if present so that it is propagated across components, otherwise a
+// new one is generated, and it is returned in the response headers. It is
+// also added to the context of the *http.Request so that handlers which do
+// not use WrapHandler can propagate it. If a Tracer has been set with
+// SetTracer, a span is created for the request.
 func ContextInjector(componentName string, handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		reqID := req.Header.Get("X-Request-ID")
+		reqID := req.Header.Get(RequestIDHeader)
 		if reqID == "" {
 			reqID = random.UUID()
+			req.Header.Set(RequestIDHeader, reqID)
 		}
+		w.Header().Set(RequestIDHeader, reqID)
+		req = req.WithContext(ctxhelper.NewContextRequestID(req.Context(), reqID))
 		ctx := ctxhelper.NewContextRequestID(context.Background(), reqID)
 		ctx = ctxhelper.NewContextComponentName(ctx, componentName)
+		var span Span
+		if t := currentTracer(); t != nil {
+			ctx, span = t.StartSpan(ctx, componentName+" "+req.Method, req)
+		}
 		rw := NewResponseWriter(w, ctx)
 		handler.ServeHTTP(rw, req)
+		if span != nil {
+			status := rw.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			span.SetStatus(status)
+			span.End()
+		}
 	})
 }
 
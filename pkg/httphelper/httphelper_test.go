@@ -0,0 +1,111 @@
+package httphelper
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/flynn/flynn/pkg/ctxhelper"
+	"golang.org/x/net/context"
+)
+
+// requestIDs returns a handler which records the request ID carried by the
+// context of the ResponseWriter and of the request.
+func requestIDs(ctxID, reqID *string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		*ctxID, _ = ctxhelper.RequestIDFromContext(w.(*ResponseWriter).Context())
+		*reqID, _ = ctxhelper.RequestIDFromContext(req.Context())
+	})
+}
+
+func TestContextInjectorGeneratesRequestID(t *testing.T) {
+	var ctxID, reqID string
+	h := ContextInjector("test", requestIDs(&ctxID, &reqID))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	id := rec.Header().Get(RequestIDHeader)
+	if id == "" {
+		t.Fatal("expected a request ID to be generated and returned")
+	}
+	if ctxID != id || reqID != id {
+		t.Fatalf("expected the contexts to carry request ID %q, got %q and %q", id, ctxID, reqID)
+	}
+
+	// each request gets a new ID
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if next := rec.Header().Get(RequestIDHeader); next == "" || next == id {
+		t.Fatalf("expected a new request ID, got %q", next)
+	}
+}
+
+func TestContextInjectorKeepsIncomingRequestID(t *testing.T) {
+	var ctxID, reqID string
+	h := ContextInjector("test", requestIDs(&ctxID, &reqID))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(RequestIDHeader, "incoming-id")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if id := rec.Header().Get(RequestIDHeader); id != "incoming-id" {
+		t.Fatalf("expected the incoming request ID to be echoed, got %q", id)
+	}
+	if ctxID != "incoming-id" || reqID != "incoming-id" {
+		t.Fatalf("expected the contexts to carry the incoming request ID, got %q and %q", ctxID, reqID)
+	}
+}
+
+type spanKey struct{}
+
+type testSpan struct {
+	name   string
+	status int
+	ended  bool
+}
+
+func (s *testSpan) SetStatus(code int) { s.status = code }
+func (s *testSpan) End()               { s.ended = true }
+
+type testTracer struct {
+	spans []*testSpan
+}
+
+func (t *testTracer) StartSpan(ctx context.Context, name string, req *http.Request) (context.Context, Span) {
+	s := &testSpan{name: name}
+	t.spans = append(t.spans, s)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (t *testTracer) Inject(ctx context.Context, h http.Header) {
+	if s, ok := ctx.Value(spanKey{}).(*testSpan); ok {
+		h.Set("Traceparent", s.name)
+	}
+}
+
+func TestContextInjectorTracer(t *testing.T) {
+	tracer := &testTracer{}
+	SetTracer(tracer)
+	defer SetTracer(nil)
+
+	var outgoing http.Header
+	h := ContextInjector("test", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		outgoing = make(http.Header)
+		InjectHeaders(w.(*ResponseWriter).Context(), outgoing)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(RequestIDHeader, "incoming-id")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(tracer.spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(tracer.spans))
+	}
+	span := tracer.spans[0]
+	if span.name != "test GET" || span.status != http.StatusNotFound || !span.ended {
+		t.Fatalf("unexpected span %+v", span)
+	}
+	if outgoing.Get(RequestIDHeader) != "incoming-id" || outgoing.Get("Traceparent") != "test GET" {
+		t.Fatalf("expected the request ID and span to be injected, got %v", outgoing)
+	}
+}
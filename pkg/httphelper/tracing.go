@@ -0,0 +1,91 @@
+package httphelper
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/flynn/flynn/pkg/ctxhelper"
+	"golang.org/x/net/context"
+)
+
+// RequestIDHeader is the header used to propagate a request ID between
+// components so that a request can be followed through the logs of, for
+// example, the controller, a host and an appliance API.
+const RequestIDHeader = "X-Request-ID"
+
+// Span is a unit of work started by a Tracer.
+type Span interface {
+	// SetStatus records the HTTP status code of the response.
+	SetStatus(code int)
+
+	// End completes the span.
+	End()
+}
+
+// Tracer creates spans for requests served by handlers wrapped with
+// ContextInjector. It allows a tracing system such as OpenTelemetry to be
+// plugged in without httphelper depending on it; the returned context
+// should carry the span so that it is available to the handler.
+type Tracer interface {
+	StartSpan(ctx context.Context, name string, req *http.Request) (context.Context, Span)
+
+	// Inject sets the headers which propagate the span carried by ctx, if
+	// any, on an outgoing request.
+	Inject(ctx context.Context, h http.Header)
+}
+
+var tracer atomic.Value // tracerHolder
+
+type tracerHolder struct{ Tracer }
+
+// SetTracer sets the Tracer used to create a span for each request, or
+// disables tracing if t is nil.
+func SetTracer(t Tracer) {
+	tracer.Store(tracerHolder{t})
+}
+
+func currentTracer() Tracer {
+	if h, ok := tracer.Load().(tracerHolder); ok {
+		return h.Tracer
+	}
+	return nil
+}
+
+// SetRequestIDHeader sets the request ID header on h to the request ID
+// carried by ctx, if any, so that an outgoing request is associated with the
+// request currently being served.
+func SetRequestIDHeader(ctx context.Context, h http.Header) {
+	if ctx == nil {
+		return
+	}
+	if id, ok := ctxhelper.RequestIDFromContext(ctx); ok && id != "" {
+		h.Set(RequestIDHeader, id)
+	}
+}
+
+// RequestIDContext returns a context which carries the request ID of a
+// request served by a handler wrapped with ContextInjector, but which unlike
+// req.Context() is not cancelled when the client disconnects, for requests
+// to other components which should complete regardless.
+func RequestIDContext(req *http.Request) context.Context {
+	ctx := context.Background()
+	if id, ok := ctxhelper.RequestIDFromContext(req.Context()); ok {
+		ctx = ctxhelper.NewContextRequestID(ctx, id)
+	}
+	return ctx
+}
+
+// InjectHeaders sets the headers which associate an outgoing request with
+// the request being served, as carried by ctx: the request ID, unless h
+// already has one, and the trace context if a Tracer has been set.
+func InjectHeaders(ctx context.Context, h http.Header) {
+	if ctx == nil {
+		return
+	}
+	if h.Get(RequestIDHeader) == "" {
+		SetRequestIDHeader(ctx, h)
+	}
+	if t := currentTracer(); t != nil {
+		t.Inject(ctx, h)
+	}
+}
@@ -0,0 +1,140 @@
+package installsource
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// SourceTarball indicates an update from a release tarball
+	SourceTarball = "tarball"
+
+	// HistoryFileName is the name of the update history file
+	HistoryFileName = "install-history.json"
+
+	// ResultSuccess and ResultFailed are the results of an update or of
+	// updating a single host
+	ResultSuccess = "success"
+	ResultFailed  = "failed"
+)
+
+// UpdateRecord records an update of the cluster for auditing.
+type UpdateRecord struct {
+	// ID uniquely identifies the record across hosts
+	ID string `json:"id"`
+	// FromVersion is the version of flynn-host which ran the update
+	FromVersion string `json:"from_version"`
+	// ToVersion is the version being updated to
+	ToVersion string `json:"to_version"`
+	// Source is the source of the release ("github" or "tarball")
+	Source string `json:"source"`
+	// Location is where the release came from, either the GitHub
+	// repository or the path of the tarball
+	Location string `json:"location,omitempty"`
+	// Initiator is the user and host which ran the update
+	Initiator string `json:"initiator"`
+	// Resumed is set if the update resumed a previous update
+	Resumed bool `json:"resumed,omitempty"`
+	// Result is either ResultSuccess or ResultFailed, with Error and
+	// FailedStep set if the update failed
+	Result     string `json:"result"`
+	Error      string `json:"error,omitempty"`
+	FailedStep string `json:"failed_step,omitempty"`
+	// Hosts are the results of updating each host, in the order they
+	// were updated
+	Hosts      []*HostUpdateResult `json:"hosts,omitempty"`
+	StartedAt  time.Time           `json:"started_at"`
+	FinishedAt time.Time           `json:"finished_at"`
+}
+
+// Duration returns how long the update took.
+func (r *UpdateRecord) Duration() time.Duration {
+	return r.FinishedAt.Sub(r.StartedAt)
+}
+
+// HostUpdateResult records the result of updating a single host.
+type HostUpdateResult struct {
+	ID         string    `json:"id"`
+	Result     string    `json:"result"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// Duration returns how long updating the host took.
+func (r *HostUpdateResult) Duration() time.Duration {
+	return r.FinishedAt.Sub(r.StartedAt)
+}
+
+// GetHistoryFilePath returns the full path to the install-history.json file
+func GetHistoryFilePath(configDir string) string {
+	if configDir == "" {
+		configDir = DefaultConfigDir
+	}
+	return filepath.Join(configDir, HistoryFileName)
+}
+
+// LoadHistory reads the update history from the config directory, oldest
+// first. Returns an empty history if the file doesn't exist.
+func LoadHistory(configDir string) ([]*UpdateRecord, error) {
+	data, err := os.ReadFile(GetHistoryFilePath(configDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var history []*UpdateRecord
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %s", HistoryFileName, err)
+	}
+	return history, nil
+}
+
+// AppendHistory appends a record to the update history in the config
+// directory. Existing records are never modified, and the file is replaced
+// atomically so an interrupted write cannot lose them.
+func AppendHistory(configDir string, record *UpdateRecord) error {
+	if configDir == "" {
+		configDir = DefaultConfigDir
+	}
+	history, err := LoadHistory(configDir)
+	if err != nil {
+		return err
+	}
+	history = append(history, record)
+
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := GetHistoryFilePath(configDir)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Initiator returns the user running the current process and the host it is
+// running on, preferring the user who invoked sudo.
+func Initiator() string {
+	name := os.Getenv("SUDO_USER")
+	if name == "" {
+		if u, err := user.Current(); err == nil {
+			name = u.Username
+		} else {
+			name = os.Getenv("USER")
+		}
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		return name + "@" + hostname
+	}
+	return name
+}
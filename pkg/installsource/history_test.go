@@ -0,0 +1,59 @@
+package installsource
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "flynn")
+
+	history, err := LoadHistory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 0 {
+		t.Fatalf("expected an empty history, got %d records", len(history))
+	}
+
+	start := time.Now().Add(-time.Minute).Round(0)
+	records := []*UpdateRecord{
+		{ID: "1", FromVersion: "v1", ToVersion: "v2", Source: SourceGitHub, Result: ResultSuccess, StartedAt: start, FinishedAt: start.Add(time.Minute)},
+		{
+			ID:          "2",
+			FromVersion: "v2",
+			ToVersion:   "v3",
+			Source:      SourceTarball,
+			Result:      ResultFailed,
+			Error:       "restart failed",
+			Hosts: []*HostUpdateResult{
+				{ID: "host1", Result: ResultSuccess},
+				{ID: "host2", Result: ResultFailed, Error: "restart failed"},
+			},
+		},
+	}
+	for _, r := range records {
+		if err := AppendHistory(dir, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	history, err = LoadHistory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].ID != "1" || history[1].ID != "2" {
+		t.Fatalf("expected records to be appended in order, got %+v", history)
+	}
+	if d := history[0].Duration(); d != time.Minute {
+		t.Fatalf("expected a duration of 1m, got %s", d)
+	}
+	if len(history[1].Hosts) != 2 || history[1].Hosts[1].Error != "restart failed" {
+		t.Fatalf("unexpected host results %+v", history[1].Hosts)
+	}
+	if _, err := os.Stat(GetHistoryFilePath(dir) + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("expected temporary file to be removed, got %v", err)
+	}
+}
@@ -27,6 +27,9 @@ type InstallSource struct {
 	Repository string `json:"repository"`
 	// Version is the installed version
 	Version string `json:"version"`
+	// Channel is the release channel updates are taken from ("stable",
+	// "beta" or "nightly"), with stable used if empty
+	Channel string `json:"channel,omitempty"`
 	// InstalledAt is when Flynn was installed
 	InstalledAt time.Time `json:"installed_at"`
 }
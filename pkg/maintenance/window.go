@@ -0,0 +1,243 @@
+// Package maintenance implements recurring maintenance windows defined with a
+// cron-like schedule, a duration and an optional time zone.
+//
+// A window is written as:
+//
+//	[TZ=<zone>] <minute> <hour> <day of month> <month> <day of week> <duration>
+//
+// For example "TZ=Europe/London 0 2 * * SAT,SUN 3h" is a three hour window
+// starting at 2am London time every weekend day. Schedule fields accept *,
+// numbers, ranges (1-5), steps (*/15, 1-10/2) and comma separated lists, and
+// the day of week also accepts names (SUN-SAT, 0 or 7 being Sunday). As with
+// cron, if both the day of month and day of week are restricted then a day
+// matching either starts a window. Times are UTC if no zone is given.
+package maintenance
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSearch limits how far ahead Next searches for a matching time.
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+// Window is a recurring maintenance window.
+type Window struct {
+	spec     string
+	loc      *time.Location
+	duration time.Duration
+
+	minute, hour, dom, month, dow uint64
+
+	// domAny and dowAny are true when the day of month or day of week
+	// fields are *, which affects how the two are combined
+	domAny, dowAny bool
+}
+
+var dayNames = map[string]int{"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6}
+
+// Parse parses a maintenance window spec.
+func Parse(spec string) (*Window, error) {
+	fields := strings.Fields(spec)
+	w := &Window{spec: strings.Join(fields, " "), loc: time.UTC}
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "TZ=") {
+		loc, err := time.LoadLocation(strings.TrimPrefix(fields[0], "TZ="))
+		if err != nil {
+			return nil, fmt.Errorf("maintenance: invalid time zone in %q: %s", spec, err)
+		}
+		w.loc = loc
+		fields = fields[1:]
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("maintenance: expected five schedule fields and a duration in %q", spec)
+	}
+
+	var err error
+	if w.minute, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("maintenance: invalid minute in %q: %s", spec, err)
+	}
+	if w.hour, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("maintenance: invalid hour in %q: %s", spec, err)
+	}
+	if w.dom, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("maintenance: invalid day of month in %q: %s", spec, err)
+	}
+	if w.month, err = parseField(fields[3], 1, 12, nil); err != nil {
+		return nil, fmt.Errorf("maintenance: invalid month in %q: %s", spec, err)
+	}
+	if w.dow, err = parseField(fields[4], 0, 7, dayNames); err != nil {
+		return nil, fmt.Errorf("maintenance: invalid day of week in %q: %s", spec, err)
+	}
+	// 7 is an alias for Sunday
+	if w.dow&(1<<7) != 0 {
+		w.dow |= 1
+	}
+	w.domAny = fields[2] == "*"
+	w.dowAny = fields[4] == "*"
+
+	if w.duration, err = time.ParseDuration(fields[5]); err != nil {
+		return nil, fmt.Errorf("maintenance: invalid duration in %q: %s", spec, err)
+	}
+	if w.duration < time.Minute {
+		return nil, fmt.Errorf("maintenance: duration in %q must be at least one minute", spec)
+	}
+	return w, nil
+}
+
+// ParseList parses a semicolon separated list of maintenance window specs.
+func ParseList(s string) ([]*Window, error) {
+	var windows []*Window
+	for _, spec := range strings.Split(s, ";") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		w, err := Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+func parseField(s string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i != -1 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			r := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = parseValue(r[0], min, max, names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if len(r) == 2 {
+				if hi, err = parseValue(r[1], min, max, names); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// a step with a single value applies up to the maximum
+				hi = max
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func parseValue(s string, min, max int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToUpper(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("value %d out of range %d-%d", v, min, max)
+	}
+	return v, nil
+}
+
+// String returns the window spec.
+func (w *Window) String() string {
+	return w.spec
+}
+
+// Duration returns how long the window lasts.
+func (w *Window) Duration() time.Duration {
+	return w.duration
+}
+
+// Next returns the start of the first window after t, or the zero time if the
+// schedule never matches (e.g. the 31st of February).
+func (w *Window) Next(t time.Time) time.Time {
+	// start from the next whole minute in the window's time zone
+	t = t.In(w.loc).Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxSearch)
+	for t.Before(limit) {
+		if w.month&(1<<uint(t.Month())) == 0 {
+			t = advance(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, w.loc))
+			continue
+		}
+		if !w.dayMatches(t) {
+			t = advance(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, w.loc))
+			continue
+		}
+		if w.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			continue
+		}
+		if w.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// advance returns next if it is after t, otherwise the minute after t. Local
+// times which don't exist because of daylight saving changes can normalize
+// to before t, and Next must always make progress.
+func advance(t, next time.Time) time.Time {
+	if next.After(t) {
+		return next
+	}
+	return t.Add(time.Minute)
+}
+
+func (w *Window) dayMatches(t time.Time) bool {
+	dom := w.dom&(1<<uint(t.Day())) != 0
+	dow := w.dow&(1<<uint(t.Weekday())) != 0
+	if w.domAny || w.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Active returns whether t is within the window and if so when it ends.
+func (w *Window) Active(t time.Time) (bool, time.Time) {
+	// windows covering t start at most the duration before it, and later
+	// starts end later if windows overlap
+	var end time.Time
+	for start := w.Next(t.Add(-w.duration - time.Minute)); !start.IsZero() && !start.After(t); start = w.Next(start) {
+		if e := start.Add(w.duration); e.After(t) {
+			end = e
+		}
+	}
+	return !end.IsZero(), end
+}
+
+// Status returns whether t is within any of the windows, when the active
+// windows end and the start of the next window after t. The times are zero
+// if there is no active or next window.
+func Status(windows []*Window, t time.Time) (active bool, until, next time.Time) {
+	for _, w := range windows {
+		if ok, end := w.Active(t); ok {
+			active = true
+			if end.After(until) {
+				until = end
+			}
+		}
+		if n := w.Next(t); !n.IsZero() && (next.IsZero() || n.Before(next)) {
+			next = n
+		}
+	}
+	return active, until, next
+}
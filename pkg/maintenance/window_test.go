@@ -0,0 +1,128 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+)
+
+func mustParse(t *testing.T, spec string) *Window {
+	w, err := Parse(spec)
+	if err != nil {
+		t.Fatalf("error parsing %q: %s", spec, err)
+	}
+	return w
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"0 2 * * *",
+		"0 2 * * * 1h extra",
+		"60 2 * * * 1h",
+		"0 24 * * * 1h",
+		"0 2 0 * * 1h",
+		"0 2 * 13 * 1h",
+		"0 2 * * FOO 1h",
+		"0 5-2 * * * 1h",
+		"*/0 2 * * * 1h",
+		"0 2 * * * soon",
+		"0 2 * * * 30s",
+		"TZ=Nowhere/Special 0 2 * * * 1h",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("expected error parsing %q", spec)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	base := time.Date(2024, time.March, 6, 12, 30, 0, 0, time.UTC) // a Wednesday
+	for _, x := range []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"0 2 * * * 1h", time.Date(2024, time.March, 7, 2, 0, 0, 0, time.UTC)},
+		{"*/15 * * * * 5m", time.Date(2024, time.March, 6, 12, 45, 0, 0, time.UTC)},
+		{"0 3 * * SAT,SUN 2h", time.Date(2024, time.March, 9, 3, 0, 0, 0, time.UTC)},
+		{"0 3 * * 7 2h", time.Date(2024, time.March, 10, 3, 0, 0, 0, time.UTC)},
+		{"30 1 1 * * 1h", time.Date(2024, time.April, 1, 1, 30, 0, 0, time.UTC)},
+		// day of month or day of week when both are restricted
+		{"0 0 15 * FRI 1h", time.Date(2024, time.March, 8, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 * 1h", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 * 1h", time.Time{}},
+	} {
+		if actual := mustParse(t, x.spec).Next(base); !actual.Equal(x.expected) {
+			t.Errorf("%s: expected next %s, got %s", x.spec, x.expected, actual)
+		}
+	}
+}
+
+func TestTimeZone(t *testing.T) {
+	w := mustParse(t, "TZ=America/New_York 0 2 * * * 3h")
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone data not available")
+	}
+
+	// 2am in New York is 7am UTC in winter
+	next := w.Next(time.Date(2024, time.January, 10, 0, 0, 0, 0, time.UTC))
+	if expected := time.Date(2024, time.January, 10, 7, 0, 0, 0, time.UTC); !next.Equal(expected) {
+		t.Fatalf("expected next %s, got %s", expected, next)
+	}
+
+	// 2am doesn't exist on the day clocks go forward, so there is no
+	// window that day
+	next = w.Next(time.Date(2024, time.March, 10, 0, 0, 0, 0, ny))
+	if expected := time.Date(2024, time.March, 11, 2, 0, 0, 0, ny); !next.Equal(expected) {
+		t.Fatalf("expected next %s, got %s", expected, next)
+	}
+}
+
+func TestActive(t *testing.T) {
+	w := mustParse(t, "0 2 * * * 2h")
+	day := time.Date(2024, time.March, 6, 0, 0, 0, 0, time.UTC)
+	for _, x := range []struct {
+		at     time.Duration
+		active bool
+	}{
+		{time.Hour + 59*time.Minute, false},
+		{2 * time.Hour, true},
+		{3*time.Hour + 59*time.Minute + 59*time.Second, true},
+		{4 * time.Hour, false},
+	} {
+		active, until := w.Active(day.Add(x.at))
+		if active != x.active {
+			t.Errorf("at %s: expected active=%t", x.at, x.active)
+		}
+		if active && !until.Equal(day.Add(4*time.Hour)) {
+			t.Errorf("at %s: expected window to end at 04:00, got %s", x.at, until)
+		}
+	}
+
+	// overlapping windows end with the latest start
+	w = mustParse(t, "* * * * * 30m")
+	now := day.Add(30*time.Second + 10*time.Minute)
+	if active, until := w.Active(now); !active || !until.Equal(day.Add(40*time.Minute)) {
+		t.Errorf("expected overlapping window to be active until 00:40, got %t %s", active, until)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	windows, err := ParseList("0 2 * * * 2h; 0 22 * * * 1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2024, time.March, 6, 0, 0, 0, 0, time.UTC)
+
+	active, until, next := Status(windows, day.Add(3*time.Hour))
+	if !active || !until.Equal(day.Add(4*time.Hour)) || !next.Equal(day.Add(22*time.Hour)) {
+		t.Fatalf("unexpected status %t %s %s", active, until, next)
+	}
+	active, _, next = Status(windows, day.Add(22*time.Hour+30*time.Minute))
+	if !active || !next.Equal(day.Add(26*time.Hour)) {
+		t.Fatalf("unexpected status %t %s", active, next)
+	}
+	if active, until, next := Status(nil, day); active || !until.IsZero() || !next.IsZero() {
+		t.Fatal("expected no status without windows")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"net/url"
 
 	hh "github.com/flynn/flynn/pkg/httphelper"
+	"golang.org/x/net/context"
 )
 
 type Resource struct {
@@ -15,8 +16,16 @@ type Resource struct {
 	Env map[string]string `json:"env"`
 }
 
-func Provision(uri string, config []byte) (*Resource, error) {
-	res, err := hh.RetryClient.Post(uri, "application/json", bytes.NewBuffer(config))
+// Provision provisions a resource from the provider at uri. The request ID
+// and trace context carried by ctx, if any, are sent with the request.
+func Provision(ctx context.Context, uri string, config []byte) (*Resource, error) {
+	req, err := http.NewRequest("POST", uri, bytes.NewBuffer(config))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	hh.InjectHeaders(ctx, req.Header)
+	res, err := hh.RetryClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -32,12 +41,16 @@ func Provision(uri string, config []byte) (*Resource, error) {
 	return resource, nil
 }
 
-func Deprovision(uri, id string) error {
+// Deprovision removes the resource with the given ID from the provider at
+// uri. The request ID and trace context carried by ctx, if any, are sent
+// with the request.
+func Deprovision(ctx context.Context, uri, id string) error {
 	path := fmt.Sprintf("%s?id=%s", uri, url.QueryEscape(id))
 	req, err := http.NewRequest("DELETE", path, nil)
 	if err != nil {
 		return err
 	}
+	hh.InjectHeaders(ctx, req.Header)
 	res, err := hh.RetryClient.Do(req)
 	if err != nil {
 		return err
@@ -16,7 +16,11 @@ var h = newHandler()
 type handler struct {
 	active atomic.Value
 	mtx    sync.Mutex
+	hooks  []*Hook
 	stack  []func()
+
+	// log is used to log slow hooks, stderr if nil
+	log *log.Logger
 }
 
 func newHandler() *handler {
@@ -62,6 +66,7 @@ func (h *handler) wait() {
 func (h *handler) exit(err error, code int, serious interface{}) {
 	h.mtx.Lock()
 	h.active.Store(true)
+	h.runHooks()
 	for i := len(h.stack) - 1; i >= 0; i-- {
 		h.stack[i]()
 	}
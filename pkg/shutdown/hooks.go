@@ -0,0 +1,119 @@
+package shutdown
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+var (
+	// DefaultHookTimeout is how long a hook without a timeout is waited
+	// for before the next hook is run.
+	DefaultHookTimeout = 30 * time.Second
+
+	// SlowHookThreshold is how long a hook can take before it is logged
+	// as slow.
+	SlowHookThreshold = time.Second
+)
+
+// Hook is a named function run on exit after the hooks it depends on, for
+// example to deregister from discoverd, then stop serving HTTP requests,
+// then close databases.
+//
+// Hooks run before the functions passed to BeforeExit, so that resources
+// closed by those functions are still available to hooks.
+type Hook struct {
+	// Name identifies the hook to hooks which depend on it. Hooks
+	// depending on a name shared by several hooks run after all of them.
+	Name string
+
+	// After is the names of the hooks which must finish before this hook
+	// runs. Names which have not been registered are ignored.
+	After []string
+
+	// Timeout is how long to wait for the hook to finish before moving on
+	// to the next hook, DefaultHookTimeout if zero. A hook which times out
+	// keeps running until the process exits.
+	Timeout time.Duration
+
+	// Func is the function to run.
+	Func func()
+}
+
+// AddHook registers a hook to run on exit.
+func AddHook(hook Hook) {
+	h.mtx.Lock()
+	h.hooks = append(h.hooks, &hook)
+	h.mtx.Unlock()
+}
+
+// runHooks runs the hooks in dependency order, with hooks which are ready at
+// the same time run in the order they were registered. Hooks which depend on
+// each other in a cycle are run last in the order they were registered.
+func (h *handler) runHooks() {
+	if len(h.hooks) == 0 {
+		return
+	}
+	l := h.log
+	if l == nil {
+		l = log.New(os.Stderr, "", log.Lmicroseconds)
+	}
+
+	// pending is the number of hooks with each name yet to run
+	pending := make(map[string]int, len(h.hooks))
+	for _, hook := range h.hooks {
+		pending[hook.Name]++
+	}
+	ready := func(hook *Hook) bool {
+		for _, name := range hook.After {
+			if name != hook.Name && pending[name] > 0 {
+				return false
+			}
+		}
+		return true
+	}
+
+	remaining := h.hooks
+	for len(remaining) > 0 {
+		var next []*Hook
+		for _, hook := range remaining {
+			if !ready(hook) {
+				next = append(next, hook)
+				continue
+			}
+			runHook(hook, l)
+			pending[hook.Name]--
+		}
+		if len(next) == len(remaining) {
+			for _, hook := range next {
+				l.Printf("shutdown hook %q has circular dependencies %v, running it anyway", hook.Name, hook.After)
+				runHook(hook, l)
+			}
+			return
+		}
+		remaining = next
+	}
+}
+
+// runHook runs a hook, waiting for it to finish or time out and logging if
+// it is slow.
+func runHook(hook *Hook, l *log.Logger) {
+	timeout := hook.Timeout
+	if timeout == 0 {
+		timeout = DefaultHookTimeout
+	}
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		hook.Func()
+	}()
+	select {
+	case <-done:
+		if d := time.Since(start); d > SlowHookThreshold {
+			l.Printf("shutdown hook %q was slow, took %s", hook.Name, d)
+		}
+	case <-time.After(timeout):
+		l.Printf("shutdown hook %q timed out after %s, continuing shutdown", hook.Name, timeout)
+	}
+}
@@ -0,0 +1,59 @@
+package shutdown
+
+import (
+	"bytes"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunHooks(t *testing.T) {
+	var buf bytes.Buffer
+	h := &handler{log: log.New(&buf, "", 0)}
+	var ran []string
+	add := func(name string, after ...string) {
+		h.hooks = append(h.hooks, &Hook{Name: name, After: after, Func: func() { ran = append(ran, name) }})
+	}
+	add("close-db", "drain-http")
+	add("drain-http", "deregister", "unknown")
+	add("flush")
+	add("deregister")
+	add("cycle-a", "cycle-b")
+	add("cycle-b", "cycle-a")
+
+	h.runHooks()
+	expected := []string{"flush", "deregister", "drain-http", "close-db", "cycle-a", "cycle-b"}
+	if !reflect.DeepEqual(ran, expected) {
+		t.Fatalf("expected hooks to run in order %v, got %v", expected, ran)
+	}
+	if !strings.Contains(buf.String(), `"cycle-a" has circular dependencies`) {
+		t.Fatalf("expected circular dependencies to be logged, got %q", buf.String())
+	}
+}
+
+func TestRunHooksTimeout(t *testing.T) {
+	defer func(d time.Duration) { SlowHookThreshold = d }(SlowHookThreshold)
+	SlowHookThreshold = 10 * time.Millisecond
+
+	var buf bytes.Buffer
+	h := &handler{log: log.New(&buf, "", 0)}
+	block := make(chan struct{})
+	defer close(block)
+	var ran bool
+	h.hooks = []*Hook{
+		{Name: "stuck", Timeout: 20 * time.Millisecond, Func: func() { <-block }},
+		{Name: "slow", Func: func() { time.Sleep(20 * time.Millisecond) }},
+		{Name: "next", After: []string{"stuck"}, Func: func() { ran = true }},
+	}
+
+	h.runHooks()
+	if !ran {
+		t.Fatal("expected hooks after a timed out hook to run")
+	}
+	out := buf.String()
+	if !strings.Contains(out, `"stuck" timed out`) || !strings.Contains(out, `"slow" was slow`) {
+		t.Fatalf("expected timed out and slow hooks to be logged, got %q", out)
+	}
+}
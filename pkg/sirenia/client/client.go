@@ -38,6 +38,40 @@ type DatabaseInfo struct {
 	XLog             string              `json:"xlog"`
 	UserExists       bool                `json:"user_exists"`
 	ReadWrite        bool                `json:"read_write"`
+
+	// ReplicaSet is the health of the database's replica set, for
+	// databases which replicate using a MongoDB replica set
+	ReplicaSet *ReplicaSetStatus `json:"replica_set,omitempty"`
+}
+
+// ReplicaSetStatus is the health of a replica set as seen by one of its
+// members.
+type ReplicaSetStatus struct {
+	Name         string              `json:"name"`
+	Members      []*ReplicaSetMember `json:"members"`
+	LastElection *time.Time          `json:"last_election,omitempty"`
+
+	// OplogWindow is the number of seconds of operations held in the oplog,
+	// which is how far a member can fall behind before needing a full
+	// resync.
+	OplogWindow int64 `json:"oplog_window"`
+
+	// OplogWindowRemaining is the oplog window less the lag of the member
+	// furthest behind the primary.
+	OplogWindowRemaining int64 `json:"oplog_window_remaining"`
+
+	// OplogWarning is set by the primary when the oplog window is below
+	// the configured minimum.
+	OplogWarning string `json:"oplog_warning,omitempty"`
+}
+
+type ReplicaSetMember struct {
+	Name          string     `json:"name"`
+	State         string     `json:"state"`
+	Healthy       bool       `json:"healthy"`
+	Optime        time.Time  `json:"optime"`
+	Lag           int64      `json:"lag"`
+	LastHeartbeat *time.Time `json:"last_heartbeat,omitempty"`
 }
 
 type Status struct {
@@ -102,6 +136,36 @@ func SyncedWith(expected *discoverd.Instance, idKey string) func(*Status) bool {
 	}
 }
 
+// WaitForRemoval waits for inst to be removed from the peer's cluster state.
+func (c *Client) WaitForRemoval(inst *discoverd.Instance, idKey string, timeout time.Duration) error {
+	return c.waitFor(RemovedFrom(inst, idKey), timeout)
+}
+
+// RemovedFrom returns a predicate that reports whether inst is no longer a
+// member (primary, sync or async) of the cluster state. As with SyncedWith,
+// appliance Meta identity is compared when idKey is set.
+func RemovedFrom(inst *discoverd.Instance, idKey string) func(*Status) bool {
+	return func(status *Status) bool {
+		if status.Peer == nil || status.Peer.State == nil {
+			return false
+		}
+		s := status.Peer.State
+		for _, m := range append([]*discoverd.Instance{s.Primary, s.Sync}, s.Async...) {
+			if m == nil {
+				continue
+			}
+			if idKey != "" && inst.Meta[idKey] != "" && m.Meta != nil {
+				if m.Meta[idKey] == inst.Meta[idKey] {
+					return false
+				}
+			} else if m.ID == inst.ID {
+				return false
+			}
+		}
+		return true
+	}
+}
+
 func (c *Client) WaitForReadWrite(timeout time.Duration) error {
 	return c.waitFor(func(status *Status) bool {
 		return status.Database != nil && status.Database.ReadWrite
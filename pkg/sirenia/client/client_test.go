@@ -12,6 +12,7 @@ import (
 	"time"
 
 	discoverd "github.com/flynn/flynn/discoverd/client"
+	"github.com/flynn/flynn/pkg/sirenia/state"
 )
 
 func mkInst(addr, id string) *discoverd.Instance {
@@ -110,3 +111,39 @@ func TestWaitForReadWriteEventually(t *testing.T) {
 		t.Fatalf("expected multiple status polls before read-write, got %d", calls)
 	}
 }
+
+func TestRemovedFrom(t *testing.T) {
+	primary := mkInst("10.0.0.1:5432", "primary")
+	sync := mkInst("10.0.0.2:5432", "sync")
+	async1 := mkInst("10.0.0.3:5432", "async-1")
+	async2 := mkInst("10.0.0.4:5432", "async-2")
+
+	status := func(async ...*discoverd.Instance) *Status {
+		return &Status{Peer: &state.PeerInfo{State: &state.State{
+			Primary: primary,
+			Sync:    sync,
+			Async:   async,
+		}}}
+	}
+
+	check := RemovedFrom(async2, "POSTGRES_ID")
+	if check(status(async1, async2)) {
+		t.Fatal("expected false when the instance is an async")
+	}
+	if !check(status(async1)) {
+		t.Fatal("expected true when the instance is no longer an async")
+	}
+	if check(&Status{}) {
+		t.Fatal("expected false when the state is unknown")
+	}
+
+	// a replacement at the same address with a different identity is not
+	// the removed instance
+	replacement := mkInst("10.0.0.4:5432", "async-3")
+	if !check(status(async1, replacement)) {
+		t.Fatal("expected true when only a replacement with the same discoverd ID remains")
+	}
+	if RemovedFrom(async2, "")(status(async1, replacement)) {
+		t.Fatal("expected false when discoverd IDs match and idKey is empty")
+	}
+}
@@ -2,6 +2,7 @@ package scale
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -9,10 +10,12 @@ import (
 	"github.com/flynn/flynn/pkg/dialer"
 	sirenia "github.com/flynn/flynn/pkg/sirenia/client"
 	"github.com/inconshreveable/log15"
+	"golang.org/x/net/context"
 )
 
-// ScaleUp scales up a dormant Sirenia cluster
-func ScaleUp(app, controllerKey, serviceAddr, procName, singleton string, logger log15.Logger) error {
+// ScaleUp scales up a dormant Sirenia cluster. Requests to the controller
+// carry the request ID of ctx and are aborted once it is done.
+func ScaleUp(ctx context.Context, app, controllerKey, serviceAddr, procName, singleton string, logger log15.Logger) error {
 	logger = logger.New("fn", "ScaleUp")
 
 	// use an explicit HTTP client which doesn't use a retry dialer so we
@@ -38,6 +41,7 @@ func ScaleUp(app, controllerKey, serviceAddr, procName, singleton string, logger
 		logger.Error("controller client error", "err", err)
 		return err
 	}
+	client = controller.WithContext(ctx, client)
 
 	// Retrieve the app release.
 	logger.Info("retrieving app release", "app", app)
@@ -99,8 +103,9 @@ func ScaleUp(app, controllerKey, serviceAddr, procName, singleton string, logger
 
 // CheckScale examines sirenia cluster formation to check if cluster
 // has been scaled up yet.
-// Returns true if scaled, false if not.
-func CheckScale(app, controllerKey, procName string, logger log15.Logger) (bool, error) {
+// Returns true if scaled, false if not. Requests to the controller carry the
+// request ID of ctx and are aborted once it is done.
+func CheckScale(ctx context.Context, app, controllerKey, procName string, logger log15.Logger) (bool, error) {
 	logger = logger.New("fn", "CheckScale")
 	// Connect to controller.
 	logger.Info("connecting to controller")
@@ -109,6 +114,7 @@ func CheckScale(app, controllerKey, procName string, logger log15.Logger) (bool,
 		logger.Error("controller client error", "err", err)
 		return false, err
 	}
+	client = controller.WithContext(ctx, client)
 
 	// Retrieve app release.
 	logger.Info("retrieving app release", "app", app)
@@ -140,3 +146,109 @@ func CheckScale(app, controllerKey, procName string, logger log15.Logger) (bool,
 	return true, nil
 
 }
+
+// MinClusterSize is the smallest size a non-singleton Sirenia cluster can be
+// scaled down to: a primary, a sync and an async to take over as the sync
+// should either fail.
+const MinClusterSize = 3
+
+// ScaleDown scales a Sirenia cluster down to count members.
+//
+// Members are removed one at a time from the tail of the async chain. Each
+// member is stopped and deregistered via its sirenia API, and only once the
+// primary has removed it from the cluster state is its job killed and the
+// formation reduced, so that the scheduler never stops the primary or sync.
+// Requests to the controller carry the request ID of ctx and are aborted once
+// it is done.
+func ScaleDown(ctx context.Context, app, controllerKey, serviceAddr, procName string, count int, logger log15.Logger) error {
+	logger = logger.New("fn", "ScaleDown", "count", count)
+
+	if count < MinClusterSize {
+		return fmt.Errorf("cannot scale below %d %s processes", MinClusterSize, procName)
+	}
+
+	logger.Info("connecting to controller")
+	client, err := controller.NewClient("", controllerKey)
+	if err != nil {
+		logger.Error("controller client error", "err", err)
+		return err
+	}
+	client = controller.WithContext(ctx, client)
+
+	logger.Info("retrieving app release", "app", app)
+	release, err := client.GetAppRelease(app)
+	if err != nil {
+		logger.Error("get release error", "app", app, "err", err)
+		return err
+	}
+
+	logger.Info("retrieving formation", "app", app, "release_id", release.ID)
+	formation, err := client.GetFormation(app, release.ID)
+	if err != nil {
+		logger.Error("formation error", "app", app, "release_id", release.ID, "err", err)
+		return err
+	}
+
+	idKey := sirenia.ProcessIDKey(procName)
+	leader := sirenia.NewClient(serviceAddr)
+	for formation.Processes[procName] > count {
+		status, err := leader.Status()
+		if err != nil {
+			logger.Error("error checking status", "host", serviceAddr, "err", err)
+			return err
+		}
+		if status.Peer == nil || status.Peer.State == nil {
+			return errors.New("sirenia cluster state is unknown")
+		}
+		state := status.Peer.State
+		if state.Singleton {
+			return errors.New("cannot scale down a singleton sirenia cluster")
+		}
+		if len(state.Async) < 2 {
+			return errors.New("cannot scale down, sirenia cluster has fewer than two async members")
+		}
+
+		// remove the tail async as no other member replicates from it
+		inst := state.Async[len(state.Async)-1]
+		jobID := inst.Meta["FLYNN_JOB_ID"]
+		log := logger.New("addr", inst.Addr, "job_id", jobID)
+		if jobID == "" {
+			log.Error("async member has no job ID")
+			return fmt.Errorf("async member %s has no job ID", inst.Addr)
+		}
+
+		log.Info("stopping async member")
+		if err := sirenia.NewClient(inst.Addr).Stop(); err != nil {
+			log.Error("error stopping async member", "err", err)
+			return err
+		}
+		log.Info("waiting for async member to be removed from cluster state")
+		if err := leader.WaitForRemoval(inst, idKey, 5*time.Minute); err != nil {
+			log.Error("error waiting for async member removal", "err", err)
+			return err
+		}
+
+		// kill the job before reducing the formation so the scheduler
+		// stops the (pending or starting) replacement rather than the
+		// most recently started member
+		log.Info("killing job")
+		if err := client.DeleteJob(app, jobID); err != nil && err != controller.ErrNotFound {
+			log.Error("error killing job", "err", err)
+			return err
+		}
+		processes := make(map[string]int, len(formation.Processes))
+		for k, v := range formation.Processes {
+			processes[k] = v
+		}
+		processes[procName]--
+		formation.Processes = processes
+		log.Info("updating formation", "processes", processes[procName])
+		if err := client.PutFormation(formation); err != nil {
+			log.Error("put formation error", "err", err)
+			return err
+		}
+	}
+
+	logger.Info("scale down complete")
+	return nil
+}
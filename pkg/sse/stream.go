@@ -12,6 +12,10 @@ import (
 	log "github.com/inconshreveable/log15"
 )
 
+// KeepAliveInterval is how often a keep-alive is sent on an otherwise idle
+// stream.
+const KeepAliveInterval = 30 * time.Second
+
 type identifier interface {
 	EventID() string
 }
@@ -75,7 +79,7 @@ func (s *Stream) Serve() {
 				},
 				{
 					Dir:  reflect.SelectRecv,
-					Chan: reflect.ValueOf(time.After(30 * time.Second)),
+					Chan: reflect.ValueOf(time.After(KeepAliveInterval)),
 				},
 				{
 					Dir:  reflect.SelectRecv,
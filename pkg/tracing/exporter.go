@@ -0,0 +1,305 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/inconshreveable/log15"
+)
+
+const (
+	// exportBatchSize is the number of spans which triggers an export
+	// before the export interval.
+	exportBatchSize = 100
+
+	// exportInterval is how often queued spans are exported.
+	exportInterval = 5 * time.Second
+
+	// maxQueuedSpans is the maximum number of spans held in memory, spans
+	// are dropped if the collector can't keep up.
+	maxQueuedSpans = 2048
+)
+
+var (
+	exporterMtx sync.Mutex
+	exporter    *otlpExporter
+)
+
+func currentExporter() *otlpExporter {
+	exporterMtx.Lock()
+	defer exporterMtx.Unlock()
+	return exporter
+}
+
+// Init enables exporting spans for the given service if an OTLP endpoint is
+// configured in the environment, returning whether it is. The service name
+// can be overridden with OTEL_SERVICE_NAME.
+//
+// Once enabled, a span is also recorded for each request served by handlers
+// wrapped with httphelper.ContextInjector, and requests made with a context
+// carrying a span propagate it in the traceparent header.
+func Init(service string) bool {
+	url := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if url == "" {
+		endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if endpoint == "" {
+			return false
+		}
+		url = strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	}
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		service = name
+	}
+	e := &otlpExporter{
+		url:     url,
+		service: service,
+		headers: parseHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")),
+		client:  &http.Client{Timeout: 10 * time.Second},
+		log:     log15.New("component", "tracing", "service", service),
+		flush:   make(chan chan struct{}),
+	}
+	exporterMtx.Lock()
+	prev := exporter
+	exporter = e
+	exporterMtx.Unlock()
+	if prev != nil {
+		prev.Flush()
+	}
+	go e.run()
+	httphelper.SetTracer(httpTracer{})
+	return true
+}
+
+// Enabled returns whether spans are being exported.
+func Enabled() bool {
+	return currentExporter() != nil
+}
+
+// Flush exports any queued spans, and should be called before exiting.
+func Flush() {
+	if e := currentExporter(); e != nil {
+		e.Flush()
+	}
+}
+
+// parseHeaders parses headers in the "key1=value1,key2=value2" format
+// used by OTEL_EXPORTER_OTLP_HEADERS.
+func parseHeaders(s string) http.Header {
+	h := make(http.Header)
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		h.Set(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	return h
+}
+
+type otlpExporter struct {
+	url     string
+	service string
+	headers http.Header
+	client  *http.Client
+	log     log15.Logger
+
+	mtx   sync.Mutex
+	spans []otlpSpan
+
+	flush chan chan struct{}
+}
+
+func (e *otlpExporter) add(s *Span, end time.Time) {
+	span := newOTLPSpan(s, end)
+	e.mtx.Lock()
+	if len(e.spans) >= maxQueuedSpans {
+		e.mtx.Unlock()
+		e.log.Warn("dropping span, export queue is full", "span", s.name)
+		return
+	}
+	e.spans = append(e.spans, span)
+	full := len(e.spans) >= exportBatchSize
+	e.mtx.Unlock()
+	if full {
+		go e.Flush()
+	}
+}
+
+func (e *otlpExporter) Flush() {
+	done := make(chan struct{})
+	e.flush <- done
+	<-done
+}
+
+func (e *otlpExporter) run() {
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.export()
+		case done := <-e.flush:
+			e.export()
+			close(done)
+		}
+	}
+}
+
+func (e *otlpExporter) export() {
+	e.mtx.Lock()
+	spans := e.spans
+	e.spans = nil
+	e.mtx.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	req := otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttr{newOTLPAttr("service.name", e.service)}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/flynn/flynn/pkg/tracing"},
+			Spans: spans,
+		}},
+	}}}
+	if err := e.post(&req); err != nil {
+		e.log.Error("error exporting spans", "count", len(spans), "err", err)
+	}
+}
+
+func (e *otlpExporter) post(data *otlpRequest) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range e.headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d from %s", res.StatusCode, e.url)
+	}
+	return nil
+}
+
+// The following types are the OTLP/HTTP JSON encoding of an export request,
+// see https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttr `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr `json:"attributes,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string        `json:"key"`
+	Value otlpAttrValue `json:"value"`
+}
+
+type otlpAttrValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusOK         = 1
+	otlpStatusError      = 2
+)
+
+func newOTLPSpan(s *Span, end time.Time) otlpSpan {
+	span := otlpSpan{
+		TraceID:           hex.EncodeToString(s.ctx.TraceID[:]),
+		SpanID:            hex.EncodeToString(s.ctx.SpanID[:]),
+		Name:              s.name,
+		Kind:              otlpSpanKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+		Status:            otlpStatus{Code: otlpStatusOK},
+	}
+	if s.parent.IsValid() {
+		span.ParentSpanID = hex.EncodeToString(s.parent.SpanID[:])
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for k, v := range s.attrs {
+		span.Attributes = append(span.Attributes, newOTLPAttr(k, v))
+	}
+	if s.err != nil {
+		span.Status = otlpStatus{Code: otlpStatusError, Message: s.err.Error()}
+	}
+	return span
+}
+
+func newOTLPAttr(key string, value interface{}) otlpAttr {
+	a := otlpAttr{Key: key}
+	switch v := value.(type) {
+	case string:
+		a.Value.StringValue = &v
+	case bool:
+		a.Value.BoolValue = &v
+	case int:
+		s := strconv.FormatInt(int64(v), 10)
+		a.Value.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		a.Value.IntValue = &s
+	case float64:
+		a.Value.DoubleValue = &v
+	default:
+		s := fmt.Sprint(v)
+		a.Value.StringValue = &s
+	}
+	return a
+}
@@ -0,0 +1,66 @@
+package tracing
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/flynn/flynn/pkg/httphelper"
+	"golang.org/x/net/context"
+)
+
+// TraceparentHeader is the W3C header used to propagate the trace context of
+// HTTP requests between components.
+const TraceparentHeader = "traceparent"
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of ctx which carries span, so that requests
+// made with the context are part of its trace (see
+// httphelper.InjectHeaders).
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span carried by ctx, or nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// httpTracer is registered with httphelper by Init so that a span is
+// recorded for each request served by handlers wrapped with
+// httphelper.ContextInjector, continuing the trace of the traceparent header
+// if there is one, and so that requests made with a context carrying a span
+// propagate it.
+type httpTracer struct{}
+
+func (httpTracer) StartSpan(ctx context.Context, name string, req *http.Request) (context.Context, httphelper.Span) {
+	parent, _ := ParseTraceparent(req.Header.Get(TraceparentHeader))
+	span := Start(name, parent)
+	span.SetAttr("http.method", req.Method)
+	span.SetAttr("http.target", req.URL.Path)
+	if id := req.Header.Get(httphelper.RequestIDHeader); id != "" {
+		span.SetAttr("request.id", id)
+	}
+	return ContextWithSpan(ctx, span), httpSpan{span}
+}
+
+func (httpTracer) Inject(ctx context.Context, h http.Header) {
+	if span := SpanFromContext(ctx); span != nil {
+		h.Set(TraceparentHeader, span.Context().String())
+	}
+}
+
+type httpSpan struct {
+	*Span
+}
+
+func (s httpSpan) SetStatus(code int) {
+	s.SetAttr("http.status_code", code)
+	if code >= 500 {
+		s.SetError(fmt.Errorf("%d %s", code, http.StatusText(code)))
+	}
+}
@@ -0,0 +1,53 @@
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/flynn/flynn/pkg/httphelper"
+	"golang.org/x/net/context"
+)
+
+func TestHTTPTracer(t *testing.T) {
+	httphelper.SetTracer(httpTracer{})
+	defer httphelper.SetTracer(nil)
+
+	// a request with a traceparent header continues its trace, and
+	// requests made while serving it propagate the request's span
+	parent := Start("caller", SpanContext{})
+	var span *Span
+	outgoing := make(http.Header)
+	h := httphelper.ContextInjector("test", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := w.(*httphelper.ResponseWriter).Context()
+		span = SpanFromContext(ctx)
+		httphelper.InjectHeaders(ctx, outgoing)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	req := httptest.NewRequest("POST", "/jobs", nil)
+	req.Header.Set(TraceparentHeader, parent.Context().String())
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if span == nil {
+		t.Fatal("expected the request context to carry a span")
+	}
+	if span.parent != parent.Context() || span.Context().TraceID != parent.Context().TraceID {
+		t.Fatalf("expected the span to be a child of %s, got parent %s", parent.Context(), span.parent)
+	}
+	if span.attrs["http.status_code"] != http.StatusInternalServerError || span.err == nil || !span.ended {
+		t.Fatalf("expected the span to record the failed response, got %+v", span.attrs)
+	}
+	if outgoing.Get(TraceparentHeader) != span.Context().String() {
+		t.Fatalf("expected the span to be propagated, got %q", outgoing.Get(TraceparentHeader))
+	}
+	if outgoing.Get(httphelper.RequestIDHeader) == "" {
+		t.Fatal("expected the request ID to be propagated")
+	}
+
+	// a context without a span propagates nothing
+	outgoing = make(http.Header)
+	httpTracer{}.Inject(context.Background(), outgoing)
+	if len(outgoing) != 0 {
+		t.Fatalf("expected no headers, got %v", outgoing)
+	}
+}
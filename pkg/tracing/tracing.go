@@ -0,0 +1,196 @@
+// Package tracing records trace spans for operations which cross component
+// boundaries, such as deploys and job launches, and exports them to an
+// OpenTelemetry collector using the OTLP/HTTP JSON protocol.
+//
+// Exporting is enabled by calling Init with the OTEL_EXPORTER_OTLP_ENDPOINT
+// (or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT) environment variable set, otherwise
+// spans are still created so that instrumented code need not check, but are
+// discarded when ended and their context is not propagated to other
+// components (see Traceparent).
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetadataKey is the job metadata key used to propagate the trace context
+// of a job launch from the scheduler to the host.
+const MetadataKey = "flynn-traceparent"
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+// IsValid returns whether both the trace and span IDs are set.
+func (c SpanContext) IsValid() bool {
+	return c.TraceID != [16]byte{} && c.SpanID != [8]byte{}
+}
+
+// String returns the context formatted as a W3C traceparent header value.
+func (c SpanContext) String() string {
+	return fmt.Sprintf("00-%x-%x-01", c.TraceID, c.SpanID)
+}
+
+// ParseTraceparent parses a W3C traceparent header value, returning false if
+// it is not valid.
+func ParseTraceparent(s string) (SpanContext, bool) {
+	var c SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return c, false
+	}
+	if n, err := hex.Decode(c.TraceID[:], []byte(parts[1])); err != nil || n != len(c.TraceID) || len(parts[1]) != 32 {
+		return SpanContext{}, false
+	}
+	if n, err := hex.Decode(c.SpanID[:], []byte(parts[2])); err != nil || n != len(c.SpanID) || len(parts[2]) != 16 {
+		return SpanContext{}, false
+	}
+	return c, c.IsValid()
+}
+
+// Traceparent returns the context of span formatted as a W3C traceparent to
+// be passed to another component, or an empty string if spans are not being
+// exported, so that trace context is only propagated when it is useful.
+func Traceparent(span *Span) string {
+	if !Enabled() || span == nil {
+		return ""
+	}
+	return span.Context().String()
+}
+
+// Span is an operation being traced. A nil *Span is valid and does nothing,
+// which simplifies instrumenting optional code paths.
+type Span struct {
+	name   string
+	ctx    SpanContext
+	parent SpanContext
+	start  time.Time
+
+	mtx   sync.Mutex
+	attrs map[string]interface{}
+	err   error
+	ended bool
+}
+
+// Start starts a span with the given name. If parent is valid the span is
+// part of the parent's trace, otherwise a new trace is started with a random
+// trace ID.
+func Start(name string, parent SpanContext) *Span {
+	ctx := SpanContext{TraceID: parent.TraceID}
+	if !parent.IsValid() {
+		ctx.TraceID = randomTraceID()
+	}
+	ctx.SpanID = randomSpanID()
+	return &Span{
+		name:   name,
+		ctx:    ctx,
+		parent: parent,
+		start:  time.Now(),
+		attrs:  make(map[string]interface{}),
+	}
+}
+
+// StartFromMetadata starts a span whose parent is the trace context stored
+// in the given job metadata, returning nil if there is none.
+func StartFromMetadata(name string, metadata map[string]string) *Span {
+	parent, ok := ParseTraceparent(metadata[MetadataKey])
+	if !ok {
+		return nil
+	}
+	return Start(name, parent)
+}
+
+// Child starts a child span with the given name, returning nil if s is nil
+// so that spans are only recorded for traced operations.
+func (s *Span) Child(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	return Start(name, s.ctx)
+}
+
+// Context returns the span's context, which should be used as the parent
+// of any child spans.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.ctx
+}
+
+// SetAttr sets an attribute on the span. value should be a string, bool,
+// integer or float, other types are formatted as strings.
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mtx.Lock()
+	s.attrs[key] = value
+	s.mtx.Unlock()
+}
+
+// IncrAttr increments an integer attribute on the span, setting it to one
+// if it is not already set.
+func (s *Span) IncrAttr(key string) {
+	if s == nil {
+		return
+	}
+	s.mtx.Lock()
+	n, _ := s.attrs[key].(int)
+	s.attrs[key] = n + 1
+	s.mtx.Unlock()
+}
+
+// SetError marks the span as failed with the given error, if it is not nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mtx.Lock()
+	s.err = err
+	s.mtx.Unlock()
+}
+
+// End completes the span and queues it for export. Calling End more than
+// once has no effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mtx.Lock()
+	if s.ended {
+		s.mtx.Unlock()
+		return
+	}
+	s.ended = true
+	s.mtx.Unlock()
+	if e := currentExporter(); e != nil {
+		e.add(s, time.Now())
+	}
+}
+
+// Finish sets the error on the span and ends it, and is intended to be
+// deferred with a named error return value.
+func (s *Span) Finish(err *error) {
+	if err != nil {
+		s.SetError(*err)
+	}
+	s.End()
+}
+
+func randomTraceID() (id [16]byte) {
+	rand.Read(id[:])
+	return
+}
+
+func randomSpanID() (id [8]byte) {
+	rand.Read(id[:])
+	return
+}
@@ -0,0 +1,129 @@
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/flynn/flynn/pkg/httphelper"
+)
+
+func TestTraceparent(t *testing.T) {
+	// root spans start a new random trace, so that each deploy of a
+	// release has its own trace
+	parent := Start("root", SpanContext{}).Context()
+	if !parent.IsValid() {
+		t.Fatal("expected root span context to be valid")
+	}
+	if Start("root", SpanContext{}).Context().TraceID == parent.TraceID {
+		t.Fatal("expected root spans to start new traces")
+	}
+
+	span := Start("test", parent)
+	if span.Context().TraceID != parent.TraceID {
+		t.Fatal("expected child span to share the parent's trace ID")
+	}
+	parsed, ok := ParseTraceparent(span.Context().String())
+	if !ok {
+		t.Fatalf("error parsing traceparent %q", span.Context().String())
+	}
+	if parsed != span.Context() {
+		t.Fatalf("expected %s, got %s", span.Context(), parsed)
+	}
+
+	for _, s := range []string{
+		"",
+		"00-00000000000000000000000000000000-0000000000000000-01",
+		"00-abc-def-01",
+		"ff-" + parsed.String()[3:],
+	} {
+		if _, ok := ParseTraceparent(s); ok {
+			t.Fatalf("expected %q to be invalid", s)
+		}
+	}
+
+	var nilSpan *Span
+	nilSpan.SetAttr("key", "value")
+	nilSpan.End()
+	if nilSpan.Context().IsValid() {
+		t.Fatal("expected nil span to have an invalid context")
+	}
+}
+
+func TestExport(t *testing.T) {
+	requests := make(chan *otlpRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("unexpected path %q", r.URL.Path)
+		}
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		requests <- &req
+	}))
+	defer srv.Close()
+
+	os.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", srv.URL)
+	defer os.Unsetenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	if !Init("test") {
+		t.Fatal("expected exporting to be enabled")
+	}
+	defer func() {
+		exporterMtx.Lock()
+		exporter = nil
+		exporterMtx.Unlock()
+		httphelper.SetTracer(nil)
+	}()
+
+	parent := Start("parent", SpanContext{})
+	child := Start("child", parent.Context())
+	child.SetAttr("job.id", "1")
+	child.SetError(errors.New("boom"))
+	child.End()
+	parent.End()
+	Flush()
+
+	req := <-requests
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	if spans[0].Name != "child" || spans[0].ParentSpanID != spans[1].SpanID || spans[0].TraceID != spans[1].TraceID {
+		t.Fatalf("unexpected child span: %+v", spans[0])
+	}
+	if spans[0].Status.Code != otlpStatusError || spans[0].Status.Message != "boom" {
+		t.Fatalf("unexpected child span status: %+v", spans[0].Status)
+	}
+	if spans[1].ParentSpanID != "" {
+		t.Fatalf("expected parent span to have no parent, got %q", spans[1].ParentSpanID)
+	}
+}
+
+func TestTraceparentOnlyWhenEnabled(t *testing.T) {
+	span := Start("test", SpanContext{})
+	if Enabled() || Traceparent(span) != "" {
+		t.Fatal("expected no traceparent when exporting is disabled")
+	}
+
+	os.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://127.0.0.1:0")
+	defer os.Unsetenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	if !Init("test") {
+		t.Fatal("expected exporting to be enabled")
+	}
+	defer func() {
+		exporterMtx.Lock()
+		exporter = nil
+		exporterMtx.Unlock()
+		httphelper.SetTracer(nil)
+	}()
+	if Traceparent(span) != span.Context().String() {
+		t.Fatalf("expected traceparent %s, got %q", span.Context(), Traceparent(span))
+	}
+	if Traceparent(nil) != "" {
+		t.Fatal("expected no traceparent for a nil span")
+	}
+}
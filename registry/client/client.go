@@ -0,0 +1,321 @@
+// Package client implements a client for the registry system app, which
+// stores images in the blobstore and serves them using the OCI distribution
+// API so they have an addressable location inside the cluster.
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	ct "github.com/flynn/flynn/controller/types"
+)
+
+// DefaultURL is the URL of the registry inside the cluster.
+const DefaultURL = "http://registry.discoverd"
+
+const (
+	// MediaTypeImageManifest is the media type of OCI image manifests.
+	MediaTypeImageManifest = "application/vnd.oci.image.manifest.v1+json"
+
+	// MediaTypeFlynnImage is the media type of the config of images
+	// pushed from Flynn artifacts, which is the raw Flynn image manifest.
+	MediaTypeFlynnImage = "application/vnd.flynn.image.manifest.v1+json"
+
+	// MediaTypeFlynnLayer is the media type of Flynn squashfs layers.
+	MediaTypeFlynnLayer = "application/vnd.flynn.image.layer.v1.squashfs"
+
+	// AnnotationLayerID is the layer annotation containing the ID of a
+	// Flynn layer, which the registry uses to serve the layer at
+	// /layers/<id>.squashfs.
+	AnnotationLayerID = "io.flynn.layer.id"
+)
+
+// Descriptor references content in the registry.
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Manifest is an OCI image manifest.
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// Error is an error returned by the registry.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("registry: %s: %s", e.Code, e.Message)
+}
+
+// ErrorResponse is the body of registry error responses.
+type ErrorResponse struct {
+	Errors []*Error `json:"errors"`
+}
+
+// ErrNotFound is returned when a blob, manifest or repository does not exist.
+var ErrNotFound = errors.New("registry: not found")
+
+// Digest returns the sha256 digest of data.
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// LayerURLTemplate returns the layer URL template of artifacts whose layers
+// are served by the registry at the given URL.
+func LayerURLTemplate(registryURL string) string {
+	return strings.TrimSuffix(registryURL, "/") + "/layers/{id}.squashfs"
+}
+
+type Client struct {
+	URL  string
+	Key  string
+	HTTP *http.Client
+}
+
+// New returns a client for the registry at the given URL, authenticating
+// writes with key.
+func New(url, key string) *Client {
+	return &Client{URL: strings.TrimSuffix(url, "/"), Key: key, HTTP: http.DefaultClient}
+}
+
+func (c *Client) do(method, path string, header http.Header, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.URL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if c.Key != "" {
+		req.SetBasicAuth("", c.Key)
+	}
+	if r, ok := body.(*bytes.Reader); ok {
+		req.ContentLength = int64(r.Len())
+	}
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	var errRes ErrorResponse
+	if err := json.NewDecoder(res.Body).Decode(&errRes); err == nil && len(errRes.Errors) > 0 {
+		return nil, errRes.Errors[0]
+	}
+	return nil, fmt.Errorf("registry: unexpected status %s", res.Status)
+}
+
+// BlobExists returns whether the registry has the blob with the given digest.
+func (c *Client) BlobExists(name, digest string) (bool, error) {
+	res, err := c.do("HEAD", fmt.Sprintf("/v2/%s/blobs/%s", name, digest), nil, nil)
+	if err == ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	res.Body.Close()
+	return true, nil
+}
+
+// PushBlob uploads a blob in a single request if the registry does not
+// already have it, returning its descriptor.
+func (c *Client) PushBlob(name string, blob io.ReadSeeker, mediaType string) (*Descriptor, error) {
+	h := sha256.New()
+	size, err := io.Copy(h, blob)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := blob.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	desc := &Descriptor{MediaType: mediaType, Digest: "sha256:" + hex.EncodeToString(h.Sum(nil)), Size: size}
+	if exists, err := c.BlobExists(name, desc.Digest); err != nil {
+		return nil, err
+	} else if exists {
+		return desc, nil
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/v2/%s/blobs/uploads/?digest=%s", c.URL, name, url.QueryEscape(desc.Digest)), blob)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if c.Key != "" {
+		req.SetBasicAuth("", c.Key)
+	}
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		var errRes ErrorResponse
+		if err := json.NewDecoder(res.Body).Decode(&errRes); err == nil && len(errRes.Errors) > 0 {
+			return nil, errRes.Errors[0]
+		}
+		return nil, fmt.Errorf("registry: unexpected status %s", res.Status)
+	}
+	return desc, nil
+}
+
+// GetBlob returns the content of a blob.
+func (c *Client) GetBlob(name, digest string) (io.ReadCloser, error) {
+	res, err := c.do("GET", fmt.Sprintf("/v2/%s/blobs/%s", name, digest), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// PutManifest uploads a manifest, tagging it if ref is a tag, and returns
+// its digest.
+func (c *Client) PutManifest(name, ref string, manifest *Manifest) (string, error) {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	header := http.Header{"Content-Type": {manifest.MediaType}}
+	res, err := c.do("PUT", fmt.Sprintf("/v2/%s/manifests/%s", name, ref), header, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	return Digest(data), nil
+}
+
+// GetManifest returns the manifest with the given tag or digest and its
+// digest.
+func (c *Client) GetManifest(name, ref string) (*Manifest, string, error) {
+	header := http.Header{"Accept": {MediaTypeImageManifest}}
+	res, err := c.do("GET", fmt.Sprintf("/v2/%s/manifests/%s", name, ref), header, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, "", err
+	}
+	return manifest, Digest(data), nil
+}
+
+// Tags returns the tags of a repository.
+func (c *Client) Tags(name string) ([]string, error) {
+	res, err := c.do("GET", fmt.Sprintf("/v2/%s/tags/list", name), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var list struct {
+		Tags []string `json:"tags"`
+	}
+	return list.Tags, json.NewDecoder(res.Body).Decode(&list)
+}
+
+// PushArtifact pushes a Flynn image artifact and its squashfs layers, which
+// are read using openLayer, tagging the image with tag. The config of the
+// pushed manifest is the raw Flynn image manifest.
+func (c *Client) PushArtifact(name, tag string, artifact *ct.Artifact, openLayer func(*ct.ImageLayer) (io.ReadSeekCloser, error)) (*ct.Artifact, error) {
+	if artifact.Type != ct.ArtifactTypeFlynn || artifact.Manifest() == nil {
+		return nil, fmt.Errorf("registry: cannot push %s artifact", artifact.Type)
+	}
+	config, err := c.PushBlob(name, bytes.NewReader(artifact.RawManifest), MediaTypeFlynnImage)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeImageManifest,
+		Config:        *config,
+	}
+	pushed := make(map[string]struct{})
+	for _, rootfs := range artifact.Manifest().Rootfs {
+		for _, layer := range rootfs.Layers {
+			if _, ok := pushed[layer.ID]; ok {
+				continue
+			}
+			f, err := openLayer(layer)
+			if err != nil {
+				return nil, err
+			}
+			desc, err := c.PushBlob(name, f, MediaTypeFlynnLayer)
+			f.Close()
+			if err != nil {
+				return nil, fmt.Errorf("error pushing layer %s: %s", layer.ID, err)
+			}
+			desc.Annotations = map[string]string{AnnotationLayerID: layer.ID}
+			manifest.Layers = append(manifest.Layers, *desc)
+			pushed[layer.ID] = struct{}{}
+		}
+	}
+	if _, err := c.PutManifest(name, tag, manifest); err != nil {
+		return nil, err
+	}
+	return c.Artifact(name, tag)
+}
+
+// Artifact returns a Flynn image artifact for the image with the given tag
+// or digest, whose layers are pulled from the registry.
+func (c *Client) Artifact(name, ref string) (*ct.Artifact, error) {
+	manifest, digest, err := c.GetManifest(name, ref)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Config.MediaType != MediaTypeFlynnImage {
+		return nil, fmt.Errorf("registry: %s:%s is not a Flynn image", name, ref)
+	}
+	body, err := c.GetBlob(name, manifest.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	rawManifest, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	var image ct.ImageManifest
+	if err := json.Unmarshal(rawManifest, &image); err != nil {
+		return nil, err
+	}
+	return &ct.Artifact{
+		Type:             ct.ArtifactTypeFlynn,
+		URI:              fmt.Sprintf("%s/v2/%s/blobs/%s", c.URL, name, manifest.Config.Digest),
+		RawManifest:      rawManifest,
+		Hashes:           image.Hashes(),
+		Size:             int64(len(rawManifest)),
+		LayerURLTemplate: LayerURLTemplate(c.URL),
+		Meta: map[string]string{
+			"registry.name":   name,
+			"registry.digest": digest,
+		},
+	}, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/flynn/flynn/controller/authorizer"
+	"github.com/flynn/flynn/pkg/httphelper"
+)
+
+func main() {
+	authKey := os.Getenv("AUTH_KEY")
+	if authKey == "" {
+		log.Fatal("missing AUTH_KEY env var")
+	}
+	tokenKey, err := authorizer.ParseTokenKey(os.Getenv("ACCESS_TOKEN_KEY"))
+	if err != nil {
+		log.Fatalln("error decoding ACCESS_TOKEN_KEY:", err)
+	}
+	tokenMaxValidity, err := authorizer.ParseTokenMaxValidity(os.Getenv("ACCESS_TOKEN_MAX_VALIDITY"))
+	if err != nil {
+		log.Fatalln("error parsing ACCESS_TOKEN_MAX_VALIDITY:", err)
+	}
+	auth := authorizer.New([]string{authKey}, nil, tokenKey, tokenMaxValidity)
+
+	blobstoreURL := os.Getenv("BLOBSTORE_URL")
+	if blobstoreURL == "" {
+		blobstoreURL = "http://blobstore.discoverd"
+	}
+
+	srv := newServer(auth, newStore(blobstoreURL))
+
+	handler := httphelper.ContextInjector(
+		"registry",
+		httphelper.NewRequestLogger(srv),
+	)
+
+	log.Fatal(http.ListenAndServe(":"+os.Getenv("PORT"), handler))
+}
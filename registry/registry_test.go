@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/flynn/flynn/controller/authorizer"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/registry/client"
+)
+
+// fakeBlobstore implements the parts of the blobstore API used by the
+// registry.
+type fakeBlobstore struct {
+	mtx   sync.Mutex
+	files map[string][]byte
+	types map[string]string
+}
+
+func (b *fakeBlobstore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	path := r.URL.Path
+	if path == "/" {
+		dir := r.URL.Query().Get("dir")
+		paths := []string{}
+		for p := range b.files {
+			if strings.HasPrefix(p, dir+"/") {
+				paths = append(paths, p)
+			}
+		}
+		sort.Strings(paths)
+		json.NewEncoder(w).Encode(paths)
+		return
+	}
+	switch r.Method {
+	case "GET", "HEAD":
+		data, ok := b.files[path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", b.types[path])
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == "GET" {
+			w.Write(data)
+		}
+	case "PUT":
+		if src := r.Header.Get("Blobstore-Copy-From"); src != "" {
+			data, ok := b.files[src]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			b.files[path] = append([]byte(nil), data...)
+			b.types[path] = b.types[src]
+			return
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		if s := r.Header.Get("Blobstore-Offset"); s != "" {
+			offset, _ := strconv.Atoi(s)
+			data = append(b.files[path][:offset], data...)
+		}
+		b.files[path] = data
+		b.types[path] = r.Header.Get("Content-Type")
+	case "DELETE":
+		if _, ok := b.files[path]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		delete(b.files, path)
+	}
+}
+
+func newTestRegistry(t *testing.T) (*httptest.Server, *fakeBlobstore) {
+	blobstore := &fakeBlobstore{files: make(map[string][]byte), types: make(map[string]string)}
+	bs := httptest.NewServer(blobstore)
+	t.Cleanup(bs.Close)
+	auth := authorizer.New([]string{"test-key"}, nil, nil, 0)
+	srv := httptest.NewServer(newServer(auth, newStore(bs.URL)))
+	t.Cleanup(srv.Close)
+	return srv, blobstore
+}
+
+type readSeekNopCloser struct {
+	io.ReadSeeker
+}
+
+func (readSeekNopCloser) Close() error { return nil }
+
+func TestPushPullArtifact(t *testing.T) {
+	srv, _ := newTestRegistry(t)
+	c := client.New(srv.URL, "test-key")
+
+	layers := map[string][]byte{
+		"layer1": []byte("base layer"),
+		"layer2": []byte("app layer"),
+	}
+	manifest := &ct.ImageManifest{
+		Type: ct.ImageManifestTypeV1,
+		Rootfs: []*ct.ImageRootfs{{
+			Platform: ct.DefaultImagePlatform,
+			Layers: []*ct.ImageLayer{
+				{ID: "layer1", Type: ct.ImageLayerTypeSquashfs, Length: int64(len(layers["layer1"]))},
+				{ID: "layer2", Type: ct.ImageLayerTypeSquashfs, Length: int64(len(layers["layer2"]))},
+			},
+		}},
+	}
+	artifact := &ct.Artifact{
+		Type:        ct.ArtifactTypeFlynn,
+		RawManifest: manifest.RawManifest(),
+	}
+	openLayer := func(l *ct.ImageLayer) (io.ReadSeekCloser, error) {
+		return readSeekNopCloser{bytes.NewReader(layers[l.ID])}, nil
+	}
+
+	// pushing requires authentication
+	if _, err := client.New(srv.URL, "").PushArtifact("flynn/app", "v1", artifact, openLayer); err == nil || !strings.Contains(err.Error(), "UNAUTHORIZED") {
+		t.Fatalf("expected unauthorized error, got %v", err)
+	}
+
+	pushed, err := c.PushArtifact("flynn/app", "v1", artifact, openLayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pushed.RawManifest, artifact.RawManifest) {
+		t.Fatalf("unexpected manifest %s", pushed.RawManifest)
+	}
+	if pushed.LayerURLTemplate != srv.URL+"/layers/{id}.squashfs" {
+		t.Fatalf("unexpected layer URL template %q", pushed.LayerURLTemplate)
+	}
+
+	// the pulled artifact's layers and manifest can be fetched without
+	// authentication
+	for _, layer := range pushed.Manifest().Rootfs[0].Layers {
+		res, err := http.Get(pushed.LayerURL(layer))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != 200 || !bytes.Equal(data, layers[layer.ID]) {
+			t.Fatalf("unexpected layer %s response: %s %q", layer.ID, res.Status, data)
+		}
+	}
+	res, err := http.Get(pushed.URI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if !bytes.Equal(data, artifact.RawManifest) {
+		t.Fatalf("unexpected artifact URI content %q", data)
+	}
+
+	// the image can also be pulled by digest
+	byDigest, err := c.Artifact("flynn/app", pushed.Meta["registry.digest"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byDigest.Manifest().ID() != pushed.Manifest().ID() {
+		t.Fatal("expected the same image when pulling by digest")
+	}
+
+	if _, err := c.PushArtifact("flynn/app", "v2", artifact, openLayer); err != nil {
+		t.Fatal(err)
+	}
+	tags, err := c.Tags("flynn/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(tags, ",") != "v1,v2" {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+
+	if _, err := c.Artifact("flynn/app", "v3"); err != client.ErrNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestChunkedUpload(t *testing.T) {
+	srv, blobstore := newTestRegistry(t)
+
+	do := func(method, url string, body []byte, header map[string]string) *http.Response {
+		req, err := http.NewRequest(method, url, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("", "test-key")
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	blob := []byte("hello registry")
+	digest := client.Digest(blob)
+
+	res := do("POST", srv.URL+"/v2/test/blobs/uploads/", nil, nil)
+	if res.StatusCode != http.StatusAccepted {
+		t.Fatalf("unexpected status %s", res.Status)
+	}
+	location := srv.URL + res.Header.Get("Location")
+	res = do("PATCH", location, blob[:5], map[string]string{"Content-Range": "0-4"})
+	if res.StatusCode != http.StatusAccepted || res.Header.Get("Range") != "0-4" {
+		t.Fatalf("unexpected response %s %q", res.Status, res.Header.Get("Range"))
+	}
+
+	// chunks which don't continue the upload are rejected
+	res = do("PATCH", location, blob[5:], map[string]string{"Content-Range": "0-8"})
+	if res.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("unexpected status %s", res.Status)
+	}
+
+	res = do("PUT", location+"?digest="+client.Digest([]byte("wrong")), blob[5:], nil)
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected digest mismatch, got %s", res.Status)
+	}
+	if _, ok := blobstore.files[blobPath(digest)]; ok {
+		t.Fatal("expected blob with mismatched digest not to be stored")
+	}
+
+	res = do("POST", srv.URL+"/v2/test/blobs/uploads/", nil, nil)
+	location = srv.URL + res.Header.Get("Location")
+	do("PATCH", location, blob[:5], nil)
+	res = do("PUT", location+"?digest="+digest, blob[5:], nil)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status %s", res.Status)
+	}
+	if got := string(blobstore.files[blobPath(digest)]); got != string(blob) {
+		t.Fatalf("unexpected blob %q", got)
+	}
+	for path := range blobstore.files {
+		if strings.HasPrefix(path, "/registry/uploads/") {
+			t.Fatalf("expected upload %s to be deleted", path)
+		}
+	}
+
+	// manifests referencing unknown blobs are rejected
+	manifest, _ := json.Marshal(&client.Manifest{
+		SchemaVersion: 2,
+		MediaType:     client.MediaTypeImageManifest,
+		Config:        client.Descriptor{Digest: digest},
+		Layers:        []client.Descriptor{{Digest: client.Digest([]byte("missing"))}},
+	})
+	res = do("PUT", srv.URL+"/v2/test/manifests/latest", manifest, map[string]string{"Content-Type": client.MediaTypeImageManifest})
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status %s", res.Status)
+	}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/flynn/flynn/controller/authorizer"
+	"github.com/flynn/flynn/pkg/random"
+	"github.com/flynn/flynn/pkg/status"
+	"github.com/flynn/flynn/registry/client"
+)
+
+// maxManifestSize is the maximum size of manifests accepted by the registry.
+const maxManifestSize = 4 * 1024 * 1024
+
+var (
+	namePattern   = `[a-z0-9]+(?:[._-][a-z0-9]+)*(?:/[a-z0-9]+(?:[._-][a-z0-9]+)*)*`
+	digestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+	tagPattern    = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+	uuidPattern   = `[a-f0-9-]{36}`
+
+	blobRoute     = regexp.MustCompile(`^/v2/(` + namePattern + `)/blobs/([^/]+)$`)
+	uploadsRoute  = regexp.MustCompile(`^/v2/(` + namePattern + `)/blobs/uploads/?$`)
+	uploadRoute   = regexp.MustCompile(`^/v2/(` + namePattern + `)/blobs/uploads/(` + uuidPattern + `)$`)
+	manifestRoute = regexp.MustCompile(`^/v2/(` + namePattern + `)/manifests/([^/]+)$`)
+	tagsRoute     = regexp.MustCompile(`^/v2/(` + namePattern + `)/tags/list$`)
+	layerRoute    = regexp.MustCompile(`^/layers/([a-zA-Z0-9_.-]+)\.squashfs$`)
+)
+
+// OCI distribution error codes
+const (
+	errBlobUnknown         = "BLOB_UNKNOWN"
+	errBlobUploadUnknown   = "BLOB_UPLOAD_UNKNOWN"
+	errDigestInvalid       = "DIGEST_INVALID"
+	errManifestBlobUnknown = "MANIFEST_BLOB_UNKNOWN"
+	errManifestInvalid     = "MANIFEST_INVALID"
+	errManifestUnknown     = "MANIFEST_UNKNOWN"
+	errNameUnknown         = "NAME_UNKNOWN"
+	errSizeInvalid         = "SIZE_INVALID"
+	errTagInvalid          = "TAG_INVALID"
+	errUnauthorized        = "UNAUTHORIZED"
+	errUnsupported         = "UNSUPPORTED"
+)
+
+type server struct {
+	auth  *authorizer.Authorizer
+	store *store
+}
+
+func newServer(auth *authorizer.Authorizer, store *store) *server {
+	return &server{auth: auth, store: store}
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == status.Path {
+		status.HealthyHandler.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+
+	// images are pulled by hosts using unauthenticated layer URLs, like
+	// those of the blobstore, so only changes require authentication
+	if r.Method != "GET" && r.Method != "HEAD" {
+		if _, err := s.auth.AuthorizeRequest(r); err != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="flynn-registry"`)
+			s.error(w, http.StatusUnauthorized, errUnauthorized, "authentication required")
+			return
+		}
+	}
+
+	path := r.URL.Path
+	var m []string
+	switch {
+	case path == "/v2/" || path == "/v2":
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	case match(uploadsRoute, path, &m) && r.Method == "POST":
+		s.handleStartUpload(w, r, m[1])
+	case match(uploadRoute, path, &m):
+		switch r.Method {
+		case "GET":
+			s.handleGetUpload(w, r, m[1], m[2])
+		case "PATCH":
+			s.handlePatchUpload(w, r, m[1], m[2])
+		case "PUT":
+			s.handleCompleteUpload(w, r, m[1], m[2])
+		case "DELETE":
+			s.handleCancelUpload(w, r, m[2])
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	case match(blobRoute, path, &m) && (r.Method == "GET" || r.Method == "HEAD"):
+		s.handleGetBlob(w, r, m[2])
+	case match(manifestRoute, path, &m):
+		switch r.Method {
+		case "GET", "HEAD":
+			s.handleGetManifest(w, r, m[1], m[2])
+		case "PUT":
+			s.handlePutManifest(w, r, m[1], m[2])
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	case match(tagsRoute, path, &m) && r.Method == "GET":
+		s.handleListTags(w, r, m[1])
+	case match(layerRoute, path, &m) && (r.Method == "GET" || r.Method == "HEAD"):
+		s.handleGetLayer(w, r, m[1])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func match(re *regexp.Regexp, path string, m *[]string) bool {
+	*m = re.FindStringSubmatch(path)
+	return *m != nil
+}
+
+func (s *server) error(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&client.ErrorResponse{Errors: []*client.Error{{Code: code, Message: msg}}})
+}
+
+func (s *server) internalError(w http.ResponseWriter, err error) {
+	log.Println("error:", err)
+	s.error(w, http.StatusInternalServerError, "UNKNOWN", "internal server error")
+}
+
+// serveFile copies a file from the blobstore to the response, or only
+// writes its headers for HEAD requests.
+func (s *server) serveFile(w http.ResponseWriter, r *http.Request, path, digest, contentType string) error {
+	f, err := s.store.Get(path, r.Method == "GET")
+	if err != nil {
+		return err
+	}
+	if contentType == "" {
+		contentType = f.ContentType
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(f.Size, 10))
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Etag", `"`+digest+`"`)
+	w.WriteHeader(http.StatusOK)
+	if f.Body != nil {
+		defer f.Body.Close()
+		io.Copy(w, f.Body)
+	}
+	return nil
+}
+
+func (s *server) handleGetBlob(w http.ResponseWriter, r *http.Request, digest string) {
+	if !digestPattern.MatchString(digest) {
+		s.error(w, http.StatusBadRequest, errDigestInvalid, "unsupported digest "+digest)
+		return
+	}
+	if err := s.serveFile(w, r, blobPath(digest), digest, "application/octet-stream"); err == errNotFound {
+		s.error(w, http.StatusNotFound, errBlobUnknown, "unknown blob "+digest)
+	} else if err != nil {
+		s.internalError(w, err)
+	}
+}
+
+// handleGetLayer serves a Flynn layer by its ID so that artifacts can use
+// the registry in their layer URL template.
+func (s *server) handleGetLayer(w http.ResponseWriter, r *http.Request, id string) {
+	digest, err := s.store.GetString(layerPath(id))
+	if err == nil {
+		err = s.serveFile(w, r, blobPath(digest), digest, client.MediaTypeFlynnLayer)
+	}
+	if err == errNotFound {
+		s.error(w, http.StatusNotFound, errBlobUnknown, "unknown layer "+id)
+	} else if err != nil {
+		s.internalError(w, err)
+	}
+}
+
+func uploadLocation(name, id string) string {
+	return fmt.Sprintf("/v2/%s/blobs/uploads/%s", name, id)
+}
+
+// handleStartUpload starts a blob upload, or uploads a blob in a single
+// request if the digest parameter is set.
+func (s *server) handleStartUpload(w http.ResponseWriter, r *http.Request, name string) {
+	// blobs are shared by all repositories, so a blob mounted from
+	// another repository either exists or has to be uploaded
+	if digest := r.URL.Query().Get("mount"); digestPattern.MatchString(digest) {
+		if _, err := s.store.Get(blobPath(digest), false); err == nil {
+			s.blobCreated(w, name, digest)
+			return
+		}
+	}
+	id := random.UUID()
+	if digest := r.URL.Query().Get("digest"); digest != "" {
+		if err := s.store.Put(uploadPath(id), r.Body, 0, ""); err != nil {
+			s.internalError(w, err)
+			return
+		}
+		s.commitUpload(w, name, id, digest)
+		return
+	}
+	if err := s.store.Put(uploadPath(id), bytes.NewReader(nil), 0, ""); err != nil {
+		s.internalError(w, err)
+		return
+	}
+	s.uploadAccepted(w, name, id, 0, http.StatusAccepted)
+}
+
+func (s *server) uploadAccepted(w http.ResponseWriter, name, id string, size int64, status int) {
+	w.Header().Set("Location", uploadLocation(name, id))
+	w.Header().Set("Docker-Upload-UUID", id)
+	w.Header().Set("Range", fmt.Sprintf("0-%d", max(size-1, 0)))
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(status)
+}
+
+func (s *server) handleGetUpload(w http.ResponseWriter, r *http.Request, name, id string) {
+	f, err := s.store.Get(uploadPath(id), false)
+	if err == errNotFound {
+		s.error(w, http.StatusNotFound, errBlobUploadUnknown, "unknown upload "+id)
+		return
+	} else if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	s.uploadAccepted(w, name, id, f.Size, http.StatusNoContent)
+}
+
+// handlePatchUpload appends a chunk to an upload, which is stored in the
+// blobstore so that any registry instance can continue it.
+func (s *server) handlePatchUpload(w http.ResponseWriter, r *http.Request, name, id string) {
+	size, ok := s.appendUpload(w, r, id)
+	if !ok {
+		return
+	}
+	s.uploadAccepted(w, name, id, size, http.StatusAccepted)
+}
+
+// appendUpload appends the request body to the upload, returning the new
+// size of the upload.
+func (s *server) appendUpload(w http.ResponseWriter, r *http.Request, id string) (int64, bool) {
+	f, err := s.store.Get(uploadPath(id), false)
+	if err == errNotFound {
+		s.error(w, http.StatusNotFound, errBlobUploadUnknown, "unknown upload "+id)
+		return 0, false
+	} else if err != nil {
+		s.internalError(w, err)
+		return 0, false
+	}
+	if cr := r.Header.Get("Content-Range"); cr != "" {
+		var start, end int64
+		if _, err := fmt.Sscanf(cr, "%d-%d", &start, &end); err != nil || start != f.Size {
+			w.Header().Set("Range", fmt.Sprintf("0-%d", max(f.Size-1, 0)))
+			s.error(w, http.StatusRequestedRangeNotSatisfiable, errSizeInvalid, "chunk does not start at the end of the upload")
+			return 0, false
+		}
+	}
+	counter := &countingReader{r: r.Body}
+	if err := s.store.Put(uploadPath(id), counter, f.Size, ""); err != nil {
+		s.internalError(w, err)
+		return 0, false
+	}
+	return f.Size + counter.n, true
+}
+
+func (s *server) handleCompleteUpload(w http.ResponseWriter, r *http.Request, name, id string) {
+	if r.ContentLength != 0 {
+		if _, ok := s.appendUpload(w, r, id); !ok {
+			return
+		}
+	}
+	s.commitUpload(w, name, id, r.URL.Query().Get("digest"))
+}
+
+// commitUpload verifies an upload against the expected digest and moves it
+// to the blob path.
+func (s *server) commitUpload(w http.ResponseWriter, name, id, digest string) {
+	defer s.store.Delete(uploadPath(id))
+	if !digestPattern.MatchString(digest) {
+		s.error(w, http.StatusBadRequest, errDigestInvalid, "unsupported digest "+digest)
+		return
+	}
+	f, err := s.store.Get(uploadPath(id), true)
+	if err == errNotFound {
+		s.error(w, http.StatusNotFound, errBlobUploadUnknown, "unknown upload "+id)
+		return
+	} else if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, f.Body)
+	f.Body.Close()
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	if actual := "sha256:" + hex.EncodeToString(h.Sum(nil)); actual != digest {
+		s.error(w, http.StatusBadRequest, errDigestInvalid, fmt.Sprintf("upload has digest %s, not %s", actual, digest))
+		return
+	}
+	if err := s.store.Copy(blobPath(digest), uploadPath(id)); err != nil {
+		s.internalError(w, err)
+		return
+	}
+	s.blobCreated(w, name, digest)
+}
+
+func (s *server) blobCreated(w http.ResponseWriter, name, digest string) {
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", name, digest))
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *server) handleCancelUpload(w http.ResponseWriter, r *http.Request, id string) {
+	if err := s.store.Delete(uploadPath(id)); err == errNotFound {
+		s.error(w, http.StatusNotFound, errBlobUploadUnknown, "unknown upload "+id)
+		return
+	} else if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resolveManifest returns the digest of the manifest with the given tag or
+// digest.
+func (s *server) resolveManifest(name, ref string) (string, error) {
+	if digestPattern.MatchString(ref) {
+		return ref, nil
+	}
+	if !tagPattern.MatchString(ref) {
+		return "", errNotFound
+	}
+	return s.store.GetString(tagsDir(name) + "/" + ref)
+}
+
+func (s *server) handleGetManifest(w http.ResponseWriter, r *http.Request, name, ref string) {
+	digest, err := s.resolveManifest(name, ref)
+	if err == nil {
+		err = s.serveFile(w, r, manifestPath(name, digest), digest, "")
+	}
+	if err == errNotFound {
+		s.error(w, http.StatusNotFound, errManifestUnknown, fmt.Sprintf("unknown manifest %s:%s", name, ref))
+	} else if err != nil {
+		s.internalError(w, err)
+	}
+}
+
+// handlePutManifest stores an image manifest once all the blobs it
+// references have been uploaded, tagging it if ref is a tag, and records
+// the digests of Flynn layers so they can be served by ID.
+func (s *server) handlePutManifest(w http.ResponseWriter, r *http.Request, name, ref string) {
+	isDigest := digestPattern.MatchString(ref)
+	if !isDigest && !tagPattern.MatchString(ref) {
+		s.error(w, http.StatusBadRequest, errTagInvalid, "invalid tag "+ref)
+		return
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxManifestSize+1))
+	if err != nil {
+		s.internalError(w, err)
+		return
+	} else if len(data) > maxManifestSize {
+		s.error(w, http.StatusRequestEntityTooLarge, errSizeInvalid, "manifest too large")
+		return
+	}
+	digest := client.Digest(data)
+	if isDigest && ref != digest {
+		s.error(w, http.StatusBadRequest, errDigestInvalid, fmt.Sprintf("manifest has digest %s, not %s", digest, ref))
+		return
+	}
+
+	var manifest client.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil || manifest.SchemaVersion != 2 {
+		s.error(w, http.StatusBadRequest, errManifestInvalid, "invalid image manifest")
+		return
+	}
+	mediaType := manifest.MediaType
+	if mediaType == "" {
+		mediaType = r.Header.Get("Content-Type")
+	}
+	if mediaType != client.MediaTypeImageManifest {
+		s.error(w, http.StatusBadRequest, errUnsupported, "unsupported manifest type "+mediaType)
+		return
+	}
+	for _, desc := range append([]client.Descriptor{manifest.Config}, manifest.Layers...) {
+		if _, err := s.store.Get(blobPath(desc.Digest), false); err == errNotFound || !digestPattern.MatchString(desc.Digest) {
+			s.error(w, http.StatusBadRequest, errManifestBlobUnknown, "unknown blob "+desc.Digest)
+			return
+		} else if err != nil {
+			s.internalError(w, err)
+			return
+		}
+	}
+
+	if err := s.store.Put(manifestPath(name, digest), bytes.NewReader(data), 0, mediaType); err != nil {
+		s.internalError(w, err)
+		return
+	}
+	if manifest.Config.MediaType == client.MediaTypeFlynnImage {
+		for _, layer := range manifest.Layers {
+			if id := layer.Annotations[client.AnnotationLayerID]; id != "" {
+				if err := s.store.PutString(layerPath(id), layer.Digest); err != nil {
+					s.internalError(w, err)
+					return
+				}
+			}
+		}
+	}
+	if !isDigest {
+		if err := s.store.PutString(tagsDir(name)+"/"+ref, digest); err != nil {
+			s.internalError(w, err)
+			return
+		}
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/manifests/%s", name, digest))
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *server) handleListTags(w http.ResponseWriter, r *http.Request, name string) {
+	tags, err := s.store.List(tagsDir(name))
+	if err != nil && err != errNotFound {
+		s.internalError(w, err)
+		return
+	}
+	if len(tags) == 0 {
+		s.error(w, http.StatusNotFound, errNameUnknown, "unknown repository "+name)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}{name, tags})
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
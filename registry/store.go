@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/flynn/flynn/pkg/httphelper"
+)
+
+var errNotFound = errors.New("registry: not found")
+
+// store keeps registry data in the blobstore using the following layout:
+//
+//	/registry/blobs/sha256/<hex>                      blob content
+//	/registry/uploads/<uuid>                          in-progress blob upload
+//	/registry/repositories/<name>/manifests/<hex>     manifest content
+//	/registry/repositories/<name>/tags/<tag>          digest of the tagged manifest
+//	/registry/layers/<id>                             digest of a Flynn squashfs layer
+type store struct {
+	url    string
+	client *http.Client
+}
+
+func newStore(blobstoreURL string) *store {
+	return &store{url: strings.TrimSuffix(blobstoreURL, "/"), client: httphelper.RetryClient}
+}
+
+func blobPath(digest string) string {
+	return "/registry/blobs/" + strings.Replace(digest, ":", "/", 1)
+}
+
+func uploadPath(id string) string {
+	return "/registry/uploads/" + id
+}
+
+func manifestPath(name, digest string) string {
+	return "/registry/repositories/" + name + "/manifests/" + strings.TrimPrefix(digest, "sha256:")
+}
+
+func tagsDir(name string) string {
+	return "/registry/repositories/" + name + "/tags"
+}
+
+func layerPath(id string) string {
+	return "/registry/layers/" + id
+}
+
+// file is a file read from the blobstore, with Body set for GET requests.
+type file struct {
+	Size        int64
+	ContentType string
+	Body        io.ReadCloser
+}
+
+func (s *store) do(req *http.Request) (*http.Response, error) {
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, errNotFound
+	} else if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return nil, fmt.Errorf("unexpected blobstore response: %s: %s", res.Status, body)
+	}
+	return res, nil
+}
+
+// Get returns the file at the given path, only opening its content if body
+// is true.
+func (s *store) Get(path string, body bool) (*file, error) {
+	method := "HEAD"
+	if body {
+		method = "GET"
+	}
+	req, err := http.NewRequest(method, s.url+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	f := &file{Size: res.ContentLength, ContentType: res.Header.Get("Content-Type")}
+	if body {
+		f.Body = res.Body
+	} else {
+		res.Body.Close()
+	}
+	return f, nil
+}
+
+// GetString returns the content of a small file, such as a tag.
+func (s *store) GetString(path string) (string, error) {
+	f, err := s.Get(path, true)
+	if err != nil {
+		return "", err
+	}
+	defer f.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(f.Body, 1024))
+	return strings.TrimSpace(string(data)), err
+}
+
+// Put writes data to the given path, starting at offset to append to an
+// existing file.
+func (s *store) Put(path string, data io.Reader, offset int64, contentType string) error {
+	req, err := http.NewRequest("PUT", s.url+path, data)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Blobstore-Offset", strconv.FormatInt(offset, 10))
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	res, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+func (s *store) PutString(path, data string) error {
+	return s.Put(path, strings.NewReader(data), 0, "text/plain")
+}
+
+// Copy copies a file without transferring its content.
+func (s *store) Copy(to, from string) error {
+	req, err := http.NewRequest("PUT", s.url+to, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Blobstore-Copy-From", from)
+	res, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+func (s *store) Delete(path string) error {
+	req, err := http.NewRequest("DELETE", s.url+path, nil)
+	if err != nil {
+		return err
+	}
+	res, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// List returns the names of the files in the given directory.
+func (s *store) List(dir string) ([]string, error) {
+	req, err := http.NewRequest("GET", s.url+"/?dir="+url.QueryEscape(dir), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var paths []string
+	if err := json.NewDecoder(res.Body).Decode(&paths); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(paths))
+	for _, p := range paths {
+		if p = strings.TrimSuffix(p, "/"); path.Dir(p) == dir {
+			names = append(names, path.Base(p))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -1,11 +1,15 @@
 package acme
 
 import (
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
 	"fmt"
+	"strings"
 
+	acmelib "github.com/eggsampler/acme/v3"
 	ct "github.com/flynn/flynn/controller/types"
 )
 
@@ -17,6 +21,11 @@ type Account struct {
 	Contacts []string `json:"contacts,omitempty"`
 	// TermsOfServiceAgreed indicates whether the ToS have been agreed to
 	TermsOfServiceAgreed bool `json:"terms_of_service_agreed,omitempty"`
+	// EABKeyID is the key identifier of the External Account Binding
+	// required by some CAs to register new accounts
+	EABKeyID string `json:"eab_key_id,omitempty"`
+	// EABHMACKey is the base64url-encoded HMAC key of the External Account Binding
+	EABHMACKey string `json:"eab_hmac_key,omitempty"`
 }
 
 // NewAccountFromConfig creates an Account from an ACMEConfig
@@ -33,6 +42,8 @@ func NewAccountFromConfig(config *ct.ACMEConfig) (*Account, error) {
 	account := &Account{
 		Key:                  config.AccountKey,
 		TermsOfServiceAgreed: config.TermsOfServiceAgreed,
+		EABKeyID:             config.EABKeyID,
+		EABHMACKey:           config.EABHMACKey,
 	}
 	if config.ContactEmail != "" {
 		account.Contacts = []string{config.ContactEmail}
@@ -67,3 +78,43 @@ func (a *Account) KeyID() string {
 	}
 	return "unknown"
 }
+
+// ExternalAccountBinding returns an option which binds a new account to an
+// existing account with the CA, as required by CAs such as ZeroSSL, Google
+// Trust Services and step-ca. The HMAC key is accepted in the base64url
+// encoding used by the CAs, with or without padding, or in standard base64.
+func ExternalAccountBinding(keyID, hmacKey string) (acmelib.NewAccountOptionFunc, error) {
+	if keyID == "" || hmacKey == "" {
+		return nil, fmt.Errorf("both the EAB key ID and HMAC key are required")
+	}
+	key := strings.NewReplacer("+", "-", "/", "_", "=", "").Replace(strings.TrimSpace(hmacKey))
+	if _, err := base64.RawURLEncoding.DecodeString(key); err != nil {
+		return nil, fmt.Errorf("invalid EAB HMAC key, expected base64url encoding: %s", err)
+	}
+	return acmelib.NewAcctOptExternalAccountBinding(acmelib.ExternalAccountBinding{
+		KeyIdentifier: keyID,
+		MacKey:        key,
+		Algorithm:     "HS256",
+		HashFunc:      crypto.SHA256,
+	}), nil
+}
+
+// NewAccountOptions returns the options for registering the account with
+// the CA.
+func (a *Account) NewAccountOptions() ([]acmelib.NewAccountOptionFunc, error) {
+	var opts []acmelib.NewAccountOptionFunc
+	if a.TermsOfServiceAgreed {
+		opts = append(opts, acmelib.NewAcctOptAgreeTOS())
+	}
+	if len(a.Contacts) > 0 {
+		opts = append(opts, acmelib.NewAcctOptWithContacts(a.Contacts...))
+	}
+	if a.EABKeyID != "" || a.EABHMACKey != "" {
+		eab, err := ExternalAccountBinding(a.EABKeyID, a.EABHMACKey)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, eab)
+	}
+	return opts, nil
+}
@@ -0,0 +1,52 @@
+package acme
+
+import (
+	"testing"
+
+	ct "github.com/flynn/flynn/controller/types"
+)
+
+func TestExternalAccountBinding(t *testing.T) {
+	for _, key := range []string{
+		"c2VjcmV0LWhtYWMta2V5LWZvci1lYWI",  // base64url
+		"c2VjcmV0LWhtYWMta2V5LWZvci1lYWI=", // padded
+		"abc+/w==",                         // standard base64
+	} {
+		if _, err := ExternalAccountBinding("kid-1", key); err != nil {
+			t.Errorf("unexpected error for key %q: %s", key, err)
+		}
+	}
+	if _, err := ExternalAccountBinding("kid-1", "not base64!"); err == nil {
+		t.Error("expected an error for an invalid HMAC key")
+	}
+	if _, err := ExternalAccountBinding("", "c2VjcmV0"); err == nil {
+		t.Error("expected an error without a key ID")
+	}
+}
+
+func TestNewAccountFromConfigEAB(t *testing.T) {
+	account, err := NewAccountFromConfig(&ct.ACMEConfig{
+		Enabled:              true,
+		ContactEmail:         "mailto:admin@example.com",
+		TermsOfServiceAgreed: true,
+		AccountKey:           "key",
+		EABKeyID:             "kid-1",
+		EABHMACKey:           "c2VjcmV0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := account.NewAccountOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// agree to the terms of service, contacts and EAB
+	if len(opts) != 3 {
+		t.Fatalf("expected 3 options, got %d", len(opts))
+	}
+
+	account.EABHMACKey = ""
+	if _, err := account.NewAccountOptions(); err == nil {
+		t.Fatal("expected an error with an incomplete EAB")
+	}
+}
@@ -8,10 +8,12 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"regexp"
 	"sync"
 	"time"
 
@@ -106,7 +108,11 @@ func (a *ACME) CreateAccount(account *Account) error {
 	if err != nil {
 		return fmt.Errorf("error generating ACME account key: %s", err)
 	}
-	if _, err := a.client.NewAccount(privKey, false, account.TermsOfServiceAgreed, account.Contacts...); err != nil {
+	opts, err := account.NewAccountOptions()
+	if err != nil {
+		return err
+	}
+	if _, err := a.client.NewAccountOptions(privKey, opts...); err != nil {
 		return fmt.Errorf("error creating ACME account: %s", err)
 	}
 	keyDER, err := x509.MarshalECPrivateKey(privKey)
@@ -126,8 +132,12 @@ type Service struct {
 	account     acmelib.Account
 	controller  ControllerClient
 	responder   *Responder
+	dns         *DNSWebhook
+	metrics     *Metrics
 	handling    map[string]struct{}
 	handlingMtx sync.Mutex
+	issued      map[string]*ct.ManagedCertificate
+	issuedMtx   sync.Mutex
 	stop        chan struct{}
 	done        chan struct{}
 	log         log15.Logger
@@ -147,7 +157,9 @@ func (a *ACME) NewService(account *Account, controllerClient ControllerClient, r
 		account:    acmeAccount,
 		controller: controllerClient,
 		responder:  responder,
+		metrics:    NewMetrics(),
 		handling:   make(map[string]struct{}),
+		issued:     make(map[string]*ct.ManagedCertificate),
 		stop:       make(chan struct{}),
 		done:       make(chan struct{}),
 		log:        log,
@@ -157,6 +169,20 @@ func (a *ACME) NewService(account *Account, controllerClient ControllerClient, r
 // configPollInterval is how often to poll for ACME configuration changes
 const configPollInterval = 10 * time.Second
 
+// rateLimitedProblemType is the type of the problem document returned by the
+// ACME CA when a request exceeds one of its rate limits
+const rateLimitedProblemType = "urn:ietf:params:acme:error:rateLimited"
+
+// defaultRateLimitBackoff is how long to wait before ordering a certificate
+// again after being rate limited when the CA does not say when to retry
+const defaultRateLimitBackoff = time.Hour
+
+var retryAfterPattern = regexp.MustCompile(`retry after (\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}) UTC`)
+
+// streamReconnectDelay is how long to wait before reconnecting the managed
+// certificate stream after it disconnects
+var streamReconnectDelay = 5 * time.Second
+
 // RunService runs an ACME service with configuration from the controller
 func RunService(ctx context.Context) error {
 	log := log15.New("component", "acme")
@@ -188,9 +214,14 @@ func RunService(ctx context.Context) error {
 		return err
 	}
 
-	// Start HTTP server for ACME challenge responses
+	// Start HTTP server for ACME challenge responses and metrics, storing
+	// challenges in the controller so that any instance can respond to them
 	log.Info("initializing ACME responder")
-	responder := NewResponder(log)
+	responder := NewResponder(client, log)
+	metrics := NewMetrics()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	mux.Handle("/", responder)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -204,7 +235,8 @@ func RunService(ctx context.Context) error {
 	}
 	defer listener.Close()
 
-	// Register with discoverd so the router can find us
+	// Register with discoverd so the router can find us, the router only
+	// forwards challenge requests so metrics are not exposed publicly
 	log.Info("registering with discoverd", "service", "acme-challenge", "addr", addr)
 	hb, err := discoverd.AddServiceAndRegister("acme-challenge", addr)
 	if err != nil {
@@ -214,7 +246,7 @@ func RunService(ctx context.Context) error {
 	defer hb.Close()
 
 	// Start HTTP server in a goroutine
-	server := &http.Server{Handler: responder}
+	server := &http.Server{Handler: mux}
 	go func() {
 		log.Info("starting HTTP server for ACME challenges", "addr", addr)
 		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
@@ -222,8 +254,19 @@ func RunService(ctx context.Context) error {
 		}
 	}()
 
+	// Only the leader orders certificates so that running multiple
+	// instances does not order each certificate multiple times
+	isLeader := func() bool {
+		leader, err := discoverd.NewService("acme-challenge").Leader()
+		if err != nil {
+			log.Debug("error getting acme-challenge leader", "err", err)
+			return false
+		}
+		return leader.Addr == hb.Addr()
+	}
+
 	// Run the main service loop that polls for configuration
-	runServiceLoop(ctx, client, responder, log)
+	runServiceLoop(ctx, client, responder, metrics, isLeader, log)
 
 	log.Info("shutting down HTTP server")
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -233,10 +276,12 @@ func RunService(ctx context.Context) error {
 	return nil
 }
 
-// runServiceLoop polls for ACME configuration and manages the ACME service lifecycle
-func runServiceLoop(ctx context.Context, client controller.Client, responder *Responder, log log15.Logger) {
+// runServiceLoop polls for ACME configuration and leadership and manages the
+// ACME service lifecycle, running the service while this instance is the
+// leader and ACME is enabled
+func runServiceLoop(ctx context.Context, client controller.Client, responder *Responder, metrics *Metrics, isLeader func() bool, log log15.Logger) {
 	var service *Service
-	var currentKeyID string
+	var currentKeyID, currentDNSWebhook string
 	ticker := time.NewTicker(configPollInterval)
 	defer ticker.Stop()
 
@@ -260,9 +305,21 @@ func runServiceLoop(ctx context.Context, client controller.Client, responder *Re
 			return
 		}
 
-		// Check if configuration changed (different account key)
+		if !isLeader() {
+			if service != nil {
+				log.Info("no longer the leader, stopping service")
+				service.Stop()
+				service = nil
+				currentKeyID = ""
+			}
+			return
+		}
+
+		// Check if configuration changed (different account key or DNS
+		// webhook)
 		keyID := account.KeyID()
-		if service != nil && keyID == currentKeyID {
+		dnsWebhook := config.DNSWebhookURL + " " + config.DNSWebhookToken
+		if service != nil && keyID == currentKeyID && dnsWebhook == currentDNSWebhook {
 			// Check if the service has stopped unexpectedly
 			if service.Stopped() {
 				log.Warn("ACME service stopped unexpectedly, will restart")
@@ -299,8 +356,13 @@ func runServiceLoop(ctx context.Context, client controller.Client, responder *Re
 			log.Error("error initializing ACME service", "err", err)
 			return
 		}
+		service.metrics = metrics
+		if config.DNSWebhookURL != "" {
+			service.dns = NewDNSWebhook(config.DNSWebhookURL, config.DNSWebhookToken)
+		}
 
 		currentKeyID = keyID
+		currentDNSWebhook = dnsWebhook
 		log.Info("starting ACME service", "key_id", keyID)
 		go service.Run()
 	}
@@ -309,7 +371,7 @@ func runServiceLoop(ctx context.Context, client controller.Client, responder *Re
 	log.Info("checking ACME configuration")
 	checkConfig()
 	if service == nil {
-		log.Info("ACME not configured, running in standby mode - configure with 'flynn-host acme configure'")
+		log.Info("ACME not configured or not the leader, running in standby mode - configure with 'flynn-host acme configure'")
 	}
 
 	for {
@@ -330,29 +392,60 @@ func runServiceLoop(ctx context.Context, client controller.Client, responder *Re
 func (s *Service) Run() {
 	defer close(s.done)
 	s.log.Info("starting ACME service - listening for managed certificates")
+	go s.refreshOCSPLoop()
 
+	// since is when the last certificate received was updated so that
+	// when the stream is reconnected, for example after the controller
+	// instance serving it dies, only certificates updated since then are
+	// streamed again
+	var since *time.Time
+	for {
+		err := s.streamCertificates(&since)
+		if err == nil {
+			return
+		}
+		s.log.Warn("managed certificate stream disconnected, reconnecting", "err", err, "since", since)
+		select {
+		case <-time.After(streamReconnectDelay):
+		case <-s.stop:
+			s.log.Info("stopping ACME service")
+			return
+		}
+	}
+}
+
+// streamCertificates handles the pending certificates received from the
+// managed certificate stream, updating since as they are received, until
+// either the stream disconnects, in which case the error is returned, or the
+// service is stopped.
+func (s *Service) streamCertificates(since **time.Time) error {
 	certs := make(chan *ct.ManagedCertificate)
-	stream, err := s.controller.StreamManagedCertificates(nil, certs)
+	stream, err := s.controller.StreamManagedCertificates(*since, certs)
 	if err != nil {
-		s.log.Error("error streaming managed certificates", "err", err)
-		return
+		return err
 	}
 	defer stream.Close()
 
-	s.log.Info("streaming managed certificates started successfully")
+	s.log.Info("streaming managed certificates started successfully", "since", *since)
 
 	for {
 		select {
 		case cert, ok := <-certs:
 			if !ok {
-				s.log.Error("certificate stream closed unexpectedly", "stream_err", stream.Err())
-				return
+				if err := stream.Err(); err != nil {
+					return err
+				}
+				return errors.New("stream closed")
 			}
 			if cert == nil {
 				s.log.Debug("received nil certificate from stream")
 				continue
 			}
+			if cert.UpdatedAt != nil && (*since == nil || cert.UpdatedAt.After(**since)) {
+				*since = cert.UpdatedAt
+			}
 			s.log.Info("received certificate from stream", "domain", cert.Domain, "status", cert.Status, "id", cert.ID)
+			s.trackIssued(cert)
 			if cert.Status != ct.ManagedCertificateStatusPending {
 				s.log.Debug("skipping non-pending certificate", "domain", cert.Domain, "status", cert.Status)
 				continue
@@ -369,7 +462,7 @@ func (s *Service) Run() {
 			go s.handleCertificate(cert)
 		case <-s.stop:
 			s.log.Info("stopping ACME service")
-			return
+			return nil
 		}
 	}
 }
@@ -399,15 +492,38 @@ func (s *Service) handleCertificate(cert *ct.ManagedCertificate) {
 	}()
 
 	log := s.log.New("domain", cert.Domain)
+	for {
+		// don't order the certificate until any rate limit window
+		// from a previous order has expired
+		if cert.RetryAfter != nil {
+			if wait := time.Until(*cert.RetryAfter); wait > 0 {
+				log.Info("waiting for ACME rate limit to expire", "retry_after", cert.RetryAfter)
+				select {
+				case <-time.After(wait):
+				case <-s.stop:
+					return
+				}
+			}
+		}
+		s.orderCertificate(cert, log)
+		if !rateLimited(cert) {
+			return
+		}
+	}
+}
+
+// orderCertificate orders a certificate for a managed certificate from the
+// ACME CA and updates the managed certificate with the result
+func (s *Service) orderCertificate(cert *ct.ManagedCertificate, log log15.Logger) {
 	log.Info("handling managed certificate")
+	start := time.Now()
+	s.metrics.OrderStarted(cert.ExpiresAt, start)
 
 	// Create a new order
 	order, err := s.client.NewOrder(s.account, []acmelib.Identifier{{Type: "dns", Value: cert.Domain}})
 	if err != nil {
 		log.Error("error creating ACME order", "err", err)
-		cert.Status = ct.ManagedCertificateStatusFailed
-		cert.AddError("order_error", err.Error())
-		s.controller.UpdateManagedCertificate(cert)
+		s.failOrBackoff(cert, "order_error", err)
 		return
 	}
 	cert.OrderURL = order.URL
@@ -418,39 +534,51 @@ func (s *Service) handleCertificate(cert *ct.ManagedCertificate) {
 		auth, err := s.client.FetchAuthorization(s.account, authURL)
 		if err != nil {
 			log.Error("error fetching authorization", "err", err)
-			cert.Status = ct.ManagedCertificateStatusFailed
-			cert.AddError("auth_error", err.Error())
-			s.controller.UpdateManagedCertificate(cert)
+			s.failOrBackoff(cert, "auth_error", err)
 			return
 		}
 
-		// Find HTTP-01 challenge
-		var challenge acmelib.Challenge
-		for _, c := range auth.Challenges {
-			if c.Type == acmelib.ChallengeTypeHTTP01 {
-				challenge = c
-				break
-			}
-		}
-		if challenge.URL == "" {
-			log.Error("no HTTP-01 challenge found")
-			cert.Status = ct.ManagedCertificateStatusFailed
-			cert.AddError("challenge_error", "no HTTP-01 challenge found")
-			s.controller.UpdateManagedCertificate(cert)
+		challenge, err := challengeForAuthorization(auth)
+		if err != nil {
+			log.Error("error finding challenge", "err", err)
+			s.fail(cert, "challenge_error", err.Error())
 			return
 		}
 
-		// Set up the challenge response using the key authorization from the challenge
+		// Set up the challenge response using the key authorization from the
+		// challenge, either as a DNS record for wildcard domains or served
+		// by the responder
 		keyAuth := challenge.Token + "." + s.account.Thumbprint
-		s.responder.SetChallenge(challenge.Token, keyAuth)
-		defer s.responder.RemoveChallenge(challenge.Token)
+		if challenge.Type == acmelib.ChallengeTypeDNS01 {
+			if s.dns == nil {
+				log.Error("no DNS webhook configured for DNS-01 challenge")
+				s.fail(cert, "challenge_error", "wildcard certificates require a DNS webhook to complete the DNS-01 challenge")
+				return
+			}
+			domain := auth.Identifier.Value
+			if err := s.dns.Present(domain, keyAuth); err != nil {
+				log.Error("error presenting DNS challenge", "err", err)
+				s.failOrBackoff(cert, "challenge_error", err)
+				return
+			}
+			defer func() {
+				if err := s.dns.CleanUp(domain, keyAuth); err != nil {
+					log.Error("error cleaning up DNS challenge", "err", err)
+				}
+			}()
+		} else {
+			if err := s.responder.SetChallenge(challenge.Token, keyAuth); err != nil {
+				log.Error("error storing challenge", "err", err)
+				s.failOrBackoff(cert, "challenge_error", err)
+				return
+			}
+			defer s.responder.RemoveChallenge(challenge.Token)
+		}
 
 		// Update the challenge
 		if _, err := s.client.UpdateChallenge(s.account, challenge); err != nil {
 			log.Error("error updating challenge", "err", err)
-			cert.Status = ct.ManagedCertificateStatusFailed
-			cert.AddError("challenge_error", err.Error())
-			s.controller.UpdateManagedCertificate(cert)
+			s.failOrBackoff(cert, "challenge_error", err)
 			return
 		}
 	}
@@ -459,9 +587,7 @@ func (s *Service) handleCertificate(cert *ct.ManagedCertificate) {
 	order, err = s.waitForOrder(order)
 	if err != nil {
 		log.Error("error waiting for order", "err", err)
-		cert.Status = ct.ManagedCertificateStatusFailed
-		cert.AddError("order_error", err.Error())
-		s.controller.UpdateManagedCertificate(cert)
+		s.failOrBackoff(cert, "order_error", err)
 		return
 	}
 
@@ -469,9 +595,7 @@ func (s *Service) handleCertificate(cert *ct.ManagedCertificate) {
 	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
 		log.Error("error generating private key", "err", err)
-		cert.Status = ct.ManagedCertificateStatusFailed
-		cert.AddError("key_error", err.Error())
-		s.controller.UpdateManagedCertificate(cert)
+		s.fail(cert, "key_error", err.Error())
 		return
 	}
 	csrTemplate := &x509.CertificateRequest{
@@ -480,17 +604,13 @@ func (s *Service) handleCertificate(cert *ct.ManagedCertificate) {
 	csrDER, err := x509.CreateCertificateRequest(rand.Reader, csrTemplate, privKey)
 	if err != nil {
 		log.Error("error creating CSR", "err", err)
-		cert.Status = ct.ManagedCertificateStatusFailed
-		cert.AddError("csr_error", err.Error())
-		s.controller.UpdateManagedCertificate(cert)
+		s.fail(cert, "csr_error", err.Error())
 		return
 	}
 	csr, err := x509.ParseCertificateRequest(csrDER)
 	if err != nil {
 		log.Error("error parsing CSR", "err", err)
-		cert.Status = ct.ManagedCertificateStatusFailed
-		cert.AddError("csr_error", err.Error())
-		s.controller.UpdateManagedCertificate(cert)
+		s.fail(cert, "csr_error", err.Error())
 		return
 	}
 
@@ -498,9 +618,7 @@ func (s *Service) handleCertificate(cert *ct.ManagedCertificate) {
 	order, err = s.client.FinalizeOrder(s.account, order, csr)
 	if err != nil {
 		log.Error("error finalizing order", "err", err)
-		cert.Status = ct.ManagedCertificateStatusFailed
-		cert.AddError("finalize_error", err.Error())
-		s.controller.UpdateManagedCertificate(cert)
+		s.failOrBackoff(cert, "finalize_error", err)
 		return
 	}
 
@@ -508,9 +626,7 @@ func (s *Service) handleCertificate(cert *ct.ManagedCertificate) {
 	certs, err := s.client.FetchCertificates(s.account, order.Certificate)
 	if err != nil {
 		log.Error("error fetching certificate", "err", err)
-		cert.Status = ct.ManagedCertificateStatusFailed
-		cert.AddError("fetch_error", err.Error())
-		s.controller.UpdateManagedCertificate(cert)
+		s.failOrBackoff(cert, "fetch_error", err)
 		return
 	}
 
@@ -518,9 +634,7 @@ func (s *Service) handleCertificate(cert *ct.ManagedCertificate) {
 	keyDER, err := x509.MarshalECPrivateKey(privKey)
 	if err != nil {
 		log.Error("error encoding private key", "err", err)
-		cert.Status = ct.ManagedCertificateStatusFailed
-		cert.AddError("key_error", err.Error())
-		s.controller.UpdateManagedCertificate(cert)
+		s.fail(cert, "key_error", err.Error())
 		return
 	}
 	keyPEM := pem.EncodeToMemory(&pem.Block{
@@ -547,13 +661,77 @@ func (s *Service) handleCertificate(cert *ct.ManagedCertificate) {
 	cert.Status = ct.ManagedCertificateStatusIssued
 	cert.Cert = string(certPEM)
 	cert.Key = string(keyPEM)
+	cert.RetryAfter = nil
+	cert.OCSPResponse = nil
+	if err := s.updateOCSP(cert, time.Now()); err != nil {
+		// the certificate is still usable without a stapled OCSP
+		// response, and fetching it is retried later
+		log.Warn("error fetching OCSP response", "err", err)
+	}
 	if err := s.controller.UpdateManagedCertificate(cert); err != nil {
 		log.Error("error updating managed certificate", "err", err)
+		s.metrics.OrderFailed("update_error")
 		return
 	}
+	s.metrics.OrderSucceeded(time.Since(start))
 	log.Info("certificate issued and route updated successfully")
 }
 
+// fail marks a managed certificate as failed with the given error and
+// records the failure
+func (s *Service) fail(cert *ct.ManagedCertificate, code, message string) {
+	cert.Status = ct.ManagedCertificateStatusFailed
+	cert.RetryAfter = nil
+	cert.AddError(code, message)
+	s.controller.UpdateManagedCertificate(cert)
+	s.metrics.OrderFailed(code)
+}
+
+// failOrBackoff fails a managed certificate because of an error returned by
+// the ACME CA, unless the CA rate limited the request, in which case the
+// certificate is left pending with a RetryAfter so that it is ordered again
+// once the rate limit window expires rather than failing permanently
+func (s *Service) failOrBackoff(cert *ct.ManagedCertificate, code string, err error) {
+	now := time.Now()
+	retryAfter, ok := rateLimitRetryAfter(err, now)
+	if !ok {
+		s.fail(cert, code, err.Error())
+		return
+	}
+	s.log.Warn("rate limited by ACME CA, backing off", "domain", cert.Domain, "retry_after", retryAfter, "err", err)
+	message := err.Error()
+	cert.Status = ct.ManagedCertificateStatusPending
+	cert.RetryAfter = &retryAfter
+	cert.LastError = &message
+	cert.LastErrorAt = &now
+	cert.AddError("rate_limited", message)
+	s.controller.UpdateManagedCertificate(cert)
+	s.metrics.OrderFailed("rate_limited")
+}
+
+// rateLimited returns whether the last order for a managed certificate was
+// rate limited and should be retried
+func rateLimited(cert *ct.ManagedCertificate) bool {
+	return cert.Status == ct.ManagedCertificateStatusPending && cert.RetryAfter != nil
+}
+
+// rateLimitRetryAfter returns when a request to the ACME CA may be retried if
+// err is a rate limit error. Let's Encrypt includes the time in the problem
+// detail (e.g. "retry after 2006-01-02 15:04:05 UTC"), otherwise
+// defaultRateLimitBackoff is used.
+func rateLimitRetryAfter(err error, now time.Time) (time.Time, bool) {
+	problem, ok := err.(acmelib.Problem)
+	if !ok || (problem.Type != rateLimitedProblemType && problem.Status != http.StatusTooManyRequests) {
+		return time.Time{}, false
+	}
+	if m := retryAfterPattern.FindStringSubmatch(problem.Detail); m != nil {
+		if t, err := time.Parse("2006-01-02 15:04:05", m[1]); err == nil && t.After(now) {
+			return t, true
+		}
+	}
+	return now.Add(defaultRateLimitBackoff), true
+}
+
 // waitForOrder waits for an order to be ready
 func (s *Service) waitForOrder(order acmelib.Order) (acmelib.Order, error) {
 	strategy := attempt.Strategy{
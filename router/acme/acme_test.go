@@ -0,0 +1,138 @@
+package acme
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	acmelib "github.com/eggsampler/acme/v3"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/stream"
+	router "github.com/flynn/flynn/router/types"
+	"github.com/inconshreveable/log15"
+)
+
+// fakeController serves each call to StreamManagedCertificates from the next
+// of its batches of certificates, after which the stream fails as it would
+// if the controller instance serving it died.
+type fakeController struct {
+	batches [][]*ct.ManagedCertificate
+	since   chan *time.Time
+	closed  chan struct{}
+}
+
+func (f *fakeController) StreamManagedCertificates(since *time.Time, output chan *ct.ManagedCertificate) (stream.Stream, error) {
+	select {
+	case f.since <- since:
+	case <-f.closed:
+	}
+	s := stream.New()
+	var batch []*ct.ManagedCertificate
+	if len(f.batches) > 0 {
+		batch, f.batches = f.batches[0], f.batches[1:]
+	}
+	go func() {
+		defer close(output)
+		for _, cert := range batch {
+			select {
+			case output <- cert:
+			case <-s.StopCh:
+				return
+			}
+		}
+		s.Error = errors.New("unexpected EOF")
+	}()
+	return s, nil
+}
+
+func (f *fakeController) UpdateManagedCertificate(cert *ct.ManagedCertificate) error { return nil }
+func (f *fakeController) CreateRoute(appID string, route *router.Route) error        { return nil }
+func (f *fakeController) DeleteRoute(appID string, routeID string) error             { return nil }
+
+func TestServiceResumesStream(t *testing.T) {
+	defer func(d time.Duration) { streamReconnectDelay = d }(streamReconnectDelay)
+	streamReconnectDelay = 10 * time.Millisecond
+
+	t1 := time.Now().Add(-time.Minute)
+	t2 := t1.Add(30 * time.Second)
+	controller := &fakeController{
+		batches: [][]*ct.ManagedCertificate{
+			{
+				{Domain: "b.example.com", Status: ct.ManagedCertificateStatusIssued, UpdatedAt: &t2},
+				{Domain: "a.example.com", Status: ct.ManagedCertificateStatusIssued, UpdatedAt: &t1},
+				{},
+			},
+		},
+		since:  make(chan *time.Time),
+		closed: make(chan struct{}),
+	}
+	log := log15.New()
+	log.SetHandler(log15.DiscardHandler())
+	s := &Service{
+		controller: controller,
+		handling:   make(map[string]struct{}),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+		log:        log,
+	}
+	go s.Run()
+	defer s.Stop()
+	defer close(controller.closed)
+
+	receiveSince := func() *time.Time {
+		select {
+		case since := <-controller.since:
+			return since
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for stream to connect")
+		}
+		return nil
+	}
+	if since := receiveSince(); since != nil {
+		t.Fatalf("expected initial stream to have no since, got %s", since)
+	}
+	// the reconnected stream resumes from the most recently updated
+	// certificate rather than the last one received
+	if since := receiveSince(); since == nil || !since.Equal(t2) {
+		t.Fatalf("expected reconnected stream to have since %s, got %v", t2, since)
+	}
+	if s.Stopped() {
+		t.Fatal("expected service to keep running after the stream disconnected")
+	}
+}
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		err      error
+		expected time.Time
+		limited  bool
+	}{
+		{
+			err: acmelib.Problem{
+				Type:   rateLimitedProblemType,
+				Status: http.StatusTooManyRequests,
+				Detail: "Error creating new order :: too many certificates already issued for exact set of domains: example.com: see https://letsencrypt.org/docs/rate-limits/, retry after 2024-01-03 08:30:00 UTC",
+			},
+			expected: time.Date(2024, 1, 3, 8, 30, 0, 0, time.UTC),
+			limited:  true,
+		},
+		{
+			err:      acmelib.Problem{Type: rateLimitedProblemType, Detail: "too many failed authorizations recently"},
+			expected: now.Add(defaultRateLimitBackoff),
+			limited:  true,
+		},
+		{
+			err: acmelib.Problem{Type: "urn:ietf:params:acme:error:unauthorized", Status: http.StatusForbidden},
+		},
+		{
+			err: errors.New("connection refused"),
+		},
+	} {
+		retryAfter, limited := rateLimitRetryAfter(test.err, now)
+		if limited != test.limited || !retryAfter.Equal(test.expected) {
+			t.Errorf("%s: expected %v %s, got %v %s", test.err, test.limited, test.expected, limited, retryAfter)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package acme
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	acmelib "github.com/eggsampler/acme/v3"
+)
+
+// dnsPropagationTimeout is how long to wait for a DNS-01 challenge record to
+// be visible before asking the CA to validate it anyway
+var dnsPropagationTimeout = 2 * time.Minute
+
+// dnsPropagationInterval is how often to check whether a DNS-01 challenge
+// record is visible
+var dnsPropagationInterval = 5 * time.Second
+
+// lookupTXT is used to check for DNS-01 challenge records, and is a variable
+// so that tests can replace it
+var lookupTXT = net.LookupTXT
+
+// DNSWebhook completes DNS-01 challenges by asking an external webhook to
+// create and remove the challenge TXT records, since the cluster does not
+// serve DNS for the domains it routes. Records are sent as JSON objects with
+// "fqdn" and "value" fields to <URL>/present and <URL>/cleanup.
+type DNSWebhook struct {
+	URL   string
+	Token string
+
+	client *http.Client
+}
+
+// NewDNSWebhook returns a DNSWebhook which sends requests to the given URL,
+// authenticated with token as a bearer token if it is set
+func NewDNSWebhook(url, token string) *DNSWebhook {
+	return &DNSWebhook{
+		URL:    strings.TrimSuffix(url, "/"),
+		Token:  token,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// dnsRecord is a DNS-01 challenge TXT record
+type dnsRecord struct {
+	FQDN  string `json:"fqdn"`
+	Value string `json:"value"`
+}
+
+// dns01Record returns the TXT record which completes the DNS-01 challenge for
+// the given domain, wildcard domains being validated with the record of the
+// parent domain
+func dns01Record(domain, keyAuth string) dnsRecord {
+	return dnsRecord{
+		FQDN:  "_acme-challenge." + strings.TrimPrefix(domain, "*.") + ".",
+		Value: acmelib.EncodeDNS01KeyAuthorization(keyAuth),
+	}
+}
+
+// Present creates the DNS-01 challenge record for the given domain and waits
+// for it to be visible
+func (d *DNSWebhook) Present(domain, keyAuth string) error {
+	record := dns01Record(domain, keyAuth)
+	if err := d.post("/present", record); err != nil {
+		return err
+	}
+	waitForTXT(record)
+	return nil
+}
+
+// CleanUp removes the DNS-01 challenge record for the given domain
+func (d *DNSWebhook) CleanUp(domain, keyAuth string) error {
+	return d.post("/cleanup", dns01Record(domain, keyAuth))
+}
+
+func (d *DNSWebhook) post(path string, record dnsRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", d.URL+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if d.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+d.Token)
+	}
+	res, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling DNS webhook: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("DNS webhook %s returned status %d for %s", path, res.StatusCode, record.FQDN)
+	}
+	return nil
+}
+
+// waitForTXT waits until the given record is visible to the resolver, giving
+// up after dnsPropagationTimeout since the CA may use resolvers which see the
+// record sooner, and returns whether it was seen
+func waitForTXT(record dnsRecord) bool {
+	deadline := time.Now().Add(dnsPropagationTimeout)
+	for {
+		values, _ := lookupTXT(record.FQDN)
+		for _, v := range values {
+			if v == record.Value {
+				return true
+			}
+		}
+		if time.Now().Add(dnsPropagationInterval).After(deadline) {
+			return false
+		}
+		time.Sleep(dnsPropagationInterval)
+	}
+}
+
+// challengeForAuthorization returns the challenge to complete for the given
+// authorization, which is DNS-01 for wildcard domains since CAs do not allow
+// them to be validated over HTTP, and HTTP-01 otherwise
+func challengeForAuthorization(auth acmelib.Authorization) (acmelib.Challenge, error) {
+	typ := acmelib.ChallengeTypeHTTP01
+	if auth.Wildcard {
+		typ = acmelib.ChallengeTypeDNS01
+	}
+	for _, c := range auth.Challenges {
+		if c.Type == typ {
+			return c, nil
+		}
+	}
+	return acmelib.Challenge{}, fmt.Errorf("no %s challenge found", strings.ToUpper(typ))
+}
@@ -0,0 +1,108 @@
+package acme
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	acmelib "github.com/eggsampler/acme/v3"
+)
+
+func TestDNSWebhook(t *testing.T) {
+	defer func(f func(string) ([]string, error)) { lookupTXT = f }(lookupTXT)
+	defer func(d time.Duration) { dnsPropagationInterval = d }(dnsPropagationInterval)
+	dnsPropagationInterval = time.Millisecond
+
+	type request struct {
+		path   string
+		auth   string
+		record dnsRecord
+	}
+	requests := make(chan request, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var record dnsRecord
+		if err := json.NewDecoder(req.Body).Decode(&record); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		requests <- request{req.URL.Path, req.Header.Get("Authorization"), record}
+	}))
+	defer srv.Close()
+
+	expected := dnsRecord{
+		FQDN:  "_acme-challenge.example.com.",
+		Value: acmelib.EncodeDNS01KeyAuthorization("token.thumbprint"),
+	}
+	lookups := 0
+	lookupTXT = func(name string) ([]string, error) {
+		if name != expected.FQDN {
+			t.Fatalf("expected lookup of %s, got %s", expected.FQDN, name)
+		}
+		lookups++
+		if lookups < 3 {
+			return nil, nil
+		}
+		return []string{expected.Value}, nil
+	}
+
+	webhook := NewDNSWebhook(srv.URL+"/", "secret")
+	if err := webhook.Present("*.example.com", "token.thumbprint"); err != nil {
+		t.Fatal(err)
+	}
+	if lookups != 3 {
+		t.Fatalf("expected to wait for the record to be visible, got %d lookups", lookups)
+	}
+	if err := webhook.CleanUp("*.example.com", "token.thumbprint"); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/present", "/cleanup"} {
+		req := <-requests
+		if req.path != path {
+			t.Fatalf("expected request to %s, got %s", path, req.path)
+		}
+		if req.auth != "Bearer secret" {
+			t.Fatalf("expected bearer token, got %q", req.auth)
+		}
+		if req.record != expected {
+			t.Fatalf("expected record %+v, got %+v", expected, req.record)
+		}
+	}
+
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	if err := webhook.Present("example.com", "token.thumbprint"); err == nil {
+		t.Fatal("expected an error when the webhook fails")
+	}
+}
+
+func TestChallengeForAuthorization(t *testing.T) {
+	challenges := []acmelib.Challenge{
+		{Type: acmelib.ChallengeTypeHTTP01, URL: "http01"},
+		{Type: acmelib.ChallengeTypeDNS01, URL: "dns01"},
+	}
+	for _, x := range []struct {
+		auth acmelib.Authorization
+		url  string
+	}{
+		{acmelib.Authorization{Challenges: challenges}, "http01"},
+		{acmelib.Authorization{Challenges: challenges, Wildcard: true}, "dns01"},
+		{acmelib.Authorization{Challenges: challenges[:1], Wildcard: true}, ""},
+	} {
+		challenge, err := challengeForAuthorization(x.auth)
+		if x.url == "" {
+			if err == nil {
+				t.Fatal("expected an error when there is no DNS-01 challenge")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if challenge.URL != x.url {
+			t.Fatalf("expected challenge %s, got %s", x.url, challenge.URL)
+		}
+	}
+}
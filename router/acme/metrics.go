@@ -0,0 +1,149 @@
+package acme
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// issuanceLatencyBuckets are the upper bounds in seconds of the
+	// issuance latency histogram buckets
+	issuanceLatencyBuckets = []float64{5, 10, 30, 60, 120, 300, 600}
+
+	// renewalLeadTimeBuckets are the upper bounds in seconds of the
+	// renewal lead time histogram buckets, from one to ninety days
+	renewalLeadTimeBuckets = []float64{86400, 3 * 86400, 7 * 86400, 14 * 86400, 30 * 86400, 60 * 86400, 90 * 86400}
+)
+
+// Metrics records the outcome of certificate orders made by the ACME
+// service and exports them in the Prometheus text exposition format. A
+// single Metrics is shared by the services started as the ACME configuration
+// changes so that counters are not reset.
+type Metrics struct {
+	mtx             sync.Mutex
+	started         uint64
+	succeeded       uint64
+	failed          map[string]uint64
+	pending         int
+	issuanceLatency *histogram
+	renewalLeadTime *histogram
+}
+
+// NewMetrics returns a new Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		failed:          make(map[string]uint64),
+		issuanceLatency: newHistogram(issuanceLatencyBuckets),
+		renewalLeadTime: newHistogram(renewalLeadTimeBuckets),
+	}
+}
+
+// OrderStarted records that an order has been started for a certificate,
+// along with how long before it expires if the order renews an existing
+// certificate.
+func (m *Metrics) OrderStarted(expiresAt *time.Time, now time.Time) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.started++
+	m.pending++
+	if expiresAt != nil {
+		m.renewalLeadTime.Observe(expiresAt.Sub(now).Seconds())
+	}
+}
+
+// OrderSucceeded records that an order finished with an issued certificate
+// after the given duration.
+func (m *Metrics) OrderSucceeded(duration time.Duration) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.succeeded++
+	m.pending--
+	m.issuanceLatency.Observe(duration.Seconds())
+}
+
+// OrderFailed records that an order failed for the given reason, which is
+// the code of the error added to the managed certificate.
+func (m *Metrics) OrderFailed(reason string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.failed[reason]++
+	m.pending--
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	m.Write(bw)
+	bw.Flush()
+}
+
+// Write writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) Write(w io.Writer) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	writeMetricHeader(w, "flynn_acme_orders_started_total", "counter", "Number of certificate orders started.")
+	fmt.Fprintf(w, "flynn_acme_orders_started_total %d\n", m.started)
+	writeMetricHeader(w, "flynn_acme_orders_succeeded_total", "counter", "Number of certificate orders which issued a certificate.")
+	fmt.Fprintf(w, "flynn_acme_orders_succeeded_total %d\n", m.succeeded)
+
+	writeMetricHeader(w, "flynn_acme_orders_failed_total", "counter", "Number of certificate orders which failed, by reason.")
+	reasons := make([]string, 0, len(m.failed))
+	for reason := range m.failed {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "flynn_acme_orders_failed_total{reason=%q} %d\n", reason, m.failed[reason])
+	}
+
+	writeMetricHeader(w, "flynn_acme_orders_pending", "gauge", "Number of certificate orders in progress.")
+	fmt.Fprintf(w, "flynn_acme_orders_pending %d\n", m.pending)
+
+	writeMetricHeader(w, "flynn_acme_issuance_duration_seconds", "histogram", "Time taken from starting an order to the certificate being issued.")
+	m.issuanceLatency.Write(w, "flynn_acme_issuance_duration_seconds")
+	writeMetricHeader(w, "flynn_acme_renewal_lead_time_seconds", "histogram", "Time until the existing certificate expired when an order to renew it was started.")
+	m.renewalLeadTime.Write(w, "flynn_acme_renewal_lead_time_seconds")
+}
+
+func writeMetricHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// histogram is a Prometheus histogram with fixed buckets.
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) Observe(v float64) {
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) Write(w io.Writer, name string) {
+	for i, bound := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(bound, 'f', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
@@ -0,0 +1,42 @@
+package acme
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	now := time.Now()
+	expiresAt := now.Add(10 * 24 * time.Hour)
+	m.OrderStarted(nil, now)
+	m.OrderStarted(&expiresAt, now)
+	m.OrderStarted(nil, now)
+	m.OrderSucceeded(20 * time.Second)
+	m.OrderFailed("challenge_error")
+
+	var buf bytes.Buffer
+	m.Write(&buf)
+	out := buf.String()
+	for _, line := range []string{
+		"flynn_acme_orders_started_total 3",
+		"flynn_acme_orders_succeeded_total 1",
+		`flynn_acme_orders_failed_total{reason="challenge_error"} 1`,
+		"flynn_acme_orders_pending 1",
+		`flynn_acme_issuance_duration_seconds_bucket{le="10"} 0`,
+		`flynn_acme_issuance_duration_seconds_bucket{le="30"} 1`,
+		`flynn_acme_issuance_duration_seconds_bucket{le="+Inf"} 1`,
+		"flynn_acme_issuance_duration_seconds_sum 20",
+		"flynn_acme_issuance_duration_seconds_count 1",
+		`flynn_acme_renewal_lead_time_seconds_bucket{le="604800"} 0`,
+		`flynn_acme_renewal_lead_time_seconds_bucket{le="1209600"} 1`,
+		"flynn_acme_renewal_lead_time_seconds_count 1",
+		"# TYPE flynn_acme_issuance_duration_seconds histogram",
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package acme
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"golang.org/x/crypto/ocsp"
+)
+
+// ocspCheckInterval is how often issued certificates are checked for OCSP
+// responses which need refreshing
+var ocspCheckInterval = 10 * time.Minute
+
+// ocspRetryDelay is how long to wait before fetching an OCSP response again
+// after the responder returned an error
+const ocspRetryDelay = time.Hour
+
+// ocspMaxResponseSize limits how much of an OCSP response is read
+const ocspMaxResponseSize = 1 << 20
+
+var ocspHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// fetchOCSP fetches an OCSP response for the leaf certificate of the given
+// PEM-encoded chain from the OCSP responder of its issuer, returning the
+// DER-encoded response and when it should be refreshed, which is halfway
+// through its validity period so that an expired response is never stapled.
+func fetchOCSP(certPEM string, now time.Time) ([]byte, time.Time, error) {
+	var chain []*x509.Certificate
+	rest := []byte(certPEM)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		chain = append(chain, c)
+	}
+	if len(chain) < 2 {
+		return nil, time.Time{}, errors.New("certificate chain does not include the issuer")
+	}
+	leaf, issuer := chain[0], chain[1]
+	if len(leaf.OCSPServer) == 0 {
+		return nil, time.Time{}, errors.New("certificate does not include an OCSP server")
+	}
+
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	res, err := ocspHTTPClient.Post(leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("unexpected status from OCSP responder: %d", res.StatusCode)
+	}
+	raw, err := ioutil.ReadAll(io.LimitReader(res.Body, ocspMaxResponseSize))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	resp, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if resp.Status != ocsp.Good {
+		return nil, time.Time{}, fmt.Errorf("unexpected OCSP certificate status: %d", resp.Status)
+	}
+
+	refresh := now.Add(ocspRetryDelay)
+	if !resp.NextUpdate.IsZero() {
+		refresh = resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate) / 2)
+	}
+	return raw, refresh, nil
+}
+
+// updateOCSP fetches a new OCSP response for an issued certificate and sets
+// it on the certificate, leaving any previous response in place and
+// scheduling a retry if it cannot be fetched.
+func (s *Service) updateOCSP(cert *ct.ManagedCertificate, now time.Time) error {
+	raw, refresh, err := fetchOCSP(cert.Cert, now)
+	if err != nil {
+		retry := now.Add(ocspRetryDelay)
+		cert.OCSPNextUpdate = &retry
+		return err
+	}
+	cert.OCSPResponse = raw
+	cert.OCSPNextUpdate = &refresh
+	return nil
+}
+
+// trackIssued records the latest state of an issued certificate received
+// from the stream so that its OCSP response is refreshed, and stops
+// tracking certificates which are no longer issued.
+func (s *Service) trackIssued(cert *ct.ManagedCertificate) {
+	s.issuedMtx.Lock()
+	defer s.issuedMtx.Unlock()
+	if cert.Status != ct.ManagedCertificateStatusIssued || cert.Cert == "" {
+		delete(s.issued, cert.ID)
+		return
+	}
+	if s.issued == nil {
+		s.issued = make(map[string]*ct.ManagedCertificate)
+	}
+	s.issued[cert.ID] = cert
+}
+
+// ocspDue returns the issued certificates whose OCSP response is missing or
+// due to be refreshed.
+func (s *Service) ocspDue(now time.Time) []*ct.ManagedCertificate {
+	s.issuedMtx.Lock()
+	defer s.issuedMtx.Unlock()
+	var due []*ct.ManagedCertificate
+	for _, cert := range s.issued {
+		if cert.OCSPNextUpdate == nil || !now.Before(*cert.OCSPNextUpdate) {
+			due = append(due, cert)
+		}
+	}
+	return due
+}
+
+// refreshOCSP refreshes the OCSP responses of issued certificates which are
+// due and updates them in the controller, which passes the new responses to
+// the router in route events.
+func (s *Service) refreshOCSP(now time.Time) {
+	for _, cert := range s.ocspDue(now) {
+		log := s.log.New("domain", cert.Domain)
+		updated := *cert
+		if err := s.updateOCSP(&updated, now); err != nil {
+			// keep stapling the previous response, which is still
+			// valid, and try again later
+			log.Warn("error refreshing OCSP response", "err", err, "retry_at", updated.OCSPNextUpdate)
+			s.trackIssued(&updated)
+			continue
+		}
+		log.Info("refreshed OCSP response", "next_update", updated.OCSPNextUpdate)
+		if err := s.controller.UpdateManagedCertificate(&updated); err != nil {
+			log.Error("error updating managed certificate OCSP response", "err", err)
+			continue
+		}
+		s.trackIssued(&updated)
+	}
+}
+
+// refreshOCSPLoop periodically refreshes OCSP responses until the service is
+// stopped.
+func (s *Service) refreshOCSPLoop() {
+	ticker := time.NewTicker(ocspCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.refreshOCSP(time.Now())
+		case <-s.stop:
+			return
+		}
+	}
+}
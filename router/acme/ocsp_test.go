@@ -0,0 +1,201 @@
+package acme
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/inconshreveable/log15"
+	"golang.org/x/crypto/ocsp"
+)
+
+// ocspResponder is an OCSP responder for certificates issued by a test CA
+type ocspResponder struct {
+	*httptest.Server
+	issuer     *x509.Certificate
+	key        crypto.Signer
+	status     int
+	thisUpdate time.Time
+	nextUpdate time.Time
+}
+
+func newOCSPResponder(t *testing.T) *ocspResponder {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &ocspResponder{issuer: issuer, key: key, status: ocsp.Good}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.ServeHTTP))
+	return r
+}
+
+func (r *ocspResponder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	ocspReq, err := ocsp.ParseRequest(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res, err := ocsp.CreateResponse(r.issuer, r.issuer, ocsp.Response{
+		Status:       r.status,
+		SerialNumber: ocspReq.SerialNumber,
+		ThisUpdate:   r.thisUpdate,
+		NextUpdate:   r.nextUpdate,
+		RevokedAt:    r.thisUpdate,
+	}, r.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(res)
+}
+
+// issue returns a PEM-encoded chain of a leaf certificate signed by the
+// responder's CA followed by the CA certificate
+func (r *ocspResponder) issue(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		OCSPServer:   []string{r.URL},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, r.issuer, key.Public(), r.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: r.issuer.Raw})
+	return buf.String()
+}
+
+type ocspController struct {
+	fakeController
+	updated []*ct.ManagedCertificate
+}
+
+func (o *ocspController) UpdateManagedCertificate(cert *ct.ManagedCertificate) error {
+	o.updated = append(o.updated, cert)
+	return nil
+}
+
+func TestFetchOCSP(t *testing.T) {
+	responder := newOCSPResponder(t)
+	defer responder.Close()
+	now := time.Now().Truncate(time.Second)
+	responder.thisUpdate = now.Add(-time.Hour)
+	responder.nextUpdate = now.Add(95 * time.Hour)
+	certPEM := responder.issue(t)
+
+	raw, refresh, err := fetchOCSP(certPEM, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ocsp.ParseResponse(raw, responder.issuer); err != nil {
+		t.Fatalf("error parsing fetched OCSP response: %s", err)
+	}
+	// the response is refreshed halfway through its validity period
+	if expected := now.Add(47 * time.Hour); !refresh.Equal(expected) {
+		t.Fatalf("expected refresh at %s, got %s", expected, refresh)
+	}
+
+	// revoked certificates are not stapled
+	responder.status = ocsp.Revoked
+	if _, _, err := fetchOCSP(certPEM, now); err == nil {
+		t.Fatal("expected error fetching OCSP response for revoked certificate")
+	}
+
+	// the chain must include the issuer to build the request
+	block, _ := pem.Decode([]byte(certPEM))
+	if _, _, err := fetchOCSP(string(pem.EncodeToMemory(block)), now); err == nil {
+		t.Fatal("expected error fetching OCSP response without issuer")
+	}
+}
+
+func TestRefreshOCSP(t *testing.T) {
+	responder := newOCSPResponder(t)
+	defer responder.Close()
+	now := time.Now().Truncate(time.Second)
+	responder.thisUpdate = now
+	responder.nextUpdate = now.Add(4 * 24 * time.Hour)
+
+	controller := &ocspController{}
+	log := log15.New()
+	log.SetHandler(log15.DiscardHandler())
+	s := &Service{controller: controller, log: log}
+
+	later := now.Add(time.Hour)
+	certPEM := responder.issue(t)
+	s.trackIssued(&ct.ManagedCertificate{ID: "due", Domain: "example.com", Status: ct.ManagedCertificateStatusIssued, Cert: certPEM})
+	s.trackIssued(&ct.ManagedCertificate{ID: "fresh", Domain: "example.com", Status: ct.ManagedCertificateStatusIssued, Cert: certPEM, OCSPNextUpdate: &later})
+	s.trackIssued(&ct.ManagedCertificate{ID: "pending", Domain: "example.com", Status: ct.ManagedCertificateStatusPending})
+
+	s.refreshOCSP(now)
+	if len(controller.updated) != 1 {
+		t.Fatalf("expected 1 certificate to be updated, got %d", len(controller.updated))
+	}
+	cert := controller.updated[0]
+	if cert.ID != "due" || len(cert.OCSPResponse) == 0 {
+		t.Fatalf("expected OCSP response for certificate %q, got %q with %d bytes", "due", cert.ID, len(cert.OCSPResponse))
+	}
+	if expected := now.Add(2 * 24 * time.Hour); cert.OCSPNextUpdate == nil || !cert.OCSPNextUpdate.Equal(expected) {
+		t.Fatalf("expected next update at %s, got %v", expected, cert.OCSPNextUpdate)
+	}
+
+	// the refreshed certificate is no longer due, and when the responder
+	// fails the retry is scheduled without updating the controller
+	responder.status = ocsp.Revoked
+	s.refreshOCSP(now)
+	if len(controller.updated) != 1 {
+		t.Fatalf("expected no further updates, got %d", len(controller.updated))
+	}
+	s.refreshOCSP(later)
+	if len(controller.updated) != 1 {
+		t.Fatalf("expected failed refresh not to update the controller, got %d updates", len(controller.updated))
+	}
+	if due := s.ocspDue(later); len(due) != 0 {
+		t.Fatalf("expected failed refresh to be retried later, got %d due", len(due))
+	}
+
+	// certificates which are no longer issued are not refreshed
+	s.trackIssued(&ct.ManagedCertificate{ID: "due", Status: ct.ManagedCertificateStatusFailed})
+	if _, ok := s.issued["due"]; ok {
+		t.Fatal("expected failed certificate to stop being tracked")
+	}
+}
@@ -5,46 +5,82 @@ import (
 	"strings"
 	"sync"
 
+	ct "github.com/flynn/flynn/controller/types"
 	"github.com/inconshreveable/log15"
 )
 
+// ChallengeStore stores pending challenges outside of the service so that
+// they can be responded to by any instance, including one which has started
+// since the challenge was set
+type ChallengeStore interface {
+	PutACMEChallenge(challenge *ct.ACMEChallenge) error
+	GetACMEChallenge(token string) (*ct.ACMEChallenge, error)
+	DeleteACMEChallenge(token string) error
+}
+
 // Responder handles HTTP-01 ACME challenges
 type Responder struct {
 	challenges map[string]string
+	store      ChallengeStore
 	mtx        sync.RWMutex
 	log        log15.Logger
 }
 
-// NewResponder creates a new Responder
-func NewResponder(log log15.Logger) *Responder {
+// NewResponder creates a new Responder which shares challenges with other
+// instances using the given store, or only responds to challenges set on
+// itself if store is nil
+func NewResponder(store ChallengeStore, log log15.Logger) *Responder {
 	return &Responder{
 		challenges: make(map[string]string),
+		store:      store,
 		log:        log.New("component", "acme-responder"),
 	}
 }
 
 // SetChallenge sets a challenge token and key authorization
-func (r *Responder) SetChallenge(token, keyAuth string) {
+func (r *Responder) SetChallenge(token, keyAuth string) error {
+	if r.store != nil {
+		if err := r.store.PutACMEChallenge(&ct.ACMEChallenge{Token: token, KeyAuthorization: keyAuth}); err != nil {
+			return err
+		}
+	}
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
 	r.challenges[token] = keyAuth
 	r.log.Info("challenge set", "token", token)
+	return nil
 }
 
 // RemoveChallenge removes a challenge token
 func (r *Responder) RemoveChallenge(token string) {
 	r.mtx.Lock()
-	defer r.mtx.Unlock()
 	delete(r.challenges, token)
+	r.mtx.Unlock()
+	if r.store != nil {
+		if err := r.store.DeleteACMEChallenge(token); err != nil {
+			r.log.Error("error removing challenge from store", "token", token, "err", err)
+		}
+	}
 	r.log.Info("challenge removed", "token", token)
 }
 
-// GetChallenge returns the key authorization for a token
+// GetChallenge returns the key authorization for a token, looking it up in
+// the store if it was not set on this instance
 func (r *Responder) GetChallenge(token string) (string, bool) {
 	r.mtx.RLock()
-	defer r.mtx.RUnlock()
 	keyAuth, ok := r.challenges[token]
-	return keyAuth, ok
+	r.mtx.RUnlock()
+	if ok || r.store == nil {
+		return keyAuth, ok
+	}
+	challenge, err := r.store.GetACMEChallenge(token)
+	if err != nil {
+		if err != ct.ErrNotFound {
+			r.log.Error("error getting challenge from store", "token", token, "err", err)
+		}
+		return "", false
+	}
+	return challenge.KeyAuthorization, true
 }
 
 // ServeHTTP handles HTTP-01 challenge requests
@@ -56,6 +92,10 @@ func (r *Responder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 
 	token := strings.TrimPrefix(req.URL.Path, "/.well-known/acme-challenge/")
+	if !validToken(token) {
+		http.NotFound(w, req)
+		return
+	}
 	keyAuth, ok := r.GetChallenge(token)
 	if !ok {
 		r.log.Warn("challenge not found", "token", token)
@@ -68,3 +108,19 @@ func (r *Responder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	w.Write([]byte(keyAuth))
 }
 
+// validToken checks that a token only contains base64url characters, as
+// required by RFC 8555, so that requests cannot look up arbitrary paths in
+// the challenge store
+func validToken(token string) bool {
+	if token == "" {
+		return false
+	}
+	for _, c := range token {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
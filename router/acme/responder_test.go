@@ -0,0 +1,73 @@
+package acme
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/inconshreveable/log15"
+)
+
+type memoryChallengeStore struct {
+	mtx        sync.Mutex
+	challenges map[string]string
+}
+
+func (m *memoryChallengeStore) PutACMEChallenge(challenge *ct.ACMEChallenge) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.challenges[challenge.Token] = challenge.KeyAuthorization
+	return nil
+}
+
+func (m *memoryChallengeStore) GetACMEChallenge(token string) (*ct.ACMEChallenge, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	keyAuth, ok := m.challenges[token]
+	if !ok {
+		return nil, ct.ErrNotFound
+	}
+	return &ct.ACMEChallenge{Token: token, KeyAuthorization: keyAuth}, nil
+}
+
+func (m *memoryChallengeStore) DeleteACMEChallenge(token string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	delete(m.challenges, token)
+	return nil
+}
+
+func TestResponderSharedStore(t *testing.T) {
+	log := log15.New()
+	log.SetHandler(log15.DiscardHandler())
+	store := &memoryChallengeStore{challenges: make(map[string]string)}
+	r1 := NewResponder(store, log)
+	r2 := NewResponder(store, log)
+
+	get := func(r *Responder, path string) (int, string) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code, w.Body.String()
+	}
+
+	if err := r1.SetChallenge("tok_en-1", "tok_en-1.thumb"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the challenge is served by the instance which did not set it
+	if code, body := get(r2, "/.well-known/acme-challenge/tok_en-1"); code != http.StatusOK || body != "tok_en-1.thumb" {
+		t.Fatalf("expected challenge to be served, got %d %q", code, body)
+	}
+
+	// tokens which are not base64url are not looked up
+	if code, _ := get(r2, "/.well-known/acme-challenge/../apps"); code != http.StatusNotFound {
+		t.Fatalf("expected 404 for invalid token, got %d", code)
+	}
+
+	r1.RemoveChallenge("tok_en-1")
+	if code, _ := get(r2, "/.well-known/acme-challenge/tok_en-1"); code != http.StatusNotFound {
+		t.Fatalf("expected 404 after removing challenge, got %d", code)
+	}
+}
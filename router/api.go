@@ -25,6 +25,7 @@ func apiHandler(rtr *Router) http.Handler {
 	r.HandlerFunc("GET", status.Path, status.HealthyHandler.ServeHTTP)
 
 	r.GET("/events", httphelper.WrapHandler(api.StreamEvents))
+	r.GET("/metrics/backends", httphelper.WrapHandler(api.GetBackendMetrics))
 
 	r.HandlerFunc("GET", "/debug/*path", pprof.Handler.ServeHTTP)
 
@@ -72,3 +73,13 @@ func (api *API) StreamEvents(ctx context.Context, w http.ResponseWriter, req *ht
 	go sendEvents(tcpEvents)
 	sse.ServeStream(w, sseEvents, log)
 }
+
+// GetBackendMetrics returns the connection and circuit breaker state of the
+// backends of every routed service.
+func (api *API) GetBackendMetrics(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	metrics := append(api.router.HTTP.BackendMetrics(), api.router.TCP.BackendMetrics()...)
+	if metrics == nil {
+		metrics = []*router.BackendMetrics{}
+	}
+	httphelper.JSON(w, 200, metrics)
+}
@@ -52,6 +52,10 @@ func NewWithAddr(addr string) Client {
 type Client interface {
 	// StreamEvents streams router events with the given options
 	StreamEvents(opts *router.StreamEventsOptions, output chan *router.StreamEvent) (stream.Stream, error)
+
+	// BackendMetrics returns the connection and circuit breaker metrics
+	// for the backends of every routed service
+	BackendMetrics() ([]*router.BackendMetrics, error)
 }
 
 func (c *client) StreamEvents(opts *router.StreamEventsOptions, output chan *router.StreamEvent) (stream.Stream, error) {
@@ -66,3 +70,8 @@ func (c *client) StreamEvents(opts *router.StreamEventsOptions, output chan *rou
 	}
 	return c.ResumingStream("GET", "/events?types="+strings.Join(types, ","), output)
 }
+
+func (c *client) BackendMetrics() ([]*router.BackendMetrics, error) {
+	var res []*router.BackendMetrics
+	return res, c.Get("/metrics/backends", &res)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/flynn/flynn/pkg/certgen"
+	router "github.com/flynn/flynn/router/types"
+	. "github.com/flynn/go-check"
+)
+
+type ClientCertSuite struct{}
+
+var _ = Suite(&ClientCertSuite{})
+
+// generateClientCert generates a client certificate with the given common
+// name signed by ca.
+func generateClientCert(c *C, ca *certgen.Certificate, name string, usage x509.ExtKeyUsage) *x509.Certificate {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+	parent, err := x509.ParseCertificate(ca.DER)
+	c.Assert(err, IsNil)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name, Organization: []string{"Example"}},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, ca.Key)
+	c.Assert(err, IsNil)
+	cert, err := x509.ParseCertificate(der)
+	c.Assert(err, IsNil)
+	return cert
+}
+
+func (ClientCertSuite) TestVerifyClientCert(c *C) {
+	ca, err := certgen.Generate(certgen.Params{IsCA: true})
+	c.Assert(err, IsNil)
+	otherCA, err := certgen.Generate(certgen.Params{IsCA: true})
+	c.Assert(err, IsNil)
+
+	pool, err := router.ParseClientCA(ca.PEM)
+	c.Assert(err, IsNil)
+	r := &httpRoute{clientCAs: pool}
+
+	request := func(certs ...*x509.Certificate) *http.Request {
+		req := httptest.NewRequest("GET", "https://example.com/", nil)
+		req.TLS = &tls.ConnectionState{PeerCertificates: certs}
+		return req
+	}
+
+	cert, err := r.verifyClientCert(request(generateClientCert(c, ca, "client", x509.ExtKeyUsageClientAuth)))
+	c.Assert(err, IsNil)
+	c.Assert(cert.Subject.String(), Equals, "CN=client,O=Example")
+
+	// certificates signed by another CA, certificates which can't be used
+	// for client authentication, and missing certificates are rejected
+	for _, req := range []*http.Request{
+		request(generateClientCert(c, otherCA, "client", x509.ExtKeyUsageClientAuth)),
+		request(generateClientCert(c, ca, "server", x509.ExtKeyUsageServerAuth)),
+		request(),
+		httptest.NewRequest("GET", "http://example.com/", nil),
+	} {
+		_, err := r.verifyClientCert(req)
+		c.Assert(err, NotNil)
+	}
+
+	// requests without a valid certificate are forbidden
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, request())
+	c.Assert(w.Code, Equals, http.StatusForbidden)
+}
+
+func (ClientCertSuite) TestParseClientCA(c *C) {
+	_, err := router.ParseClientCA("not a certificate")
+	c.Assert(err, NotNil)
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"log"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/http/httputil"
@@ -35,6 +37,9 @@ type HTTPListener struct {
 
 	LegacyTLSVersions bool
 
+	// TLSPolicy is the default TLS policy, which routes may override
+	TLSPolicy *router.TLSPolicy
+
 	defaultPorts []int
 
 	mtx      sync.RWMutex
@@ -61,6 +66,10 @@ type HTTPListener struct {
 
 	// ACME challenge service for automatic TLS certificates
 	acmeService *cache.ServiceCache
+
+	// ticketKeys, if set, shares TLS session ticket keys with other
+	// router instances
+	ticketKeys *ticketKeyManager
 }
 
 type DiscoverdClient interface {
@@ -75,6 +84,9 @@ func (s *HTTPListener) Close() error {
 		return nil
 	}
 	s.stopSync()
+	if s.ticketKeys != nil {
+		s.ticketKeys.Close()
+	}
 	for _, service := range s.services {
 		service.sc.Close()
 	}
@@ -119,6 +131,16 @@ func (s *HTTPListener) Start() error {
 		return err
 	}
 
+	if s.ticketKeys != nil {
+		// fall back to the per-process keys generated by crypto/tls if
+		// the shared keys can't be loaded, they'll be applied once a
+		// later sync succeeds
+		if err := s.ticketKeys.Sync(); err != nil {
+			logger.Error("error syncing TLS session ticket keys", "err", err)
+		}
+		go s.ticketKeys.Run()
+	}
+
 	if err := s.startListen(); err != nil {
 		s.Close()
 		return err
@@ -221,9 +243,21 @@ func (h *httpSyncHandler) Set(data *router.Route) error {
 		if err != nil {
 			return err
 		}
+		kp.OCSPStaple = cert.OCSPResponse
 		r.keypair = &kp
 		r.Certificate = nil
 	}
+	if err := r.TLSPolicy.Validate(); err != nil {
+		logger.Error("ignoring invalid route TLS policy", "route", data.ID, "err", err)
+		r.TLSPolicy = nil
+	}
+	if r.ClientCA != "" {
+		pool, err := router.ParseClientCA(r.ClientCA)
+		if err != nil {
+			return err
+		}
+		r.clientCAs = pool
+	}
 
 	h.l.mtx.Lock()
 	defer h.l.mtx.Unlock()
@@ -231,41 +265,35 @@ func (h *httpSyncHandler) Set(data *router.Route) error {
 		return nil
 	}
 
-	service := h.l.services[r.Service]
-	if service != nil && service.name != r.Service {
-		service.refs--
-		if service.refs <= 0 {
-			service.Close()
-			delete(h.l.services, service.name)
-		}
-		service = nil
+	service, err := h.acquireService(r.Service, r.DrainBackends)
+	if err != nil {
+		return err
 	}
-	if service == nil {
-		sc, err := cache.New(h.l.discoverd.Service(r.Service))
+	r.service = service
+	instances := service.sc.Instances
+	if r.Leader {
+		instances = service.sc.Leader
+	} else if releases := splitReleases(r.Service, r.Splits); len(releases) > 0 {
+		instances = excludeReleases(instances, releases)
+	}
+	r.rp = h.newReverseProxy(r, service, instances)
+	for _, split := range r.Splits {
+		s, err := h.acquireService(split.Service, r.DrainBackends)
 		if err != nil {
+			h.releaseServices(r)
 			return err
 		}
-
-		service = newService(r.Service, sc, h.l.wm, r.DrainBackends)
-		h.l.services[r.Service] = service
-	}
-	service.refs++
-	var bf proxy.BackendListFunc
-	if r.Leader {
-		bf = backendFunc(r.Service, service.sc.Leader)
-	} else {
-		bf = backendFunc(r.Service, service.sc.Instances)
+		instances := s.sc.Instances
+		if split.Release != "" {
+			instances = releaseInstances(instances, split.Release)
+		}
+		r.splits = append(r.splits, &routeSplit{
+			weight:   split.Weight,
+			service:  s,
+			backends: instances,
+			rp:       h.newReverseProxy(r, s, instances),
+		})
 	}
-	r.rp = proxy.NewReverseProxy(proxy.ReverseProxyConfig{
-		BackendListFunc:   bf,
-		StickyKey:         h.l.cookieKey,
-		Sticky:            r.Sticky,
-		DisableKeepAlives: r.DisableKeepAlives,
-		RequestTracker:    service,
-		Logger:            logger.New("service", r.Service),
-	})
-	r.rp.Error503Page = h.l.error503Page
-	r.service = service
 	h.l.routes[data.ID] = r
 	domain := net.JoinHostPort(strings.ToLower(r.Domain), strconv.Itoa(r.Port))
 	if data.Path == "/" {
@@ -286,6 +314,56 @@ func (h *httpSyncHandler) Set(data *router.Route) error {
 	return nil
 }
 
+// acquireService returns the service with the given name, watching it in
+// discoverd if no other route uses it. It must be called with h.l.mtx held.
+func (h *httpSyncHandler) acquireService(name string, drainBackends bool) (*service, error) {
+	s, ok := h.l.services[name]
+	if !ok {
+		sc, err := cache.New(h.l.discoverd.Service(name))
+		if err != nil {
+			return nil, err
+		}
+		s = newService(name, sc, h.l.wm, drainBackends)
+		h.l.services[name] = s
+	}
+	s.refs++
+	return s, nil
+}
+
+// releaseServices releases the services acquired for a route, closing those
+// which are no longer used by any route. It must be called with h.l.mtx
+// held.
+func (h *httpSyncHandler) releaseServices(r *httpRoute) {
+	release := func(s *service) {
+		s.refs--
+		if s.refs <= 0 {
+			s.Close()
+			delete(h.l.services, s.name)
+		}
+	}
+	release(r.service)
+	for _, split := range r.splits {
+		release(split.service)
+	}
+}
+
+// newReverseProxy returns a proxy for the route's requests to the given
+// instances of a service.
+func (h *httpSyncHandler) newReverseProxy(r *httpRoute, s *service, instances func() []*discoverd.Instance) *proxy.ReverseProxy {
+	rp := proxy.NewReverseProxy(proxy.ReverseProxyConfig{
+		BackendListFunc:   backendFunc(s.name, instances),
+		StickyKey:         h.l.cookieKey,
+		Sticky:            r.Sticky,
+		DisableKeepAlives: r.DisableKeepAlives,
+		HTTP2:             r.HTTP2,
+		RequestTracker:    s,
+		CircuitBreaker:    s.breaker,
+		Logger:            logger.New("service", s.name),
+	})
+	rp.Error503Page = h.l.error503Page
+	return rp
+}
+
 func (h *httpSyncHandler) Remove(id string) error {
 	h.l.mtx.Lock()
 	defer h.l.mtx.Unlock()
@@ -297,11 +375,7 @@ func (h *httpSyncHandler) Remove(id string) error {
 		return ErrNotFound
 	}
 
-	r.service.refs--
-	if r.service.refs <= 0 {
-		r.service.sc.Close()
-		delete(h.l.services, r.service.name)
-	}
+	h.releaseServices(r)
 
 	delete(h.l.routes, id)
 	domain := net.JoinHostPort(r.Domain, strconv.Itoa(r.Port))
@@ -379,6 +453,32 @@ func (s *HTTPListener) listenAndServeTLS() error {
 		} else {
 			tlsConfig.MinVersion = tls.VersionTLS12
 		}
+		if err := s.TLSPolicy.Apply(tlsConfig); err != nil {
+			return err
+		}
+		if s.ticketKeys != nil {
+			s.ticketKeys.AddConfig(tlsConfig)
+		}
+		// use a copy of the config with the route's policy applied for
+		// routes which override the default policy or require client
+		// certificates
+		baseConfig := tlsConfig
+		tlsConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			r := s.findRoute(hello.ServerName, port, "/")
+			if r == nil || r.TLSPolicy == nil && r.clientCAs == nil {
+				return nil, nil
+			}
+			config := baseConfig.Clone()
+			config.GetConfigForClient = nil
+			if err := r.TLSPolicy.Apply(config); err != nil {
+				return nil, err
+			}
+			if r.clientCAs != nil {
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+				config.ClientCAs = r.clientCAs
+			}
+			return config, nil
+		}
 
 		l, err := listenFunc("tcp4", addr)
 		if err != nil {
@@ -422,6 +522,18 @@ func (s *HTTPListener) listenAndServeTLS() error {
 	return nil
 }
 
+// BackendMetrics returns the connection and circuit breaker metrics for the
+// backends of each service with an HTTP route.
+func (s *HTTPListener) BackendMetrics() []*router.BackendMetrics {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	var metrics []*router.BackendMetrics
+	for _, service := range s.services {
+		metrics = append(metrics, service.breaker.Metrics()...)
+	}
+	return metrics
+}
+
 func (s *HTTPListener) findRoute(host string, portInt int, path string) *httpRoute {
 	host = strings.ToLower(host)
 	if strings.Contains(host, ":") {
@@ -482,10 +594,29 @@ func (s *HTTPListener) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		fail(w, 404)
 		return
 	}
+	if r.redirectsToHTTPS(req) {
+		s.redirectHTTPS(w, req)
+		return
+	}
 
 	r.ServeHTTP(w, req.WithContext(ctx))
 }
 
+// redirectHTTPS responds with a permanent redirect to the HTTPS version of
+// the requested URL, on the first TLS port if it isn't the default.
+func (s *HTTPListener) redirectHTTPS(w http.ResponseWriter, req *http.Request) {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if len(s.TLSAddrs) > 0 {
+		if port := mustPortFromAddr(s.TLSAddrs[0]); port != "443" {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), port)
+		}
+	}
+	http.Redirect(w, req, "https://"+host+req.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
 func (s *HTTPListener) serveACMEChallenge(w http.ResponseWriter, req *http.Request) {
 	// Lazily initialize the ACME service cache if needed
 	s.mtx.Lock()
@@ -526,7 +657,38 @@ type httpRoute struct {
 
 	keypair *tls.Certificate
 	service *service
-	rp      *proxy.ReverseProxy
+
+	// clientCAs is set for routes which require a client certificate
+	clientCAs *x509.CertPool
+	rp        *proxy.ReverseProxy
+
+	// splits send a percentage of the route's requests to other services
+	splits []*routeSplit
+}
+
+// routeSplit is a service which is sent a percentage of a route's requests.
+type routeSplit struct {
+	weight   int
+	service  *service
+	backends func() []*discoverd.Instance
+	rp       *proxy.ReverseProxy
+}
+
+// splitProxy returns the proxy for a request given n in the range
+// [0, MaxRouteSplitWeight), which selects a split by weight. Requests which
+// are not selected for a split, or are selected for a split whose service
+// has no instances, are sent to the route's service.
+func (r *httpRoute) splitProxy(n int) *proxy.ReverseProxy {
+	for _, split := range r.splits {
+		if n < split.weight {
+			if len(split.backends()) == 0 {
+				break
+			}
+			return split.rp
+		}
+		n -= split.weight
+	}
+	return r.rp
 }
 
 // A service definition: name, and set of backends.
@@ -538,13 +700,18 @@ type service struct {
 	stream stream.Stream
 	reqs   map[string]int64
 	cond   *sync.Cond
+
+	// breaker ejects backends after consecutive failures and is shared
+	// by all routes for the service
+	breaker *proxy.CircuitBreaker
 }
 
 func newService(name string, sc *cache.ServiceCache, wm *WatchManager, trackBackends bool) *service {
 	s := &service{
-		name: name,
-		sc:   sc,
-		wm:   wm,
+		name:    name,
+		sc:      sc,
+		wm:      wm,
+		breaker: proxy.NewCircuitBreaker(name, proxy.DefaultBreakerThreshold, proxy.DefaultBreakerCooldown),
 	}
 	if trackBackends {
 		events := make(chan *discoverd.Event)
@@ -631,7 +798,64 @@ func (r *httpRoute) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	req.Header.Set("X-Request-Start", strconv.FormatInt(start.UnixNano()/int64(time.Millisecond), 10))
 	setRequestID(req)
 
-	r.rp.ServeHTTP(w, req)
+	// never pass a client supplied subject header on to the backend
+	req.Header.Del(clientCertSubjectHeader)
+	if r.clientCAs != nil {
+		cert, err := r.verifyClientCert(req)
+		if err != nil {
+			fail(w, http.StatusForbidden)
+			return
+		}
+		req.Header.Set(clientCertSubjectHeader, cert.Subject.String())
+	}
+
+	// the route's HSTS header is set before the backend's response headers
+	// are added so that it takes precedence, as clients only use the first
+	if req.TLS != nil {
+		if hsts := r.TLSPolicy.HSTSHeader(); hsts != "" {
+			w.Header().Set("Strict-Transport-Security", hsts)
+		}
+	}
+
+	rp := r.rp
+	if len(r.splits) > 0 {
+		rp = r.splitProxy(rand.Intn(router.MaxRouteSplitWeight))
+	}
+	rp.ServeHTTP(w, req)
+}
+
+// redirectsToHTTPS returns whether a plaintext request should be redirected
+// to HTTPS, which is when the route has a manual or managed certificate.
+func (r *httpRoute) redirectsToHTTPS(req *http.Request) bool {
+	return r.RedirectHTTPS && r.keypair != nil && req.TLS == nil
+}
+
+// clientCertSubjectHeader is set to the subject DN of the verified client
+// certificate on requests to routes which require one.
+const clientCertSubjectHeader = "X-Client-Cert-Subject"
+
+// verifyClientCert checks that the request was made with a client
+// certificate signed by one of the route's client CAs, returning the verified
+// certificate. The certificate is verified for each request as well as during
+// the handshake since the handshake uses the client CAs of the domain's
+// default route, which may differ from those of a route for a path.
+func (r *httpRoute) verifyClientCert(req *http.Request) (*x509.Certificate, error) {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return nil, errors.New("no client certificate")
+	}
+	certs := req.TLS.PeerCertificates
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         r.clientCAs,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return nil, err
+	}
+	return certs[0], nil
 }
 
 func mustPortFromAddr(addr string) string {
@@ -651,6 +875,55 @@ func setRequestID(req *http.Request) {
 	}
 }
 
+// splitReleases returns the releases which have their own split of the
+// route's service, and so are not sent the route's other requests.
+func splitReleases(service string, splits []*router.RouteSplit) map[string]struct{} {
+	var releases map[string]struct{}
+	for _, split := range splits {
+		if split.Release == "" || split.Service != service {
+			continue
+		}
+		if releases == nil {
+			releases = make(map[string]struct{})
+		}
+		releases[split.Release] = struct{}{}
+	}
+	return releases
+}
+
+// releaseInstances returns a function which returns the instances returned by
+// f which belong to the given release.
+func releaseInstances(f func() []*discoverd.Instance, release string) func() []*discoverd.Instance {
+	return func() []*discoverd.Instance {
+		var res []*discoverd.Instance
+		for _, inst := range f() {
+			if inst.Meta["FLYNN_RELEASE_ID"] == release {
+				res = append(res, inst)
+			}
+		}
+		return res
+	}
+}
+
+// excludeReleases returns a function which returns the instances returned by
+// f which do not belong to the given releases, or all of them if they all
+// do, so that requests are not dropped once the other releases have gone.
+func excludeReleases(f func() []*discoverd.Instance, releases map[string]struct{}) func() []*discoverd.Instance {
+	return func() []*discoverd.Instance {
+		all := f()
+		res := make([]*discoverd.Instance, 0, len(all))
+		for _, inst := range all {
+			if _, ok := releases[inst.Meta["FLYNN_RELEASE_ID"]]; !ok {
+				res = append(res, inst)
+			}
+		}
+		if len(res) == 0 {
+			return all
+		}
+		return res
+	}
+}
+
 func backendFunc(service string, f func() []*discoverd.Instance) proxy.BackendListFunc {
 	return func() []*router.Backend {
 		instances := f()
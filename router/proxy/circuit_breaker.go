@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	router "github.com/flynn/flynn/router/types"
+)
+
+const (
+	// DefaultBreakerThreshold is the number of consecutive failures after
+	// which a backend is ejected.
+	DefaultBreakerThreshold = 5
+
+	// DefaultBreakerCooldown is how long an ejected backend is skipped
+	// before being tried again.
+	DefaultBreakerCooldown = 10 * time.Second
+
+	// breakerIdleTimeout is how long a backend can go without any requests
+	// before its stats are discarded, so backends which have gone away
+	// don't accumulate.
+	breakerIdleTimeout = 10 * time.Minute
+)
+
+// CircuitBreaker tracks request outcomes for the backends of a service and
+// ejects a backend after consecutive failures so that requests are not sent
+// to a job which is unhealthy but still registered. An ejected backend is
+// retried once its cooldown expires: a success closes the circuit and a
+// failure ejects it again.
+//
+// A CircuitBreaker is safe for concurrent use and is intended to be shared
+// by all proxies for a service.
+type CircuitBreaker struct {
+	service   string
+	threshold int
+	cooldown  time.Duration
+
+	mtx       sync.Mutex
+	backends  map[string]*backendCircuit
+	lastPrune time.Time
+
+	// now is overridden in tests
+	now func() time.Time
+}
+
+type backendCircuit struct {
+	inFlight            int64
+	requests            int64
+	failures            int64
+	consecutiveFailures int
+	ejections           int64
+	ejectedUntil        time.Time
+	lastUsed            time.Time
+}
+
+// NewCircuitBreaker returns a CircuitBreaker for the given service which
+// ejects a backend after threshold consecutive failures for the given
+// cooldown.
+func NewCircuitBreaker(service string, threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		service:   service,
+		threshold: threshold,
+		cooldown:  cooldown,
+		backends:  make(map[string]*backendCircuit),
+		now:       time.Now,
+	}
+}
+
+// state returns the circuit state of the backend at the given time.
+func (c *backendCircuit) state(now time.Time) router.CircuitState {
+	switch {
+	case c.ejectedUntil.IsZero():
+		return router.CircuitStateClosed
+	case now.Before(c.ejectedUntil):
+		return router.CircuitStateOpen
+	default:
+		return router.CircuitStateHalfOpen
+	}
+}
+
+// backend returns the circuit for the given address, creating it if
+// necessary, the lock must be held.
+func (b *CircuitBreaker) backend(addr string, now time.Time) *backendCircuit {
+	c, ok := b.backends[addr]
+	if !ok {
+		c = &backendCircuit{}
+		b.backends[addr] = c
+	}
+	c.lastUsed = now
+	return c
+}
+
+// Filter returns the backends which are not currently ejected. If every
+// backend is ejected then all of them are returned, since attempting an
+// ejected backend is better than failing the request outright.
+func (b *CircuitBreaker) Filter(backends []*router.Backend) []*router.Backend {
+	if b == nil || len(backends) == 0 {
+		return backends
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	now := b.now()
+	available := make([]*router.Backend, 0, len(backends))
+	for _, backend := range backends {
+		if c, ok := b.backends[backend.Addr]; ok && c.state(now) == router.CircuitStateOpen {
+			continue
+		}
+		available = append(available, backend)
+	}
+	if len(available) == 0 {
+		return backends
+	}
+	return available
+}
+
+// RequestStart records the start of a request to the given backend.
+func (b *CircuitBreaker) RequestStart(addr string) {
+	if b == nil {
+		return
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	c := b.backend(addr, b.now())
+	c.inFlight++
+	c.requests++
+}
+
+// RequestDone records the end of a request to the given backend.
+func (b *CircuitBreaker) RequestDone(addr string) {
+	if b == nil {
+		return
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if c, ok := b.backends[addr]; ok && c.inFlight > 0 {
+		c.inFlight--
+	}
+}
+
+// Success records a successful request to the given backend, closing its
+// circuit.
+func (b *CircuitBreaker) Success(addr string) {
+	if b == nil {
+		return
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	now := b.now()
+	c := b.backend(addr, now)
+	c.consecutiveFailures = 0
+	c.ejectedUntil = time.Time{}
+	b.prune(now)
+}
+
+// Failure records a failed request to the given backend, ejecting it if it
+// has reached the failure threshold or if it failed while half-open. It
+// returns whether the backend was ejected.
+func (b *CircuitBreaker) Failure(addr string) bool {
+	if b == nil {
+		return false
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	now := b.now()
+	c := b.backend(addr, now)
+	c.failures++
+	c.consecutiveFailures++
+	b.prune(now)
+	state := c.state(now)
+	if state == router.CircuitStateHalfOpen || (state == router.CircuitStateClosed && c.consecutiveFailures >= b.threshold) {
+		c.ejectedUntil = now.Add(b.cooldown)
+		c.ejections++
+		return true
+	}
+	return false
+}
+
+// prune discards stats for backends which have not been used recently, the
+// lock must be held.
+func (b *CircuitBreaker) prune(now time.Time) {
+	if now.Sub(b.lastPrune) < breakerIdleTimeout {
+		return
+	}
+	b.lastPrune = now
+	for addr, c := range b.backends {
+		if c.inFlight == 0 && now.Sub(c.lastUsed) > breakerIdleTimeout {
+			delete(b.backends, addr)
+		}
+	}
+}
+
+// Metrics returns the current metrics for each known backend, sorted by
+// address.
+func (b *CircuitBreaker) Metrics() []*router.BackendMetrics {
+	if b == nil {
+		return nil
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	now := b.now()
+	metrics := make([]*router.BackendMetrics, 0, len(b.backends))
+	for addr, c := range b.backends {
+		m := &router.BackendMetrics{
+			Service:             b.service,
+			Addr:                addr,
+			State:               c.state(now),
+			InFlightRequests:    c.inFlight,
+			Requests:            c.requests,
+			Failures:            c.failures,
+			ConsecutiveFailures: c.consecutiveFailures,
+			Ejections:           c.ejections,
+		}
+		if m.State == router.CircuitStateOpen {
+			until := c.ejectedUntil
+			m.EjectedUntil = &until
+		}
+		metrics = append(metrics, m)
+	}
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Addr < metrics[j].Addr })
+	return metrics
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	router "github.com/flynn/flynn/router/types"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	b := NewCircuitBreaker("test", 2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	backends := []*router.Backend{{Addr: "a"}, {Addr: "b"}}
+	filtered := func() []string {
+		var addrs []string
+		for _, backend := range b.Filter(backends) {
+			addrs = append(addrs, backend.Addr)
+		}
+		return addrs
+	}
+	state := func(addr string) router.CircuitState {
+		for _, m := range b.Metrics() {
+			if m.Addr == addr {
+				return m.State
+			}
+		}
+		return ""
+	}
+
+	// a single failure does not eject the backend
+	if b.Failure("a") {
+		t.Fatal("expected backend not to be ejected after one failure")
+	}
+	if got := filtered(); len(got) != 2 {
+		t.Fatalf("expected both backends, got %v", got)
+	}
+
+	// a success resets the consecutive failure count
+	b.Success("a")
+	b.Failure("a")
+	if got := filtered(); len(got) != 2 {
+		t.Fatalf("expected both backends, got %v", got)
+	}
+
+	// reaching the threshold ejects the backend
+	if !b.Failure("a") {
+		t.Fatal("expected backend to be ejected")
+	}
+	if got := filtered(); len(got) != 1 || got[0] != "b" {
+		t.Fatalf("expected only backend b, got %v", got)
+	}
+	if s := state("a"); s != router.CircuitStateOpen {
+		t.Fatalf("expected state %q, got %q", router.CircuitStateOpen, s)
+	}
+
+	// all backends are returned if they are all ejected
+	b.Failure("b")
+	b.Failure("b")
+	if got := filtered(); len(got) != 2 {
+		t.Fatalf("expected both backends when all are ejected, got %v", got)
+	}
+
+	// the backend is half-open after the cooldown and a failure ejects it
+	// again immediately
+	now = now.Add(time.Minute)
+	if s := state("a"); s != router.CircuitStateHalfOpen {
+		t.Fatalf("expected state %q, got %q", router.CircuitStateHalfOpen, s)
+	}
+	if !b.Failure("a") {
+		t.Fatal("expected half-open backend to be ejected after a failure")
+	}
+
+	// a success while half-open closes the circuit
+	now = now.Add(time.Minute)
+	b.Success("a")
+	if s := state("a"); s != router.CircuitStateClosed {
+		t.Fatalf("expected state %q, got %q", router.CircuitStateClosed, s)
+	}
+	for _, m := range b.Metrics() {
+		if m.Addr == "a" && m.Ejections != 2 {
+			t.Fatalf("expected 2 ejections, got %d", m.Ejections)
+		}
+	}
+}
+
+func TestCircuitBreakerPruneOnFailure(t *testing.T) {
+	now := time.Now()
+	b := NewCircuitBreaker("test", 2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	b.Success("a")
+	b.Failure("b")
+
+	// backends which have gone away are discarded even if every
+	// subsequent request fails
+	now = now.Add(2 * breakerIdleTimeout)
+	b.Failure("c")
+	metrics := b.Metrics()
+	if len(metrics) != 1 || metrics[0].Addr != "c" {
+		t.Fatalf("expected only backend c, got %v", metrics)
+	}
+}
@@ -17,6 +17,7 @@ import (
 
 	"github.com/inconshreveable/log15"
 	"golang.org/x/net/context"
+	"golang.org/x/net/http/httpguts"
 )
 
 const (
@@ -68,7 +69,9 @@ type ReverseProxyConfig struct {
 	StickyKey         *[32]byte
 	Sticky            bool
 	DisableKeepAlives bool
+	HTTP2             bool
 	RequestTracker    RequestTracker
+	CircuitBreaker    *CircuitBreaker
 	Logger            log15.Logger
 }
 
@@ -83,11 +86,12 @@ type RequestTracker interface {
 func NewReverseProxy(c ReverseProxyConfig) *ReverseProxy {
 	return &ReverseProxy{
 		transport: &transport{
-			Transport:         newHTTPTransport(c.DisableKeepAlives),
+			Transport:         newHTTPTransport(c.DisableKeepAlives, c.HTTP2),
 			getBackends:       c.BackendListFunc,
 			stickyCookieKey:   c.StickyKey,
 			useStickySessions: c.Sticky,
 			inFlightRequests:  make(map[string]int64),
+			breaker:           c.CircuitBreaker,
 		},
 		FlushInterval:  10 * time.Millisecond,
 		RequestTracker: c.RequestTracker,
@@ -268,8 +272,34 @@ func prepareResponseHeaders(res *http.Response) {
 func (p *ReverseProxy) writeResponse(rw http.ResponseWriter, res *http.Response) {
 	copyHeader(rw.Header(), res.Header)
 
+	// announce the trailers the backend announced so they can be set
+	// once the body has been copied (gRPC sends the status of a call in
+	// trailers), removing any Content-Length so that HTTP/1.1 responses
+	// are chunked, which trailers require
+	announcedTrailers := len(res.Trailer)
+	if announcedTrailers > 0 {
+		rw.Header().Del("Content-Length")
+		keys := make([]string, 0, announcedTrailers)
+		for k := range res.Trailer {
+			keys = append(keys, k)
+		}
+		rw.Header().Add("Trailer", strings.Join(keys, ", "))
+	}
+
 	rw.WriteHeader(res.StatusCode)
 	p.copyResponse(rw, res.Body)
+
+	// res.Trailer is populated once the body has been read, with any
+	// trailers which were not announced set using http.TrailerPrefix
+	if len(res.Trailer) == announcedTrailers {
+		copyHeader(rw.Header(), res.Trailer)
+		return
+	}
+	for k, vv := range res.Trailer {
+		for _, v := range vv {
+			rw.Header().Add(http.TrailerPrefix+k, v)
+		}
+	}
 }
 
 func isConnectionUpgrade(h http.Header) bool {
@@ -367,6 +397,12 @@ func prepareRequest(req *http.Request) *http.Request {
 		outreq.Header.Del(h)
 	}
 
+	// keep "TE: trailers", which gRPC servers require to detect proxies
+	// which do not support trailers
+	if httpguts.HeaderValuesContainsToken(req.Header["Te"], "trailers") {
+		outreq.Header.Set("Te", "trailers")
+	}
+
 	// remove the Upgrade header and headers referenced in the Connection
 	// header if HTTP < 1.1 or if Connection header didn't contain "upgrade":
 	// https://tools.ietf.org/html/rfc7230#section-6.7
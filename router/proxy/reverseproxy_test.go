@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	router "github.com/flynn/flynn/router/types"
+	"github.com/inconshreveable/log15"
+)
+
+type nopRequestTracker struct{}
+
+func (nopRequestTracker) TrackRequestStart(string) {}
+func (nopRequestTracker) TrackRequestDone(string)  {}
+
+func TestReverseProxyHTTP2(t *testing.T) {
+	// the backend only accepts HTTP/2 without TLS, like a gRPC server
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.ProtoMajor != 2 {
+			t.Errorf("expected an HTTP/2 request, got %s", req.Proto)
+		}
+		if te := req.Header.Get("Te"); te != "trailers" {
+			t.Errorf(`expected "TE: trailers", got %q`, te)
+		}
+		w.Header().Set("Trailer", "Grpc-Status")
+		io.WriteString(w, "hello")
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", "ok")
+	}))
+	backend.Config.Protocols = new(http.Protocols)
+	backend.Config.Protocols.SetUnencryptedHTTP2(true)
+	backend.Start()
+	defer backend.Close()
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	rp := NewReverseProxy(ReverseProxyConfig{
+		BackendListFunc: func() []*router.Backend {
+			return []*router.Backend{{Addr: backend.Listener.Addr().String()}}
+		},
+		HTTP2:          true,
+		RequestTracker: nopRequestTracker{},
+		Logger:         logger,
+	})
+	srv := httptest.NewServer(rp)
+	defer srv.Close()
+
+	req, err := http.NewRequest("POST", srv.URL+"/pkg.Service/Method", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Te", "trailers")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 200 || string(body) != "hello" {
+		t.Fatalf("expected 200 hello, got %d %q", res.StatusCode, body)
+	}
+
+	// both announced and unannounced trailers are proxied
+	if s := res.Trailer.Get("Grpc-Status"); s != "0" {
+		t.Fatalf("expected Grpc-Status trailer 0, got %q", s)
+	}
+	if m := res.Trailer.Get("Grpc-Message"); m != "ok" {
+		t.Fatalf("expected Grpc-Message trailer ok, got %q", m)
+	}
+}
+
+func TestReverseProxyServerErrorEjects(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer backend.Close()
+	addr := backend.Listener.Addr().String()
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	breaker := NewCircuitBreaker("test", 2, time.Minute)
+	rp := NewReverseProxy(ReverseProxyConfig{
+		BackendListFunc: func() []*router.Backend {
+			return []*router.Backend{{Addr: addr}}
+		},
+		RequestTracker: nopRequestTracker{},
+		CircuitBreaker: breaker,
+		Logger:         logger,
+	})
+	srv := httptest.NewServer(rp)
+	defer srv.Close()
+
+	for i := 0; i < 2; i++ {
+		res, err := http.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusInternalServerError {
+			t.Fatalf("expected status 500, got %d", res.StatusCode)
+		}
+	}
+
+	// server errors count as failures, so the backend is ejected
+	metrics := breaker.Metrics()
+	if len(metrics) != 1 || metrics[0].State != router.CircuitStateOpen || metrics[0].Failures != 2 {
+		t.Fatalf("expected backend to be ejected after 2 failures, got %+v", metrics)
+	}
+}
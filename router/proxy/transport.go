@@ -40,8 +40,10 @@ const maxBackendAttempts = 4
 // BackendListFunc returns a slice of backends
 type BackendListFunc func() []*router.Backend
 
-func newHTTPTransport(disableKeepAlives bool) *http.Transport {
-	return &http.Transport{
+// newHTTPTransport returns a transport for proxying requests to backends,
+// which uses HTTP/2 without TLS (h2c) rather than HTTP/1.1 if http2 is set.
+func newHTTPTransport(disableKeepAlives, http2 bool) *http.Transport {
+	t := &http.Transport{
 		Dial: customDial,
 		// The response header timeout is currently set pretty high because
 		// gitreceive doesn't send headers until it is done unpacking the repo,
@@ -50,6 +52,11 @@ func newHTTPTransport(disableKeepAlives bool) *http.Transport {
 		TLSHandshakeTimeout:   10 * time.Second, // unused, but safer to leave default in place
 		DisableKeepAlives:     disableKeepAlives,
 	}
+	if http2 {
+		t.Protocols = new(http.Protocols)
+		t.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return t
 }
 
 type transport struct {
@@ -62,12 +69,16 @@ type transport struct {
 
 	inFlightMtx      sync.Mutex
 	inFlightRequests map[string]int64
+
+	// breaker, if set, ejects backends after consecutive failures
+	breaker *CircuitBreaker
 }
 
 func (t *transport) trackRequestStart(backend *router.Backend) {
 	t.inFlightMtx.Lock()
 	t.inFlightRequests[backend.Addr]++
 	t.inFlightMtx.Unlock()
+	t.breaker.RequestStart(backend.Addr)
 }
 
 func (t *transport) trackRequestEnd(backend *router.Backend) {
@@ -77,6 +88,38 @@ func (t *transport) trackRequestEnd(backend *router.Backend) {
 		delete(t.inFlightRequests, backend.Addr)
 	}
 	t.inFlightMtx.Unlock()
+	t.breaker.RequestDone(backend.Addr)
+}
+
+// recordResult records the outcome of a request to the given backend with
+// the circuit breaker. Client errors do not reflect on the backend so are
+// not counted as failures.
+func (t *transport) recordResult(backend *router.Backend, err error, l log15.Logger) {
+	if err == nil {
+		t.breaker.Success(backend.Addr)
+		return
+	}
+	if clientError(err) || err == errCanceled {
+		return
+	}
+	t.recordFailure(backend, l)
+}
+
+// recordResponse records a response from the given backend with the circuit
+// breaker, counting server errors as failures so that a backend which
+// accepts connections but fails every request is still ejected.
+func (t *transport) recordResponse(backend *router.Backend, res *http.Response, l log15.Logger) {
+	if res.StatusCode >= 500 {
+		t.recordFailure(backend, l)
+		return
+	}
+	t.breaker.Success(backend.Addr)
+}
+
+func (t *transport) recordFailure(backend *router.Backend, l log15.Logger) {
+	if t.breaker.Failure(backend.Addr) {
+		l.Warn("ejecting backend after consecutive failures", "job.id", backend.JobID, "addr", backend.Addr, "cooldown", t.breaker.cooldown)
+	}
 }
 
 // eachBackend iterates through the given backends and calls the given
@@ -87,6 +130,9 @@ func (t *transport) trackRequestEnd(backend *router.Backend) {
 // If stickyBackend matches one of the backends then that backend will be tried
 // first.
 //
+// Backends ejected by the circuit breaker are skipped unless every backend
+// has been ejected.
+//
 // On each iteration, two random backends are picked and the one with the least
 // load is tried, thus implementing the "power of two random choices"
 // algorithm.
@@ -95,6 +141,7 @@ func (t *transport) eachBackend(stickyBackend string, backends []*router.Backend
 	if len(backends) == 0 {
 		return errNoBackends
 	}
+	backends = t.breaker.Filter(backends)
 
 	attempt := 0
 
@@ -214,8 +261,10 @@ func (t *transport) RoundTrip(req *http.Request, l log15.Logger) (*http.Response
 		if err == nil {
 			trace.Finalize(backend)
 			t.setStickyBackend(res, stickyBackend)
+			t.recordResponse(backend, res, l)
 			return
 		}
+		t.recordResult(backend, err, l)
 		rt.TrackRequestDone(backend.Addr)
 		return
 	})
@@ -227,7 +276,7 @@ func (t *transport) RoundTrip(req *http.Request, l log15.Logger) (*http.Response
 
 func (t *transport) Connect(ctx context.Context, l log15.Logger) (net.Conn, error) {
 	backends := t.getOrderedBackends("")
-	conn, backend, err := dialTCP(ctx, l, backends)
+	conn, backend, err := t.dialTCP(ctx, l, backends)
 	if err != nil {
 		l.Error("connection failed", "err", err, "num_backends", len(backends), "job.id", backend.JobID, "addr", backend.Addr)
 	}
@@ -237,7 +286,7 @@ func (t *transport) Connect(ctx context.Context, l log15.Logger) (net.Conn, erro
 func (t *transport) UpgradeHTTP(req *http.Request, l log15.Logger) (*http.Response, net.Conn, error) {
 	stickyBackend := t.getStickyBackend(req)
 	backends := t.getOrderedBackends(stickyBackend)
-	upconn, backend, err := dialTCP(context.Background(), l, backends)
+	upconn, backend, err := t.dialTCP(context.Background(), l, backends)
 	if err != nil {
 		l.Error("dial failed", "status", "503", "num_backends", len(backends))
 		return nil, nil, err
@@ -260,15 +309,16 @@ func (t *transport) UpgradeHTTP(req *http.Request, l log15.Logger) (*http.Respon
 	return res, conn, nil
 }
 
-func dialTCP(ctx context.Context, l log15.Logger, backends []*router.Backend) (net.Conn, *router.Backend, error) {
+func (t *transport) dialTCP(ctx context.Context, l log15.Logger, backends []*router.Backend) (net.Conn, *router.Backend, error) {
 	donec := ctx.Done()
-	for i, backend := range backends {
+	for i, backend := range t.breaker.Filter(backends) {
 		select {
 		case <-donec:
 			return nil, nil, errCanceled
 		default:
 		}
 		conn, err := dialer.Dial("tcp", backend.Addr)
+		t.recordResult(backend, err, l)
 		if err == nil {
 			return conn, backend, nil
 		}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+
+	router "github.com/flynn/flynn/router/types"
+	. "github.com/flynn/go-check"
+)
+
+type RedirectHTTPSSuite struct{}
+
+var _ = Suite(&RedirectHTTPSSuite{})
+
+func (RedirectHTTPSSuite) TestRedirectsToHTTPS(c *C) {
+	plain := httptest.NewRequest("GET", "http://example.com/", nil)
+	secure := httptest.NewRequest("GET", "https://example.com/", nil)
+	secure.TLS = &tls.ConnectionState{}
+
+	for _, t := range []struct {
+		redirect bool
+		keypair  *tls.Certificate
+		req      *http.Request
+		expected bool
+	}{
+		{redirect: true, keypair: &tls.Certificate{}, req: plain, expected: true},
+		{redirect: true, keypair: &tls.Certificate{}, req: secure, expected: false},
+		{redirect: true, keypair: nil, req: plain, expected: false},
+		{redirect: false, keypair: &tls.Certificate{}, req: plain, expected: false},
+	} {
+		r := &httpRoute{HTTPRoute: &router.HTTPRoute{RedirectHTTPS: t.redirect}, keypair: t.keypair}
+		c.Assert(r.redirectsToHTTPS(t.req), Equals, t.expected)
+	}
+}
+
+func (RedirectHTTPSSuite) TestRedirectHTTPS(c *C) {
+	for _, t := range []struct {
+		tlsAddrs []string
+		url      string
+		expected string
+	}{
+		{[]string{"127.0.0.1:443"}, "http://example.com/foo?bar=baz", "https://example.com/foo?bar=baz"},
+		{[]string{"127.0.0.1:443"}, "http://example.com:80/", "https://example.com/"},
+		{[]string{"127.0.0.1:8443"}, "http://example.com:8080/foo", "https://example.com:8443/foo"},
+		{[]string{"[::]:8443"}, "http://[::1]:8080/", "https://[::1]:8443/"},
+	} {
+		s := &HTTPListener{TLSAddrs: t.tlsAddrs}
+		w := httptest.NewRecorder()
+		s.redirectHTTPS(w, httptest.NewRequest("GET", t.url, nil))
+		c.Assert(w.Code, Equals, http.StatusMovedPermanently)
+		c.Assert(w.Header().Get("Location"), Equals, t.expected)
+	}
+}
@@ -14,10 +14,13 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	discoverd "github.com/flynn/flynn/discoverd/client"
+	hh "github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/keepalive"
 	"github.com/flynn/flynn/pkg/shutdown"
+	router "github.com/flynn/flynn/router/types"
 	"github.com/inconshreveable/log15"
 )
 
@@ -33,6 +36,7 @@ func init() {
 type Listener interface {
 	Start() error
 	Close() error
+	BackendMetrics() []*router.BackendMetrics
 	Watcher
 }
 
@@ -218,6 +222,28 @@ func main() {
 		httpsAddrs = append(httpsAddrs, net.JoinHostPort(os.Getenv("LISTEN_IP"), strconv.Itoa(port)))
 		reservedPorts = append(reservedPorts, port)
 	}
+	tlsPolicy := &router.TLSPolicy{
+		MinVersion:       os.Getenv("TLS_MIN_VERSION"),
+		CipherSuites:     router.ParseTLSPolicyList(os.Getenv("TLS_CIPHER_SUITES")),
+		CurvePreferences: router.ParseTLSPolicyList(os.Getenv("TLS_CURVE_PREFERENCES")),
+	}
+	if err := tlsPolicy.Validate(); err != nil {
+		shutdown.Fatalf("invalid TLS policy: %s", err)
+	}
+
+	ticketKeyRotation := defaultTicketKeyRotation
+	if rotation := os.Getenv("TLS_TICKET_KEY_ROTATION"); rotation != "" {
+		ticketKeyRotation, err = time.ParseDuration(rotation)
+		if err != nil {
+			shutdown.Fatalf("error parsing TLS_TICKET_KEY_ROTATION: %s", err)
+		}
+	}
+	if err := discoverd.DefaultClient.AddService(ticketKeyService, nil); err != nil && !hh.IsObjectExistsError(err) {
+		log.Error("error creating TLS session ticket key service", "err", err)
+		shutdown.Fatal(err)
+	}
+	ticketKeys := newTicketKeyManager(discoverd.NewService(ticketKeyService), cookieKey, ticketKeyRotation)
+
 	r := Router{
 		TCP: &TCPListener{
 			IP:            *tcpIP,
@@ -231,6 +257,7 @@ func main() {
 			Addrs:             httpAddrs,
 			TLSAddrs:          httpsAddrs,
 			LegacyTLSVersions: legacyTLS,
+			TLSPolicy:         tlsPolicy,
 			defaultPorts:      defaultPorts,
 			cookieKey:         cookieKey,
 			keypair:           keypair,
@@ -238,6 +265,7 @@ func main() {
 			discoverd:         discoverd.DefaultClient,
 			proxyProtocol:     proxyProtocol,
 			error503Page:      error503Page,
+			ticketKeys:        ticketKeys,
 		},
 	}
 
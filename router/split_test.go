@@ -0,0 +1,102 @@
+package main
+
+import (
+	discoverd "github.com/flynn/flynn/discoverd/client"
+	"github.com/flynn/flynn/router/proxy"
+	router "github.com/flynn/flynn/router/types"
+	. "github.com/flynn/go-check"
+)
+
+type RouteSplitSuite struct{}
+
+var _ = Suite(&RouteSplitSuite{})
+
+func (RouteSplitSuite) TestSplitProxy(c *C) {
+	instances := func(n int) func() []*discoverd.Instance {
+		return func() []*discoverd.Instance {
+			return make([]*discoverd.Instance, n)
+		}
+	}
+	main := &proxy.ReverseProxy{}
+	canary := &proxy.ReverseProxy{}
+	empty := &proxy.ReverseProxy{}
+	r := &httpRoute{
+		HTTPRoute: &router.HTTPRoute{},
+		rp:        main,
+		splits: []*routeSplit{
+			{weight: 10, backends: instances(1), rp: canary},
+			{weight: 5, backends: instances(0), rp: empty},
+		},
+	}
+
+	for _, t := range []struct {
+		n        int
+		expected *proxy.ReverseProxy
+	}{
+		{n: 0, expected: canary},
+		{n: 9, expected: canary},
+		// the second split has no instances so its requests are sent
+		// to the route's service
+		{n: 10, expected: main},
+		{n: 14, expected: main},
+		{n: 15, expected: main},
+		{n: 99, expected: main},
+	} {
+		c.Assert(r.splitProxy(t.n) == t.expected, Equals, true, Commentf("n = %d", t.n))
+	}
+}
+
+func (RouteSplitSuite) TestValidateRouteSplits(c *C) {
+	for _, t := range []struct {
+		splits []*router.RouteSplit
+		err    string
+	}{
+		{splits: []*router.RouteSplit{{Service: "canary", Weight: 10}}},
+		{splits: []*router.RouteSplit{{Service: "a", Weight: 60}, {Service: "b", Weight: 40}}},
+		{splits: []*router.RouteSplit{{Weight: 10}}, err: "route split service must be set"},
+		{splits: []*router.RouteSplit{{Service: "canary"}}, err: `route split weight for service "canary" must be positive`},
+		{splits: []*router.RouteSplit{{Service: "a", Weight: 60}, {Service: "b", Weight: 50}}, err: "route split weights must not exceed 100 in total, got 110"},
+	} {
+		err := router.ValidateRouteSplits(t.splits)
+		if t.err == "" {
+			c.Assert(err, IsNil)
+		} else {
+			c.Assert(err, ErrorMatches, t.err)
+		}
+	}
+}
+
+func (RouteSplitSuite) TestReleaseSplitInstances(c *C) {
+	inst := func(addr, release string) *discoverd.Instance {
+		return &discoverd.Instance{Addr: addr, Meta: map[string]string{"FLYNN_RELEASE_ID": release}}
+	}
+	var instances []*discoverd.Instance
+	all := func() []*discoverd.Instance { return instances }
+	addrs := func(f func() []*discoverd.Instance) []string {
+		var res []string
+		for _, inst := range f() {
+			res = append(res, inst.Addr)
+		}
+		return res
+	}
+
+	releases := splitReleases("web", []*router.RouteSplit{
+		{Service: "web", Release: "new", Weight: 10},
+		{Service: "other", Release: "other", Weight: 10},
+		{Service: "canary", Weight: 10},
+	})
+	c.Assert(releases, DeepEquals, map[string]struct{}{"new": {}})
+	canary := releaseInstances(all, "new")
+	main := excludeReleases(all, releases)
+
+	// the split is sent the new release's instances and the route's
+	// service the others
+	instances = []*discoverd.Instance{inst("old1", "old"), inst("new1", "new"), inst("old2", "old")}
+	c.Assert(addrs(canary), DeepEquals, []string{"new1"})
+	c.Assert(addrs(main), DeepEquals, []string{"old1", "old2"})
+
+	// once the old release has gone the route's service is sent all of
+	// the instances
+	instances = []*discoverd.Instance{inst("new1", "new"), inst("new2", "new")}
+	c.Assert(addrs(main), DeepEquals, []string{"new1", "new2"})
+}
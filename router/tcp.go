@@ -119,6 +119,18 @@ func (l *TCPListener) doSync(ctx context.Context, errc chan<- error) <-chan stru
 	return startc
 }
 
+// BackendMetrics returns the connection and circuit breaker metrics for the
+// backends of each service with a TCP route.
+func (l *TCPListener) BackendMetrics() []*router.BackendMetrics {
+	l.mtx.RLock()
+	defer l.mtx.RUnlock()
+	var metrics []*router.BackendMetrics
+	for _, service := range l.services {
+		metrics = append(metrics, service.breaker.Metrics()...)
+	}
+	return metrics
+}
+
 func (l *TCPListener) Close() error {
 	l.mtx.Lock()
 	defer l.mtx.Unlock()
@@ -192,6 +204,7 @@ func (h *tcpSyncHandler) Set(data *router.Route) error {
 	r.rp = proxy.NewReverseProxy(proxy.ReverseProxyConfig{
 		BackendListFunc: bf,
 		RequestTracker:  service,
+		CircuitBreaker:  service.breaker,
 		Logger:          logger,
 	})
 	if listener, ok := h.l.listeners[r.Port]; ok {
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	discoverd "github.com/flynn/flynn/discoverd/client"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+const (
+	// ticketKeyService is the discoverd service whose metadata holds the
+	// TLS session ticket keys shared between router instances
+	ticketKeyService = "router-tls-tickets"
+
+	// defaultTicketKeyRotation is how often a new session ticket key is
+	// generated
+	defaultTicketKeyRotation = 12 * time.Hour
+
+	// maxTicketKeys is the number of keys kept, so sessions can be resumed
+	// for up to maxTicketKeys rotation periods and keys are discarded after
+	// that for forward secrecy
+	maxTicketKeys = 3
+
+	ticketKeySyncInterval = time.Minute
+)
+
+// ticketKeyStore stores the shared session ticket keys, and is implemented
+// by discoverd.Service.
+type ticketKeyStore interface {
+	GetMeta() (*discoverd.ServiceMeta, error)
+	SetMeta(*discoverd.ServiceMeta) error
+}
+
+// ticketKeyState is the set of session ticket keys, newest first. The newest
+// key is used to issue tickets, and all keys are accepted for resumption.
+type ticketKeyState struct {
+	Keys      [][32]byte `json:"keys"`
+	RotatedAt time.Time  `json:"rotated_at"`
+}
+
+// ticketKeyMeta is the service metadata, which holds the ticket key state
+// encrypted with the key shared by router instances so that the keys aren't
+// readable by anything else with access to discoverd.
+type ticketKeyMeta struct {
+	Box []byte `json:"box"`
+}
+
+// ticketKeyManager coordinates TLS session ticket keys between router
+// instances so that sessions resumed on a different router succeed, and
+// rotates the keys on a schedule.
+type ticketKeyManager struct {
+	store    ticketKeyStore
+	secret   *[32]byte
+	rotation time.Duration
+
+	mtx     sync.Mutex
+	keys    [][32]byte
+	configs []*tls.Config
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newTicketKeyManager(store ticketKeyStore, secret *[32]byte, rotation time.Duration) *ticketKeyManager {
+	if rotation <= 0 {
+		rotation = defaultTicketKeyRotation
+	}
+	return &ticketKeyManager{
+		store:    store,
+		secret:   secret,
+		rotation: rotation,
+		stop:     make(chan struct{}),
+	}
+}
+
+// AddConfig sets the session ticket keys of the given TLS config, and
+// updates them whenever the keys change.
+func (m *ticketKeyManager) AddConfig(config *tls.Config) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.configs = append(m.configs, config)
+	if len(m.keys) > 0 {
+		config.SetSessionTicketKeys(m.keys)
+	}
+}
+
+// Run periodically syncs the keys from the store until Close is called.
+func (m *ticketKeyManager) Run() {
+	ticker := time.NewTicker(ticketKeySyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.Sync(); err != nil {
+				logger.Error("error syncing TLS session ticket keys", "err", err)
+			}
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *ticketKeyManager) Close() {
+	m.stopOnce.Do(func() { close(m.stop) })
+}
+
+// Sync reads the keys from the store, rotating them if the newest key is
+// older than the rotation period, and applies them to the TLS configs.
+func (m *ticketKeyManager) Sync() error {
+	meta, err := m.store.GetMeta()
+	if err != nil && !discoverd.IsNotFound(err) {
+		return err
+	}
+	if meta == nil {
+		meta = &discoverd.ServiceMeta{}
+	}
+
+	state := &ticketKeyState{}
+	if len(meta.Data) > 0 {
+		if state, err = m.decode(meta.Data); err != nil {
+			return err
+		}
+	}
+
+	if len(state.Keys) == 0 || time.Since(state.RotatedAt) >= m.rotation {
+		rotated, err := m.rotate(state)
+		if err != nil {
+			return err
+		}
+		data, err := m.encode(rotated)
+		if err != nil {
+			return err
+		}
+		// the write only succeeds if no other router has updated the
+		// keys since they were read, in which case use theirs on the
+		// next sync
+		if err := m.store.SetMeta(&discoverd.ServiceMeta{Data: data, Index: meta.Index}); err != nil {
+			return err
+		}
+		logger.Info("rotated TLS session ticket keys", "keys", len(rotated.Keys))
+		state = rotated
+	}
+
+	m.apply(state.Keys)
+	return nil
+}
+
+func (m *ticketKeyManager) rotate(state *ticketKeyState) (*ticketKeyState, error) {
+	var key [32]byte
+	if _, err := io.ReadFull(rand.Reader, key[:]); err != nil {
+		return nil, err
+	}
+	keys := append([][32]byte{key}, state.Keys...)
+	if len(keys) > maxTicketKeys {
+		keys = keys[:maxTicketKeys]
+	}
+	return &ticketKeyState{Keys: keys, RotatedAt: time.Now()}, nil
+}
+
+func (m *ticketKeyManager) apply(keys [][32]byte) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.keys = keys
+	for _, config := range m.configs {
+		config.SetSessionTicketKeys(keys)
+	}
+}
+
+func (m *ticketKeyManager) encode(state *ticketKeyState) ([]byte, error) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, err
+	}
+	box := secretbox.Seal(nonce[:], data, &nonce, m.secret)
+	return json.Marshal(&ticketKeyMeta{Box: box})
+}
+
+var errInvalidTicketKeys = errors.New("router: unable to decrypt TLS session ticket keys (routers may have different COOKIE_KEY values)")
+
+func (m *ticketKeyManager) decode(data []byte) (*ticketKeyState, error) {
+	var meta ticketKeyMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	if len(meta.Box) < 24 {
+		return nil, errInvalidTicketKeys
+	}
+	var nonce [24]byte
+	copy(nonce[:], meta.Box[:24])
+	plain, ok := secretbox.Open(nil, meta.Box[24:], &nonce, m.secret)
+	if !ok {
+		return nil, errInvalidTicketKeys
+	}
+	state := &ticketKeyState{}
+	return state, json.Unmarshal(plain, state)
+}
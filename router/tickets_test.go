@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"sync"
+	"time"
+
+	discoverd "github.com/flynn/flynn/discoverd/client"
+	hh "github.com/flynn/flynn/pkg/httphelper"
+	. "github.com/flynn/go-check"
+)
+
+type TicketKeySuite struct{}
+
+var _ = Suite(&TicketKeySuite{})
+
+// fakeTicketKeyStore is an in-memory ticketKeyStore which, like discoverd,
+// only accepts writes with the current index.
+type fakeTicketKeyStore struct {
+	mtx  sync.Mutex
+	meta *discoverd.ServiceMeta
+}
+
+func (s *fakeTicketKeyStore) GetMeta() (*discoverd.ServiceMeta, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.meta == nil {
+		return nil, hh.JSONError{Code: hh.ObjectNotFoundErrorCode}
+	}
+	return &discoverd.ServiceMeta{Data: s.meta.Data, Index: s.meta.Index}, nil
+}
+
+func (s *fakeTicketKeyStore) SetMeta(meta *discoverd.ServiceMeta) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	var index uint64
+	if s.meta != nil {
+		index = s.meta.Index
+	}
+	if meta.Index != index {
+		return errors.New("index mismatch")
+	}
+	s.meta = &discoverd.ServiceMeta{Data: meta.Data, Index: index + 1}
+	return nil
+}
+
+func (TicketKeySuite) TestTicketKeysShared(c *C) {
+	store := &fakeTicketKeyStore{}
+	secret := &[32]byte{1}
+
+	// the first router generates a key, which the second adopts
+	m1 := newTicketKeyManager(store, secret, time.Hour)
+	m2 := newTicketKeyManager(store, secret, time.Hour)
+	c.Assert(m1.Sync(), IsNil)
+	c.Assert(m2.Sync(), IsNil)
+	c.Assert(m1.keys, HasLen, 1)
+	c.Assert(m2.keys, DeepEquals, m1.keys)
+
+	// the keys are encrypted in the store
+	c.Assert(string(store.meta.Data), Not(Matches), ".*keys.*")
+
+	// routers with a different secret can't read the keys
+	m3 := newTicketKeyManager(store, &[32]byte{2}, time.Hour)
+	c.Assert(m3.Sync(), Equals, errInvalidTicketKeys)
+
+	// configs get the current keys and subsequent rotations
+	config := &tls.Config{}
+	m2.AddConfig(config)
+	m2.rotation = time.Nanosecond
+	c.Assert(m2.Sync(), IsNil)
+	c.Assert(m2.keys, HasLen, 2)
+	c.Assert(m2.keys[1], Equals, m1.keys[0])
+	c.Assert(m1.Sync(), IsNil)
+	c.Assert(m1.keys, DeepEquals, m2.keys)
+}
+
+func (TicketKeySuite) TestTicketKeyRotationLimit(c *C) {
+	store := &fakeTicketKeyStore{}
+	m := newTicketKeyManager(store, &[32]byte{1}, time.Nanosecond)
+	for i := 0; i < maxTicketKeys+2; i++ {
+		c.Assert(m.Sync(), IsNil)
+	}
+	c.Assert(m.keys, HasLen, maxTicketKeys)
+
+	// a write based on stale metadata is rejected
+	stale, err := store.GetMeta()
+	c.Assert(err, IsNil)
+	c.Assert(m.Sync(), IsNil)
+	c.Assert(store.SetMeta(stale), NotNil)
+}
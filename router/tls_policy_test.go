@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/tls"
+
+	router "github.com/flynn/flynn/router/types"
+	. "github.com/flynn/go-check"
+)
+
+type TLSPolicySuite struct{}
+
+var _ = Suite(&TLSPolicySuite{})
+
+func (TLSPolicySuite) TestApply(c *C) {
+	policy := &router.TLSPolicy{
+		MinVersion:       "1.2",
+		CipherSuites:     []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"},
+		CurvePreferences: []string{"X25519", "P256"},
+	}
+	c.Assert(policy.Validate(), IsNil)
+
+	config := &tls.Config{MinVersion: tls.VersionTLS10}
+	c.Assert(policy.Apply(config), IsNil)
+	c.Assert(config.MinVersion, Equals, uint16(tls.VersionTLS12))
+	c.Assert(config.CipherSuites, DeepEquals, []uint16{
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	})
+	c.Assert(config.CurvePreferences, DeepEquals, []tls.CurveID{tls.X25519, tls.CurveP256})
+
+	// unset fields leave the config unchanged
+	config = &tls.Config{MinVersion: tls.VersionTLS11}
+	c.Assert((&router.TLSPolicy{CurvePreferences: []string{"P384"}}).Apply(config), IsNil)
+	c.Assert(config.MinVersion, Equals, uint16(tls.VersionTLS11))
+	c.Assert(config.CipherSuites, IsNil)
+
+	// a nil policy is valid and changes nothing
+	var nilPolicy *router.TLSPolicy
+	c.Assert(nilPolicy.Validate(), IsNil)
+	c.Assert(nilPolicy.Apply(config), IsNil)
+}
+
+func (TLSPolicySuite) TestValidate(c *C) {
+	for _, policy := range []*router.TLSPolicy{
+		{MinVersion: "1.4"},
+		{MinVersion: "TLS1.2"},
+		{CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_FOO"}},
+		{CurvePreferences: []string{"P224"}},
+	} {
+		c.Assert(policy.Validate(), NotNil, Commentf("%+v", policy))
+	}
+}
+
+func (TLSPolicySuite) TestParseList(c *C) {
+	c.Assert(router.ParseTLSPolicyList(""), IsNil)
+	c.Assert(router.ParseTLSPolicyList(" X25519, P256,,"), DeepEquals, []string{"X25519", "P256"})
+}
+
+func (TLSPolicySuite) TestALPNAndHSTS(c *C) {
+	policy := &router.TLSPolicy{ALPNProtocols: []string{"http/1.1"}, HSTSMaxAge: 31536000, HSTSIncludeSubdomains: true}
+	c.Assert(policy.Validate(), IsNil)
+
+	config := &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+	c.Assert(policy.Apply(config), IsNil)
+	c.Assert(config.NextProtos, DeepEquals, []string{"http/1.1"})
+	c.Assert(policy.HSTSHeader(), Equals, "max-age=31536000; includeSubDomains")
+
+	c.Assert((&router.TLSPolicy{HSTSMaxAge: 600}).HSTSHeader(), Equals, "max-age=600")
+	c.Assert((&router.TLSPolicy{MinVersion: "1.2"}).HSTSHeader(), Equals, "")
+	var nilPolicy *router.TLSPolicy
+	c.Assert(nilPolicy.HSTSHeader(), Equals, "")
+
+	for _, policy := range []*router.TLSPolicy{
+		{ALPNProtocols: []string{"h3"}},
+		{HSTSMaxAge: -1},
+		{HSTSIncludeSubdomains: true},
+	} {
+		c.Assert(policy.Validate(), NotNil, Commentf("%+v", policy))
+	}
+}
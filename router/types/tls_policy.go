@@ -0,0 +1,169 @@
+package router
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TLSPolicy restricts the TLS versions, cipher suites, elliptic curves and
+// application protocols the router negotiates with clients, and whether
+// responses enable HTTP Strict Transport Security. Unset fields use the
+// router's defaults.
+type TLSPolicy struct {
+	// MinVersion is the minimum TLS version, one of "1.0", "1.1", "1.2" or
+	// "1.3".
+	MinVersion string `json:"min_version,omitempty"`
+
+	// CipherSuites is the list of enabled cipher suites, named as in the
+	// crypto/tls package (e.g. "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256").
+	// The TLS 1.3 cipher suites are not configurable.
+	CipherSuites []string `json:"cipher_suites,omitempty"`
+
+	// CurvePreferences is the list of enabled elliptic curves in order of
+	// preference, one or more of "X25519", "P256", "P384" and "P521".
+	CurvePreferences []string `json:"curve_preferences,omitempty"`
+
+	// ALPNProtocols is the list of application protocols offered with ALPN
+	// in order of preference, one or more of "h2" and "http/1.1" (e.g.
+	// "http/1.1" alone disables HTTP/2).
+	ALPNProtocols []string `json:"alpn_protocols,omitempty"`
+
+	// HSTSMaxAge is the max-age in seconds of the Strict-Transport-Security
+	// header added to responses to HTTPS requests, which is not added if
+	// zero.
+	HSTSMaxAge int `json:"hsts_max_age,omitempty"`
+
+	// HSTSIncludeSubdomains is whether the Strict-Transport-Security header
+	// applies to subdomains of the route's domain.
+	HSTSIncludeSubdomains bool `json:"hsts_include_subdomains,omitempty"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var alpnProtocols = map[string]struct{}{
+	"h2":       {},
+	"http/1.1": {},
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+func tlsCipherSuite(name string) (uint16, bool) {
+	for _, s := range tls.CipherSuites() {
+		if s.Name == name {
+			return s.ID, true
+		}
+	}
+	for _, s := range tls.InsecureCipherSuites() {
+		if s.Name == name {
+			return s.ID, true
+		}
+	}
+	return 0, false
+}
+
+// Validate returns an error if the policy contains an unknown version,
+// cipher suite, curve or protocol, or an invalid HSTS policy.
+func (p *TLSPolicy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	if p.HSTSMaxAge < 0 {
+		return fmt.Errorf("invalid HSTS max-age %d, must not be negative", p.HSTSMaxAge)
+	}
+	if p.HSTSIncludeSubdomains && p.HSTSMaxAge == 0 {
+		return errors.New("HSTS includeSubDomains requires a HSTS max-age")
+	}
+	return p.Apply(&tls.Config{})
+}
+
+// HSTSHeader returns the value of the Strict-Transport-Security header set
+// by the policy, or an empty string if HSTS is not enabled.
+func (p *TLSPolicy) HSTSHeader() string {
+	if p == nil || p.HSTSMaxAge <= 0 {
+		return ""
+	}
+	header := fmt.Sprintf("max-age=%d", p.HSTSMaxAge)
+	if p.HSTSIncludeSubdomains {
+		header += "; includeSubDomains"
+	}
+	return header
+}
+
+// Apply sets the fields of config restricted by the policy.
+func (p *TLSPolicy) Apply(config *tls.Config) error {
+	if p == nil {
+		return nil
+	}
+	if p.MinVersion != "" {
+		v, ok := tlsVersions[p.MinVersion]
+		if !ok {
+			return fmt.Errorf("invalid TLS version %q, must be one of 1.0, 1.1, 1.2 or 1.3", p.MinVersion)
+		}
+		config.MinVersion = v
+	}
+	if len(p.CipherSuites) > 0 {
+		suites := make([]uint16, len(p.CipherSuites))
+		for i, name := range p.CipherSuites {
+			id, ok := tlsCipherSuite(name)
+			if !ok {
+				return fmt.Errorf("unknown TLS cipher suite %q", name)
+			}
+			suites[i] = id
+		}
+		config.CipherSuites = suites
+	}
+	if len(p.CurvePreferences) > 0 {
+		curves := make([]tls.CurveID, len(p.CurvePreferences))
+		for i, name := range p.CurvePreferences {
+			id, ok := tlsCurves[name]
+			if !ok {
+				return fmt.Errorf("unknown TLS curve %q, must be one of X25519, P256, P384 or P521", name)
+			}
+			curves[i] = id
+		}
+		config.CurvePreferences = curves
+	}
+	if len(p.ALPNProtocols) > 0 {
+		for _, proto := range p.ALPNProtocols {
+			if _, ok := alpnProtocols[proto]; !ok {
+				return fmt.Errorf("unknown ALPN protocol %q, must be h2 or http/1.1", proto)
+			}
+		}
+		config.NextProtos = p.ALPNProtocols
+	}
+	return nil
+}
+
+// ParseTLSPolicyList splits a comma separated list of cipher suite or curve
+// names.
+func ParseTLSPolicyList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// ParseClientCA parses a PEM encoded bundle of client CA certificates.
+func ParseClientCA(bundle string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(bundle)) {
+		return nil, errors.New("client CA does not contain any valid PEM encoded certificates")
+	}
+	return pool, nil
+}
@@ -4,6 +4,9 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
 	"time"
 )
 
@@ -19,6 +22,9 @@ type Certificate struct {
 	Key string `json:"key,omitempty"`
 	// Chain is a list of DER-encoded X.509 certificates (for managed certs).
 	Chain [][]byte `json:"chain,omitempty"`
+	// OCSPResponse is an optional DER-encoded OCSP response which is
+	// stapled to TLS handshakes using this certificate.
+	OCSPResponse []byte `json:"ocsp_response,omitempty"`
 	// NoStrict disables strict certificate validation
 	NoStrict bool `json:"no_strict,omitempty"`
 	// CreatedAt is the time this cert was created.
@@ -121,6 +127,165 @@ type Route struct {
 	// DisableKeepAlives when set will disable keep-alives between the
 	// router and backends for this route
 	DisableKeepAlives bool `json:"disable_keep_alives,omitempty"`
+
+	// TLSPolicy optionally overrides the router's TLS policy for
+	// connections to this route. It is only used for HTTP routes.
+	TLSPolicy *TLSPolicy `json:"tls_policy,omitempty"`
+
+	// ClientCA is an optional PEM encoded bundle of CA certificates which
+	// clients must present a certificate signed by to use this route. It
+	// is only used for HTTP routes.
+	ClientCA string `json:"client_ca,omitempty"`
+
+	// RedirectHTTPS is whether plaintext requests to the route are
+	// redirected to HTTPS when it has a certificate. It is only used for
+	// HTTP routes.
+	RedirectHTTPS bool `json:"redirect_https,omitempty"`
+
+	// Splits optionally sends a percentage of the route's requests to
+	// other services, for example to shift traffic to a canary service
+	// during a deploy, with the remaining requests sent to Service. It is
+	// only used for HTTP routes.
+	Splits []*RouteSplit `json:"splits,omitempty"`
+
+	// HTTP2 is whether requests are proxied to backends using HTTP/2
+	// without TLS (h2c) rather than HTTP/1.1, which gRPC services require
+	// for streaming and trailers. Clients must connect using HTTP/2 over
+	// TLS to make use of it. It is only used for HTTP routes.
+	HTTP2 bool `json:"http2,omitempty"`
+}
+
+// RouteSplit sends a percentage of a route's requests to a service.
+type RouteSplit struct {
+	// Service is the ID of the service.
+	Service string `json:"service"`
+	// Release, if set, limits the split to instances of the service which
+	// belong to the release with the given ID, and stops the route's
+	// service sending requests to them unless it has no other instances.
+	// This lets a canary deploy shift requests to a new release of the
+	// route's own service.
+	Release string `json:"release,omitempty"`
+	// Weight is the percentage of requests sent to the service.
+	Weight int `json:"weight"`
+}
+
+// MaxRouteSplitWeight is the total weight of a route's splits, with any
+// weight not given to a split sent to the route's service.
+const MaxRouteSplitWeight = 100
+
+// ValidateRouteSplits checks that each split has a service and a positive
+// weight, and that the splits do not exceed MaxRouteSplitWeight in total.
+func ValidateRouteSplits(splits []*RouteSplit) error {
+	total := 0
+	for _, split := range splits {
+		if split == nil || split.Service == "" {
+			return errors.New("route split service must be set")
+		}
+		if split.Weight <= 0 {
+			return fmt.Errorf("route split weight for service %q must be positive", split.Service)
+		}
+		total += split.Weight
+	}
+	if total > MaxRouteSplitWeight {
+		return fmt.Errorf("route split weights must not exceed %d in total, got %d", MaxRouteSplitWeight, total)
+	}
+	return nil
+}
+
+// RoutePatch is a partial update of a Route, with nil fields left
+// unchanged.
+type RoutePatch struct {
+	Service           *string `json:"service,omitempty"`
+	Port              *int32  `json:"port,omitempty"`
+	Leader            *bool   `json:"leader,omitempty"`
+	Sticky            *bool   `json:"sticky,omitempty"`
+	Path              *string `json:"path,omitempty"`
+	DisableKeepAlives *bool   `json:"disable_keep_alives,omitempty"`
+	ClientCA          *string `json:"client_ca,omitempty"`
+	RedirectHTTPS     *bool   `json:"redirect_https,omitempty"`
+	HTTP2             *bool   `json:"http2,omitempty"`
+
+	// TLSPolicy replaces the route's TLS policy, with an empty policy
+	// removing it.
+	TLSPolicy *TLSPolicy `json:"tls_policy,omitempty"`
+
+	// Certificate replaces the route's certificate, with an empty
+	// certificate removing it.
+	Certificate *Certificate `json:"certificate,omitempty"`
+
+	// ManagedCertificateDomain sets the domain of the route's managed
+	// certificate, with an empty domain removing the managed certificate
+	// along with the certificate issued for it.
+	ManagedCertificateDomain *string `json:"managed_certificate_domain,omitempty"`
+
+	// Splits replaces the route's splits, with an empty list removing
+	// them.
+	Splits *[]*RouteSplit `json:"splits,omitempty"`
+
+	// UpdatedAt, if set, must match the route's UpdatedAt for the patch to
+	// be applied, so that changes made since the route was read are not
+	// overwritten.
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// Apply sets the fields of the route which are set in the patch.
+func (p *RoutePatch) Apply(r *Route) {
+	if p.Service != nil {
+		r.Service = *p.Service
+	}
+	if p.Port != nil {
+		r.Port = *p.Port
+	}
+	if p.Leader != nil {
+		r.Leader = *p.Leader
+	}
+	if p.Sticky != nil {
+		r.Sticky = *p.Sticky
+	}
+	if p.Path != nil {
+		r.Path = *p.Path
+	}
+	if p.DisableKeepAlives != nil {
+		r.DisableKeepAlives = *p.DisableKeepAlives
+	}
+	if p.ClientCA != nil {
+		r.ClientCA = *p.ClientCA
+	}
+	if p.RedirectHTTPS != nil {
+		r.RedirectHTTPS = *p.RedirectHTTPS
+	}
+	if p.HTTP2 != nil {
+		r.HTTP2 = *p.HTTP2
+	}
+	if p.TLSPolicy != nil {
+		if reflect.DeepEqual(*p.TLSPolicy, TLSPolicy{}) {
+			r.TLSPolicy = nil
+		} else {
+			r.TLSPolicy = p.TLSPolicy
+		}
+	}
+	if p.ManagedCertificateDomain != nil {
+		if *p.ManagedCertificateDomain == "" {
+			if r.ManagedCertificateDomain != nil && *r.ManagedCertificateDomain != "" {
+				// the certificate was issued for the managed
+				// domain, so remove it too
+				r.Certificate = nil
+			}
+			r.ManagedCertificateDomain = nil
+		} else {
+			r.ManagedCertificateDomain = p.ManagedCertificateDomain
+		}
+	}
+	if p.Certificate != nil {
+		r.Certificate = p.Certificate
+	}
+	if p.Splits != nil {
+		if len(*p.Splits) == 0 {
+			r.Splits = nil
+		} else {
+			r.Splits = *p.Splits
+		}
+	}
 }
 
 func (r Route) FormattedID() string {
@@ -146,6 +311,11 @@ func (r Route) HTTPRoute() *HTTPRoute {
 		Sticky:                   r.Sticky,
 		Path:                     r.Path,
 		DisableKeepAlives:        r.DisableKeepAlives,
+		TLSPolicy:                r.TLSPolicy,
+		ClientCA:                 r.ClientCA,
+		RedirectHTTPS:            r.RedirectHTTPS,
+		Splits:                   r.Splits,
+		HTTP2:                    r.HTTP2,
 	}
 }
 
@@ -181,6 +351,11 @@ type HTTPRoute struct {
 	Sticky                   bool
 	Path                     string
 	DisableKeepAlives        bool
+	TLSPolicy                *TLSPolicy    `json:"tls_policy,omitempty"`
+	ClientCA                 string        `json:"client_ca,omitempty"`
+	RedirectHTTPS            bool          `json:"redirect_https,omitempty"`
+	Splits                   []*RouteSplit `json:"splits,omitempty"`
+	HTTP2                    bool          `json:"http2,omitempty"`
 }
 
 func (r HTTPRoute) FormattedID() string {
@@ -213,6 +388,11 @@ func (r HTTPRoute) ToRoute() *Route {
 		Sticky:                   r.Sticky,
 		Path:                     r.Path,
 		DisableKeepAlives:        r.DisableKeepAlives,
+		TLSPolicy:                r.TLSPolicy,
+		ClientCA:                 r.ClientCA,
+		RedirectHTTPS:            r.RedirectHTTPS,
+		Splits:                   r.Splits,
+		HTTP2:                    r.HTTP2,
 	}
 }
 
@@ -285,3 +465,33 @@ type StreamEvent struct {
 type StreamEventsOptions struct {
 	EventTypes []EventType
 }
+
+// CircuitState is the state of a backend's circuit breaker.
+type CircuitState string
+
+const (
+	// CircuitStateClosed means requests are sent to the backend as normal.
+	CircuitStateClosed CircuitState = "closed"
+
+	// CircuitStateOpen means the backend has been ejected after
+	// consecutive failures and is skipped until its cooldown expires.
+	CircuitStateOpen CircuitState = "open"
+
+	// CircuitStateHalfOpen means the cooldown has expired and the next
+	// request to the backend decides whether it is closed or re-ejected.
+	CircuitStateHalfOpen CircuitState = "half-open"
+)
+
+// BackendMetrics are the connection and circuit breaker metrics for a
+// single service backend, as returned by the router metrics endpoint.
+type BackendMetrics struct {
+	Service             string       `json:"service"`
+	Addr                string       `json:"addr"`
+	State               CircuitState `json:"state"`
+	InFlightRequests    int64        `json:"in_flight_requests"`
+	Requests            int64        `json:"requests"`
+	Failures            int64        `json:"failures"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	Ejections           int64        `json:"ejections"`
+	EjectedUntil        *time.Time   `json:"ejected_until,omitempty"`
+}
@@ -25,6 +25,10 @@ var SystemApps = []SystemApp{
 	{Name: "router"},
 	{Name: "gitreceive"},
 	{Name: "tarreceive"},
+	{
+		Name:     "registry",
+		Optional: true,
+	},
 	{Name: "controller"},
 	{Name: "logaggregator"},
 	{
@@ -18,6 +18,7 @@ import (
 	"github.com/flynn/flynn/updater/types"
 	"github.com/mattn/go-colorable"
 	"github.com/inconshreveable/log15"
+	"golang.org/x/net/context"
 )
 
 var redisImage, slugBuilder, slugRunner *ct.Artifact
@@ -26,6 +27,9 @@ var redisImage, slugBuilder, slugRunner *ct.Artifact
 // assigning a TTY to the job causes reading images via stdin to fail.
 var isTTY = flag.Bool("tty", false, "use a TTY log formatter")
 
+// deployTimeout bounds each app deploy, including the API calls made to
+// prepare the new release, so that an unresponsive controller does not
+// block the update indefinitely.
 const deployTimeout = 30 * time.Minute
 
 func main() {
@@ -247,7 +251,9 @@ func (e errDeploySkipped) Error() string {
 }
 
 func deployApp(client controller.Client, app *ct.App, image *ct.Artifact, updateFn updater.UpdateReleaseFn, log log15.Logger) error {
-	release, err := client.GetAppRelease(app.ID)
+	ctx, cancel := context.WithTimeout(context.Background(), deployTimeout)
+	defer cancel()
+	release, err := client.GetAppReleaseCtx(ctx, app.ID)
 	if err != nil {
 		log.Error("error getting release", "err", err)
 		return err
@@ -255,7 +261,7 @@ func deployApp(client controller.Client, app *ct.App, image *ct.Artifact, update
 	if len(release.ArtifactIDs) == 0 {
 		return errDeploySkipped{"release has no artifacts"}
 	}
-	artifact, err := client.GetArtifact(release.ArtifactIDs[0])
+	artifact, err := client.GetArtifactCtx(ctx, release.ArtifactIDs[0])
 	if err != nil {
 		log.Error("error getting release artifact", "err", err)
 		return err
@@ -272,7 +278,7 @@ func deployApp(client controller.Client, app *ct.App, image *ct.Artifact, update
 	if skipDeploy {
 		return errDeploySkipped{"app is already using latest images"}
 	}
-	if err := client.CreateArtifact(image); err != nil {
+	if err := client.CreateArtifactCtx(ctx, image); err != nil {
 		log.Error("error creating artifact", "err", err)
 		return err
 	}
@@ -281,13 +287,11 @@ func deployApp(client controller.Client, app *ct.App, image *ct.Artifact, update
 	if updateFn != nil {
 		updateFn(release)
 	}
-	if err := client.CreateRelease(app.ID, release); err != nil {
+	if err := client.CreateReleaseCtx(ctx, app.ID, release); err != nil {
 		log.Error("error creating new release", "err", err)
 		return err
 	}
-	timeoutCh := make(chan struct{})
-	time.AfterFunc(deployTimeout, func() { close(timeoutCh) })
-	if err := client.DeployAppRelease(app.ID, release.ID, timeoutCh); err != nil {
+	if err := client.DeployAppReleaseCtx(ctx, app.ID, release.ID); err != nil {
 		log.Error("error deploying app", "err", err)
 		return err
 	}